@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/uploader"
+	"github.com/muzy/xferd/pkg/client"
+)
+
+// upload is a local file and the destination it is uploaded to
+type upload struct {
+	path        string
+	destination string // directory name, followed by the file's subdirectory
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: xferd-client -url URL -dir DIRECTORY[/SUBDIRECTORY] [flags] FILE|DIR...\n\n")
+		flag.PrintDefaults()
+	}
+	baseURL := flag.String("url", os.Getenv("XFERD_URL"), "Base URL of the xferd server, e.g. https://xferd.example.com:8080 (default $XFERD_URL)")
+	dir := flag.String("dir", "", "Directory to upload to, optionally followed by a subdirectory (invoices/2026/10)")
+	username := flag.String("user", "", "Basic auth username; the password is read from -password-file or $XFERD_PASSWORD")
+	passwordFile := flag.String("password-file", "", "File holding the basic auth password")
+	tokenFile := flag.String("token-file", "", "File holding a bearer token (default $XFERD_TOKEN)")
+	certFile := flag.String("cert", "", "Client certificate for mTLS")
+	keyFile := flag.String("key", "", "Client certificate key for mTLS")
+	caFile := flag.String("ca", "", "CA bundle to verify the server with, instead of the system roots")
+	insecure := flag.Bool("insecure", false, "Skip server certificate verification (testing only)")
+	parallel := flag.Int("parallel", 4, "Files uploaded at the same time")
+	retries := flag.Int("retries", client.DefaultMaxRetries, "Retries of connection errors, 429 and 5xx responses per file")
+	timeout := flag.Duration("timeout", 0, "Longest time one file may take, including retries (default: no limit)")
+	stdinName := flag.String("stdin", "", "Upload standard input under this filename instead of files (not retried)")
+	quiet := flag.Bool("quiet", false, "Only report failures")
+	flag.Parse()
+
+	if *baseURL == "" {
+		return errors.New("-url is required")
+	}
+	if *dir == "" {
+		return errors.New("-dir is required")
+	}
+	if *stdinName == "" && flag.NArg() == 0 {
+		return errors.New("no files given")
+	}
+	if *stdinName != "" && flag.NArg() > 0 {
+		return errors.New("-stdin does not take files")
+	}
+
+	opts := []client.Option{client.WithRetries(*retries, client.DefaultBackoff)}
+	if *username != "" {
+		password, err := readSecret(*passwordFile, "XFERD_PASSWORD")
+		if err != nil {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		opts = append(opts, client.WithBasicAuth(*username, password))
+	} else if token, err := readSecret(*tokenFile, "XFERD_TOKEN"); err != nil {
+		return fmt.Errorf("failed to read token: %w", err)
+	} else if token != "" {
+		opts = append(opts, client.WithBearerToken(token))
+	}
+	tlsConfig, err := uploader.NewTLSConfig(config.OutboundTLSConfig{
+		CertFile:           *certFile,
+		KeyFile:            *keyFile,
+		CAFile:             *caFile,
+		InsecureSkipVerify: *insecure,
+	})
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = max(*parallel, 2)
+	opts = append(opts, client.WithHTTPClient(&http.Client{Transport: transport}))
+
+	c, err := client.New(*baseURL, opts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	destination := strings.Trim(*dir, "/")
+	if *stdinName != "" {
+		res, err := c.UploadStream(ctx, destination, *stdinName, os.Stdin)
+		if err != nil {
+			return err
+		}
+		if !*quiet {
+			fmt.Printf("OK   %s/%s (%d bytes, sha256 %s%s)\n", res.Destination, res.Filename, res.Size, res.Checksum, receiptNote(res))
+		}
+		return nil
+	}
+
+	uploads, err := collect(flag.Args(), destination)
+	if err != nil {
+		return err
+	}
+	if len(uploads) == 0 {
+		return errors.New("no files found")
+	}
+	return uploadAll(ctx, c, uploads, *parallel, *timeout, *quiet)
+}
+
+// readSecret reads a secret from file, trimming the trailing newline, or
+// from the environment variable env if no file is given
+func readSecret(file, env string) (string, error) {
+	if file == "" {
+		return os.Getenv(env), nil
+	}
+	data, err := os.ReadFile(file) // #nosec G304 -- file chosen by the user
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// receiptNote names the server's receipt for an upload, if it sent one
+func receiptNote(res *client.UploadResult) string {
+	if res.ReceiptID == "" {
+		return ""
+	}
+	return ", receipt " + res.ReceiptID
+}
+
+// collect lists the files to upload. Files in directory trees keep their
+// path below the tree's root as a subdirectory of destination.
+func collect(args []string, destination string) ([]upload, error) {
+	var uploads []upload
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			uploads = append(uploads, upload{path: arg, destination: destination})
+			continue
+		}
+		err = filepath.WalkDir(arg, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+				return nil // sockets, devices and dangling links
+			}
+			rel, err := filepath.Rel(arg, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			uploads = append(uploads, upload{path: p, destination: path.Join(destination, filepath.ToSlash(rel))})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uploads, nil
+}
+
+// uploadAll uploads files with parallel workers, printing a line for each
+// finished file and a summary. It fails if any file failed.
+func uploadAll(ctx context.Context, c *client.Client, uploads []upload, parallel int, timeout time.Duration, quiet bool) error {
+	started := time.Now()
+	jobs := make(chan upload)
+	var done, failed, bytes atomic.Int64
+	var mu sync.Mutex // keeps output lines whole
+
+	var wg sync.WaitGroup
+	for range max(parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				fileCtx, cancel := ctx, func() {}
+				if timeout > 0 {
+					fileCtx, cancel = context.WithTimeout(ctx, timeout)
+				}
+				fileStarted := time.Now()
+				res, err := c.UploadFile(fileCtx, u.destination, u.path)
+				cancel()
+
+				n := done.Add(1)
+				mu.Lock()
+				if err != nil {
+					failed.Add(1)
+					fmt.Fprintf(os.Stderr, "[%d/%d] FAIL %s: %v\n", n, len(uploads), u.path, err)
+				} else {
+					bytes.Add(res.Size)
+					if !quiet {
+						fmt.Printf("[%d/%d] OK   %s -> %s/%s (%d bytes, %s%s)\n", n, len(uploads), u.path,
+							res.Destination, res.Filename, res.Size, time.Since(fileStarted).Round(time.Millisecond), receiptNote(res))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, u := range uploads {
+		select {
+		case jobs <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	elapsed := time.Since(started).Round(time.Millisecond)
+	uploaded := done.Load() - failed.Load()
+	if !quiet {
+		fmt.Printf("Uploaded %d of %d files, %d bytes in %s\n", uploaded, len(uploads), bytes.Load(), elapsed)
+	}
+	if uploaded < int64(len(uploads)) {
+		return fmt.Errorf("%d of %d files not uploaded", int64(len(uploads))-uploaded, len(uploads))
+	}
+	return nil
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"syscall"
 
 	"golang.org/x/crypto/bcrypt"
@@ -10,14 +15,29 @@ import (
 )
 
 func main() {
-	fmt.Println("xferd Password Hash Generator")
-	fmt.Println("==============================")
-	fmt.Println()
-	fmt.Print("Enter password: ")
+	passwordStdin := flag.Bool("password-stdin", false, "Read the password from the first line of standard input instead of prompting")
+	cost := flag.Int("cost", bcrypt.DefaultCost, fmt.Sprintf("bcrypt cost factor (%d-%d)", bcrypt.MinCost, bcrypt.MaxCost))
+	quiet := flag.Bool("quiet", false, "Print only the hash; with -verify, print nothing and only set the exit status")
+	verify := flag.String("verify", "", "Check the password against this bcrypt hash instead of generating one")
+	flag.Parse()
 
-	// Read password without echoing to terminal
-	password, err := term.ReadPassword(int(syscall.Stdin))
-	fmt.Println() // Print newline after password input
+	if *cost < bcrypt.MinCost || *cost > bcrypt.MaxCost {
+		fmt.Fprintf(os.Stderr, "Error: cost must be between %d and %d\n", bcrypt.MinCost, bcrypt.MaxCost)
+		os.Exit(2)
+	}
+
+	// Quiet mode drops the banner and prompts on stderr, keeping stdout to the hash
+	var out io.Writer = os.Stdout
+	if *quiet {
+		out = io.Discard
+	}
+	if !*passwordStdin {
+		fmt.Fprintln(out, "xferd Password Hash Generator")
+		fmt.Fprintln(out, "==============================")
+		fmt.Fprintln(out)
+	}
+
+	password, err := readPassword(*passwordStdin, *quiet)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
 		os.Exit(1)
@@ -28,13 +48,31 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Generate bcrypt hash with default cost (10)
-	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if *verify != "" {
+		err := bcrypt.CompareHashAndPassword([]byte(*verify), password)
+		switch {
+		case err == nil:
+			fmt.Fprintln(out, "OK: the password matches the hash")
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			fmt.Fprintln(out, "FAIL: the password does not match the hash")
+			os.Exit(1)
+		default:
+			fmt.Fprintf(os.Stderr, "Error verifying hash: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(password, *cost)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error generating hash: %v\n", err)
 		os.Exit(1)
 	}
 
+	if *quiet {
+		fmt.Println(string(hash))
+		return
+	}
 	fmt.Println()
 	fmt.Println("Generated bcrypt hash:")
 	fmt.Println(string(hash))
@@ -49,3 +87,24 @@ func main() {
 	fmt.Println()
 	fmt.Println("Note: Do NOT use both 'password' and 'password_hash' - use only 'password_hash' for production.")
 }
+
+// readPassword reads the password from the first line of standard input,
+// or prompts for it without echoing it to the terminal
+func readPassword(fromStdin, quiet bool) ([]byte, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+
+	prompt := os.Stdout
+	if quiet {
+		prompt = os.Stderr
+	}
+	fmt.Fprint(prompt, "Enter password: ")
+	password, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(prompt) // Print newline after password input
+	return password, err
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"flag"
 	"fmt"
 	"log"
@@ -12,9 +13,39 @@ import (
 const version = "1.0.0"
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "mock-destination" {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+		if err := runMockDestination(os.Args[2:]); err != nil {
+			log.Fatalf("Mock destination error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "probe-destination" {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+		if err := runProbeDestination(os.Args[2:]); err != nil {
+			log.Fatalf("Probe destination error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+		if err := runRestore(os.Args[2:]); err != nil {
+			log.Fatalf("Restore error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if err := runValidate(os.Args[2:]); err != nil {
+			log.Fatalf("Validation error: %v", err)
+		}
+		return
+	}
+
 	// Command line flags
-	configPath := flag.String("config", "/etc/xferd/config.yml", "Path to configuration file")
+	configPath := flag.String("config", cmp.Or(os.Getenv("XFERD_CONFIG"), "/etc/xferd/config.yml"), "Path to configuration file, or a directory holding config.yml such as a mounted ConfigMap")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+	check := flag.Bool("check", false, "Validate the configuration and exit, like the validate subcommand")
 	flag.Parse()
 
 	// Show version
@@ -23,6 +54,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Validate configuration
+	if *check {
+		if err := runValidate([]string{"-config", *configPath}); err != nil {
+			log.Fatalf("Validation error: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	// Setup logging
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 	log.Printf("Starting xferd v%s", version)
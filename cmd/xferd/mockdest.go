@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/muzy/xferd/internal/mockdest"
+)
+
+// runMockDestination runs the mock-destination subcommand
+func runMockDestination(args []string) error {
+	flags := flag.NewFlagSet("mock-destination", flag.ExitOnError)
+	listen := flags.String("listen", ":9090", "Address to listen on")
+	latency := flags.Duration("latency", 0, "Latency added to every upload response")
+	jitter := flags.Duration("jitter", 0, "Random extra latency up to this value")
+	failureRate := flags.Float64("failure-rate", 0, "Fraction of uploads answered with -failure-status (0-1)")
+	failureStatus := flags.Int("failure-status", http.StatusServiceUnavailable, "Status of injected failures")
+	basicAuth := flags.String("basic-auth", "", "Require basic auth as user:password")
+	token := flags.String("bearer-token", "", "Require this bearer token")
+	requireChecksum := flags.Bool("require-checksum", false, "Reject uploads without an X-Checksum-SHA256 header")
+	storeDir := flags.String("store", "", "Keep received files in this directory (default: discard)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := mockdest.Config{
+		Latency:         *latency,
+		Jitter:          *jitter,
+		FailureRate:     *failureRate,
+		FailureStatus:   *failureStatus,
+		Token:           *token,
+		RequireChecksum: *requireChecksum,
+		StoreDir:        *storeDir,
+	}
+	if *basicAuth != "" {
+		var ok bool
+		if cfg.Username, cfg.Password, ok = strings.Cut(*basicAuth, ":"); !ok {
+			return errors.New("-basic-auth must be user:password")
+		}
+	}
+	if cfg.FailureRate < 0 || cfg.FailureRate > 1 {
+		return fmt.Errorf("-failure-rate must be between 0 and 1")
+	}
+
+	log.Printf("Mock destination listening on %s (latency %v + up to %v, failure rate %.2f)", *listen, cfg.Latency, cfg.Jitter, cfg.FailureRate)
+	log.Printf("Counters: http://%s/_stats", *listen)
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mockdest.New(cfg),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/uploader"
+)
+
+// runProbeDestination runs the probe-destination subcommand: a test upload
+// to a directory's destination before go-live
+func runProbeDestination(args []string) error {
+	flags := flag.NewFlagSet("probe-destination", flag.ExitOnError)
+	configPath := flags.String("config", "/etc/xferd/config.yml", "Path to configuration file")
+	dirName := flags.String("dir", "", "Directory whose destination is probed")
+	timeout := flags.Duration("timeout", time.Minute, "Longest time the test upload may take, including retries")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dirName == "" {
+		return errors.New("-dir is required")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(cfg.Directories, func(d config.DirectoryConfig) bool { return d.Name == *dirName })
+	if i < 0 {
+		return fmt.Errorf("unknown directory: %s", *dirName)
+	}
+	dirCfg := cfg.Directories[i]
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	fmt.Printf("Probing the %s destination of %s: %s\n", dirCfg.Outbound.GetType(), dirCfg.Name, dirCfg.Outbound.URL)
+	result := uploader.ProbeUpload(ctx, dirCfg, cfg.Instance)
+	fmt.Printf("Test file: %s (%d bytes)\n", result.File, result.Size)
+	for _, ex := range result.Exchanges {
+		line := fmt.Sprintf("  %s %s -> ", ex.Method, ex.URL)
+		if ex.Err != nil {
+			line += ex.Err.Error()
+		} else {
+			line += fmt.Sprint(ex.Status)
+		}
+		if ex.TLS != "" {
+			line += " (" + ex.TLS + ")"
+		}
+		fmt.Println(line)
+	}
+
+	if result.Err != nil {
+		fmt.Printf("FAIL: %v\n", result.Err)
+		if result.Hint != "" {
+			fmt.Printf("Hint: %s\n", result.Hint)
+		}
+		return errors.New("destination probe failed")
+	}
+	fmt.Println("PASS: the destination accepted the test file; remove it there if it should not be kept")
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/shadow"
+)
+
+// runRestore runs the restore subcommand: it finds shadow copies in the
+// manifest of a shadow directory and copies them back for re-delivery
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := flags.String("config", "/etc/xferd/config.yml", "Path to configuration file, used with -dir")
+	dirName := flags.String("dir", "", "Directory whose shadow copies are restored, by default into its watch path")
+	shadowPath := flags.String("shadow", "", "Shadow directory to restore from, instead of -dir")
+	name := flags.String("file", "", "Case-insensitive substring of the original path")
+	checksum := flags.String("checksum", "", "SHA-256 of the content")
+	date := flags.String("date", "", "Day (2006-01-02, UTC) the copy was made")
+	to := flags.String("to", "", "Directory to restore into (default: the watch path of -dir)")
+	all := flags.Bool("all", false, "Restore every matching copy instead of requiring a single match")
+	list := flags.Bool("list", false, "Only list matching copies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var shadowCfg config.ShadowConfig
+	var watchPath string
+	switch {
+	case *shadowPath != "" && *dirName != "":
+		return errors.New("-shadow and -dir are mutually exclusive")
+	case *shadowPath != "":
+		shadowCfg = config.ShadowConfig{Enabled: true, Path: *shadowPath}
+	case *dirName != "":
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(cfg.Directories, func(d config.DirectoryConfig) bool { return d.Name == *dirName })
+		if i < 0 {
+			return fmt.Errorf("unknown directory: %s", *dirName)
+		}
+		if !cfg.Directories[i].Shadow.Enabled {
+			return fmt.Errorf("directory %s has no shadow copies", *dirName)
+		}
+		shadowCfg, watchPath = cfg.Directories[i].Shadow, cfg.Directories[i].WatchPath
+	default:
+		return errors.New("-shadow or -dir is required")
+	}
+	target := *to
+	if target == "" {
+		target = watchPath
+	}
+	if target == "" && !*list {
+		return errors.New("-to is required with -shadow")
+	}
+
+	query := shadow.Query{Name: *name, Checksum: *checksum}
+	if *date != "" {
+		day, err := time.Parse(time.DateOnly, *date)
+		if err != nil {
+			return fmt.Errorf("invalid -date: %s", *date)
+		}
+		query.Since, query.Until = day, day.Add(24*time.Hour-time.Nanosecond)
+	}
+	entries, err := shadow.Search(shadowCfg, query)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no shadow copy in %s matches", shadowCfg.GetBasePath())
+	}
+	if *list || (len(entries) > 1 && !*all) {
+		for _, e := range entries {
+			fmt.Printf("%s  %s  %s  %d bytes  %s\n", e.Time.Format(time.RFC3339), e.Path, e.Source, e.Size, e.Checksum)
+		}
+		if *list {
+			return nil
+		}
+		return fmt.Errorf("%d copies match; narrow them down with -file, -date or -checksum, or pass -all", len(entries))
+	}
+
+	failed := 0
+	for _, e := range entries {
+		restored, err := shadow.Restore(shadowCfg, e, shadow.RestorePath(target, watchPath, e.Source))
+		if err != nil {
+			fmt.Printf("FAIL: %s: %v\n", e.Path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Restored %s to %s\n", e.Path, restored.RestoredTo)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d copies could not be restored", failed, len(entries))
+	}
+	return nil
+}
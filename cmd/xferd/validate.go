@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/service"
+)
+
+// runValidate runs the validate subcommand: it loads a configuration and
+// checks its paths and TLS files, for CI and deploy pipelines
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "/etc/xferd/config.yml", "Path to configuration file, or a directory holding config.yml")
+	probe := flags.Bool("probe", false, "Also send a HEAD request to every HTTP destination")
+	timeout := flags.Duration("timeout", 30*time.Second, "Longest time all checks together may take")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Printf("FAIL config %s: %v\n", *configPath, err)
+		return errors.New("configuration is invalid")
+	}
+	fmt.Printf("OK   config %s\n", *configPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	failed := 0
+	results := service.CheckConfig(ctx, cfg, *probe)
+	for _, r := range results {
+		name := r.Name
+		if r.Directory != "" {
+			name = r.Directory + "/" + r.Name
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s %s: %v\n", name, r.Target, r.Err)
+		} else {
+			fmt.Printf("OK   %s %s\n", name, r.Target)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	fmt.Printf("PASS: %d checks\n", len(results))
+	return nil
+}
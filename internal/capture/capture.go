@@ -0,0 +1,303 @@
+// Package capture records sampled outbound requests and their responses to a
+// debug directory, for diagnosing rejections by a destination that cannot be
+// reproduced elsewhere. Secrets in headers, URL passwords and query
+// parameters are redacted.
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// recordings counts outbound requests recorded
+var recordings = metrics.NewCounterVec("xferd_outbound_captures_total",
+	"Outbound requests recorded by outbound_capture: recorded, or failed if the recording could not be written",
+	"outcome")
+
+// redacted replaces the values of secret headers
+const redacted = "REDACTED"
+
+// secretHeaders are always redacted
+var secretHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Amz-Security-Token",
+}
+
+// secretParams are parts of query parameter names, such as access_token,
+// api_key or sig, whose values are always redacted
+var secretParams = []string{
+	"token",
+	"key",
+	"secret",
+	"passw",
+	"sig",
+	"auth",
+	"credential",
+	"session",
+}
+
+// State is whether recording is switched on and at which sample rate
+type State struct {
+	Enabled    bool    `json:"enabled"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// Recorder writes sampled requests and responses to a directory. A nil
+// Recorder records nothing.
+type Recorder struct {
+	dir      string
+	maxBody  int64
+	maxFiles int
+	redact   map[string]bool // canonical header names
+	query    map[string]bool // lower case query parameter names
+	enabled  atomic.Bool
+	rate     atomic.Uint64 // math.Float64bits of the sample rate
+	mu       sync.Mutex
+	files    []string // recordings on disk, oldest first
+	seq      uint64
+}
+
+// New creates a Recorder writing to the configured directory, switched on
+// if the configuration enables it
+func New(cfg config.CaptureConfig) (*Recorder, error) {
+	if err := os.MkdirAll(cfg.Path, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create outbound capture directory: %w", err)
+	}
+	r := &Recorder{
+		dir:      cfg.Path,
+		maxBody:  cfg.GetMaxBodyBytes(),
+		maxFiles: cfg.GetMaxFiles(),
+		redact:   make(map[string]bool),
+		query:    make(map[string]bool),
+	}
+	for _, h := range slices.Concat(secretHeaders, cfg.RedactHeaders) {
+		r.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, p := range cfg.RedactQuery {
+		r.query[strings.ToLower(p)] = true
+	}
+	r.enabled.Store(cfg.Enabled)
+	r.rate.Store(math.Float64bits(cfg.GetSampleRate()))
+
+	// Recordings of earlier runs count towards max_files; names sort by time
+	entries, err := os.ReadDir(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbound capture directory: %w", err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".json") {
+			r.files = append(r.files, e.Name())
+		}
+	}
+	slices.Sort(r.files)
+	r.prune()
+	return r, nil
+}
+
+// State returns whether recording is switched on and the sample rate
+func (r *Recorder) State() State {
+	return State{Enabled: r.enabled.Load(), SampleRate: math.Float64frombits(r.rate.Load())}
+}
+
+// Set switches recording on or off and changes the sample rate
+func (r *Recorder) Set(s State) error {
+	if s.SampleRate <= 0 || s.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be greater than 0 and at most 1")
+	}
+	r.rate.Store(math.Float64bits(s.SampleRate))
+	r.enabled.Store(s.Enabled)
+	return nil
+}
+
+// sampled reports whether the next request should be recorded
+func (r *Recorder) sampled() bool {
+	if r == nil || !r.enabled.Load() {
+		return false
+	}
+	return rand.Float64() < math.Float64frombits(r.rate.Load()) // #nosec G404 -- sampling needs no cryptographic randomness
+}
+
+// RoundTrip sends req through next, recording the exchange if it is sampled.
+// directory names the configured directory the request was sent for.
+// The response body is recorded as the caller reads it, up to max_body_bytes,
+// and the recording is written once the caller closes it.
+func (r *Recorder) RoundTrip(directory string, req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	if !r.sampled() {
+		return next.RoundTrip(req)
+	}
+
+	rec := &recording{
+		Time:      time.Now().UTC(),
+		Directory: directory,
+		Request: requestRecord{
+			Method:  req.Method,
+			URL:     r.url(req.URL),
+			Headers: r.headers(req.Header),
+			Length:  req.ContentLength,
+		},
+	}
+	resp, err := next.RoundTrip(req)
+	rec.DurationMs = float64(time.Since(rec.Time).Microseconds()) / 1000
+	if err != nil {
+		rec.Error = err.Error()
+		r.write(rec)
+		return resp, err
+	}
+
+	rec.Response = &responseRecord{Status: resp.StatusCode, Headers: r.headers(resp.Header)}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, r: r, rec: rec}
+	return resp, nil
+}
+
+// headers copies h with secret values redacted
+func (r *Recorder) headers(h http.Header) http.Header {
+	out := h.Clone()
+	for name := range out {
+		if r.redact[name] {
+			out[name] = []string{redacted}
+		}
+	}
+	return out
+}
+
+// url returns u with its password and the values of secret query
+// parameters redacted, keeping the parameters in order
+func (r *Recorder) url(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Redacted()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, p := range params {
+		name, _, _ := strings.Cut(p, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if r.secretParam(name) {
+			params[i] = url.QueryEscape(name) + "=" + redacted
+		}
+	}
+	c := *u
+	c.RawQuery = strings.Join(params, "&")
+	return c.Redacted()
+}
+
+// secretParam reports whether the value of a query parameter is redacted
+func (r *Recorder) secretParam(name string) bool {
+	name = strings.ToLower(name)
+	return r.query[name] || slices.ContainsFunc(secretParams, func(s string) bool {
+		return strings.Contains(name, s)
+	})
+}
+
+// write stores a recording and removes the oldest beyond max_files
+func (r *Recorder) write(rec *recording) {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		recordings.With("failed").Inc()
+		log.Printf("Outbound capture: failed to encode recording: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	name := fmt.Sprintf("%s-%06d.json", rec.Time.Format("20060102T150405.000000000Z"), r.seq%1000000)
+	if err := os.WriteFile(filepath.Join(r.dir, name), data, 0o600); err != nil {
+		recordings.With("failed").Inc()
+		log.Printf("Outbound capture: failed to write recording: %v", err)
+		return
+	}
+	recordings.With("recorded").Inc()
+	r.files = append(r.files, name)
+	r.prune()
+}
+
+// prune removes the oldest recordings beyond max_files. Must be called with
+// mu held, or before the Recorder is shared.
+func (r *Recorder) prune() {
+	for len(r.files) > r.maxFiles {
+		if err := os.Remove(filepath.Join(r.dir, r.files[0])); err != nil && !os.IsNotExist(err) {
+			log.Printf("Outbound capture: failed to remove old recording: %v", err)
+		}
+		r.files = r.files[1:]
+	}
+}
+
+// recording is one recorded request and its response
+type recording struct {
+	Time       time.Time       `json:"time"`
+	Directory  string          `json:"directory,omitempty"`
+	DurationMs float64         `json:"duration_ms"`
+	Request    requestRecord   `json:"request"`
+	Response   *responseRecord `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// requestRecord is a recorded request; file content is not recorded
+type requestRecord struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Length  int64       `json:"content_length"`
+}
+
+// responseRecord is a recorded response
+type responseRecord struct {
+	Status    int         `json:"status"`
+	Headers   http.Header `json:"headers"`
+	Body      string      `json:"body"`
+	Truncated bool        `json:"body_truncated,omitempty"`
+}
+
+// recordingBody keeps the start of a response body as it is read and writes
+// the recording when it is closed
+type recordingBody struct {
+	io.ReadCloser
+	r    *Recorder
+	rec  *recording
+	buf  bytes.Buffer
+	once sync.Once
+}
+
+// Read keeps up to max_body_bytes of what is read
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	keep := max(min(int64(n), b.r.maxBody-int64(b.buf.Len())), 0)
+	b.buf.Write(p[:keep])
+	if int64(n) > keep {
+		b.rec.Response.Truncated = true
+	}
+	return n, err
+}
+
+// Close writes the recording
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.rec.Response.Body = b.buf.String()
+		b.r.write(b.rec)
+	})
+	return err
+}
@@ -0,0 +1,160 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// readRecordings returns the recordings in dir, oldest first
+func readRecordings(t *testing.T, dir string) []recording {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read capture directory: %v", err)
+	}
+	var recs []recording
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read recording: %v", err)
+		}
+		var rec recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			t.Fatalf("Failed to decode recording %s: %v", e.Name(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = io.WriteString(w, `{"error":"schema validation failed at line 3"}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	r, err := New(config.CaptureConfig{Enabled: true, Path: dir, SampleRate: 1, MaxBodyBytes: 16, RedactHeaders: []string{"x-partner-key"}, RedactQuery: []string{"Partner"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return r.RoundTrip("invoices", req, http.DefaultTransport)
+	})}
+
+	req, _ := http.NewRequest(http.MethodPost, strings.Replace(server.URL, "http://", "http://user:pass@", 1)+"/upload?name=a.csv&access_token=abc&X-Amz-Signature=def&partner=ghi", strings.NewReader("file content"))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Partner-Key", "key")
+	req.Header.Set("Content-Type", "text/csv")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "line 3") {
+		t.Errorf("Expected the caller to receive the whole body, got %q", body)
+	}
+
+	recs := readRecordings(t, dir)
+	if len(recs) != 1 {
+		t.Fatalf("Expected one recording, got %d", len(recs))
+	}
+	rec := recs[0]
+	if rec.Directory != "invoices" || rec.Request.Method != http.MethodPost || strings.Contains(rec.Request.URL, "pass") {
+		t.Errorf("Unexpected request record: %+v", rec.Request)
+	}
+	if want := "/upload?name=a.csv&access_token=REDACTED&X-Amz-Signature=REDACTED&partner=REDACTED"; !strings.HasSuffix(rec.Request.URL, want) {
+		t.Errorf("Expected query secrets to be redacted, got %s", rec.Request.URL)
+	}
+	for _, h := range []string{"Authorization", "X-Partner-Key"} {
+		if got := rec.Request.Headers.Get(h); got != redacted {
+			t.Errorf("Expected %s to be redacted, got %q", h, got)
+		}
+	}
+	if got := rec.Request.Headers.Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Expected Content-Type to be recorded, got %q", got)
+	}
+	if rec.Response == nil || rec.Response.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected a 422 response record, got %+v", rec.Response)
+	}
+	if rec.Response.Headers.Get("Set-Cookie") != redacted {
+		t.Errorf("Expected Set-Cookie to be redacted, got %q", rec.Response.Headers.Get("Set-Cookie"))
+	}
+	if rec.Response.Body != `{"error":"schema` || !rec.Response.Truncated {
+		t.Errorf("Expected the body truncated to 16 bytes, got %q (truncated %v)", rec.Response.Body, rec.Response.Truncated)
+	}
+}
+
+func TestRecorderSampling(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(config.CaptureConfig{Path: dir, SampleRate: 1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if r.sampled() {
+		t.Error("Expected nothing to be recorded until switched on")
+	}
+	if err := r.Set(State{Enabled: true, SampleRate: 1}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !r.sampled() {
+		t.Error("Expected every request to be recorded at sample rate 1")
+	}
+	if err := r.Set(State{Enabled: true, SampleRate: 0}); err == nil {
+		t.Error("Expected an error for sample rate 0")
+	}
+
+	var nilRecorder *Recorder
+	if nilRecorder.sampled() {
+		t.Error("Expected a nil recorder to record nothing")
+	}
+}
+
+func TestRecorderMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "20000101T000000.000000000Z-000001.json"), []byte("{}"), 0600); err != nil {
+		t.Fatalf("Failed to write old recording: %v", err)
+	}
+	r, err := New(config.CaptureConfig{Enabled: true, Path: dir, SampleRate: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	failing := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+	for range 3 {
+		req, _ := http.NewRequest(http.MethodHead, "http://destination.invalid/", nil)
+		if _, err := r.RoundTrip("", req, failing); err == nil {
+			t.Fatal("Expected the transport error to be returned")
+		}
+	}
+
+	recs := readRecordings(t, dir)
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 recordings to be kept, got %d", len(recs))
+	}
+	for _, rec := range recs {
+		if rec.Error == "" || rec.Response != nil {
+			t.Errorf("Expected a recorded transport error, got %+v", rec)
+		}
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
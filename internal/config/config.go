@@ -2,219 +2,2775 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/muzy/xferd/internal/magic"
+	"github.com/muzy/xferd/internal/strftime"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the entire xferd configuration
 type Config struct {
-	Server      ServerConfig      `yaml:"server"`
-	Directories []DirectoryConfig `yaml:"directories"`
+	Server       ServerConfig       `yaml:"server"`
+	MaxWorkers   int                `yaml:"max_workers,omitempty"`      // Optional: cap on concurrent uploads across all directories (0 = unlimited)
+	Journal      JournalConfig      `yaml:"journal_export,omitempty"`   // Optional: ship per-file transfer events to a collector
+	ControlPlane ControlPlaneConfig `yaml:"control_plane,omitempty"`    // Optional: receive directory configuration from a central service
+	Instance     InstanceConfig     `yaml:"instance,omitempty"`         // Optional: identify this instance in outbound uploads and journal events
+	WatchProbe   WatchProbeConfig   `yaml:"watch_probe,omitempty"`      // Optional: measure watch path latency and report slow paths as not ready
+	History      HistoryConfig      `yaml:"history,omitempty"`          // Optional: keep a searchable record of completed transfers
+	FileState    FileStateConfig    `yaml:"file_state,omitempty"`       // Optional: track each file's lifecycle for GET /files
+	Readiness    ReadinessConfig    `yaml:"readiness,omitempty"`        // Optional: tune the /ready checks
+	Shutdown     ShutdownConfig     `yaml:"shutdown,omitempty"`         // Optional: how queued files are handled on shutdown
+	Capture      CaptureConfig      `yaml:"outbound_capture,omitempty"` // Optional: record sampled outbound requests and responses for debugging
+	Kubernetes   KubernetesConfig   `yaml:"kubernetes,omitempty"`       // Optional: pod identity, ConfigMap reload and grace-period-aware draining
+	Secrets      SecretsConfig      `yaml:"secrets,omitempty"`          // Optional: where vault: secret references are read from
+	Permissions  PermissionsConfig  `yaml:"permissions,omitempty"`      // Optional: mode and owner of files and directories xferd creates
+	DiskSpace    DiskSpaceConfig    `yaml:"disk_space,omitempty"`       // Optional: reject uploads and pause shadow copies on full volumes
+	Directories  []DirectoryConfig  `yaml:"directories"`
+}
+
+// HistoryConfig defines the transfer history served by GET /history
+type HistoryConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Path          string `yaml:"path"`           // JSON Lines file holding the history
+	RetentionDays int    `yaml:"retention_days"` // How long transfers are kept (default 90)
+	Checksums     bool   `yaml:"checksums"`      // Record the SHA-256 of delivered files, hashing them if no other feature did
+}
+
+// FileStateConfig defines the file lifecycle store served by GET /files
+type FileStateConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Path          string `yaml:"path"`           // JSON Lines file holding the lifecycle events
+	RetentionDays int    `yaml:"retention_days"` // How long events are kept (default 30)
+}
+
+// CaptureConfig defines sampled recording of outbound request headers and
+// response bodies, for diagnosing rejections by a destination. With a path
+// set, recording can be switched on and off at runtime via /debug/capture.
+type CaptureConfig struct {
+	Enabled       bool     `yaml:"enabled"`        // Record from startup rather than only once switched on
+	Path          string   `yaml:"path"`           // Directory recordings are written to, one JSON file per request
+	SampleRate    float64  `yaml:"sample_rate"`    // Fraction of requests recorded (default 0.1)
+	MaxBodyBytes  int64    `yaml:"max_body_bytes"` // Response body bytes kept per recording (default 64 KiB)
+	MaxFiles      int      `yaml:"max_files"`      // Recordings kept, the oldest are removed (default 1000)
+	RedactHeaders []string `yaml:"redact_headers"` // Headers redacted in addition to Authorization, cookies and API keys
+	RedactQuery   []string `yaml:"redact_query"`   // URL query parameters redacted in addition to names like token, key, secret or sig
+}
+
+// ReadinessConfig defines the checks /ready runs in addition to writable
+// paths, shadow paths and queue capacity
+type ReadinessConfig struct {
+	ProbeDestinations bool `yaml:"probe_destinations"` // Send a HEAD request to each HTTP destination
+	TimeoutMs         int  `yaml:"timeout_ms"`         // Checks slower than this fail (default 5000)
+}
+
+// ShutdownConfig defines how the service drains on shutdown. Draining stops
+// ingress uploads and watchers, then waits for queued files to be delivered.
+type ShutdownConfig struct {
+	DrainTimeoutSeconds int `yaml:"drain_timeout_seconds"` // Longest wait for queued files (default 30, -1 disables)
+}
+
+// KubernetesConfig defines behaviour when running in a Kubernetes pod, e.g.
+// as a sidecar shipping files another container drops into an emptyDir
+type KubernetesConfig struct {
+	Enabled                       bool   `yaml:"enabled"`
+	PodName                       string `yaml:"pod_name"`                         // Pod name (default: POD_NAME from the downward API, else hostname)
+	Namespace                     string `yaml:"namespace"`                        // Pod namespace (default: POD_NAMESPACE, else the service account's namespace)
+	ReloadIntervalMs              int    `yaml:"reload_interval_ms"`               // Time between checks of the configuration file for changes (default 10000, -1 disables)
+	TerminationGracePeriodSeconds int    `yaml:"termination_grace_period_seconds"` // The pod's terminationGracePeriodSeconds (default 30)
+	DrainMarginSeconds            int    `yaml:"drain_margin_seconds"`             // Part of the grace period kept for stopping after draining (default 5)
+}
+
+// WatchProbeConfig defines periodic stat/read probes of the watch paths, to
+// detect hung network mounts
+type WatchProbeConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	IntervalMs  int  `yaml:"interval_ms"`  // Time between probes (default 30000)
+	ThresholdMs int  `yaml:"threshold_ms"` // Probes slower than this make the service not ready (default 5000)
+}
+
+// ControlPlaneConfig defines a central service pushing directory configuration.
+// Pushed directories replace the ones in the configuration file.
+type ControlPlaneConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	URL             string            `yaml:"url"`               // Endpoint streaming configuration documents, one JSON object per line
+	NodeID          string            `yaml:"node_id"`           // Identifies this node to the control plane (default: hostname)
+	Token           string            `yaml:"token"`             // Optional: bearer token
+	TLS             OutboundTLSConfig `yaml:"tls"`               // Optional: TLS settings for the control plane
+	AllowInsecure   bool              `yaml:"allow_insecure"`    // Optional: accept a plain http:// url
+	AllowCommands   bool              `yaml:"allow_commands"`    // Optional: accept post_upload.command in pushed directories
+	AllowLocalFiles bool              `yaml:"allow_local_files"` // Optional: accept *_file paths and vault: references in pushed directories
+}
+
+// DiskSpaceConfig defines the free space kept on the temp, watch, ingest
+// and shadow volumes. Below it, uploads are rejected with 507 and shadow
+// copies are paused rather than failing part way through writing.
+type DiskSpaceConfig struct {
+	Enabled            bool  `yaml:"enabled"`
+	MinFreeBytes       int64 `yaml:"min_free_bytes"`        // Free space below which uploads are rejected (default 1 GiB)
+	ShadowMinFreeBytes int64 `yaml:"shadow_min_free_bytes"` // Optional: free space below which shadow copies are paused (default min_free_bytes)
+	IntervalSeconds    int   `yaml:"interval_seconds"`      // Time between checks (default 10)
+}
+
+// GetMinFree returns the free space below which uploads are rejected
+func (d *DiskSpaceConfig) GetMinFree() uint64 {
+	if d.MinFreeBytes <= 0 {
+		return 1 << 30
+	}
+	return uint64(d.MinFreeBytes) // #nosec G115 -- positive
+}
+
+// GetShadowMinFree returns the free space below which shadow copies are paused
+func (d *DiskSpaceConfig) GetShadowMinFree() uint64 {
+	if d.ShadowMinFreeBytes <= 0 {
+		return d.GetMinFree()
+	}
+	return uint64(d.ShadowMinFreeBytes) // #nosec G115 -- positive
+}
+
+// GetInterval returns the time between free space checks
+func (d *DiskSpaceConfig) GetInterval() time.Duration {
+	if d.IntervalSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(d.IntervalSeconds) * time.Second
+}
+
+// PermissionsConfig defines the mode and owner of the files and directories
+// xferd creates for other processes to read: REST uploads and their
+// directories, the temp directory and shadow copies
+type PermissionsConfig struct {
+	FileMode string `yaml:"file_mode"` // Octal mode of created files, e.g. "0640" (default: 0644 less the umask)
+	DirMode  string `yaml:"dir_mode"`  // Octal mode of created directories, e.g. "0750" (default: 0755 less the umask)
+	Umask    string `yaml:"umask"`     // Optional: octal umask of the process, e.g. "0027" (Linux only)
+	Owner    string `yaml:"owner"`     // Optional: user name or uid given created files and directories (Linux only, requires root)
+	Group    string `yaml:"group"`     // Optional: group name or gid given created files and directories (Linux only)
+}
+
+// ParseMode parses an octal file mode such as "0640"; "" is 0
+func ParseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(strings.TrimPrefix(mode, "0o"), 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q: must be octal permissions such as 0640", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// validate checks the modes and that owners are only set where supported
+func (p *PermissionsConfig) validate() error {
+	for _, m := range []struct{ name, value string }{
+		{"file_mode", p.FileMode}, {"dir_mode", p.DirMode}, {"umask", p.Umask},
+	} {
+		if _, err := ParseMode(m.value); err != nil {
+			return fmt.Errorf("permissions.%s: %w", m.name, err)
+		}
+	}
+	if (p.Owner != "" || p.Group != "" || p.Umask != "") && runtime.GOOS != "linux" {
+		return fmt.Errorf("permissions.owner, group and umask are only supported on Linux")
+	}
+	return nil
+}
+
+// SecretsConfig defines where secret references in the configuration are
+// resolved. Secret values of the form vault:<path>#<key> are read from Vault.
+type SecretsConfig struct {
+	Vault VaultConfig `yaml:"vault"`
+}
+
+// VaultConfig defines the HashiCorp Vault server secrets are read from, with
+// the KV secrets engine (version 1 or 2)
+type VaultConfig struct {
+	Address   string `yaml:"address"`    // Vault URL (default $VAULT_ADDR)
+	TokenFile string `yaml:"token_file"` // File holding the Vault token (default $VAULT_TOKEN)
+	Namespace string `yaml:"namespace"`  // Optional: Vault Enterprise namespace
+	CAFile    string `yaml:"ca_file"`    // Optional: CA bundle verifying the Vault server
+	TimeoutMs int    `yaml:"timeout_ms"` // Longest time one secret may take to read (default 10000)
+}
+
+// GetTimeout returns the longest time a secret may take to read
+func (v *VaultConfig) GetTimeout() time.Duration {
+	if v.TimeoutMs > 0 {
+		return time.Duration(v.TimeoutMs) * time.Millisecond
+	}
+	return 10 * time.Second
+}
+
+// InstanceConfig identifies this xferd instance to destinations and
+// collectors, e.g. to attribute files to an edge site
+type InstanceConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	ID      string `yaml:"id"`     // Instance name (default: hostname)
+	Header  string `yaml:"header"` // Upload request header carrying the ID (default X-Xferd-Instance)
+	Field   string `yaml:"field"`  // Optional: multipart form field carrying the ID
+}
+
+// JournalConfig defines shipping of per-file transfer events to an external collector
+type JournalConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	Type            string            `yaml:"type"`              // http (default) or kafka_rest: publish to a topic through a Kafka REST Proxy
+	URL             string            `yaml:"url"`               // Collector endpoint receiving JSON arrays of events
+	Headers         map[string]string `yaml:"headers"`           // Extra request headers, e.g. Authorization
+	BatchSize       int               `yaml:"batch_size"`        // Events per request (default 100)
+	FlushIntervalMs int               `yaml:"flush_interval_ms"` // Longest an event waits for a full batch (default 1000)
+	QueueSize       int               `yaml:"queue_size"`        // Events waiting to be sent; more are dropped (default 10000)
+	MaxRetries      int               `yaml:"max_retries"`       // Retries per batch before it is dropped (default 5)
+	Outcomes        []string          `yaml:"outcomes"`          // Only export these outcomes: detected, delivered, failed, removed, overdue, quarantined (default all but detected)
+	Template        string            `yaml:"template"`          // Go template rendering each event as its own request body, e.g. a chat message
+	ContentType     string            `yaml:"content_type"`      // Content-Type of templated requests (default application/json)
+	Checksums       bool              `yaml:"checksums"`         // Add the SHA-256 of delivered files, hashing them if no other feature did
+	Kafka           KafkaConfig       `yaml:"kafka"`             // kafka_rest only: topic and record key
+}
+
+// KafkaConfig defines the topic journal events are published to
+type KafkaConfig struct {
+	Topic string `yaml:"topic"`
+	Key   string `yaml:"key"` // Go template for the record key (default {{.Path}})
 }
 
 // ServerConfig defines REST ingress settings
 type ServerConfig struct {
-	Address   string          `yaml:"address"`
-	Port      int             `yaml:"port"`
-	TLS       TLSConfig       `yaml:"tls"`
-	TempDir   string          `yaml:"temp_dir"`
-	BasicAuth BasicAuthConfig `yaml:"basic_auth"`
+	Enabled           *bool                   `yaml:"enabled,omitempty"` // Optional: serve the REST API (default true); false runs the watchers only
+	Address           string                  `yaml:"address"`
+	Port              int                     `yaml:"port"`
+	Listen            []ListenConfig          `yaml:"listen,omitempty"` // Optional: multiple listeners, overrides address/port
+	TLS               TLSConfig               `yaml:"tls"`
+	TempDir           string                  `yaml:"temp_dir"`
+	MaxUploadBytes    int64                   `yaml:"max_upload_bytes"` // Optional: largest accepted upload (0 = unlimited)
+	BasicAuth         BasicAuthConfig         `yaml:"basic_auth"`
+	JWTAuth           JWTAuthConfig           `yaml:"jwt_auth"`
+	RateLimit         RateLimitConfig         `yaml:"rate_limit"`
+	AccessLog         AccessLogConfig         `yaml:"access_log"`                   // Optional: one line per ingress request, separate from the application log
+	TempCleanup       TempCleanupConfig       `yaml:"temp_cleanup,omitempty"`       // Optional: removal of uploads left staged by a crash or lost connection
+	AdminConfirmation AdminConfirmationConfig `yaml:"admin_confirmation,omitempty"` // Optional: confirm disruptive admin requests with a one-time token
+}
+
+// AdminConfirmationConfig requires disruptive admin requests to be sent
+// twice: the first is answered with a one-time token, and only a repetition
+// carrying it is carried out. With second_approver, another identity must
+// send the repetition, a two-person rule.
+type AdminConfirmationConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Endpoints      []string `yaml:"endpoints"`       // drain and/or shadow_restore (default both)
+	TTLSeconds     int      `yaml:"ttl_seconds"`     // How long a token may be used (default 300)
+	SecondApprover bool     `yaml:"second_approver"` // The confirming request must be authenticated as someone else
+}
+
+// Admin endpoints that can require confirmation
+const (
+	AdminEndpointDrain         = "drain"
+	AdminEndpointShadowRestore = "shadow_restore"
+)
+
+// TempCleanupConfig defines the removal of .partial files that interrupted
+// uploads left in the temp directories, on startup and periodically
+type TempCleanupConfig struct {
+	Enabled             *bool           `yaml:"enabled,omitempty"`              // Optional: default true
+	MaxAgeMinutes       int             `yaml:"max_age_minutes"`                // Files not written to for this long are stale (default 1440)
+	IntervalMinutes     int             `yaml:"interval_minutes"`               // Time between scans after startup (default 60)
+	QuarantinePath      string          `yaml:"quarantine_path,omitempty"`      // Optional: stale files are moved here instead of deleted
+	QuarantineRetention RetentionConfig `yaml:"quarantine_retention,omitempty"` // Optional: bounds the age and size of quarantine_path
+}
+
+// RetentionConfig bounds what accumulates in a quarantine directory. Files
+// past max_age_hours are removed, then the oldest ones while the directory
+// holds more than max_bytes. Zero leaves the respective limit off.
+type RetentionConfig struct {
+	MaxAgeHours int   `yaml:"max_age_hours"` // Quarantined files are removed this long after they were moved there
+	MaxBytes    int64 `yaml:"max_bytes"`     // Most content kept, the oldest files are removed first
+}
+
+// AccessLogConfig defines logging of ingress requests
+type AccessLogConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`   // File requests are appended to (default: standard output)
+	Format  string `yaml:"format"` // combined (default) or json
+}
+
+// ListenConfig defines a single ingress listener
+type ListenConfig struct {
+	Name    string `yaml:"name,omitempty"` // Optional: referenced by directory listeners
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	Network string `yaml:"network"` // tcp (dual-stack, default), tcp4 or tcp6 (IPv6 only)
+}
+
+// BasicAuthConfig defines optional basic authentication
+type BasicAuthConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	Username         string `yaml:"username"`
+	Password         string `yaml:"password"`           // Plaintext password (not recommended for production)
+	PasswordHash     string `yaml:"password_hash"`      // Bcrypt hash of password (recommended)
+	PasswordFile     string `yaml:"password_file"`      // Alternative: file holding the password
+	PasswordHashFile string `yaml:"password_hash_file"` // Alternative: file holding the bcrypt hash
+}
+
+// JWTAuthConfig defines optional JWT bearer authentication
+type JWTAuthConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Secret    string `yaml:"secret"`     // HS256 shared secret
+	JWKSURL   string `yaml:"jwks_url"`   // RS256 JSON Web Key Set URL
+	Issuer    string `yaml:"issuer"`     // Optional: required "iss" claim
+	Audience  string `yaml:"audience"`   // Optional: required "aud" claim
+	DirsClaim string `yaml:"dirs_claim"` // Optional: claim listing permitted directory names (e.g. "dirs")
+	// Optional: accept tokens without an "exp" claim, which never expire
+	// (default: rejected)
+	AllowMissingExp bool `yaml:"allow_missing_exp"`
+}
+
+// RateLimitConfig defines optional ingress rate limiting
+type RateLimitConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Global    RateLimitRule `yaml:"global"`     // Limits across all clients
+	PerClient RateLimitRule `yaml:"per_client"` // Limits per client IP
+}
+
+// RateLimitRule defines request rate and upload concurrency limits (0 = unlimited)
+type RateLimitRule struct {
+	RequestsPerSecond    float64 `yaml:"requests_per_second"`
+	Burst                int     `yaml:"burst"` // Defaults to requests_per_second (at least 1)
+	MaxConcurrentUploads int     `yaml:"max_concurrent_uploads"`
+}
+
+// TLSConfig defines TLS settings
+type TLSConfig struct {
+	Enabled      bool                `yaml:"enabled"`
+	CertFile     string              `yaml:"cert_file"`
+	KeyFile      string              `yaml:"key_file"`
+	Certificates []CertificateConfig `yaml:"certificates,omitempty"` // Optional: additional certificates selected by SNI
+
+	// Client certificate (mTLS) authentication
+	ClientCAFile      string              `yaml:"client_ca_file"`             // CA bundle used to verify client certificates
+	RequireClientCert bool                `yaml:"require_client_cert"`        // Reject connections without a valid client certificate
+	ClientCertDirs    map[string][]string `yaml:"client_cert_dirs,omitempty"` // Optional: certificate CN/SAN -> permitted directory names
+}
+
+// CertificateConfig defines a certificate/key pair
+type CertificateConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// DirectoryConfig represents a single watched directory configuration
+type DirectoryConfig struct {
+	Name                  string                    `yaml:"name"`
+	WatchPath             string                    `yaml:"watch_path"`
+	IngestPath            string                    `yaml:"ingest_path,omitempty"` // Optional: defaults to watch_path
+	TempDir               string                    `yaml:"temp_dir,omitempty"`    // Optional: stages uploads, batches and mirror spools of this directory instead of server.temp_dir
+	Recursive             bool                      `yaml:"recursive"`
+	Include               []string                  `yaml:"include,omitempty"` // Optional: only files matching these globs are processed, before ignore applies
+	Ignore                []string                  `yaml:"ignore"`
+	MinSizeBytes          int64                     `yaml:"min_size_bytes,omitempty"`          // Optional: smaller files are not delivered, e.g. 1 for empty placeholders
+	MaxSizeBytes          int64                     `yaml:"max_size_bytes,omitempty"`          // Optional: larger files are not delivered (0 = unlimited)
+	RejectedPath          string                    `yaml:"rejected_path,omitempty"`           // Optional: files outside the size range are moved here instead of left in place (quarantine_path takes precedence)
+	BlockedExtensions     []string                  `yaml:"blocked_extensions,omitempty"`      // Optional: files with these extensions are not delivered, e.g. [.exe, .bat]
+	QuarantinePath        string                    `yaml:"quarantine_path,omitempty"`         // Optional: files failing validation are moved here with a .reason.json sidecar
+	QuarantineRetention   RetentionConfig           `yaml:"quarantine_retention,omitempty"`    // Optional: bounds the age and size of quarantine_path (or rejected_path)
+	AfterUpload           string                    `yaml:"after_upload,omitempty"`            // Optional: delete (default), keep or move the source once it is delivered
+	ProcessedPath         string                    `yaml:"processed_path,omitempty"`          // Optional: where after_upload: move puts delivered files, date tokens such as %Y/%m/%d are expanded (UTC)
+	ReadOnly              bool                      `yaml:"read_only,omitempty"`               // Optional: never delete or move source files, track delivered files instead
+	ReadOnlyState         ReadOnlyStateConfig       `yaml:"read_only_state,omitempty"`         // Optional: how read_only tracks delivered files
+	Hosts                 []string                  `yaml:"hosts,omitempty"`                   // Optional: Host headers this directory accepts uploads on
+	Listeners             []string                  `yaml:"listeners,omitempty"`               // Optional: named listeners this directory accepts uploads on
+	MaxUploadBytes        int64                     `yaml:"max_upload_bytes,omitempty"`        // Optional: overrides server.max_upload_bytes
+	AllowSubdirectories   *bool                     `yaml:"allow_subdirectories,omitempty"`    // Optional: accept uploads into subdirectories (default true)
+	MaxDepth              int                       `yaml:"max_depth,omitempty"`               // Optional: most nested subdirectory levels an upload may use (0 = unlimited)
+	MaxWorkers            int                       `yaml:"max_workers,omitempty"`             // Optional: upload workers for this directory (default 4)
+	QueueSize             int                       `yaml:"queue_size,omitempty"`              // Optional: upload queue capacity (default 100)
+	QueueOverflow         QueueOverflow             `yaml:"queue_overflow,omitempty"`          // Optional: what to do when the upload queue is full
+	QueueStateFile        string                    `yaml:"queue_state_file,omitempty"`        // Optional: persist queued files and enqueue them again on restart
+	Ordered               bool                      `yaml:"ordered,omitempty"`                 // Optional: deliver files strictly in the order they were enqueued
+	OrderingKey           string                    `yaml:"ordering_key,omitempty"`            // Optional: directory (default, single stream) or subdirectory
+	Priorities            []PriorityRule            `yaml:"priorities,omitempty"`              // Optional: upload priority by file pattern (first match wins)
+	ContentRules          []ContentRule             `yaml:"content_rules,omitempty"`           // Optional: ignore or route files by detected type (first match wins)
+	Routes                []RouteRule               `yaml:"routes,omitempty"`                  // Optional: destination, headers or auth by name, path and size (first match wins)
+	UploadDeadlineSeconds int                       `yaml:"upload_deadline_seconds,omitempty"` // Optional: hard per-file limit, stuck uploads are cancelled and their worker replaced (default 0, disabled)
+	SLA                   SLAConfig                 `yaml:"sla,omitempty"`                     // Optional: escalate files not delivered within a deadline of their detection
+	Scan                  ScanConfig                `yaml:"scan,omitempty"`                    // Optional: scan files for malware before upload, quarantining infected ones
+	AdaptiveConcurrency   AdaptiveConcurrencyConfig `yaml:"adaptive_concurrency,omitempty"`    // Optional: tune concurrent uploads to the destination's responses
+	Rotation              RotationConfig            `yaml:"rotation,omitempty"`                // Optional: handle files renamed by rotation tools such as logrotate
+	Watch                 WatchConfig               `yaml:"watch"`
+	Stability             StabilityConfig           `yaml:"stability"`
+	Shadow                ShadowConfig              `yaml:"shadow"`
+	Outbound              OutboundConfig            `yaml:"outbound"`
+	Mirror                MirrorConfig              `yaml:"mirror,omitempty"`      // Optional: forward delivered files to a secondary xferd
+	PostUpload            PostUploadConfig          `yaml:"post_upload,omitempty"` // Optional: run a command or webhook for every delivered file
+	Batch                 BatchConfig               `yaml:"batch,omitempty"`       // Optional: upload small files together as archives
+	Passthrough           PassthroughConfig         `yaml:"passthrough,omitempty"` // Optional: stream REST uploads straight to the outbound destination
+}
+
+// SLAConfig defines an overall deadline from detection to delivery. Files
+// still not delivered when it passes are escalated once: logged, counted,
+// exported as overdue journal events and optionally sent to the failover
+// destinations.
+type SLAConfig struct {
+	Enabled         bool `yaml:"enabled"`
+	DeadlineSeconds int  `yaml:"deadline_seconds"` // Longest time from detection to delivery (default 3600)
+	Failover        bool `yaml:"failover"`         // Send overdue files to outbound.failover.urls, skipping the primary
+}
+
+// BatchConfig defines archiving of small files into batches. Files are
+// collected until max_files or max_bytes is reached or window_ms has passed
+// since the first one, then uploaded together as a single archive.
+type BatchConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Format   string `yaml:"format"`    // tar (default), tar.gz or zip
+	MaxFiles int    `yaml:"max_files"` // Most files per archive (default 1000)
+	MaxBytes int64  `yaml:"max_bytes"` // Most content per archive (default 64 MiB); larger files are uploaded on their own
+	WindowMs int    `yaml:"window_ms"` // Longest a file waits for its batch to fill (default 10000)
+}
+
+// Batch archive formats
+const (
+	BatchFormatTar   = "tar"
+	BatchFormatTarGz = "tar.gz"
+	BatchFormatZip   = "zip"
+)
+
+// GetFormat returns the archive format of batches
+func (b *BatchConfig) GetFormat() string {
+	if b.Format == "" {
+		return BatchFormatTar
+	}
+	return b.Format
+}
+
+// GetMaxFiles returns the most files in one batch
+func (b *BatchConfig) GetMaxFiles() int {
+	if b.MaxFiles <= 0 {
+		return 1000
+	}
+	return b.MaxFiles
+}
+
+// GetMaxBytes returns the most file content in one batch
+func (b *BatchConfig) GetMaxBytes() int64 {
+	if b.MaxBytes <= 0 {
+		return 64 << 20
+	}
+	return b.MaxBytes
+}
+
+// GetWindow returns how long a batch collects files after its first one
+func (b *BatchConfig) GetWindow() time.Duration {
+	if b.WindowMs <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(b.WindowMs) * time.Millisecond
+}
+
+// validate checks the batch settings of a directory. Batches are delivered
+// as a whole, so per-file delivery features do not apply to them.
+func (b *BatchConfig) validate(d *DirectoryConfig) error {
+	if b.MaxFiles < 0 || b.MaxBytes < 0 || b.WindowMs < 0 {
+		return fmt.Errorf("batch.max_files, max_bytes and window_ms must not be negative")
+	}
+	if !b.Enabled {
+		return nil
+	}
+	switch b.GetFormat() {
+	case BatchFormatTar, BatchFormatTarGz, BatchFormatZip:
+	default:
+		return fmt.Errorf("invalid batch.format: %s (tar, tar.gz or zip)", b.Format)
+	}
+	o := &d.Outbound
+	switch {
+	case d.Passthrough.Enabled, d.Ordered:
+		return fmt.Errorf("batch cannot be combined with passthrough or ordered delivery")
+	case o.Versioning.Enabled, o.Dedup.Enabled, o.IdempotencyKey.Enabled:
+		return fmt.Errorf("batch cannot be combined with outbound.versioning, dedup or idempotency_key")
+	case len(d.Routes) > 0:
+		return fmt.Errorf("batch cannot be combined with routes")
+	case d.ReadOnly:
+		return fmt.Errorf("batch cannot be combined with read_only")
+	}
+	for i, rule := range d.ContentRules {
+		if rule.GetAction() == ContentActionRoute {
+			return fmt.Errorf("content_rules[%d]: routing cannot be combined with batch", i)
+		}
+	}
+	return nil
+}
+
+// PostUploadConfig defines a hook run after each successful delivery and
+// before the source is deleted, e.g. to update an external tracking system.
+// The delivery is described as JSON on the command's standard input and in
+// the webhook's request body. Sources of files whose hook fails are kept.
+type PostUploadConfig struct {
+	Enabled    bool              `yaml:"enabled"`
+	Command    []string          `yaml:"command"`     // Program and arguments to run, e.g. [/usr/local/bin/track, --delivered]
+	URL        string            `yaml:"url"`         // Webhook the delivery is POSTed to
+	Headers    map[string]string `yaml:"headers"`     // Extra webhook request headers, e.g. Authorization
+	TimeoutMs  int               `yaml:"timeout_ms"`  // Longest a hook run may take (default 30000)
+	MaxRetries int               `yaml:"max_retries"` // Retries of a failed hook before the file is quarantined (default 2)
+}
+
+// GetTimeout returns how long a hook run may take
+func (p *PostUploadConfig) GetTimeout() time.Duration {
+	if p.TimeoutMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(p.TimeoutMs) * time.Millisecond
+}
+
+// GetMaxRetries returns how often a failed hook is retried
+func (p *PostUploadConfig) GetMaxRetries() int {
+	if p.MaxRetries > 0 {
+		return p.MaxRetries
+	}
+	return 2
+}
+
+// validate checks the post-upload hook of a directory
+func (p *PostUploadConfig) validate(d *DirectoryConfig) error {
+	if p.TimeoutMs < 0 || p.MaxRetries < 0 {
+		return fmt.Errorf("post_upload.timeout_ms and post_upload.max_retries must not be negative")
+	}
+	if !p.Enabled {
+		return nil
+	}
+	if len(p.Command) == 0 && p.URL == "" {
+		return fmt.Errorf("post_upload requires a command or url")
+	}
+	if len(p.Command) > 0 && p.Command[0] == "" {
+		return fmt.Errorf("post_upload.command must name a program")
+	}
+	if p.URL != "" {
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("post_upload.url must be an http or https URL")
+		}
+	}
+	if d.Passthrough.Enabled {
+		return fmt.Errorf("passthrough cannot be combined with post_upload")
+	}
+	return nil
+}
+
+// Malware scanners
+const (
+	ScanTypeClamd = "clamd" // ClamAV daemon, streamed with INSTREAM
+	ScanTypeICAP  = "icap"  // ICAP server, sent as a RESPMOD request
+)
+
+// ScanConfig defines a malware scan of every file before it is uploaded.
+// Infected files are moved to quarantine_path and exported as quarantined
+// journal events; files that could not be scanned are not uploaded and retried.
+type ScanConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Type      string `yaml:"type"`       // clamd (default) or icap
+	Address   string `yaml:"address"`    // clamd: unix socket path or host:port
+	URL       string `yaml:"url"`        // icap: service URL, e.g. icap://scanner:1344/avscan
+	TimeoutMs int    `yaml:"timeout_ms"` // Longest a scan may take (default 60000)
+}
+
+// GetType returns the scanner type
+func (s *ScanConfig) GetType() string {
+	if s.Type == "" {
+		return ScanTypeClamd
+	}
+	return s.Type
+}
+
+// GetTimeout returns how long a scan may take
+func (s *ScanConfig) GetTimeout() time.Duration {
+	if s.TimeoutMs <= 0 {
+		return time.Minute
+	}
+	return time.Duration(s.TimeoutMs) * time.Millisecond
+}
+
+// validate checks the scan settings of a directory
+func (s *ScanConfig) validate(d *DirectoryConfig) error {
+	if s.TimeoutMs < 0 {
+		return fmt.Errorf("scan.timeout_ms must not be negative")
+	}
+	if !s.Enabled {
+		return nil
+	}
+	switch s.GetType() {
+	case ScanTypeClamd:
+		if s.Address == "" {
+			return fmt.Errorf("scan.address is required for clamd")
+		}
+	case ScanTypeICAP:
+		u, err := url.Parse(s.URL)
+		if err != nil || u.Scheme != "icap" || u.Host == "" {
+			return fmt.Errorf("scan.url must be an icap:// URL")
+		}
+	default:
+		return fmt.Errorf("invalid scan.type: %s (clamd or icap)", s.Type)
+	}
+	if d.GetQuarantinePath() == "" {
+		return fmt.Errorf("scan requires quarantine_path for infected files")
+	}
+	if d.Passthrough.Enabled {
+		return fmt.Errorf("passthrough cannot be combined with scan")
+	}
+	return nil
+}
+
+// Rotation handling of active and rotated files
+const (
+	RotationActiveIgnore  = "ignore"  // active files are never delivered
+	RotationActiveDeliver = "deliver" // active files are delivered like any other file
+	RotationRotatedOnce   = "once"    // each rotated file is delivered once, however often it is renamed
+	RotationRotatedIgnore = "ignore"  // rotated files are never delivered
+)
+
+// RotationConfig defines how files written by an application and rotated by
+// renaming, e.g. app.log to app.log.1 or app.log-20260101, are delivered
+type RotationConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	Patterns []string `yaml:"patterns"` // Active files, e.g. "*.log"
+	Active   string   `yaml:"active"`   // ignore (default) or deliver
+	Rotated  string   `yaml:"rotated"`  // once (default) or ignore
+}
+
+// PassthroughConfig defines direct delivery of files received through the
+// ingest API. Files are streamed to the outbound destination while they are
+// received and only written to the watch directory if that upload fails.
+type PassthroughConfig struct {
+	Enabled     bool  `yaml:"enabled"`
+	BufferBytes int64 `yaml:"buffer_bytes"` // Copy kept in memory per upload before it is staged on disk (default 8 MiB)
+}
+
+// MirrorConfig defines best-effort forwarding of every delivered file to the
+// upload endpoint of another xferd instance, e.g. to keep a DR site warm
+type MirrorConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	URL       string            `yaml:"url"`        // Base URL of the secondary xferd, e.g. https://dr.example.com:8080
+	Directory string            `yaml:"directory"`  // Directory name on the secondary (default: this directory's name)
+	Auth      AuthConfig        `yaml:"auth"`       // none, basic or bearer
+	TLS       OutboundTLSConfig `yaml:"tls"`        // Optional: TLS settings for the secondary
+	QueueSize int               `yaml:"queue_size"` // Files waiting to be mirrored; more are dropped (default 100)
+	SpoolPath string            `yaml:"spool_path"` // Where copies wait to be mirrored (default: <server.temp_dir>/mirror/<name>)
+}
+
+// AdaptiveConcurrencyConfig defines AIMD tuning of concurrent uploads: the
+// limit grows by one while the destination keeps up and is halved when it
+// answers 429/503 or slower than the target latency. max_workers is the ceiling.
+type AdaptiveConcurrencyConfig struct {
+	Enabled         bool `yaml:"enabled"`
+	MinWorkers      int  `yaml:"min_workers"`       // Floor and starting point of the limit (default 1)
+	TargetLatencyMs int  `yaml:"target_latency_ms"` // Responses slower than this count as congestion (default 0, only 429/503)
+}
+
+// QueueOverflow defines how a full upload queue is handled
+type QueueOverflow struct {
+	Policy         string `yaml:"policy"`           // drop_newest (default), drop_oldest or block
+	BlockTimeoutMs int    `yaml:"block_timeout_ms"` // block only: how long to wait before dropping (default 30000)
+}
+
+// PriorityRule assigns an upload priority to files matching ignore-style patterns
+type PriorityRule struct {
+	Priority string   `yaml:"priority"` // high, normal or low
+	Patterns []string `yaml:"patterns"` // Filename globs, or path globs if they contain a separator
+}
+
+// ContentRule ignores or routes files by their detected type (magic bytes)
+// rather than their name
+type ContentRule struct {
+	Types  []string `yaml:"types"`  // Detected types, e.g. zip, gzip, pdf, xml
+	Action string   `yaml:"action"` // route (default) or ignore
+	URL    string   `yaml:"url"`    // route only: outbound URL template used instead of outbound.url
+}
+
+// RouteRule sends files matching all of its conditions to a different
+// destination, or with different headers or authentication
+type RouteRule struct {
+	Patterns     []string          `yaml:"patterns"`       // Filename globs, or globs on the path below the watch directory if they contain a /
+	Regex        string            `yaml:"regex"`          // Regular expression on the path below the watch directory
+	Subdirectory string            `yaml:"subdirectory"`   // Only files below this subdirectory of the watch directory
+	MinSizeBytes int64             `yaml:"min_size_bytes"` // Only files at least this large
+	MaxSizeBytes int64             `yaml:"max_size_bytes"` // Only files at most this large (0 = no limit)
+	URL          string            `yaml:"url"`            // Outbound URL template used instead of outbound.url
+	Headers      map[string]string `yaml:"headers"`        // Extra headers sent with the upload
+	Auth         *AuthConfig       `yaml:"auth"`           // Replaces outbound.auth
+}
+
+// WatchConfig defines watching behavior
+type WatchConfig struct {
+	Mode                 string              `yaml:"mode"`
+	StartupReconcileScan *bool               `yaml:"startup_reconcile_scan"`
+	ReconcileScan        ReconcileScanConfig `yaml:"reconcile_scan"`
+	CloseWrite           bool                `yaml:"close_write"` // hybrid mode, Linux only: deliver files when their writer closes them instead of checking stability
+	Tail                 TailConfig          `yaml:"tail"`        // tail mode only
+}
+
+// Tail delivery modes
+const (
+	TailDeliverSegments = "segments" // appended data, periodically
+	TailDeliverClose    = "close"    // whole file, when its writer closes it
+)
+
+// TailConfig defines how growing files are delivered in tail mode
+type TailConfig struct {
+	Deliver    string `yaml:"deliver"`     // segments (default) or close (Linux only)
+	IntervalMs int    `yaml:"interval_ms"` // segments: time between checks for appended data (default 10000)
+	SpoolPath  string `yaml:"spool_path"`  // segments: where segments wait for delivery, with the file offsets
+}
+
+// ReconcileScanConfig defines periodic reconciliation
+type ReconcileScanConfig struct {
+	Enabled         bool `yaml:"enabled"`
+	IntervalSeconds int  `yaml:"interval_seconds"`
+}
+
+// StabilityConfig defines file stability confirmation settings
+type StabilityConfig struct {
+	ConfirmationIntervalMs int `yaml:"confirmation_interval_ms"`
+	RequiredStableChecks   int `yaml:"required_stable_checks"`
+	MaxWaitMs              int `yaml:"max_wait_ms"`
+	DebounceMs             int `yaml:"debounce_ms"` // Hybrid mode: quiet time after the last write event before the stability check starts (default: confirmation_interval_ms)
+}
+
+// ShadowConfig defines shadow directory settings
+type ShadowConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Path           string   `yaml:"path"` // Date tokens such as %Y/%m/%d are expanded per file (UTC)
+	RetentionHours int      `yaml:"retention_hours"`
+	Layout         string   `yaml:"layout"`        // flat (default: <timestamp>-<name>) or relative (the file's path below the watch directory)
+	RestorePaths   []string `yaml:"restore_paths"` // Directories POST /shadow/restore may restore copies into besides the watch path
+	Compression    string   `yaml:"compression"`   // none (default) or gzip: copies are stored gzipped with .gz appended
+}
+
+// Shadow copy layouts
+const (
+	ShadowLayoutFlat     = "flat"
+	ShadowLayoutRelative = "relative"
+)
+
+// Shadow copy compression
+const (
+	ShadowCompressionNone = "none"
+	ShadowCompressionGzip = "gzip"
+)
+
+// OutboundConfig defines upload destination settings
+type OutboundConfig struct {
+	Type                 string             `yaml:"type"`        // http (default), ftp (url is ftp:// or ftps://) or local_dir (move files into path instead of uploading them)
+	Path                 string             `yaml:"path"`        // local_dir only: target directory, may contain date tokens such as %Y/%m/%d; subdirectories below the watch directory are kept
+	URL                  string             `yaml:"url"`         // Template expanded per file, e.g. {{.Filename}}, {{.RelPath}} (see README)
+	Method               string             `yaml:"method"`      // POST (default) or PUT
+	BodyFormat           string             `yaml:"body_format"` // multipart (default) or raw (file content as the body, name in X-Filename)
+	FieldName            string             `yaml:"field_name"`  // Multipart form field holding the file (default: file)
+	Auth                 AuthConfig         `yaml:"auth"`
+	Connection           ConnectionConfig   `yaml:"connection"`
+	TLS                  OutboundTLSConfig  `yaml:"tls"`
+	PropagateDeletes     bool               `yaml:"propagate_deletes"` // Send DELETE when a queued file is removed before upload
+	Versioning           VersioningConfig   `yaml:"versioning"`
+	StreamThresholdBytes int64              `yaml:"stream_threshold_bytes"` // Files larger than this are streamed (default 1 MiB)
+	Commit               CommitConfig       `yaml:"commit"`
+	Dedup                DedupConfig        `yaml:"dedup"`
+	IdempotencyKey       IdempotencyConfig  `yaml:"idempotency_key"`
+	RelativePath         RelativePathConfig `yaml:"relative_path"`
+	Success              SuccessConfig      `yaml:"success"`
+	Verify               VerifyConfig       `yaml:"verify"`
+	Failover             FailoverConfig     `yaml:"failover"`
+	FTP                  FTPConfig          `yaml:"ftp"`
+	Compression          CompressionConfig  `yaml:"compression"`
+	Encryption           EncryptionConfig   `yaml:"encryption"`
+}
+
+// EncryptionConfig defines encryption of files for their recipient before
+// they are delivered. Content is encrypted while it is streamed to the
+// destination, so no encrypted copy is written to disk; the name sent gets
+// the suffix of the format, .age or .pgp.
+type EncryptionConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Type       string   `yaml:"type"`       // age (default) or pgp
+	Recipients []string `yaml:"recipients"` // age: X25519 recipients, e.g. age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+	KeyFile    string   `yaml:"key_file"`   // pgp: the recipient's public key, armored or binary; every key in the file can decrypt
+}
+
+// Encryption formats
+const (
+	EncryptionAge = "age"
+	EncryptionPGP = "pgp"
+)
+
+// GetType returns the encryption format
+func (e *EncryptionConfig) GetType() string {
+	if e.Type == "" {
+		return EncryptionAge
+	}
+	return e.Type
+}
+
+// validate checks the encryption settings of an outbound. Keys are parsed
+// when the directory is created.
+func (e *EncryptionConfig) validate(o *OutboundConfig) error {
+	if !e.Enabled {
+		return nil
+	}
+	switch e.GetType() {
+	case EncryptionAge:
+		if len(e.Recipients) == 0 {
+			return fmt.Errorf("outbound.encryption.recipients is required for age")
+		}
+		for _, r := range e.Recipients {
+			if !strings.HasPrefix(r, "age1") {
+				return fmt.Errorf("invalid outbound.encryption.recipients entry: %q (age1...)", r)
+			}
+		}
+	case EncryptionPGP:
+		if e.KeyFile == "" {
+			return fmt.Errorf("outbound.encryption.key_file is required for pgp")
+		}
+	default:
+		return fmt.Errorf("invalid outbound.encryption.type: %s (age or pgp)", e.Type)
+	}
+	switch {
+	case o.GetType() == OutboundLocalDir:
+		return fmt.Errorf("outbound.encryption is not supported with local_dir")
+	case o.Verify.Enabled:
+		return fmt.Errorf("outbound.verify cannot be combined with encryption, the destination stores the encrypted file")
+	case o.Compression.Enabled && o.Compression.GetMode() != CompressionSuffix:
+		return fmt.Errorf("outbound.compression requires mode suffix with encryption, encrypted content does not compress")
+	}
+	return nil
+}
+
+// CompressionConfig defines gzip compression of files while they are
+// uploaded. Small files and types that are already compressed are sent as is.
+type CompressionConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Mode         string   `yaml:"mode"`           // header (default): the request body is sent with Content-Encoding: gzip; suffix: the file is sent gzipped with .gz appended to its name
+	Level        int      `yaml:"level"`          // gzip level from 1 (fastest) to 9 (smallest), default 6
+	MinSizeBytes int64    `yaml:"min_size_bytes"` // Smaller files are sent uncompressed (default 1024)
+	ExcludeTypes []string `yaml:"exclude_types"`  // MIME types sent uncompressed, e.g. image/* (default: archives, images, audio and video)
+}
+
+// FTPConfig defines delivery to FTP and FTPS servers. Transfers are binary
+// and use passive mode; ftps:// URLs use implicit TLS.
+type FTPConfig struct {
+	ExplicitTLS bool `yaml:"explicit_tls"` // ftp:// only: upgrade the connection with AUTH TLS and encrypt data connections
+}
+
+// FailoverConfig defines secondary destinations used while the ones before
+// them are unavailable
+type FailoverConfig struct {
+	URLs             []string `yaml:"urls"`              // Secondary URL templates, tried in order after url
+	FailureThreshold int      `yaml:"failure_threshold"` // Consecutive failed uploads that take a destination out of rotation (default 3)
+	CooldownSeconds  int      `yaml:"cooldown_seconds"`  // How long it stays out before it is tried again (default 60)
+}
+
+// VerifyConfig defines a request confirming the destination persisted an
+// upload before the source file is shadow-copied and deleted
+type VerifyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"`    // Verification endpoint, {upload_id} and {checksum} (SHA-256) are replaced (default: the upload URL)
+	Method  string `yaml:"method"` // HEAD (default) or GET
+}
+
+// SuccessConfig defines checks a 2xx upload response must also pass to count
+// as delivered. Failed checks are retried like server errors.
+type SuccessConfig struct {
+	JSONField string `yaml:"json_field"` // Field of a JSON response body, dotted for nested objects, e.g. result.status
+	JSONValue string `yaml:"json_value"` // Required value of json_field, compared as text (empty: field must be present)
+	BodyRegex string `yaml:"body_regex"` // Regular expression the response body must match
+	Header    string `yaml:"header"`     // Response header that must be present
+}
+
+// RelativePathConfig defines how the file's path below the watch directory is
+// sent, so the destination can rebuild the directory layout
+type RelativePathConfig struct {
+	Mode string `yaml:"mode"` // url (appended to the URL path), header or field (multipart only); empty disables
+	Name string `yaml:"name"` // Header or form field name (default: X-Relative-Path / relative_path)
+}
+
+// Relative path modes
+const (
+	RelativePathURL    = "url"
+	RelativePathHeader = "header"
+	RelativePathField  = "field"
+)
+
+// IdempotencyConfig defines the stable per-file key sent with every upload attempt
+type IdempotencyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Source  string `yaml:"source"` // metadata (default: path, size and mtime) or content (SHA-256 digest)
+	Header  string `yaml:"header"` // Header name (default: Idempotency-Key)
+}
+
+// Outbound compression modes
+const (
+	CompressionHeader = "header" // Content-Encoding: gzip on the request
+	CompressionSuffix = "suffix" // gzipped file named <name>.gz
+)
+
+// DefaultCompressionExcludeTypes are the MIME types of files that are sent
+// uncompressed unless exclude_types is set, as gzip barely shrinks them
+var DefaultCompressionExcludeTypes = []string{
+	"application/gzip", "application/x-gzip", "application/zip", "application/x-bzip2", "application/x-xz",
+	"application/zstd", "application/x-7z-compressed", "image/*", "audio/*", "video/*",
+}
+
+// GetMode returns how compressed files are marked
+func (c *CompressionConfig) GetMode() string {
+	if c.Mode == "" {
+		return CompressionHeader
+	}
+	return c.Mode
+}
+
+// GetLevel returns the gzip compression level
+func (c *CompressionConfig) GetLevel() int {
+	if c.Level == 0 {
+		return 6
+	}
+	return c.Level
+}
+
+// GetMinSize returns the smallest file that is compressed
+func (c *CompressionConfig) GetMinSize() int64 {
+	if c.MinSizeBytes == 0 {
+		return 1024
+	}
+	return c.MinSizeBytes
+}
+
+// GetExcludeTypes returns the MIME types that are sent uncompressed
+func (c *CompressionConfig) GetExcludeTypes() []string {
+	if c.ExcludeTypes == nil {
+		return DefaultCompressionExcludeTypes
+	}
+	return c.ExcludeTypes
+}
+
+// Excludes reports whether files of a MIME type are sent uncompressed.
+// Parameters such as charset are ignored; patterns may use wildcards.
+func (c *CompressionConfig) Excludes(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	for _, pattern := range c.GetExcludeTypes() {
+		if ok, _ := path.Match(strings.ToLower(pattern), mimeType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the compression settings of an outbound
+func (c *CompressionConfig) validate(o *OutboundConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if o.GetType() != OutboundHTTP {
+		return fmt.Errorf("outbound.compression is only supported with http")
+	}
+	switch c.GetMode() {
+	case CompressionHeader:
+	case CompressionSuffix:
+		if o.Verify.Enabled {
+			return fmt.Errorf("outbound.verify cannot be combined with compression mode suffix, the destination stores the gzipped file")
+		}
+	default:
+		return fmt.Errorf("invalid outbound.compression.mode: %s (header or suffix)", c.Mode)
+	}
+	if c.Level < 0 || c.Level > 9 {
+		return fmt.Errorf("outbound.compression.level must be between 1 and 9")
+	}
+	if c.MinSizeBytes < 0 {
+		return fmt.Errorf("outbound.compression.min_size_bytes must not be negative")
+	}
+	for _, pattern := range c.ExcludeTypes {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid outbound.compression.exclude_types entry: %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Outbound body formats
+const (
+	BodyFormatMultipart = "multipart"
+	BodyFormatRaw       = "raw"
+)
+
+// Idempotency key sources
+const (
+	IdempotencySourceMetadata = "metadata"
+	IdempotencySourceContent  = "content"
+)
+
+// DedupConfig defines suppression of byte-identical files uploaded shortly after each other
+type DedupConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	WindowSeconds int    `yaml:"window_seconds"` // How long an uploaded hash suppresses duplicates (default 3600)
+	MaxEntries    int    `yaml:"max_entries"`    // Hashes remembered, least recently uploaded evicted first (default 10000)
+	StateFile     string `yaml:"state_file"`     // Optional: persist remembered hashes across restarts
+}
+
+// CommitConfig defines two-phase delivery to destinations that answer an upload
+// with an upload ID that must be committed before the file is accepted
+type CommitConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"`      // Commit endpoint, {upload_id} is replaced (default: <outbound url>/{upload_id}/commit)
+	IDField string `yaml:"id_field"` // JSON response field holding the upload ID (default: upload_id)
+}
+
+// ReadOnlyStateConfig defines how a read_only directory recognizes files it
+// delivered already. With outbound.versioning, its history is used instead.
+type ReadOnlyStateConfig struct {
+	Detect    string `yaml:"detect"`     // size_mtime (default) or hash
+	StateFile string `yaml:"state_file"` // Optional: persist delivered files across restarts
+}
+
+// VersioningConfig defines re-delivery of files that change after they were delivered
+type VersioningConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Detect    string `yaml:"detect"`     // size_mtime (default) or hash
+	StateFile string `yaml:"state_file"` // Optional: persist delivery history across restarts
+}
+
+// OutboundTLSConfig defines TLS settings for outbound connections
+type OutboundTLSConfig struct {
+	CertFile           string `yaml:"cert_file"`            // Client certificate for mTLS
+	KeyFile            string `yaml:"key_file"`             // Client certificate key for mTLS
+	CAFile             string `yaml:"ca_file"`              // Custom CA bundle (replaces system roots)
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Disable server certificate verification (testing only)
+	MinVersion         string `yaml:"min_version"`          // Minimum TLS version: 1.2 (default) or 1.3
+	SessionCacheSize   int    `yaml:"session_cache_size"`   // TLS sessions kept for resumption (default 64, -1 disables)
+}
+
+// ConnectionConfig defines outbound connection management settings
+type ConnectionConfig struct {
+	WarmUp             bool   `yaml:"warm_up"`              // Establish a connection to the destination on startup
+	DNSRefreshSeconds  int    `yaml:"dns_refresh_seconds"`  // Re-resolve the destination and failover hosts periodically (0 = disabled)
+	IPFamily           string `yaml:"ip_family"`            // auto (default), ipv4 or ipv6
+	FallbackDelayMs    int    `yaml:"fallback_delay_ms"`    // Happy Eyeballs fallback delay (0 = 300ms default, negative disables)
+	MinThroughputBytes int64  `yaml:"min_throughput_bytes"` // Scale the request timeout to file size at this many bytes/s (0 = fixed 5 minute timeout)
+	MinTimeoutSeconds  int    `yaml:"min_timeout_seconds"`  // Timeout floor added to the scaled timeout (default 30)
+}
+
+// AuthConfig defines authentication settings
+type AuthConfig struct {
+	Type         string `yaml:"type"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	Token        string `yaml:"token"`
+	PasswordFile string `yaml:"password_file"` // Alternative: file holding the password
+	TokenFile    string `yaml:"token_file"`    // Alternative: file holding the token
+
+	// AWS SigV4 signing (type: aws_sigv4)
+	Region           string `yaml:"region"`
+	Service          string `yaml:"service"`           // e.g. execute-api, s3
+	CredentialSource string `yaml:"credential_source"` // static, env or instance_profile (default: first available)
+	AccessKeyID      string `yaml:"access_key_id"`
+	SecretAccessKey  string `yaml:"secret_access_key"`
+	SessionToken     string `yaml:"session_token"`
+}
+
+// Load reads and parses the configuration file
+func Load(path string) (*Config, error) {
+	path, err := ResolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if doc.Kind != 0 {
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
+
+	// Add the directories of included fragments
+	fragments, err := includedFiles(path, doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, fragment := range fragments {
+		dirs, err := loadFragment(fragment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load included file %s: %w", fragment, err)
+		}
+		cfg.Directories = append(cfg.Directories, dirs...)
+	}
+
+	// Apply environment variable overrides
+	applyEnvOverrides(&cfg)
+
+	// Read *_file and vault: secrets
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	// Set defaults
+	setDefaults(&cfg)
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// readDocument reads and parses a configuration file, expanding ${VAR}
+// references before decoding so they work in any field
+func readDocument(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- configuration file chosen by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if err := expandEnv(&doc); err != nil {
+		return nil, fmt.Errorf("failed to expand environment variables: %w", err)
+	}
+	return &doc, nil
+}
+
+// Files returns the configuration file at path followed by the fragments it
+// includes, e.g. to watch all of them for changes
+func Files(path string) ([]string, error) {
+	path, err := ResolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	fragments, err := includedFiles(path, doc)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{path}, fragments...), nil
+}
+
+// includedFiles returns the fragments matched by the include setting of a
+// configuration document, a path or glob or a list of them. Relative
+// patterns are relative to the configuration file. Each pattern's matches
+// are sorted by name; a glob may match nothing, a plain path must exist.
+func includedFiles(path string, doc *yaml.Node) ([]string, error) {
+	var patterns []string
+	if include := mappingValue(doc, "include"); include != nil {
+		if include.Kind == yaml.ScalarNode {
+			patterns = []string{include.Value}
+		} else if err := include.Decode(&patterns); err != nil {
+			return nil, fmt.Errorf("invalid include: must be a path or a list of paths")
+		}
+	}
+
+	var files []string
+	seen := map[string]bool{filepath.Clean(path): true}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("included file not found: %s", pattern)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				continue
+			}
+			if !seen[filepath.Clean(match)] {
+				seen[filepath.Clean(match)] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// loadFragment reads the directories of an included fragment, which may set
+// nothing else
+func loadFragment(path string) ([]DirectoryConfig, error) {
+	doc, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		return nil, nil // empty file
+	}
+	if root := doc.Content[0]; root.Kind == yaml.MappingNode {
+		for i := 0; i < len(root.Content); i += 2 {
+			if key := root.Content[i].Value; key != "directories" {
+				return nil, fmt.Errorf("only directories may be set in an included file, found %s", key)
+			}
+		}
+	}
+	var fragment struct {
+		Directories []DirectoryConfig `yaml:"directories"`
+	}
+	if err := doc.Decode(&fragment); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return fragment.Directories, nil
+}
+
+// mappingValue returns the value of key in the top-level mapping of a
+// document, nil if it is not set
+func mappingValue(doc *yaml.Node, key string) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			return root.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// configFileNames are looked for, in order, when the configuration path is a
+// directory, e.g. a mounted ConfigMap
+var configFileNames = []string{"config.yml", "config.yaml", "xferd.yml", "xferd.yaml"}
+
+// ResolvePath returns the configuration file at path. If path is a
+// directory, the first of config.yml, config.yaml, xferd.yml and xferd.yaml
+// in it is used.
+func ResolvePath(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return path, nil // reported when the file is read
+	}
+	for _, name := range configFileNames {
+		candidate := filepath.Join(path, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no configuration file in %s: expected one of %s", path, strings.Join(configFileNames, ", "))
+}
+
+// Validate checks the configuration for errors
+func (c *Config) Validate() error {
+	if len(c.Server.Listen) == 0 && c.Server.IsEnabled() {
+		if c.Server.Port <= 0 || c.Server.Port > 65535 {
+			return fmt.Errorf("invalid server port: %d", c.Server.Port)
+		}
+	}
+
+	validNetworks := map[string]bool{"": true, "tcp": true, "tcp4": true, "tcp6": true}
+	listenerNames := make(map[string]bool)
+	for i, l := range c.Server.Listen {
+		if l.Name != "" {
+			if listenerNames[l.Name] {
+				return fmt.Errorf("listen[%d]: duplicate listener name: %s", i, l.Name)
+			}
+			listenerNames[l.Name] = true
+		}
+		if l.Port <= 0 || l.Port > 65535 {
+			return fmt.Errorf("listen[%d]: invalid port: %d", i, l.Port)
+		}
+		if !validNetworks[l.Network] {
+			return fmt.Errorf("listen[%d]: invalid network: %s", i, l.Network)
+		}
+	}
+
+	if c.Server.TempDir == "" {
+		return fmt.Errorf("temp_dir is required")
+	}
+
+	if c.Server.MaxUploadBytes < 0 {
+		return fmt.Errorf("max_upload_bytes must not be negative")
+	}
+
+	if t := c.Server.TempCleanup; t.MaxAgeMinutes < 0 || t.IntervalMinutes < 0 {
+		return fmt.Errorf("temp_cleanup.max_age_minutes and interval_minutes must not be negative")
+	}
+	if err := c.Server.TempCleanup.QuarantineRetention.validate(c.Server.TempCleanup.QuarantinePath); err != nil {
+		return fmt.Errorf("temp_cleanup.%w", err)
+	}
+
+	switch c.Server.AccessLog.Format {
+	case "", "combined", "json":
+	default:
+		return fmt.Errorf("invalid access_log.format: %s (combined or json)", c.Server.AccessLog.Format)
+	}
+
+	// Validate basic auth config
+	if c.Server.BasicAuth.Enabled {
+		if c.Server.BasicAuth.Username == "" {
+			return fmt.Errorf("basic_auth.username is required when basic_auth is enabled")
+		}
+		if c.Server.BasicAuth.Password == "" && c.Server.BasicAuth.PasswordHash == "" {
+			return fmt.Errorf("either basic_auth.password or basic_auth.password_hash is required when basic_auth is enabled")
+		}
+		if c.Server.BasicAuth.Password != "" && c.Server.BasicAuth.PasswordHash != "" {
+			return fmt.Errorf("cannot specify both basic_auth.password and basic_auth.password_hash")
+		}
+	}
+
+	// Validate client certificate config
+	if c.Server.TLS.ClientCAFile == "" && (c.Server.TLS.RequireClientCert || len(c.Server.TLS.ClientCertDirs) > 0) {
+		return fmt.Errorf("tls.client_ca_file is required for client certificate authentication")
+	}
+
+	// Validate JWT auth config
+	if c.Server.JWTAuth.Enabled {
+		if c.Server.JWTAuth.Secret == "" && c.Server.JWTAuth.JWKSURL == "" {
+			return fmt.Errorf("either jwt_auth.secret or jwt_auth.jwks_url is required when jwt_auth is enabled")
+		}
+		if c.Server.JWTAuth.Secret != "" && c.Server.JWTAuth.JWKSURL != "" {
+			return fmt.Errorf("cannot specify both jwt_auth.secret and jwt_auth.jwks_url")
+		}
+	}
+
+	if err := c.Server.AdminConfirmation.validate(&c.Server); err != nil {
+		return err
+	}
+
+	// Validate rate limit config
+	if c.Server.RateLimit.Enabled {
+		if err := c.Server.RateLimit.Global.validate("rate_limit.global"); err != nil {
+			return err
+		}
+		if err := c.Server.RateLimit.PerClient.validate("rate_limit.per_client"); err != nil {
+			return err
+		}
+	}
+
+	if c.MaxWorkers < 0 {
+		return fmt.Errorf("max_workers must not be negative")
+	}
+
+	if err := c.Journal.validate(); err != nil {
+		return err
+	}
+
+	if err := c.ControlPlane.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Permissions.validate(); err != nil {
+		return err
+	}
+
+	if d := c.DiskSpace; d.MinFreeBytes < 0 || d.ShadowMinFreeBytes < 0 || d.IntervalSeconds < 0 {
+		return fmt.Errorf("disk_space.min_free_bytes, shadow_min_free_bytes and interval_seconds must not be negative")
+	}
+
+	if c.History.Enabled && c.History.Path == "" {
+		return fmt.Errorf("history.path is required when history is enabled")
+	}
+	if c.History.RetentionDays < 0 {
+		return fmt.Errorf("history.retention_days must not be negative")
+	}
+
+	if c.FileState.Enabled && c.FileState.Path == "" {
+		return fmt.Errorf("file_state.path is required when file_state is enabled")
+	}
+	if c.FileState.RetentionDays < 0 {
+		return fmt.Errorf("file_state.retention_days must not be negative")
+	}
+
+	if c.Capture.Enabled && c.Capture.Path == "" {
+		return fmt.Errorf("outbound_capture.path is required when outbound_capture is enabled")
+	}
+	if c.Capture.SampleRate < 0 || c.Capture.SampleRate > 1 {
+		return fmt.Errorf("outbound_capture.sample_rate must be between 0 and 1")
+	}
+	if c.Capture.MaxBodyBytes < 0 || c.Capture.MaxFiles < 0 {
+		return fmt.Errorf("outbound_capture.max_body_bytes and max_files must not be negative")
+	}
+
+	if c.Readiness.TimeoutMs < 0 {
+		return fmt.Errorf("readiness.timeout_ms must not be negative")
+	}
+	if c.Kubernetes.ReloadIntervalMs < -1 {
+		return fmt.Errorf("kubernetes reload_interval_ms must be -1 or greater")
+	}
+	if c.Kubernetes.TerminationGracePeriodSeconds < 0 || c.Kubernetes.DrainMarginSeconds < 0 {
+		return fmt.Errorf("kubernetes termination_grace_period_seconds and drain_margin_seconds must not be negative")
+	}
+	if c.Shutdown.DrainTimeoutSeconds < -1 {
+		return fmt.Errorf("shutdown.drain_timeout_seconds must be -1 (do not wait) or more")
+	}
+	if c.WatchProbe.IntervalMs < 0 || c.WatchProbe.ThresholdMs < 0 {
+		return fmt.Errorf("watch_probe.interval_ms and threshold_ms must not be negative")
+	}
+
+	if len(c.Directories) == 0 {
+		return fmt.Errorf("at least one directory must be configured")
+	}
+
+	queueStateFiles := make(map[string]bool)
+	dirNames := make(map[string]bool)
+	for i := range c.Directories {
+		dir := &c.Directories[i]
+		if err := dir.Validate(); err != nil {
+			return fmt.Errorf("directory[%d] (%s): %w", i, dir.Name, err)
+		}
+		if dirNames[dir.Name] {
+			return fmt.Errorf("directory[%d] (%s): duplicate directory name", i, dir.Name)
+		}
+		dirNames[dir.Name] = true
+		if dir.QueueStateFile != "" {
+			if queueStateFiles[filepath.Clean(dir.QueueStateFile)] {
+				return fmt.Errorf("directory[%d] (%s): queue_state_file is used by another directory", i, dir.Name)
+			}
+			queueStateFiles[filepath.Clean(dir.QueueStateFile)] = true
+		}
+		for _, name := range dir.Listeners {
+			if !listenerNames[name] {
+				return fmt.Errorf("directory[%d] (%s): unknown listener: %s", i, dir.Name, name)
+			}
+		}
+		if !c.Server.IsEnabled() {
+			// Without the REST API these directories would never receive a file
+			if dir.Watch.Mode == "none" {
+				return fmt.Errorf("directory[%d] (%s): watch mode none requires the server to be enabled", i, dir.Name)
+			}
+			if dir.Passthrough.Enabled {
+				return fmt.Errorf("directory[%d] (%s): passthrough requires the server to be enabled", i, dir.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a directory configuration
+func (d *DirectoryConfig) Validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if d.WatchPath == "" {
+		return fmt.Errorf("watch_path is required")
+	}
+
+	if d.MaxUploadBytes < 0 {
+		return fmt.Errorf("max_upload_bytes must not be negative")
+	}
+
+	if d.MaxDepth < 0 {
+		return fmt.Errorf("max_depth must not be negative")
+	}
+	if d.MaxDepth > 0 && !d.IsSubdirectoryUploadAllowed() {
+		return fmt.Errorf("max_depth cannot be set when allow_subdirectories is false")
+	}
+
+	if d.MaxWorkers < 0 {
+		return fmt.Errorf("max_workers must not be negative")
+	}
+
+	if d.QueueSize < 0 {
+		return fmt.Errorf("queue_size must not be negative")
+	}
+
+	if d.UploadDeadlineSeconds < 0 {
+		return fmt.Errorf("upload_deadline_seconds must not be negative")
+	}
+
+	if d.SLA.DeadlineSeconds < 0 {
+		return fmt.Errorf("sla.deadline_seconds must not be negative")
+	}
+	if d.SLA.Failover && len(d.Outbound.Failover.URLs) == 0 {
+		return fmt.Errorf("sla.failover requires outbound.failover.urls")
+	}
+
+	if err := d.Scan.validate(d); err != nil {
+		return err
+	}
+
+	if d.AdaptiveConcurrency.MinWorkers < 0 || d.AdaptiveConcurrency.TargetLatencyMs < 0 {
+		return fmt.Errorf("adaptive_concurrency.min_workers and target_latency_ms must not be negative")
+	}
+	if d.AdaptiveConcurrency.Enabled && d.AdaptiveConcurrency.GetMinWorkers() > d.GetMaxWorkers() {
+		return fmt.Errorf("adaptive_concurrency.min_workers must not exceed max_workers")
+	}
+
+	validOverflowPolicies := map[string]bool{
+		"":                 true,
+		OverflowDropNewest: true,
+		OverflowDropOldest: true,
+		OverflowBlock:      true,
+	}
+	if !validOverflowPolicies[d.QueueOverflow.Policy] {
+		return fmt.Errorf("invalid queue_overflow.policy: %s", d.QueueOverflow.Policy)
+	}
+	if d.QueueOverflow.BlockTimeoutMs < 0 {
+		return fmt.Errorf("queue_overflow.block_timeout_ms must not be negative")
+	}
+	// The state file in the watch path would be uploaded itself
+	if d.QueueStateFile != "" {
+		for _, root := range []string{d.WatchPath, d.GetIngestPath()} {
+			if rel, err := filepath.Rel(root, filepath.Dir(d.QueueStateFile)); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("queue_state_file must be outside watch_path and ingest_path")
+			}
+		}
+	}
+	// Batch archives and mirror spools in the watch path would be uploaded
+	if d.TempDir != "" {
+		for _, root := range []string{d.WatchPath, d.GetIngestPath()} {
+			if rel, err := filepath.Rel(root, d.TempDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("temp_dir must be outside watch_path and ingest_path")
+			}
+		}
+	}
+
+	switch d.OrderingKey {
+	case "", OrderingKeyDirectory, OrderingKeySubdirectory:
+	default:
+		return fmt.Errorf("invalid ordering_key: %s", d.OrderingKey)
+	}
+	if d.Ordered && d.QueueOverflow.Policy != "" && d.QueueOverflow.Policy != OverflowBlock {
+		return fmt.Errorf("queue_overflow.policy must be block when ordered is enabled (dropping files breaks the delivery order)")
+	}
+
+	validPriorities := map[string]bool{PriorityHigh: true, PriorityNormal: true, PriorityLow: true}
+	for i, rule := range d.Priorities {
+		if !validPriorities[rule.Priority] {
+			return fmt.Errorf("priorities[%d]: invalid priority: %s", i, rule.Priority)
+		}
+		if len(rule.Patterns) == 0 {
+			return fmt.Errorf("priorities[%d]: at least one pattern is required", i)
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("priorities[%d]: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	if d.Rotation.Enabled {
+		if err := validateRotation(d); err != nil {
+			return err
+		}
+	}
+	if d.Ordered && len(d.Priorities) > 0 {
+		return fmt.Errorf("priorities cannot be combined with ordered delivery")
+	}
+
+	for i, rule := range d.ContentRules {
+		if len(rule.Types) == 0 {
+			return fmt.Errorf("content_rules[%d]: at least one type is required", i)
+		}
+		for _, name := range rule.Types {
+			if !magic.Known(name) {
+				return fmt.Errorf("content_rules[%d]: unknown type %q (known: %s)", i, name, strings.Join(magic.Types, ", "))
+			}
+		}
+		switch rule.GetAction() {
+		case ContentActionIgnore:
+		case ContentActionRoute:
+			if rule.URL == "" {
+				return fmt.Errorf("content_rules[%d]: url is required to route files", i)
+			}
+			if _, err := template.New("url").Parse(rule.URL); err != nil {
+				return fmt.Errorf("content_rules[%d]: invalid url template: %w", i, err)
+			}
+		default:
+			return fmt.Errorf("content_rules[%d]: invalid action: %s", i, rule.Action)
+		}
+	}
+
+	for i, rule := range d.Routes {
+		for _, pattern := range rule.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("routes[%d]: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("routes[%d]: invalid regex: %w", i, err)
+		}
+		if rule.MinSizeBytes < 0 || rule.MaxSizeBytes < 0 {
+			return fmt.Errorf("routes[%d]: min_size_bytes and max_size_bytes must not be negative", i)
+		}
+		if rule.MaxSizeBytes > 0 && rule.MinSizeBytes > rule.MaxSizeBytes {
+			return fmt.Errorf("routes[%d]: min_size_bytes must not exceed max_size_bytes", i)
+		}
+		if rule.URL == "" && len(rule.Headers) == 0 && rule.Auth == nil {
+			return fmt.Errorf("routes[%d]: url, headers or auth is required", i)
+		}
+		if _, err := template.New("url").Parse(rule.URL); err != nil {
+			return fmt.Errorf("routes[%d]: invalid url template: %w", i, err)
+		}
+		if rule.Auth != nil && rule.Auth.Type == "aws_sigv4" {
+			if err := rule.Auth.validateSigV4(); err != nil {
+				return fmt.Errorf("routes[%d]: %w", i, err)
+			}
+		}
+	}
+
+	if err := d.Mirror.validate(); err != nil {
+		return err
+	}
+
+	if err := d.PostUpload.validate(d); err != nil {
+		return err
+	}
+
+	if err := d.Batch.validate(d); err != nil {
+		return err
+	}
+
+	if d.Passthrough.BufferBytes < 0 {
+		return fmt.Errorf("passthrough.buffer_bytes must not be negative")
+	}
+	if d.Passthrough.Enabled && d.Ordered {
+		return fmt.Errorf("passthrough cannot be combined with ordered delivery")
+	}
+	if err := strftime.Validate(d.Shadow.Path); err != nil {
+		return fmt.Errorf("invalid shadow.path: %w", err)
+	}
+	if l := d.Shadow.GetLayout(); l != ShadowLayoutFlat && l != ShadowLayoutRelative {
+		return fmt.Errorf("invalid shadow.layout: %s (flat or relative)", d.Shadow.Layout)
+	}
+	if c := d.Shadow.GetCompression(); c != ShadowCompressionNone && c != ShadowCompressionGzip {
+		return fmt.Errorf("invalid shadow.compression: %s (none or gzip)", d.Shadow.Compression)
+	}
+	for _, p := range d.Shadow.RestorePaths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("shadow.restore_paths entries must be absolute: %s", p)
+		}
+	}
+
+	if d.Passthrough.Enabled && d.Shadow.Enabled {
+		return fmt.Errorf("passthrough cannot be combined with shadow copies")
+	}
+	if d.Passthrough.Enabled && d.Outbound.Verify.Enabled {
+		return fmt.Errorf("passthrough cannot be combined with outbound.verify")
+	}
+	if d.Passthrough.Enabled && d.Outbound.Auth.SignsPayload() {
+		return fmt.Errorf("passthrough cannot be combined with aws_sigv4 for services other than s3")
+	}
+
+	// If ingest_path is not specified, it defaults to watch_path, so no validation needed
+	// (The above check is not needed as the condition is always false)
+
+	// Validate watch mode
+	validModes := map[string]bool{
+		"event_only":               true,
+		"polling_only":             true,
+		"hybrid_ultra_low_latency": true,
+		"tail":                     true,
+		"none":                     true,
+	}
+	if !validModes[d.Watch.Mode] {
+		return fmt.Errorf("invalid watch mode: %s", d.Watch.Mode)
+	}
+	if d.Watch.Mode == "tail" {
+		if err := validateTail(d); err != nil {
+			return err
+		}
+	}
+	if d.Watch.CloseWrite {
+		if d.Watch.Mode != "hybrid_ultra_low_latency" {
+			return fmt.Errorf("watch.close_write requires watch mode hybrid_ultra_low_latency")
+		}
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("watch.close_write is only supported on Linux")
+		}
+	}
+	if d.Watch.Mode == "none" && d.GetIngestPath() != d.WatchPath {
+		return fmt.Errorf("watch mode none delivers REST uploads directly and cannot use a separate ingest_path")
+	}
+
+	for _, pattern := range d.Include {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+	}
+
+	if d.MinSizeBytes < 0 || d.MaxSizeBytes < 0 {
+		return fmt.Errorf("min_size_bytes and max_size_bytes must not be negative")
+	}
+	if d.MaxSizeBytes > 0 && d.MaxSizeBytes < d.MinSizeBytes {
+		return fmt.Errorf("max_size_bytes must not be less than min_size_bytes")
+	}
+	if d.RejectedPath != "" {
+		if d.MinSizeBytes == 0 && d.MaxSizeBytes == 0 {
+			return fmt.Errorf("rejected_path requires min_size_bytes or max_size_bytes")
+		}
+		// Rejected files in the watch path would be picked up again
+		if rel, err := filepath.Rel(d.WatchPath, d.RejectedPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("rejected_path must be outside watch_path")
+		}
+	}
+
+	for _, ext := range d.BlockedExtensions {
+		if e := strings.TrimPrefix(ext, "."); e == "" || strings.ContainsAny(e, `./\*?[`) {
+			return fmt.Errorf("invalid blocked extension %q", ext)
+		}
+	}
+	if d.QuarantinePath != "" {
+		// Quarantined files in the watch or ingest path would be picked up again
+		for _, path := range []string{d.WatchPath, d.GetIngestPath()} {
+			if rel, err := filepath.Rel(path, d.QuarantinePath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("quarantine_path must be outside watch_path and ingest_path")
+			}
+		}
+	}
+	if err := d.QuarantineRetention.validate(d.GetQuarantinePath()); err != nil {
+		return err
+	}
+
+	if d.ReadOnly {
+		switch {
+		case d.AfterUpload != "" && d.AfterUpload != AfterUploadKeep:
+			return fmt.Errorf("read_only cannot be combined with after_upload: %s", d.AfterUpload)
+		case d.GetQuarantinePath() != "":
+			return fmt.Errorf("read_only cannot be combined with quarantine_path or rejected_path")
+		case d.Outbound.Versioning.Enabled && d.ReadOnlyState != (ReadOnlyStateConfig{}):
+			return fmt.Errorf("read_only_state cannot be combined with outbound.versioning, whose history is used instead")
+		}
+		switch d.ReadOnlyState.Detect {
+		case "", "size_mtime", "hash":
+		default:
+			return fmt.Errorf("invalid read_only_state.detect: %s (size_mtime or hash)", d.ReadOnlyState.Detect)
+		}
+	} else if d.ReadOnlyState != (ReadOnlyStateConfig{}) {
+		return fmt.Errorf("read_only_state requires read_only")
+	}
+	switch d.GetAfterUpload() {
+	case AfterUploadDelete:
+	case AfterUploadKeep:
+		if d.ReadOnly {
+			break
+		}
+		// Kept files are found again by scans and after restarts
+		if !d.Outbound.Versioning.Enabled {
+			return fmt.Errorf("after_upload: keep requires outbound.versioning, so kept files are not delivered again")
+		}
+	case AfterUploadMove:
+		if d.ProcessedPath == "" {
+			return fmt.Errorf("after_upload: move requires processed_path")
+		}
+		if err := strftime.Validate(d.ProcessedPath); err != nil {
+			return fmt.Errorf("invalid processed_path: %w", err)
+		}
+		// Processed files must not be picked up again: below a recursively
+		// watched path they would be, and the watched directory itself is
+		// where they came from
+		for _, path := range []string{d.WatchPath, d.GetIngestPath()} {
+			rel, err := filepath.Rel(path, strftime.StaticDir(d.ProcessedPath))
+			if filepath.Clean(d.ProcessedPath) == filepath.Clean(path) || d.Recursive && err == nil && (rel == "." || filepath.IsLocal(rel)) {
+				return fmt.Errorf("processed_path must not be watch_path or ingest_path, nor below them with recursive")
+			}
+		}
+	default:
+		return fmt.Errorf("invalid after_upload: %s (delete, keep or move)", d.AfterUpload)
+	}
+	if d.ProcessedPath != "" && d.GetAfterUpload() != AfterUploadMove {
+		return fmt.Errorf("processed_path requires after_upload: move")
+	}
+
+	// Validate stability config; REST uploads are complete once committed
+	if d.Watch.Mode != "none" {
+		if d.Stability.ConfirmationIntervalMs <= 0 {
+			return fmt.Errorf("confirmation_interval_ms must be positive")
+		}
+		if d.Stability.RequiredStableChecks <= 0 {
+			return fmt.Errorf("required_stable_checks must be positive")
+		}
+		if d.Stability.MaxWaitMs <= 0 {
+			return fmt.Errorf("max_wait_ms must be positive")
+		}
+		if d.Stability.DebounceMs < 0 {
+			return fmt.Errorf("debounce_ms cannot be negative")
+		}
+	}
+
+	// Validate outbound config
+	switch d.Outbound.GetType() {
+	case OutboundHTTP:
+		if d.Outbound.URL == "" {
+			return fmt.Errorf("outbound.url is required")
+		}
+	case OutboundFTP:
+		if err := d.validateFTP(); err != nil {
+			return err
+		}
+	case OutboundLocalDir:
+		if err := d.validateLocalDir(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid outbound.type: %s (http, ftp or local_dir)", d.Outbound.Type)
+	}
+	if _, err := template.New("url").Parse(d.Outbound.URL); err != nil {
+		return fmt.Errorf("invalid outbound.url template: %w", err)
+	}
+	if err := d.Outbound.Compression.validate(&d.Outbound); err != nil {
+		return err
+	}
+	if err := d.Outbound.Encryption.validate(&d.Outbound); err != nil {
+		return err
+	}
+	if d.Outbound.Connection.MinThroughputBytes < 0 {
+		return fmt.Errorf("outbound.connection.min_throughput_bytes must not be negative")
+	}
+	if d.Outbound.Connection.MinTimeoutSeconds < 0 {
+		return fmt.Errorf("outbound.connection.min_timeout_seconds must not be negative")
+	}
+	if d.Outbound.Connection.DNSRefreshSeconds < 0 {
+		return fmt.Errorf("outbound.connection.dns_refresh_seconds must not be negative")
+	}
+	if d.Outbound.Auth.Type == "aws_sigv4" {
+		if err := d.Outbound.Auth.validateSigV4(); err != nil {
+			return err
+		}
+	}
+	if (d.Outbound.TLS.CertFile == "") != (d.Outbound.TLS.KeyFile == "") {
+		return fmt.Errorf("outbound.tls.cert_file and outbound.tls.key_file must be set together")
+	}
+	switch d.Outbound.TLS.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("invalid outbound.tls.min_version: %s", d.Outbound.TLS.MinVersion)
+	}
+	if d.Outbound.TLS.SessionCacheSize < -1 {
+		return fmt.Errorf("outbound.tls.session_cache_size must be -1 (disabled) or more")
+	}
+	switch d.Outbound.Connection.IPFamily {
+	case "", "auto", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("invalid outbound.connection.ip_family: %s", d.Outbound.Connection.IPFamily)
+	}
+	if d.Outbound.StreamThresholdBytes < 0 {
+		return fmt.Errorf("outbound.stream_threshold_bytes must not be negative")
+	}
+	switch d.Outbound.Versioning.Detect {
+	case "", "size_mtime", "hash":
+	default:
+		return fmt.Errorf("invalid outbound.versioning.detect: %s", d.Outbound.Versioning.Detect)
+	}
+	switch d.Outbound.Method {
+	case "", "POST", "PUT":
+	default:
+		return fmt.Errorf("invalid outbound.method: %s (POST or PUT)", d.Outbound.Method)
+	}
+	switch d.Outbound.BodyFormat {
+	case "", BodyFormatMultipart, BodyFormatRaw:
+	default:
+		return fmt.Errorf("invalid outbound.body_format: %s", d.Outbound.BodyFormat)
+	}
+	for i, u := range d.Outbound.Failover.URLs {
+		if _, err := template.New("url").Parse(u); err != nil {
+			return fmt.Errorf("invalid outbound.failover.urls[%d] template: %w", i, err)
+		}
+	}
+	if d.Outbound.Failover.FailureThreshold < 0 || d.Outbound.Failover.CooldownSeconds < 0 {
+		return fmt.Errorf("outbound.failover.failure_threshold and cooldown_seconds must not be negative")
+	}
+	switch d.Outbound.Verify.Method {
+	case "", http.MethodHead, http.MethodGet:
+	default:
+		return fmt.Errorf("invalid outbound.verify.method: %s (HEAD or GET)", d.Outbound.Verify.Method)
+	}
+	if d.Outbound.Success.JSONValue != "" && d.Outbound.Success.JSONField == "" {
+		return fmt.Errorf("outbound.success.json_value requires json_field")
+	}
+	if _, err := regexp.Compile(d.Outbound.Success.BodyRegex); err != nil {
+		return fmt.Errorf("invalid outbound.success.body_regex: %w", err)
+	}
+	switch d.Outbound.RelativePath.Mode {
+	case "", RelativePathURL, RelativePathHeader:
+	case RelativePathField:
+		if d.Outbound.GetBodyFormat() != BodyFormatMultipart {
+			return fmt.Errorf("outbound.relative_path.mode field requires the multipart body format")
+		}
+	default:
+		return fmt.Errorf("invalid outbound.relative_path.mode: %s", d.Outbound.RelativePath.Mode)
+	}
+	switch d.Outbound.IdempotencyKey.Source {
+	case "", IdempotencySourceMetadata, IdempotencySourceContent:
+	default:
+		return fmt.Errorf("invalid outbound.idempotency_key.source: %s", d.Outbound.IdempotencyKey.Source)
+	}
+	if d.Outbound.Dedup.WindowSeconds < 0 {
+		return fmt.Errorf("outbound.dedup.window_seconds must not be negative")
+	}
+	if d.Outbound.Dedup.MaxEntries < 0 {
+		return fmt.Errorf("outbound.dedup.max_entries must not be negative")
+	}
+
+	return nil
+}
+
+// validate checks mirror settings
+func (m *MirrorConfig) validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	u, err := url.Parse(m.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("mirror.url must be an http or https URL")
+	}
+	switch m.Auth.Type {
+	case "", "none", "basic", "bearer":
+	default:
+		return fmt.Errorf("invalid mirror.auth.type: %s (none, basic or bearer)", m.Auth.Type)
+	}
+	if m.QueueSize < 0 {
+		return fmt.Errorf("mirror.queue_size must not be negative")
+	}
+	if (m.TLS.CertFile == "") != (m.TLS.KeyFile == "") {
+		return fmt.Errorf("mirror.tls.cert_file and mirror.tls.key_file must be set together")
+	}
+	return nil
+}
+
+// validate checks control plane settings
+func (p *ControlPlaneConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("control_plane.url must be an http or https URL")
+	}
+	// Pushed directories decide where files go and with which credentials
+	if u.Scheme == "http" && !p.AllowInsecure {
+		return fmt.Errorf("control_plane.url must use https unless control_plane.allow_insecure is set")
+	}
+	if (p.TLS.CertFile == "") != (p.TLS.KeyFile == "") {
+		return fmt.Errorf("control_plane.tls.cert_file and control_plane.tls.key_file must be set together")
+	}
+	return nil
+}
+
+// GetNodeID returns the name this node reports to the control plane
+func (p *ControlPlaneConfig) GetNodeID() string {
+	if p.NodeID != "" {
+		return p.NodeID
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// GetID returns the name identifying this instance
+func (i *InstanceConfig) GetID() string {
+	if i.ID != "" {
+		return i.ID
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// GetHeader returns the upload request header carrying the instance ID
+func (i *InstanceConfig) GetHeader() string {
+	if i.Header == "" {
+		return "X-Xferd-Instance"
+	}
+	return i.Header
+}
+
+// GetRetention returns how long transfers are kept in the history
+func (h *HistoryConfig) GetRetention() time.Duration {
+	days := h.RetentionDays
+	if days == 0 {
+		days = 90
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetRetention returns how long file lifecycle events are kept
+func (f *FileStateConfig) GetRetention() time.Duration {
+	days := f.RetentionDays
+	if days == 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Defaults for outbound capture
+const (
+	DefaultCaptureSampleRate   = 0.1
+	DefaultCaptureMaxBodyBytes = 64 << 10
+	DefaultCaptureMaxFiles     = 1000
+)
+
+// GetSampleRate returns the fraction of outbound requests recorded
+func (c *CaptureConfig) GetSampleRate() float64 {
+	if c.SampleRate == 0 {
+		return DefaultCaptureSampleRate
+	}
+	return c.SampleRate
+}
+
+// GetMaxBodyBytes returns how much of a response body is recorded
+func (c *CaptureConfig) GetMaxBodyBytes() int64 {
+	if c.MaxBodyBytes == 0 {
+		return DefaultCaptureMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// GetMaxFiles returns how many recordings are kept
+func (c *CaptureConfig) GetMaxFiles() int {
+	if c.MaxFiles == 0 {
+		return DefaultCaptureMaxFiles
+	}
+	return c.MaxFiles
+}
+
+// GetSessionCacheSize returns how many TLS sessions are kept for resumption,
+// 0 if resumption is disabled
+func (t *OutboundTLSConfig) GetSessionCacheSize() int {
+	switch {
+	case t.SessionCacheSize < 0:
+		return 0
+	case t.SessionCacheSize == 0:
+		return 64
+	}
+	return t.SessionCacheSize
+}
+
+// GetTimeout returns how long a /ready check may take
+func (r *ReadinessConfig) GetTimeout() time.Duration {
+	if r.TimeoutMs > 0 {
+		return time.Duration(r.TimeoutMs) * time.Millisecond
+	}
+	return 5 * time.Second
+}
+
+// GetDrainTimeout returns how long shutdown waits for queued files to be
+// delivered, 0 if it does not wait
+func (s *ShutdownConfig) GetDrainTimeout() time.Duration {
+	switch {
+	case s.DrainTimeoutSeconds < 0:
+		return 0
+	case s.DrainTimeoutSeconds == 0:
+		return 30 * time.Second
+	}
+	return time.Duration(s.DrainTimeoutSeconds) * time.Second
+}
+
+// serviceAccountNamespace holds the namespace of the pod's service account
+// token, mounted into every pod unless disabled
+var serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// GetPodName returns the name of the pod this instance runs in
+func (k *KubernetesConfig) GetPodName() string {
+	if k.PodName != "" {
+		return k.PodName
+	}
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// GetNamespace returns the namespace of the pod this instance runs in, or
+// "" if it is unknown
+func (k *KubernetesConfig) GetNamespace() string {
+	if k.Namespace != "" {
+		return k.Namespace
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	data, err := os.ReadFile(serviceAccountNamespace)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// GetReloadInterval returns the time between checks of the configuration
+// file for changes, 0 if it is not reloaded
+func (k *KubernetesConfig) GetReloadInterval() time.Duration {
+	switch {
+	case !k.Enabled || k.ReloadIntervalMs < 0:
+		return 0
+	case k.ReloadIntervalMs == 0:
+		return 10 * time.Second
+	}
+	return time.Duration(k.ReloadIntervalMs) * time.Millisecond
+}
+
+// DrainTimeout caps the shutdown drain timeout so that draining ends before
+// the pod is killed at the end of its termination grace period
+func (k *KubernetesConfig) DrainTimeout(shutdown time.Duration) time.Duration {
+	if !k.Enabled || shutdown == 0 {
+		return shutdown
+	}
+	grace := k.TerminationGracePeriodSeconds
+	if grace == 0 {
+		grace = 30
+	}
+	margin := k.DrainMarginSeconds
+	if margin == 0 {
+		margin = 5
+	}
+	return max(min(shutdown, time.Duration(grace-margin)*time.Second), 0)
+}
+
+// GetInterval returns the time between watch path probes
+func (p *WatchProbeConfig) GetInterval() time.Duration {
+	if p.IntervalMs > 0 {
+		return time.Duration(p.IntervalMs) * time.Millisecond
+	}
+	return 30 * time.Second
+}
+
+// GetThreshold returns the probe latency above which a watch path is unhealthy
+func (p *WatchProbeConfig) GetThreshold() time.Duration {
+	if p.ThresholdMs > 0 {
+		return time.Duration(p.ThresholdMs) * time.Millisecond
+	}
+	return 5 * time.Second
+}
+
+// WithDirectories returns a copy of the configuration with its directories
+// replaced, defaulted and validated
+func (c *Config) WithDirectories(dirs []DirectoryConfig) (*Config, error) {
+	next := *c
+	next.Directories = slices.Clone(dirs)
+	secrets := newSecretResolver(c.Secrets)
+	for i := range next.Directories {
+		if err := next.Directories[i].resolveSecrets(secrets); err != nil {
+			return nil, fmt.Errorf("failed to resolve secrets: directory[%d] (%s): %w", i, next.Directories[i].Name, err)
+		}
+	}
+	setDefaults(&next)
+	if err := next.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &next, nil
+}
+
+// validate checks journal export settings
+func (j *JournalConfig) validate() error {
+	if !j.Enabled {
+		return nil
+	}
+	switch j.Type {
+	case "", "http":
+	case "kafka_rest":
+		if j.Kafka.Topic == "" {
+			return fmt.Errorf("journal_export.kafka.topic is required for type kafka_rest")
+		}
+		if j.Template != "" {
+			return fmt.Errorf("journal_export.template cannot be used with type kafka_rest")
+		}
+	default:
+		return fmt.Errorf("unsupported journal_export.type: %s (http or kafka_rest)", j.Type)
+	}
+	u, err := url.Parse(j.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("journal_export.url must be an http or https URL")
+	}
+	if j.BatchSize < 0 || j.FlushIntervalMs < 0 || j.QueueSize < 0 || j.MaxRetries < 0 {
+		return fmt.Errorf("journal_export.batch_size, flush_interval_ms, queue_size and max_retries must not be negative")
+	}
+	for _, outcome := range j.Outcomes {
+		switch outcome {
+		case "detected", "delivered", "failed", "removed", "overdue", "quarantined":
+		default:
+			return fmt.Errorf("invalid journal_export.outcomes entry: %s (detected, delivered, failed, removed, overdue or quarantined)", outcome)
+		}
+	}
+	return nil
+}
+
+// GetBatchSize returns how many events are sent per request
+func (j *JournalConfig) GetBatchSize() int {
+	if j.BatchSize > 0 {
+		return j.BatchSize
+	}
+	return 100
+}
+
+// GetContentType returns the Content-Type of templated requests
+func (j *JournalConfig) GetContentType() string {
+	if j.ContentType == "" {
+		return "application/json"
+	}
+	return j.ContentType
+}
+
+// GetFlushInterval returns how long an event waits for a full batch
+func (j *JournalConfig) GetFlushInterval() time.Duration {
+	if j.FlushIntervalMs > 0 {
+		return time.Duration(j.FlushIntervalMs) * time.Millisecond
+	}
+	return time.Second
+}
+
+// GetQueueSize returns how many events may wait to be sent
+func (j *JournalConfig) GetQueueSize() int {
+	if j.QueueSize > 0 {
+		return j.QueueSize
+	}
+	return 10000
+}
+
+// GetMaxRetries returns how often a failed batch is retried
+func (j *JournalConfig) GetMaxRetries() int {
+	if j.MaxRetries > 0 {
+		return j.MaxRetries
+	}
+	return 5
+}
+
+// validateRotation checks the log rotation settings of a directory
+func validateRotation(d *DirectoryConfig) error {
+	r := d.Rotation
+	if len(r.Patterns) == 0 {
+		return fmt.Errorf("rotation.patterns is required")
+	}
+	for _, pattern := range r.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rotation: invalid pattern %q: %w", pattern, err)
+		}
+	}
+	switch r.GetActive() {
+	case RotationActiveIgnore, RotationActiveDeliver:
+	default:
+		return fmt.Errorf("invalid rotation.active: %s (must be ignore or deliver)", r.Active)
+	}
+	switch r.GetRotated() {
+	case RotationRotatedOnce, RotationRotatedIgnore:
+	default:
+		return fmt.Errorf("invalid rotation.rotated: %s (must be once or ignore)", r.Rotated)
+	}
+	if r.GetActive() == RotationActiveIgnore && r.GetRotated() == RotationRotatedIgnore {
+		return fmt.Errorf("rotation.active and rotation.rotated cannot both be ignore")
+	}
+	if d.Watch.Mode == "tail" && d.Watch.Tail.GetDeliver() == TailDeliverSegments {
+		return fmt.Errorf("rotation cannot be combined with tail segments, which follow rotated files by themselves")
+	}
+	return nil
+}
+
+// validateTail checks the tail mode settings of a directory
+func validateTail(d *DirectoryConfig) error {
+	tail := d.Watch.Tail
+	if tail.IntervalMs < 0 {
+		return fmt.Errorf("watch.tail.interval_ms must not be negative")
+	}
+	switch tail.GetDeliver() {
+	case TailDeliverSegments:
+		if tail.SpoolPath == "" {
+			return fmt.Errorf("watch.tail.spool_path is required to deliver segments")
+		}
+		// Segments in the watch path would be tailed themselves
+		if rel, err := filepath.Rel(d.WatchPath, tail.SpoolPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("watch.tail.spool_path must be outside watch_path")
+		}
+	case TailDeliverClose:
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("watch.tail.deliver close is only supported on Linux")
+		}
+	default:
+		return fmt.Errorf("invalid watch.tail.deliver: %s (must be segments or close)", tail.Deliver)
+	}
+	return nil
+}
+
+// validateFTP checks an ftp outbound, which supports none of the HTTP
+// request and two-phase delivery features
+func (d *DirectoryConfig) validateFTP() error {
+	o := &d.Outbound
+	if !strings.HasPrefix(o.URL, "ftp://") && !strings.HasPrefix(o.URL, "ftps://") {
+		return fmt.Errorf("outbound.url must be an ftp:// or ftps:// URL for type ftp")
+	}
+	if o.FTP.ExplicitTLS && strings.HasPrefix(o.URL, "ftps://") {
+		return fmt.Errorf("outbound.ftp.explicit_tls applies to ftp:// URLs only, ftps:// uses implicit TLS")
+	}
+	for _, u := range o.Failover.URLs {
+		if !strings.HasPrefix(u, "ftp://") && !strings.HasPrefix(u, "ftps://") {
+			return fmt.Errorf("outbound.failover.urls must be ftp:// or ftps:// URLs for type ftp")
+		}
+	}
+	switch o.Auth.Type {
+	case "", "none", "basic":
+	default:
+		return fmt.Errorf("outbound.auth.type must be none or basic for type ftp")
+	}
+	switch {
+	case o.Commit.Enabled, o.Verify.Enabled, o.PropagateDeletes:
+		return fmt.Errorf("outbound.commit, verify and propagate_deletes are not supported with ftp")
+	case o.RelativePath.Mode == RelativePathHeader, o.RelativePath.Mode == RelativePathField:
+		return fmt.Errorf("outbound.relative_path.mode must be url with ftp")
+	case d.Passthrough.Enabled:
+		return fmt.Errorf("passthrough is not supported with ftp")
+	}
+	return nil
+}
+
+// validateLocalDir checks a local_dir outbound, which supports none of the
+// HTTP delivery features
+func (d *DirectoryConfig) validateLocalDir() error {
+	if d.Outbound.Path == "" {
+		return fmt.Errorf("outbound.path is required for local_dir")
+	}
+	if err := strftime.Validate(d.Outbound.Path); err != nil {
+		return fmt.Errorf("invalid outbound.path: %w", err)
+	}
+	o := &d.Outbound
+	switch {
+	case o.Commit.Enabled, o.Verify.Enabled, len(o.Failover.URLs) > 0, o.PropagateDeletes:
+		return fmt.Errorf("outbound.commit, verify, failover and propagate_deletes are not supported with local_dir")
+	case d.Passthrough.Enabled, len(d.Routes) > 0:
+		return fmt.Errorf("passthrough and routes are not supported with local_dir")
+	}
+	for i, rule := range d.ContentRules {
+		if rule.GetAction() == ContentActionRoute {
+			return fmt.Errorf("content_rules[%d]: routing is not supported with local_dir", i)
+		}
+	}
+	return nil
+}
+
+// SignsPayload reports whether requests must be signed over a hash of their
+// body. SigV4 only accepts unsigned payloads for S3.
+func (a *AuthConfig) SignsPayload() bool {
+	return a.Type == "aws_sigv4" && a.Service != "s3"
+}
+
+// validateSigV4 checks AWS SigV4 auth settings
+func (a *AuthConfig) validateSigV4() error {
+	if a.Region == "" || a.Service == "" {
+		return fmt.Errorf("outbound.auth.region and outbound.auth.service are required for aws_sigv4")
+	}
+	switch a.CredentialSource {
+	case "", "env", "instance_profile":
+	case "static":
+		if a.AccessKeyID == "" || a.SecretAccessKey == "" {
+			return fmt.Errorf("outbound.auth.access_key_id and outbound.auth.secret_access_key are required for static credentials")
+		}
+	default:
+		return fmt.Errorf("invalid outbound.auth.credential_source: %s", a.CredentialSource)
+	}
+	return nil
+}
+
+// GetConfirmationInterval returns the stability confirmation interval
+func (s *StabilityConfig) GetConfirmationInterval() time.Duration {
+	return time.Duration(s.ConfirmationIntervalMs) * time.Millisecond
+}
+
+// GetActive returns how active files are handled
+func (r *RotationConfig) GetActive() string {
+	if r.Active == "" {
+		return RotationActiveIgnore
+	}
+	return r.Active
+}
+
+// GetRotated returns how rotated files are handled
+func (r *RotationConfig) GetRotated() string {
+	if r.Rotated == "" {
+		return RotationRotatedOnce
+	}
+	return r.Rotated
+}
+
+// GetDeliver returns how growing files are delivered in tail mode
+func (t *TailConfig) GetDeliver() string {
+	if t.Deliver == "" {
+		return TailDeliverSegments
+	}
+	return t.Deliver
+}
+
+// GetInterval returns the time between checks for appended data
+func (t *TailConfig) GetInterval() time.Duration {
+	if t.IntervalMs > 0 {
+		return time.Duration(t.IntervalMs) * time.Millisecond
+	}
+	return 10 * time.Second
+}
+
+// GetMaxWait returns the maximum wait time for stability
+func (s *StabilityConfig) GetMaxWait() time.Duration {
+	return time.Duration(s.MaxWaitMs) * time.Millisecond
+}
+
+// GetDebounce returns how long write events of a file are coalesced
+func (s *StabilityConfig) GetDebounce() time.Duration {
+	if s.DebounceMs <= 0 {
+		return s.GetConfirmationInterval()
+	}
+	return time.Duration(s.DebounceMs) * time.Millisecond
 }
 
-// BasicAuthConfig defines optional basic authentication
-type BasicAuthConfig struct {
-	Enabled      bool   `yaml:"enabled"`
-	Username     string `yaml:"username"`
-	Password     string `yaml:"password"`      // Plaintext password (not recommended for production)
-	PasswordHash string `yaml:"password_hash"` // Bcrypt hash of password (recommended)
+// GetBasePath returns the part of the shadow path above its first date
+// token, which holds every shadow copy
+func (s *ShadowConfig) GetBasePath() string {
+	return strftime.StaticDir(s.Path)
 }
 
-// TLSConfig defines TLS settings
-type TLSConfig struct {
-	Enabled  bool   `yaml:"enabled"`
-	CertFile string `yaml:"cert_file"`
-	KeyFile  string `yaml:"key_file"`
+// GetLayout returns how shadow copies are named
+func (s *ShadowConfig) GetLayout() string {
+	if s.Layout == "" {
+		return ShadowLayoutFlat
+	}
+	return s.Layout
 }
 
-// DirectoryConfig represents a single watched directory configuration
-type DirectoryConfig struct {
-	Name       string          `yaml:"name"`
-	WatchPath  string          `yaml:"watch_path"`
-	IngestPath string          `yaml:"ingest_path,omitempty"` // Optional: defaults to watch_path
-	Recursive  bool            `yaml:"recursive"`
-	Ignore     []string        `yaml:"ignore"`
-	Watch      WatchConfig     `yaml:"watch"`
-	Stability  StabilityConfig `yaml:"stability"`
-	Shadow     ShadowConfig    `yaml:"shadow"`
-	Outbound   OutboundConfig  `yaml:"outbound"`
+// GetCompression returns how shadow copies are compressed at rest
+func (s *ShadowConfig) GetCompression() string {
+	if s.Compression == "" {
+		return ShadowCompressionNone
+	}
+	return s.Compression
 }
 
-// WatchConfig defines watching behavior
-type WatchConfig struct {
-	Mode                 string              `yaml:"mode"`
-	StartupReconcileScan *bool               `yaml:"startup_reconcile_scan"`
-	ReconcileScan        ReconcileScanConfig `yaml:"reconcile_scan"`
+// GetRetentionDuration returns the shadow retention duration
+func (s *ShadowConfig) GetRetentionDuration() time.Duration {
+	return time.Duration(s.RetentionHours) * time.Hour
 }
 
-// ReconcileScanConfig defines periodic reconciliation
-type ReconcileScanConfig struct {
-	Enabled         bool `yaml:"enabled"`
-	IntervalSeconds int  `yaml:"interval_seconds"`
+// GetReconcileInterval returns the reconciliation scan interval
+func (r *ReconcileScanConfig) GetReconcileInterval() time.Duration {
+	return time.Duration(r.IntervalSeconds) * time.Second
 }
 
-// StabilityConfig defines file stability confirmation settings
-type StabilityConfig struct {
-	ConfirmationIntervalMs int `yaml:"confirmation_interval_ms"`
-	RequiredStableChecks   int `yaml:"required_stable_checks"`
-	MaxWaitMs              int `yaml:"max_wait_ms"`
+// GetDNSRefreshInterval returns the outbound DNS re-resolution interval
+func (c *ConnectionConfig) GetDNSRefreshInterval() time.Duration {
+	return time.Duration(c.DNSRefreshSeconds) * time.Second
 }
 
-// ShadowConfig defines shadow directory settings
-type ShadowConfig struct {
-	Enabled        bool   `yaml:"enabled"`
-	Path           string `yaml:"path"`
-	RetentionHours int    `yaml:"retention_hours"`
+// GetFallbackDelay returns the Happy Eyeballs fallback delay for dual-stack dialing.
+// Zero selects the Go default; a negative value disables the fallback.
+func (c *ConnectionConfig) GetFallbackDelay() time.Duration {
+	return time.Duration(c.FallbackDelayMs) * time.Millisecond
 }
 
-// OutboundConfig defines upload destination settings
-type OutboundConfig struct {
-	URL  string     `yaml:"url"`
-	Auth AuthConfig `yaml:"auth"`
+// validate checks that rate limit values are not negative
+func (r *RateLimitRule) validate(prefix string) error {
+	if r.RequestsPerSecond < 0 {
+		return fmt.Errorf("%s.requests_per_second must not be negative", prefix)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("%s.burst must not be negative", prefix)
+	}
+	if r.MaxConcurrentUploads < 0 {
+		return fmt.Errorf("%s.max_concurrent_uploads must not be negative", prefix)
+	}
+	return nil
 }
 
-// AuthConfig defines authentication settings
-type AuthConfig struct {
-	Type     string `yaml:"type"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Token    string `yaml:"token"`
+// GetBurst returns the token bucket size, defaulting to the per-second rate (at least 1)
+func (r *RateLimitRule) GetBurst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return max(1, int(math.Ceil(r.RequestsPerSecond)))
 }
 
-// Load reads and parses the configuration file
-func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// GetIDField returns the JSON response field holding the upload ID
+func (c *CommitConfig) GetIDField() string {
+	if c.IDField != "" {
+		return c.IDField
 	}
+	return "upload_id"
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+// DefaultStreamThresholdBytes is the file size above which uploads are streamed
+const DefaultStreamThresholdBytes = 1 << 20
+
+// GetStreamThreshold returns the size above which uploads are streamed
+func (o *OutboundConfig) GetStreamThreshold() int64 {
+	if o.StreamThresholdBytes > 0 {
+		return o.StreamThresholdBytes
 	}
+	return DefaultStreamThresholdBytes
+}
 
-	// Apply environment variable overrides
-	applyEnvOverrides(&cfg)
+// GetHeader returns the header carrying the idempotency key
+func (i *IdempotencyConfig) GetHeader() string {
+	if i.Header != "" {
+		return i.Header
+	}
+	return "Idempotency-Key"
+}
 
-	// Set defaults
-	setDefaults(&cfg)
+// GetSource returns what the idempotency key is derived from
+func (i *IdempotencyConfig) GetSource() string {
+	if i.Source == "" {
+		return IdempotencySourceMetadata
+	}
+	return i.Source
+}
 
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+// Default duplicate suppression settings
+const (
+	DefaultDedupWindow     = time.Hour
+	DefaultDedupMaxEntries = 10000
+)
+
+// GetWindow returns how long an uploaded hash suppresses duplicates
+func (d *DedupConfig) GetWindow() time.Duration {
+	if d.WindowSeconds > 0 {
+		return time.Duration(d.WindowSeconds) * time.Second
 	}
+	return DefaultDedupWindow
+}
 
-	return &cfg, nil
+// GetMaxEntries returns how many hashes are remembered
+func (d *DedupConfig) GetMaxEntries() int {
+	if d.MaxEntries > 0 {
+		return d.MaxEntries
+	}
+	return DefaultDedupMaxEntries
 }
 
-// Validate checks the configuration for errors
-func (c *Config) Validate() error {
-	if c.Server.Port <= 0 || c.Server.Port > 65535 {
-		return fmt.Errorf("invalid server port: %d", c.Server.Port)
+// DefaultPassthroughBufferBytes is the in-memory copy kept per passthrough upload
+const DefaultPassthroughBufferBytes = 8 << 20
+
+// GetBufferBytes returns how much of a passthrough upload is kept in memory
+func (p *PassthroughConfig) GetBufferBytes() int64 {
+	if p.BufferBytes > 0 {
+		return p.BufferBytes
 	}
+	return DefaultPassthroughBufferBytes
+}
 
-	if c.Server.TempDir == "" {
-		return fmt.Errorf("temp_dir is required")
+// GetDirectory returns the directory name to upload to on the secondary
+func (m *MirrorConfig) GetDirectory(name string) string {
+	if m.Directory != "" {
+		return m.Directory
 	}
+	return name
+}
 
-	// Validate basic auth config
-	if c.Server.BasicAuth.Enabled {
-		if c.Server.BasicAuth.Username == "" {
-			return fmt.Errorf("basic_auth.username is required when basic_auth is enabled")
-		}
-		if c.Server.BasicAuth.Password == "" && c.Server.BasicAuth.PasswordHash == "" {
-			return fmt.Errorf("either basic_auth.password or basic_auth.password_hash is required when basic_auth is enabled")
-		}
-		if c.Server.BasicAuth.Password != "" && c.Server.BasicAuth.PasswordHash != "" {
-			return fmt.Errorf("cannot specify both basic_auth.password and basic_auth.password_hash")
-		}
+// GetQueueSize returns how many files may wait to be mirrored
+func (m *MirrorConfig) GetQueueSize() int {
+	if m.QueueSize > 0 {
+		return m.QueueSize
 	}
+	return DefaultQueueSize
+}
 
-	if len(c.Directories) == 0 {
-		return fmt.Errorf("at least one directory must be configured")
+// GetUploadDeadline returns the hard per-file upload deadline, or 0 if disabled
+func (d *DirectoryConfig) GetUploadDeadline() time.Duration {
+	return time.Duration(d.UploadDeadlineSeconds) * time.Second
+}
+
+// DefaultSLADeadline is the longest time from detection to delivery
+const DefaultSLADeadline = time.Hour
+
+// GetDeadline returns the longest time from detection to delivery
+func (s *SLAConfig) GetDeadline() time.Duration {
+	if s.DeadlineSeconds > 0 {
+		return time.Duration(s.DeadlineSeconds) * time.Second
 	}
+	return DefaultSLADeadline
+}
 
-	for i := range c.Directories {
-		dir := &c.Directories[i]
-		if err := dir.Validate(); err != nil {
-			return fmt.Errorf("directory[%d] (%s): %w", i, dir.Name, err)
-		}
+// GetSpoolPath returns where copies wait to be mirrored
+func (m *MirrorConfig) GetSpoolPath(tempDir, name string) string {
+	if m.SpoolPath != "" {
+		return m.SpoolPath
 	}
+	return filepath.Join(tempDir, "mirror", name)
+}
 
-	return nil
+// GetMethod returns the HTTP method used for uploads
+func (o *OutboundConfig) GetMethod() string {
+	if o.Method == "" {
+		return "POST"
+	}
+	return o.Method
 }
 
-// Validate checks a directory configuration
-func (d *DirectoryConfig) Validate() error {
-	if d.Name == "" {
-		return fmt.Errorf("name is required")
+// GetFailureThreshold returns the consecutive failures that open a destination's circuit
+func (f *FailoverConfig) GetFailureThreshold() int {
+	if f.FailureThreshold > 0 {
+		return f.FailureThreshold
 	}
+	return 3
+}
 
-	if d.WatchPath == "" {
-		return fmt.Errorf("watch_path is required")
+// GetCooldown returns how long a failing destination is skipped
+func (f *FailoverConfig) GetCooldown() time.Duration {
+	if f.CooldownSeconds > 0 {
+		return time.Duration(f.CooldownSeconds) * time.Second
 	}
+	return time.Minute
+}
 
-	// If ingest_path is not specified, it defaults to watch_path, so no validation needed
-	// (The above check is not needed as the condition is always false)
+// GetMethod returns the HTTP method of verify requests
+func (v *VerifyConfig) GetMethod() string {
+	if v.Method == "" {
+		return http.MethodHead
+	}
+	return v.Method
+}
 
-	// Validate watch mode
-	validModes := map[string]bool{
-		"event_only":               true,
-		"polling_only":             true,
-		"hybrid_ultra_low_latency": true,
+// GetBodyFormat returns how the file is encoded in the upload request
+func (o *OutboundConfig) GetBodyFormat() string {
+	if o.BodyFormat == "" {
+		return BodyFormatMultipart
 	}
-	if !validModes[d.Watch.Mode] {
-		return fmt.Errorf("invalid watch mode: %s", d.Watch.Mode)
+	return o.BodyFormat
+}
+
+// GetFieldName returns the multipart form field holding the file
+func (o *OutboundConfig) GetFieldName() string {
+	if o.FieldName == "" {
+		return "file"
 	}
+	return o.FieldName
+}
+
+// GetName returns the header or form field carrying the relative path
+func (r *RelativePathConfig) GetName() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.Mode == RelativePathField:
+		return "relative_path"
+	default:
+		return "X-Relative-Path"
+	}
+}
 
-	// Validate stability config
-	if d.Stability.ConfirmationIntervalMs <= 0 {
-		return fmt.Errorf("confirmation_interval_ms must be positive")
+// DefaultMinTimeout is the request timeout floor with size-scaled timeouts
+const DefaultMinTimeout = 30 * time.Second
+
+// GetRequestTimeout returns the timeout for uploading size bytes, or 0 for
+// the fixed default when no minimum throughput is set or the size is unknown
+func (c *ConnectionConfig) GetRequestTimeout(size int64) time.Duration {
+	if c.MinThroughputBytes <= 0 || size < 0 {
+		return 0
 	}
-	if d.Stability.RequiredStableChecks <= 0 {
-		return fmt.Errorf("required_stable_checks must be positive")
+	floor := DefaultMinTimeout
+	if c.MinTimeoutSeconds > 0 {
+		floor = time.Duration(c.MinTimeoutSeconds) * time.Second
 	}
-	if d.Stability.MaxWaitMs <= 0 {
-		return fmt.Errorf("max_wait_ms must be positive")
+	return floor + time.Duration(float64(size)/float64(c.MinThroughputBytes)*float64(time.Second))
+}
+
+// GetFormat returns the access log format
+func (a *AccessLogConfig) GetFormat() string {
+	if a.Format == "" {
+		return "combined"
 	}
+	return a.Format
+}
 
-	// Validate outbound config
-	if d.Outbound.URL == "" {
-		return fmt.Errorf("outbound.url is required")
+// IsEnabled returns whether the REST API is served
+func (s *ServerConfig) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// IsEnabled returns whether stale temp files are cleaned up
+func (t *TempCleanupConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// GetMaxAge returns how long a staged file must be untouched to be stale
+func (t *TempCleanupConfig) GetMaxAge() time.Duration {
+	if t.MaxAgeMinutes <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(t.MaxAgeMinutes) * time.Minute
+}
+
+// GetInterval returns the time between scans for stale temp files
+func (t *TempCleanupConfig) GetInterval() time.Duration {
+	if t.IntervalMinutes <= 0 {
+		return time.Hour
 	}
+	return time.Duration(t.IntervalMinutes) * time.Minute
+}
+
+// IsSet returns whether any retention limit is configured
+func (r *RetentionConfig) IsSet() bool {
+	return r.MaxAgeHours > 0 || r.MaxBytes > 0
+}
+
+// GetMaxAge returns how long quarantined files are kept, or 0 for no limit
+func (r *RetentionConfig) GetMaxAge() time.Duration {
+	return time.Duration(r.MaxAgeHours) * time.Hour
+}
 
+// validate checks the limits of the quarantine directory at path
+func (r *RetentionConfig) validate(path string) error {
+	if r.MaxAgeHours < 0 || r.MaxBytes < 0 {
+		return fmt.Errorf("quarantine_retention.max_age_hours and max_bytes must not be negative")
+	}
+	if r.IsSet() && path == "" {
+		return fmt.Errorf("quarantine_retention requires quarantine_path")
+	}
 	return nil
 }
 
-// GetConfirmationInterval returns the stability confirmation interval
-func (s *StabilityConfig) GetConfirmationInterval() time.Duration {
-	return time.Duration(s.ConfirmationIntervalMs) * time.Millisecond
+// Requires returns whether requests to endpoint must be confirmed
+func (a *AdminConfirmationConfig) Requires(endpoint string) bool {
+	if !a.Enabled {
+		return false
+	}
+	return len(a.Endpoints) == 0 || slices.Contains(a.Endpoints, endpoint)
 }
 
-// GetMaxWait returns the maximum wait time for stability
-func (s *StabilityConfig) GetMaxWait() time.Duration {
-	return time.Duration(s.MaxWaitMs) * time.Millisecond
+// GetTTL returns how long a confirmation token may be used
+func (a *AdminConfirmationConfig) GetTTL() time.Duration {
+	if a.TTLSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(a.TTLSeconds) * time.Second
 }
 
-// GetRetentionDuration returns the shadow retention duration
-func (s *ShadowConfig) GetRetentionDuration() time.Duration {
-	return time.Duration(s.RetentionHours) * time.Hour
+// validate checks the confirmation settings against the server's authentication
+func (a *AdminConfirmationConfig) validate(s *ServerConfig) error {
+	if !a.Enabled {
+		return nil
+	}
+	for _, endpoint := range a.Endpoints {
+		switch endpoint {
+		case AdminEndpointDrain, AdminEndpointShadowRestore:
+		default:
+			return fmt.Errorf("invalid admin_confirmation endpoint: %s (drain or shadow_restore)", endpoint)
+		}
+	}
+	if a.TTLSeconds < 0 {
+		return fmt.Errorf("admin_confirmation.ttl_seconds must not be negative")
+	}
+	// Basic auth has a single user, so only JWTs and client certificates
+	// can tell two people apart
+	if a.SecondApprover && !s.JWTAuth.Enabled && s.TLS.ClientCAFile == "" {
+		return fmt.Errorf("admin_confirmation.second_approver requires jwt_auth or tls.client_ca_file")
+	}
+	return nil
 }
 
-// GetReconcileInterval returns the reconciliation scan interval
-func (r *ReconcileScanConfig) GetReconcileInterval() time.Duration {
-	return time.Duration(r.IntervalSeconds) * time.Second
+// GetListeners returns the configured listeners, defaulting to address/port
+func (s *ServerConfig) GetListeners() []ListenConfig {
+	if len(s.Listen) > 0 {
+		return s.Listen
+	}
+	return []ListenConfig{{Address: s.Address, Port: s.Port, Network: "tcp"}}
+}
+
+// GetNetwork returns the listen network, defaulting to dual-stack tcp
+func (l *ListenConfig) GetNetwork() string {
+	if l.Network == "" {
+		return "tcp"
+	}
+	return l.Network
 }
 
 // IsStartupReconcileScanEnabled returns whether startup reconciliation scan is enabled
@@ -225,6 +2781,20 @@ func (w *WatchConfig) IsStartupReconcileScanEnabled() bool {
 	return *w.StartupReconcileScan
 }
 
+// IsSubdirectoryUploadAllowed returns whether uploads may target subdirectories
+func (d *DirectoryConfig) IsSubdirectoryUploadAllowed() bool {
+	return d.AllowSubdirectories == nil || *d.AllowSubdirectories
+}
+
+// GetTempDir returns the directory's temp directory, defaulting to the
+// server's
+func (d *DirectoryConfig) GetTempDir(serverTempDir string) string {
+	if d.TempDir != "" {
+		return d.TempDir
+	}
+	return serverTempDir
+}
+
 // GetIngestPath returns the ingest path, defaulting to watch_path if not specified
 func (d *DirectoryConfig) GetIngestPath() string {
 	if d.IngestPath != "" {
@@ -233,6 +2803,176 @@ func (d *DirectoryConfig) GetIngestPath() string {
 	return d.WatchPath
 }
 
+// What happens to a source file once it was delivered
+const (
+	AfterUploadDelete = "delete"
+	AfterUploadKeep   = "keep"
+	AfterUploadMove   = "move"
+)
+
+// GetAfterUpload returns what happens to a source file once it was delivered.
+// Files in read_only directories are always kept.
+func (d *DirectoryConfig) GetAfterUpload() string {
+	if d.ReadOnly {
+		return AfterUploadKeep
+	}
+	if d.AfterUpload == "" {
+		return AfterUploadDelete
+	}
+	return d.AfterUpload
+}
+
+// GetQuarantinePath returns where files failing validation are moved, or ""
+// if they are left in place. rejected_path is used if quarantine_path is not set.
+func (d *DirectoryConfig) GetQuarantinePath() string {
+	if d.QuarantinePath != "" {
+		return d.QuarantinePath
+	}
+	return d.RejectedPath
+}
+
+// IsBlockedExtension reports whether path has one of blocked_extensions,
+// compared case-insensitively
+func (d *DirectoryConfig) IsBlockedExtension(path string) bool {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return false
+	}
+	for _, blocked := range d.BlockedExtensions {
+		if strings.EqualFold(strings.TrimPrefix(ext, "."), strings.TrimPrefix(blocked, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Default upload concurrency and queue capacity per directory
+const (
+	DefaultMaxWorkers = 4
+	DefaultQueueSize  = 100
+)
+
+// GetMinWorkers returns the lowest concurrency the adaptive limit may set
+func (a *AdaptiveConcurrencyConfig) GetMinWorkers() int {
+	if a.MinWorkers > 0 {
+		return a.MinWorkers
+	}
+	return 1
+}
+
+// GetTargetLatency returns the response time above which the destination is
+// considered congested, 0 if latency is not used
+func (a *AdaptiveConcurrencyConfig) GetTargetLatency() time.Duration {
+	return time.Duration(a.TargetLatencyMs) * time.Millisecond
+}
+
+// GetMaxWorkers returns the number of upload workers for the directory
+func (d *DirectoryConfig) GetMaxWorkers() int {
+	if d.MaxWorkers > 0 {
+		return d.MaxWorkers
+	}
+	return DefaultMaxWorkers
+}
+
+// GetQueueSize returns the upload queue capacity for the directory
+func (d *DirectoryConfig) GetQueueSize() int {
+	if d.QueueSize > 0 {
+		return d.QueueSize
+	}
+	return DefaultQueueSize
+}
+
+// Upload queue overflow policies
+const (
+	OverflowDropNewest = "drop_newest" // reject the file being enqueued
+	OverflowDropOldest = "drop_oldest" // evict the longest-waiting file to make room
+	OverflowBlock      = "block"       // wait for room, then drop the new file after the timeout
+)
+
+// Outbound types
+const (
+	OutboundHTTP     = "http"
+	OutboundFTP      = "ftp"
+	OutboundLocalDir = "local_dir"
+)
+
+// GetType returns how files are delivered
+func (o *OutboundConfig) GetType() string {
+	if o.Type == "" {
+		return OutboundHTTP
+	}
+	return o.Type
+}
+
+// Content rule actions
+const (
+	ContentActionRoute  = "route"
+	ContentActionIgnore = "ignore"
+)
+
+// GetAction returns what a content rule does with matching files
+func (r *ContentRule) GetAction() string {
+	if r.Action == "" {
+		return ContentActionRoute
+	}
+	return r.Action
+}
+
+// MatchContentRule returns the first rule matching a detected type, or nil
+func MatchContentRule(rules []ContentRule, detected string) *ContentRule {
+	if detected == "" {
+		return nil
+	}
+	for i := range rules {
+		if slices.Contains(rules[i].Types, detected) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Upload priority classes
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Ordering keys for ordered delivery
+const (
+	OrderingKeyDirectory    = "directory"    // one ordered stream for the whole directory
+	OrderingKeySubdirectory = "subdirectory" // one ordered stream per subdirectory
+)
+
+// GetQueueOverflow returns the queue overflow settings. Ordered directories
+// block by default because dropping a file would break the delivery order.
+func (d *DirectoryConfig) GetQueueOverflow() QueueOverflow {
+	overflow := d.QueueOverflow
+	if d.Ordered && overflow.Policy == "" {
+		overflow.Policy = OverflowBlock
+	}
+	return overflow
+}
+
+// DefaultQueueBlockTimeout is how long the block policy waits for queue space
+const DefaultQueueBlockTimeout = 30 * time.Second
+
+// GetPolicy returns the overflow policy, defaulting to drop_newest
+func (q *QueueOverflow) GetPolicy() string {
+	if q.Policy == "" {
+		return OverflowDropNewest
+	}
+	return q.Policy
+}
+
+// GetBlockTimeout returns how long the block policy waits for queue space
+func (q *QueueOverflow) GetBlockTimeout() time.Duration {
+	if q.BlockTimeoutMs > 0 {
+		return time.Duration(q.BlockTimeoutMs) * time.Millisecond
+	}
+	return DefaultQueueBlockTimeout
+}
+
 // setDefaults applies default values to the configuration
 func setDefaults(cfg *Config) {
 	for i := range cfg.Directories {
@@ -244,6 +2984,77 @@ func setDefaults(cfg *Config) {
 	}
 }
 
+// expandEnv replaces ${VAR} and ${VAR:-default} in every scalar of a parsed
+// document with the value of the environment variable VAR. Unquoted scalars
+// are resolved again afterwards, so ${PORT} can fill in a number.
+func expandEnv(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		value, err := interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value && node.Style == 0 {
+			node.Tag = ""
+		}
+		node.Value = value
+	}
+	for _, child := range node.Content {
+		if err := expandEnv(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interpolate expands the ${VAR} and ${VAR:-default} references in s. The
+// default is used if VAR is unset or empty; a VAR without one must be set.
+// $${ is kept as a literal ${.
+func interpolate(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i]) // drops one $ of $${
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s[i:])
+		}
+		expr := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDefault := strings.Cut(expr, ":-")
+		if !isEnvName(name) {
+			return "", fmt.Errorf("invalid environment variable reference ${%s}", expr)
+		}
+		value, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && value == "":
+			value = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(value)
+	}
+}
+
+// isEnvName reports whether name is a valid environment variable name
+func isEnvName(name string) bool {
+	for i, c := range name {
+		if c != '_' && !('A' <= c && c <= 'Z') && !('a' <= c && c <= 'z') && (i == 0 || !('0' <= c && c <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
 // applyEnvOverrides applies environment variable overrides to the config
 func applyEnvOverrides(cfg *Config) {
 	if port := os.Getenv("XFERD_PORT"); port != "" {
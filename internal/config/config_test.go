@@ -3,7 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadValidConfig(t *testing.T) {
@@ -802,3 +805,2127 @@ func TestDirectoryConfigValidationWithIngestPath(t *testing.T) {
 		})
 	}
 }
+
+// newValidConfig returns a minimal valid configuration for validation tests
+func newValidConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:    8080,
+			TempDir: "/tmp/test",
+		},
+		Directories: []DirectoryConfig{
+			{
+				Name:      "test",
+				WatchPath: "/tmp/test",
+				Watch: WatchConfig{
+					Mode: "hybrid_ultra_low_latency",
+				},
+				Stability: StabilityConfig{
+					ConfirmationIntervalMs: 100,
+					RequiredStableChecks:   2,
+					MaxWaitMs:              1500,
+				},
+				Outbound: OutboundConfig{
+					URL: "https://example.com/upload",
+				},
+			},
+		},
+	}
+}
+
+func TestValidateConnectionConfig(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Connection = ConnectionConfig{
+		WarmUp:            true,
+		DNSRefreshSeconds: 30,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid connection config, got: %v", err)
+	}
+
+	if got := cfg.Directories[0].Outbound.Connection.GetDNSRefreshInterval(); got != 30*time.Second {
+		t.Errorf("Expected DNS refresh interval 30s, got %v", got)
+	}
+
+	cfg.Directories[0].Outbound.Connection.DNSRefreshSeconds = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative dns_refresh_seconds")
+	}
+}
+
+func TestDirectoryTempDir(t *testing.T) {
+	cfg := newValidConfig()
+	d := &cfg.Directories[0]
+	if got := d.GetTempDir("/var/lib/xferd/temp"); got != "/var/lib/xferd/temp" {
+		t.Errorf("Expected server temp_dir by default, got %s", got)
+	}
+
+	d.TempDir = filepath.Join(filepath.Dir(d.WatchPath), "staging")
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid temp_dir, got: %v", err)
+	}
+	if got := d.GetTempDir("/var/lib/xferd/temp"); got != d.TempDir {
+		t.Errorf("Expected directory temp_dir %s, got %s", d.TempDir, got)
+	}
+
+	d.TempDir = filepath.Join(d.WatchPath, ".staging")
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for temp_dir inside watch_path")
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Permissions = PermissionsConfig{FileMode: "0640", DirMode: "0o750"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid permissions, got: %v", err)
+	}
+	if mode, _ := ParseMode(cfg.Permissions.DirMode); mode != 0o750 {
+		t.Errorf("Expected dir_mode 0750, got %o", mode)
+	}
+
+	for _, mode := range []string{"0648", "rw-r-----", "01777"} {
+		cfg.Permissions.FileMode = mode
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected validation error for file_mode %q", mode)
+		}
+	}
+
+	cfg.Permissions = PermissionsConfig{Owner: "ingest"}
+	err := cfg.Validate()
+	if runtime.GOOS == "linux" && err != nil {
+		t.Errorf("Expected owner to be valid on Linux, got: %v", err)
+	}
+	if runtime.GOOS != "linux" && err == nil {
+		t.Error("Expected owner to be rejected outside Linux")
+	}
+}
+
+func TestValidateListenConfig(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.Port = 0
+	cfg.Server.Listen = []ListenConfig{
+		{Address: "0.0.0.0", Port: 8080, Network: "tcp4"},
+		{Address: "::", Port: 8443, Network: "tcp6"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid listen config, got: %v", err)
+	}
+
+	listeners := cfg.Server.GetListeners()
+	if len(listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %d", len(listeners))
+	}
+
+	cfg.Server.Listen[1].Network = "udp"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid network")
+	}
+
+	cfg.Server.Listen[1].Network = "tcp6"
+	cfg.Server.Listen[1].Port = 70000
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid listener port")
+	}
+}
+
+func TestGetListenersDefault(t *testing.T) {
+	server := ServerConfig{Address: "127.0.0.1", Port: 9000}
+
+	listeners := server.GetListeners()
+	if len(listeners) != 1 {
+		t.Fatalf("Expected 1 listener, got %d", len(listeners))
+	}
+	if listeners[0].Address != "127.0.0.1" || listeners[0].Port != 9000 || listeners[0].GetNetwork() != "tcp" {
+		t.Errorf("Unexpected default listener: %+v", listeners[0])
+	}
+}
+
+func TestValidateIPFamily(t *testing.T) {
+	cfg := newValidConfig()
+	for _, family := range []string{"", "auto", "ipv4", "ipv6"} {
+		cfg.Directories[0].Outbound.Connection.IPFamily = family
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected ip_family %q to be valid, got: %v", family, err)
+		}
+	}
+
+	cfg.Directories[0].Outbound.Connection.IPFamily = "ipx"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid ip_family")
+	}
+}
+
+func TestValidateJWTAuth(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.JWTAuth = JWTAuthConfig{Enabled: true}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error when neither secret nor jwks_url is set")
+	}
+
+	cfg.Server.JWTAuth.Secret = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid HS256 config, got: %v", err)
+	}
+
+	cfg.Server.JWTAuth.JWKSURL = "https://idp.example.com/jwks.json"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error when both secret and jwks_url are set")
+	}
+}
+
+func TestValidateDirectoryListeners(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.Listen = []ListenConfig{
+		{Name: "partner-a", Address: "10.0.0.1", Port: 8443},
+		{Name: "partner-b", Address: "10.0.0.2", Port: 8443},
+	}
+	cfg.Directories[0].Listeners = []string{"partner-a"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid listener binding, got: %v", err)
+	}
+
+	cfg.Directories[0].Listeners = []string{"partner-c"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown listener")
+	}
+
+	cfg.Directories[0].Listeners = nil
+	cfg.Server.Listen[1].Name = "partner-a"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for duplicate listener name")
+	}
+}
+
+func TestValidateClientCertConfig(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.TLS = TLSConfig{Enabled: true, RequireClientCert: true}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for require_client_cert without client_ca_file")
+	}
+
+	cfg.Server.TLS = TLSConfig{Enabled: true, ClientCertDirs: map[string][]string{"partner": {"test"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for client_cert_dirs without client_ca_file")
+	}
+
+	cfg.Server.TLS.ClientCAFile = "/etc/xferd/ca.pem"
+	cfg.Server.TLS.RequireClientCert = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid client certificate config, got: %v", err)
+	}
+}
+
+func TestValidateOutboundTLS(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.TLS = OutboundTLSConfig{CertFile: "/etc/xferd/client.pem"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for cert_file without key_file")
+	}
+
+	cfg.Directories[0].Outbound.TLS.KeyFile = "/etc/xferd/client.key"
+	cfg.Directories[0].Outbound.TLS.MinVersion = "1.3"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid outbound TLS config, got: %v", err)
+	}
+
+	cfg.Directories[0].Outbound.TLS.MinVersion = "1.0"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unsupported min_version")
+	}
+}
+
+func TestValidateSigV4Auth(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "aws_sigv4"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error without region and service")
+	}
+
+	cfg.Directories[0].Outbound.Auth.Region = "us-east-1"
+	cfg.Directories[0].Outbound.Auth.Service = "execute-api"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid aws_sigv4 config, got: %v", err)
+	}
+
+	cfg.Directories[0].Outbound.Auth.CredentialSource = "static"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for static credentials without keys")
+	}
+
+	cfg.Directories[0].Outbound.Auth.CredentialSource = "vault"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown credential source")
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.RateLimit = RateLimitConfig{
+		Enabled:   true,
+		Global:    RateLimitRule{RequestsPerSecond: 10, MaxConcurrentUploads: 5},
+		PerClient: RateLimitRule{RequestsPerSecond: 0.5},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid rate limit config, got: %v", err)
+	}
+
+	cfg.Server.RateLimit.PerClient.MaxConcurrentUploads = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative max_concurrent_uploads")
+	}
+}
+
+func TestRateLimitRuleGetBurst(t *testing.T) {
+	tests := []struct {
+		rule     RateLimitRule
+		expected int
+	}{
+		{RateLimitRule{RequestsPerSecond: 10}, 10},
+		{RateLimitRule{RequestsPerSecond: 2.5}, 3},
+		{RateLimitRule{RequestsPerSecond: 0.5}, 1},
+		{RateLimitRule{RequestsPerSecond: 10, Burst: 25}, 25},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.GetBurst(); got != tt.expected {
+			t.Errorf("GetBurst(%+v) = %d, expected %d", tt.rule, got, tt.expected)
+		}
+	}
+}
+
+func TestValidateVersioning(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Versioning = VersioningConfig{Enabled: true, Detect: "hash"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid versioning config, got: %v", err)
+	}
+
+	cfg.Directories[0].Outbound.Versioning.Detect = "checksum"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown versioning.detect")
+	}
+}
+
+func TestValidateMaxUploadBytes(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.MaxUploadBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative server max_upload_bytes")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].MaxUploadBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative directory max_upload_bytes")
+	}
+}
+
+func TestGetStreamThreshold(t *testing.T) {
+	outbound := OutboundConfig{}
+	if got := outbound.GetStreamThreshold(); got != DefaultStreamThresholdBytes {
+		t.Errorf("Expected default threshold %d, got %d", DefaultStreamThresholdBytes, got)
+	}
+
+	outbound.StreamThresholdBytes = 4096
+	if got := outbound.GetStreamThreshold(); got != 4096 {
+		t.Errorf("Expected threshold 4096, got %d", got)
+	}
+
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.StreamThresholdBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative stream_threshold_bytes")
+	}
+}
+
+func TestDirectoryWorkerDefaults(t *testing.T) {
+	dir := DirectoryConfig{}
+	if got := dir.GetMaxWorkers(); got != DefaultMaxWorkers {
+		t.Errorf("Expected default %d workers, got %d", DefaultMaxWorkers, got)
+	}
+	if got := dir.GetQueueSize(); got != DefaultQueueSize {
+		t.Errorf("Expected default queue size %d, got %d", DefaultQueueSize, got)
+	}
+
+	dir.MaxWorkers = 16
+	dir.QueueSize = 1000
+	if got := dir.GetMaxWorkers(); got != 16 {
+		t.Errorf("Expected 16 workers, got %d", got)
+	}
+	if got := dir.GetQueueSize(); got != 1000 {
+		t.Errorf("Expected queue size 1000, got %d", got)
+	}
+}
+
+func TestValidateWorkers(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.MaxWorkers = 8
+	cfg.Directories[0].MaxWorkers = 4
+	cfg.Directories[0].QueueSize = 500
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid worker config, got %v", err)
+	}
+
+	cfg = newValidConfig()
+	cfg.MaxWorkers = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative max_workers")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].MaxWorkers = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative directory max_workers")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].QueueSize = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative queue_size")
+	}
+}
+
+func TestValidateQueueOverflow(t *testing.T) {
+	for _, policy := range []string{"", OverflowDropNewest, OverflowDropOldest, OverflowBlock} {
+		cfg := newValidConfig()
+		cfg.Directories[0].QueueOverflow.Policy = policy
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected policy %q to be valid, got %v", policy, err)
+		}
+	}
+
+	cfg := newValidConfig()
+	cfg.Directories[0].QueueOverflow.Policy = "spill"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown overflow policy")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].QueueOverflow.BlockTimeoutMs = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative block_timeout_ms")
+	}
+
+	overflow := QueueOverflow{}
+	if got := overflow.GetPolicy(); got != OverflowDropNewest {
+		t.Errorf("Expected default policy %s, got %s", OverflowDropNewest, got)
+	}
+	if got := overflow.GetBlockTimeout(); got != DefaultQueueBlockTimeout {
+		t.Errorf("Expected default block timeout %v, got %v", DefaultQueueBlockTimeout, got)
+	}
+	overflow.BlockTimeoutMs = 500
+	if got := overflow.GetBlockTimeout(); got != 500*time.Millisecond {
+		t.Errorf("Expected block timeout 500ms, got %v", got)
+	}
+}
+
+func TestCommitGetIDField(t *testing.T) {
+	commit := CommitConfig{}
+	if got := commit.GetIDField(); got != "upload_id" {
+		t.Errorf("Expected default id field upload_id, got %s", got)
+	}
+
+	commit.IDField = "id"
+	if got := commit.GetIDField(); got != "id" {
+		t.Errorf("Expected id field id, got %s", got)
+	}
+}
+
+func TestValidateOrdered(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Ordered = true
+	cfg.Directories[0].OrderingKey = OrderingKeySubdirectory
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid ordered config, got %v", err)
+	}
+	overflow := cfg.Directories[0].GetQueueOverflow()
+	if got := overflow.GetPolicy(); got != OverflowBlock {
+		t.Errorf("Expected ordered directories to default to %s, got %s", OverflowBlock, got)
+	}
+
+	cfg.Directories[0].OrderingKey = "file"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown ordering_key")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Ordered = true
+	cfg.Directories[0].QueueOverflow.Policy = OverflowDropOldest
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for drop policy with ordered delivery")
+	}
+
+	cfg = newValidConfig()
+	overflow = cfg.Directories[0].GetQueueOverflow()
+	if got := overflow.GetPolicy(); got != OverflowDropNewest {
+		t.Errorf("Expected unordered directories to default to %s, got %s", OverflowDropNewest, got)
+	}
+}
+
+func TestValidatePriorities(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Priorities = []PriorityRule{
+		{Priority: PriorityHigh, Patterns: []string{"*.ctl"}},
+		{Priority: PriorityLow, Patterns: []string{"*.iso", "bulk/*"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid priorities, got %v", err)
+	}
+
+	cfg.Directories[0].Priorities[0].Priority = "urgent"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown priority")
+	}
+
+	cfg.Directories[0].Priorities[0] = PriorityRule{Priority: PriorityHigh}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for rule without patterns")
+	}
+
+	cfg.Directories[0].Priorities[0] = PriorityRule{Priority: PriorityHigh, Patterns: []string{"[.ctl"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for malformed pattern")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Ordered = true
+	cfg.Directories[0].Priorities = []PriorityRule{{Priority: PriorityHigh, Patterns: []string{"*.ctl"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for priorities with ordered delivery")
+	}
+}
+
+func TestValidateDedup(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Dedup = DedupConfig{Enabled: true, WindowSeconds: 600, MaxEntries: 100}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid dedup config, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.Dedup.WindowSeconds = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative window_seconds")
+	}
+
+	cfg.Directories[0].Outbound.Dedup.WindowSeconds = 0
+	cfg.Directories[0].Outbound.Dedup.MaxEntries = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative max_entries")
+	}
+
+	dedup := DedupConfig{}
+	if got := dedup.GetWindow(); got != DefaultDedupWindow {
+		t.Errorf("Expected default window %v, got %v", DefaultDedupWindow, got)
+	}
+	if got := dedup.GetMaxEntries(); got != DefaultDedupMaxEntries {
+		t.Errorf("Expected default max entries %d, got %d", DefaultDedupMaxEntries, got)
+	}
+}
+
+func TestValidateOutboundURLTemplate(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.URL = `https://example.com/{{.Directory}}/{{.Date "2006/01/02"}}/{filename}`
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid URL template, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.URL = "https://example.com/{{.Filename"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for malformed URL template")
+	}
+}
+
+func TestValidatePassthrough(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Passthrough.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid passthrough config, got %v", err)
+	}
+	if got := cfg.Directories[0].Passthrough.GetBufferBytes(); got != DefaultPassthroughBufferBytes {
+		t.Errorf("Expected default buffer %d, got %d", DefaultPassthroughBufferBytes, got)
+	}
+
+	cfg.Directories[0].Passthrough.BufferBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative buffer_bytes")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Passthrough.Enabled = true
+	cfg.Directories[0].Ordered = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for passthrough with ordered delivery")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Passthrough.Enabled = true
+	cfg.Directories[0].Shadow = ShadowConfig{Enabled: true, Path: "/tmp/shadow"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for passthrough with shadow copies")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Passthrough.Enabled = true
+	cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "aws_sigv4", Region: "eu-west-1", Service: "execute-api"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for passthrough with aws_sigv4 outside s3")
+	}
+	cfg.Directories[0].Outbound.Auth.Service = "s3"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected passthrough with aws_sigv4 for s3 to be valid, got %v", err)
+	}
+}
+
+func TestValidateUploadDeadline(t *testing.T) {
+	cfg := newValidConfig()
+	if got := cfg.Directories[0].GetUploadDeadline(); got != 0 {
+		t.Errorf("Expected upload deadline to be disabled by default, got %v", got)
+	}
+
+	cfg.Directories[0].UploadDeadlineSeconds = 600
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid upload deadline, got %v", err)
+	}
+	if got := cfg.Directories[0].GetUploadDeadline(); got != 10*time.Minute {
+		t.Errorf("Expected upload deadline 10m, got %v", got)
+	}
+
+	cfg.Directories[0].UploadDeadlineSeconds = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative upload_deadline_seconds")
+	}
+}
+
+func TestValidateCloseWrite(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Watch.CloseWrite = true
+	err := cfg.Validate()
+	if runtime.GOOS == "linux" && err != nil {
+		t.Errorf("Expected valid close_write on Linux, got %v", err)
+	}
+	if runtime.GOOS != "linux" && err == nil {
+		t.Error("Expected validation error for close_write outside Linux")
+	}
+
+	cfg.Directories[0].Watch.Mode = "polling_only"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for close_write outside hybrid mode")
+	}
+}
+
+func TestValidateInclude(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Include = []string{"*.xml", "reports/**/*.csv"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid include patterns, got %v", err)
+	}
+
+	cfg.Directories[0].Include = []string{"[invalid"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a malformed include pattern")
+	}
+}
+
+func TestValidateSizeRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int64
+		rejected string
+		wantErr  bool
+	}{
+		{"min only", 1, 0, "", false},
+		{"range with rejected path", 1, 1024, "/var/lib/xferd/rejected", false},
+		{"negative", -1, 0, "", true},
+		{"max below min", 100, 10, "", true},
+		{"rejected path without range", 0, 0, "/var/lib/xferd/rejected", true},
+		{"rejected path in watch path", 1, 0, "/tmp/test/rejected", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			dir := &cfg.Directories[0]
+			dir.MinSizeBytes, dir.MaxSizeBytes, dir.RejectedPath = tt.min, tt.max, tt.rejected
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateQuarantine(t *testing.T) {
+	tests := []struct {
+		name       string
+		blocked    []string
+		quarantine string
+		wantErr    bool
+	}{
+		{"blocked extensions", []string{".exe", "bat"}, "", false},
+		{"quarantine path", nil, "/var/lib/xferd/quarantine", false},
+		{"empty extension", []string{"."}, "", true},
+		{"multi-part extension", []string{".tar.gz"}, "", true},
+		{"pattern", []string{"*.exe"}, "", true},
+		{"quarantine in watch path", nil, "/tmp/test/quarantine", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			dir := &cfg.Directories[0]
+			dir.BlockedExtensions, dir.QuarantinePath = tt.blocked, tt.quarantine
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	dir := DirectoryConfig{BlockedExtensions: []string{".exe", "bat"}, RejectedPath: "/rejected"}
+	for path, blocked := range map[string]bool{"a/setup.EXE": true, "run.bat": true, "notes.txt": false, "exe": false} {
+		if got := dir.IsBlockedExtension(path); got != blocked {
+			t.Errorf("IsBlockedExtension(%q) = %v, expected %v", path, got, blocked)
+		}
+	}
+	if got := dir.GetQuarantinePath(); got != "/rejected" {
+		t.Errorf("Expected rejected_path as the quarantine path, got %s", got)
+	}
+	dir.QuarantinePath = "/quarantine"
+	if got := dir.GetQuarantinePath(); got != "/quarantine" {
+		t.Errorf("Expected quarantine_path to take precedence, got %s", got)
+	}
+}
+
+func TestValidateQuarantineRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"directory retention", func(c *Config) {
+			c.Directories[0].QuarantinePath = "/var/lib/xferd/quarantine"
+			c.Directories[0].QuarantineRetention = RetentionConfig{MaxAgeHours: 720, MaxBytes: 1 << 30}
+		}, false},
+		{"retention of rejected_path", func(c *Config) {
+			c.Directories[0].MaxSizeBytes, c.Directories[0].RejectedPath = 1024, "/var/lib/xferd/rejected"
+			c.Directories[0].QuarantineRetention = RetentionConfig{MaxAgeHours: 720}
+		}, false},
+		{"temp cleanup retention", func(c *Config) {
+			c.Server.TempCleanup.QuarantinePath = "/var/lib/xferd/abandoned"
+			c.Server.TempCleanup.QuarantineRetention = RetentionConfig{MaxBytes: 1 << 30}
+		}, false},
+		{"directory retention without quarantine", func(c *Config) {
+			c.Directories[0].QuarantineRetention = RetentionConfig{MaxAgeHours: 720}
+		}, true},
+		{"temp cleanup retention without quarantine", func(c *Config) {
+			c.Server.TempCleanup.QuarantineRetention = RetentionConfig{MaxAgeHours: 720}
+		}, true},
+		{"negative max_bytes", func(c *Config) {
+			c.Directories[0].QuarantinePath = "/var/lib/xferd/quarantine"
+			c.Directories[0].QuarantineRetention = RetentionConfig{MaxBytes: -1}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			tt.modify(cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateAdminConfirmation(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*ServerConfig)
+		wantErr bool
+	}{
+		{"all endpoints", func(s *ServerConfig) {
+			s.AdminConfirmation = AdminConfirmationConfig{Enabled: true}
+		}, false},
+		{"listed endpoints", func(s *ServerConfig) {
+			s.AdminConfirmation = AdminConfirmationConfig{Enabled: true, Endpoints: []string{"drain", "shadow_restore"}}
+		}, false},
+		{"unknown endpoint", func(s *ServerConfig) {
+			s.AdminConfirmation = AdminConfirmationConfig{Enabled: true, Endpoints: []string{"purge"}}
+		}, true},
+		{"negative ttl", func(s *ServerConfig) {
+			s.AdminConfirmation = AdminConfirmationConfig{Enabled: true, TTLSeconds: -1}
+		}, true},
+		{"second approver with jwt", func(s *ServerConfig) {
+			s.JWTAuth = JWTAuthConfig{Enabled: true, Secret: "secret"}
+			s.AdminConfirmation = AdminConfirmationConfig{Enabled: true, SecondApprover: true}
+		}, false},
+		{"second approver with basic auth only", func(s *ServerConfig) {
+			s.BasicAuth = BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"}
+			s.AdminConfirmation = AdminConfirmationConfig{Enabled: true, SecondApprover: true}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			tt.modify(&cfg.Server)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	a := AdminConfirmationConfig{Enabled: true, Endpoints: []string{AdminEndpointShadowRestore}}
+	if a.Requires(AdminEndpointDrain) || !a.Requires(AdminEndpointShadowRestore) {
+		t.Error("Expected only the listed endpoint to require confirmation")
+	}
+	if got := a.GetTTL(); got != 5*time.Minute {
+		t.Errorf("Expected default ttl of 5m, got %v", got)
+	}
+}
+
+func TestValidateAfterUpload(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		processed  string
+		recursive  bool
+		versioning bool
+		wantErr    bool
+	}{
+		{"default", "", "", false, false, false},
+		{"delete", AfterUploadDelete, "", false, false, false},
+		{"keep with versioning", AfterUploadKeep, "", false, true, false},
+		{"keep without versioning", AfterUploadKeep, "", false, false, true},
+		{"move", AfterUploadMove, "/var/lib/xferd/processed/%Y/%m/%d", false, false, false},
+		{"move without path", AfterUploadMove, "", false, false, true},
+		{"move with invalid token", AfterUploadMove, "/processed/%Q", false, false, true},
+		{"move below watch path", AfterUploadMove, "/tmp/test/processed/%Y", false, false, false},
+		{"move below recursive watch path", AfterUploadMove, "/tmp/test/processed/%Y", true, false, true},
+		{"move into watch path", AfterUploadMove, "/tmp/test", false, false, true},
+		{"path without move", AfterUploadDelete, "/processed", false, false, true},
+		{"unknown action", "archive", "", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			dir := &cfg.Directories[0]
+			dir.AfterUpload, dir.ProcessedPath, dir.Recursive = tt.action, tt.processed, tt.recursive
+			dir.Outbound.Versioning.Enabled = tt.versioning
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateReadOnly(t *testing.T) {
+	cfg := newValidConfig()
+	dir := &cfg.Directories[0]
+	dir.ReadOnly = true
+	dir.ReadOnlyState = ReadOnlyStateConfig{Detect: "hash", StateFile: "/var/lib/xferd/delivered.json"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected read_only to be valid, got %v", err)
+	}
+	if got := dir.GetAfterUpload(); got != AfterUploadKeep {
+		t.Errorf("Expected read_only to keep files, got %s", got)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(d *DirectoryConfig)
+	}{
+		{"move", func(d *DirectoryConfig) { d.AfterUpload, d.ProcessedPath = AfterUploadMove, "/processed" }},
+		{"delete", func(d *DirectoryConfig) { d.AfterUpload = AfterUploadDelete }},
+		{"quarantine", func(d *DirectoryConfig) { d.QuarantinePath = "/var/lib/xferd/quarantine" }},
+		{"rejected path", func(d *DirectoryConfig) { d.RejectedPath = "/var/lib/xferd/rejected" }},
+		{"unknown detect", func(d *DirectoryConfig) { d.ReadOnlyState.Detect = "inode" }},
+		{"state with versioning", func(d *DirectoryConfig) { d.Outbound.Versioning.Enabled = true }},
+		{"batch", func(d *DirectoryConfig) { d.ReadOnlyState, d.Batch.Enabled = ReadOnlyStateConfig{}, true }},
+		{"state without read_only", func(d *DirectoryConfig) { d.ReadOnly = false }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Directories[0].ReadOnly = true
+			cfg.Directories[0].ReadOnlyState = ReadOnlyStateConfig{StateFile: "/var/lib/xferd/delivered.json"}
+			tt.modify(&cfg.Directories[0])
+			if err := cfg.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestValidateScan(t *testing.T) {
+	tests := []struct {
+		name       string
+		scan       ScanConfig
+		quarantine string
+		wantErr    bool
+	}{
+		{"disabled", ScanConfig{}, "", false},
+		{"clamd socket", ScanConfig{Enabled: true, Address: "/run/clamav/clamd.ctl"}, "/var/lib/xferd/quarantine", false},
+		{"icap", ScanConfig{Enabled: true, Type: ScanTypeICAP, URL: "icap://scanner:1344/avscan"}, "/var/lib/xferd/quarantine", false},
+		{"clamd without address", ScanConfig{Enabled: true}, "/var/lib/xferd/quarantine", true},
+		{"icap with http url", ScanConfig{Enabled: true, Type: ScanTypeICAP, URL: "http://scanner/avscan"}, "/var/lib/xferd/quarantine", true},
+		{"unknown type", ScanConfig{Enabled: true, Type: "sophos", Address: "x"}, "/var/lib/xferd/quarantine", true},
+		{"without quarantine", ScanConfig{Enabled: true, Address: "127.0.0.1:3310"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			dir := &cfg.Directories[0]
+			dir.Scan, dir.QuarantinePath = tt.scan, tt.quarantine
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePostUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    PostUploadConfig
+		wantErr bool
+	}{
+		{"disabled", PostUploadConfig{}, false},
+		{"command", PostUploadConfig{Enabled: true, Command: []string{"/usr/local/bin/track", "--delivered"}}, false},
+		{"webhook", PostUploadConfig{Enabled: true, URL: "https://tracking.example.com/deliveries"}, false},
+		{"command and webhook", PostUploadConfig{Enabled: true, Command: []string{"track"}, URL: "http://tracking/"}, false},
+		{"neither", PostUploadConfig{Enabled: true}, true},
+		{"empty program", PostUploadConfig{Enabled: true, Command: []string{""}}, true},
+		{"non-http url", PostUploadConfig{Enabled: true, URL: "ftp://tracking/"}, true},
+		{"negative retries", PostUploadConfig{MaxRetries: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Directories[0].PostUpload = tt.hook
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	hook := PostUploadConfig{}
+	if hook.GetTimeout() != 30*time.Second || hook.GetMaxRetries() != 2 {
+		t.Errorf("Expected defaults of 30s and 2 retries, got %v and %d", hook.GetTimeout(), hook.GetMaxRetries())
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression CompressionConfig
+		verify      bool
+		wantErr     bool
+	}{
+		{"disabled", CompressionConfig{Mode: "brotli"}, false, false},
+		{"defaults", CompressionConfig{Enabled: true}, true, false},
+		{"suffix", CompressionConfig{Enabled: true, Mode: CompressionSuffix, Level: 9, ExcludeTypes: []string{"image/*"}}, false, false},
+		{"suffix with verify", CompressionConfig{Enabled: true, Mode: CompressionSuffix}, true, true},
+		{"unknown mode", CompressionConfig{Enabled: true, Mode: "brotli"}, false, true},
+		{"level too high", CompressionConfig{Enabled: true, Level: 10}, false, true},
+		{"not a mime type", CompressionConfig{Enabled: true, ExcludeTypes: []string{"png"}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Directories[0].Outbound.Compression = tt.compression
+			cfg.Directories[0].Outbound.Verify.Enabled = tt.verify
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	c := CompressionConfig{}
+	for mimeType, want := range map[string]bool{
+		"text/plain; charset=utf-8": false,
+		"application/json":          false,
+		"image/png":                 true,
+		"application/zip":           true,
+	} {
+		if got := c.Excludes(mimeType); got != want {
+			t.Errorf("Excludes(%q) = %v, want %v", mimeType, got, want)
+		}
+	}
+	c.ExcludeTypes = []string{}
+	if c.Excludes("image/png") {
+		t.Error("Expected an empty exclude_types to compress every type")
+	}
+}
+
+func TestValidateBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(d *DirectoryConfig)
+		wantErr bool
+	}{
+		{"defaults", func(d *DirectoryConfig) {}, false},
+		{"zip", func(d *DirectoryConfig) { d.Batch.Format = BatchFormatZip }, false},
+		{"unknown format", func(d *DirectoryConfig) { d.Batch.Format = "rar" }, true},
+		{"negative window", func(d *DirectoryConfig) { d.Batch.WindowMs = -1 }, true},
+		{"ordered", func(d *DirectoryConfig) { d.Ordered = true }, true},
+		{"versioning", func(d *DirectoryConfig) { d.Outbound.Versioning.Enabled = true }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			dir := &cfg.Directories[0]
+			dir.Batch.Enabled = true
+			tt.modify(dir)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	b := BatchConfig{}
+	if b.GetFormat() != BatchFormatTar || b.GetMaxFiles() != 1000 || b.GetMaxBytes() != 64<<20 || b.GetWindow() != 10*time.Second {
+		t.Errorf("Unexpected defaults: %s, %d, %d, %v", b.GetFormat(), b.GetMaxFiles(), b.GetMaxBytes(), b.GetWindow())
+	}
+}
+
+func TestValidateEncryption(t *testing.T) {
+	const recipient = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	tests := []struct {
+		name       string
+		encryption EncryptionConfig
+		modify     func(o *OutboundConfig)
+		wantErr    bool
+	}{
+		{"disabled", EncryptionConfig{Type: "rsa"}, nil, false},
+		{"age", EncryptionConfig{Enabled: true, Recipients: []string{recipient}}, nil, false},
+		{"pgp", EncryptionConfig{Enabled: true, Type: EncryptionPGP, KeyFile: "/etc/xferd/partner.asc"}, nil, false},
+		{"age without recipients", EncryptionConfig{Enabled: true}, nil, true},
+		{"age with ssh key", EncryptionConfig{Enabled: true, Recipients: []string{"ssh-ed25519 AAAA"}}, nil, true},
+		{"pgp without key", EncryptionConfig{Enabled: true, Type: EncryptionPGP}, nil, true},
+		{"with verify", EncryptionConfig{Enabled: true, Recipients: []string{recipient}},
+			func(o *OutboundConfig) { o.Verify.Enabled = true }, true},
+		{"with header compression", EncryptionConfig{Enabled: true, Recipients: []string{recipient}},
+			func(o *OutboundConfig) { o.Compression.Enabled = true }, true},
+		{"with suffix compression", EncryptionConfig{Enabled: true, Recipients: []string{recipient}},
+			func(o *OutboundConfig) { o.Compression = CompressionConfig{Enabled: true, Mode: CompressionSuffix} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			outbound := &cfg.Directories[0].Outbound
+			outbound.Encryption = tt.encryption
+			if tt.modify != nil {
+				tt.modify(outbound)
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCapture(t *testing.T) {
+	cfg := newValidConfig()
+	if got := cfg.Capture.GetSampleRate(); got != DefaultCaptureSampleRate {
+		t.Errorf("Expected default sample rate %v, got %v", DefaultCaptureSampleRate, got)
+	}
+
+	cfg.Capture = CaptureConfig{Enabled: true}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for outbound_capture without a path")
+	}
+
+	cfg.Capture = CaptureConfig{Path: "/var/lib/xferd/capture", SampleRate: 1.5}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a sample rate above 1")
+	}
+
+	cfg.Capture.SampleRate = 0.5
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid outbound_capture, got %v", err)
+	}
+}
+
+func TestStabilityDebounce(t *testing.T) {
+	cfg := newValidConfig()
+	stability := &cfg.Directories[0].Stability
+	if got := stability.GetDebounce(); got != stability.GetConfirmationInterval() {
+		t.Errorf("Expected debounce to default to the confirmation interval, got %v", got)
+	}
+
+	stability.DebounceMs = 250
+	if got := stability.GetDebounce(); got != 250*time.Millisecond {
+		t.Errorf("Expected debounce of 250ms, got %v", got)
+	}
+
+	stability.DebounceMs = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative debounce_ms")
+	}
+}
+
+func TestValidateSLA(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].SLA = SLAConfig{Enabled: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid sla, got %v", err)
+	}
+	if got := cfg.Directories[0].SLA.GetDeadline(); got != DefaultSLADeadline {
+		t.Errorf("Expected default deadline %v, got %v", DefaultSLADeadline, got)
+	}
+
+	cfg.Directories[0].SLA.DeadlineSeconds = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative sla.deadline_seconds")
+	}
+
+	cfg.Directories[0].SLA = SLAConfig{Enabled: true, Failover: true}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for sla.failover without failover urls")
+	}
+	cfg.Directories[0].Outbound.Failover.URLs = []string{"https://dr.example.com/upload"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid sla failover, got %v", err)
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Mirror = MirrorConfig{Enabled: true, URL: "https://dr.example.com:8080", Auth: AuthConfig{Type: "bearer", Token: "t"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid mirror config, got %v", err)
+	}
+
+	cfg.Directories[0].Mirror.Auth.Type = "aws_sigv4"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unsupported mirror auth type")
+	}
+
+	cfg.Directories[0].Mirror.Auth.Type = ""
+	cfg.Directories[0].Mirror.URL = "dr.example.com"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for mirror URL without scheme")
+	}
+
+	mirror := MirrorConfig{}
+	if got := mirror.GetDirectory("invoices"); got != "invoices" {
+		t.Errorf("Expected mirror directory to default to invoices, got %s", got)
+	}
+	if got := mirror.GetSpoolPath("/tmp/xferd", "invoices"); got != filepath.Join("/tmp/xferd", "mirror", "invoices") {
+		t.Errorf("Unexpected default spool path %s", got)
+	}
+}
+
+func TestValidateIdempotencyKey(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.IdempotencyKey = IdempotencyConfig{Enabled: true, Source: IdempotencySourceContent}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid idempotency key config, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.IdempotencyKey.Source = "random"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown idempotency_key.source")
+	}
+
+	idempotency := IdempotencyConfig{}
+	if got := idempotency.GetHeader(); got != "Idempotency-Key" {
+		t.Errorf("Expected default header Idempotency-Key, got %s", got)
+	}
+	if got := idempotency.GetSource(); got != IdempotencySourceMetadata {
+		t.Errorf("Expected default source %s, got %s", IdempotencySourceMetadata, got)
+	}
+}
+
+func TestValidateOutboundRequestFormat(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Method = "PUT"
+	cfg.Directories[0].Outbound.BodyFormat = BodyFormatRaw
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid PUT/raw config, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.Method = "PATCH"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unsupported method")
+	}
+
+	cfg.Directories[0].Outbound.Method = ""
+	cfg.Directories[0].Outbound.BodyFormat = "json"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown body_format")
+	}
+
+	outbound := OutboundConfig{}
+	if outbound.GetMethod() != "POST" || outbound.GetBodyFormat() != BodyFormatMultipart || outbound.GetFieldName() != "file" {
+		t.Errorf("Unexpected defaults: %s %s %s", outbound.GetMethod(), outbound.GetBodyFormat(), outbound.GetFieldName())
+	}
+}
+
+func TestValidateContentRules(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].ContentRules = []ContentRule{
+		{Types: []string{"zip", "gzip"}, Action: ContentActionIgnore},
+		{Types: []string{"pdf"}, URL: "http://example.com/documents/{{.Filename}}"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid content rules, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		rule ContentRule
+	}{
+		{"no types", ContentRule{Action: ContentActionIgnore}},
+		{"unknown type", ContentRule{Types: []string{"docx"}, Action: ContentActionIgnore}},
+		{"route without url", ContentRule{Types: []string{"pdf"}}},
+		{"invalid url template", ContentRule{Types: []string{"pdf"}, URL: "http://example.com/{{.Missing"}},
+		{"invalid action", ContentRule{Types: []string{"pdf"}, Action: "delete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Directories[0].ContentRules = []ContentRule{tt.rule}
+			if err := cfg.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestMatchContentRule(t *testing.T) {
+	rules := []ContentRule{
+		{Types: []string{"zip"}, Action: ContentActionIgnore},
+		{Types: []string{"zip", "pdf"}, URL: "http://example.com/"},
+	}
+	if rule := MatchContentRule(rules, "zip"); rule != &rules[0] {
+		t.Error("Expected the first matching rule to win")
+	}
+	if rule := MatchContentRule(rules, "pdf"); rule != &rules[1] || rule.GetAction() != ContentActionRoute {
+		t.Error("Expected pdf to match the route rule")
+	}
+	if rule := MatchContentRule(rules, ""); rule != nil {
+		t.Error("Expected no match for undetected content")
+	}
+}
+
+func TestValidateRelativePath(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.RelativePath.Mode = RelativePathField
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid relative path config, got %v", err)
+	}
+	if got := cfg.Directories[0].Outbound.RelativePath.GetName(); got != "relative_path" {
+		t.Errorf("Expected default field name relative_path, got %s", got)
+	}
+
+	cfg.Directories[0].Outbound.BodyFormat = BodyFormatRaw
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for field mode with raw bodies")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Outbound.RelativePath.Mode = RelativePathHeader
+	if got := cfg.Directories[0].Outbound.RelativePath.GetName(); got != "X-Relative-Path" {
+		t.Errorf("Expected default header X-Relative-Path, got %s", got)
+	}
+	cfg.Directories[0].Outbound.RelativePath.Mode = "query"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid relative_path mode")
+	}
+}
+
+func TestGetRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		conn ConnectionConfig
+		size int64
+		want time.Duration
+	}{
+		{"disabled", ConnectionConfig{}, 1 << 30, 0},
+		{"unknown size", ConnectionConfig{MinThroughputBytes: 1 << 20}, -1, 0},
+		{"small file", ConnectionConfig{MinThroughputBytes: 1 << 20}, 1024, DefaultMinTimeout + time.Second/1024},
+		{"large file", ConnectionConfig{MinThroughputBytes: 1 << 20}, 10 << 30, DefaultMinTimeout + 10240*time.Second},
+		{"custom floor", ConnectionConfig{MinThroughputBytes: 1 << 20, MinTimeoutSeconds: 5}, 2 << 20, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conn.GetRequestTimeout(tt.size); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Connection.MinThroughputBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative min_throughput_bytes")
+	}
+}
+
+func TestValidateJournal(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Journal = JournalConfig{Enabled: true, URL: "https://collector.example.com/events"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid journal export config, got %v", err)
+	}
+	if cfg.Journal.GetBatchSize() != 100 || cfg.Journal.GetFlushInterval() != time.Second ||
+		cfg.Journal.GetQueueSize() != 10000 || cfg.Journal.GetMaxRetries() != 5 {
+		t.Error("Expected journal export defaults")
+	}
+
+	tests := []struct {
+		name    string
+		journal JournalConfig
+	}{
+		{"unsupported type", JournalConfig{Enabled: true, Type: "kafka", URL: "https://collector.example.com/"}},
+		{"missing url", JournalConfig{Enabled: true}},
+		{"negative batch size", JournalConfig{Enabled: true, URL: "https://collector.example.com/", BatchSize: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Journal = tt.journal
+			if err := cfg.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestValidateSuccess(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Success = SuccessConfig{JSONField: "status", JSONValue: "ok", BodyRegex: "stored", Header: "X-Stored"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid success checks, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.Success = SuccessConfig{JSONValue: "ok"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for json_value without json_field")
+	}
+
+	cfg.Directories[0].Outbound.Success = SuccessConfig{BodyRegex: "("}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid body_regex")
+	}
+}
+
+func TestValidateVerify(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Verify = VerifyConfig{Enabled: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid verify config, got %v", err)
+	}
+	if got := cfg.Directories[0].Outbound.Verify.GetMethod(); got != "HEAD" {
+		t.Errorf("Expected default method HEAD, got %s", got)
+	}
+
+	cfg.Directories[0].Outbound.Verify.Method = "POST"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for verify method POST")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Outbound.Verify = VerifyConfig{Enabled: true}
+	cfg.Directories[0].Passthrough.Enabled = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for verify with passthrough")
+	}
+}
+
+func TestWithDirectories(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.ControlPlane = ControlPlaneConfig{Enabled: true, URL: "https://control.example.com/config"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid control plane config, got %v", err)
+	}
+
+	dirs := []DirectoryConfig{newValidConfig().Directories[0]}
+	dirs[0].Name = "pushed"
+	next, err := cfg.WithDirectories(dirs)
+	if err != nil {
+		t.Fatalf("WithDirectories failed: %v", err)
+	}
+	if next.Directories[0].Name != "pushed" || cfg.Directories[0].Name == "pushed" {
+		t.Error("Expected directories replaced in a copy only")
+	}
+	if next.Directories[0].Watch.StartupReconcileScan == nil {
+		t.Error("Expected defaults applied to pushed directories")
+	}
+
+	if _, err := cfg.WithDirectories(nil); err == nil {
+		t.Error("Expected validation error for a configuration without directories")
+	}
+
+	cfg.ControlPlane.URL = "nats://control.example.com"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a non-HTTP control plane url")
+	}
+
+	cfg.ControlPlane.URL = "http://control.example.com/config"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a plain http control plane url")
+	}
+	cfg.ControlPlane.AllowInsecure = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected http control plane url with allow_insecure, got %v", err)
+	}
+}
+
+func TestCheckPushed(t *testing.T) {
+	plain := newValidConfig().Directories[0]
+	withCommand := plain
+	withCommand.PostUpload = PostUploadConfig{Enabled: true, Command: []string{"/bin/sh", "-c", "id"}}
+	withKeyFile := plain
+	withKeyFile.Outbound.Encryption = EncryptionConfig{Enabled: true, Type: EncryptionPGP, KeyFile: "/etc/shadow"}
+	withTokenFile := plain
+	withTokenFile.Routes = []RouteRule{{Auth: &AuthConfig{Type: "bearer", TokenFile: "/root/.ssh/id_ed25519"}}}
+	withVault := plain
+	withVault.Outbound.Auth = AuthConfig{Type: "bearer", Token: "vault:secret/data/xferd#token"}
+
+	tests := []struct {
+		name  string
+		cp    ControlPlaneConfig
+		dir   DirectoryConfig
+		field string // expected in the error, "" for none
+	}{
+		{"Plain", ControlPlaneConfig{}, plain, ""},
+		{"Command", ControlPlaneConfig{}, withCommand, "post_upload.command"},
+		{"CommandAllowed", ControlPlaneConfig{AllowCommands: true}, withCommand, ""},
+		{"KeyFile", ControlPlaneConfig{}, withKeyFile, "outbound.encryption.key_file"},
+		{"TokenFile", ControlPlaneConfig{}, withTokenFile, "routes[0].auth.token_file"},
+		{"Vault", ControlPlaneConfig{}, withVault, "outbound.auth.token"},
+		{"LocalFilesAllowed", ControlPlaneConfig{AllowLocalFiles: true}, withVault, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cp.CheckPushed([]DirectoryConfig{plain, tt.dir})
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Expected directory to be accepted, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "directory[1]") || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("Expected %s to be rejected, got %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestValidateFailover(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.Failover = FailoverConfig{URLs: []string{"https://dr.example.com/{{.Filename}}"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid failover config, got %v", err)
+	}
+	failover := cfg.Directories[0].Outbound.Failover
+	if failover.GetFailureThreshold() != 3 || failover.GetCooldown() != time.Minute {
+		t.Errorf("Expected defaults 3 and 1m, got %d and %v", failover.GetFailureThreshold(), failover.GetCooldown())
+	}
+
+	cfg.Directories[0].Outbound.Failover.URLs = []string{"https://dr.example.com/{{.Filename"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid failover url template")
+	}
+
+	cfg.Directories[0].Outbound.Failover = FailoverConfig{CooldownSeconds: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative cooldown_seconds")
+	}
+}
+
+func TestValidateAdaptiveConcurrency(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].AdaptiveConcurrency = AdaptiveConcurrencyConfig{Enabled: true, TargetLatencyMs: 500}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid adaptive concurrency config, got %v", err)
+	}
+	adaptive := cfg.Directories[0].AdaptiveConcurrency
+	if adaptive.GetMinWorkers() != 1 || adaptive.GetTargetLatency() != 500*time.Millisecond {
+		t.Errorf("Expected 1 and 500ms, got %d and %v", adaptive.GetMinWorkers(), adaptive.GetTargetLatency())
+	}
+
+	cfg.Directories[0].AdaptiveConcurrency.MinWorkers = DefaultMaxWorkers + 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for min_workers above max_workers")
+	}
+
+	cfg.Directories[0].AdaptiveConcurrency = AdaptiveConcurrencyConfig{TargetLatencyMs: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative target_latency_ms")
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Routes = []RouteRule{
+		{Patterns: []string{"*.pdf"}, URL: "https://docs.example.com/{{.Filename}}"},
+		{Regex: `\.csv$`, MaxSizeBytes: 1024, Headers: map[string]string{"X-Team": "finance"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid routes, got %v", err)
+	}
+
+	invalid := []RouteRule{
+		{Patterns: []string{"["}, URL: "https://example.com/"},
+		{Regex: "(", URL: "https://example.com/"},
+		{MinSizeBytes: 10, MaxSizeBytes: 5, URL: "https://example.com/"},
+		{MinSizeBytes: -1, URL: "https://example.com/"},
+		{Patterns: []string{"*.pdf"}},
+		{URL: "https://example.com/{{.Filename"},
+		{Patterns: []string{"*.pdf"}, Auth: &AuthConfig{Type: "aws_sigv4"}},
+	}
+	for i, rule := range invalid {
+		cfg.Directories[0].Routes = []RouteRule{rule}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected validation error for invalid route %d", i)
+		}
+	}
+}
+
+func TestValidateJournalOutcomes(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Journal = JournalConfig{Enabled: true, URL: "https://collector.example.com/events", Outcomes: []string{"failed", "removed"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid journal outcomes, got %v", err)
+	}
+	if got := cfg.Journal.GetContentType(); got != "application/json" {
+		t.Errorf("Expected default content type application/json, got %q", got)
+	}
+
+	cfg.Journal.Outcomes = []string{"succeeded"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown journal outcome")
+	}
+}
+
+func TestValidateJournalKafka(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Journal = JournalConfig{Enabled: true, Type: "kafka_rest", URL: "http://kafka-rest:8082",
+		Outcomes: []string{"detected", "delivered", "failed"}, Kafka: KafkaConfig{Topic: "xferd-events"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid kafka_rest journal export, got %v", err)
+	}
+
+	cfg.Journal.Kafka.Topic = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for kafka_rest without topic")
+	}
+
+	cfg.Journal.Kafka.Topic = "xferd-events"
+	cfg.Journal.Template = "{{.Path}}"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for kafka_rest with template")
+	}
+}
+
+func TestInstanceDefaults(t *testing.T) {
+	instance := InstanceConfig{Enabled: true}
+	hostname, _ := os.Hostname()
+	if got := instance.GetID(); got != hostname {
+		t.Errorf("Expected hostname %q as default ID, got %q", hostname, got)
+	}
+	if got := instance.GetHeader(); got != "X-Xferd-Instance" {
+		t.Errorf("Expected default header X-Xferd-Instance, got %q", got)
+	}
+
+	instance = InstanceConfig{ID: "edge-berlin", Header: "X-Site"}
+	if instance.GetID() != "edge-berlin" || instance.GetHeader() != "X-Site" {
+		t.Errorf("Expected configured ID and header, got %q and %q", instance.GetID(), instance.GetHeader())
+	}
+}
+
+func TestValidateLocalDir(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundLocalDir, Path: "/mnt/target"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid local_dir outbound, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.Path = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for local_dir without path")
+	}
+
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundLocalDir, Path: "/mnt/target", Commit: CommitConfig{Enabled: true}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for local_dir with commit")
+	}
+
+	cfg.Directories[0].Outbound = OutboundConfig{Type: "sftp", URL: "sftp://example.com"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown outbound type")
+	}
+}
+
+func TestValidateDateTokens(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Shadow = ShadowConfig{Enabled: true, Path: "/var/shadow/%Y/%m/%d", RetentionHours: 24}
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundLocalDir, Path: "/mnt/target/%Y-%m-%d"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid date tokens, got %v", err)
+	}
+	if got := cfg.Directories[0].Shadow.GetBasePath(); got != "/var/shadow" {
+		t.Errorf("Expected shadow base path /var/shadow, got %s", got)
+	}
+
+	cfg.Directories[0].Shadow.Path = "/var/shadow/%Q"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unsupported shadow.path token")
+	}
+
+	cfg.Directories[0].Shadow.Path = "/var/shadow"
+	cfg.Directories[0].Shadow.Layout = ShadowLayoutRelative
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected relative shadow layout to be valid, got %v", err)
+	}
+	cfg.Directories[0].Shadow.Layout = "nested"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown shadow.layout")
+	}
+	cfg.Directories[0].Shadow.Layout = ""
+	cfg.Directories[0].Shadow.RestorePaths = []string{"restore"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for relative shadow.restore_paths entry")
+	}
+	cfg.Directories[0].Shadow.RestorePaths = nil
+	cfg.Directories[0].Shadow.Compression = ShadowCompressionGzip
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected gzip shadow compression to be valid, got %v", err)
+	}
+	cfg.Directories[0].Shadow.Compression = "zstd"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unsupported shadow.compression")
+	}
+	cfg.Directories[0].Shadow.Compression = ""
+
+	cfg.Directories[0].Shadow.Layout = ""
+	cfg.Directories[0].Outbound.Path = "/mnt/target/%"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for incomplete outbound.path token")
+	}
+}
+
+func TestValidateFTP(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundFTP, URL: "ftp://ftp.example.com/incoming/"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid ftp outbound, got %v", err)
+	}
+
+	cfg.Directories[0].Outbound.URL = "https://ftp.example.com/incoming/"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for ftp outbound with http url")
+	}
+
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundFTP, URL: "ftps://ftp.example.com/", FTP: FTPConfig{ExplicitTLS: true}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for explicit_tls with ftps url")
+	}
+
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundFTP, URL: "ftp://ftp.example.com/", Auth: AuthConfig{Type: "bearer", Token: "t"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for ftp with bearer auth")
+	}
+
+	cfg.Directories[0].Outbound = OutboundConfig{Type: OutboundFTP, URL: "ftp://ftp.example.com/", Commit: CommitConfig{Enabled: true}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for ftp with commit")
+	}
+}
+
+func TestValidateWatchProbe(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.WatchProbe = WatchProbeConfig{Enabled: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid watch probe config, got %v", err)
+	}
+	if cfg.WatchProbe.GetInterval() != 30*time.Second || cfg.WatchProbe.GetThreshold() != 5*time.Second {
+		t.Error("Expected watch probe defaults")
+	}
+
+	cfg.WatchProbe.ThresholdMs = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative threshold_ms")
+	}
+}
+
+func TestValidateSubdirectoryPolicy(t *testing.T) {
+	cfg := newValidConfig()
+	if !cfg.Directories[0].IsSubdirectoryUploadAllowed() {
+		t.Error("Expected subdirectory uploads to be allowed by default")
+	}
+
+	cfg.Directories[0].MaxDepth = 2
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid max_depth, got %v", err)
+	}
+
+	disallowed := false
+	cfg.Directories[0].AllowSubdirectories = &disallowed
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for max_depth with allow_subdirectories false")
+	}
+
+	cfg.Directories[0].AllowSubdirectories = nil
+	cfg.Directories[0].MaxDepth = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative max_depth")
+	}
+}
+
+func TestValidateAccessLog(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Server.AccessLog = AccessLogConfig{Enabled: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid access log config, got %v", err)
+	}
+	if got := cfg.Server.AccessLog.GetFormat(); got != "combined" {
+		t.Errorf("Expected combined format by default, got %q", got)
+	}
+
+	cfg.Server.AccessLog.Format = "common"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown access log format")
+	}
+}
+
+func TestValidateHistory(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.History = HistoryConfig{Enabled: true, Path: "/var/lib/xferd/history.jsonl"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid history config, got %v", err)
+	}
+	if got := cfg.History.GetRetention(); got != 90*24*time.Hour {
+		t.Errorf("Expected 90 days retention by default, got %v", got)
+	}
+
+	cfg.History.Path = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for history without path")
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	var r ReadinessConfig
+	if got := r.GetTimeout(); got != 5*time.Second {
+		t.Errorf("Expected default timeout 5s, got %v", got)
+	}
+
+	cfg := newValidConfig()
+	cfg.Readiness.TimeoutMs = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative readiness timeout")
+	}
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	tests := []struct {
+		seconds  int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{-1, 0},
+		{120, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		shutdown := ShutdownConfig{DrainTimeoutSeconds: tt.seconds}
+		if got := shutdown.GetDrainTimeout(); got != tt.expected {
+			t.Errorf("GetDrainTimeout() with %d = %v, expected %v", tt.seconds, got, tt.expected)
+		}
+	}
+
+	cfg := newValidConfig()
+	cfg.Shutdown.DrainTimeoutSeconds = -2
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for drain_timeout_seconds below -1")
+	}
+}
+
+func TestOutboundTLSSessionCacheSize(t *testing.T) {
+	tests := []struct {
+		size     int
+		expected int
+	}{
+		{0, 64},
+		{-1, 0},
+		{256, 256},
+	}
+	for _, tt := range tests {
+		tlsCfg := OutboundTLSConfig{SessionCacheSize: tt.size}
+		if got := tlsCfg.GetSessionCacheSize(); got != tt.expected {
+			t.Errorf("GetSessionCacheSize() with %d = %d, expected %d", tt.size, got, tt.expected)
+		}
+	}
+
+	cfg := newValidConfig()
+	cfg.Directories[0].Outbound.TLS.SessionCacheSize = -2
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for session_cache_size below -1")
+	}
+}
+
+func TestValidateTailMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		tail    TailConfig
+		wantErr bool
+	}{
+		{"segments", TailConfig{SpoolPath: "/var/spool/xferd/logs"}, false},
+		{"segments without spool path", TailConfig{}, true},
+		{"spool path inside watch path", TailConfig{SpoolPath: "/tmp/watch/spool"}, true},
+		{"negative interval", TailConfig{SpoolPath: "/var/spool/xferd/logs", IntervalMs: -1}, true},
+		{"invalid deliver", TailConfig{Deliver: "lines"}, true},
+		{"close", TailConfig{Deliver: TailDeliverClose}, runtime.GOOS != "linux"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Directories[0].WatchPath = "/tmp/watch"
+			cfg.Directories[0].Watch.Mode = "tail"
+			cfg.Directories[0].Watch.Tail = tt.tail
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		rotation RotationConfig
+		mode     string
+		wantErr  bool
+	}{
+		{"defaults", RotationConfig{Enabled: true, Patterns: []string{"*.log"}}, "", false},
+		{"disabled without patterns", RotationConfig{}, "", false},
+		{"no patterns", RotationConfig{Enabled: true}, "", true},
+		{"invalid pattern", RotationConfig{Enabled: true, Patterns: []string{"[.log"}}, "", true},
+		{"invalid active", RotationConfig{Enabled: true, Patterns: []string{"*.log"}, Active: "skip"}, "", true},
+		{"invalid rotated", RotationConfig{Enabled: true, Patterns: []string{"*.log"}, Rotated: "twice"}, "", true},
+		{"both ignored", RotationConfig{Enabled: true, Patterns: []string{"*.log"}, Rotated: RotationRotatedIgnore}, "", true},
+		{"tail segments", RotationConfig{Enabled: true, Patterns: []string{"*.log"}}, "tail", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newValidConfig()
+			cfg.Directories[0].Rotation = tt.rotation
+			if tt.mode != "" {
+				cfg.Directories[0].WatchPath = "/tmp/watch"
+				cfg.Directories[0].Watch.Mode = tt.mode
+				cfg.Directories[0].Watch.Tail.SpoolPath = "/var/spool/xferd/logs"
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerDisabled(t *testing.T) {
+	cfg := newValidConfig()
+	if !cfg.Server.IsEnabled() {
+		t.Error("Expected the server to be enabled by default")
+	}
+
+	// Watcher-only mode needs no port
+	disabled := false
+	cfg.Server.Enabled = &disabled
+	cfg.Server.Port = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid config without server, got %v", err)
+	}
+
+	cfg.Directories[0].Passthrough.Enabled = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for passthrough without server")
+	}
+
+	cfg.Directories[0].Passthrough.Enabled = false
+	cfg.Directories[0].Watch.Mode = "none"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for watch mode none without server")
+	}
+}
+
+func TestWatchModeNone(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].Watch.Mode = "none"
+	cfg.Directories[0].Stability = StabilityConfig{}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected watch mode none without stability settings to be valid, got %v", err)
+	}
+
+	cfg.Directories[0].IngestPath = "/tmp/ingest"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for watch mode none with a separate ingest_path")
+	}
+}
+
+func TestQueueStateFile(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.Directories[0].QueueStateFile = "/var/lib/xferd/test.queue.json"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid queue_state_file, got %v", err)
+	}
+
+	cfg.Directories[0].QueueStateFile = "/tmp/test/state/queue.json"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for queue_state_file inside watch_path")
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].QueueStateFile = "/var/lib/xferd/queue.json"
+	second := cfg.Directories[0]
+	second.Name = "second"
+	second.WatchPath = "/tmp/second"
+	cfg.Directories = append(cfg.Directories, second)
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a queue_state_file shared by two directories")
+	}
+}
+
+func TestValidateFileState(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.FileState = FileStateConfig{Enabled: true, Path: "/var/lib/xferd/files.jsonl"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid file state config, got %v", err)
+	}
+	if got := cfg.FileState.GetRetention(); got != 30*24*time.Hour {
+		t.Errorf("Expected 30 days retention by default, got %v", got)
+	}
+
+	cfg.FileState.Path = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for file state without path")
+	}
+}
+
+func TestKubernetesDrainTimeout(t *testing.T) {
+	tests := []struct {
+		kubernetes KubernetesConfig
+		shutdown   time.Duration
+		expected   time.Duration
+	}{
+		{KubernetesConfig{}, 2 * time.Minute, 2 * time.Minute},
+		{KubernetesConfig{Enabled: true}, 2 * time.Minute, 25 * time.Second},
+		{KubernetesConfig{Enabled: true}, 10 * time.Second, 10 * time.Second},
+		{KubernetesConfig{Enabled: true, TerminationGracePeriodSeconds: 120, DrainMarginSeconds: 10}, 5 * time.Minute, 110 * time.Second},
+		{KubernetesConfig{Enabled: true, TerminationGracePeriodSeconds: 3}, 30 * time.Second, 0},
+		{KubernetesConfig{Enabled: true}, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.kubernetes.DrainTimeout(tt.shutdown); got != tt.expected {
+			t.Errorf("DrainTimeout(%v) with %+v = %v, expected %v", tt.shutdown, tt.kubernetes, got, tt.expected)
+		}
+	}
+
+	cfg := newValidConfig()
+	cfg.Kubernetes.DrainMarginSeconds = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for negative drain_margin_seconds")
+	}
+}
+
+func TestKubernetesPodIdentity(t *testing.T) {
+	t.Setenv("POD_NAME", "xferd-7d9f")
+	t.Setenv("POD_NAMESPACE", "")
+	saved := serviceAccountNamespace
+	defer func() { serviceAccountNamespace = saved }()
+	serviceAccountNamespace = filepath.Join(t.TempDir(), "namespace")
+
+	k := KubernetesConfig{Enabled: true}
+	if got := k.GetPodName(); got != "xferd-7d9f" {
+		t.Errorf("GetPodName() = %q, expected POD_NAME", got)
+	}
+	if got := k.GetNamespace(); got != "" {
+		t.Errorf("GetNamespace() = %q, expected empty outside a pod", got)
+	}
+
+	if err := os.WriteFile(serviceAccountNamespace, []byte("ingest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := k.GetNamespace(); got != "ingest" {
+		t.Errorf("GetNamespace() = %q, expected the service account namespace", got)
+	}
+	t.Setenv("POD_NAMESPACE", "shipping")
+	if got := k.GetNamespace(); got != "shipping" {
+		t.Errorf("GetNamespace() = %q, expected POD_NAMESPACE", got)
+	}
+
+	k = KubernetesConfig{Enabled: true, PodName: "edge", Namespace: "prod"}
+	if k.GetPodName() != "edge" || k.GetNamespace() != "prod" {
+		t.Errorf("Configured pod identity not used: %s/%s", k.GetNamespace(), k.GetPodName())
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	// A ConfigMap volume holds its keys behind a ..data symlink
+	dir := t.TempDir()
+	data := filepath.Join(dir, "..2026_10_15")
+	if err := os.Mkdir(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Base(data), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ResolvePath(dir); err == nil {
+		t.Error("Expected an error for a directory without a configuration file")
+	}
+
+	if err := os.WriteFile(filepath.Join(data, "config.yaml"), []byte("directories: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ResolvePath(dir)
+	if err != nil {
+		t.Fatalf("ResolvePath() failed: %v", err)
+	}
+	if got != filepath.Join(dir, "config.yaml") {
+		t.Errorf("ResolvePath() = %s, expected config.yaml in the directory", got)
+	}
+
+	file := filepath.Join(dir, "other.yml")
+	if got, _ := ResolvePath(file); got != file {
+		t.Errorf("ResolvePath() = %s, expected a file path unchanged", got)
+	}
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("TEST_XFERD_WATCH", "/tmp/watched")
+	t.Setenv("TEST_XFERD_PORT", "9443")
+	t.Setenv("TEST_XFERD_TOKEN", "s3cr3t")
+	t.Setenv("TEST_XFERD_EMPTY", "")
+
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	content := `
+server:
+  port: ${TEST_XFERD_PORT}
+  temp_dir: ${TEST_XFERD_TEMP:-/tmp/xferd}
+directories:
+  - name: test
+    watch_path: ${TEST_XFERD_WATCH}
+    watch:
+      mode: ${TEST_XFERD_MODE:-polling_only}
+    stability:
+      confirmation_interval_ms: 100
+      required_stable_checks: 2
+      max_wait_ms: 1500
+    outbound:
+      url: "https://example.com/${TEST_XFERD_EMPTY:-upload}?literal=$${HOME}"
+      auth:
+        type: bearer
+        token: "${TEST_XFERD_TOKEN}"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.Port != 9443 {
+		t.Errorf("Expected port 9443, got %d", cfg.Server.Port)
+	}
+	if cfg.Server.TempDir != "/tmp/xferd" {
+		t.Errorf("Expected default temp dir, got %s", cfg.Server.TempDir)
+	}
+	dir := cfg.Directories[0]
+	if dir.WatchPath != "/tmp/watched" {
+		t.Errorf("Expected watch path from environment, got %s", dir.WatchPath)
+	}
+	if want := "https://example.com/upload?literal=${HOME}"; dir.Outbound.URL != want {
+		t.Errorf("Expected URL %s, got %s", want, dir.Outbound.URL)
+	}
+	if dir.Outbound.Auth.Token != "s3cr3t" {
+		t.Errorf("Expected token from environment, got %s", dir.Outbound.Auth.Token)
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	t.Setenv("TEST_XFERD_EMPTY", "")
+	for _, s := range []string{"${TEST_XFERD_UNSET_VARIABLE}", "${TEST_XFERD", "${}", "${1ABC}", "${A-B}"} {
+		if _, err := interpolate(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+	if v, err := interpolate("${TEST_XFERD_EMPTY}"); err != nil || v != "" {
+		t.Errorf("Expected set but empty variable to expand to empty, got %q, %v", v, err)
+	}
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir := t.TempDir()
+	confD := filepath.Join(dir, "conf.d")
+	if err := os.MkdirAll(confD, 0755); err != nil {
+		t.Fatal(err)
+	}
+	directory := func(name string) string {
+		return `
+  - name: ` + name + `
+    watch_path: /tmp/` + name + `
+    watch:
+      mode: polling_only
+    stability:
+      confirmation_interval_ms: 100
+      required_stable_checks: 2
+      max_wait_ms: 1500
+    outbound:
+      url: https://example.com/upload
+`
+	}
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configPath := filepath.Join(dir, "config.yml")
+	write(configPath, `
+include: conf.d/*.yml
+server:
+  port: 8080
+  temp_dir: /tmp/xferd
+directories:`+directory("main"))
+	write(filepath.Join(confD, "20-billing.yml"), "directories:"+directory("billing"))
+	write(filepath.Join(confD, "10-reports.yml"), "directories:"+directory("reports")+directory("exports"))
+	write(filepath.Join(confD, "notes.txt"), "not yaml: [")
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var names []string
+	for _, d := range cfg.Directories {
+		names = append(names, d.Name)
+	}
+	if want := "main reports exports billing"; strings.Join(names, " ") != want {
+		t.Errorf("Expected directories %s, got %v", want, names)
+	}
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 3 || files[0] != configPath {
+		t.Errorf("Expected config file and two fragments, got %v", files)
+	}
+
+	write(filepath.Join(confD, "30-duplicate.yml"), "directories:"+directory("billing"))
+	if _, err := Load(configPath); err == nil || !strings.Contains(err.Error(), "duplicate directory name") {
+		t.Errorf("Expected duplicate directory to be rejected, got %v", err)
+	}
+	write(filepath.Join(confD, "30-duplicate.yml"), "server:\n  port: 9090\n")
+	if _, err := Load(configPath); err == nil || !strings.Contains(err.Error(), "only directories") {
+		t.Errorf("Expected server settings in a fragment to be rejected, got %v", err)
+	}
+	if err := os.Remove(filepath.Join(confD, "30-duplicate.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	write(configPath, "include: [conf.d/*.yml, missing.yml]\nserver:\n  port: 8080\n  temp_dir: /tmp/xferd\n")
+	if _, err := Load(configPath); err == nil || !strings.Contains(err.Error(), "included file not found") {
+		t.Errorf("Expected missing included file to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// CheckPushed rejects directories pushed by the control plane that run
+// commands, or read local files or Vault secrets, unless allow_commands or
+// allow_local_files permit it. Whoever controls the control plane, or its
+// connection, could otherwise run commands on every node, or send local
+// files and secrets to a destination of their choice as credentials.
+func (p *ControlPlaneConfig) CheckPushed(dirs []DirectoryConfig) error {
+	for i := range dirs {
+		d := &dirs[i]
+		if len(d.PostUpload.Command) > 0 && !p.AllowCommands {
+			return fmt.Errorf("directory[%d] (%s): post_upload.command is not accepted from the control plane unless control_plane.allow_commands is set", i, d.Name)
+		}
+		if p.AllowLocalFiles {
+			continue
+		}
+		if field := localReference(reflect.ValueOf(*d), ""); field != "" {
+			return fmt.Errorf("directory[%d] (%s): %s refers to a local file or Vault secret, which is not accepted from the control plane unless control_plane.allow_local_files is set", i, d.Name, field)
+		}
+	}
+	return nil
+}
+
+// localReference returns the key of the first *_file setting or vault:
+// reference found in v, or "" if there is none. path is the key of v.
+func localReference(v reflect.Value, path string) string {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		return localReference(v.Elem(), path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			key := path
+			if name != "" && name != "-" {
+				key = strings.TrimPrefix(path+"."+name, ".")
+			}
+			field := v.Field(i)
+			if field.Kind() == reflect.String && strings.HasSuffix(name, "_file") && field.String() != "" {
+				return key
+			}
+			if found := localReference(field, key); found != "" {
+				return found
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if found := localReference(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); found != "" {
+				return found
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if found := localReference(iter.Value(), fmt.Sprintf("%s.%v", path, iter.Key())); found != "" {
+				return found
+			}
+		}
+	case reflect.String:
+		if strings.HasPrefix(v.String(), vaultPrefix) {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// vaultPrefix marks a secret read from Vault: vault:<path>#<key>, e.g.
+// vault:secret/data/xferd#password for the KV version 2 engine at secret/
+const vaultPrefix = "vault:"
+
+// secretResolver reads *_file secrets and vault: references. Vault secrets
+// are read once per path.
+type secretResolver struct {
+	vault  VaultConfig
+	client *http.Client
+	cache  map[string]map[string]any
+}
+
+func newSecretResolver(cfg SecretsConfig) *secretResolver {
+	return &secretResolver{vault: cfg.Vault, cache: make(map[string]map[string]any)}
+}
+
+// resolveSecrets replaces the secrets of the configuration that are given as
+// files or vault: references by their values
+func (c *Config) resolveSecrets() error {
+	r := newSecretResolver(c.Secrets)
+	b := &c.Server.BasicAuth
+	if err := r.resolve("server.basic_auth.password", &b.Password, b.PasswordFile); err != nil {
+		return err
+	}
+	if err := r.resolve("server.basic_auth.password_hash", &b.PasswordHash, b.PasswordHashFile); err != nil {
+		return err
+	}
+	if err := r.resolve("server.jwt_auth.secret", &c.Server.JWTAuth.Secret, ""); err != nil {
+		return err
+	}
+	if err := r.resolve("control_plane.token", &c.ControlPlane.Token, ""); err != nil {
+		return err
+	}
+	for i := range c.Directories {
+		if err := c.Directories[i].resolveSecrets(r); err != nil {
+			return fmt.Errorf("directory[%d] (%s): %w", i, c.Directories[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// resolveSecrets replaces the credentials of the directory's destinations
+func (d *DirectoryConfig) resolveSecrets(r *secretResolver) error {
+	if err := d.Outbound.Auth.resolveSecrets(r, "outbound.auth"); err != nil {
+		return err
+	}
+	if err := d.Mirror.Auth.resolveSecrets(r, "mirror.auth"); err != nil {
+		return err
+	}
+	for i, rule := range d.Routes {
+		if rule.Auth != nil {
+			if err := rule.Auth.resolveSecrets(r, fmt.Sprintf("routes[%d].auth", i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecrets replaces the credentials given as files or vault: references
+func (a *AuthConfig) resolveSecrets(r *secretResolver, name string) error {
+	if err := r.resolve(name+".password", &a.Password, a.PasswordFile); err != nil {
+		return err
+	}
+	if err := r.resolve(name+".token", &a.Token, a.TokenFile); err != nil {
+		return err
+	}
+	if err := r.resolve(name+".secret_access_key", &a.SecretAccessKey, ""); err != nil {
+		return err
+	}
+	return r.resolve(name+".session_token", &a.SessionToken, "")
+}
+
+// resolve sets *value to the content of file, without its trailing newline,
+// if file is set. A value of the form vault:<path>#<key> is then replaced
+// by the secret read from Vault.
+func (r *secretResolver) resolve(name string, value *string, file string) error {
+	if file != "" {
+		if *value != "" {
+			return fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+		}
+		data, err := os.ReadFile(file) // #nosec G304 -- file from the configuration
+		if err != nil {
+			return fmt.Errorf("failed to read %s_file: %w", name, err)
+		}
+		*value = strings.TrimRight(string(data), "\r\n")
+	}
+	ref, ok := strings.CutPrefix(*value, vaultPrefix)
+	if !ok {
+		return nil
+	}
+	secret, err := r.readVault(ref)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*value = secret
+	return nil
+}
+
+// readVault reads the key of a vault:<path>#<key> reference
+func (r *secretResolver) readVault(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("invalid Vault reference %q, expected vault:<path>#<key>", vaultPrefix+ref)
+	}
+	data, ok := r.cache[path]
+	if !ok {
+		var err error
+		if data, err = r.fetchVault(path); err != nil {
+			return "", fmt.Errorf("failed to read %s from Vault: %w", path, err)
+		}
+		r.cache[path] = data
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s in Vault has no string key %s", path, key)
+	}
+	return value, nil
+}
+
+// fetchVault reads a secret from the KV engine. Version 2 nests the secret
+// in data.data next to data.metadata; version 1 returns it in data.
+func (r *secretResolver) fetchVault(path string) (map[string]any, error) {
+	address := r.vault.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("secrets.vault.address or VAULT_ADDR is required")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if r.vault.TokenFile != "" {
+		data, err := os.ReadFile(r.vault.TokenFile) // #nosec G304 -- file from the configuration
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets.vault.token_file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("secrets.vault.token_file or VAULT_TOKEN is required")
+	}
+	if r.client == nil {
+		client, err := newVaultClient(r.vault)
+		if err != nil {
+			return nil, err
+		}
+		r.client = client
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.vault.GetTimeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(address, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if r.vault.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.vault.Namespace)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]any `json:"data"`
+		Errors []string       `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from Vault: %s", resp.StatusCode, strings.Join(body.Errors, "; "))
+	}
+	if inner, ok := body.Data["data"].(map[string]any); ok && body.Data["metadata"] != nil {
+		return inner, nil
+	}
+	return body.Data, nil
+}
+
+// newVaultClient returns the HTTP client for Vault, trusting ca_file if set
+func newVaultClient(cfg VaultConfig) (*http.Client, error) {
+	if cfg.CAFile == "" {
+		return &http.Client{}, nil
+	}
+	caPEM, err := os.ReadFile(cfg.CAFile) // #nosec G304 -- file from the configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets.vault.ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in secrets.vault.ca_file %s", cfg.CAFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(passwordFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("abc.def\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newValidConfig()
+	cfg.Server.BasicAuth = BasicAuthConfig{Enabled: true, Username: "admin", PasswordFile: passwordFile}
+	cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "bearer", TokenFile: tokenFile}
+	cfg.Directories[0].Routes = []RouteRule{{Auth: &AuthConfig{Type: "basic", Username: "u", PasswordFile: passwordFile}}}
+	if err := cfg.resolveSecrets(); err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	if cfg.Server.BasicAuth.Password != "s3cr3t" {
+		t.Errorf("Expected password from file, got %q", cfg.Server.BasicAuth.Password)
+	}
+	if got := cfg.Directories[0].Outbound.Auth.Token; got != "abc.def" {
+		t.Errorf("Expected token from file, got %q", got)
+	}
+	if got := cfg.Directories[0].Routes[0].Auth.Password; got != "s3cr3t" {
+		t.Errorf("Expected route password from file, got %q", got)
+	}
+
+	cfg = newValidConfig()
+	cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "basic", Password: "inline", PasswordFile: passwordFile}
+	if err := cfg.resolveSecrets(); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("Expected password and password_file to be rejected, got %v", err)
+	}
+
+	cfg = newValidConfig()
+	cfg.Server.BasicAuth = BasicAuthConfig{Enabled: true, Username: "admin", PasswordHashFile: filepath.Join(dir, "missing")}
+	if err := cfg.resolveSecrets(); err == nil {
+		t.Error("Expected missing password_hash_file to fail")
+	}
+}
+
+func TestResolveVaultSecrets(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/xferd": // KV version 2
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"v2-pass","token":"v2-token"},"metadata":{"version":3}}}`))
+		case "/v1/kv/xferd": // KV version 1
+			_, _ = w.Write([]byte(`{"data":{"secret":"v1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "vault-token")
+	if err := os.WriteFile(tokenFile, []byte("root\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newValidConfig()
+	cfg.Secrets.Vault = VaultConfig{Address: server.URL, TokenFile: tokenFile}
+	cfg.Server.BasicAuth = BasicAuthConfig{Enabled: true, Username: "admin", Password: "vault:secret/data/xferd#password"}
+	cfg.Server.JWTAuth.Secret = "vault:kv/xferd#secret"
+	cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "bearer", Token: "vault:secret/data/xferd#token"}
+	if err := cfg.resolveSecrets(); err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	if cfg.Server.BasicAuth.Password != "v2-pass" || cfg.Directories[0].Outbound.Auth.Token != "v2-token" {
+		t.Errorf("Expected KV version 2 secrets, got %q and %q", cfg.Server.BasicAuth.Password, cfg.Directories[0].Outbound.Auth.Token)
+	}
+	if cfg.Server.JWTAuth.Secret != "v1-secret" {
+		t.Errorf("Expected KV version 1 secret, got %q", cfg.Server.JWTAuth.Secret)
+	}
+	if requests != 2 {
+		t.Errorf("Expected each path to be read once, got %d requests", requests)
+	}
+
+	for _, ref := range []string{"vault:secret/data/xferd#missing", "vault:secret/data/other#password", "vault:secret/data/xferd"} {
+		cfg := newValidConfig()
+		cfg.Secrets.Vault = VaultConfig{Address: server.URL, TokenFile: tokenFile}
+		cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "bearer", Token: ref}
+		if err := cfg.resolveSecrets(); err == nil {
+			t.Errorf("Expected %s to fail", ref)
+		}
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	cfg = newValidConfig()
+	cfg.Secrets.Vault = VaultConfig{Address: server.URL}
+	cfg.Directories[0].Outbound.Auth = AuthConfig{Type: "bearer", Token: "vault:secret/data/xferd#token"}
+	if err := cfg.resolveSecrets(); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected Vault error to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package controlplane subscribes to a central service that pushes directory
+// configuration, so fleets of nodes can be reconfigured without distributing
+// configuration files.
+package controlplane
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/uploader"
+)
+
+// Headers sent with the subscription request
+const (
+	nodeHeader    = "X-Xferd-Node"
+	versionHeader = "X-Config-Version"
+)
+
+// maxDocumentBytes bounds a single configuration document
+const maxDocumentBytes = 16 << 20
+
+// Reconnect backoff bounds
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Update is a configuration document pushed by the control plane. Documents
+// are JSON (or single-line YAML) objects using the configuration file keys.
+type Update struct {
+	Version     string                   `yaml:"version"`
+	Directories []config.DirectoryConfig `yaml:"directories"`
+}
+
+// Subscriber holds a streaming connection to the control plane and hands
+// every new configuration document to a callback
+type Subscriber struct {
+	cfg     config.ControlPlaneConfig
+	client  *http.Client
+	version string // last applied version
+}
+
+// NewSubscriber creates a subscriber for cfg
+func NewSubscriber(cfg config.ControlPlaneConfig) (*Subscriber, error) {
+	tlsConfig, err := uploader.NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control plane TLS configuration: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	// No client timeout: the subscription stays open until the server ends it
+	return &Subscriber{cfg: cfg, client: &http.Client{Transport: transport}}, nil
+}
+
+// Run subscribes until ctx is cancelled, reconnecting with backoff. apply is
+// called for every document with a new version and must return once the
+// document is in effect: its version is only recorded, and reported on
+// reconnect, if apply succeeds. Documents it rejects are logged and the
+// previous configuration stays in effect.
+func (s *Subscriber) Run(ctx context.Context, apply func(Update) error) {
+	backoff := minBackoff
+	for {
+		connected, err := s.subscribe(ctx, apply)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minBackoff
+		}
+		log.Printf("Control plane: subscription to %s ended, reconnecting in %v: %v", s.cfg.URL, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// subscribe holds one streaming request, reporting whether it was accepted
+func (s *Subscriber) subscribe(ctx context.Context, apply func(Update) error) (bool, error) {
+	target, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return false, err
+	}
+	query := target.Query()
+	query.Set("node", s.cfg.GetNodeID())
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), http.NoBody)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set(nodeHeader, s.cfg.GetNodeID())
+	if s.version != "" {
+		req.Header.Set(versionHeader, s.version)
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	log.Printf("Control plane: subscribed to %s as %s", s.cfg.URL, s.cfg.GetNodeID())
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxDocumentBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue // keep-alive
+		}
+		s.handle(line, apply)
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, fmt.Errorf("stream closed by server")
+}
+
+// handle decodes and applies one configuration document
+func (s *Subscriber) handle(document []byte, apply func(Update) error) {
+	var update Update
+	if err := yaml.Unmarshal(document, &update); err != nil {
+		log.Printf("Control plane: ignoring malformed configuration: %v", err)
+		return
+	}
+	if update.Version != "" && update.Version == s.version {
+		return
+	}
+	if err := apply(update); err != nil {
+		log.Printf("Control plane: rejected configuration %s: %v", update.Version, err)
+		return
+	}
+	s.version = update.Version
+	log.Printf("Control plane: accepted configuration %s (%d directories)", update.Version, len(update.Directories))
+}
@@ -0,0 +1,87 @@
+package controlplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestSubscriberAppliesUpdates(t *testing.T) {
+	var mu sync.Mutex
+	var versions []string // X-Config-Version sent on each connection
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Query().Get("node") != "edge-1" || r.Header.Get(nodeHeader) != "edge-1" {
+			t.Errorf("Expected node edge-1, got %q / %q", r.URL.Query().Get("node"), r.Header.Get(nodeHeader))
+		}
+		mu.Lock()
+		versions = append(versions, r.Header.Get(versionHeader))
+		mu.Unlock()
+
+		fmt.Fprintln(w, `not a document: [`)
+		fmt.Fprintln(w, ``)
+		fmt.Fprintln(w, `{"version":"v1","directories":[{"name":"in","watch_path":"/data/in","outbound":{"url":"https://example.com/"}}]}`)
+		fmt.Fprintln(w, `{"version":"v1","directories":[]}`)
+		fmt.Fprintln(w, `{"version":"v2","directories":[]}`)
+	}))
+	defer server.Close()
+
+	s, err := NewSubscriber(config.ControlPlaneConfig{Enabled: true, URL: server.URL, NodeID: "edge-1", Token: "secret"})
+	if err != nil {
+		t.Fatalf("NewSubscriber failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	applied := make(chan Update, 10)
+	go s.Run(ctx, func(u Update) error {
+		if u.Version == "v2" {
+			return errors.New("rejected")
+		}
+		applied <- u
+		return nil
+	})
+
+	select {
+	case u := <-applied:
+		if len(u.Directories) != 1 || u.Directories[0].Name != "in" || u.Directories[0].Outbound.URL != "https://example.com/" {
+			t.Errorf("Unexpected directories: %+v", u.Directories)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No configuration applied")
+	}
+
+	// The stream ends after each response; the reconnect reports the applied version
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(versions)
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Subscriber did not reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if versions[0] != "" || versions[1] != "v1" {
+		t.Errorf("Expected versions [\"\" v1], got %q", versions)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected repeated and rejected versions not to be applied again, got %d more", len(applied))
+	}
+}
@@ -0,0 +1,8 @@
+// Package diskspace reports free space on the filesystem holding a path.
+package diskspace
+
+// Available returns the number of bytes available to unprivileged users on
+// the filesystem containing path
+func Available(path string) (uint64, error) {
+	return available(path)
+}
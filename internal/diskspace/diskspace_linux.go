@@ -0,0 +1,17 @@
+//go:build linux
+
+package diskspace
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// available uses statfs to query free blocks
+func available(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil // #nosec G115 -- block size is always positive
+}
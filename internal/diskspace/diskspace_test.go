@@ -0,0 +1,22 @@
+package diskspace
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAvailable(t *testing.T) {
+	free, err := Available(t.TempDir())
+	if err != nil {
+		t.Fatalf("Available failed: %v", err)
+	}
+	if free == 0 {
+		t.Error("Expected non-zero free space for temp directory")
+	}
+}
+
+func TestAvailableNonexistent(t *testing.T) {
+	if _, err := Available(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for nonexistent path")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package diskspace
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// available uses GetDiskFreeSpaceEx to query free bytes
+func available(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+
+	var freeBytes, totalBytes, totalFreeBytes uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &freeBytes, &totalBytes, &totalFreeBytes); err != nil {
+		return 0, fmt.Errorf("GetDiskFreeSpaceEx %s: %w", path, err)
+	}
+	return freeBytes, nil
+}
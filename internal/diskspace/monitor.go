@@ -0,0 +1,113 @@
+package diskspace
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// freeBytes reports the free space last measured for each monitored path
+var freeBytes = metrics.NewGaugeVec("xferd_disk_free_bytes",
+	"Bytes available on the filesystem holding a monitored path (temp, watch, ingest or shadow directory)",
+	"path")
+
+// lowSpace is 1 for monitored paths below their free space threshold
+var lowSpace = metrics.NewGaugeVec("xferd_disk_space_low",
+	"1 if the free space of the filesystem holding a monitored path is below its threshold, else 0",
+	"path")
+
+// Monitor checks the free space of the filesystems holding a set of paths
+// periodically, so callers can refuse to write to full volumes without
+// querying the filesystem on every request. A nil *Monitor reports no path
+// as low.
+type Monitor struct {
+	mu    sync.RWMutex
+	paths map[string]*volume
+}
+
+// volume is the free space threshold and last measurement of a path
+type volume struct {
+	minFree uint64
+	free    uint64
+	low     bool
+}
+
+// NewMonitor creates a monitor without paths
+func NewMonitor() *Monitor {
+	return &Monitor{paths: make(map[string]*volume)}
+}
+
+// Watch adds path to the monitored paths. Its filesystem is low on space
+// when fewer than minFree bytes are available; watching a path again keeps
+// the higher threshold.
+func (m *Monitor) Watch(path string, minFree uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.paths[path]; ok {
+		v.minFree = max(v.minFree, minFree)
+		return
+	}
+	m.paths[path] = &volume{minFree: minFree}
+}
+
+// Low reports whether the filesystem holding path was below its threshold
+// at the last check, and how many bytes were available. Paths not watched,
+// or whose free space could not be determined, are not low.
+func (m *Monitor) Low(path string) (free uint64, low bool) {
+	if m == nil {
+		return 0, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if v, ok := m.paths[path]; ok {
+		return v.free, v.low
+	}
+	return 0, false
+}
+
+// Check measures the free space of all paths, logging paths that drop
+// below or recover above their threshold
+func (m *Monitor) Check() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, v := range m.paths {
+		free, err := Available(path)
+		if err != nil {
+			// Paths created on demand, such as shadow directories, may not exist yet
+			v.low = false
+			continue
+		}
+		low := free < v.minFree
+		switch {
+		case low && !v.low:
+			log.Printf("Disk space low: %s has %d bytes free, below the minimum of %d", path, free, v.minFree)
+		case !low && v.low:
+			log.Printf("Disk space recovered: %s has %d bytes free", path, free)
+		}
+		v.free, v.low = free, low
+		freeBytes.With(path).Set(float64(free))
+		if low {
+			lowSpace.With(path).Set(1)
+		} else {
+			lowSpace.With(path).Set(0)
+		}
+	}
+}
+
+// Run checks the free space every interval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package diskspace
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	full, roomy := t.TempDir(), t.TempDir()
+	m := NewMonitor()
+	m.Watch(full, math.MaxUint64)
+	m.Watch(roomy, 1)
+	m.Watch(filepath.Join(roomy, "missing"), math.MaxUint64)
+
+	if _, low := m.Low(full); low {
+		t.Error("Expected no path to be low before the first check")
+	}
+	m.Check()
+
+	if free, low := m.Low(full); !low || free == 0 {
+		t.Errorf("Expected %s to be low with its free space reported, got %d, %v", full, free, low)
+	}
+	if _, low := m.Low(roomy); low {
+		t.Errorf("Expected %s not to be low", roomy)
+	}
+	if _, low := m.Low(filepath.Join(roomy, "missing")); low {
+		t.Error("Expected a path that does not exist not to be low")
+	}
+	if _, low := m.Low("/not/watched"); low {
+		t.Error("Expected a path that is not watched not to be low")
+	}
+
+	// Watching again keeps the higher threshold
+	m.Watch(full, 1)
+	m.Check()
+	if _, low := m.Low(full); !low {
+		t.Error("Expected the higher threshold to be kept")
+	}
+
+	var nilMonitor *Monitor
+	if _, low := nilMonitor.Low(full); low {
+		t.Error("Expected a nil monitor to report no path as low")
+	}
+}
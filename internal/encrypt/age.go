@@ -0,0 +1,33 @@
+package encrypt
+
+import (
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+)
+
+// ageEngine encrypts for age X25519 recipients
+type ageEngine struct {
+	recipients []age.Recipient
+}
+
+func newAge(recipients []string) (*ageEngine, error) {
+	a := &ageEngine{}
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", r, err)
+		}
+		a.recipients = append(a.recipients, recipient)
+	}
+	return a, nil
+}
+
+func (a *ageEngine) writer(w io.Writer) (io.WriteCloser, error) {
+	wc, err := age.Encrypt(w, a.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start age encryption: %w", err)
+	}
+	return wc, nil
+}
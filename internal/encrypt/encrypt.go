@@ -0,0 +1,69 @@
+// Package encrypt encrypts files for their recipient while they are
+// uploaded, with age X25519 recipients or OpenPGP public keys
+package encrypt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// engine encrypts content in one format
+type engine interface {
+	writer(w io.Writer) (io.WriteCloser, error)
+}
+
+// Encrypter encrypts the files of a directory. A nil Encrypter leaves
+// content and names as they are.
+type Encrypter struct {
+	engine engine
+	suffix string
+}
+
+// New creates the encrypter configured for an outbound, parsing its keys.
+// It returns nil if encryption is disabled.
+func New(cfg config.EncryptionConfig) (*Encrypter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.GetType() {
+	case config.EncryptionAge:
+		a, err := newAge(cfg.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{engine: a, suffix: ".age"}, nil
+	case config.EncryptionPGP:
+		pgp, err := newPGP(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{engine: pgp, suffix: ".pgp"}, nil
+	default:
+		return nil, fmt.Errorf("unknown encryption type: %s", cfg.Type)
+	}
+}
+
+// Name returns the name a file is sent under, with the suffix of the format
+// appended
+func (e *Encrypter) Name(path string) string {
+	if e == nil {
+		return path
+	}
+	return path + e.suffix
+}
+
+// Writer returns a writer that encrypts what is written to it into w. It
+// must be closed to write the end of the encrypted content; closing does not
+// close w.
+func (e *Encrypter) Writer(w io.Writer) (io.WriteCloser, error) {
+	if e == nil {
+		return nopCloser{w}, nil
+	}
+	return e.engine.writer(w)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,199 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/muzy/xferd/internal/config"
+)
+
+// encryptAll encrypts plain with enc in odd-sized writes, so that chunks
+// straddle them
+func encryptAll(t *testing.T, enc *Encrypter, plain []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := enc.Writer(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := plain; len(p) > 0; p = p[min(len(p), 1000):] {
+		if _, err := w.Write(p[:min(len(p), 1000)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := New(config.EncryptionConfig{Enabled: true, Recipients: []string{other.Recipient().String(), identity.Recipient().String()}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	const chunk = 64 << 10 // age payload chunk size
+	for _, size := range []int{0, 100, chunk, 2*chunk + 1} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		r, err := age.Decrypt(bytes.NewReader(encryptAll(t, enc, plain)), identity)
+		if err != nil {
+			t.Fatalf("Size %d: Decrypt failed: %v", size, err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("Size %d: decrypted content differs (%v)", size, err)
+		}
+	}
+	if name := enc.Name("report.csv"); name != "report.csv.age" {
+		t.Errorf("Expected report.csv.age, got %s", name)
+	}
+}
+
+func TestAgeExampleKey(t *testing.T) {
+	// The example key of the age reference implementation and its recipient
+	identity, err := age.ParseX25519Identity("AGE-SECRET-KEY-184JMZMVQH3E6U0PSL869004Y3U2NYV7R30EU99CSEDNPH02YUVFSZW44VU")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := New(config.EncryptionConfig{Enabled: true, Recipients: []string{"age1cy0su9fwf3gf9mw868g5yut09p6nytfmmnktexz2ya5uqg9vl9sss4euqm"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(encryptAll(t, enc, []byte("quarterly figures"))), identity)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "quarterly figures" {
+		t.Errorf("Expected the content back, got %q", got)
+	}
+}
+
+func TestAgeRecipient(t *testing.T) {
+	// from the age documentation
+	if _, err := newAge([]string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}); err != nil {
+		t.Errorf("Valid recipient rejected: %v", err)
+	}
+	for _, r := range []string{
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q", // checksum
+		"age1qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqq",    // length
+		"AGE1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", // mixed case
+	} {
+		if _, err := newAge([]string{r}); err == nil {
+			t.Errorf("Expected %s to be rejected", r)
+		}
+	}
+}
+
+func TestPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("Partner", "", "partner@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "partner.gpg")
+	var key bytes.Buffer
+	if err := entity.Serialize(&key); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, key.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := New(config.EncryptionConfig{Enabled: true, Type: config.EncryptionPGP, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	plain := []byte("quarterly figures")
+	md, err := openpgp.ReadMessage(bytes.NewReader(encryptAll(t, enc, plain)), openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	got, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Expected %q, got %q (%v)", plain, got, err)
+	}
+	if name := enc.Name("report.csv"); name != "report.csv.pgp" {
+		t.Errorf("Expected report.csv.pgp, got %s", name)
+	}
+
+	if _, err := New(config.EncryptionConfig{Enabled: true, Type: config.EncryptionPGP, KeyFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("Expected error for missing key file")
+	}
+}
+
+// TestPGPGnuPGKeys encrypts for keys exported by GnuPG 2.2 (testdata), an
+// RSA key and a Curve25519 key, and decrypts with their secret keys, using
+// gpg itself if it is installed
+func TestPGPGnuPGKeys(t *testing.T) {
+	gpg, _ := exec.LookPath("gpg")
+	for _, name := range []string{"gnupg-rsa", "gnupg-cv25519"} {
+		t.Run(name, func(t *testing.T) {
+			enc, err := New(config.EncryptionConfig{Enabled: true, Type: config.EncryptionPGP, KeyFile: filepath.Join("testdata", name+".asc")})
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			plain := []byte("quarterly figures")
+			encrypted := encryptAll(t, enc, plain)
+
+			secret, err := os.Open(filepath.Join("testdata", name+"-secret.asc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer secret.Close()
+			keys, err := openpgp.ReadArmoredKeyRing(secret)
+			if err != nil {
+				t.Fatalf("Failed to read secret key: %v", err)
+			}
+			md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), keys, nil, nil)
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if got, err := io.ReadAll(md.UnverifiedBody); err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("Expected %q, got %q (%v)", plain, got, err)
+			}
+
+			if gpg == "" {
+				return
+			}
+			home := t.TempDir()
+			if out, err := exec.Command(gpg, "--homedir", home, "--batch", "--import", filepath.Join("testdata", name+"-secret.asc")).CombinedOutput(); err != nil {
+				t.Fatalf("gpg --import failed: %v\n%s", err, out)
+			}
+			cmd := exec.Command(gpg, "--homedir", home, "--batch", "--decrypt")
+			cmd.Stdin = bytes.NewReader(encrypted)
+			got, err := cmd.Output()
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("Expected gpg to decrypt %q, got %q (%v)", plain, got, err)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	enc, err := New(config.EncryptionConfig{})
+	if err != nil || enc != nil {
+		t.Fatalf("Expected no encrypter, got %v (%v)", enc, err)
+	}
+	var out bytes.Buffer
+	w, _ := enc.Writer(&out)
+	_, _ = w.Write([]byte("plain"))
+	_ = w.Close()
+	if out.String() != "plain" || enc.Name("a.txt") != "a.txt" {
+		t.Errorf("Expected content and name unchanged, got %q", out.String())
+	}
+}
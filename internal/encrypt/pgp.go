@@ -0,0 +1,41 @@
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// pgp encrypts for OpenPGP public keys
+type pgp struct {
+	recipients openpgp.EntityList
+}
+
+func newPGP(keyFile string) (*pgp, error) {
+	data, err := os.ReadFile(keyFile) // #nosec G304 -- key file from the configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pgp key file: %w", err)
+	}
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pgp key file %s: %w", keyFile, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in pgp key file %s", keyFile)
+	}
+	return &pgp{recipients: keys}, nil
+}
+
+func (p *pgp) writer(w io.Writer) (io.WriteCloser, error) {
+	wc, err := openpgp.Encrypt(w, p.recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start pgp encryption: %w", err)
+	}
+	return wc, nil
+}
@@ -0,0 +1,235 @@
+// Package filestate tracks the lifecycle of every file from detection to
+// deletion, so that "where did file X go" can be answered without grepping
+// the logs of several components.
+package filestate
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/jsonl"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+// pruneInterval is how often expired events are removed from the file
+const pruneInterval = 24 * time.Hour
+
+// Lifecycle stages
+const (
+	StageDetected    = "detected"    // seen by a watcher, before the stability check
+	StageStable      = "stable"      // stability confirmed, handed to the dispatcher
+	StageEnqueued    = "enqueued"    // waiting for an upload worker
+	StageDropped     = "dropped"     // not enqueued because the queue was full, left for a later scan
+	StageFailed      = "failed"      // upload attempt failed, the file is kept
+	StageOverdue     = "overdue"     // not delivered within the directory's sla deadline
+	StageUploaded    = "uploaded"    // delivered to the destination
+	StageSkipped     = "skipped"     // not uploaded: unchanged since its last delivery or a duplicate of a recent upload
+	StageShadowed    = "shadowed"    // shadow copy committed, or failed to be with an error
+	StageHookFailed  = "hook_failed" // post-upload hook still failing after its retries
+	StageDeleted     = "deleted"     // source removed after delivery
+	StageMoved       = "moved"       // source moved to processed_path after delivery
+	StageRemoved     = "removed"     // deleted by someone else before it was uploaded
+	StageQuarantined = "quarantined" // found infected by the malware scan, or delivered with a failed hook, and moved to quarantine_path
+)
+
+// Event is a single lifecycle transition of a file
+type Event struct {
+	Time       time.Time `json:"time"`
+	Directory  string    `json:"directory"`
+	Path       string    `json:"path"`
+	TransferID string    `json:"transfer_id,omitempty"`
+	Stage      string    `json:"stage"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Transition is a stage a file reached
+type Transition struct {
+	Stage string    `json:"stage"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// File is the lifecycle of one file
+type File struct {
+	Directory  string       `json:"directory"`
+	Path       string       `json:"path"`
+	TransferID string       `json:"transfer_id,omitempty"` // transfer ID of the latest stage
+	Stage      string       `json:"stage"`                 // latest stage reached
+	Updated    time.Time    `json:"updated"`               // when the latest stage was reached
+	LastError  string       `json:"last_error,omitempty"`  // most recent error, if any
+	Stages     []Transition `json:"stages"`                // oldest first
+}
+
+// Query selects files from the store
+type Query struct {
+	Directories []string  // only these directories, all if empty
+	Path        string    // case-insensitive substring of the path
+	Since       time.Time // only files updated at or after this time
+	Limit       int       // most recently updated files returned
+}
+
+// Store appends lifecycle events to a JSON Lines file and keeps them grouped
+// by file in memory, so lookups do not read the file. The file is read once
+// when the store is opened and again after the daily pruning of events older
+// than the retention period, so memory grows with the number of events kept.
+type Store struct {
+	cfg   config.FileStateConfig
+	file  *jsonl.Store[Event]
+	mu    sync.RWMutex
+	files map[fileKey]*File // lifecycles by directory and path
+}
+
+// fileKey identifies a file by directory and path
+type fileKey struct {
+	directory, path string
+}
+
+// Open opens or creates the event file of cfg, prunes expired events and
+// indexes the rest
+func Open(cfg config.FileStateConfig) (*Store, error) {
+	events, err := jsonl.Open(cfg.Path, "file state", cfg.GetRetention(), func(e Event) time.Time { return e.Time })
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{cfg: cfg, file: events}
+	if s.files, err = s.load(); err != nil {
+		events.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Record appends a lifecycle event. Safe to call on a nil store.
+func (s *Store) Record(e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.TransferID == "" {
+		e.TransferID = transferid.Lookup(e.Path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Append(e); err != nil {
+		log.Printf("File state: failed to record %s of %s: %v", e.Stage, e.Path, err)
+		return
+	}
+	index(s.files, e)
+}
+
+// index adds an event to the lifecycle of its file
+func index(files map[fileKey]*File, e Event) {
+	key := fileKey{e.Directory, e.Path}
+	f, ok := files[key]
+	if !ok {
+		f = &File{Directory: e.Directory, Path: e.Path}
+		files[key] = f
+	}
+	f.Stages = append(f.Stages, Transition{Stage: e.Stage, Time: e.Time, Error: e.Error})
+	if !e.Time.Before(f.Updated) {
+		f.Stage, f.Updated = e.Stage, e.Time
+		if e.TransferID != "" {
+			f.TransferID = e.TransferID
+		}
+	}
+	if e.Error != "" {
+		f.LastError = e.Error
+	}
+}
+
+// load builds the lifecycles from the event file
+func (s *Store) load() (map[fileKey]*File, error) {
+	files := make(map[fileKey]*File)
+	if err := s.file.Scan(func(e Event) { index(files, e) }); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Lookup returns the lifecycles of the files matching q, most recently
+// updated first
+func (s *Store) Lookup(q Query) ([]File, error) {
+	text := strings.ToLower(q.Path)
+
+	s.mu.RLock()
+	var matches []File
+	for _, f := range s.files {
+		if len(q.Directories) > 0 && !slices.Contains(q.Directories, f.Directory) {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToLower(f.Path), text) {
+			continue
+		}
+		if f.Updated.Before(q.Since) {
+			continue
+		}
+		match := *f
+		match.Stages = slices.Clone(f.Stages)
+		matches = append(matches, match)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(matches, func(a, b File) int {
+		return cmp.Or(b.Updated.Compare(a.Updated), cmp.Compare(a.Path, b.Path))
+	})
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	if matches == nil {
+		matches = []File{}
+	}
+	return matches, nil
+}
+
+// RunPruning removes expired events every day until ctx is cancelled
+func (s *Store) RunPruning(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.prune(now); err != nil {
+				log.Printf("File state: failed to prune %s: %v", s.cfg.Path, err)
+			}
+		}
+	}
+}
+
+// Close closes the event file
+func (s *Store) Close() error {
+	return s.file.Close()
+}
+
+// prune removes events older than the retention period from the file and
+// rebuilds the lifecycles from what is left
+func (s *Store) prune(now time.Time) error {
+	// Events recorded meanwhile would be missing from the rebuilt lifecycles
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired, err := s.file.Prune(now)
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		log.Printf("File state: pruned %d events older than %v", expired, s.cfg.GetRetention())
+	}
+	files, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.files = files
+	return nil
+}
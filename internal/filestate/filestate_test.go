@@ -0,0 +1,161 @@
+package filestate
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+func TestStoreLookup(t *testing.T) {
+	store, err := Open(config.FileStateConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "state", "files.jsonl")})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC()
+	store.Record(Event{Time: now.Add(-3 * time.Hour), Directory: "invoices", Path: "/data/invoices/a.csv", Stage: StageDetected})
+	store.Record(Event{Time: now.Add(-3 * time.Hour), Directory: "invoices", Path: "/data/invoices/a.csv", Stage: StageEnqueued})
+	store.Record(Event{Time: now.Add(-2 * time.Hour), Directory: "invoices", Path: "/data/invoices/a.csv", Stage: StageFailed, Error: "503 Service Unavailable"})
+	store.Record(Event{Time: now.Add(-time.Hour), Directory: "reports", Path: "/data/reports/A.csv", Stage: StageUploaded})
+	store.Record(Event{Time: now, Directory: "invoices", Path: "/data/invoices/b.csv", Stage: StageDeleted})
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []string
+	}{
+		{"all, most recently updated first", Query{}, []string{"/data/invoices/b.csv", "/data/reports/A.csv", "/data/invoices/a.csv"}},
+		{"directory", Query{Directories: []string{"invoices"}}, []string{"/data/invoices/b.csv", "/data/invoices/a.csv"}},
+		{"path is case-insensitive", Query{Path: "a.CSV"}, []string{"/data/reports/A.csv", "/data/invoices/a.csv"}},
+		{"since", Query{Since: now.Add(-90 * time.Minute)}, []string{"/data/invoices/b.csv", "/data/reports/A.csv"}},
+		{"limit", Query{Limit: 1}, []string{"/data/invoices/b.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := store.Lookup(tt.query)
+			if err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			var paths []string
+			for _, f := range files {
+				paths = append(paths, f.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+
+	// Events of a file are grouped into its lifecycle, oldest first
+	files, _ := store.Lookup(Query{Path: "invoices/a.csv"})
+	if len(files) != 1 {
+		t.Fatalf("Expected one file, got %+v", files)
+	}
+	f := files[0]
+	if f.Stage != StageFailed || f.LastError != "503 Service Unavailable" || len(f.Stages) != 3 ||
+		f.Stages[0].Stage != StageDetected || f.Stages[2].Error != "503 Service Unavailable" {
+		t.Errorf("Unexpected lifecycle: %+v", f)
+	}
+}
+
+func TestStoreTransferID(t *testing.T) {
+	store, err := Open(config.FileStateConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "files.jsonl")})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	path := "/data/invoices/c.csv"
+	transferid.Assign(path, "req-1")
+	defer transferid.Forget(path)
+	store.Record(Event{Directory: "invoices", Path: path, Stage: StageDetected})
+	store.Record(Event{Directory: "invoices", Path: path, Stage: StageUploaded})
+
+	files, err := store.Lookup(Query{Path: path})
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(files) != 1 || files[0].TransferID != "req-1" {
+		t.Errorf("Expected file with transfer ID req-1, got %+v", files)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "files.jsonl")
+	cfg := config.FileStateConfig{Enabled: true, Path: path, RetentionDays: 7}
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	now := time.Now().UTC()
+	store.Record(Event{Time: now.Add(-8 * 24 * time.Hour), Directory: "in", Path: "/in/old", Stage: StageDeleted})
+	store.Record(Event{Time: now, Directory: "in", Path: "/in/new", Stage: StageEnqueued})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Expired events are pruned when the store is opened
+	store, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file state: %v", err)
+	}
+	if strings.Contains(string(content), "/in/old") || !strings.Contains(string(content), "/in/new") {
+		t.Errorf("Expected only the recent event to be kept, got %s", content)
+	}
+
+	// The store stays writable after pruning
+	store.Record(Event{Directory: "in", Path: "/in/newer", Stage: StageDetected})
+	files, err := store.Lookup(Query{Path: "newer"})
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected the new event after pruning, got %v %+v", err, files)
+	}
+}
+
+func TestStoreLookupFromMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "files.jsonl")
+	cfg := config.FileStateConfig{Enabled: true, Path: path}
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	store.Record(Event{Directory: "in", Path: "/in/a", Stage: StageDetected})
+	store.Record(Event{Directory: "in", Path: "/in/a", Stage: StageUploaded})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Lifecycles are rebuilt from the file when the store is opened
+	store, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+	store.Record(Event{Directory: "in", Path: "/in/a", Stage: StageDeleted})
+
+	// and answered from memory afterwards. Windows cannot remove a file
+	// that is still open.
+	if runtime.GOOS != "windows" {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := store.Lookup(Query{Path: "/in/a"})
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Stage != StageDeleted || len(files[0].Stages) != 3 {
+		t.Errorf("Expected the lifecycle of /in/a with 3 stages, got %+v", files)
+	}
+}
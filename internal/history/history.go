@@ -0,0 +1,148 @@
+// Package history keeps a searchable record of completed transfers, so that
+// delivery questions can be answered long after the files are gone.
+package history
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/jsonl"
+)
+
+// pruneInterval is how often expired transfers are removed from the file
+const pruneInterval = 24 * time.Hour
+
+// Transfer statuses
+const (
+	StatusDelivered = "delivered" // uploaded to the destination
+	StatusFailed    = "failed"    // upload attempt failed, the file is kept
+)
+
+// Entry is a completed transfer attempt
+type Entry struct {
+	Time        time.Time `json:"time"`    // when the attempt finished
+	Started     time.Time `json:"started"` // when the attempt began
+	Directory   string    `json:"directory"`
+	Path        string    `json:"path"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size,omitempty"`
+	Checksum    string    `json:"checksum,omitempty"` // SHA-256, if known or checksums is enabled
+	Status      string    `json:"status"`
+	Destination string    `json:"destination,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Query selects transfers from the history
+type Query struct {
+	Directories []string  // only these directories, all if empty
+	Text        string    // case-insensitive substring of the path, checksum or destination
+	Since       time.Time // only transfers finished at or after this time
+	Limit       int       // most recent matches returned
+}
+
+// Store appends transfers to a JSON Lines file and searches it. Transfers
+// older than the retention period are pruned daily. Searches read the whole
+// file, which keeps memory use independent of the retention period.
+type Store struct {
+	cfg  config.HistoryConfig
+	file *jsonl.Store[Entry]
+}
+
+// Open opens or creates the history file of cfg and prunes expired transfers
+func Open(cfg config.HistoryConfig) (*Store, error) {
+	entries, err := jsonl.Open(cfg.Path, "history", cfg.GetRetention(), func(e Entry) time.Time { return e.Time })
+	if err != nil {
+		return nil, err
+	}
+	return &Store{cfg: cfg, file: entries}, nil
+}
+
+// Checksums reports whether delivered transfers should carry a checksum.
+// Safe to call on a nil store.
+func (s *Store) Checksums() bool {
+	return s != nil && s.cfg.Checksums
+}
+
+// Record appends a transfer. Safe to call on a nil store.
+func (s *Store) Record(e Entry) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Filename == "" {
+		e.Filename = filepath.Base(e.Path)
+	}
+	if err := s.file.Append(e); err != nil {
+		log.Printf("History: failed to record transfer of %s: %v", e.Path, err)
+	}
+}
+
+// Search returns the most recent transfers matching q, newest first
+func (s *Store) Search(q Query) ([]Entry, error) {
+	text := strings.ToLower(q.Text)
+	var matches []Entry
+	err := s.file.Scan(func(e Entry) {
+		if e.Time.Before(q.Since) {
+			return
+		}
+		if len(q.Directories) > 0 && !slices.Contains(q.Directories, e.Directory) {
+			return
+		}
+		if text != "" && !strings.Contains(strings.ToLower(e.Path), text) &&
+			!strings.Contains(e.Checksum, text) && !strings.Contains(strings.ToLower(e.Destination), text) {
+			return
+		}
+		matches = append(matches, e)
+		// Keep memory bounded: drop the oldest matches once twice the limit is reached
+		if q.Limit > 0 && len(matches) >= 2*q.Limit {
+			matches = slices.Delete(matches, 0, len(matches)-q.Limit)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(matches)
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches, nil
+}
+
+// RunPruning removes expired transfers every day until ctx is cancelled
+func (s *Store) RunPruning(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.prune(now); err != nil {
+				log.Printf("History: failed to prune %s: %v", s.cfg.Path, err)
+			}
+		}
+	}
+}
+
+// Close closes the history file
+func (s *Store) Close() error {
+	return s.file.Close()
+}
+
+// prune removes transfers older than the retention period
+func (s *Store) prune(now time.Time) error {
+	expired, err := s.file.Prune(now)
+	if expired > 0 {
+		log.Printf("History: pruned %d transfers older than %v", expired, s.cfg.GetRetention())
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestStoreSearch(t *testing.T) {
+	store, err := Open(config.HistoryConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "history", "transfers.jsonl")})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC()
+	store.Record(Entry{Time: now.Add(-48 * time.Hour), Directory: "invoices", Path: "/data/invoices/a.csv", Status: StatusDelivered, Checksum: "abc123"})
+	store.Record(Entry{Time: now.Add(-time.Hour), Directory: "invoices", Path: "/data/invoices/b.csv", Status: StatusFailed, Error: "timeout"})
+	store.Record(Entry{Time: now, Directory: "reports", Path: "/data/reports/A.csv", Status: StatusDelivered})
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []string
+	}{
+		{"all, newest first", Query{}, []string{"/data/reports/A.csv", "/data/invoices/b.csv", "/data/invoices/a.csv"}},
+		{"directory", Query{Directories: []string{"invoices"}}, []string{"/data/invoices/b.csv", "/data/invoices/a.csv"}},
+		{"text is case-insensitive", Query{Text: "a.CSV"}, []string{"/data/reports/A.csv", "/data/invoices/a.csv"}},
+		{"checksum", Query{Text: "abc1"}, []string{"/data/invoices/a.csv"}},
+		{"since", Query{Since: now.Add(-2 * time.Hour)}, []string{"/data/reports/A.csv", "/data/invoices/b.csv"}},
+		{"limit", Query{Limit: 1}, []string{"/data/reports/A.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := store.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var paths []string
+			for _, e := range entries {
+				paths = append(paths, e.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+
+	entries, _ := store.Search(Query{Text: "b.csv"})
+	if len(entries) != 1 || entries[0].Filename != "b.csv" || entries[0].Error != "timeout" {
+		t.Errorf("Unexpected entry: %+v", entries)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transfers.jsonl")
+	cfg := config.HistoryConfig{Enabled: true, Path: path, RetentionDays: 30}
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	now := time.Now().UTC()
+	store.Record(Entry{Time: now.Add(-31 * 24 * time.Hour), Directory: "in", Path: "/in/old", Status: StatusDelivered})
+	store.Record(Entry{Time: now, Directory: "in", Path: "/in/new", Status: StatusDelivered})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Expired transfers are pruned when the history is opened
+	store, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if strings.Contains(string(content), "/in/old") || !strings.Contains(string(content), "/in/new") {
+		t.Errorf("Expected only the recent transfer to be kept, got %s", content)
+	}
+
+	// The reopened history still appends
+	store.Record(Entry{Directory: "in", Path: "/in/newer", Status: StatusDelivered})
+	entries, err := store.Search(Query{})
+	if err != nil || len(entries) != 2 || entries[0].Path != "/in/newer" {
+		t.Errorf("Expected 2 transfers after pruning, got %+v (%v)", entries, err)
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+	store.Record(Entry{Path: "/in/a"})
+	if store.Checksums() {
+		t.Error("Expected nil store not to want checksums")
+	}
+}
@@ -0,0 +1,183 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+// requestIDHeader carries the request ID, taken from the client if it sent one
+const requestIDHeader = "X-Request-ID"
+
+// accessLogger writes one line per request in the combined log format or as
+// JSON, separate from the application log
+type accessLogger struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+	file   *os.File // nil when writing to standard output
+}
+
+// newAccessLogger opens the access log configured in cfg
+func newAccessLogger(cfg config.AccessLogConfig) (*accessLogger, error) {
+	l := &accessLogger{format: cfg.GetFormat(), out: os.Stdout}
+	if cfg.Path != "" {
+		file, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640) // #nosec G302 G304 -- path from the configuration file
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		l.out, l.file = file, file
+	}
+	return l, nil
+}
+
+// close closes the access log file
+func (l *accessLogger) close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// accessLogEntry is a logged request
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// wrap logs every request handled by next and tags it with a request ID
+func (l *accessLogger) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		user, _, _ := r.BasicAuth()
+		l.write(accessLogEntry{
+			Time:       start,
+			RequestID:  id,
+			RemoteAddr: clientIP(r),
+			User:       user,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Protocol:   r.Proto,
+			Status:     rec.statusCode(),
+			Bytes:      rec.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+// write appends an entry to the log
+func (l *accessLogger) write(e accessLogEntry) {
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		// Combined log format, followed by the request ID and duration in milliseconds
+		line = fmt.Appendf(nil, "%s - %s [%s] %s %d %d %s %s %s %s\n",
+			e.RemoteAddr, dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(e.Method+" "+e.URI+" "+e.Protocol), e.Status, e.Bytes,
+			strconv.Quote(dash(e.Referer)), strconv.Quote(dash(e.UserAgent)),
+			e.RequestID, strconv.FormatFloat(e.DurationMs, 'f', 3, 64))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(line)
+}
+
+// dash returns s, or "-" for an empty field
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	return transferid.New()
+}
+
+// requestID returns the ID of a request: the one assigned by the access log,
+// or the client's X-Request-ID, or a new one. Uploads set it as the transfer
+// ID of the stored file, correlating the request with its delivery.
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		return id
+	}
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return id
+}
+
+// statusRecorder captures the status code and body size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the status code
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the body bytes written
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// statusCode returns the response status, 200 if nothing was written
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
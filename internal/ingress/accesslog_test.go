@@ -0,0 +1,98 @@
+package ingress
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestAccessLogCombined(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "access.log")
+	cfg := config.ServerConfig{
+		Port:      8080,
+		TempDir:   filepath.Join(tmpDir, "temp"),
+		AccessLog: config.AccessLogConfig{Enabled: true, Path: logPath},
+	}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/health?probe=1", nil)
+	req.Header.Set("User-Agent", "probe/1.0")
+	req.Header.Set(requestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIDHeader); got != "req-42" {
+		t.Errorf("Expected the client's request ID to be echoed, got %q", got)
+	}
+
+	// Requests without an ID get a generated one
+	w = httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/health", nil))
+	generated := w.Header().Get(requestIDHeader)
+	if generated == "" {
+		t.Error("Expected a generated request ID")
+	}
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Failed to stop server: %v", err)
+	}
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read access log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 access log lines, got %q", lines)
+	}
+	combined := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /health\?probe=1 HTTP/1\.1" 200 2 "-" "probe/1\.0" req-42 \d+\.\d{3}$`)
+	if !combined.MatchString(lines[0]) {
+		t.Errorf("Unexpected combined log line: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"POST /health HTTP/1.1" 405 `) || !strings.Contains(lines[1], generated) {
+		t.Errorf("Expected the rejected request with its generated ID, got: %s", lines[1])
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "access.log")
+	cfg := config.ServerConfig{
+		Port:      8080,
+		TempDir:   filepath.Join(tmpDir, "temp"),
+		AccessLog: config.AccessLogConfig{Enabled: true, Path: logPath, Format: "json"},
+	}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.SetBasicAuth("partner", "secret")
+	server.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Failed to stop server: %v", err)
+	}
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read access log: %v", err)
+	}
+	var entry accessLogEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatalf("Failed to decode access log entry %s: %v", content, err)
+	}
+	if entry.Method != http.MethodGet || entry.URI != "/health" || entry.Status != http.StatusOK ||
+		entry.Bytes != 2 || entry.User != "partner" || entry.RequestID == "" || entry.Time.IsZero() {
+		t.Errorf("Unexpected access log entry: %+v", entry)
+	}
+}
@@ -0,0 +1,55 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/muzy/xferd/internal/capture"
+)
+
+// SetCapture lets /debug/capture switch outbound recording on and off.
+// Must be called before Start.
+func (s *Server) SetCapture(r *capture.Recorder) {
+	s.capture = r
+}
+
+// handleCapture reports or changes whether sampled outbound requests are recorded
+// GET returns the state; POST takes {"enabled": bool, "sample_rate": number}
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.capture == nil {
+		writeError(w, r, http.StatusNotFound, ErrCodeCaptureDisabled, "Outbound capture is not configured")
+		return
+	}
+	// Recording covers every directory, so anonymous clients and clients
+	// restricted to some directories may not see or change it
+	if !isAuthenticated(r) {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Outbound capture requires authentication to be configured")
+		return
+	}
+	if !isDirectoryAllowed(r, "*") {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		state := s.capture.State()
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&state); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if err := s.capture.Set(state); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		log.Printf("Outbound capture set to enabled=%v, sample_rate=%v by %s", state.Enabled, state.SampleRate, r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.capture.State())
+}
@@ -0,0 +1,98 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muzy/xferd/internal/capture"
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestCaptureEndpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{
+		Port:      8080,
+		TempDir:   filepath.Join(tmpDir, "temp"),
+		BasicAuth: config.BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+	}
+	dirs := []config.DirectoryConfig{{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")}}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/debug/capture", strings.NewReader(body))
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("GET", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without outbound capture, got %d", w.Code)
+	}
+
+	recorder, err := capture.New(config.CaptureConfig{Path: filepath.Join(tmpDir, "capture")})
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+	server.SetCapture(recorder)
+
+	w := serve("POST", `{"enabled":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var state capture.State
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !state.Enabled || state.SampleRate != config.DefaultCaptureSampleRate {
+		t.Errorf("Expected capture enabled at the default sample rate, got %+v", state)
+	}
+
+	if w := serve("POST", `{"sample_rate":2}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid sample rate, got %d", w.Code)
+	}
+
+	// Clients restricted to some directories may not change global recording
+	req := httptest.NewRequest("POST", "/debug/capture", strings.NewReader(`{"enabled":false}`))
+	req = withAuthenticated(req.WithContext(context.WithValue(req.Context(), allowedDirsKey, []string{"test"})), "jwt:uploader")
+	w = httptest.NewRecorder()
+	server.handleCapture(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a restricted client, got %d", w.Code)
+	}
+	if !recorder.State().Enabled {
+		t.Error("Expected capture to stay enabled")
+	}
+}
+
+func TestCaptureEndpointForbidden(t *testing.T) {
+	// Without authentication anyone who reaches the port could record outbound traffic
+	server, err := NewServer(config.ServerConfig{TempDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	recorder, err := capture.New(config.CaptureConfig{Path: filepath.Join(t.TempDir(), "capture")})
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+	server.SetCapture(recorder)
+
+	for _, method := range []string{"GET", "POST"} {
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(method, "/debug/capture", strings.NewReader(`{"enabled":true}`)))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for an anonymous %s, got %d", method, w.Code)
+		}
+	}
+	if recorder.State().Enabled {
+		t.Error("Expected an anonymous POST /debug/capture to be refused")
+	}
+}
@@ -0,0 +1,100 @@
+package ingress
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/quarantine"
+	"github.com/muzy/xferd/internal/storage"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+// ChecksumHeader carries the hex-encoded SHA-256 of an uploaded file. Clients
+// that stream the body and only know the checksum at the end may send it as
+// an HTTP trailer instead.
+const ChecksumHeader = "X-Checksum-SHA256"
+
+// expectedChecksum returns the checksum declared by the client, or "" if none
+// was sent. Trailers are only populated once the body has been read to EOF,
+// so the remaining body is drained first.
+func expectedChecksum(r *http.Request) (string, error) {
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	value := r.Header.Get(ChecksumHeader)
+	if value == "" {
+		value = r.Trailer.Get(ChecksumHeader)
+	}
+	if value == "" {
+		return "", nil
+	}
+
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) != sha256.Size*2 {
+		return "", fmt.Errorf("%s must be %d hex characters", ChecksumHeader, sha256.Size*2)
+	}
+	if _, err := hex.DecodeString(value); err != nil {
+		return "", fmt.Errorf("%s is not valid hex", ChecksumHeader)
+	}
+	return value, nil
+}
+
+// checksumReader hashes everything read through it
+type checksumReader struct {
+	r    io.Reader
+	hash hash.Hash
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{r: r, hash: sha256.New()}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+// Sum returns the hex-encoded checksum of the data read so far
+func (c *checksumReader) Sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// quarantineUpload keeps an upload that does not match its declared checksum
+// in the directory's quarantine path, or discards it if there is none
+func quarantineUpload(dst storage.File, dirConfig config.DirectoryConfig, finalPath, id, detail string) {
+	dir := dirConfig.GetQuarantinePath()
+	relocatable, ok := dst.(storage.Relocatable)
+	if dir == "" || !ok {
+		dst.Abort()
+		return
+	}
+
+	prefix := transferid.Prefix(dirConfig.Name, id)
+	target := quarantine.Target(dir, dirConfig.GetIngestPath(), finalPath)
+	if err := relocatable.CommitTo(target); err != nil {
+		log.Printf("%s Failed to quarantine upload %s: %v", prefix, finalPath, err)
+		return
+	}
+	rec := quarantine.Record{
+		File:       finalPath,
+		Directory:  dirConfig.Name,
+		Reason:     quarantine.ReasonChecksumMismatch,
+		Detail:     detail,
+		TransferID: id,
+	}
+	if info, err := os.Stat(target); err == nil {
+		rec.Size = info.Size()
+	}
+	if err := quarantine.Write(target, rec); err != nil {
+		log.Printf("%s %v", prefix, err)
+	}
+	log.Printf("%s Quarantined upload %s: %s, moved to %s", prefix, finalPath, detail, target)
+}
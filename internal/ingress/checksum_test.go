@@ -0,0 +1,201 @@
+package ingress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/quarantine"
+)
+
+func newChecksumTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+
+	cfg := config.ServerConfig{TempDir: filepath.Join(tmpDir, "temp")}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server, watchDir
+}
+
+func multipartUpload(t *testing.T, filename string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	_, _ = part.Write(content)
+	_ = writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadChecksumHeader(t *testing.T) {
+	server, watchDir := newChecksumTestServer(t)
+	content := []byte("checksummed content")
+
+	body, contentType := multipartUpload(t, "ok.txt", content)
+	req := httptest.NewRequest("POST", "/upload/test", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(ChecksumHeader, strings.ToUpper(sha256Hex(content)))
+	w := httptest.NewRecorder()
+	server.handleUpload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "ok.txt")); err != nil {
+		t.Errorf("Expected uploaded file: %v", err)
+	}
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	server, watchDir := newChecksumTestServer(t)
+
+	body, contentType := multipartUpload(t, "bad.txt", []byte("corrupted"))
+	req := httptest.NewRequest("POST", "/upload/test", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(ChecksumHeader, sha256Hex([]byte("original")))
+	w := httptest.NewRecorder()
+	server.handleUpload(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", w.Code)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	if resp.Error.Code != ErrCodeChecksumMismatch {
+		t.Errorf("Expected code %s, got %s", ErrCodeChecksumMismatch, resp.Error.Code)
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "bad.txt")); !os.IsNotExist(err) {
+		t.Error("Mismatched upload should not reach the watch directory")
+	}
+	entries, _ := os.ReadDir(server.config.TempDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected temp directory to be cleaned up, found %d entries", len(entries))
+	}
+}
+
+func TestUploadChecksumMismatchQuarantined(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	quarantineDir := filepath.Join(tmpDir, "quarantine")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+	cfg := config.ServerConfig{TempDir: filepath.Join(tmpDir, "temp")}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir, QuarantinePath: quarantineDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	body, contentType := multipartUpload(t, "bad.txt", []byte("corrupted"))
+	req := httptest.NewRequest("POST", "/upload/test/in", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(ChecksumHeader, sha256Hex([]byte("original")))
+	w := httptest.NewRecorder()
+	server.handleUpload(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "in", "bad.txt")); !os.IsNotExist(err) {
+		t.Error("Mismatched upload should not reach the watch directory")
+	}
+	quarantined := filepath.Join(quarantineDir, "in", "bad.txt")
+	if data, err := os.ReadFile(quarantined); err != nil || string(data) != "corrupted" {
+		t.Errorf("Expected the upload in quarantine, got %q, %v", data, err)
+	}
+	var rec quarantine.Record
+	data, err := os.ReadFile(quarantined + quarantine.SidecarSuffix)
+	if err != nil {
+		t.Fatalf("Expected a sidecar: %v", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("Failed to decode sidecar: %v", err)
+	}
+	if rec.Reason != quarantine.ReasonChecksumMismatch || rec.Size != 9 || rec.TransferID == "" {
+		t.Errorf("Unexpected sidecar: %+v", rec)
+	}
+}
+
+func TestUploadChecksumInvalid(t *testing.T) {
+	server, _ := newChecksumTestServer(t)
+
+	body, contentType := multipartUpload(t, "x.txt", []byte("x"))
+	req := httptest.NewRequest("POST", "/upload/test", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(ChecksumHeader, "not-a-checksum")
+	w := httptest.NewRecorder()
+	server.handleUpload(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestUploadChecksumTrailer(t *testing.T) {
+	server, watchDir := newChecksumTestServer(t)
+	ts := httptest.NewServer(http.HandlerFunc(server.handleUpload))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name       string
+		checksum   func(content []byte) string
+		wantStatus int
+	}{
+		{"Match", sha256Hex, http.StatusOK},
+		{"Mismatch", func([]byte) string { return sha256Hex([]byte("other")) }, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content := []byte("streamed " + tc.name)
+			body, contentType := multipartUpload(t, tc.name+".txt", content)
+
+			// Wrap the body so the client cannot know its length and must use chunked encoding
+			req, err := http.NewRequest("POST", ts.URL+"/upload/test", io.MultiReader(body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", contentType)
+			req.Trailer = http.Header{ChecksumHeader: {tc.checksum(content)}}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, resp.StatusCode)
+			}
+			_, statErr := os.Stat(filepath.Join(watchDir, tc.name+".txt"))
+			if (statErr == nil) != (tc.wantStatus == http.StatusOK) {
+				t.Errorf("Unexpected file state in watch directory: %v", statErr)
+			}
+		})
+	}
+}
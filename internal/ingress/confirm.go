@@ -0,0 +1,96 @@
+package ingress
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// confirmationHeader carries the one-time token of a confirmation, both in
+// the answer to the first request and in the repetition confirming it
+const confirmationHeader = "X-Confirmation-Token"
+
+// pendingConfirmation is a disruptive request waiting to be confirmed
+type pendingConfirmation struct {
+	action    string // the endpoint and its arguments; the repetition must match
+	requester string // identity that sent the first request
+	expires   time.Time
+}
+
+// confirmations holds the tokens issued for admin_confirmation
+type confirmations struct {
+	config  config.AdminConfirmationConfig
+	mu      sync.Mutex
+	pending map[string]pendingConfirmation // token -> request
+}
+
+// newConfirmations creates the token store for cfg
+func newConfirmations(cfg config.AdminConfirmationConfig) *confirmations {
+	return &confirmations{config: cfg, pending: make(map[string]pendingConfirmation)}
+}
+
+// confirmed reports whether a request to endpoint may be carried out. If
+// endpoint requires confirmation and the request carries no token, it is
+// answered with 428 and a token for the repetition; an unknown, expired or
+// mismatching token is refused with 403. action identifies what the request
+// does, e.g. the shadow copy it restores, so a token cannot confirm another.
+func (s *Server) confirmed(w http.ResponseWriter, r *http.Request, endpoint, action string) bool {
+	c := s.confirms
+	if c == nil || !c.config.Requires(endpoint) {
+		return true
+	}
+	action = endpoint + " " + action
+	who := identity(r)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, token)
+		}
+	}
+
+	token := r.Header.Get(confirmationHeader)
+	if token == "" {
+		token = newConfirmationToken()
+		expires := now.Add(c.config.GetTTL())
+		c.pending[token] = pendingConfirmation{action: action, requester: who, expires: expires}
+		log.Printf("Confirmation required for %s requested by %s (%s)", action, who, r.RemoteAddr)
+
+		w.Header().Set(confirmationHeader, token)
+		w.Header().Set("X-Confirmation-Expires", expires.UTC().Format(time.RFC3339))
+		message := "Repeat the request with the " + confirmationHeader + " header to confirm it"
+		if c.config.SecondApprover {
+			message = "Another user must repeat the request with the " + confirmationHeader + " header to confirm it"
+		}
+		writeError(w, r, http.StatusPreconditionRequired, ErrCodeConfirmationRequired, message)
+		return false
+	}
+
+	p, ok := c.pending[token]
+	if !ok || p.action != action {
+		writeError(w, r, http.StatusForbidden, ErrCodeConfirmationInvalid, "Unknown or expired confirmation token")
+		return false
+	}
+	// The token stays valid so that someone else can still confirm
+	if c.config.SecondApprover && p.requester == who {
+		writeError(w, r, http.StatusForbidden, ErrCodeConfirmationInvalid, "The request must be confirmed by another user")
+		return false
+	}
+	delete(c.pending, token)
+	log.Printf("%s requested by %s confirmed by %s (%s)", action, p.requester, who, r.RemoteAddr)
+	return true
+}
+
+// newConfirmationToken returns a random one-time token
+func newConfirmationToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
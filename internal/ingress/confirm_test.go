@@ -0,0 +1,134 @@
+package ingress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestAdminConfirmation(t *testing.T) {
+	cfg := config.ServerConfig{
+		TempDir:           t.TempDir(),
+		BasicAuth:         config.BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+		AdminConfirmation: config.AdminConfirmationConfig{Enabled: true},
+	}
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	drain := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/drain", nil)
+		req.SetBasicAuth("admin", "secret")
+		if token != "" {
+			req.Header.Set(confirmationHeader, token)
+		}
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first request only yields a token
+	w := drain("")
+	token := w.Header().Get(confirmationHeader)
+	if w.Code != http.StatusPreconditionRequired || token == "" {
+		t.Fatalf("Expected 428 with a confirmation token, got %d %q", w.Code, token)
+	}
+	if server.draining.Load() {
+		t.Fatal("Expected the unconfirmed request not to drain")
+	}
+
+	if w := drain("0123456789abcdef"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an unknown token, got %d", w.Code)
+	}
+
+	// A token issued for one action does not confirm another
+	req := httptest.NewRequest("POST", "/shadow/restore", nil)
+	req.Header.Set(confirmationHeader, token)
+	w = httptest.NewRecorder()
+	if server.confirmed(w, req, config.AdminEndpointShadowRestore, "invoices a.pdf /data") || w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a token of another action, got %d", w.Code)
+	}
+
+	if w := drain(token); w.Code != http.StatusAccepted || !server.draining.Load() {
+		t.Errorf("Expected the confirmed request to drain, got %d", w.Code)
+	}
+
+	// Tokens are used once
+	server.draining.Store(false)
+	if w := drain(token); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a used token, got %d", w.Code)
+	}
+}
+
+func TestAdminConfirmationEndpoints(t *testing.T) {
+	cfg := config.ServerConfig{
+		TempDir:   t.TempDir(),
+		BasicAuth: config.BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+		AdminConfirmation: config.AdminConfirmationConfig{
+			Enabled:   true,
+			Endpoints: []string{config.AdminEndpointShadowRestore},
+		},
+	}
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Endpoints not listed are carried out straight away
+	req := httptest.NewRequest("POST", "/drain", nil)
+	req.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202 without confirmation, got %d", w.Code)
+	}
+}
+
+func TestAdminConfirmationSecondApprover(t *testing.T) {
+	cfg := config.ServerConfig{
+		TempDir: t.TempDir(),
+		JWTAuth: config.JWTAuthConfig{Enabled: true, Secret: "shared-secret"},
+		AdminConfirmation: config.AdminConfirmationConfig{
+			Enabled:        true,
+			SecondApprover: true,
+			TTLSeconds:     60,
+		},
+	}
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	drain := func(subject, token string) *httptest.ResponseRecorder {
+		jwt := signHS256(t, "shared-secret", map[string]interface{}{"sub": subject, "exp": time.Now().Add(time.Hour).Unix()})
+		req := httptest.NewRequest("POST", "/drain", nil)
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		if token != "" {
+			req.Header.Set(confirmationHeader, token)
+		}
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := drain("alice", "")
+	token := w.Header().Get(confirmationHeader)
+	if w.Code != http.StatusPreconditionRequired || token == "" {
+		t.Fatalf("Expected 428 with a confirmation token, got %d %q", w.Code, token)
+	}
+	if expires, err := time.Parse(time.RFC3339, w.Header().Get("X-Confirmation-Expires")); err != nil || time.Until(expires) > time.Minute {
+		t.Errorf("Expected the token to expire within ttl_seconds, got %q", w.Header().Get("X-Confirmation-Expires"))
+	}
+
+	// The requester cannot approve their own request, and the token stays valid
+	if w := drain("alice", token); w.Code != http.StatusForbidden || server.draining.Load() {
+		t.Errorf("Expected 403 when the requester confirms, got %d", w.Code)
+	}
+	if w := drain("bob", token); w.Code != http.StatusAccepted || !server.draining.Load() {
+		t.Errorf("Expected a second user to confirm, got %d", w.Code)
+	}
+}
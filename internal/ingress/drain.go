@@ -0,0 +1,73 @@
+package ingress
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// drainResponse is the POST /drain response
+type drainResponse struct {
+	Draining bool `json:"draining"`
+}
+
+// SetDrainHandler sets the function POST /drain calls to start draining the
+// service. Must be called before Start.
+func (s *Server) SetDrainHandler(drain func()) {
+	s.drain = drain
+}
+
+// SetDraining stops accepting uploads; they are rejected with 503 and /ready
+// fails while the service delivers what is already queued
+func (s *Server) SetDraining() {
+	s.draining.Store(true)
+}
+
+// withDrain rejects requests once the service is draining
+func (s *Server) withDrain(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Connection", "close")
+			writeError(w, r, http.StatusServiceUnavailable, ErrCodeDraining, "Service is draining and no longer accepts uploads")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleDrain starts draining: uploads are rejected, queued files are
+// delivered for up to drain_timeout_seconds and the service exits
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	// Stopping the service affects every directory, so anonymous clients and
+	// clients restricted to some directories may not do it
+	if !isAuthenticated(r) {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Draining requires authentication to be configured")
+		return
+	}
+	if !isDirectoryAllowed(r, "*") {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+		return
+	}
+
+	if !s.draining.Load() {
+		if !s.confirmed(w, r, config.AdminEndpointDrain, "") {
+			return
+		}
+		log.Printf("Drain requested by %s", r.RemoteAddr)
+		if s.drain != nil {
+			s.drain()
+		} else {
+			s.SetDraining()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(drainResponse{Draining: true})
+}
@@ -0,0 +1,142 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestDrainEndpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{
+		Port:      8080,
+		TempDir:   filepath.Join(tmpDir, "temp"),
+		BasicAuth: config.BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+	}
+	dirs := []config.DirectoryConfig{{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")}}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetReadinessCheck(func(context.Context) []ReadinessCheck {
+		return []ReadinessCheck{{Name: "queue", Directory: "test", OK: true}}
+	})
+
+	// The service marks the server as draining when it starts to drain
+	drains := 0
+	server.SetDrainHandler(func() {
+		drains++
+		server.SetDraining()
+	})
+
+	drainRequest := func(method string) *http.Request {
+		req := httptest.NewRequest(method, "/drain", nil)
+		req.SetBasicAuth("admin", "secret")
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, drainRequest("GET"))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+
+	for range 2 {
+		w = httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, drainRequest("POST"))
+		if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"draining":true`) {
+			t.Errorf("Expected 202 and draining, got %d %s", w.Code, w.Body.String())
+		}
+	}
+	if drains != 1 {
+		t.Errorf("Expected the drain handler to be called once, got %d", drains)
+	}
+
+	// Uploads and validations are rejected while draining
+	for _, path := range []string{"/upload/test", "/validate/test?filename=a.txt"} {
+		w = httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		var resp ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if w.Code != http.StatusServiceUnavailable || resp.Error.Code != ErrCodeDraining {
+			t.Errorf("Expected 503 %s for %s, got %d %s", ErrCodeDraining, path, w.Code, resp.Error.Code)
+		}
+	}
+
+	// Load balancers stop routing to a draining instance
+	w = httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	var ready ReadinessResponse
+	if err := json.NewDecoder(w.Body).Decode(&ready); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusServiceUnavailable || ready.Ready || len(ready.Checks) != 1 || ready.Checks[0].Name != "draining" {
+		t.Errorf("Expected 503 with the draining check, got %d %+v", w.Code, ready)
+	}
+
+	// Health stays available
+	w = httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for /health, got %d", w.Code)
+	}
+}
+
+func TestDrainEndpointWithoutHandler(t *testing.T) {
+	cfg := config.ServerConfig{
+		TempDir:   t.TempDir(),
+		BasicAuth: config.BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+	}
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/drain", nil)
+	req.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202, got %d", w.Code)
+	}
+	if !server.draining.Load() {
+		t.Error("Expected uploads to be rejected after POST /drain")
+	}
+}
+
+func TestDrainEndpointForbidden(t *testing.T) {
+	// Without authentication anyone who reaches the port could stop the service
+	server, err := NewServer(config.ServerConfig{TempDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/drain", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without authentication, got %d", w.Code)
+	}
+	if server.draining.Load() {
+		t.Error("Expected an anonymous POST /drain to be refused")
+	}
+
+	// Clients restricted to some directories may not stop the others
+	req := httptest.NewRequest("POST", "/drain", nil)
+	req = withAuthenticated(req.WithContext(context.WithValue(req.Context(), allowedDirsKey, []string{"test"})), "jwt:uploader")
+	w = httptest.NewRecorder()
+	server.handleDrain(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a restricted client, got %d", w.Code)
+	}
+	if server.draining.Load() {
+		t.Error("Expected a restricted POST /drain to be refused")
+	}
+}
@@ -0,0 +1,62 @@
+package ingress
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorCode is a stable, machine-readable identifier for API failures.
+// Codes are part of the public API: never change or reuse an existing value.
+type ErrorCode string
+
+// Error code catalogue
+const (
+	ErrCodeMethodNotAllowed     ErrorCode = "XFERD_METHOD_NOT_ALLOWED"
+	ErrCodeUnauthorized         ErrorCode = "XFERD_UNAUTHORIZED"
+	ErrCodeForbidden            ErrorCode = "XFERD_FORBIDDEN"
+	ErrCodeDirectoryRequired    ErrorCode = "XFERD_DIRECTORY_REQUIRED"
+	ErrCodeUnknownDirectory     ErrorCode = "XFERD_UNKNOWN_DIRECTORY"
+	ErrCodeInvalidRequest       ErrorCode = "XFERD_INVALID_REQUEST"
+	ErrCodeMissingFile          ErrorCode = "XFERD_MISSING_FILE"
+	ErrCodeFilenameRequired     ErrorCode = "XFERD_FILENAME_REQUIRED"
+	ErrCodeInvalidFilename      ErrorCode = "XFERD_INVALID_FILENAME"
+	ErrCodeInvalidPath          ErrorCode = "XFERD_INVALID_PATH"
+	ErrCodeQuotaExceeded        ErrorCode = "XFERD_QUOTA_EXCEEDED"
+	ErrCodeInsufficientSpace    ErrorCode = "XFERD_INSUFFICIENT_SPACE"
+	ErrCodeRateLimited          ErrorCode = "XFERD_RATE_LIMITED"
+	ErrCodePayloadTooLarge      ErrorCode = "XFERD_PAYLOAD_TOO_LARGE"
+	ErrCodeChecksumMismatch     ErrorCode = "XFERD_CHECKSUM_MISMATCH"
+	ErrCodeStorageError         ErrorCode = "XFERD_STORAGE_ERROR"
+	ErrCodeNotReady             ErrorCode = "XFERD_NOT_READY"
+	ErrCodeHistoryDisabled      ErrorCode = "XFERD_HISTORY_DISABLED"
+	ErrCodeFileStateDisabled    ErrorCode = "XFERD_FILE_STATE_DISABLED"
+	ErrCodeCaptureDisabled      ErrorCode = "XFERD_CAPTURE_DISABLED"
+	ErrCodeDraining             ErrorCode = "XFERD_DRAINING"
+	ErrCodeShadowDisabled       ErrorCode = "XFERD_SHADOW_DISABLED"
+	ErrCodeFileExists           ErrorCode = "XFERD_FILE_EXISTS"
+	ErrCodeConfirmationRequired ErrorCode = "XFERD_CONFIRMATION_REQUIRED"
+	ErrCodeConfirmationInvalid  ErrorCode = "XFERD_CONFIRMATION_INVALID"
+	ErrCodeInternalError        ErrorCode = "XFERD_INTERNAL_ERROR"
+)
+
+// ErrorResponse is the JSON body returned for failed requests
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes a single API error
+type ErrorDetail struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// writeError writes a JSON error response and logs it with its code
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	log.Printf("Request failed [%s] %s %s from %s: %d %s", code, r.Method, r.URL.Path, r.RemoteAddr, status, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
@@ -0,0 +1,97 @@
+package ingress
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestWriteError(t *testing.T) {
+	req := httptest.NewRequest("POST", "/upload/test", nil)
+	w := httptest.NewRecorder()
+
+	writeError(w, req, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %s", ct)
+	}
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	if resp.Error.Code != ErrCodeUnknownDirectory {
+		t.Errorf("Expected code %s, got %s", ErrCodeUnknownDirectory, resp.Error.Code)
+	}
+	if resp.Error.Message != "Unknown directory" {
+		t.Errorf("Expected message 'Unknown directory', got %q", resp.Error.Message)
+	}
+}
+
+func TestUploadErrorCodes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+	}
+	dirs := []config.DirectoryConfig{
+		{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	multipartBody := func(filename string) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", filename)
+		_, _ = part.Write([]byte("test content"))
+		writer.Close()
+		return body, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		filename string
+		wantCode ErrorCode
+	}{
+		{"MethodNotAllowed", "GET", "/upload/test", "", ErrCodeMethodNotAllowed},
+		{"DirectoryRequired", "POST", "/upload/", "test.txt", ErrCodeDirectoryRequired},
+		{"UnknownDirectory", "POST", "/upload/missing", "test.txt", ErrCodeUnknownDirectory},
+		{"InvalidFilename", "POST", "/upload/test", "..", ErrCodeInvalidFilename},
+		{"InvalidPath", "POST", "/upload/test/a//b", "test.txt", ErrCodeInvalidPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := multipartBody(tt.filename)
+			req := httptest.NewRequest(tt.method, tt.target, body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+
+			server.handleUpload(w, req)
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if resp.Error.Code != tt.wantCode {
+				t.Errorf("Expected code %s, got %s", tt.wantCode, resp.Error.Code)
+			}
+		})
+	}
+}
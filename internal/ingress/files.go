@@ -0,0 +1,83 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/muzy/xferd/internal/filestate"
+)
+
+// filesResponse is the GET /files response
+type filesResponse struct {
+	Files []filestate.File `json:"files"`
+}
+
+// SetFileState serves file lifecycles from a file state store on /files.
+// Must be called before Start.
+func (s *Server) SetFileState(store *filestate.Store) {
+	s.files = store
+}
+
+// handleFiles looks up the lifecycle of files
+// URL format: /files?dir={directory}&path={text}&since={time}&limit={n}
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.files == nil {
+		writeError(w, r, http.StatusNotFound, ErrCodeFileStateDisabled, "File state tracking is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	query := filestate.Query{Path: params.Get("path"), Limit: defaultHistoryLimit}
+	if dirName := params.Get("dir"); dirName != "" {
+		if _, exists := s.lookupDirectory(r, dirName); !exists {
+			writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+			return
+		}
+		if !isDirectoryAllowed(r, dirName) {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+			return
+		}
+		query.Directories = []string{dirName}
+	} else {
+		// Without a directory, search every directory the client may see
+		query.Directories = s.allowedDirectories(r)
+		if len(query.Directories) == 0 {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+			return
+		}
+	}
+	if since := params.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid since: %s", since))
+			return
+		}
+		query.Since = t
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid limit: %s", limit))
+			return
+		}
+		query.Limit = min(n, maxHistoryLimit)
+	}
+
+	files, err := s.files.Lookup(query)
+	if err != nil {
+		log.Printf("File state lookup failed: %v", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternalError, "File state lookup failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(filesResponse{Files: files})
+}
@@ -0,0 +1,85 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/filestate"
+)
+
+func TestHandleFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	dirs := []config.DirectoryConfig{
+		{Name: "invoices", WatchPath: filepath.Join(tmpDir, "invoices")},
+		{Name: "reports", WatchPath: filepath.Join(tmpDir, "reports")},
+	}
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Without a file state store the endpoint reports it is disabled
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/files", nil))
+	var errResp ErrorResponse
+	_ = json.NewDecoder(w.Body).Decode(&errResp)
+	if w.Code != http.StatusNotFound || errResp.Error.Code != ErrCodeFileStateDisabled {
+		t.Errorf("Expected 404 %s without file state, got %d %s", ErrCodeFileStateDisabled, w.Code, errResp.Error.Code)
+	}
+
+	store, err := filestate.Open(config.FileStateConfig{Enabled: true, Path: filepath.Join(tmpDir, "files.jsonl")})
+	if err != nil {
+		t.Fatalf("Failed to open file state: %v", err)
+	}
+	defer store.Close()
+	store.Record(filestate.Event{Directory: "invoices", Path: "/data/invoices/a.csv", Stage: filestate.StageEnqueued})
+	store.Record(filestate.Event{Directory: "invoices", Path: "/data/invoices/a.csv", Stage: filestate.StageUploaded})
+	store.Record(filestate.Event{Directory: "reports", Path: "/data/reports/a.csv", Stage: filestate.StageDetected})
+	server.SetFileState(store)
+
+	lookup := func(r *http.Request) (int, []filestate.File) {
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, r)
+		var resp filesResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, resp.Files
+	}
+
+	if code, files := lookup(httptest.NewRequest("GET", "/files?path=a.csv", nil)); code != http.StatusOK || len(files) != 2 {
+		t.Errorf("Expected 2 files, got %d %+v", code, files)
+	}
+	if code, files := lookup(httptest.NewRequest("GET", "/files?dir=invoices", nil)); code != http.StatusOK ||
+		len(files) != 1 || files[0].Stage != filestate.StageUploaded || len(files[0].Stages) != 2 {
+		t.Errorf("Expected the invoices lifecycle, got %d %+v", code, files)
+	}
+	if code, files := lookup(httptest.NewRequest("GET", "/files?path=missing", nil)); code != http.StatusOK || files == nil || len(files) != 0 {
+		t.Errorf("Expected an empty list, got %d %+v", code, files)
+	}
+	if code, _ := lookup(httptest.NewRequest("GET", "/files?limit=0", nil)); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid limit, got %d", code)
+	}
+	if code, _ := lookup(httptest.NewRequest("GET", "/files?dir=unknown", nil)); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown directory, got %d", code)
+	}
+
+	// Clients restricted to some directories only see their files
+	restricted := httptest.NewRequest("GET", "/files", nil)
+	restricted = restricted.WithContext(context.WithValue(restricted.Context(), allowedDirsKey, []string{"reports"}))
+	w = httptest.NewRecorder()
+	server.handleFiles(w, restricted)
+	var resp filesResponse
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Files) != 1 || resp.Files[0].Directory != "reports" {
+		t.Errorf("Expected only the reports file, got %+v", resp.Files)
+	}
+}
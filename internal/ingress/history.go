@@ -0,0 +1,124 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/muzy/xferd/internal/history"
+)
+
+// History search limits
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// historyResponse is the GET /history response
+type historyResponse struct {
+	Transfers []history.Entry `json:"transfers"`
+}
+
+// SetHistory serves transfers from a history store on /history. Must be
+// called before Start.
+func (s *Server) SetHistory(store *history.Store) {
+	s.history = store
+}
+
+// handleHistory searches completed transfers
+// URL format: /history?dir={directory}&q={text}&since={time}&limit={n}
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.history == nil {
+		writeError(w, r, http.StatusNotFound, ErrCodeHistoryDisabled, "Transfer history is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	query := history.Query{Text: params.Get("q"), Limit: defaultHistoryLimit}
+	if dirName := params.Get("dir"); dirName != "" {
+		if _, exists := s.lookupDirectory(r, dirName); !exists {
+			writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+			return
+		}
+		if !isDirectoryAllowed(r, dirName) {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+			return
+		}
+		query.Directories = []string{dirName}
+	} else {
+		// Without a directory, search every directory the client may see
+		query.Directories = s.allowedDirectories(r)
+		if len(query.Directories) == 0 {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+			return
+		}
+	}
+	if since := params.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid since: %s", since))
+			return
+		}
+		query.Since = t
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid limit: %s", limit))
+			return
+		}
+		query.Limit = min(n, maxHistoryLimit)
+	}
+
+	transfers, err := s.history.Search(query)
+	if err != nil {
+		log.Printf("History search failed: %v", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternalError, "History search failed")
+		return
+	}
+	if transfers == nil {
+		transfers = []history.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(historyResponse{Transfers: transfers})
+}
+
+// allowedDirectories returns the configured directories a request may access
+func (s *Server) allowedDirectories(r *http.Request) []string {
+	s.mu.RLock()
+	directories := s.directories
+	s.mu.RUnlock()
+
+	var names []string
+	for name := range directories {
+		if isDirectoryAllowed(r, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseSince parses an RFC 3339 timestamp, a date or a duration before now
+// such as 720h
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid since: %s", value)
+	}
+	return time.Now().Add(-d), nil
+}
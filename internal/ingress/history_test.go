@@ -0,0 +1,90 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/history"
+)
+
+func TestHandleHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	dirs := []config.DirectoryConfig{
+		{Name: "invoices", WatchPath: filepath.Join(tmpDir, "invoices")},
+		{Name: "reports", WatchPath: filepath.Join(tmpDir, "reports")},
+	}
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Without a history store the endpoint reports it is disabled
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/history", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without history, got %d", w.Code)
+	}
+
+	store, err := history.Open(config.HistoryConfig{Enabled: true, Path: filepath.Join(tmpDir, "history.jsonl")})
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	defer store.Close()
+	store.Record(history.Entry{Directory: "invoices", Path: "/data/invoices/a.csv", Status: history.StatusDelivered})
+	store.Record(history.Entry{Directory: "reports", Path: "/data/reports/a.csv", Status: history.StatusDelivered})
+	server.SetHistory(store)
+
+	search := func(r *http.Request) (int, []history.Entry) {
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, r)
+		var resp historyResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, resp.Transfers
+	}
+
+	if code, transfers := search(httptest.NewRequest("GET", "/history?q=a.csv", nil)); code != http.StatusOK || len(transfers) != 2 {
+		t.Errorf("Expected 2 transfers, got %d %+v", code, transfers)
+	}
+	if code, transfers := search(httptest.NewRequest("GET", "/history?dir=invoices&since=24h", nil)); code != http.StatusOK ||
+		len(transfers) != 1 || transfers[0].Directory != "invoices" {
+		t.Errorf("Expected the invoices transfer, got %d %+v", code, transfers)
+	}
+	if code, transfers := search(httptest.NewRequest("GET", "/history?since=2999-01-01", nil)); code != http.StatusOK || transfers == nil || len(transfers) != 0 {
+		t.Errorf("Expected an empty list, got %d %+v", code, transfers)
+	}
+	if code, _ := search(httptest.NewRequest("GET", "/history?since=yesterday", nil)); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid since, got %d", code)
+	}
+	if code, _ := search(httptest.NewRequest("GET", "/history?dir=unknown", nil)); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown directory, got %d", code)
+	}
+
+	// Clients restricted to some directories only see their transfers
+	restricted := httptest.NewRequest("GET", "/history", nil)
+	restricted = restricted.WithContext(context.WithValue(restricted.Context(), allowedDirsKey, []string{"reports"}))
+	w = httptest.NewRecorder()
+	server.handleHistory(w, restricted)
+	var resp historyResponse
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Transfers) != 1 || resp.Transfers[0].Directory != "reports" {
+		t.Errorf("Expected only the reports transfer, got %+v", resp.Transfers)
+	}
+
+	restricted = httptest.NewRequest("GET", "/history?dir=invoices", nil)
+	restricted = restricted.WithContext(context.WithValue(restricted.Context(), allowedDirsKey, []string{"reports"}))
+	w = httptest.NewRecorder()
+	server.handleHistory(w, restricted)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a directory outside the token's scope, got %d", w.Code)
+	}
+}
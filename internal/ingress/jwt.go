@@ -0,0 +1,310 @@
+package ingress
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// jwtLeeway tolerates small clock differences when checking exp/nbf
+const jwtLeeway = 30 * time.Second
+
+// jwksRefreshInterval limits how often the key set is refetched for unknown key IDs
+const jwksRefreshInterval = time.Minute
+
+// jwksFetchTimeout bounds a key set fetch
+const jwksFetchTimeout = 10 * time.Second
+
+// jwtVerifier validates HS256 and RS256 signed JSON Web Tokens
+type jwtVerifier struct {
+	config config.JWTAuthConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey // kid -> key
+	lastFetched time.Time                 // last successful fetch
+	fetching    *jwksFetch                // fetch in progress, nil if none is
+}
+
+// jwksFetch is a key set fetch shared by the requests waiting for it
+type jwksFetch struct {
+	done chan struct{} // closed once the fetch ended and its keys are in place
+	err  error         // set before done is closed
+}
+
+// jwtHeader is the decoded JOSE header
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims holds decoded token claims
+type jwtClaims map[string]interface{}
+
+// newJWTVerifier creates a verifier for the given configuration
+func newJWTVerifier(cfg config.JWTAuthConfig) *jwtVerifier {
+	return &jwtVerifier{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Verify checks the token signature and standard claims, returning the claims
+func (v *jwtVerifier) Verify(ctx context.Context, token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	switch header.Alg {
+	case "HS256":
+		if v.config.Secret == "" {
+			return nil, fmt.Errorf("HS256 tokens not accepted")
+		}
+		mac := hmac.New(sha256.New, []byte(v.config.Secret))
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, fmt.Errorf("invalid signature")
+		}
+	case "RS256":
+		if v.config.JWKSURL == "" {
+			return nil, fmt.Errorf("RS256 tokens not accepted")
+		}
+		key, keyErr := v.publicKey(ctx, header.Kid)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validateClaims checks expiry, not-before, issuer and audience. Tokens
+// without expiry are rejected unless allow_missing_exp is set.
+func (v *jwtVerifier) validateClaims(claims jwtClaims) error {
+	now := time.Now()
+
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+			return fmt.Errorf("token expired")
+		}
+	} else if !v.config.AllowMissingExp {
+		return fmt.Errorf("token has no expiry")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+			return fmt.Errorf("token not yet valid")
+		}
+	}
+
+	if v.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+			return fmt.Errorf("invalid issuer")
+		}
+	}
+
+	if v.config.Audience != "" && !slices.Contains(claimStrings(claims["aud"]), v.config.Audience) {
+		return fmt.Errorf("invalid audience")
+	}
+
+	return nil
+}
+
+// AllowedDirectories returns the directories permitted by the configured claim.
+// A nil result means no restriction applies.
+func (v *jwtVerifier) AllowedDirectories(claims jwtClaims) []string {
+	if v.config.DirsClaim == "" {
+		return nil
+	}
+	dirs := claimStrings(claims[v.config.DirsClaim])
+	if dirs == nil {
+		return []string{} // Claim missing: no directories permitted
+	}
+	return dirs
+}
+
+// publicKey returns the RSA key for a key ID, refetching the key set if
+// unknown. The key set is fetched in the background, so tokens with known
+// keys are verified meanwhile and a client giving up does not abort the
+// fetch; requests for unknown keys wait for the fetch in progress.
+func (v *jwtVerifier) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	if key, ok := v.lookupKey(kid); ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+	fetch := v.fetching
+	if fetch == nil {
+		if time.Since(v.lastFetched) < jwksRefreshInterval {
+			v.mu.Unlock()
+			return nil, fmt.Errorf("unknown key id: %s", kid)
+		}
+		fetch = &jwksFetch{done: make(chan struct{})}
+		v.fetching = fetch
+		go v.refreshKeys(fetch)
+	}
+	v.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if fetch.err != nil {
+		return nil, fetch.err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// refreshKeys fetches the key set for fetch, detached from the requests
+// waiting for it. Only a successful fetch delays the next one by
+// jwksRefreshInterval.
+func (v *jwtVerifier) refreshKeys(fetch *jwksFetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := v.fetchKeys(ctx)
+
+	v.mu.Lock()
+	if err == nil {
+		v.keys = keys
+		v.lastFetched = time.Now()
+	}
+	fetch.err = err
+	v.fetching = nil
+	v.mu.Unlock()
+	close(fetch.done)
+}
+
+// lookupKey finds a cached key; tokens without kid match a single-key set
+func (v *jwtVerifier) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if key, ok := v.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchKeys downloads and parses the JWKS document
+func (v *jwtVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKSURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, nErr := base64.RawURLEncoding.DecodeString(k.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(k.E)
+		if nErr != nil || eErr != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// decodeJWTSegment decodes a base64url JSON segment
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings converts a string or string-array claim into a slice
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,364 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// signHS256 builds an HS256 token for the given claims
+func signHS256(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	input := encodeJWTSegment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeJWTSegment(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signRS256 builds an RS256 token for the given claims
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	input := encodeJWTSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeJWTSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func encodeJWTSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal segment: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestJWTVerifyHS256(t *testing.T) {
+	verifier := newJWTVerifier(config.JWTAuthConfig{
+		Enabled:  true,
+		Secret:   "shared-secret",
+		Issuer:   "https://idp.example.com",
+		Audience: "xferd",
+	})
+
+	valid := map[string]interface{}{
+		"iss": "https://idp.example.com",
+		"aud": []string{"xferd", "other"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"Valid", signHS256(t, "shared-secret", valid), false},
+		{"WrongSecret", signHS256(t, "other-secret", valid), true},
+		{"Malformed", "not-a-token", true},
+		{"Expired", signHS256(t, "shared-secret", map[string]interface{}{
+			"iss": "https://idp.example.com", "aud": "xferd", "exp": time.Now().Add(-time.Hour).Unix(),
+		}), true},
+		{"NoExpiry", signHS256(t, "shared-secret", map[string]interface{}{
+			"iss": "https://idp.example.com", "aud": "xferd",
+		}), true},
+		{"NotYetValid", signHS256(t, "shared-secret", map[string]interface{}{
+			"iss": "https://idp.example.com", "aud": "xferd", "exp": time.Now().Add(2 * time.Hour).Unix(), "nbf": time.Now().Add(time.Hour).Unix(),
+		}), true},
+		{"WrongIssuer", signHS256(t, "shared-secret", map[string]interface{}{
+			"iss": "https://evil.example.com", "aud": "xferd", "exp": time.Now().Add(time.Hour).Unix(),
+		}), true},
+		{"WrongAudience", signHS256(t, "shared-secret", map[string]interface{}{
+			"iss": "https://idp.example.com", "aud": "someone-else", "exp": time.Now().Add(time.Hour).Unix(),
+		}), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTAllowMissingExp(t *testing.T) {
+	verifier := newJWTVerifier(config.JWTAuthConfig{Enabled: true, Secret: "shared-secret", AllowMissingExp: true})
+	if _, err := verifier.Verify(context.Background(), signHS256(t, "shared-secret", map[string]interface{}{"sub": "client"})); err != nil {
+		t.Errorf("Expected token without exp to be accepted, got: %v", err)
+	}
+	expired := signHS256(t, "shared-secret", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})
+	if _, err := verifier.Verify(context.Background(), expired); err == nil {
+		t.Error("Expected expired token to be rejected")
+	}
+}
+
+func TestJWTVerifyRS256WithJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	verifier := newJWTVerifier(config.JWTAuthConfig{Enabled: true, JWKSURL: jwks.URL})
+
+	token := signRS256(t, key, "key-1", map[string]interface{}{"sub": "client", "exp": time.Now().Add(time.Hour).Unix()})
+	claims, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Expected valid RS256 token, got: %v", err)
+	}
+	if claims["sub"] != "client" {
+		t.Errorf("Expected sub claim 'client', got %v", claims["sub"])
+	}
+
+	// HS256 tokens must not be accepted when only a JWKS is configured
+	if _, err := verifier.Verify(context.Background(), signHS256(t, "x", map[string]interface{}{})); err == nil {
+		t.Error("Expected HS256 token to be rejected")
+	}
+
+	// Unknown key IDs are rejected
+	if _, err := verifier.Verify(context.Background(), signRS256(t, key, "key-2", map[string]interface{}{})); err == nil {
+		t.Error("Expected unknown kid to be rejected")
+	}
+}
+
+func TestJWTKnownKeysDuringJWKSFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release // the refetch for an unknown key hangs
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+	defer close(release)
+
+	verifier := newJWTVerifier(config.JWTAuthConfig{Enabled: true, JWKSURL: jwks.URL})
+	known := signRS256(t, key, "key-1", map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := verifier.Verify(context.Background(), known); err != nil {
+		t.Fatalf("Expected valid RS256 token, got: %v", err)
+	}
+
+	// Allow a refetch and start one for an unknown key
+	verifier.mu.Lock()
+	verifier.lastFetched = time.Time{}
+	verifier.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = verifier.Verify(ctx, signRS256(t, key, "key-2", map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for fetches.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Key set was not refetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(context.Background(), known)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected known key to verify during the fetch, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Verification with a known key waited for the key set fetch")
+	}
+
+	// A second unknown key waits for the fetch in progress rather than fetching again
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	if _, err := verifier.Verify(waitCtx, signRS256(t, key, "key-3", map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected to wait for the fetch in progress, got: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("Expected 2 key set fetches, got %d", n)
+	}
+}
+
+func TestJWTJWKSFetchOutlivesRequest(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch fetches.Add(1) {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		case 2:
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	verifier := newJWTVerifier(config.JWTAuthConfig{Enabled: true, JWKSURL: jwks.URL})
+	token := signRS256(t, key, "key-1", map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+
+	// A failed fetch does not hold off the next one
+	if _, err := verifier.Verify(context.Background(), token); err == nil {
+		t.Fatal("Expected the token to be rejected while the key set is unavailable")
+	}
+
+	// The client giving up does not abort the fetch it started
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for fetches.Load() < 2 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := verifier.Verify(ctx, token); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled request to give up, got %v", err)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := verifier.Verify(context.Background(), token)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the key set fetched for the cancelled request, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("Expected 2 key set fetches, got %d", n)
+	}
+}
+
+func TestJWTAuthDirectoryRestriction(t *testing.T) {
+	tmpDir := t.TempDir()
+	invoicesDir := filepath.Join(tmpDir, "invoices")
+	reportsDir := filepath.Join(tmpDir, "reports")
+	for _, dir := range []string{invoicesDir, reportsDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+		JWTAuth: config.JWTAuthConfig{
+			Enabled:   true,
+			Secret:    "shared-secret",
+			DirsClaim: "dirs",
+		},
+	}
+
+	dirs := []config.DirectoryConfig{
+		{Name: "invoices", WatchPath: invoicesDir},
+		{Name: "reports", WatchPath: reportsDir},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	token := signHS256(t, "shared-secret", map[string]interface{}{"dirs": []string{"invoices"}, "exp": time.Now().Add(time.Hour).Unix()})
+
+	upload := func(target, authorization string) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		_, _ = part.Write([]byte("test content"))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload/"+target, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+
+		server.withAuth(server.handleUpload)(w, req)
+		return w.Result().StatusCode
+	}
+
+	if status := upload("invoices", "Bearer "+token); status != http.StatusOK {
+		t.Errorf("Expected status 200 for permitted directory, got %d", status)
+	}
+
+	if status := upload("reports", "Bearer "+token); status != http.StatusForbidden {
+		t.Errorf("Expected status 403 for forbidden directory, got %d", status)
+	}
+
+	if status := upload("invoices", "Bearer invalid"); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for invalid token, got %d", status)
+	}
+
+	if status := upload("invoices", ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %d", status)
+	}
+
+	// Tokens without the claim are not permitted any directory
+	noClaim := signHS256(t, "shared-secret", map[string]interface{}{"sub": "client", "exp": time.Now().Add(time.Hour).Unix()})
+	if status := upload("invoices", "Bearer "+noClaim); status != http.StatusForbidden {
+		t.Errorf("Expected status 403 without dirs claim, got %d", status)
+	}
+}
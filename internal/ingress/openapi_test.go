@@ -0,0 +1,128 @@
+package ingress
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// openAPIDocument is the subset of the OpenAPI document checked by the tests
+type openAPIDocument struct {
+	OpenAPI    string                    `json:"openapi"`
+	Paths      map[string]map[string]any `json:"paths"`
+	Components struct {
+		Schemas struct {
+			ErrorCode struct {
+				Enum []string `json:"enum"`
+			} `json:"ErrorCode"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	dirs := []config.DirectoryConfig{{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")}}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %s", ct)
+	}
+
+	var doc openAPIDocument
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode OpenAPI document: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("Expected OpenAPI 3.1.0, got %s", doc.OpenAPI)
+	}
+
+	expectedPaths := map[string]string{
+		"/upload/{directory}":          "post",
+		"/upload/{directory}/{path}":   "post",
+		"/validate/{directory}":        "post",
+		"/validate/{directory}/{path}": "post",
+		"/health":                      "get",
+		"/ready":                       "get",
+		"/history":                     "get",
+		"/files":                       "get",
+		"/shadow":                      "get",
+		"/shadow/restore":              "post",
+		"/drain":                       "post",
+		"/debug/capture":               "post",
+		"/metrics":                     "get",
+		"/openapi.json":                "get",
+	}
+	for path, method := range expectedPaths {
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("Expected %s %s to be documented", method, path)
+		}
+	}
+
+	req = httptest.NewRequest("POST", "/openapi.json", nil)
+	w = httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", w.Code)
+	}
+}
+
+func TestOpenAPIErrorCodesMatchCatalogue(t *testing.T) {
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("Failed to parse OpenAPI document: %v", err)
+	}
+
+	// Collect the ErrorCode constants declared in errors.go
+	file, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse errors.go: %v", err)
+	}
+	var codes []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || spec.Type == nil || len(spec.Values) != 1 {
+			return true
+		}
+		if ident, ok := spec.Type.(*ast.Ident); !ok || ident.Name != "ErrorCode" {
+			return true
+		}
+		if lit, ok := spec.Values[0].(*ast.BasicLit); ok {
+			code, _ := strconv.Unquote(lit.Value)
+			codes = append(codes, code)
+		}
+		return true
+	})
+
+	if len(codes) == 0 {
+		t.Fatal("Expected to find error codes in errors.go")
+	}
+	for _, code := range codes {
+		if !slices.Contains(doc.Components.Schemas.ErrorCode.Enum, code) {
+			t.Errorf("Error code %s is missing from openapi.json", code)
+		}
+	}
+	if len(doc.Components.Schemas.ErrorCode.Enum) != len(codes) {
+		t.Errorf("Expected %d documented error codes, got %d", len(codes), len(doc.Components.Schemas.ErrorCode.Enum))
+	}
+}
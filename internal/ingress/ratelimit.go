@@ -0,0 +1,209 @@
+package ingress
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// clientIdleTimeout is how long an idle client's limiter state is kept
+const clientIdleTimeout = 10 * time.Minute
+
+// tokenBucket is a token bucket refilled continuously at rate tokens per second
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket, or nil if the rate is unlimited
+func newTokenBucket(rule config.RateLimitRule, now time.Time) *tokenBucket {
+	if rule.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(rule.GetBurst())
+	return &tokenBucket{rate: rule.RequestsPerSecond, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds the tokens accrued since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait returns how long until a token is available (zero if one is available now)
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// clientLimit holds the limiter state for a single client IP
+type clientLimit struct {
+	bucket   *tokenBucket
+	uploads  int
+	lastSeen time.Time
+}
+
+// rateLimiter enforces global and per-client request rates and upload concurrency
+type rateLimiter struct {
+	config config.RateLimitConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	global    *tokenBucket
+	uploads   int
+	clients   map[string]*clientLimit
+	lastPrune time.Time
+}
+
+// newRateLimiter creates a rate limiter for the given configuration
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		config:    cfg,
+		now:       time.Now,
+		global:    newTokenBucket(cfg.Global, now),
+		clients:   make(map[string]*clientLimit),
+		lastPrune: now,
+	}
+}
+
+// allow takes a request token for the client. If the request is rejected it
+// returns the time until a retry may succeed and the reason.
+func (l *rateLimiter) allow(ip string) (time.Duration, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	client := l.client(ip, now)
+
+	// Check both buckets before taking from either
+	if client.bucket != nil {
+		client.bucket.refill(now)
+		if wait := client.bucket.wait(); wait > 0 {
+			return wait, "client request rate exceeded"
+		}
+	}
+	if l.global != nil {
+		l.global.refill(now)
+		if wait := l.global.wait(); wait > 0 {
+			return wait, "server request rate exceeded"
+		}
+	}
+
+	if client.bucket != nil {
+		client.bucket.tokens--
+	}
+	if l.global != nil {
+		l.global.tokens--
+	}
+	return 0, ""
+}
+
+// acquireUpload reserves an upload slot for the client. On success it returns
+// a function that releases the slot; otherwise it returns the rejection reason.
+func (l *rateLimiter) acquireUpload(ip string) (func(), string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client := l.client(ip, l.now())
+	if limit := l.config.PerClient.MaxConcurrentUploads; limit > 0 && client.uploads >= limit {
+		return nil, "too many concurrent uploads from client"
+	}
+	if limit := l.config.Global.MaxConcurrentUploads; limit > 0 && l.uploads >= limit {
+		return nil, "too many concurrent uploads"
+	}
+
+	client.uploads++
+	l.uploads++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			client.uploads--
+			client.lastSeen = l.now()
+			l.uploads--
+		})
+	}, ""
+}
+
+// client returns the state for ip, creating it if needed. Callers must hold l.mu.
+func (l *rateLimiter) client(ip string, now time.Time) *clientLimit {
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimit{bucket: newTokenBucket(l.config.PerClient, now)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	return client
+}
+
+// prune drops state for clients idle longer than clientIdleTimeout. Callers must hold l.mu.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for ip, client := range l.clients {
+		if client.uploads == 0 && now.Sub(client.lastSeen) > clientIdleTimeout {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// withRateLimit wraps a handler with request rate limiting and, for uploads,
+// concurrency limiting. Rejected requests receive 429 with a Retry-After header.
+func (s *Server) withRateLimit(next http.HandlerFunc, upload bool) http.HandlerFunc {
+	if s.limiter == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+
+		if wait, reason := s.limiter.allow(ip); wait > 0 {
+			rejectRateLimited(w, r, wait, reason)
+			return
+		}
+
+		if upload {
+			release, reason := s.limiter.acquireUpload(ip)
+			if release == nil {
+				rejectRateLimited(w, r, time.Second, reason)
+				return
+			}
+			defer release()
+		}
+
+		next(w, r)
+	}
+}
+
+// rejectRateLimited writes a 429 response advising the client when to retry
+func rejectRateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration, reason string) {
+	seconds := max(1, int(math.Ceil(wait.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, http.StatusTooManyRequests, ErrCodeRateLimited, fmt.Sprintf("Rate limit exceeded: %s", reason))
+}
+
+// clientIP returns the IP address of the connecting client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,177 @@
+package ingress
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestRateLimiterRequestRate(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{
+		Enabled:   true,
+		PerClient: config.RateLimitRule{RequestsPerSecond: 2},
+	})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait, reason := limiter.allow("192.0.2.1"); wait > 0 {
+			t.Fatalf("Request %d should be allowed, got: %s", i+1, reason)
+		}
+	}
+
+	wait, _ := limiter.allow("192.0.2.1")
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("Expected third request to be rejected with wait <= 1s, got %v", wait)
+	}
+
+	// Other clients have their own bucket
+	if wait, _ := limiter.allow("192.0.2.2"); wait > 0 {
+		t.Error("Expected request from another client to be allowed")
+	}
+
+	// Tokens refill over time
+	now = now.Add(500 * time.Millisecond)
+	if wait, _ := limiter.allow("192.0.2.1"); wait > 0 {
+		t.Error("Expected request to be allowed after refill")
+	}
+}
+
+func TestRateLimiterGlobalRate(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{
+		Enabled: true,
+		Global:  config.RateLimitRule{RequestsPerSecond: 1, Burst: 2},
+	})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	limiter.allow("192.0.2.1")
+	limiter.allow("192.0.2.2")
+	if wait, reason := limiter.allow("192.0.2.3"); wait <= 0 {
+		t.Error("Expected global rate limit to reject third client")
+	} else if reason != "server request rate exceeded" {
+		t.Errorf("Unexpected reason: %s", reason)
+	}
+}
+
+func TestRateLimiterConcurrentUploads(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{
+		Enabled:   true,
+		Global:    config.RateLimitRule{MaxConcurrentUploads: 2},
+		PerClient: config.RateLimitRule{MaxConcurrentUploads: 1},
+	})
+
+	release1, _ := limiter.acquireUpload("192.0.2.1")
+	if release1 == nil {
+		t.Fatal("Expected first upload slot to be granted")
+	}
+	if release, _ := limiter.acquireUpload("192.0.2.1"); release != nil {
+		t.Error("Expected per-client concurrency limit to reject second upload")
+	}
+
+	release2, _ := limiter.acquireUpload("192.0.2.2")
+	if release2 == nil {
+		t.Fatal("Expected upload slot for second client")
+	}
+	if release, _ := limiter.acquireUpload("192.0.2.3"); release != nil {
+		t.Error("Expected global concurrency limit to reject third client")
+	}
+
+	// Releasing twice must not free extra slots
+	release1()
+	release1()
+	if release, _ := limiter.acquireUpload("192.0.2.1"); release == nil {
+		t.Error("Expected upload slot after release")
+	}
+	if release, _ := limiter.acquireUpload("192.0.2.3"); release != nil {
+		t.Error("Expected global limit to still apply after double release")
+	}
+	release2()
+}
+
+func TestRateLimiterPrunesIdleClients(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{
+		Enabled:   true,
+		PerClient: config.RateLimitRule{RequestsPerSecond: 1},
+	})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	limiter.allow("192.0.2.1")
+	now = now.Add(clientIdleTimeout + time.Minute)
+	limiter.allow("192.0.2.2")
+
+	if _, ok := limiter.clients["192.0.2.1"]; ok {
+		t.Error("Expected idle client to be pruned")
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+		RateLimit: config.RateLimitConfig{
+			Enabled:   true,
+			PerClient: config.RateLimitRule{RequestsPerSecond: 1},
+		},
+	}
+
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	handler := server.withRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, true)
+
+	req := httptest.NewRequest("POST", "/upload/test", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Error.Code != ErrCodeRateLimited {
+		t.Errorf("Expected code %s, got %s", ErrCodeRateLimited, resp.Error.Code)
+	}
+}
+
+func TestWithRateLimitDisabled(t *testing.T) {
+	server, err := NewServer(config.ServerConfig{Port: 8080, TempDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	handler := server.withRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, true)
+
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/upload/test", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i+1, w.Code)
+		}
+	}
+}
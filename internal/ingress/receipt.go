@@ -0,0 +1,64 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadResponse acknowledges a stored upload, for clients to keep for
+// reconciliation
+type UploadResponse struct {
+	ReceiptID  string    `json:"receipt_id"`  // generated by the server for every stored file
+	TransferID string    `json:"transfer_id"` // the request ID, correlating the upload with its delivery in logs, history and journal
+	Directory  string    `json:"directory"`
+	Filename   string    `json:"filename"`
+	Path       string    `json:"path"`     // stored file relative to the ingest directory
+	Size       int64     `json:"size"`     // bytes stored
+	Checksum   string    `json:"checksum"` // hex-encoded SHA-256 of the stored content
+	Received   time.Time `json:"received"` // when the file was stored, in UTC
+}
+
+// writeUploadResponse acknowledges a stored upload with JSON, or with the
+// plain text line of earlier versions if the client prefers text/plain
+func writeUploadResponse(w http.ResponseWriter, r *http.Request, resp UploadResponse) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if prefersText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Upload successful: %s\n", resp.Filename)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// prefersText reports whether an Accept header ranks text/plain above
+// application/json. Without a preference, JSON is used.
+func prefersText(accept string) bool {
+	var textQ, jsonQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/plain", "text/*":
+			textQ = max(textQ, q)
+		case "application/json", "application/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return textQ > jsonQ
+}
@@ -0,0 +1,109 @@
+package ingress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestUploadResponse(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	upload := func(name, accept string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		_, _ = part.Write([]byte("receipt content"))
+		_ = writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload/test/2026", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Request-ID", "req-"+name)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.handleUpload(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	before := time.Now().UTC().Add(-time.Second)
+	w := upload("a.txt", "")
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %s", ct)
+	}
+	var resp UploadResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode upload response: %v", err)
+	}
+	sum := sha256.Sum256([]byte("receipt content"))
+	if resp.ReceiptID == "" || resp.TransferID != "req-a.txt" || resp.Directory != "test" || resp.Filename != "a.txt" ||
+		resp.Path != "2026/a.txt" || resp.Size != 15 || resp.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected upload response: %+v", resp)
+	}
+	if resp.Received.Before(before) || resp.Received.Location() != time.UTC {
+		t.Errorf("Expected a recent UTC receive time, got %v", resp.Received)
+	}
+
+	var second UploadResponse
+	_ = json.NewDecoder(upload("b.txt", "application/json").Body).Decode(&second)
+	if second.ReceiptID == "" || second.ReceiptID == resp.ReceiptID {
+		t.Errorf("Expected a new receipt ID per upload, got %q and %q", resp.ReceiptID, second.ReceiptID)
+	}
+
+	w = upload("c.txt", "text/plain")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected Content-Type text/plain, got %s", ct)
+	}
+	if w.Body.String() != "Upload successful: c.txt\n" {
+		t.Errorf("Unexpected plain text response: %q", w.Body.String())
+	}
+}
+
+func TestPrefersText(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/plain", true},
+		{"text/*", true},
+		{"text/plain, application/json", false},
+		{"application/json;q=0.5, text/plain", true},
+		{"text/plain;q=0.2, */*;q=0.1", true},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", false},
+	}
+	for _, tt := range tests {
+		if got := prefersText(tt.accept); got != tt.want {
+			t.Errorf("prefersText(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
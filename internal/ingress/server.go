@@ -4,28 +4,91 @@ import (
 	"context"
 	"crypto/subtle"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/muzy/xferd/internal/capture"
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/diskspace"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/history"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/storage"
+	"github.com/muzy/xferd/internal/transferid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Server handles REST ingress for file uploads
 type Server struct {
 	config      config.ServerConfig
-	directories map[string]config.DirectoryConfig // name -> config
+	directories map[string]config.DirectoryConfig // name -> config, replaced as a whole under mu
 	httpServer  *http.Server
+	jwt         *jwtVerifier                               // nil unless JWT auth is enabled
+	limiter     *rateLimiter                               // nil unless rate limiting is enabled
+	accessLog   *accessLogger                              // nil unless access logging is enabled
+	history     *history.Store                             // nil unless the transfer history is enabled
+	files       *filestate.Store                           // nil unless file lifecycles are tracked
+	capture     *capture.Recorder                          // nil unless outbound capture is configured
+	status      func() any                                 // builds the /status response, set by the service
+	ready       func(ctx context.Context) []ReadinessCheck // runs the /ready checks, set by the service
+	storage     storage.Storage                            // where ingested files are written
+	dirStorage  map[string]storage.Storage                 // per-directory overrides, e.g. passthrough; replaced as a whole under mu
+	drain       func()                                     // starts draining the service, set by the service
+	disk        *diskspace.Monitor                         // nil unless disk_space is enabled
+	draining    atomic.Bool                                // uploads are rejected while the service drains
+	confirms    *confirmations                             // nil unless admin_confirmation is enabled
 	mu          sync.RWMutex
 }
 
+// contextKey is the type for request context keys set by the server
+type contextKey int
+
+const (
+	// allowedDirsKey holds the directory names a request is restricted to
+	allowedDirsKey contextKey = iota
+	// listenerNameKey holds the name of the listener a request arrived on
+	listenerNameKey
+	// requestIDKey holds the request ID assigned by the access log
+	requestIDKey
+	// authenticatedKey holds the identity of a request that presented valid credentials
+	authenticatedKey
+)
+
+// namedListener tags accepted connections with the listener name
+type namedListener struct {
+	net.Listener
+	name string
+}
+
+// namedConn is a connection accepted on a named listener
+type namedConn struct {
+	net.Conn
+	listener string
+}
+
+// Accept wraps the accepted connection with the listener name
+func (l *namedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &namedConn{Conn: c, listener: l.name}, nil
+}
+
 // NewServer creates a new REST ingress server
 func NewServer(cfg config.ServerConfig, directories []config.DirectoryConfig) (*Server, error) {
 	// Create temp directory if it doesn't exist
@@ -42,24 +105,129 @@ func NewServer(cfg config.ServerConfig, directories []config.DirectoryConfig) (*
 	s := &Server{
 		config:      cfg,
 		directories: dirMap,
+		storage:     storage.NewLocal(cfg.TempDir),
+	}
+	if cfg.JWTAuth.Enabled {
+		s.jwt = newJWTVerifier(cfg.JWTAuth)
+	}
+	if cfg.RateLimit.Enabled {
+		s.limiter = newRateLimiter(cfg.RateLimit)
+	}
+	if cfg.AdminConfirmation.Enabled {
+		s.confirms = newConfirmations(cfg.AdminConfirmation)
 	}
 
 	// Setup HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/upload/", s.withAuth(s.handleUpload))
+	mux.HandleFunc("/upload/", s.withDrain(s.withRateLimit(s.withAuth(s.handleUpload), true)))
+	mux.HandleFunc("/validate/", s.withDrain(s.withRateLimit(s.withAuth(s.handleValidate), false)))
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+	mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("/status", s.withAuth(s.handleStatus))
+	mux.HandleFunc("/history", s.withAuth(s.handleHistory))
+	mux.HandleFunc("/files", s.withAuth(s.handleFiles))
+	mux.HandleFunc("/shadow", s.withAuth(s.handleShadow))
+	mux.HandleFunc("/shadow/restore", s.withAuth(s.handleShadowRestore))
+	mux.HandleFunc("/drain", s.withAuth(s.handleDrain))
+	mux.HandleFunc("/debug/capture", s.withAuth(s.handleCapture))
+
+	var handler http.Handler = mux
+	if cfg.AccessLog.Enabled {
+		accessLog, err := newAccessLogger(cfg.AccessLog)
+		if err != nil {
+			return nil, err
+		}
+		s.accessLog = accessLog
+		handler = accessLog.wrap(mux)
+	}
 
-	addr := fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
+	addr := net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port))
 	s.httpServer = &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  30 * time.Minute, // Long timeout for large file uploads
 		WriteTimeout: 30 * time.Minute,
+		ConnContext:  connContext,
 	}
 
 	return s, nil
 }
 
+// SetStorage replaces the local disk storage ingested files are written to.
+// Must be called before Start.
+func (s *Server) SetStorage(st storage.Storage) {
+	s.storage = st
+}
+
+// SetDiskMonitor sets the monitor uploads are rejected by while the temp or
+// ingest volume is low on space. Must be called before Start.
+func (s *Server) SetDiskMonitor(m *diskspace.Monitor) {
+	s.disk = m
+}
+
+// SetDirectoryStorage sets the storage for uploads to one directory
+func (s *Server) SetDirectoryStorage(name string, st storage.Storage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirStorage = withEntry(s.dirStorage, name, st)
+}
+
+// SetDirectory adds or replaces a directory while the server runs. Uploads
+// to it use st, or the server's storage if st is nil. Requests already
+// accepted finish with the previous configuration.
+func (s *Server) SetDirectory(cfg config.DirectoryConfig, st storage.Storage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.directories = withEntry(s.directories, cfg.Name, cfg)
+	if st != nil {
+		s.dirStorage = withEntry(s.dirStorage, cfg.Name, st)
+	} else {
+		s.dirStorage = withoutEntry(s.dirStorage, cfg.Name)
+	}
+}
+
+// RemoveDirectory removes a directory while the server runs; uploads to it
+// are rejected as unknown from then on
+func (s *Server) RemoveDirectory(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.directories = withoutEntry(s.directories, name)
+	s.dirStorage = withoutEntry(s.dirStorage, name)
+}
+
+// withEntry returns a copy of m with key set to value. Maps shared with
+// readers are never modified in place, so readers need the lock only to
+// load them.
+func withEntry[V any](m map[string]V, key string, value V) map[string]V {
+	next := make(map[string]V, len(m)+1)
+	maps.Copy(next, m)
+	next[key] = value
+	return next
+}
+
+// withoutEntry returns a copy of m without key
+func withoutEntry[V any](m map[string]V, key string) map[string]V {
+	if _, ok := m[key]; !ok {
+		return m
+	}
+	next := maps.Clone(m)
+	delete(next, key)
+	return next
+}
+
+// storageFor returns the storage for uploads to a directory
+func (s *Server) storageFor(name string) storage.Storage {
+	s.mu.RLock()
+	st, ok := s.dirStorage[name]
+	s.mu.RUnlock()
+	if ok {
+		return st
+	}
+	return s.storage
+}
+
 // Start starts the HTTP server
 func (s *Server) Start(ctx context.Context) error {
 	go func() {
@@ -71,47 +239,182 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}()
 
-	addr := s.httpServer.Addr
 	if s.config.TLS.Enabled {
-		log.Printf("Starting HTTPS ingress server on %s", addr)
+		tlsConfig, err := s.buildTLSConfig()
+		if err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = tlsConfig
+	}
 
-		// Load TLS certificate
-		cert, err := tls.LoadX509KeyPair(s.config.TLS.CertFile, s.config.TLS.KeyFile)
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	// Serve on every listener; the first error (ErrServerClosed on shutdown) is returned
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if s.config.TLS.Enabled {
+				log.Printf("Starting HTTPS ingress server on %s (%s)", ln.Addr(), ln.Addr().Network())
+				errCh <- s.httpServer.ServeTLS(ln, "", "")
+				return
+			}
+			log.Printf("Starting HTTP ingress server on %s (%s)", ln.Addr(), ln.Addr().Network())
+			errCh <- s.httpServer.Serve(ln)
+		}(ln)
+	}
+
+	return <-errCh
+}
+
+// buildTLSConfig creates the server TLS configuration including client certificate verification
+func (s *Server) buildTLSConfig() (*tls.Config, error) {
+	certs, err := s.loadCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: certs, // Selected by SNI when more than one is configured
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.config.TLS.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(s.config.TLS.ClientCAFile)
 		if err != nil {
-			return fmt.Errorf("failed to load TLS certificate: %w", err)
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", s.config.TLS.ClientCAFile)
 		}
 
-		s.httpServer.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
+		tlsConfig.ClientCAs = pool
+		if s.config.TLS.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 		}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCertificates loads the primary TLS certificate and any additional SNI certificates
+func (s *Server) loadCertificates() ([]tls.Certificate, error) {
+	pairs := make([]config.CertificateConfig, 0, len(s.config.TLS.Certificates)+1)
+	if s.config.TLS.CertFile != "" {
+		pairs = append(pairs, config.CertificateConfig{CertFile: s.config.TLS.CertFile, KeyFile: s.config.TLS.KeyFile})
+	}
+	pairs = append(pairs, s.config.TLS.Certificates...)
+
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate %s: %w", pair.CertFile, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no TLS certificate configured")
+	}
+	return certs, nil
+}
+
+// connContext records the listener name of a connection in its context
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if nc, ok := c.(*namedConn); ok {
+		return context.WithValue(ctx, listenerNameKey, nc.listener)
+	}
+	return ctx
+}
+
+// listen opens all configured listeners, closing any already opened on failure
+func (s *Server) listen() ([]net.Listener, error) {
+	configs := s.config.GetListeners()
+	listeners := make([]net.Listener, 0, len(configs))
 
-		return s.httpServer.ListenAndServeTLS("", "")
+	for i := range configs {
+		lc := &configs[i]
+		addr := net.JoinHostPort(lc.Address, strconv.Itoa(lc.Port))
+		ln, err := net.Listen(lc.GetNetwork(), addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s (%s): %w", addr, lc.GetNetwork(), err)
+		}
+		listeners = append(listeners, &namedListener{Listener: ln, name: lc.Name})
 	}
 
-	log.Printf("Starting HTTP ingress server on %s", addr)
-	return s.httpServer.ListenAndServe()
+	return listeners, nil
 }
 
 // Stop stops the server
 func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	if s.accessLog != nil {
+		if closeErr := s.accessLog.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
-// withAuth wraps a handler with basic authentication if enabled
+// withAuth wraps a handler with client certificate, basic and/or JWT authentication if enabled
 func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.config.BasicAuth.Enabled {
+		// A verified client certificate authenticates the request on its own
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			if dirs := s.clientCertDirectories(r.TLS.VerifiedChains[0][0]); dirs != nil {
+				r = r.WithContext(context.WithValue(r.Context(), allowedDirsKey, dirs))
+			}
+			next(w, withAuthenticated(r, "cert:"+r.TLS.VerifiedChains[0][0].Subject.String()))
+			return
+		}
+
+		if !s.config.BasicAuth.Enabled && s.jwt == nil {
 			next(w, r)
 			return
 		}
 
+		// Bearer tokens are validated as JWTs
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && s.jwt != nil {
+			claims, err := s.jwt.Verify(r.Context(), token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="xferd", error="invalid_token"`)
+				writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized")
+				log.Printf("Rejected JWT from %s: %v", r.RemoteAddr, err)
+				return
+			}
+
+			if dirs := s.jwt.AllowedDirectories(claims); dirs != nil {
+				r = r.WithContext(context.WithValue(r.Context(), allowedDirsKey, dirs))
+			}
+			subject, _ := claims["sub"].(string)
+			next(w, withAuthenticated(r, "jwt:"+subject))
+			return
+		}
+
+		if !s.config.BasicAuth.Enabled {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="xferd"`)
+			writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized")
+			return
+		}
+
 		username, password, ok := r.BasicAuth()
 		if !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="xferd"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized")
 			return
 		}
 
@@ -130,13 +433,95 @@ func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
 
 		if !usernameMatch || !passwordMatch {
 			w.Header().Set("WWW-Authenticate", `Basic realm="xferd"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized")
 			log.Printf("Failed authentication attempt from %s (username: %s)", r.RemoteAddr, username)
 			return
 		}
 
-		next(w, r)
+		next(w, withAuthenticated(r, "basic:"+username))
+	}
+}
+
+// withAuthenticated marks a request whose credentials were verified, with
+// the identity they belong to
+func withAuthenticated(r *http.Request, identity string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticatedKey, identity))
+}
+
+// isAuthenticated reports whether the request presented valid credentials,
+// as opposed to passing withAuth because no authentication is configured
+func isAuthenticated(r *http.Request) bool {
+	_, ok := r.Context().Value(authenticatedKey).(string)
+	return ok
+}
+
+// identity returns who authenticated the request, e.g. jwt:<subject>, or ""
+func identity(r *http.Request) string {
+	id, _ := r.Context().Value(authenticatedKey).(string)
+	return id
+}
+
+// lookupDirectory returns the directory config if it is reachable through the
+// request's Host header and listener. Directories bound to other hosts or
+// listeners are reported as unknown, like name-based virtual hosts.
+func (s *Server) lookupDirectory(r *http.Request, dirName string) (config.DirectoryConfig, bool) {
+	s.mu.RLock()
+	dirConfig, exists := s.directories[dirName]
+	s.mu.RUnlock()
+
+	if !exists {
+		return config.DirectoryConfig{}, false
+	}
+
+	if len(dirConfig.Hosts) > 0 {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !slices.ContainsFunc(dirConfig.Hosts, func(h string) bool { return strings.EqualFold(h, host) }) {
+			return config.DirectoryConfig{}, false
+		}
+	}
+
+	if len(dirConfig.Listeners) > 0 {
+		listener, _ := r.Context().Value(listenerNameKey).(string)
+		if !slices.Contains(dirConfig.Listeners, listener) {
+			return config.DirectoryConfig{}, false
+		}
+	}
+
+	return dirConfig, true
+}
+
+// clientCertDirectories maps a client certificate's CN and SANs to permitted directories.
+// A nil result means no restriction applies.
+func (s *Server) clientCertDirectories(cert *x509.Certificate) []string {
+	mapping := s.config.TLS.ClientCertDirs
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	identities := []string{cert.Subject.CommonName}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
 	}
+
+	dirs := []string{}
+	for _, identity := range identities {
+		dirs = append(dirs, mapping[identity]...)
+	}
+	return dirs
+}
+
+// isDirectoryAllowed checks the request's directory restriction (set by JWT claims or client certificate)
+func isDirectoryAllowed(r *http.Request, dirName string) bool {
+	dirs, ok := r.Context().Value(allowedDirsKey).([]string)
+	if !ok {
+		return true // No restriction
+	}
+	return slices.Contains(dirs, dirName) || slices.Contains(dirs, "*")
 }
 
 // sanitizeFilename validates a filename (no path separators allowed)
@@ -222,6 +607,18 @@ func sanitizeSubdirectoryPath(subdir string) (string, error) {
 	return safePath, nil
 }
 
+// checkSubdirectoryPolicy enforces a directory's allow_subdirectories and
+// max_depth on a sanitized subdirectory path
+func checkSubdirectoryPolicy(dirConfig *config.DirectoryConfig, subdir string) error {
+	if !dirConfig.IsSubdirectoryUploadAllowed() {
+		return fmt.Errorf("subdirectories are not allowed")
+	}
+	if depth := len(strings.Split(filepath.ToSlash(subdir), "/")); dirConfig.MaxDepth > 0 && depth > dirConfig.MaxDepth {
+		return fmt.Errorf("depth %d exceeds max_depth %d", depth, dirConfig.MaxDepth)
+	}
+	return nil
+}
+
 // validateSubdirectoryPath ensures the final destination path is within the base directory
 // This is a critical security check to prevent directory escape attacks
 func validateSubdirectoryPath(baseDir, relativePath string) (string, error) {
@@ -251,7 +648,7 @@ func validateSubdirectoryPath(baseDir, relativePath string) (string, error) {
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -264,14 +661,15 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 // Example: /upload/invoices/2025/01/30
 func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
+	id := requestID(w, r)
 
 	// Extract path after /upload/
 	uploadPath := r.URL.Path[len("/upload/"):]
 	if uploadPath == "" {
-		http.Error(w, "Directory name required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeDirectoryRequired, "Directory name required")
 		return
 	}
 
@@ -285,39 +683,76 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Lookup directory config
-	s.mu.RLock()
-	dirConfig, exists := s.directories[dirName]
-	s.mu.RUnlock()
-
+	dirConfig, exists := s.lookupDirectory(r, dirName)
 	if !exists {
-		http.Error(w, "Unknown directory", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
 		return
 	}
 
+	if !isDirectoryAllowed(r, dirName) {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+		log.Printf("Rejected upload to %s from %s: directory not permitted by token", dirName, r.RemoteAddr)
+		return
+	}
+
+	if apiErr := s.checkLowDisk(dirConfig); apiErr != nil {
+		writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+		log.Printf("Rejected upload to %s from %s: %s", dirName, r.RemoteAddr, apiErr.message)
+		return
+	}
+
+	// Reject oversized uploads before reading the body; the multipart
+	// encoding adds a little overhead on top of the file itself
+	limit := s.maxUploadBytes(dirConfig)
+	if limit > 0 {
+		if r.ContentLength > limit+multipartOverhead {
+			apiErr := payloadTooLarge(limit)
+			writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+	}
+
 	// Parse multipart form
 	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB memory limit
-		http.Error(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		if isMaxBytesError(err) {
+			apiErr := payloadTooLarge(limit)
+			writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Failed to parse form: %v", err))
 		return
 	}
 
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get file: %v", err), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeMissingFile, fmt.Sprintf("Failed to get file: %v", err))
 		return
 	}
 	defer file.Close()
 
+	checksum, err := expectedChecksum(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if apiErr := s.checkUploadSize(handler.Size, dirConfig); apiErr != nil {
+		writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+		return
+	}
+
 	// Get filename from multipart (Go extracts basename automatically)
 	filename := handler.Filename
 	if filename == "" {
-		http.Error(w, "Filename is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeFilenameRequired, "Filename is required")
 		return
 	}
 
 	// Sanitize the filename (no path separators allowed in filename itself)
 	safeFilename, err := sanitizeFilename(filename)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid filename: %v", err), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidFilename, fmt.Sprintf("Invalid filename: %v", err))
 		log.Printf("Rejected unsafe filename from %s: %s", r.RemoteAddr, filename)
 		return
 	}
@@ -328,10 +763,15 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		// Sanitize subdirectory path (allows path separators)
 		safeSubdir, subdirErr := sanitizeSubdirectoryPath(subdirPath)
 		if subdirErr != nil {
-			http.Error(w, fmt.Sprintf("Invalid subdirectory path: %v", subdirErr), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, fmt.Sprintf("Invalid subdirectory path: %v", subdirErr))
 			log.Printf("Rejected unsafe subdirectory from %s: %s", r.RemoteAddr, subdirPath)
 			return
 		}
+		if policyErr := checkSubdirectoryPolicy(&dirConfig, safeSubdir); policyErr != nil {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Subdirectory not permitted: %v", policyErr))
+			log.Printf("Rejected upload to %s/%s from %s: %v", dirName, subdirPath, r.RemoteAddr, policyErr)
+			return
+		}
 		targetRelPath = filepath.Join(safeSubdir, safeFilename)
 	} else {
 		targetRelPath = safeFilename
@@ -340,62 +780,66 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	// Validate that the final path is within the ingest directory
 	finalPath, err := validateSubdirectoryPath(dirConfig.GetIngestPath(), targetRelPath)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid path: %v", err), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, fmt.Sprintf("Invalid path: %v", err))
 		log.Printf("Rejected path escape attempt from %s: %s", r.RemoteAddr, targetRelPath)
 		return
 	}
 
-	// Create subdirectories if needed
-	finalDir := filepath.Dir(finalPath)
-	if err := os.MkdirAll(finalDir, 0o755); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
-		log.Printf("Directory creation failed for %s: %v", handler.Filename, err)
+	// Stage the file; it becomes visible to the watcher only once committed
+	dst, err := s.storageFor(dirConfig.Name).Create(r.Context(), finalPath)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeStorageError, fmt.Sprintf("Failed to write file: %v", err))
+		log.Printf("Upload failed for %s: %v", handler.Filename, err)
 		return
 	}
 
-	// Stream file to temp location with .partial suffix
-	// Use a unique temp name to avoid collisions
-	tempPath := filepath.Join(s.config.TempDir, filepath.Base(safeFilename)+".partial")
-
-	if err := s.streamToFile(file, tempPath); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
+	src := newChecksumReader(file)
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Abort()
+		writeStorageError(w, r, "Failed to write file", err)
 		log.Printf("Upload failed for %s: %v", handler.Filename, err)
 		return
 	}
 
-	// Atomic rename into watched directory
-	if err := os.Rename(tempPath, finalPath); err != nil {
-		os.Remove(tempPath) // Cleanup on error
-		http.Error(w, fmt.Sprintf("Failed to finalize file: %v", err), http.StatusInternalServerError)
-		log.Printf("Rename failed for %s: %v", handler.Filename, err)
+	// Reject corrupted uploads before they become visible to the watcher
+	if checksum != "" && src.Sum() != checksum {
+		quarantineUpload(dst, dirConfig, finalPath, id, fmt.Sprintf("expected SHA-256 %s, got %s", checksum, src.Sum()))
+		writeError(w, r, http.StatusBadRequest, ErrCodeChecksumMismatch,
+			fmt.Sprintf("Checksum mismatch: expected %s, got %s", checksum, src.Sum()))
 		return
 	}
 
-	log.Printf("Upload complete: %s -> %s (%d bytes)", safeFilename, dirConfig.Name, handler.Size)
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Upload successful: %s\n", safeFilename)
-}
-
-// streamToFile streams data to a file efficiently
-func (s *Server) streamToFile(src io.Reader, destPath string) error {
-	// Create temp file
-	f, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+	// Assigned before the file becomes visible, so the watcher picks it up
+	transferid.Assign(finalPath, id)
+	if err := dst.Commit(); err != nil {
+		transferid.Forget(finalPath)
+		writeStorageError(w, r, "Failed to store file", err)
+		log.Printf("%s Commit failed for %s: %v", transferid.Prefix(dirConfig.Name, id), handler.Filename, err)
+		return
 	}
-	defer f.Close()
 
-	// Stream copy
-	if _, err := io.Copy(f, src); err != nil {
-		return fmt.Errorf("failed to copy data: %w", err)
-	}
+	receipt := newRequestID()
+	log.Printf("%s Upload complete: %s (%d bytes, receipt %s)", transferid.Prefix(dirConfig.Name, id), safeFilename, handler.Size, receipt)
+	writeUploadResponse(w, r, UploadResponse{
+		ReceiptID:  receipt,
+		TransferID: id,
+		Directory:  dirConfig.Name,
+		Filename:   safeFilename,
+		Path:       filepath.ToSlash(targetRelPath),
+		Size:       handler.Size,
+		Checksum:   src.Sum(),
+		Received:   time.Now().UTC(),
+	})
+}
 
-	// Sync to disk before atomic rename
-	if err := f.Sync(); err != nil {
-		return fmt.Errorf("failed to sync file: %w", err)
-	}
+// multipartOverhead is the allowance for multipart headers and boundaries
+// on top of max_upload_bytes when checking request body sizes
+const multipartOverhead = 64 << 10
 
-	return nil
+// isMaxBytesError reports whether err was caused by exceeding an http.MaxBytesReader limit
+func isMaxBytesError(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
 }
 
 // StreamingHandler provides an alternative streaming upload handler
@@ -403,14 +847,15 @@ func (s *Server) streamToFile(src io.Reader, destPath string) error {
 // URL format: /upload/{directory_name}[/subdirectory/path]
 func (s *Server) handleStreamingUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
+	id := requestID(w, r)
 
 	// Extract path after /upload/
 	uploadPath := r.URL.Path[len("/upload/"):]
 	if uploadPath == "" {
-		http.Error(w, "Directory name required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeDirectoryRequired, "Directory name required")
 		return
 	}
 
@@ -422,29 +867,48 @@ func (s *Server) handleStreamingUpload(w http.ResponseWriter, r *http.Request) {
 		subdirPath = pathParts[1]
 	}
 
-	s.mu.RLock()
-	dirConfig, exists := s.directories[dirName]
-	s.mu.RUnlock()
-
+	dirConfig, exists := s.lookupDirectory(r, dirName)
 	if !exists {
-		http.Error(w, "Unknown directory", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+		return
+	}
+
+	if !isDirectoryAllowed(r, dirName) {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+		log.Printf("Rejected upload to %s from %s: directory not permitted by token", dirName, r.RemoteAddr)
+		return
+	}
+
+	if apiErr := s.checkLowDisk(dirConfig); apiErr != nil {
+		writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+		log.Printf("Rejected upload to %s from %s: %s", dirName, r.RemoteAddr, apiErr.message)
 		return
 	}
 
+	// Reject oversized uploads before reading the body
+	limit := s.maxUploadBytes(dirConfig)
+	if limit > 0 {
+		if apiErr := s.checkUploadSize(r.ContentLength, dirConfig); apiErr != nil {
+			writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
 	// Get filename from header or query param
 	filename := r.URL.Query().Get("filename")
 	if filename == "" {
 		filename = r.Header.Get("X-Filename")
 	}
 	if filename == "" {
-		http.Error(w, "Filename required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeFilenameRequired, "Filename required")
 		return
 	}
 
 	// Sanitize filename (no path separators allowed in filename itself)
 	safeFilename, err := sanitizeFilename(filename)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid filename: %v", err), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidFilename, fmt.Sprintf("Invalid filename: %v", err))
 		log.Printf("Rejected unsafe filename from %s: %s", r.RemoteAddr, filename)
 		return
 	}
@@ -455,10 +919,15 @@ func (s *Server) handleStreamingUpload(w http.ResponseWriter, r *http.Request) {
 		// Sanitize subdirectory path (allows path separators)
 		safeSubdir, subdirErr := sanitizeSubdirectoryPath(subdirPath)
 		if subdirErr != nil {
-			http.Error(w, fmt.Sprintf("Invalid subdirectory path: %v", subdirErr), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, fmt.Sprintf("Invalid subdirectory path: %v", subdirErr))
 			log.Printf("Rejected unsafe subdirectory from %s: %s", r.RemoteAddr, subdirPath)
 			return
 		}
+		if policyErr := checkSubdirectoryPolicy(&dirConfig, safeSubdir); policyErr != nil {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Subdirectory not permitted: %v", policyErr))
+			log.Printf("Rejected upload to %s/%s from %s: %v", dirName, subdirPath, r.RemoteAddr, policyErr)
+			return
+		}
 		targetRelPath = filepath.Join(safeSubdir, safeFilename)
 	} else {
 		targetRelPath = safeFilename
@@ -467,37 +936,52 @@ func (s *Server) handleStreamingUpload(w http.ResponseWriter, r *http.Request) {
 	// Validate that the final path is within the ingest directory
 	finalPath, err := validateSubdirectoryPath(dirConfig.GetIngestPath(), targetRelPath)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid path: %v", err), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, fmt.Sprintf("Invalid path: %v", err))
 		log.Printf("Rejected path escape attempt from %s: %s", r.RemoteAddr, targetRelPath)
 		return
 	}
 
-	// Create subdirectories if needed
-	finalDir := filepath.Dir(finalPath)
-	if err := os.MkdirAll(finalDir, 0o755); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
-		log.Printf("Directory creation failed for %s: %v", filename, err)
+	// Stream directly from request body
+	dst, err := s.storageFor(dirConfig.Name).Create(r.Context(), finalPath)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeStorageError, fmt.Sprintf("Failed to write file: %v", err))
+		log.Printf("Streaming upload failed for %s: %v", safeFilename, err)
 		return
 	}
 
-	// Stream directly from request body
-	tempPath := filepath.Join(s.config.TempDir, filepath.Base(safeFilename)+".partial")
-
-	if err := s.streamToFile(r.Body, tempPath); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
+	src := newChecksumReader(r.Body)
+	size, err := io.Copy(dst, src)
+	if err != nil {
+		dst.Abort()
+		if isMaxBytesError(err) {
+			apiErr := payloadTooLarge(limit)
+			writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+			return
+		}
+		writeStorageError(w, r, "Failed to write file", err)
 		log.Printf("Streaming upload failed for %s: %v", safeFilename, err)
 		return
 	}
 
-	// Atomic rename
-	if err := os.Rename(tempPath, finalPath); err != nil {
-		os.Remove(tempPath)
-		http.Error(w, fmt.Sprintf("Failed to finalize file: %v", err), http.StatusInternalServerError)
-		log.Printf("Rename failed for %s: %v", safeFilename, err)
+	// Assigned before the file becomes visible, so the watcher picks it up
+	transferid.Assign(finalPath, id)
+	if err := dst.Commit(); err != nil {
+		transferid.Forget(finalPath)
+		writeStorageError(w, r, "Failed to store file", err)
+		log.Printf("%s Commit failed for %s: %v", transferid.Prefix(dirConfig.Name, id), safeFilename, err)
 		return
 	}
 
-	log.Printf("Streaming upload complete: %s -> %s", safeFilename, dirConfig.Name)
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Upload successful: %s\n", safeFilename)
+	receipt := newRequestID()
+	log.Printf("%s Streaming upload complete: %s (%d bytes, receipt %s)", transferid.Prefix(dirConfig.Name, id), safeFilename, size, receipt)
+	writeUploadResponse(w, r, UploadResponse{
+		ReceiptID:  receipt,
+		TransferID: id,
+		Directory:  dirConfig.Name,
+		Filename:   safeFilename,
+		Path:       filepath.ToSlash(targetRelPath),
+		Size:       size,
+		Checksum:   src.Sum(),
+		Received:   time.Now().UTC(),
+	})
 }
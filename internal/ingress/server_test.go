@@ -3,17 +3,30 @@ package ingress
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"io"
+	"math/big"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/storage"
+	"github.com/muzy/xferd/internal/transferid"
 )
 
 func TestNewServer(t *testing.T) {
@@ -121,6 +134,47 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestReadyEndpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	dirs := []config.DirectoryConfig{{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")}}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Without checks the service is ready
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"ready":true`) {
+		t.Errorf("Expected 200 and ready, got %d %s", w.Code, w.Body.String())
+	}
+
+	checks := []ReadinessCheck{{Name: "queue", Directory: "test", OK: true}}
+	server.SetReadinessCheck(func(context.Context) []ReadinessCheck { return checks })
+	w = httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	checks = append(checks, ReadinessCheck{Name: "watch_path", Directory: "test", Error: "watch path of test not writable: permission denied"})
+	w = httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	var resp ReadinessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Ready || len(resp.Checks) != 2 || resp.Checks[1].OK || resp.Error == nil ||
+		resp.Error.Code != ErrCodeNotReady || !strings.Contains(resp.Error.Message, "not writable") {
+		t.Errorf("Expected the failing check with %s, got %+v", ErrCodeNotReady, resp)
+	}
+}
+
 func TestHealthEndpointInvalidMethod(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -419,85 +473,6 @@ func TestUploadEndpointLargeFile(t *testing.T) {
 	}
 }
 
-func TestStreamToFile(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	cfg := config.ServerConfig{
-		Address: "0.0.0.0",
-		Port:    8080,
-		TempDir: tmpDir,
-	}
-
-	server, err := NewServer(cfg, nil)
-	if err != nil {
-		t.Fatalf("Failed to create server: %v", err)
-	}
-
-	content := []byte("test content for streaming")
-	reader := bytes.NewReader(content)
-
-	destPath := filepath.Join(tmpDir, "streamed.txt")
-
-	err = server.streamToFile(reader, destPath)
-	if err != nil {
-		t.Fatalf("streamToFile failed: %v", err)
-	}
-
-	// Verify file content
-	written, err := os.ReadFile(destPath)
-	if err != nil {
-		t.Fatalf("Failed to read streamed file: %v", err)
-	}
-
-	if string(written) != string(content) {
-		t.Errorf("Content mismatch. Expected '%s', got '%s'", string(content), string(written))
-	}
-}
-
-func TestStreamToFileLarge(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	cfg := config.ServerConfig{
-		Address: "0.0.0.0",
-		Port:    8080,
-		TempDir: tmpDir,
-	}
-
-	server, err := NewServer(cfg, nil)
-	if err != nil {
-		t.Fatalf("Failed to create server: %v", err)
-	}
-
-	// Create 5 MB content
-	content := make([]byte, 5*1024*1024)
-	for i := range content {
-		content[i] = byte(i % 256)
-	}
-	reader := bytes.NewReader(content)
-
-	destPath := filepath.Join(tmpDir, "large.bin")
-
-	start := time.Now()
-	err = server.streamToFile(reader, destPath)
-	elapsed := time.Since(start)
-
-	if err != nil {
-		t.Fatalf("streamToFile failed: %v", err)
-	}
-
-	t.Logf("Streamed 5 MB in %v", elapsed)
-
-	// Verify file size
-	info, err := os.Stat(destPath)
-	if err != nil {
-		t.Fatalf("Failed to stat streamed file: %v", err)
-	}
-
-	if info.Size() != int64(len(content)) {
-		t.Errorf("Size mismatch. Expected %d, got %d", len(content), info.Size())
-	}
-}
-
 func TestHandleStreamingUpload(t *testing.T) {
 	tmpDir := t.TempDir()
 	tempDir := filepath.Join(tmpDir, "temp")
@@ -549,6 +524,31 @@ func TestHandleStreamingUpload(t *testing.T) {
 	}
 }
 
+func TestUploadAssignsTransferID(t *testing.T) {
+	watchDir := t.TempDir()
+	server, err := NewServer(config.ServerConfig{TempDir: t.TempDir()}, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/upload/test?filename=traced.txt", strings.NewReader("content"))
+	req.Header.Set(requestIDHeader, "req-7")
+	w := httptest.NewRecorder()
+	server.handleStreamingUpload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	finalPath := filepath.Join(watchDir, "traced.txt")
+	defer transferid.Forget(finalPath)
+	if id := transferid.Lookup(finalPath); id != "req-7" {
+		t.Errorf("Expected the request ID as transfer ID, got %q", id)
+	}
+	if got := w.Header().Get(requestIDHeader); got != "req-7" {
+		t.Errorf("Expected the request ID to be echoed, got %q", got)
+	}
+}
+
 func TestHandleStreamingUploadMissingFilename(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -1484,6 +1484,54 @@ func TestStreamingUploadToSubdirectory(t *testing.T) {
 	}
 }
 
+func TestSubdirectoryPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	flatDir := filepath.Join(tmpDir, "flat")
+	shallowDir := filepath.Join(tmpDir, "shallow")
+	for _, dir := range []string{flatDir, shallowDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create watch directory: %v", err)
+		}
+	}
+
+	noSubdirectories := false
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	dirs := []config.DirectoryConfig{
+		{Name: "flat", WatchPath: flatDir, AllowSubdirectories: &noSubdirectories},
+		{Name: "shallow", WatchPath: shallowDir, MaxDepth: 2},
+	}
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		urlPath  string
+		expected int
+	}{
+		{"flat_root", "/upload/flat", http.StatusOK},
+		{"flat_nested", "/upload/flat/logs", http.StatusForbidden},
+		{"shallow_within_depth", "/upload/shallow/data/2025", http.StatusOK},
+		{"shallow_too_deep", "/upload/shallow/data/2025/01", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.urlPath, strings.NewReader("content"))
+			req.Header.Set("X-Filename", "file.txt")
+			w := httptest.NewRecorder()
+			server.handleStreamingUpload(w, req)
+			if w.Code != tc.expected {
+				t.Errorf("Expected status %d, got %d: %s", tc.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(flatDir, "logs")); !os.IsNotExist(err) {
+		t.Error("Expected no subdirectory to be created in flat directory")
+	}
+}
+
 // TestSubdirectoryPathTraversalProtection ensures path traversal is blocked for subdirectories
 // Tests malicious URL paths that attempt to escape the watch directory
 func TestSubdirectoryPathTraversalProtection(t *testing.T) {
@@ -1590,3 +1638,616 @@ func TestHealthEndpointNoAuth(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
 }
+
+func TestServerMultipleListeners(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := config.ServerConfig{
+		Listen: []config.ListenConfig{
+			{Address: "127.0.0.1", Port: 18083, Network: "tcp4"},
+			{Address: "127.0.0.1", Port: 18084},
+		},
+		TempDir: filepath.Join(tmpDir, "temp"),
+	}
+
+	// Add an IPv6-only listener when the host supports IPv6
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		ln.Close()
+		cfg.Listen = append(cfg.Listen, config.ListenConfig{Address: "::1", Port: 18085, Network: "tcp6"})
+	}
+
+	dirs := []config.DirectoryConfig{
+		{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Start(ctx)
+	}()
+
+	// Give server time to start
+	time.Sleep(100 * time.Millisecond)
+
+	for _, l := range cfg.Listen {
+		url := "http://" + net.JoinHostPort(l.Address, strconv.Itoa(l.Port)) + "/health"
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("Failed to reach listener %s: %v", url, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200 from %s, got %d", url, resp.StatusCode)
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not stop within timeout")
+	}
+}
+
+func TestServerListenFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Occupy a port so the second listener fails
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to occupy port: %v", err)
+	}
+	defer occupied.Close()
+	occupiedPort := occupied.Addr().(*net.TCPAddr).Port
+
+	cfg := config.ServerConfig{
+		Listen: []config.ListenConfig{
+			{Address: "127.0.0.1", Port: 18086},
+			{Address: "127.0.0.1", Port: occupiedPort},
+		},
+		TempDir: filepath.Join(tmpDir, "temp"),
+	}
+
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if err := server.Start(context.Background()); err == nil {
+		t.Fatal("Expected error when a listener cannot bind")
+	}
+
+	// The first listener must have been released again
+	ln, err := net.Listen("tcp", "127.0.0.1:18086")
+	if err != nil {
+		t.Fatalf("Expected first listener to be closed: %v", err)
+	}
+	ln.Close()
+}
+
+func TestUploadHostBinding(t *testing.T) {
+	tmpDir := t.TempDir()
+	partnerADir := filepath.Join(tmpDir, "partner-a")
+	partnerBDir := filepath.Join(tmpDir, "partner-b")
+	for _, dir := range []string{partnerADir, partnerBDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+	}
+
+	dirs := []config.DirectoryConfig{
+		{Name: "partner-a", WatchPath: partnerADir, Hosts: []string{"uploads-a.example.com"}},
+		{Name: "partner-b", WatchPath: partnerBDir, Hosts: []string{"uploads-b.example.com"}},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		target     string
+		wantStatus int
+	}{
+		{"MatchingHost", "uploads-a.example.com", "partner-a", http.StatusOK},
+		{"MatchingHostWithPort", "UPLOADS-B.example.com:8443", "partner-b", http.StatusOK},
+		{"OtherPartnerHost", "uploads-b.example.com", "partner-a", http.StatusNotFound},
+		{"UnboundHost", "localhost", "partner-a", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", "test.txt")
+			_, _ = part.Write([]byte("test content"))
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/upload/"+tt.target, body)
+			req.Host = tt.host
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+
+			server.handleUpload(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestUploadListenerBinding(t *testing.T) {
+	tmpDir := t.TempDir()
+	internalDir := filepath.Join(tmpDir, "internal")
+	if err := os.MkdirAll(internalDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	cfg := config.ServerConfig{
+		Listen: []config.ListenConfig{
+			{Name: "public", Address: "127.0.0.1", Port: 18087},
+			{Name: "internal", Address: "127.0.0.1", Port: 18088},
+		},
+		TempDir: filepath.Join(tmpDir, "temp"),
+	}
+
+	dirs := []config.DirectoryConfig{
+		{Name: "internal", WatchPath: internalDir, Listeners: []string{"internal"}},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = server.Start(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	upload := func(port int) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		_, _ = part.Write([]byte("test content"))
+		writer.Close()
+
+		resp, err := http.Post("http://127.0.0.1:"+strconv.Itoa(port)+"/upload/internal", writer.FormDataContentType(), body)
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := upload(18088); status != http.StatusOK {
+		t.Errorf("Expected status 200 on bound listener, got %d", status)
+	}
+	if status := upload(18087); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 on other listener, got %d", status)
+	}
+}
+
+func TestLoadCertificatesMissing(t *testing.T) {
+	server := &Server{config: config.ServerConfig{
+		TLS: config.TLSConfig{
+			Enabled: true,
+			Certificates: []config.CertificateConfig{
+				{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"},
+			},
+		},
+	}}
+
+	if _, err := server.loadCertificates(); err == nil {
+		t.Error("Expected error for missing certificate files")
+	}
+
+	server.config.TLS.Certificates = nil
+	if _, err := server.loadCertificates(); err == nil {
+		t.Error("Expected error when no certificate is configured")
+	}
+}
+
+// newTestCertificate creates a certificate signed by parent (self-signed when parent is nil)
+func newTestCertificate(t *testing.T, cn string, dnsNames []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func TestBuildTLSConfigClientCA(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	ca, caKey := newTestCertificate(t, "test-ca", nil, nil, nil)
+	serverCert, serverKey := newTestCertificate(t, "localhost", []string{"localhost"}, ca, caKey)
+
+	caFile := filepath.Join(tmpDir, "ca.pem")
+	certFile := filepath.Join(tmpDir, "cert.pem")
+	keyFile := filepath.Join(tmpDir, "key.pem")
+
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	files := map[string][]byte{
+		caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}),
+		certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Raw}),
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	server := &Server{config: config.ServerConfig{
+		TLS: config.TLSConfig{
+			Enabled:           true,
+			CertFile:          certFile,
+			KeyFile:           keyFile,
+			ClientCAFile:      caFile,
+			RequireClientCert: true,
+		},
+	}}
+
+	tlsConfig, err := server.buildTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to build TLS config: %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("Expected RequireAndVerifyClientCert, got %v", tlsConfig.ClientAuth)
+	}
+	if tlsConfig.ClientCAs == nil {
+		t.Error("Expected client CA pool to be set")
+	}
+
+	server.config.TLS.RequireClientCert = false
+	tlsConfig, err = server.buildTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to build TLS config: %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("Expected VerifyClientCertIfGiven, got %v", tlsConfig.ClientAuth)
+	}
+
+	// A CA file without certificates is rejected
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if _, err := server.buildTLSConfig(); err == nil {
+		t.Error("Expected error for invalid client CA file")
+	}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	tmpDir := t.TempDir()
+	invoicesDir := filepath.Join(tmpDir, "invoices")
+	reportsDir := filepath.Join(tmpDir, "reports")
+	for _, dir := range []string{invoicesDir, reportsDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+		BasicAuth: config.BasicAuthConfig{
+			Enabled:  true,
+			Username: "user",
+			Password: "pass",
+		},
+		TLS: config.TLSConfig{
+			ClientCAFile: "/etc/xferd/ca.pem",
+			ClientCertDirs: map[string][]string{
+				"partner-a":             {"invoices"},
+				"reports.partner-b.com": {"reports"},
+			},
+		},
+	}
+
+	dirs := []config.DirectoryConfig{
+		{Name: "invoices", WatchPath: invoicesDir},
+		{Name: "reports", WatchPath: reportsDir},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ca, caKey := newTestCertificate(t, "test-ca", nil, nil, nil)
+	partnerA, _ := newTestCertificate(t, "partner-a", nil, ca, caKey)
+	partnerB, _ := newTestCertificate(t, "partner-b", []string{"reports.partner-b.com"}, ca, caKey)
+	unmapped, _ := newTestCertificate(t, "unknown", nil, ca, caKey)
+
+	upload := func(target string, cert *x509.Certificate) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		_, _ = part.Write([]byte("test content"))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload/"+target, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca}}}
+		}
+		w := httptest.NewRecorder()
+
+		server.withAuth(server.handleUpload)(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		cert       *x509.Certificate
+		wantStatus int
+	}{
+		{"CommonNameMapped", "invoices", partnerA, http.StatusOK},
+		{"CommonNameOtherDirectory", "reports", partnerA, http.StatusForbidden},
+		{"SANMapped", "reports", partnerB, http.StatusOK},
+		{"UnmappedCertificate", "invoices", unmapped, http.StatusForbidden},
+		{"NoCertificateFallsBackToBasicAuth", "invoices", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := upload(tt.target, tt.cert); status != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, status)
+			}
+		})
+	}
+}
+
+func TestUploadMaxUploadBytes(t *testing.T) {
+	tmpDir := t.TempDir()
+	tempDir := filepath.Join(tmpDir, "temp")
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+
+	cfg := config.ServerConfig{
+		Port:           8080,
+		TempDir:        tempDir,
+		MaxUploadBytes: 1000,
+	}
+	dirs := []config.DirectoryConfig{
+		{Name: "test", WatchPath: watchDir},
+		{Name: "small", WatchPath: watchDir, MaxUploadBytes: 100},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	newUpload := func(dir string, size int, knownLength bool) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "data.bin")
+		_, _ = part.Write(bytes.Repeat([]byte("x"), size))
+		_ = writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload/"+dir, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if !knownLength {
+			req.ContentLength = -1
+		}
+		return req
+	}
+
+	tests := []struct {
+		name           string
+		req            *http.Request
+		expectedStatus int
+	}{
+		{"WithinServerLimit", newUpload("test", 500, true), http.StatusOK},
+		{"DirectoryOverride", newUpload("small", 500, true), http.StatusRequestEntityTooLarge},
+		{"ContentLengthTooLarge", newUpload("test", 200<<10, true), http.StatusRequestEntityTooLarge},
+		{"UnknownLengthTooLarge", newUpload("test", 200<<10, false), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.handleUpload(w, tt.req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), string(ErrCodePayloadTooLarge)) {
+				t.Errorf("Expected error code %s, got: %s", ErrCodePayloadTooLarge, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestStreamingUploadMaxUploadBytes(t *testing.T) {
+	tmpDir := t.TempDir()
+	tempDir := filepath.Join(tmpDir, "temp")
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+
+	cfg := config.ServerConfig{Port: 8080, TempDir: tempDir, MaxUploadBytes: 1000}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Body without Content-Length is cut off while streaming
+	req := httptest.NewRequest("POST", "/upload/test?filename=big.bin", bytes.NewReader(make([]byte, 2000)))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	server.handleStreamingUpload(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "big.bin.partial")); !os.IsNotExist(err) {
+		t.Error("Expected partial file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "big.bin")); !os.IsNotExist(err) {
+		t.Error("Expected oversized upload not to be stored")
+	}
+}
+
+// memoryStorage keeps committed files in memory
+type memoryStorage struct {
+	files map[string]string
+}
+
+type memoryFile struct {
+	bytes.Buffer
+	storage *memoryStorage
+	path    string
+}
+
+func (m *memoryStorage) Create(_ context.Context, path string) (storage.File, error) {
+	return &memoryFile{storage: m, path: path}, nil
+}
+
+func (f *memoryFile) Commit() error {
+	f.storage.files[f.path] = f.String()
+	return nil
+}
+
+func (f *memoryFile) Abort() {}
+
+func TestUploadCustomStorage(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	mem := &memoryStorage{files: make(map[string]string)}
+	server.SetStorage(mem)
+
+	req := httptest.NewRequest("POST", "/upload/test/sub?filename=stream.txt", strings.NewReader("in memory"))
+	w := httptest.NewRecorder()
+	server.handleStreamingUpload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, contentType := multipartUpload(t, "multipart.txt", []byte("also in memory"))
+	req = httptest.NewRequest("POST", "/upload/test", body)
+	req.Header.Set("Content-Type", contentType)
+	w = httptest.NewRecorder()
+	server.handleUpload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := mem.files[filepath.Join(watchDir, "sub", "stream.txt")]; got != "in memory" {
+		t.Errorf("Expected streamed upload in storage, got %q", got)
+	}
+	if got := mem.files[filepath.Join(watchDir, "multipart.txt")]; got != "also in memory" {
+		t.Errorf("Expected multipart upload in storage, got %q", got)
+	}
+	if _, err := os.Stat(watchDir); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written to the watch directory, got %v", err)
+	}
+}
+
+func TestSetRemoveDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	upload := func(dir string) int {
+		req := httptest.NewRequest("POST", "/upload/"+dir+"?filename=file.txt", strings.NewReader("content"))
+		w := httptest.NewRecorder()
+		server.handleStreamingUpload(w, req)
+		return w.Code
+	}
+
+	if code := upload("added"); code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 before the directory is added, got %d", code)
+	}
+
+	// Added directories accept uploads into their own storage
+	mem := &memoryStorage{files: make(map[string]string)}
+	addedDir := filepath.Join(tmpDir, "added")
+	server.SetDirectory(config.DirectoryConfig{Name: "added", WatchPath: addedDir}, mem)
+	if code := upload("added"); code != http.StatusOK {
+		t.Fatalf("Expected status 200 after the directory is added, got %d", code)
+	}
+	if got := mem.files[filepath.Join(addedDir, "file.txt")]; got != "content" {
+		t.Errorf("Expected upload in the directory's storage, got %q", got)
+	}
+
+	// Replacing a directory without storage falls back to the server's storage
+	server.SetDirectory(config.DirectoryConfig{Name: "added", WatchPath: addedDir}, nil)
+	if code := upload("added"); code != http.StatusOK {
+		t.Fatalf("Expected status 200 after the directory is replaced, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(addedDir, "file.txt")); err != nil {
+		t.Errorf("Expected upload written to the watch directory: %v", err)
+	}
+
+	server.RemoveDirectory("added")
+	if code := upload("added"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after the directory is removed, got %d", code)
+	}
+	if code := upload("test"); code != http.StatusOK {
+		t.Errorf("Expected other directories to be unaffected, got %d", code)
+	}
+}
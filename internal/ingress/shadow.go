@@ -0,0 +1,203 @@
+package ingress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/shadow"
+)
+
+// shadowResponse is the GET /shadow response
+type shadowResponse struct {
+	Entries []shadow.ManifestEntry `json:"entries"`
+}
+
+// restoreRequest is the POST /shadow/restore body
+type restoreRequest struct {
+	Directory string `json:"directory"`
+	Path      string `json:"path"` // the shadow copy, as listed by GET /shadow
+	To        string `json:"to"`   // target directory (default: the watch path)
+}
+
+// restoreResponse is the POST /shadow/restore response
+type restoreResponse struct {
+	Restored string               `json:"restored"` // path of the restored file
+	Entry    shadow.ManifestEntry `json:"entry"`
+}
+
+// handleShadow searches the shadow copies of directories
+// URL format: /shadow?dir={directory}&name={text}&checksum={sha256}&since={time}&until={time}&limit={n}
+func (s *Server) handleShadow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	var dirs []config.DirectoryConfig
+	if dirName := params.Get("dir"); dirName != "" {
+		dirConfig, exists := s.lookupDirectory(r, dirName)
+		if !exists {
+			writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+			return
+		}
+		if !isDirectoryAllowed(r, dirName) {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+			return
+		}
+		dirs = append(dirs, dirConfig)
+	} else {
+		// Without a directory, search every directory the client may see
+		names := s.allowedDirectories(r)
+		if len(names) == 0 {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+			return
+		}
+		for _, name := range names {
+			if dirConfig, exists := s.lookupDirectory(r, name); exists {
+				dirs = append(dirs, dirConfig)
+			}
+		}
+	}
+	dirs = slices.DeleteFunc(dirs, func(d config.DirectoryConfig) bool { return !d.Shadow.Enabled })
+	if len(dirs) == 0 {
+		writeError(w, r, http.StatusNotFound, ErrCodeShadowDisabled, "Shadow copies are not enabled")
+		return
+	}
+
+	query := shadow.Query{Name: params.Get("name"), Checksum: params.Get("checksum"), Limit: defaultHistoryLimit}
+	if since := params.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid since: %s", since))
+			return
+		}
+		query.Since = t
+	}
+	if until := params.Get("until"); until != "" {
+		t, err := parseSince(until)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid until: %s", until))
+			return
+		}
+		query.Until = t
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid limit: %s", limit))
+			return
+		}
+		query.Limit = min(n, maxHistoryLimit)
+	}
+
+	entries := []shadow.ManifestEntry{}
+	for _, d := range dirs {
+		found, err := shadow.Search(d.Shadow, query)
+		if err != nil {
+			log.Printf("Shadow search failed for %s: %v", d.Name, err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternalError, "Shadow search failed")
+			return
+		}
+		for i := range found {
+			found[i].Directory = d.Name
+		}
+		entries = append(entries, found...)
+	}
+	slices.SortStableFunc(entries, func(a, b shadow.ManifestEntry) int { return b.Time.Compare(a.Time) })
+	entries = entries[:min(len(entries), query.Limit)]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(shadowResponse{Entries: entries})
+}
+
+// handleShadowRestore copies a shadow copy back into the watch path, where it
+// is delivered again, or into one of the directory's shadow.restore_paths.
+// The file keeps its path below the watch path and is never overwritten.
+func (s *Server) handleShadowRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req restoreRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.Directory == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeDirectoryRequired, "Directory is required")
+		return
+	}
+	dirConfig, exists := s.lookupDirectory(r, req.Directory)
+	if !exists {
+		writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+		return
+	}
+	if !isDirectoryAllowed(r, req.Directory) {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+		return
+	}
+	if !dirConfig.Shadow.Enabled {
+		writeError(w, r, http.StatusNotFound, ErrCodeShadowDisabled, "Shadow copies are not enabled")
+		return
+	}
+	if req.Path == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Path is required")
+		return
+	}
+
+	targetDir := dirConfig.WatchPath
+	if req.To != "" {
+		targetDir = filepath.Clean(req.To)
+		allowed := append([]string{dirConfig.WatchPath}, dirConfig.Shadow.RestorePaths...)
+		if !filepath.IsAbs(targetDir) || !slices.ContainsFunc(allowed, func(p string) bool { return isWithin(p, targetDir) }) {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, "Target must be the watch path or below one of shadow.restore_paths")
+			return
+		}
+	}
+
+	entry, err := shadow.Lookup(dirConfig.Shadow, req.Path)
+	if err == nil {
+		if !s.confirmed(w, r, config.AdminEndpointShadowRestore, dirConfig.Name+" "+entry.Path+" "+targetDir) {
+			return
+		}
+		entry, err = shadow.Restore(dirConfig.Shadow, entry, shadow.RestorePath(targetDir, dirConfig.WatchPath, entry.Source))
+	}
+	switch {
+	case errors.Is(err, shadow.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, ErrCodeMissingFile, "No such shadow copy")
+		return
+	case errors.Is(err, os.ErrExist):
+		writeError(w, r, http.StatusConflict, ErrCodeFileExists, "A file already exists at the restore target")
+		return
+	case errors.Is(err, shadow.ErrChecksumMismatch):
+		log.Printf("Shadow restore failed for %s: %v", req.Path, err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeChecksumMismatch, "Shadow copy is corrupt")
+		return
+	case err != nil:
+		log.Printf("Shadow restore failed for %s: %v", req.Path, err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeStorageError, "Shadow restore failed")
+		return
+	}
+	entry.Directory = dirConfig.Name
+	log.Printf("Shadow copy %s restored to %s by %s", entry.Path, entry.RestoredTo, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(restoreResponse{Restored: entry.RestoredTo, Entry: entry})
+}
+
+// isWithin reports whether path is dir or below it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), path)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
@@ -0,0 +1,131 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/shadow"
+)
+
+func TestHandleShadow(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchPath := filepath.Join(tmpDir, "invoices")
+	shadowCfg := config.ShadowConfig{
+		Enabled:        true,
+		Path:           filepath.Join(tmpDir, "shadow"),
+		RetentionHours: 24,
+		Layout:         config.ShadowLayoutRelative,
+		RestorePaths:   []string{filepath.Join(tmpDir, "restore")},
+	}
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	dirs := []config.DirectoryConfig{
+		{Name: "invoices", WatchPath: watchPath, Shadow: shadowCfg},
+		{Name: "reports", WatchPath: filepath.Join(tmpDir, "reports")},
+	}
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	mgr, err := shadow.NewManager(shadowCfg)
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	mgr.SetWatchPath(watchPath)
+	source := filepath.Join(watchPath, "2026", "a.pdf")
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatalf("Failed to create watch path: %v", err)
+	}
+	if err := os.WriteFile(source, []byte("invoice"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := mgr.Store(source); err != nil {
+		t.Fatalf("Failed to store shadow copy: %v", err)
+	}
+	if err := os.Remove(source); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+
+	search := func(r *http.Request) (int, []shadow.ManifestEntry) {
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, r)
+		var resp shadowResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, resp.Entries
+	}
+	code, entries := search(httptest.NewRequest("GET", "/shadow?name=a.pdf", nil))
+	if code != http.StatusOK || len(entries) != 1 || entries[0].Directory != "invoices" || entries[0].Source != source {
+		t.Fatalf("Expected the invoice copy, got %d %+v", code, entries)
+	}
+	if code, entries := search(httptest.NewRequest("GET", "/shadow?name=missing", nil)); code != http.StatusOK || entries == nil || len(entries) != 0 {
+		t.Errorf("Expected an empty list, got %d %+v", code, entries)
+	}
+	if code, _ := search(httptest.NewRequest("GET", "/shadow?dir=reports", nil)); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without shadow copies, got %d", code)
+	}
+	if code, _ := search(httptest.NewRequest("GET", "/shadow?until=soon", nil)); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid until, got %d", code)
+	}
+
+	restore := func(body string) (int, ErrorCode) {
+		w := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/shadow/restore", bytes.NewBufferString(body)))
+		var errResp ErrorResponse
+		_ = json.NewDecoder(w.Body).Decode(&errResp)
+		return w.Code, errResp.Error.Code
+	}
+	body, _ := json.Marshal(restoreRequest{Directory: "invoices", Path: entries[0].Path})
+
+	// Restored into the watch path below its original relative path
+	if code, _ := restore(string(body)); code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", code)
+	}
+	if data, _ := os.ReadFile(source); string(data) != "invoice" {
+		t.Errorf("Expected the file to be restored to %s, got %q", source, data)
+	}
+	if code, errCode := restore(string(body)); code != http.StatusConflict || errCode != ErrCodeFileExists {
+		t.Errorf("Expected 409 %s for an existing file, got %d %s", ErrCodeFileExists, code, errCode)
+	}
+
+	// Other targets must be below restore_paths
+	to := filepath.Join(tmpDir, "restore", "audit")
+	body, _ = json.Marshal(restoreRequest{Directory: "invoices", Path: entries[0].Path, To: to})
+	if code, _ := restore(string(body)); code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(to, "2026", "a.pdf")); err != nil {
+		t.Errorf("Expected the file below %s: %v", to, err)
+	}
+	body, _ = json.Marshal(restoreRequest{Directory: "invoices", Path: entries[0].Path, To: filepath.Join(tmpDir, "elsewhere")})
+	if code, errCode := restore(string(body)); code != http.StatusBadRequest || errCode != ErrCodeInvalidPath {
+		t.Errorf("Expected 400 %s outside restore_paths, got %d %s", ErrCodeInvalidPath, code, errCode)
+	}
+	body, _ = json.Marshal(restoreRequest{Directory: "invoices", Path: filepath.Join(shadowCfg.Path, "missing.pdf")})
+	if code, errCode := restore(string(body)); code != http.StatusNotFound || errCode != ErrCodeMissingFile {
+		t.Errorf("Expected 404 %s for an unknown copy, got %d %s", ErrCodeMissingFile, code, errCode)
+	}
+	body, _ = json.Marshal(restoreRequest{Directory: "reports", Path: entries[0].Path})
+	if code, errCode := restore(string(body)); code != http.StatusNotFound || errCode != ErrCodeShadowDisabled {
+		t.Errorf("Expected 404 %s without shadow copies, got %d %s", ErrCodeShadowDisabled, code, errCode)
+	}
+
+	// Clients restricted to other directories cannot restore
+	r := httptest.NewRequest("POST", "/shadow/restore", bytes.NewBufferString(`{"directory":"invoices","path":"x"}`))
+	r = r.WithContext(context.WithValue(r.Context(), allowedDirsKey, []string{"reports"}))
+	w := httptest.NewRecorder()
+	server.handleShadowRestore(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ReadinessCheck is the result of one check run by /ready
+type ReadinessCheck struct {
+	Name      string `json:"name"`                // what was checked, e.g. watch_path or queue
+	Directory string `json:"directory,omitempty"` // directory the check belongs to, if any
+	Target    string `json:"target,omitempty"`    // path or URL checked
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the /ready response. Error is set when a check failed.
+type ReadinessResponse struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+	Error  *ErrorDetail     `json:"error,omitempty"`
+}
+
+// SetStatusProvider sets the function that builds the /status response.
+// Must be called before Start.
+func (s *Server) SetStatusProvider(provider func() any) {
+	s.status = provider
+}
+
+// SetReadinessCheck sets the function running the /ready checks. The service
+// is ready if every check is OK. Must be called before Start.
+func (s *Server) SetReadinessCheck(check func(ctx context.Context) []ReadinessCheck) {
+	s.ready = check
+}
+
+// handleStatus reports service state such as startup backlog progress
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var status any = struct{}{}
+	if s.status != nil {
+		status = s.status()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// handleReady runs the readiness checks and reports each result, with 503 if
+// any failed
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	resp := ReadinessResponse{Ready: true, Checks: []ReadinessCheck{}}
+	if s.draining.Load() {
+		resp.Checks = append(resp.Checks, ReadinessCheck{Name: "draining", Error: "service is draining"})
+	} else if s.ready != nil {
+		resp.Checks = append(resp.Checks, s.ready(r.Context())...)
+	}
+	var failed []string
+	for _, check := range resp.Checks {
+		if !check.OK {
+			failed = append(failed, check.Error)
+		}
+	}
+
+	status := http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusServiceUnavailable
+		resp.Ready = false
+		resp.Error = &ErrorDetail{Code: ErrCodeNotReady, Message: strings.Join(failed, "; ")}
+		log.Printf("Request failed [%s] %s %s from %s: %d %s", ErrCodeNotReady, r.Method, r.URL.Path, r.RemoteAddr, status, resp.Error.Message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,46 @@
+package ingress
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestHandleStatus(t *testing.T) {
+	server, err := NewServer(config.ServerConfig{TempDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleStatus(w, httptest.NewRequest("GET", "/status", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{}\n" {
+		t.Errorf("Expected empty status without provider, got %d %q", w.Code, w.Body.String())
+	}
+
+	server.SetStatusProvider(func() any {
+		return map[string]int{"queued": 3}
+	})
+	w = httptest.NewRecorder()
+	server.handleStatus(w, httptest.NewRequest("GET", "/status", nil))
+
+	var status map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status["queued"] != 3 {
+		t.Errorf("Expected queued 3, got %v", status)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %s", ct)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleStatus(w, httptest.NewRequest("POST", "/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package ingress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/diskspace"
+)
+
+// ValidateResponse is returned by the pre-validation endpoint when an upload would be accepted
+type ValidateResponse struct {
+	Valid     bool   `json:"valid"`
+	Directory string `json:"directory"`
+	Filename  string `json:"filename"`
+	Path      string `json:"path"`           // destination relative to the ingest directory
+	Size      int64  `json:"size,omitempty"` // declared size, if provided
+}
+
+// handleValidate checks whether an upload would be accepted without transferring the body.
+// Filename comes from the "filename" query parameter or X-Filename header, the size from
+// the "size" query parameter or X-File-Size header.
+// URL format: /validate/{directory_name}[/subdirectory/path]
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Extract path after /validate/
+	validatePath := strings.TrimPrefix(r.URL.Path, "/validate/")
+	if validatePath == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeDirectoryRequired, "Directory name required")
+		return
+	}
+
+	pathParts := strings.SplitN(validatePath, "/", 2)
+	dirName := pathParts[0]
+	var subdirPath string
+	if len(pathParts) > 1 {
+		subdirPath = pathParts[1]
+	}
+
+	dirConfig, exists := s.lookupDirectory(r, dirName)
+	if !exists {
+		writeError(w, r, http.StatusNotFound, ErrCodeUnknownDirectory, "Unknown directory")
+		return
+	}
+
+	if !isDirectoryAllowed(r, dirName) {
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Forbidden")
+		return
+	}
+
+	if apiErr := s.checkLowDisk(dirConfig); apiErr != nil {
+		writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		filename = r.Header.Get("X-Filename")
+	}
+	if filename == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeFilenameRequired, "Filename required")
+		return
+	}
+
+	safeFilename, err := sanitizeFilename(filename)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidFilename, fmt.Sprintf("Invalid filename: %v", err))
+		return
+	}
+
+	targetRelPath := safeFilename
+	if subdirPath != "" {
+		safeSubdir, subdirErr := sanitizeSubdirectoryPath(subdirPath)
+		if subdirErr != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, fmt.Sprintf("Invalid subdirectory path: %v", subdirErr))
+			return
+		}
+		if policyErr := checkSubdirectoryPolicy(&dirConfig, safeSubdir); policyErr != nil {
+			writeError(w, r, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Subdirectory not permitted: %v", policyErr))
+			return
+		}
+		targetRelPath = filepath.Join(safeSubdir, safeFilename)
+	}
+
+	if _, err := validateSubdirectoryPath(dirConfig.GetIngestPath(), targetRelPath); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPath, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+
+	// Declared size is optional; when present it must fit on disk
+	var size int64
+	sizeStr := r.URL.Query().Get("size")
+	if sizeStr == "" {
+		sizeStr = r.Header.Get("X-File-Size")
+	}
+	if sizeStr != "" {
+		size, err = strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || size < 0 {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid size: %s", sizeStr))
+			return
+		}
+
+		if apiErr := s.checkUploadSize(size, dirConfig); apiErr != nil {
+			writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+			return
+		}
+
+		if apiErr := s.checkDiskSpace(size, dirConfig); apiErr != nil {
+			writeError(w, r, apiErr.status, apiErr.code, apiErr.message)
+			return
+		}
+	}
+
+	log.Printf("Upload pre-validation passed: %s -> %s (%d bytes)", safeFilename, dirConfig.Name, size)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(ValidateResponse{
+		Valid:     true,
+		Directory: dirConfig.Name,
+		Filename:  safeFilename,
+		Path:      filepath.ToSlash(targetRelPath),
+		Size:      size,
+	})
+}
+
+// apiError is a request failure carrying its HTTP status and error code
+type apiError struct {
+	status  int
+	code    ErrorCode
+	message string
+}
+
+// maxUploadBytes returns the upload size limit for a directory (0 = unlimited)
+func (s *Server) maxUploadBytes(dir config.DirectoryConfig) int64 {
+	if dir.MaxUploadBytes > 0 {
+		return dir.MaxUploadBytes
+	}
+	return s.config.MaxUploadBytes
+}
+
+// checkUploadSize verifies that size bytes do not exceed the directory's upload limit
+func (s *Server) checkUploadSize(size int64, dir config.DirectoryConfig) *apiError {
+	if limit := s.maxUploadBytes(dir); limit > 0 && size > limit {
+		return payloadTooLarge(limit)
+	}
+	return nil
+}
+
+// payloadTooLarge returns the error for uploads exceeding limit bytes
+func payloadTooLarge(limit int64) *apiError {
+	return &apiError{
+		status:  http.StatusRequestEntityTooLarge,
+		code:    ErrCodePayloadTooLarge,
+		message: fmt.Sprintf("Upload exceeds maximum size of %d bytes", limit),
+	}
+}
+
+// checkDiskSpace verifies that size bytes fit in both the temp and ingest directories.
+// Directories whose free space cannot be determined are not treated as full.
+func (s *Server) checkDiskSpace(size int64, dirConfig config.DirectoryConfig) *apiError {
+	for _, dir := range []string{dirConfig.GetTempDir(s.config.TempDir), dirConfig.GetIngestPath()} {
+		free, err := diskspace.Available(dir)
+		if err != nil {
+			log.Printf("Could not determine free space for %s: %v", dir, err)
+			continue
+		}
+		if uint64(size) > free { // #nosec G115 -- size validated non-negative
+			return &apiError{
+				status:  http.StatusInsufficientStorage,
+				code:    ErrCodeInsufficientSpace,
+				message: fmt.Sprintf("Insufficient disk space: %d bytes required, %d available", size, free),
+			}
+		}
+	}
+	return nil
+}
+
+// checkLowDisk rejects uploads while the temp or ingest directory of a
+// directory is on a volume below disk_space.min_free_bytes
+func (s *Server) checkLowDisk(dirConfig config.DirectoryConfig) *apiError {
+	for _, dir := range []string{dirConfig.GetTempDir(s.config.TempDir), dirConfig.GetIngestPath()} {
+		if free, low := s.disk.Low(dir); low {
+			return &apiError{
+				status:  http.StatusInsufficientStorage,
+				code:    ErrCodeInsufficientSpace,
+				message: fmt.Sprintf("Insufficient disk space: %d bytes available, below the configured minimum", free),
+			}
+		}
+	}
+	return nil
+}
+
+// writeStorageError answers a failed write of an upload with 507 if the
+// volume filled up, or else 500
+func writeStorageError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	if errors.Is(err, syscall.ENOSPC) {
+		writeError(w, r, http.StatusInsufficientStorage, ErrCodeInsufficientSpace, fmt.Sprintf("Insufficient disk space: %v", err))
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, ErrCodeStorageError, fmt.Sprintf("%s: %v", message, err))
+}
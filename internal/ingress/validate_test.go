@@ -0,0 +1,171 @@
+package ingress
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/diskspace"
+)
+
+func TestHandleValidate(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+	}
+	dirs := []config.DirectoryConfig{
+		{Name: "test", WatchPath: watchDir},
+		{Name: "limited", WatchPath: watchDir, MaxUploadBytes: 100},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/validate/test/2025/01?filename=report.pdf", nil)
+		req.Header.Set("X-File-Size", "1024")
+		w := httptest.NewRecorder()
+
+		server.handleValidate(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp ValidateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !resp.Valid || resp.Path != "2025/01/report.pdf" || resp.Size != 1024 {
+			t.Errorf("Unexpected response: %+v", resp)
+		}
+
+		// Nothing must be written by pre-validation
+		if _, err := os.Stat(filepath.Join(watchDir, "2025")); !os.IsNotExist(err) {
+			t.Error("Expected no directories to be created by validation")
+		}
+	})
+
+	tests := []struct {
+		name       string
+		target     string
+		filename   string
+		size       string
+		wantStatus int
+		wantCode   ErrorCode
+	}{
+		{"UnknownDirectory", "/validate/missing", "a.txt", "", http.StatusNotFound, ErrCodeUnknownDirectory},
+		{"MissingFilename", "/validate/test", "", "", http.StatusBadRequest, ErrCodeFilenameRequired},
+		{"InvalidFilename", "/validate/test", "..", "", http.StatusBadRequest, ErrCodeInvalidFilename},
+		{"InvalidSubdirectory", "/validate/test/a//b", "a.txt", "", http.StatusBadRequest, ErrCodeInvalidPath},
+		{"InvalidSize", "/validate/test", "a.txt", "-5", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"TooLarge", "/validate/limited", "a.txt", "101", http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge},
+		{"InsufficientSpace", "/validate/test", "a.txt", "9223372036854775807", http.StatusInsufficientStorage, ErrCodeInsufficientSpace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, nil)
+			if tt.filename != "" {
+				req.Header.Set("X-Filename", tt.filename)
+			}
+			if tt.size != "" {
+				req.Header.Set("X-File-Size", tt.size)
+			}
+			w := httptest.NewRecorder()
+
+			server.handleValidate(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if resp.Error.Code != tt.wantCode {
+				t.Errorf("Expected code %s, got %s", tt.wantCode, resp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestHandleValidateRequiresAuth(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := config.ServerConfig{
+		Port:    8080,
+		TempDir: filepath.Join(tmpDir, "temp"),
+		BasicAuth: config.BasicAuthConfig{
+			Enabled:  true,
+			Username: "user",
+			Password: "pass",
+		},
+	}
+	dirs := []config.DirectoryConfig{
+		{Name: "test", WatchPath: filepath.Join(tmpDir, "watch")},
+	}
+
+	server, err := NewServer(cfg, dirs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/validate/test?filename=a.txt", nil)
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestLowDiskRejectsUploads(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch directory: %v", err)
+	}
+	cfg := config.ServerConfig{Port: 8080, TempDir: filepath.Join(tmpDir, "temp")}
+	server, err := NewServer(cfg, []config.DirectoryConfig{{Name: "test", WatchPath: watchDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// No volume has this much space
+	disk := diskspace.NewMonitor()
+	disk.Watch(watchDir, math.MaxUint64)
+	disk.Check()
+	server.SetDiskMonitor(disk)
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"/validate/test": server.handleValidate,
+		"/upload/test":   server.handleUpload,
+	} {
+		req := httptest.NewRequest("POST", name+"?filename=a.txt", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != http.StatusInsufficientStorage {
+			t.Errorf("%s: expected status 507, got %d: %s", name, w.Code, w.Body.String())
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Error.Code != ErrCodeInsufficientSpace {
+			t.Errorf("%s: expected code %s, got %+v (%v)", name, ErrCodeInsufficientSpace, resp, err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package journal ships file-level transfer events to an external collector
+// or a Kafka topic for data-flow monitoring.
+package journal
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+var journalEvents = metrics.NewCounterVec("xferd_journal_events_total",
+	"Transfer events shipped to the journal collector, by outcome: exported or dropped (queue full or collector unavailable)",
+	"outcome")
+
+// Event outcomes
+const (
+	OutcomeDetected    = "detected"    // queued for upload, only exported if listed in outcomes
+	OutcomeDelivered   = "delivered"   // uploaded to the destination
+	OutcomeFailed      = "failed"      // upload attempt failed, the file is kept
+	OutcomeRemoved     = "removed"     // deleted before it could be uploaded
+	OutcomeOverdue     = "overdue"     // not delivered within the directory's sla deadline
+	OutcomeQuarantined = "quarantined" // found infected by the malware scan and moved to quarantine_path
+)
+
+// stopTimeout bounds how long Stop waits for queued events to be sent
+const stopTimeout = 10 * time.Second
+
+// maxBackoff caps the wait between retries of a batch
+const maxBackoff = 30 * time.Second
+
+// kafkaContentType is the Kafka REST Proxy v2 format for JSON records
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// Event is a file-level transfer event
+type Event struct {
+	Time        time.Time `json:"time"`
+	Instance    string    `json:"instance,omitempty"` // instance.id, if enabled
+	Directory   string    `json:"directory"`
+	Path        string    `json:"path"`
+	TransferID  string    `json:"transfer_id,omitempty"` // correlates the file's log lines, from REST receipt to delivery
+	Size        int64     `json:"size,omitempty"`
+	Checksum    string    `json:"checksum,omitempty"` // SHA-256 of delivered files, if known or checksums is enabled
+	Outcome     string    `json:"outcome"`
+	Destination string    `json:"destination,omitempty"` // URL that accepted the file, with outbound failover
+	Archive     string    `json:"archive,omitempty"`     // batch archive the file was delivered in, with batching
+	Error       string    `json:"error,omitempty"`
+}
+
+// templateData is what an event template is rendered with
+type templateData struct {
+	Event
+	Filename string // base name of Path
+}
+
+// templateFuncs are available in event templates
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. to embed a path in a JSON string safely
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// kafkaRecord is a record produced through the Kafka REST Proxy
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Event  `json:"value"`
+}
+
+// Exporter batches transfer events and posts them to an HTTP collector as
+// JSON arrays, retrying failed batches with backoff. With a template, each
+// event is rendered and posted on its own instead; with kafka_rest, batches
+// are produced to a topic. Recording never blocks: events that do not fit
+// the queue are dropped and counted.
+type Exporter struct {
+	cfg      config.JournalConfig
+	endpoint string             // URL batches are posted to
+	tmpl     *template.Template // nil unless events are rendered with a template
+	key      *template.Template // Kafka record key, nil unless type is kafka_rest
+	instance string             // set on every event, empty unless instance identity is enabled
+	client   *http.Client
+	events   chan Event
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewExporter creates an exporter for cfg
+func NewExporter(cfg config.JournalConfig) (*Exporter, error) {
+	var tmpl *template.Template
+	if cfg.Template != "" {
+		var err error
+		tmpl, err = template.New("event").Funcs(templateFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal_export.template: %w", err)
+		}
+	}
+	endpoint := cfg.URL
+	var key *template.Template
+	if cfg.Type == "kafka_rest" {
+		endpoint = strings.TrimSuffix(cfg.URL, "/") + "/topics/" + url.PathEscape(cfg.Kafka.Topic)
+		var err error
+		key, err = template.New("key").Funcs(templateFuncs).Parse(cmp.Or(cfg.Kafka.Key, "{{.Path}}"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal_export.kafka.key: %w", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Exporter{
+		cfg:      cfg,
+		endpoint: endpoint,
+		tmpl:     tmpl,
+		key:      key,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		events:   make(chan Event, cfg.GetQueueSize()),
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// SetInstance names this instance in every event. Must be called before Start.
+func (e *Exporter) SetInstance(id string) {
+	e.instance = id
+}
+
+// Wants reports whether events with outcome are exported. Safe to call on a
+// nil exporter.
+func (e *Exporter) Wants(outcome string) bool {
+	if e == nil {
+		return false
+	}
+	if len(e.cfg.Outcomes) == 0 {
+		return outcome != OutcomeDetected
+	}
+	return slices.Contains(e.cfg.Outcomes, outcome)
+}
+
+// Checksums reports whether delivered events should carry a checksum. Safe
+// to call on a nil exporter.
+func (e *Exporter) Checksums() bool {
+	return e != nil && e.cfg.Checksums && e.Wants(OutcomeDelivered)
+}
+
+// Record queues an event for export. Safe to call on a nil exporter.
+func (e *Exporter) Record(ev Event) {
+	if !e.Wants(ev.Outcome) {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	ev.Instance = e.instance
+	if ev.TransferID == "" {
+		ev.TransferID = transferid.Lookup(ev.Path)
+	}
+	select {
+	case e.events <- ev:
+	default:
+		journalEvents.With("dropped").Inc()
+	}
+}
+
+// Start begins exporting queued events
+func (e *Exporter) Start() {
+	go e.run()
+}
+
+// Stop sends the events still queued, giving up after a timeout
+func (e *Exporter) Stop() {
+	close(e.stop)
+	select {
+	case <-e.done:
+	case <-time.After(stopTimeout):
+		e.cancel()
+		<-e.done
+	}
+	e.cancel()
+}
+
+// run collects events into batches, sent when full or at the flush interval
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.cfg.GetFlushInterval())
+	defer ticker.Stop()
+
+	batchSize := e.cfg.GetBatchSize()
+	batch := make([]Event, 0, batchSize)
+	for {
+		select {
+		case ev := <-e.events:
+			batch = append(batch, ev)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-e.stop:
+			e.flush(batch, batchSize)
+			return
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush sends batch and everything left in the queue
+func (e *Exporter) flush(batch []Event, batchSize int) {
+	for {
+		select {
+		case ev := <-e.events:
+			batch = append(batch, ev)
+			if len(batch) < batchSize {
+				continue
+			}
+		default:
+			if len(batch) > 0 {
+				e.send(batch)
+			}
+			return
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// send posts a batch as a JSON array, as Kafka records, or each event
+// rendered with the template
+func (e *Exporter) send(batch []Event) {
+	if e.key != nil {
+		e.sendKafka(batch)
+		return
+	}
+	if e.tmpl == nil {
+		body, err := json.Marshal(batch)
+		if err != nil {
+			log.Printf("Journal export: failed to encode %d events: %v", len(batch), err)
+			journalEvents.With("dropped").Add(uint64(len(batch)))
+			return
+		}
+		e.deliver(body, len(batch), "application/json")
+		return
+	}
+
+	for _, ev := range batch {
+		var body bytes.Buffer
+		if err := e.tmpl.Execute(&body, templateData{Event: ev, Filename: filepath.Base(ev.Path)}); err != nil {
+			log.Printf("Journal export: failed to render event for %s: %v", ev.Path, err)
+			journalEvents.With("dropped").Inc()
+			continue
+		}
+		e.deliver(body.Bytes(), 1, e.cfg.GetContentType())
+	}
+}
+
+// sendKafka produces a batch to the topic, keyed with the key template
+func (e *Exporter) sendKafka(batch []Event) {
+	records := make([]kafkaRecord, 0, len(batch))
+	for _, ev := range batch {
+		var key strings.Builder
+		if err := e.key.Execute(&key, templateData{Event: ev, Filename: filepath.Base(ev.Path)}); err != nil {
+			log.Printf("Journal export: failed to render record key for %s: %v", ev.Path, err)
+			journalEvents.With("dropped").Inc()
+			continue
+		}
+		records = append(records, kafkaRecord{Key: key.String(), Value: ev})
+	}
+	if len(records) == 0 {
+		return
+	}
+	body, err := json.Marshal(struct {
+		Records []kafkaRecord `json:"records"`
+	}{records})
+	if err != nil {
+		log.Printf("Journal export: failed to encode %d events: %v", len(records), err)
+		journalEvents.With("dropped").Add(uint64(len(records)))
+		return
+	}
+	e.deliver(body, len(records), kafkaContentType)
+}
+
+// deliver posts a body holding n events, retrying with backoff. Bodies that
+// still fail are dropped.
+func (e *Exporter) deliver(body []byte, n int, contentType string) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(body, contentType)
+		if err == nil {
+			journalEvents.With("exported").Add(uint64(n))
+			return
+		}
+		if !retry || attempt >= e.cfg.GetMaxRetries() {
+			log.Printf("Journal export: dropping %d events: %v", n, err)
+			journalEvents.With("dropped").Add(uint64(n))
+			return
+		}
+		select {
+		case <-e.ctx.Done():
+			journalEvents.With("dropped").Add(uint64(n))
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post sends one request. It reports whether a failure is worth retrying.
+func (e *Exporter) post(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range e.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("collector error: %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("collector rejected events: %d", resp.StatusCode)
+	}
+}
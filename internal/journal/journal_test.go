@@ -0,0 +1,295 @@
+package journal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+func TestExporterBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected configured Authorization header, got %q", r.Header.Get("Authorization"))
+		}
+		var batch []Event
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	exported := journalEvents.With("exported").Value()
+	e, _ := NewExporter(config.JournalConfig{
+		Enabled:         true,
+		URL:             server.URL,
+		Headers:         map[string]string{"Authorization": "Bearer secret"},
+		BatchSize:       2,
+		FlushIntervalMs: 60000,
+	})
+	e.Start()
+	for _, path := range []string{"/in/a", "/in/b", "/in/c"} {
+		e.Record(Event{Directory: "in", Path: path, Outcome: OutcomeDelivered})
+	}
+	// The partial batch is sent on Stop rather than at the flush interval
+	e.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("Expected batches of 2 and 1 events, got %v", batches)
+	}
+	if got := batches[1][0]; got.Path != "/in/c" || got.Outcome != OutcomeDelivered || got.Time.IsZero() {
+		t.Errorf("Unexpected event: %+v", got)
+	}
+	if got := journalEvents.With("exported").Value() - exported; got != 3 {
+		t.Errorf("Expected 3 exported events, got %d", got)
+	}
+}
+
+func TestExporterRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	exported := journalEvents.With("exported").Value()
+	e, _ := NewExporter(config.JournalConfig{Enabled: true, URL: server.URL, FlushIntervalMs: 10})
+	e.Start()
+	defer e.Stop()
+	e.Record(Event{Directory: "in", Path: "/in/a", Outcome: OutcomeFailed, Error: "boom"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for journalEvents.With("exported").Value() == exported {
+		if time.Now().After(deadline) {
+			t.Fatal("Event was not exported after a retry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected 2 requests, got %d", got)
+	}
+}
+
+func TestExporterRejected(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	dropped := journalEvents.With("dropped").Value()
+	e, _ := NewExporter(config.JournalConfig{Enabled: true, URL: server.URL})
+	e.Start()
+	e.Record(Event{Directory: "in", Path: "/in/a", Outcome: OutcomeRemoved})
+	e.Stop()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected rejected batch not to be retried, got %d requests", got)
+	}
+	if got := journalEvents.With("dropped").Value() - dropped; got != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", got)
+	}
+}
+
+func TestExporterQueueFull(t *testing.T) {
+	dropped := journalEvents.With("dropped").Value()
+	e, _ := NewExporter(config.JournalConfig{Enabled: true, URL: "http://127.0.0.1:1", QueueSize: 1})
+	e.Record(Event{Path: "/in/a"})
+	e.Record(Event{Path: "/in/b"})
+	if got := journalEvents.With("dropped").Value() - dropped; got != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", got)
+	}
+
+	// A nil exporter ignores events
+	var disabled *Exporter
+	disabled.Record(Event{Path: "/in/c"})
+}
+
+func TestExporterTemplate(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		contentType = r.Header.Get("Content-Type")
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	e, err := NewExporter(config.JournalConfig{
+		Enabled:  true,
+		URL:      server.URL,
+		Outcomes: []string{OutcomeFailed},
+		Template: `{"text": {{json (printf "%s: %s failed: %s" .Directory .Filename .Error)}}}`,
+	})
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	e.Start()
+	e.Record(Event{Directory: "in", Path: "/in/a.csv", Outcome: OutcomeDelivered})
+	e.Record(Event{Directory: "in", Path: "/in/b.csv", Outcome: OutcomeFailed, Error: `server error: 503 - "busy"`})
+	e.Record(Event{Directory: "in", Path: "/in/c.csv", Outcome: OutcomeFailed, Error: "timeout"})
+	e.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		`{"text": "in: b.csv failed: server error: 503 - \"busy\""}`,
+		`{"text": "in: c.csv failed: timeout"}`,
+	}
+	if len(bodies) != len(expected) {
+		t.Fatalf("Expected one request per failed event, got %q", bodies)
+	}
+	for i := range expected {
+		if bodies[i] != expected[i] {
+			t.Errorf("Expected body %s, got %s", expected[i], bodies[i])
+		}
+	}
+	if contentType != "application/json" {
+		t.Errorf("Expected application/json, got %q", contentType)
+	}
+}
+
+func TestExporterInvalidTemplate(t *testing.T) {
+	if _, err := NewExporter(config.JournalConfig{Enabled: true, URL: "http://127.0.0.1:1", Template: "{{.Path"}); err == nil {
+		t.Error("Expected error for invalid template")
+	}
+}
+
+func TestExporterInstance(t *testing.T) {
+	var mu sync.Mutex
+	var batch []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	e, _ := NewExporter(config.JournalConfig{Enabled: true, URL: server.URL})
+	e.SetInstance("edge-berlin")
+	e.Start()
+	e.Record(Event{Directory: "in", Path: "/in/a", Outcome: OutcomeDelivered})
+	e.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batch) != 1 || batch[0].Instance != "edge-berlin" {
+		t.Errorf("Expected event from instance edge-berlin, got %+v", batch)
+	}
+}
+
+func TestExporterTransferID(t *testing.T) {
+	var mu sync.Mutex
+	var batch []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	transferid.Assign("/in/a", "req-42")
+	defer transferid.Forget("/in/a")
+
+	e, _ := NewExporter(config.JournalConfig{Enabled: true, URL: server.URL})
+	e.Start()
+	e.Record(Event{Directory: "in", Path: "/in/a", Outcome: OutcomeDelivered})
+	e.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batch) != 1 || batch[0].TransferID != "req-42" {
+		t.Errorf("Expected event with transfer ID req-42, got %+v", batch)
+	}
+}
+
+func TestExporterKafka(t *testing.T) {
+	var mu sync.Mutex
+	var records []struct {
+		Key   string `json:"key"`
+		Value Event  `json:"value"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/file-events" {
+			t.Errorf("Expected request to /topics/file-events, got %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("Expected Kafka REST content type, got %q", got)
+		}
+		var body struct {
+			Records []struct {
+				Key   string `json:"key"`
+				Value Event  `json:"value"`
+			} `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode records: %v", err)
+		}
+		mu.Lock()
+		records = append(records, body.Records...)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	e, err := NewExporter(config.JournalConfig{
+		Enabled:  true,
+		Type:     "kafka_rest",
+		URL:      server.URL + "/",
+		Outcomes: []string{OutcomeDetected, OutcomeDelivered},
+		Kafka:    config.KafkaConfig{Topic: "file-events", Key: "{{.Directory}}/{{.Filename}}"},
+	})
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	e.Start()
+	e.Record(Event{Directory: "in", Path: "/in/a.csv", Outcome: OutcomeDetected})
+	e.Record(Event{Directory: "in", Path: "/in/a.csv", Size: 5, Checksum: "abc", Outcome: OutcomeDelivered})
+	e.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v", records)
+	}
+	if records[0].Key != "in/a.csv" || records[0].Value.Outcome != OutcomeDetected {
+		t.Errorf("Unexpected detection record: %+v", records[0])
+	}
+	if records[1].Value.Checksum != "abc" || records[1].Value.Size != 5 {
+		t.Errorf("Unexpected delivery record: %+v", records[1])
+	}
+}
+
+func TestExporterDetectedOptIn(t *testing.T) {
+	e, _ := NewExporter(config.JournalConfig{Enabled: true, URL: "http://127.0.0.1:1"})
+	if e.Wants(OutcomeDetected) || !e.Wants(OutcomeDelivered) {
+		t.Error("Expected all outcomes but detected by default")
+	}
+	var nilExporter *Exporter
+	if nilExporter.Wants(OutcomeDelivered) || nilExporter.Checksums() {
+		t.Error("Expected nil exporter to want nothing")
+	}
+}
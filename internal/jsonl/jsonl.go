@@ -0,0 +1,151 @@
+// Package jsonl keeps records in an append-only JSON Lines file, with
+// records older than a retention period removed by rewriting the file. It is
+// the storage shared by the transfer history and the file state: both are
+// written far more often than they are read, need no schema migrations and
+// stay readable with standard tools, at the cost of reading the whole file
+// to search it. Callers that are queried often keep an index in memory.
+package jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLineBytes bounds a single record line
+const maxLineBytes = 1 << 20
+
+// Store appends records of type T to a JSON Lines file
+type Store[T any] struct {
+	path      string
+	name      string // what the file holds, for errors, e.g. "history"
+	retention time.Duration
+	timeOf    func(T) time.Time // when a record was written, for pruning
+	mu        sync.Mutex
+	file      *os.File
+}
+
+// Open opens or creates the file at path and prunes records older than
+// retention, as timed by timeOf. name describes the file in errors.
+func Open[T any](path, name string, retention time.Duration, timeOf func(T) time.Time) (*Store[T], error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s directory: %w", name, err)
+	}
+	s := &Store[T]{path: path, name: name, retention: retention, timeOf: timeOf}
+	if _, err := s.Prune(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the file the records are kept in
+func (s *Store[T]) Path() string {
+	return s.path
+}
+
+// Append writes a record to the end of the file
+func (s *Store[T]) Append(v T) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Scan calls fn for every record, oldest first. Lines that cannot be
+// decoded, e.g. a line being appended, are skipped.
+func (s *Store[T]) Scan(fn func(T)) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.name, err)
+	}
+	defer file.Close()
+	return s.scan(file, fn)
+}
+
+// scan calls fn for every record in file
+func (s *Store[T]) scan(file *os.File, fn func(T)) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		var v T
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			continue
+		}
+		fn(v)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// Prune rewrites the file without records older than the retention period
+// and reopens it for appending. It returns how many records were removed.
+func (s *Store[T]) Prune(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now.Add(-s.retention)
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".xferd-"+strings.ReplaceAll(s.name, " ", "")+"-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", s.name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	expired := 0
+	if file, openErr := os.Open(s.path); openErr == nil {
+		err = s.scan(file, func(v T) {
+			if s.timeOf(v).Before(cutoff) {
+				expired++
+				return
+			}
+			line, _ := json.Marshal(v)
+			_, _ = writer.Write(append(line, '\n'))
+		})
+		file.Close()
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	} else if !os.IsNotExist(openErr) {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to open %s: %w", s.name, openErr)
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write %s: %w", s.name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", s.name, err)
+	}
+	// Windows cannot replace a file that is still open
+	if s.file != nil {
+		s.file.Close()
+	}
+	renameErr := os.Rename(tmp.Name(), s.path)
+	s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600) // #nosec G304 -- path from the configuration file
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", s.name, err)
+	}
+	if renameErr != nil {
+		return 0, fmt.Errorf("failed to replace %s: %w", s.name, renameErr)
+	}
+	return expired, nil
+}
+
+// Close closes the file
+func (s *Store[T]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,80 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type record struct {
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+}
+
+func openRecords(t *testing.T, path string) *Store[record] {
+	t.Helper()
+	s, err := Open(path, "records", 24*time.Hour, func(r record) time.Time { return r.Time })
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return s
+}
+
+func names(t *testing.T, s *Store[record]) string {
+	t.Helper()
+	var got []string
+	if err := s.Scan(func(r record) { got = append(got, r.Name) }); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	return strings.Join(got, ",")
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "records.jsonl")
+	s := openRecords(t, path)
+	now := time.Now().UTC()
+	for _, r := range []record{{now.Add(-48 * time.Hour), "old"}, {now, "a"}, {now, "b"}} {
+		if err := s.Append(r); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if got := names(t, s); got != "old,a,b" {
+		t.Errorf("Expected records oldest first, got %s", got)
+	}
+
+	expired, err := s.Prune(now)
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected 1 expired record, got %d %v", expired, err)
+	}
+	if got := names(t, s); got != "a,b" {
+		t.Errorf("Expected the expired record to be pruned, got %s", got)
+	}
+
+	// The file stays writable after pruning and survives reopening
+	if err := s.Append(record{now, "c"}); err != nil {
+		t.Fatalf("Append after Prune failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	s = openRecords(t, path)
+	defer s.Close()
+	if got := names(t, s); got != "a,b,c" {
+		t.Errorf("Expected records after reopening, got %s", got)
+	}
+}
+
+func TestStoreSkipsUndecodableLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	line := `{"time":"` + time.Now().UTC().Format(time.RFC3339) + `","name":"kept"}`
+	if err := os.WriteFile(path, []byte(line+"\n{\"time\":\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := openRecords(t, path)
+	defer s.Close()
+	if got := names(t, s); got != "kept" {
+		t.Errorf("Expected the partial line to be skipped, got %s", got)
+	}
+}
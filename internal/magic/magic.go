@@ -0,0 +1,81 @@
+// Package magic detects file types from their leading bytes (magic numbers),
+// so files can be handled by content rather than by name
+package magic
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+)
+
+// headerSize is how much of a file is read for detection
+const headerSize = 512
+
+// signature identifies a file type by bytes at a fixed offset
+type signature struct {
+	name   string
+	offset int
+	magic  []byte
+}
+
+// signatures are checked in order; the first match wins
+var signatures = []signature{
+	{"zip", 0, []byte("PK\x03\x04")},
+	{"zip", 0, []byte("PK\x05\x06")}, // empty archive
+	{"gzip", 0, []byte("\x1f\x8b")},
+	{"bzip2", 0, []byte("BZh")},
+	{"xz", 0, []byte("\xfd7zXZ\x00")},
+	{"7z", 0, []byte("7z\xbc\xaf\x27\x1c")},
+	{"zstd", 0, []byte("\x28\xb5\x2f\xfd")},
+	{"pdf", 0, []byte("%PDF-")},
+	{"png", 0, []byte("\x89PNG\r\n\x1a\n")},
+	{"jpeg", 0, []byte("\xff\xd8\xff")},
+	{"gif", 0, []byte("GIF87a")},
+	{"gif", 0, []byte("GIF89a")},
+	{"tiff", 0, []byte("II*\x00")},
+	{"tiff", 0, []byte("MM\x00*")},
+	{"elf", 0, []byte("\x7fELF")},
+	{"exe", 0, []byte("MZ")},
+	{"tar", 257, []byte("ustar")},
+}
+
+// Types lists the names Detect can return
+var Types = []string{"zip", "gzip", "bzip2", "xz", "7z", "zstd", "pdf", "png", "jpeg", "gif", "tiff", "elf", "exe", "tar", "xml"}
+
+// Known reports whether name is a type Detect can return
+func Known(name string) bool {
+	return slices.Contains(Types, name)
+}
+
+// Detect returns the type of content starting with header, or "" if unknown
+func Detect(header []byte) string {
+	for _, sig := range signatures {
+		if len(header) >= sig.offset+len(sig.magic) && bytes.Equal(header[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.name
+		}
+	}
+
+	// XML may start with a byte order mark and whitespace
+	text := bytes.TrimLeft(bytes.TrimPrefix(header, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if bytes.HasPrefix(text, []byte("<?xml")) {
+		return "xml"
+	}
+	return ""
+}
+
+// DetectFile returns the type of a file, or "" if unknown
+func DetectFile(path string) (string, error) {
+	f, err := os.Open(path) // #nosec G304 -- path is a watched file
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return Detect(header[:n]), nil
+}
@@ -0,0 +1,95 @@
+package magic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("a.txt")
+	_, _ = w.Write([]byte("hello"))
+	_ = zw.Close()
+
+	var gzipBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzipBuf)
+	_, _ = gw.Write([]byte("hello"))
+	_ = gw.Close()
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	_ = tw.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0644, Size: 5})
+	_, _ = tw.Write([]byte("hello"))
+	_ = tw.Close()
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string
+	}{
+		{"zip", zipBuf.Bytes(), "zip"},
+		{"gzip", gzipBuf.Bytes(), "gzip"},
+		{"tar", tarBuf.Bytes(), "tar"},
+		{"pdf", []byte("%PDF-1.7\n"), "pdf"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "png"},
+		{"xml", []byte(`<?xml version="1.0"?><a/>`), "xml"},
+		{"xml with BOM and whitespace", []byte("\xef\xbb\xbf\n  <?xml version=\"1.0\"?>"), "xml"},
+		{"plain text", []byte("a,b,c\n"), ""},
+		{"xml without declaration", []byte("<a/>"), ""},
+		{"empty", nil, ""},
+		{"truncated signature", []byte("PK"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.header); got != tt.expected {
+				t.Errorf("Detect() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetectFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A ZIP archive misnamed as XML is detected by content
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, _ = zw.Create("a.txt")
+	_ = zw.Close()
+	path := filepath.Join(tmpDir, "invoice.xml")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if got, err := DetectFile(path); err != nil || got != "zip" {
+		t.Errorf("Expected zip, got %q (err: %v)", got, err)
+	}
+
+	// Files shorter than the header are fine
+	short := filepath.Join(tmpDir, "short.txt")
+	if err := os.WriteFile(short, []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if got, err := DetectFile(short); err != nil || got != "" {
+		t.Errorf("Expected unknown type, got %q (err: %v)", got, err)
+	}
+
+	if _, err := DetectFile(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestKnown(t *testing.T) {
+	if !Known("zip") || !Known("xml") {
+		t.Error("Expected zip and xml to be known types")
+	}
+	if Known("docx") {
+		t.Error("Expected docx to be unknown")
+	}
+}
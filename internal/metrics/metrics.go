@@ -0,0 +1,282 @@
+// Package metrics provides minimal counters and gauges exposed in the
+// Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current counter value
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// CounterVec is a family of counters partitioned by label values
+type CounterVec struct {
+	name     string
+	help     string
+	labels   []string
+	mu       sync.RWMutex
+	counters map[string]*labeledCounter // joined label values -> counter
+}
+
+// labeledCounter is a counter together with its label values
+type labeledCounter struct {
+	Counter
+	values []string
+}
+
+// With returns the counter for the given label values, creating it if needed.
+// The number of values must match the labels the vector was created with.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.counters[key]
+	v.mu.RUnlock()
+	if ok {
+		return &c.Counter
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.counters[key]; !ok {
+		c = &labeledCounter{values: slices.Clone(values)}
+		v.counters[key] = c
+	}
+	return &c.Counter
+}
+
+// write writes the vector in the Prometheus text exposition format
+func (v *CounterVec) write(w io.Writer) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", v.name)
+
+	keys := make([]string, 0, len(v.counters))
+	for key := range v.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		c := v.counters[key]
+		fmt.Fprintf(w, "%s%s %d\n", v.name, formatLabels(v.labels, c.values), c.Value())
+	}
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits atomic.Uint64 // math.Float64bits of the value
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// GaugeVec is a family of gauges partitioned by label values
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.RWMutex
+	gauges map[string]*labeledGauge // joined label values -> gauge
+}
+
+// labeledGauge is a gauge together with its label values
+type labeledGauge struct {
+	Gauge
+	values []string
+}
+
+// With returns the gauge for the given label values, creating it if needed.
+// The number of values must match the labels the vector was created with.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	g, ok := v.gauges[key]
+	v.mu.RUnlock()
+	if ok {
+		return &g.Gauge
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if g, ok = v.gauges[key]; !ok {
+		g = &labeledGauge{values: slices.Clone(values)}
+		v.gauges[key] = g
+	}
+	return &g.Gauge
+}
+
+// write writes the vector in the Prometheus text exposition format
+func (v *GaugeVec) write(w io.Writer) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", v.name)
+
+	keys := make([]string, 0, len(v.gauges))
+	for key := range v.gauges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		g := v.gauges[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, g.values), strconv.FormatFloat(g.Value(), 'g', -1, 64))
+	}
+}
+
+// family is a metric family that can be written for exposition
+type family interface {
+	write(w io.Writer)
+}
+
+// Registry holds metric families for exposition
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// DefaultRegistry is the registry used by the package-level constructors
+var DefaultRegistry = NewRegistry()
+
+// NewCounterVec creates and registers a counter family. Registering the same
+// name twice returns the existing family.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v, ok := r.families[name]; ok {
+		return v.(*CounterVec)
+	}
+	v := &CounterVec{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		counters: make(map[string]*labeledCounter),
+	}
+	r.families[name] = v
+	return v
+}
+
+// NewCounterVec creates and registers a counter family in the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewGaugeVec creates and registers a gauge family. Registering the same
+// name twice returns the existing family.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v, ok := r.families[name]; ok {
+		return v.(*GaugeVec)
+	}
+	v := &GaugeVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		gauges: make(map[string]*labeledGauge),
+	}
+	r.families[name] = v
+	return v
+}
+
+// NewGaugeVec creates and registers a gauge family in the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// WriteText writes all registered metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]family, len(names))
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	r.mu.Unlock()
+
+	for _, v := range families {
+		v.write(w)
+	}
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// formatLabels renders label pairs as {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes the characters the text format requires in label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
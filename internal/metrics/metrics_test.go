@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	registry := NewRegistry()
+	checks := registry.NewCounterVec("test_checks_total", "Test checks", "directory", "outcome")
+
+	checks.With("invoices", "stable").Inc()
+	checks.With("invoices", "stable").Add(2)
+	checks.With("invoices", "timeout").Inc()
+
+	if got := checks.With("invoices", "stable").Value(); got != 3 {
+		t.Errorf("Expected 3, got %d", got)
+	}
+	if got := checks.With("reports", "stable").Value(); got != 0 {
+		t.Errorf("Expected 0 for unused labels, got %d", got)
+	}
+
+	// Registering the same name returns the existing family
+	if registry.NewCounterVec("test_checks_total", "Test checks", "directory", "outcome") != checks {
+		t.Error("Expected duplicate registration to return the existing family")
+	}
+}
+
+func TestCounterVecLabelCountMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for wrong number of label values")
+		}
+	}()
+	NewRegistry().NewCounterVec("test_total", "Test", "directory").With("a", "b")
+}
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("b_total", "Second family", "name").With(`quote"back\slash`).Inc()
+	registry.NewCounterVec("a_total", "First family").With().Add(5)
+
+	var b strings.Builder
+	registry.WriteText(&b)
+
+	expected := `# HELP a_total First family
+# TYPE a_total counter
+a_total 5
+# HELP b_total Second family
+# TYPE b_total counter
+b_total{name="quote\"back\\slash"} 1
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	registry := NewRegistry()
+	latency := registry.NewGaugeVec("test_latency_seconds", "Test latency", "directory")
+
+	latency.With("invoices").Set(2.5)
+	latency.With("invoices").Set(0.25)
+	if got := latency.With("invoices").Value(); got != 0.25 {
+		t.Errorf("Expected 0.25, got %v", got)
+	}
+	if registry.NewGaugeVec("test_latency_seconds", "Test latency", "directory") != latency {
+		t.Error("Expected duplicate registration to return the existing family")
+	}
+
+	var b strings.Builder
+	registry.WriteText(&b)
+	expected := `# HELP test_latency_seconds Test latency
+# TYPE test_latency_seconds gauge
+test_latency_seconds{directory="invoices"} 0.25
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "Test", "directory").With("invoices").Inc()
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type: %s", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), `test_total{directory="invoices"} 1`) {
+		t.Errorf("Expected counter in output, got: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
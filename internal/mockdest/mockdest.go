@@ -0,0 +1,238 @@
+// Package mockdest implements a configurable receiving endpoint for load and
+// acceptance testing of xferd deployments before they point at production
+// destinations.
+package mockdest
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checksumHeader is compared against the SHA-256 digest of received content
+const checksumHeader = "X-Checksum-SHA256"
+
+// Config defines the behaviour of the mock destination
+type Config struct {
+	Latency         time.Duration // Added to every upload response
+	Jitter          time.Duration // Random extra latency up to this value
+	FailureRate     float64       // Fraction of uploads answered with FailureStatus (0-1)
+	FailureStatus   int           // Status of injected failures (default 503)
+	Username        string        // Required basic auth user, with Password
+	Password        string
+	Token           string // Required bearer token
+	RequireChecksum bool   // Reject uploads without an X-Checksum-SHA256 header
+	StoreDir        string // Keep received files here; empty discards them
+}
+
+// Stats counts what the mock destination received
+type Stats struct {
+	Received           int64 `json:"received"`
+	Bytes              int64 `json:"bytes"`
+	InjectedFailures   int64 `json:"injected_failures"`
+	AuthFailures       int64 `json:"auth_failures"`
+	ChecksumMismatches int64 `json:"checksum_mismatches"`
+	BadRequests        int64 `json:"bad_requests"`
+}
+
+// Server is the mock destination handler. Uploads are accepted as multipart
+// forms (any file field) or raw bodies named by X-Filename or the URL path.
+// HEAD and GET confirm received files by name, and /_stats reports counters.
+type Server struct {
+	cfg      Config
+	ids      atomic.Int64
+	mu       sync.Mutex
+	stats    Stats
+	received map[string]string // file name to SHA-256 digest
+}
+
+// New creates a mock destination
+func New(cfg Config) *Server {
+	if cfg.FailureStatus == 0 {
+		cfg.FailureStatus = http.StatusServiceUnavailable
+	}
+	return &Server{cfg: cfg, received: make(map[string]string)}
+}
+
+// Stats returns a snapshot of the counters
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// count updates the counters under the lock
+func (s *Server) count(update func(*Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.stats)
+}
+
+// ServeHTTP dispatches uploads, confirmations and the stats endpoint
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/_stats" {
+		writeJSON(w, http.StatusOK, s.Stats())
+		return
+	}
+	if !s.authorized(r) {
+		s.count(func(st *Stats) { st.AuthFailures++ })
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "error", "error": "unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		s.upload(w, r)
+	case http.MethodHead, http.MethodGet:
+		s.confirm(w, r)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized checks the configured credentials
+func (s *Server) authorized(r *http.Request) bool {
+	if s.cfg.Username != "" {
+		user, pass, ok := r.BasicAuth()
+		return ok && subtle.ConstantTimeCompare([]byte(user), []byte(s.cfg.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(s.cfg.Password)) == 1
+	}
+	if s.cfg.Token != "" {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.cfg.Token)) == 1
+	}
+	return true
+}
+
+// upload receives one file, then applies latency and failure injection
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	name, size, digest, err := s.receive(r)
+	if err != nil {
+		s.count(func(st *Stats) { st.BadRequests++ })
+		writeJSON(w, http.StatusBadRequest, map[string]string{"status": "error", "error": err.Error()})
+		return
+	}
+
+	expected := r.Header.Get(checksumHeader)
+	if (expected == "" && s.cfg.RequireChecksum) || (expected != "" && !strings.EqualFold(expected, digest)) {
+		s.count(func(st *Stats) { st.ChecksumMismatches++ })
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"status": "error", "error": "checksum mismatch", "sha256": digest})
+		return
+	}
+
+	delay := s.cfg.Latency
+	if s.cfg.Jitter > 0 {
+		delay += rand.N(s.cfg.Jitter) // #nosec G404 -- test traffic shaping, not security
+	}
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	if s.cfg.FailureRate > 0 && rand.Float64() < s.cfg.FailureRate { // #nosec G404 -- test traffic shaping, not security
+		s.count(func(st *Stats) { st.InjectedFailures++ })
+		writeJSON(w, s.cfg.FailureStatus, map[string]string{"status": "error", "error": "injected failure"})
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.Received++
+	s.stats.Bytes += size
+	s.received[name] = digest
+	s.mu.Unlock()
+
+	id := strconv.FormatInt(s.ids.Add(1), 10)
+	w.Header().Set("X-Upload-ID", id)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "upload_id": id, "filename": name, "size": size, "sha256": digest})
+}
+
+// receive reads the uploaded content, returning its name, size and digest
+func (s *Server) receive(r *http.Request) (string, int64, string, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		name := r.Header.Get("X-Filename")
+		if name == "" {
+			name = path.Base(r.URL.Path)
+		}
+		return s.store(name, r.Body)
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", 0, "", fmt.Errorf("no file in multipart form")
+		}
+		if err != nil {
+			return "", 0, "", fmt.Errorf("invalid multipart form: %w", err)
+		}
+		if part.FileName() != "" {
+			return s.store(part.FileName(), part)
+		}
+	}
+}
+
+// store hashes content and keeps it in StoreDir if configured
+func (s *Server) store(name string, content io.Reader) (string, int64, string, error) {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		return "", 0, "", fmt.Errorf("missing file name")
+	}
+
+	dst := io.Discard
+	if s.cfg.StoreDir != "" {
+		f, err := os.Create(filepath.Join(s.cfg.StoreDir, name)) // #nosec G304 -- name is reduced to a base name
+		if err != nil {
+			return "", 0, "", err
+		}
+		defer f.Close()
+		dst = f
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), content)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to read content: %w", err)
+	}
+	return name, size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// confirm answers verification requests for received files by name
+func (s *Server) confirm(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	digest, ok := s.received[path.Base(r.URL.Path)]
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set(checksumHeader, digest)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON writes v with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package mockdest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// multipartBody builds a multipart form with one file
+func multipartBody(t *testing.T, name, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	_, _ = io.WriteString(part, content)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close multipart writer: %v", err)
+	}
+	return body, writer.FormDataContentType()
+}
+
+func TestMockDestinationReceives(t *testing.T) {
+	storeDir := t.TempDir()
+	mock := New(Config{StoreDir: storeDir})
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	body, contentType := multipartBody(t, "report.csv", "a,b\n")
+	resp, err := http.Post(server.URL+"/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	var result map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || result["status"] != "ok" || resp.Header.Get("X-Upload-ID") == "" {
+		t.Fatalf("Expected ok response with upload ID, got %d %v", resp.StatusCode, result)
+	}
+
+	// Raw bodies are named by X-Filename
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/upload", strings.NewReader("raw"))
+	req.Header.Set("X-Filename", "../raw.bin")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Raw upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected raw upload to succeed, got %d", resp.StatusCode)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(storeDir, "raw.bin")); err != nil || string(data) != "raw" {
+		t.Errorf("Expected stored raw.bin, got %q (%v)", data, err)
+	}
+	if got := mock.Stats(); got.Received != 2 || got.Bytes != 7 {
+		t.Errorf("Expected 2 files and 7 bytes received, got %+v", got)
+	}
+
+	// Received files can be confirmed by name
+	for name, want := range map[string]int{"report.csv": http.StatusOK, "missing.csv": http.StatusNotFound} {
+		resp, err := http.Head(server.URL + "/files/" + name)
+		if err != nil {
+			t.Fatalf("HEAD failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected HEAD %s to return %d, got %d", name, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestMockDestinationChecks(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		header  map[string]string
+		auth    func(*http.Request)
+		want    int
+		counter func(Stats) int64
+	}{
+		{"failure injection", Config{FailureRate: 1, FailureStatus: http.StatusBadGateway}, nil, nil,
+			http.StatusBadGateway, func(s Stats) int64 { return s.InjectedFailures }},
+		{"basic auth missing", Config{Username: "u", Password: "p"}, nil, nil,
+			http.StatusUnauthorized, func(s Stats) int64 { return s.AuthFailures }},
+		{"basic auth", Config{Username: "u", Password: "p"}, nil, func(r *http.Request) { r.SetBasicAuth("u", "p") },
+			http.StatusOK, func(s Stats) int64 { return s.Received }},
+		{"bearer token", Config{Token: "secret"}, nil, func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+			http.StatusOK, func(s Stats) int64 { return s.Received }},
+		{"checksum match", Config{RequireChecksum: true}, map[string]string{checksumHeader: checksum}, nil,
+			http.StatusOK, func(s Stats) int64 { return s.Received }},
+		{"checksum missing", Config{RequireChecksum: true}, nil, nil,
+			http.StatusUnprocessableEntity, func(s Stats) int64 { return s.ChecksumMismatches }},
+		{"checksum mismatch", Config{}, map[string]string{checksumHeader: "00"}, nil,
+			http.StatusUnprocessableEntity, func(s Stats) int64 { return s.ChecksumMismatches }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := New(tt.cfg)
+			server := httptest.NewServer(mock)
+			defer server.Close()
+
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/upload/test.txt", strings.NewReader("content"))
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+			if got := tt.counter(mock.Stats()); got != 1 {
+				t.Errorf("Expected counter to be 1, got %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Package perm gives the files and directories xferd creates the configured
+// mode and owner, so other processes can read what xferd writes.
+package perm
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// Perm holds the mode and owner of created files and directories. A nil
+// *Perm keeps the defaults of the caller and the process umask.
+type Perm struct {
+	fileMode os.FileMode // 0 keeps the mode the file was created with
+	dirMode  os.FileMode // 0 keeps the caller's default mode
+	uid      int         // -1 keeps the owner
+	gid      int         // -1 keeps the group
+}
+
+// New sets the process umask if configured and returns the permissions to
+// apply, or nil if neither modes nor an owner are configured
+func New(cfg config.PermissionsConfig) (*Perm, error) {
+	if cfg.Umask != "" {
+		umask, err := config.ParseMode(cfg.Umask)
+		if err != nil {
+			return nil, fmt.Errorf("permissions.umask: %w", err)
+		}
+		if err := setUmask(umask); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.FileMode == "" && cfg.DirMode == "" && cfg.Owner == "" && cfg.Group == "" {
+		return nil, nil
+	}
+
+	p := &Perm{uid: -1, gid: -1}
+	var err error
+	if p.fileMode, err = config.ParseMode(cfg.FileMode); err != nil {
+		return nil, fmt.Errorf("permissions.file_mode: %w", err)
+	}
+	if p.dirMode, err = config.ParseMode(cfg.DirMode); err != nil {
+		return nil, fmt.Errorf("permissions.dir_mode: %w", err)
+	}
+	if cfg.Owner != "" {
+		if p.uid, err = lookupID(cfg.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("permissions.owner: %w", err)
+		}
+	}
+	if cfg.Group != "" {
+		if p.gid, err = lookupID(cfg.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("permissions.group: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// lookupID returns a numeric id as is, and looks up names
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// MkdirAll creates path and its missing parents with the configured
+// directory mode, or def if none is configured. Only directories it created
+// are given the configured mode and owner.
+func (p *Perm) MkdirAll(path string, def os.FileMode) error {
+	if p == nil {
+		return os.MkdirAll(path, def)
+	}
+
+	var created []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		created = append(created, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	mode := def
+	if p.dirMode != 0 {
+		mode = p.dirMode
+	}
+	if err := os.MkdirAll(path, mode); err != nil {
+		return err
+	}
+	// created lists the deepest directory first
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := p.apply(created[i], p.dirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File gives an open file the configured mode and owner
+func (p *Perm) File(f *os.File) error {
+	if p == nil {
+		return nil
+	}
+	if p.fileMode != 0 {
+		if err := f.Chmod(p.fileMode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", f.Name(), err)
+		}
+	}
+	if p.uid >= 0 || p.gid >= 0 {
+		if err := f.Chown(p.uid, p.gid); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", f.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Apply gives the file at path the configured mode and owner
+func (p *Perm) Apply(path string) error {
+	if p == nil {
+		return nil
+	}
+	return p.apply(path, p.fileMode)
+}
+
+// apply sets mode, unless it is 0, and the configured owner of path
+func (p *Perm) apply(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", path, err)
+		}
+	}
+	if p.uid >= 0 || p.gid >= 0 {
+		if err := os.Chown(path, p.uid, p.gid); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", path, err)
+		}
+	}
+	return nil
+}
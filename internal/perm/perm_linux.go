@@ -0,0 +1,14 @@
+//go:build linux
+
+package perm
+
+import (
+	"os"
+	"syscall"
+)
+
+// setUmask sets the umask of the process
+func setUmask(umask os.FileMode) error {
+	syscall.Umask(int(umask))
+	return nil
+}
@@ -0,0 +1,92 @@
+package perm
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestNewNothingConfigured(t *testing.T) {
+	p, err := New(config.PermissionsConfig{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if p != nil {
+		t.Error("Expected nil permissions when nothing is configured")
+	}
+
+	// A nil *Perm falls back to the defaults
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := p.MkdirAll(dir, 0o750); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := p.Apply(dir); err != nil {
+		t.Errorf("Apply failed: %v", err)
+	}
+}
+
+func TestModes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("file modes are only enforced on Linux")
+	}
+	p, err := New(config.PermissionsConfig{FileMode: "0640", DirMode: "0751"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	root := t.TempDir()
+	if err := os.Chmod(root, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "a", "b")
+	if err := p.MkdirAll(dir, 0o700); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	for _, d := range []string{filepath.Join(root, "a"), dir} {
+		if info, err := os.Stat(d); err != nil || info.Mode().Perm() != 0o751 {
+			t.Errorf("Expected %s to have mode 0751, got %v (%v)", d, info.Mode().Perm(), err)
+		}
+	}
+	if info, _ := os.Stat(root); info.Mode().Perm() != 0o700 {
+		t.Errorf("Expected existing directory to keep its mode, got %v", info.Mode().Perm())
+	}
+
+	path := filepath.Join(dir, "file.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.File(f); err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Errorf("Expected file mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestNumericOwner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("owners are only supported on Linux")
+	}
+	// Chowning to the current owner needs no privileges
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	p, err := New(config.PermissionsConfig{Owner: uid, Group: gid})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if p.uid != os.Getuid() || p.gid != os.Getgid() {
+		t.Errorf("Expected %s:%s, got %d:%d", uid, gid, p.uid, p.gid)
+	}
+	if err := p.MkdirAll(filepath.Join(t.TempDir(), "owned"), 0o755); err != nil {
+		t.Errorf("MkdirAll failed: %v", err)
+	}
+
+	if _, err := New(config.PermissionsConfig{Owner: "no-such-user-xferd"}); err == nil {
+		t.Error("Expected unknown owner to fail")
+	}
+}
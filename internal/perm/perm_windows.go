@@ -0,0 +1,13 @@
+//go:build windows
+
+package perm
+
+import (
+	"errors"
+	"os"
+)
+
+// setUmask fails, Windows has no umask
+func setUmask(os.FileMode) error {
+	return errors.New("permissions.umask is only supported on Linux")
+}
@@ -0,0 +1,114 @@
+// Package quarantine moves files that failed validation out of the watch
+// path into a directory for inspection, each next to a sidecar naming why it
+// was rejected
+package quarantine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+// quarantinedFiles counts files moved to quarantine
+var quarantinedFiles = metrics.NewCounterVec("xferd_quarantined_files_total",
+	"Files moved to quarantine_path because they failed validation",
+	"directory", "reason")
+
+// Reasons a file is quarantined for
+const (
+	ReasonTooSmall         = "too_small"
+	ReasonTooLarge         = "too_large"
+	ReasonBlockedExtension = "blocked_extension"
+	ReasonChecksumMismatch = "checksum_mismatch"
+	ReasonInfected         = "infected"
+	ReasonAbandoned        = "abandoned"   // a staged upload that was never completed
+	ReasonHookFailed       = "hook_failed" // delivered, but its post-upload hook failed
+)
+
+// SidecarSuffix is appended to a quarantined file's name for its sidecar
+const SidecarSuffix = ".reason.json"
+
+// Record is the content of a sidecar
+type Record struct {
+	File       string    `json:"file"` // where the file was found
+	Directory  string    `json:"directory"`
+	Reason     string    `json:"reason"`
+	Detail     string    `json:"detail,omitempty"`
+	Size       int64     `json:"size"`
+	TransferID string    `json:"transfer_id,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Target returns a free path for a file in dir, below its path relative to
+// root. A file quarantined earlier under the same name is not overwritten: a
+// numeric suffix is added instead.
+func Target(dir, root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(path)
+	}
+	target := filepath.Join(dir, rel)
+	ext := filepath.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	for i := 1; exists(target); i++ {
+		target = base + "." + strconv.Itoa(i) + ext
+	}
+	return target
+}
+
+// exists reports whether a file or its sidecar is at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	_, sidecarErr := os.Lstat(path + SidecarSuffix)
+	return err == nil || sidecarErr == nil
+}
+
+// Move moves a file below root into dir and writes its sidecar. It returns
+// where the file was moved to. Files are moved by renaming, so dir should be
+// on the same filesystem as root.
+func Move(directory, dir, root, path, reason, detail string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	target := Target(dir, root, path)
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("failed to move file to quarantine: %w", err)
+	}
+	rec := Record{
+		File:       path,
+		Directory:  directory,
+		Reason:     reason,
+		Detail:     detail,
+		Size:       info.Size(),
+		TransferID: transferid.Lookup(path),
+	}
+	return target, Write(target, rec)
+}
+
+// Write writes the sidecar of a file placed in quarantine at target and
+// counts it. Time is set to now if it is zero.
+func Write(target string, rec Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	quarantinedFiles.With(rec.Directory, rec.Reason).Inc()
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(target+SidecarSuffix, append(data, '\n'), 0o640); err != nil {
+		return fmt.Errorf("failed to write quarantine sidecar: %w", err)
+	}
+	return nil
+}
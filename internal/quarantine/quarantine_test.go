@@ -0,0 +1,61 @@
+package quarantine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+func TestMove(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "quarantine")
+	path := filepath.Join(root, "in", "orders.csv")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("a,b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	transferid.Assign(path, "0123456789abcdef")
+	defer transferid.Forget(path)
+
+	before := quarantinedFiles.With("orders", ReasonTooSmall).Value()
+	target, err := Move("orders", dir, root, path, ReasonTooSmall, "size 3 bytes is outside the allowed range")
+	if err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if target != filepath.Join(dir, "in", "orders.csv") {
+		t.Errorf("Expected the file below its relative path, got %s", target)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be moved: %v", err)
+	}
+	if got := quarantinedFiles.With("orders", ReasonTooSmall).Value(); got != before+1 {
+		t.Errorf("Expected count %d, got %d", before+1, got)
+	}
+
+	data, err := os.ReadFile(target + SidecarSuffix)
+	if err != nil {
+		t.Fatalf("Expected a sidecar: %v", err)
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("Failed to decode sidecar: %v", err)
+	}
+	if rec.File != path || rec.Reason != ReasonTooSmall || rec.Size != 3 || rec.TransferID != "0123456789abcdef" || rec.Time.IsZero() {
+		t.Errorf("Unexpected sidecar: %+v", rec)
+	}
+
+	// A later file of the same name does not overwrite it
+	if next := Target(dir, root, path); next != filepath.Join(dir, "in", "orders.1.csv") {
+		t.Errorf("Expected a numbered name for a taken path, got %s", next)
+	}
+
+	// Files outside root keep their name only
+	if got := Target(dir, root, "/elsewhere/x.bin"); got != filepath.Join(dir, "x.bin") {
+		t.Errorf("Expected a file outside root at the top of dir, got %s", got)
+	}
+}
@@ -0,0 +1,102 @@
+package quarantine
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Usage is what a quarantine directory holds
+type Usage struct {
+	Files   int   // quarantined files, not counting sidecars
+	Bytes   int64 // their size including sidecars
+	Removed int   // files removed by Prune
+}
+
+// entry is a quarantined file together with its sidecar
+type entry struct {
+	path  string // the file; it may be gone while its sidecar is left
+	size  int64
+	moved time.Time
+}
+
+// Prune removes files from the quarantine directory dir that were moved
+// there more than maxAge before now, then the oldest ones while dir holds
+// more than maxBytes. A zero limit is not applied. Sidecars are removed with
+// their file, and their modification time, written when the file was
+// quarantined, decides its age. It returns what dir holds afterwards.
+func Prune(dir string, maxAge time.Duration, maxBytes int64, now time.Time) (Usage, error) {
+	entries := map[string]*entry{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		file, isSidecar := strings.CutSuffix(path, SidecarSuffix)
+		e := entries[file]
+		if e == nil {
+			e = &entry{path: file}
+			entries[file] = e
+		}
+		e.size += info.Size()
+		// The sidecar is written when the file is moved; the file keeps its
+		// original modification time
+		if isSidecar || e.moved.IsZero() {
+			e.moved = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	sorted := make([]*entry, 0, len(entries))
+	var usage Usage
+	for _, e := range entries {
+		sorted = append(sorted, e)
+		usage.Bytes += e.size
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].moved.Before(sorted[j].moved) })
+
+	var firstErr error
+	for _, e := range sorted {
+		expired := maxAge > 0 && now.Sub(e.moved) > maxAge
+		if !expired && (maxBytes <= 0 || usage.Bytes <= maxBytes) {
+			usage.Files++
+			continue
+		}
+		if err := remove(e.path); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			usage.Files++
+			continue
+		}
+		usage.Bytes -= e.size
+		usage.Removed++
+	}
+	return usage, firstErr
+}
+
+// remove deletes a quarantined file and its sidecar
+func remove(path string) error {
+	for _, p := range []string{path, path + SidecarSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
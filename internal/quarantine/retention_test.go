@@ -0,0 +1,68 @@
+package quarantine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// quarantineAt places a file of size bytes in dir with a sidecar written at moved
+func quarantineAt(t *testing.T, dir, name string, size int, moved time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+SidecarSuffix, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path+SidecarSuffix, moved, moved); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	expired := quarantineAt(t, dir, "old.csv", 100, now.Add(-72*time.Hour))
+	older := quarantineAt(t, dir, "in/older.csv", 100, now.Add(-3*time.Hour))
+	newer := quarantineAt(t, dir, "newer.csv", 100, now.Add(-time.Hour))
+
+	// Without limits nothing is removed
+	usage, err := Prune(dir, 0, 0, now)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if usage.Files != 3 || usage.Bytes != 306 || usage.Removed != 0 {
+		t.Errorf("Expected 3 files of 306 bytes, got %+v", usage)
+	}
+
+	// Files past the age limit go first, then the oldest until under max_bytes
+	usage, err = Prune(dir, 48*time.Hour, 150, now)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if usage.Files != 1 || usage.Bytes != 102 || usage.Removed != 2 {
+		t.Errorf("Expected 1 file of 102 bytes left after removing 2, got %+v", usage)
+	}
+	for _, path := range []string{expired, expired + SidecarSuffix, older, older + SidecarSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(newer); err != nil {
+		t.Errorf("Expected the newest file to be kept: %v", err)
+	}
+}
+
+func TestPruneMissingDirectory(t *testing.T) {
+	usage, err := Prune(filepath.Join(t.TempDir(), "missing"), time.Hour, 1, time.Now())
+	if err != nil || usage != (Usage{}) {
+		t.Errorf("Expected an empty usage for a missing directory, got %+v, %v", usage, err)
+	}
+}
@@ -0,0 +1,119 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// icap scans content with an ICAP server (RFC 3507), sending it as the body
+// of an HTTP response in a RESPMOD request. The server answers 204 for clean
+// content and 200 with a replacement response for infected content.
+type icap struct {
+	url  *url.URL
+	host string // host:port, 1344 by default
+}
+
+// newICAP creates an ICAP scanner for a service URL such as icap://scanner/avscan
+func newICAP(rawURL string) (*icap, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ICAP URL: %s", rawURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	return &icap{url: u, host: host}, nil
+}
+
+// scan sends r in a RESPMOD request and reads the verdict
+func (c *icap) scan(ctx context.Context, r io.Reader, name string) (Result, error) {
+	conn, closeConn, err := dial(ctx, "tcp", c.host)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect to ICAP server: %w", err)
+	}
+	defer closeConn()
+
+	// The encapsulated request names the file, which some scanners log
+	reqHdr := fmt.Sprintf("GET /%s HTTP/1.1\r\nHost: xferd\r\n\r\n", url.PathEscape(filepath.Base(name)))
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nTransfer-Encoding: chunked\r\n\r\n"
+	w := bufio.NewWriterSize(conn, chunkSize+16)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.url)
+	fmt.Fprintf(w, "Host: %s\r\n", c.url.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr), len(reqHdr)+len(resHdr))
+	w.WriteString(reqHdr)
+	w.WriteString(resHdr)
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return Result{}, fmt.Errorf("failed to send file to ICAP server: %w", err)
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read ICAP response: %w", err)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read ICAP response: %w", err)
+	}
+	return parseICAPResponse(status, header)
+}
+
+// parseICAPResponse interprets the status line and headers of a RESPMOD
+// response. Scanners name the malware in X-Infection-Found (Threat=...),
+// X-Virus-ID or X-Violations-Found.
+func parseICAPResponse(status string, header textproto.MIMEHeader) (Result, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return Result{}, fmt.Errorf("invalid ICAP status line: %q", status)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid ICAP status line: %q", status)
+	}
+	switch code {
+	case 204:
+		return Result{}, nil
+	case 200:
+		res := Result{Infected: true}
+		for _, part := range strings.Split(header.Get("X-Infection-Found"), ";") {
+			if threat, ok := strings.CutPrefix(strings.TrimSpace(part), "Threat="); ok {
+				res.Signature = threat
+			}
+		}
+		if res.Signature == "" {
+			res.Signature = strings.TrimSpace(header.Get("X-Virus-ID"))
+		}
+		if res.Signature == "" {
+			res.Signature = strings.TrimSpace(header.Get("X-Violations-Found"))
+		}
+		return res, nil
+	}
+	return Result{}, fmt.Errorf("ICAP server answered %s", strings.Join(fields[1:], " "))
+}
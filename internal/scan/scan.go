@@ -0,0 +1,97 @@
+// Package scan checks files for malware with a ClamAV daemon or an ICAP
+// server before they leave the network
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// scans counts scan results
+var scans = metrics.NewCounterVec("xferd_scans_total",
+	"Malware scans before upload, by result: clean, infected or error (the scanner could not be reached or failed)",
+	"directory", "result")
+
+// chunkSize is how much of a file is sent to the scanner at once
+const chunkSize = 64 << 10
+
+// Result is the verdict of a scan
+type Result struct {
+	Infected  bool
+	Signature string // name of the detected malware, if the scanner reported it
+}
+
+// engine scans content with one kind of scanner
+type engine interface {
+	scan(ctx context.Context, r io.Reader, name string) (Result, error)
+}
+
+// Scanner scans the files of a directory
+type Scanner struct {
+	directory string
+	engine    engine
+	timeout   time.Duration
+}
+
+// New creates the scanner configured for a directory
+func New(directory string, cfg config.ScanConfig) (*Scanner, error) {
+	s := &Scanner{directory: directory, timeout: cfg.GetTimeout()}
+	switch cfg.GetType() {
+	case config.ScanTypeClamd:
+		s.engine = &clamd{address: cfg.Address}
+	case config.ScanTypeICAP:
+		icap, err := newICAP(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		s.engine = icap
+	default:
+		return nil, fmt.Errorf("unknown scan type: %s", cfg.Type)
+	}
+	return s, nil
+}
+
+// Scan scans a file. An error means the file could not be scanned and its
+// content is unknown.
+func (s *Scanner) Scan(ctx context.Context, path string) (Result, error) {
+	f, err := os.Open(path) // #nosec G304 -- files picked up from the watch path
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	res, err := s.engine.scan(ctx, f, path)
+	switch {
+	case err != nil:
+		scans.With(s.directory, "error").Inc()
+		return Result{}, fmt.Errorf("scan failed: %w", err)
+	case res.Infected:
+		scans.With(s.directory, "infected").Inc()
+	default:
+		scans.With(s.directory, "clean").Inc()
+	}
+	return res, nil
+}
+
+// dial connects to a scanner, aborting the connection once ctx is done
+func dial(ctx context.Context, network, address string) (net.Conn, func(), error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	return conn, func() { stop(); conn.Close() }, nil
+}
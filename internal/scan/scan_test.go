@@ -0,0 +1,242 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// eicar stands in for malware in the fake scanners
+const eicar = "EICAR-TEST"
+
+// fakeClamd answers INSTREAM requests on ln, reporting content containing
+// eicar as infected
+func fakeClamd(t *testing.T, ln net.Listener) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var content bytes.Buffer
+				for {
+					var n uint32
+					if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+						return
+					}
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&content, r, int64(n)); err != nil {
+						return
+					}
+				}
+				if strings.Contains(content.String(), eicar) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}()
+		}
+	}()
+}
+
+// fakeICAP answers RESPMOD requests on ln with 204, or 200 for content
+// containing eicar
+func fakeICAP(t *testing.T, ln net.Listener) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tp := textproto.NewReader(bufio.NewReader(conn))
+				if line, err := tp.ReadLine(); err != nil || !strings.HasPrefix(line, "RESPMOD icap://") {
+					return
+				}
+				if _, err := tp.ReadMIMEHeader(); err != nil {
+					return
+				}
+				// Encapsulated request and response headers
+				for range 2 {
+					if _, err := tp.ReadLine(); err != nil {
+						return
+					}
+					if _, err := tp.ReadMIMEHeader(); err != nil {
+						return
+					}
+				}
+				body, err := io.ReadAll(newChunkedReader(tp.R))
+				if err != nil {
+					return
+				}
+				if strings.Contains(string(body), eicar) {
+					conn.Write([]byte("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\nEncapsulated: null-body=0\r\n\r\n"))
+					return
+				}
+				conn.Write([]byte("ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n"))
+			}()
+		}
+	}()
+}
+
+// chunkedReader decodes an ICAP chunked body
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int64
+	done bool
+}
+
+func newChunkedReader(r *bufio.Reader) *chunkedReader { return &chunkedReader{r: r} }
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.left == 0 {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		size, err := parseHex(strings.TrimSpace(line))
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			c.done = true
+			_, _ = c.r.ReadString('\n')
+			return 0, io.EOF
+		}
+		c.left = size
+	}
+	n, err := c.r.Read(p[:min(int64(len(p)), c.left)])
+	c.left -= int64(n)
+	if c.left == 0 && err == nil {
+		_, err = c.r.ReadString('\n')
+	}
+	return n, err
+}
+
+func parseHex(s string) (int64, error) {
+	var n int64
+	for _, ch := range s {
+		switch {
+		case ch >= '0' && ch <= '9':
+			n = n*16 + int64(ch-'0')
+		case ch >= 'a' && ch <= 'f':
+			n = n*16 + int64(ch-'a'+10)
+		default:
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	return n, nil
+}
+
+func writeFiles(t *testing.T) (clean, infected string) {
+	t.Helper()
+	dir := t.TempDir()
+	clean = filepath.Join(dir, "clean.txt")
+	infected = filepath.Join(dir, "infected.txt")
+	// More than one chunk, with the signature in the second
+	content := strings.Repeat("x", chunkSize+100)
+	if err := os.WriteFile(clean, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(infected, []byte(content+eicar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return clean, infected
+}
+
+func TestScanClamd(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	fakeClamd(t, ln)
+
+	scanner, err := New("scanned", config.ScanConfig{Enabled: true, Address: socket})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	clean, infected := writeFiles(t)
+
+	infectedBefore := scans.With("scanned", "infected").Value()
+	if res, err := scanner.Scan(context.Background(), clean); err != nil || res.Infected {
+		t.Errorf("Expected clean result, got %+v, %v", res, err)
+	}
+	res, err := scanner.Scan(context.Background(), infected)
+	if err != nil || !res.Infected || res.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Expected infected result, got %+v, %v", res, err)
+	}
+	if got := scans.With("scanned", "infected").Value(); got != infectedBefore+1 {
+		t.Errorf("Expected infected count %d, got %d", infectedBefore+1, got)
+	}
+
+	// An unreachable scanner is an error, not a clean result
+	ln.Close()
+	if _, err := scanner.Scan(context.Background(), clean); err == nil {
+		t.Error("Expected an error without a scanner")
+	}
+}
+
+func TestScanICAP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	fakeICAP(t, ln)
+
+	scanner, err := New("scanned", config.ScanConfig{Enabled: true, Type: config.ScanTypeICAP, URL: "icap://" + ln.Addr().String() + "/avscan"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	clean, infected := writeFiles(t)
+
+	if res, err := scanner.Scan(context.Background(), clean); err != nil || res.Infected {
+		t.Errorf("Expected clean result, got %+v, %v", res, err)
+	}
+	res, err := scanner.Scan(context.Background(), infected)
+	if err != nil || !res.Infected || res.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Expected infected result, got %+v, %v", res, err)
+	}
+}
+
+func TestParseReplies(t *testing.T) {
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Error("Expected an error for a clamd error reply")
+	}
+
+	res, err := parseICAPResponse("ICAP/1.0 200 OK", textproto.MIMEHeader{"X-Virus-Id": {"Win.Trojan.Agent"}})
+	if err != nil || res.Signature != "Win.Trojan.Agent" {
+		t.Errorf("Expected signature from X-Virus-ID, got %+v, %v", res, err)
+	}
+	if _, err := parseICAPResponse("ICAP/1.0 500 Server Error", nil); err == nil {
+		t.Error("Expected an error for an ICAP server error")
+	}
+}
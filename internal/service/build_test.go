@@ -0,0 +1,25 @@
+package service
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestBuildTagsExcludePackages checks that the noingress tag leaves the
+// ingress package out of the binary, not just unused
+func TestBuildTagsExcludePackages(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("Skipping build check without the go tool or in short mode")
+	}
+	out, err := exec.Command(gobin, "list", "-deps", "-tags", "noingress", "github.com/muzy/xferd/cmd/xferd").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go list failed: %v\n%s", err, out)
+	}
+	for _, pkg := range strings.Fields(string(out)) {
+		if pkg == "github.com/muzy/xferd/internal/ingress" {
+			t.Errorf("noingress build depends on %s", pkg)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/encrypt"
+	"github.com/muzy/xferd/internal/shadow"
+	"github.com/muzy/xferd/internal/strftime"
+	"github.com/muzy/xferd/internal/uploader"
+)
+
+// CheckResult is the outcome of one configuration check
+type CheckResult struct {
+	Name      string // what was checked, e.g. watch_path or outbound_tls
+	Directory string // "" for server-wide checks
+	Target    string // the path or URL checked
+	Err       error
+}
+
+// CheckConfig checks what loading a configuration cannot: that its paths
+// exist, or can be created, with the permissions xferd needs, and that its
+// certificate and key files parse. With probe, HTTP destinations must answer
+// a HEAD request. Nothing is changed, except for hidden files created and
+// removed again to test that directories are writable.
+func CheckConfig(ctx context.Context, cfg *config.Config, probe bool) []CheckResult {
+	var results []CheckResult
+	add := func(name, directory, target string, err error) {
+		results = append(results, CheckResult{Name: name, Directory: directory, Target: target, Err: err})
+	}
+
+	add("temp_dir", "", cfg.Server.TempDir, checkDir(cfg.Server.TempDir, true, true))
+	if t := cfg.Server.TLS; t.Enabled {
+		add("server_tls", "", t.CertFile, checkKeyPair(t.CertFile, t.KeyFile))
+		for _, pair := range t.Certificates {
+			add("server_tls", "", pair.CertFile, checkKeyPair(pair.CertFile, pair.KeyFile))
+		}
+		if t.ClientCAFile != "" {
+			add("client_ca", "", t.ClientCAFile, checkCAFile(t.ClientCAFile))
+		}
+	}
+	if cfg.History.Enabled {
+		add("history", "", cfg.History.Path, checkStateFile(cfg.History.Path))
+	}
+	if cfg.FileState.Enabled {
+		add("file_state", "", cfg.FileState.Path, checkStateFile(cfg.FileState.Path))
+	}
+	if p := cfg.ControlPlane; p.Enabled && hasTLSFiles(p.TLS) {
+		_, err := uploader.NewTLSConfig(p.TLS)
+		add("control_plane_tls", "", p.URL, err)
+	}
+
+	for _, d := range cfg.Directories {
+		name := d.Name
+		// Files in read_only directories are only read
+		add("watch_path", name, d.WatchPath, checkDir(d.WatchPath, !d.ReadOnly, false))
+		if ingestPath := d.GetIngestPath(); ingestPath != d.WatchPath {
+			add("ingest_path", name, ingestPath, checkDir(ingestPath, true, true))
+		}
+		if d.TempDir != "" {
+			add("temp_dir", name, d.TempDir, checkDir(d.TempDir, true, true))
+		}
+		if d.Shadow.Enabled {
+			add("shadow_path", name, d.Shadow.GetBasePath(), checkDir(d.Shadow.GetBasePath(), true, true))
+			for _, p := range d.Shadow.RestorePaths {
+				add("restore_path", name, p, checkDir(p, true, true))
+			}
+		}
+		if p := d.GetQuarantinePath(); p != "" {
+			add("quarantine_path", name, p, checkDir(p, true, true))
+		}
+		if d.GetAfterUpload() == config.AfterUploadMove {
+			p := strftime.StaticDir(d.ProcessedPath)
+			add("processed_path", name, p, checkDir(p, true, true))
+		}
+		if d.Outbound.GetType() == config.OutboundLocalDir {
+			p := strftime.StaticDir(d.Outbound.Path)
+			add("outbound_path", name, p, checkDir(p, true, true))
+		}
+		for _, f := range []struct{ name, path string }{
+			{"queue_state_file", d.QueueStateFile},
+			{"versioning_state_file", d.Outbound.Versioning.StateFile},
+			{"read_only_state_file", d.ReadOnlyState.StateFile},
+		} {
+			if f.path != "" {
+				add(f.name, name, f.path, checkStateFile(f.path))
+			}
+		}
+
+		if hasTLSFiles(d.Outbound.TLS) {
+			_, err := uploader.NewTLSConfig(d.Outbound.TLS)
+			add("outbound_tls", name, d.Outbound.URL, err)
+		}
+		if d.Mirror.Enabled && hasTLSFiles(d.Mirror.TLS) {
+			_, err := uploader.NewTLSConfig(d.Mirror.TLS)
+			add("mirror_tls", name, d.Mirror.URL, err)
+		}
+		if d.Outbound.Encryption.Enabled {
+			_, err := encrypt.New(d.Outbound.Encryption)
+			add("encryption", name, d.Outbound.Encryption.KeyFile, err)
+		}
+
+		if probe && d.Outbound.GetType() == config.OutboundHTTP {
+			shadowMgr, _ := shadow.NewManager(config.ShadowConfig{Enabled: false})
+			err := uploader.NewDispatcher(d.Outbound, shadowMgr, 1, 1).ProbeDestination(ctx)
+			add("destination", name, d.Outbound.URL, err)
+		}
+	}
+	return results
+}
+
+// checkDir checks that dir is a directory xferd can list, and write to if
+// write is set. With create, a missing directory passes if it can be
+// created, as xferd creates it when it needs it.
+func checkDir(dir string, write, create bool) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) && create {
+		parent := existingParent(dir)
+		if err := checkWritable(parent); err != nil {
+			return fmt.Errorf("missing and cannot be created in %s: %w", parent, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	if write {
+		return checkWritable(dir)
+	}
+	f, err := os.Open(dir) // #nosec G304 -- directory from the configuration
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// existingParent returns the closest parent of path that exists
+func existingParent(path string) string {
+	for {
+		parent := filepath.Dir(path)
+		if _, err := os.Stat(parent); err == nil || parent == path {
+			return parent
+		}
+		path = parent
+	}
+}
+
+// checkStateFile checks that a file xferd keeps state in can be read, and
+// written next to, as it is replaced by renaming a new version into place
+func checkStateFile(path string) error {
+	if f, err := os.Open(path); err == nil { // #nosec G304 -- file from the configuration
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return checkDir(filepath.Dir(path), true, true)
+}
+
+// checkKeyPair checks that a certificate and its key parse, match and that
+// the certificate has not expired
+func checkKeyPair(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if leaf := cert.Leaf; leaf != nil && time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired on %s", leaf.NotAfter.Format(time.DateOnly))
+	}
+	return nil
+}
+
+// checkCAFile checks that a CA bundle holds at least one certificate
+func checkCAFile(path string) error {
+	caPEM, err := os.ReadFile(path) // #nosec G304 -- file from the configuration
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificates found")
+	}
+	return nil
+}
+
+// hasTLSFiles reports whether outbound TLS settings name files to load
+func hasTLSFiles(cfg config.OutboundTLSConfig) bool {
+	return cfg.CertFile != "" || cfg.CAFile != ""
+}
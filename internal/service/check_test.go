@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir := t.TempDir()
+	watchPath := filepath.Join(dir, "watch")
+	if err := os.MkdirAll(watchPath, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	notADir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADir, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	badCert := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(badCert, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var heads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{TempDir: filepath.Join(dir, "temp", "nested")},
+		Directories: []config.DirectoryConfig{
+			{
+				Name:      "good",
+				WatchPath: watchPath,
+				Shadow:    config.ShadowConfig{Enabled: true, Path: filepath.Join(dir, "shadow")},
+				Outbound:  config.OutboundConfig{URL: server.URL},
+			},
+			{
+				Name:           "bad",
+				WatchPath:      filepath.Join(dir, "missing"),
+				QuarantinePath: notADir,
+				Outbound: config.OutboundConfig{
+					URL: server.URL,
+					TLS: config.OutboundTLSConfig{CAFile: badCert},
+				},
+			},
+		},
+	}
+
+	failed := make(map[string]bool)
+	for _, r := range CheckConfig(context.Background(), cfg, true) {
+		if r.Err != nil {
+			failed[r.Directory+"/"+r.Name] = true
+		} else if r.Name == "destination" && r.Directory != "good" {
+			t.Errorf("Unexpected successful probe of %s", r.Directory)
+		}
+	}
+
+	for _, name := range []string{"/temp_dir", "good/watch_path", "good/shadow_path", "good/destination"} {
+		if failed[name] {
+			t.Errorf("Expected %s to pass", name)
+		}
+	}
+	for _, name := range []string{"bad/watch_path", "bad/quarantine_path", "bad/outbound_tls"} {
+		if !failed[name] {
+			t.Errorf("Expected %s to fail", name)
+		}
+	}
+	if heads == 0 {
+		t.Error("Expected the destination to be probed with HEAD")
+	}
+}
+
+func TestCheckDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkDir(dir, true, false); err != nil {
+		t.Errorf("Expected existing directory to pass: %v", err)
+	}
+	if err := checkDir(filepath.Join(dir, "a", "b"), true, true); err != nil {
+		t.Errorf("Expected creatable directory to pass: %v", err)
+	}
+	if err := checkDir(filepath.Join(dir, "a", "b"), true, false); err == nil {
+		t.Error("Expected missing directory to fail")
+	}
+	if err := checkDir(file, false, false); err == nil {
+		t.Error("Expected file to fail")
+	}
+	if err := checkDir(filepath.Join(file, "below"), true, true); err == nil {
+		t.Error("Expected directory below a file to fail")
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/controlplane"
+)
+
+func TestControlPlaneApplyWaitsForService(t *testing.T) {
+	cfg := &config.Config{
+		Server:       config.ServerConfig{Port: 8080, TempDir: t.TempDir()},
+		ControlPlane: config.ControlPlaneConfig{Enabled: true, URL: "https://control.example.com/"},
+	}
+	update := controlplane.Update{Version: "v1", Directories: []config.DirectoryConfig{{
+		Name:      "in",
+		WatchPath: t.TempDir(),
+		Watch:     config.WatchConfig{Mode: "polling_only"},
+		Stability: config.StabilityConfig{ConfirmationIntervalMs: 100, RequiredStableChecks: 2, MaxWaitMs: 1500},
+		Outbound:  config.OutboundConfig{URL: "https://example.com/upload"},
+	}}}
+	if _, err := cfg.WithDirectories(update.Directories); err != nil {
+		t.Fatalf("Invalid test configuration: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan pushedConfig)
+	apply := controlPlaneApply(ctx, cfg, updates)
+
+	// The service loop's result is what the subscriber sees
+	for _, want := range []error{errors.New("directory in: watch path missing"), nil} {
+		go func() {
+			pushed := <-updates
+			pushed.done <- want
+		}()
+		if err := apply(update); !errors.Is(err, want) {
+			t.Errorf("Expected %v, got %v", want, err)
+		}
+	}
+
+	// Commands are refused before anything reaches the service loop
+	hooked := update
+	hooked.Directories = []config.DirectoryConfig{update.Directories[0]}
+	hooked.Directories[0].PostUpload = config.PostUploadConfig{Enabled: true, Command: []string{"/bin/sh", "-c", "id"}}
+	if err := apply(hooked); err == nil {
+		t.Error("Expected a pushed post_upload.command to be rejected")
+	}
+
+	// A configuration that is not applied before shutdown is not accepted
+	done := make(chan error, 1)
+	update.Version = "v2"
+	go func() { done <- apply(update) }()
+	<-updates
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error when shutting down before the configuration is applied")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("apply did not return on shutdown")
+	}
+}
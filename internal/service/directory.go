@@ -0,0 +1,383 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/encrypt"
+	"github.com/muzy/xferd/internal/scan"
+	"github.com/muzy/xferd/internal/shadow"
+	"github.com/muzy/xferd/internal/storage"
+	"github.com/muzy/xferd/internal/uploader"
+	"github.com/muzy/xferd/internal/watcher"
+)
+
+// directory is the runtime of one configured directory: its watcher feeds
+// its dispatcher, and both are started and stopped together
+type directory struct {
+	config     config.DirectoryConfig
+	watcher    watcher.Watcher
+	dispatcher *uploader.Dispatcher
+	backlog    *uploader.Backlog // nil without a startup scan
+	shadow     *shadow.Manager
+	probe      *watcher.Probe     // nil unless watch_probe is enabled
+	storage    storage.Storage    // REST upload storage, nil for the server's default
+	cancel     context.CancelFunc // stops the directory's goroutines
+	shadowStop chan struct{}      // closed to stop the shadow cleanup routine
+	wg         sync.WaitGroup
+}
+
+// newDirectory creates the runtime of a directory without starting it
+func (s *Service) newDirectory(dirCfg config.DirectoryConfig) (*directory, error) {
+	d := &directory{config: dirCfg}
+
+	// Create shadow manager
+	shadowMgr, err := shadow.NewManager(dirCfg.Shadow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shadow manager for %s: %w", dirCfg.Name, err)
+	}
+	shadowMgr.SetWatchPath(dirCfg.WatchPath)
+	shadowMgr.SetPermissions(s.perm)
+	if s.disk != nil {
+		minFree := s.config.DiskSpace.GetMinFree()
+		for _, path := range []string{dirCfg.WatchPath, dirCfg.GetIngestPath(), dirCfg.GetTempDir(s.config.Server.TempDir)} {
+			s.disk.Watch(path, minFree)
+		}
+		if dirCfg.Shadow.Enabled {
+			s.disk.Watch(dirCfg.Shadow.GetBasePath(), s.config.DiskSpace.GetShadowMinFree())
+		}
+		shadowMgr.SetDiskMonitor(s.disk)
+	}
+	d.shadow = shadowMgr
+
+	// Fail fast on unusable outbound TLS settings
+	if _, err := uploader.NewTLSConfig(dirCfg.Outbound.TLS); err != nil {
+		return nil, fmt.Errorf("invalid outbound TLS configuration for %s: %w", dirCfg.Name, err)
+	}
+
+	// Fail fast on unusable encryption keys rather than sending plaintext
+	if _, err := encrypt.New(dirCfg.Outbound.Encryption); err != nil {
+		return nil, fmt.Errorf("invalid outbound encryption configuration for %s: %w", dirCfg.Name, err)
+	}
+
+	// Create upload dispatcher
+	dispatcher := uploader.NewDispatcher(dirCfg.Outbound, shadowMgr, dirCfg.GetMaxWorkers(), dirCfg.GetQueueSize())
+	dispatcher.SetWorkerLimit(s.workerLimit)
+	dispatcher.SetName(dirCfg.Name)
+	dispatcher.SetWatchPath(dirCfg.WatchPath)
+	dispatcher.SetQueueOverflow(dirCfg.GetQueueOverflow())
+	if dirCfg.QueueStateFile != "" {
+		dispatcher.SetQueueStateFile(dirCfg.QueueStateFile)
+	}
+	dispatcher.SetUploadDeadline(dirCfg.GetUploadDeadline())
+	dispatcher.SetSLA(dirCfg.SLA)
+	dispatcher.SetAdaptiveConcurrency(dirCfg.AdaptiveConcurrency)
+	dispatcher.SetJournal(s.journal)
+	dispatcher.SetTransferHistory(s.transfers)
+	dispatcher.SetFileState(s.files)
+	dispatcher.SetInstance(s.config.Instance)
+	dispatcher.SetRecorder(s.capture)
+	if k := s.config.Kubernetes; k.Enabled {
+		dispatcher.SetPod(k.GetPodName(), k.GetNamespace())
+	}
+	if dirCfg.Scan.Enabled {
+		scanner, err := scan.New(dirCfg.Name, dirCfg.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scan configuration for %s: %w", dirCfg.Name, err)
+		}
+		dispatcher.SetScanner(scanner, dirCfg.GetQuarantinePath())
+	}
+	dispatcher.SetPostUpload(dirCfg.PostUpload, dirCfg.GetQuarantinePath())
+	dispatcher.SetAfterUpload(dirCfg.GetAfterUpload(), dirCfg.ProcessedPath)
+	if dirCfg.ReadOnly {
+		dispatcher.SetReadOnly(dirCfg.ReadOnlyState)
+	}
+	tempDir := dirCfg.GetTempDir(s.config.Server.TempDir)
+	if err := dispatcher.SetBatch(dirCfg.Batch, tempDir); err != nil {
+		return nil, fmt.Errorf("failed to set up batches for %s: %w", dirCfg.Name, err)
+	}
+	if dirCfg.Ordered {
+		dispatcher.SetOrdering(dirCfg.OrderingKey, dirCfg.WatchPath)
+	}
+	if len(dirCfg.Priorities) > 0 {
+		dispatcher.EnablePriorities()
+	}
+	if err := dispatcher.SetContentRules(dirCfg.ContentRules); err != nil {
+		return nil, fmt.Errorf("invalid content rules for %s: %w", dirCfg.Name, err)
+	}
+	if err := dispatcher.SetRoutes(dirCfg.Routes); err != nil {
+		return nil, fmt.Errorf("invalid routes for %s: %w", dirCfg.Name, err)
+	}
+	if dirCfg.Mirror.Enabled {
+		mirror, err := uploader.NewMirror(dirCfg.Name, dirCfg.WatchPath, tempDir, dirCfg.Mirror)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mirror for %s: %w", dirCfg.Name, err)
+		}
+		dispatcher.SetMirror(mirror)
+	}
+	d.dispatcher = dispatcher
+	d.backlog = scanBacklog(&dirCfg)
+
+	// Clear enqueued files from all watchers once a file has been uploaded,
+	// was deleted before upload or was evicted from the queue. Uploaded and
+	// deleted files also count towards startup backlog progress.
+	clearEnqueued := func(path string) {
+		for _, other := range s.directories() {
+			other.watcher.ClearEnqueued(path)
+		}
+	}
+	done := func(path string) {
+		clearEnqueued(path)
+		d.backlog.Done(path)
+	}
+	dispatcher.SetOnSuccessfulUpload(done)
+	dispatcher.SetOnRemoved(done)
+	dispatcher.SetOnDropped(clearEnqueued)
+
+	// Create watcher
+	w, err := newWatcher(dirCfg, s.createFileHandler(dirCfg.Name, dispatcher))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher for %s: %w", dirCfg.Name, err)
+	}
+	d.watcher = w
+
+	if s.server != nil {
+		checkSameFilesystem(dirCfg.Name, tempDir, dirCfg.GetIngestPath())
+	}
+
+	// REST uploads are handed to the watcher in watch mode none, streamed
+	// to the destination with passthrough, and staged in the directory's
+	// own temp directory if it has one
+	if s.server != nil && (dirCfg.Watch.Mode == "none" || dirCfg.Passthrough.Enabled || dirCfg.TempDir != "") {
+		if err := s.perm.MkdirAll(tempDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create temp directory for %s: %w", dirCfg.Name, err)
+		}
+		local := storage.NewLocal(tempDir)
+		local.SetPermissions(s.perm)
+		var st storage.Storage = local
+		if ingest, ok := w.(*watcher.IngestWatcher); ok {
+			st = storage.NewNotify(st, ingest.Add)
+		}
+		if dirCfg.Passthrough.Enabled {
+			st = uploader.NewPassthrough(dispatcher, st, dirCfg.Passthrough.GetBufferBytes())
+		}
+		d.storage = st
+	}
+
+	if s.config.WatchProbe.Enabled {
+		d.probe = watcher.NewProbe(dirCfg.Name, dirCfg.WatchPath, s.config.WatchProbe.GetThreshold())
+	}
+	return d, nil
+}
+
+// checkSameFilesystem logs if REST uploads to a directory cannot be renamed
+// from the temp directory into place, so they are copied there instead
+func checkSameFilesystem(name, tempDir, ingestPath string) {
+	same, err := storage.SameFilesystem(tempDir, ingestPath)
+	if err != nil {
+		log.Printf("Directory %s: cannot compare filesystems of temp directory and ingest path: %v", name, err)
+	} else if !same {
+		log.Printf("Directory %s: temp directory %s is on another filesystem than %s; uploads are copied into place instead of renamed",
+			name, tempDir, ingestPath)
+	}
+}
+
+// startDirectory starts the dispatcher, watcher and background routines of a directory
+func (s *Service) startDirectory(d *directory) error {
+	var ctx context.Context
+	ctx, d.cancel = context.WithCancel(s.ctx)
+	name := d.config.Name
+
+	d.dispatcher.Start(ctx)
+	log.Printf("[%s] Started dispatcher", name)
+
+	// Report startup backlog progress until it is delivered
+	if d.backlog != nil && !d.backlog.Progress().Complete {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.backlog.LogProgress(ctx, backlogLogInterval)
+		}()
+	}
+
+	// Enqueue the files left queued by the previous run before the watcher's
+	// reconciliation scan, which would stability check them again
+	s.resumeQueue(d)
+
+	if err := d.watcher.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start watcher for %s: %w", name, err)
+	}
+
+	// Probe watch path latency
+	if d.probe != nil {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.probe.Run(ctx, s.config.WatchProbe.GetInterval())
+		}()
+	}
+
+	// Start shadow cleanup routine
+	d.shadowStop = make(chan struct{})
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		log.Printf("[%s] Starting shadow cleanup routine", name)
+		d.shadow.StartCleanupRoutine(d.shadowStop)
+	}()
+	return nil
+}
+
+// stopDirectory stops a running directory removed from the configuration:
+// its watcher stops, queued files are drained and its dispatcher stops
+func (s *Service) stopDirectory(d *directory) error {
+	err := d.watcher.Stop()
+	if err != nil {
+		log.Printf("[%s] Error stopping watcher: %v", d.config.Name, err)
+	}
+	s.drainDispatchers([]*directory{d})
+	d.cancel()
+	d.dispatcher.Stop()
+	log.Printf("[%s] Stopped dispatcher", d.config.Name)
+	d.stopRoutines()
+	return err
+}
+
+// stopRoutines stops the shadow cleanup and waits for the directory's goroutines
+func (d *directory) stopRoutines() {
+	if d.shadowStop != nil {
+		close(d.shadowStop)
+	}
+	d.wg.Wait()
+}
+
+// directories returns the running directories. The slice is replaced as a
+// whole on reconfiguration and must not be modified.
+func (s *Service) directories() []*directory {
+	s.dirsMu.RLock()
+	defer s.dirsMu.RUnlock()
+	return s.dirs
+}
+
+// ApplyDirectories reconfigures the running service to dirs. Directories
+// whose configuration is unchanged keep running; removed and changed ones
+// are stopped after draining their queue, and added and changed ones are
+// started. Uploads to a changed directory are rejected while it restarts.
+// If a directory cannot be created, nothing is changed.
+func (s *Service) ApplyDirectories(dirs []config.DirectoryConfig) error {
+	s.reconfigure.Lock()
+	defer s.reconfigure.Unlock()
+	return s.applyDirectories(dirs)
+}
+
+// AddDirectory adds a directory to the running service
+func (s *Service) AddDirectory(dirCfg config.DirectoryConfig) error {
+	s.reconfigure.Lock()
+	defer s.reconfigure.Unlock()
+
+	dirs := s.directoryConfigs()
+	if slices.ContainsFunc(dirs, func(d config.DirectoryConfig) bool { return d.Name == dirCfg.Name }) {
+		return fmt.Errorf("directory %s already exists", dirCfg.Name)
+	}
+	return s.applyDirectories(append(dirs, dirCfg))
+}
+
+// RemoveDirectory removes a directory from the running service after
+// draining its queue
+func (s *Service) RemoveDirectory(name string) error {
+	s.reconfigure.Lock()
+	defer s.reconfigure.Unlock()
+
+	dirs := s.directoryConfigs()
+	i := slices.IndexFunc(dirs, func(d config.DirectoryConfig) bool { return d.Name == name })
+	if i < 0 {
+		return fmt.Errorf("unknown directory: %s", name)
+	}
+	return s.applyDirectories(slices.Delete(dirs, i, i+1))
+}
+
+// applyDirectories implements ApplyDirectories with the reconfigure lock held
+func (s *Service) applyDirectories(dirs []config.DirectoryConfig) error {
+	if s.ctx == nil || s.ctx.Err() != nil {
+		return fmt.Errorf("service is not running")
+	}
+
+	next, err := s.config.WithDirectories(dirs)
+	if err != nil {
+		return err
+	}
+
+	current := s.directories()
+	byName := make(map[string]*directory, len(current))
+	for _, d := range current {
+		byName[d.config.Name] = d
+	}
+
+	// Create added and changed directories before anything is stopped
+	updated := make([]*directory, 0, len(next.Directories))
+	var created []*directory
+	for _, dirCfg := range next.Directories {
+		if d, ok := byName[dirCfg.Name]; ok && reflect.DeepEqual(d.config, dirCfg) {
+			updated = append(updated, d)
+			continue
+		}
+		d, err := s.newDirectory(dirCfg)
+		if err != nil {
+			for _, c := range created {
+				_ = c.watcher.Stop()
+			}
+			return err
+		}
+		updated = append(updated, d)
+		created = append(created, d)
+	}
+
+	// Stop removed and changed directories; a changed directory's new
+	// dispatcher resumes the queue state its old one leaves behind
+	for _, d := range current {
+		if slices.Contains(updated, d) {
+			continue
+		}
+		if s.server != nil {
+			s.server.RemoveDirectory(d.config.Name)
+		}
+		log.Printf("[%s] Stopping directory", d.config.Name)
+		if err := s.stopDirectory(d); err != nil {
+			log.Printf("[%s] Error stopping directory: %v", d.config.Name, err)
+		}
+	}
+
+	s.dirsMu.Lock()
+	s.dirs = updated
+	s.dirsMu.Unlock()
+
+	var startErr error
+	for _, d := range created {
+		log.Printf("[%s] Starting directory", d.config.Name)
+		if err := s.startDirectory(d); err != nil {
+			log.Printf("[%s] %v", d.config.Name, err)
+			startErr = cmp.Or(startErr, err)
+			continue
+		}
+		if s.server != nil {
+			s.server.SetDirectory(d.config, d.storage)
+		}
+	}
+	return startErr
+}
+
+// directoryConfigs returns the configurations of the running directories
+func (s *Service) directoryConfigs() []config.DirectoryConfig {
+	current := s.directories()
+	dirs := make([]config.DirectoryConfig, 0, len(current))
+	for _, d := range current {
+		dirs = append(dirs, d.config)
+	}
+	return dirs
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Drain starts a graceful shutdown: Start returns once queued files were
+// delivered or the drain timeout passed. Safe to call more than once.
+func (s *Service) Drain() {
+	s.drainOnce.Do(func() {
+		close(s.drainCh)
+	})
+}
+
+// watchDrainSignals calls Drain when a drain signal arrives, on platforms that have one
+func (s *Service) watchDrainSignals() {
+	if len(drainSignals) == 0 {
+		return
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, drainSignals...)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer signal.Stop(sigCh)
+		select {
+		case sig := <-sigCh:
+			log.Printf("Received signal: %v, draining...", sig)
+			s.Drain()
+		case <-s.ctx.Done():
+		}
+	}()
+}
+
+// drainDispatchers waits up to the drain timeout for the dispatchers of dirs
+// to deliver their queued files. Ingress uploads and watchers must be stopped
+// first. Files still queued afterwards stay in the watch directory for the
+// next start.
+func (s *Service) drainDispatchers(dirs []*directory) {
+	timeout := s.config.Kubernetes.DrainTimeout(s.config.Shutdown.GetDrainTimeout())
+	if timeout == 0 || (s.ctx != nil && s.ctx.Err() != nil) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, d := range dirs {
+		dispatcher := d.dispatcher
+		if dispatcher.Idle() {
+			continue
+		}
+		name := d.config.Name
+		log.Printf("[%s] Draining: waiting up to %v for %d queued files", name, timeout, dispatcher.QueueLength())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if dispatcher.Drain(ctx) {
+				log.Printf("[%s] Drained", name)
+			} else {
+				log.Printf("[%s] Drain timeout reached with %d files still queued; they are picked up again on the next start", name, dispatcher.QueueLength())
+			}
+		}()
+	}
+	wg.Wait()
+}
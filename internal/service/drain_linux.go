@@ -0,0 +1,12 @@
+//go:build linux
+
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignals trigger a graceful drain without a shutdown signal from the
+// service manager
+var drainSignals = []os.Signal{syscall.SIGUSR1}
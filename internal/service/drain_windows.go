@@ -0,0 +1,8 @@
+//go:build windows
+
+package service
+
+import "os"
+
+// drainSignals is empty: Windows has no SIGUSR1, use POST /drain instead
+var drainSignals []os.Signal
@@ -0,0 +1,18 @@
+//go:build noingress
+
+package service
+
+import (
+	"errors"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// ingressBuilt reports whether the REST API is compiled in. The noingress
+// tag leaves it out; server.enabled must be false.
+const ingressBuilt = false
+
+// newRESTServer fails: the REST API is not compiled in
+func newRESTServer(*config.Config) (restServer, error) {
+	return nil, errors.New("xferd was built without the REST API")
+}
@@ -0,0 +1,40 @@
+//go:build !noingress
+
+package service
+
+import (
+	"context"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/ingress"
+)
+
+// ingressBuilt reports whether the REST API is compiled in. Build with the
+// noingress tag for watcher-only deployments.
+const ingressBuilt = true
+
+// ingressServer adapts ingress.Server to restServer
+type ingressServer struct {
+	*ingress.Server
+}
+
+// newRESTServer creates the REST ingress server for cfg
+func newRESTServer(cfg *config.Config) (restServer, error) {
+	server, err := ingress.NewServer(cfg.Server, cfg.Directories)
+	if err != nil {
+		return nil, err
+	}
+	return ingressServer{server}, nil
+}
+
+// SetReadinessCheck sets the checks reported by /ready
+func (s ingressServer) SetReadinessCheck(check func(ctx context.Context) []readinessResult) {
+	s.Server.SetReadinessCheck(func(ctx context.Context) []ingress.ReadinessCheck {
+		results := check(ctx)
+		checks := make([]ingress.ReadinessCheck, len(results))
+		for i, r := range results {
+			checks[i] = ingress.ReadinessCheck(r)
+		}
+		return checks
+	})
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// podInfo names the pod this instance runs in, so its metrics can be joined
+// with the pod's labels
+var podInfo = metrics.NewGaugeVec("xferd_pod_info",
+	"Always 1, labelled with the Kubernetes pod and namespace this instance runs in",
+	"pod", "namespace")
+
+// configReloads counts reloads of a changed configuration file
+var configReloads = metrics.NewCounterVec("xferd_config_reloads_total",
+	"Reloads of the configuration file after it changed: applied, or failed if it could not be loaded or applied",
+	"outcome")
+
+// watchConfigFile checks the configuration file and the fragments it includes
+// for changes every interval and sends each changed configuration that loads
+// on updates, dropping one not yet received. Mounted ConfigMaps are updated by swapping a symlink,
+// which file watches miss, so the content is compared instead.
+func watchConfigFile(ctx context.Context, path string, interval time.Duration, updates chan *config.Config) {
+	last := configDigest(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		digest := configDigest(path)
+		if digest == nil || bytes.Equal(digest, last) {
+			continue
+		}
+		last = digest
+
+		next, err := config.Load(path)
+		if err != nil {
+			configReloads.With("failed").Inc()
+			log.Printf("Configuration file changed but was not reloaded: %v", err)
+			continue
+		}
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- next
+	}
+}
+
+// configDigest hashes the configuration file and the fragments it includes,
+// nil if one cannot be read
+func configDigest(path string) []byte {
+	files, err := config.Files(path)
+	if err != nil {
+		return nil
+	}
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file) // #nosec G304 -- configuration file chosen by the operator
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+	return hash.Sum(nil)
+}
+
+// reload applies the directories of a reloaded configuration. Other settings
+// take effect on the next start.
+func (s *Service) reload(next *config.Config) {
+	log.Printf("Configuration file changed, applying %d directories", len(next.Directories))
+	if err := s.ApplyDirectories(next.Directories); err != nil {
+		configReloads.With("failed").Inc()
+		log.Printf("Reloaded configuration not fully applied: %v", err)
+		return
+	}
+	configReloads.With("applied").Inc()
+
+	current, changed := *s.config, *next
+	current.Directories, changed.Directories = nil, nil
+	if !reflect.DeepEqual(current, changed) {
+		log.Println("Configuration file changed outside directories; restart xferd to apply those settings")
+	}
+}
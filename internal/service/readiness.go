@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// readinessResult is the outcome of a /ready check. It has the fields of
+// ingress.ReadinessCheck, which the REST API converts it to.
+type readinessResult struct {
+	Name      string // what was checked, e.g. watch_path or queue
+	Directory string // directory the check belongs to, if any
+	Target    string // path or URL checked
+	OK        bool
+	Error     string
+}
+
+// readinessCheck is a /ready check and the function running it
+type readinessCheck struct {
+	readinessResult
+	run func(ctx context.Context) error
+}
+
+// ready runs the readiness checks concurrently. Checks that do not finish
+// within the readiness timeout, e.g. on a hung mount, fail.
+func (s *Service) ready(ctx context.Context) []readinessResult {
+	timeout := s.config.Readiness.GetTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	checks := s.readinessChecks()
+	errs := make([]chan error, len(checks))
+	for i, check := range checks {
+		errs[i] = make(chan error, 1)
+		go func() { errs[i] <- check.run(ctx) }()
+	}
+
+	results := make([]readinessResult, len(checks))
+	for i, check := range checks {
+		result := check.readinessResult
+		var err error
+		select {
+		case err = <-errs[i]:
+		case <-ctx.Done():
+			err = fmt.Errorf("%s %s not responding within %v", check.Name, check.Target, timeout)
+		}
+		result.OK = err == nil
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// readinessChecks lists the checks for the temp directory and every directory
+func (s *Service) readinessChecks() []readinessCheck {
+	tempDir := s.config.Server.TempDir
+	checks := []readinessCheck{{
+		readinessResult: readinessResult{Name: "temp_dir", Target: tempDir},
+		run: func(context.Context) error {
+			if err := checkWritable(tempDir); err != nil {
+				return fmt.Errorf("temp dir %s not writable: %w", tempDir, err)
+			}
+			return nil
+		},
+	}}
+
+	for _, d := range s.directories() {
+		dirCfg, dispatcher := d.config, d.dispatcher
+		name := dirCfg.Name
+		check := func(checkName, target string, run func(ctx context.Context) error) {
+			checks = append(checks, readinessCheck{
+				readinessResult: readinessResult{Name: checkName, Directory: name, Target: target},
+				run:             run,
+			})
+		}
+
+		check("watch_path", dirCfg.WatchPath, func(context.Context) error {
+			if dirCfg.ReadOnly {
+				if err := checkDir(dirCfg.WatchPath, false, false); err != nil {
+					return fmt.Errorf("watch path of %s not readable: %w", name, err)
+				}
+				return nil
+			}
+			if err := checkWritable(dirCfg.WatchPath); err != nil {
+				return fmt.Errorf("watch path of %s not writable: %w", name, err)
+			}
+			return nil
+		})
+		if ingestPath := dirCfg.GetIngestPath(); ingestPath != dirCfg.WatchPath {
+			check("ingest_path", ingestPath, func(context.Context) error {
+				if err := checkWritable(ingestPath); err != nil {
+					return fmt.Errorf("ingest path of %s not writable: %w", name, err)
+				}
+				return nil
+			})
+		}
+		if dirCfg.TempDir != "" {
+			check("temp_dir", dirCfg.TempDir, func(context.Context) error {
+				if err := checkWritable(dirCfg.TempDir); err != nil {
+					return fmt.Errorf("temp dir of %s not writable: %w", name, err)
+				}
+				return nil
+			})
+		}
+		if dirCfg.Shadow.Enabled {
+			check("shadow_path", dirCfg.Shadow.GetBasePath(), func(context.Context) error {
+				info, err := os.Stat(dirCfg.Shadow.GetBasePath())
+				if err == nil && !info.IsDir() {
+					err = fmt.Errorf("not a directory")
+				}
+				if err != nil {
+					return fmt.Errorf("shadow path of %s: %w", name, err)
+				}
+				return nil
+			})
+		}
+		check("queue", "", func(context.Context) error {
+			if queued, capacity := dispatcher.QueueLength(), dispatcher.QueueCapacity(); queued >= capacity {
+				return fmt.Errorf("upload queue of %s full: %d of %d", name, queued, capacity)
+			}
+			return nil
+		})
+		if probe := d.probe; probe != nil {
+			check("watch_probe", dirCfg.WatchPath, func(context.Context) error {
+				return probe.Check()
+			})
+		}
+		if s.config.Readiness.ProbeDestinations && dirCfg.Outbound.GetType() == config.OutboundHTTP {
+			check("destination", dirCfg.Outbound.URL, dispatcher.ProbeDestination)
+		}
+	}
+	return checks
+}
+
+// checkWritable creates and removes a hidden file in dir, which the watcher ignores
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".xferd-ready-*")
+	if err != nil {
+		return err
+	}
+	name := file.Name()
+	if err := file.Close(); err != nil {
+		_ = os.Remove(name)
+		return err
+	}
+	return os.Remove(name)
+}
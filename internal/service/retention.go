@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/quarantine"
+)
+
+// quarantineRetentionInterval is the time between quarantine retention passes
+const quarantineRetentionInterval = 10 * time.Minute
+
+var (
+	quarantineFiles = metrics.NewGaugeVec("xferd_quarantine_files",
+		"Files held in a quarantine directory, not counting sidecars", "path")
+	quarantineBytes = metrics.NewGaugeVec("xferd_quarantine_bytes",
+		"Bytes held in a quarantine directory, including sidecars", "path")
+	quarantineRemoved = metrics.NewCounterVec("xferd_quarantine_removed_files_total",
+		"Quarantined files removed by quarantine_retention", "path")
+)
+
+// runQuarantineRetention applies quarantine retention now and then every
+// interval until ctx is cancelled
+func (s *Service) runQuarantineRetention(ctx context.Context) {
+	s.pruneQuarantines(time.Now())
+	ticker := time.NewTicker(quarantineRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.pruneQuarantines(now)
+		}
+	}
+}
+
+// quarantineRetentions returns the configured quarantine directories with
+// their retention. A directory shared by several configurations keeps the
+// strictest limits.
+func (s *Service) quarantineRetentions() map[string]config.RetentionConfig {
+	paths := map[string]config.RetentionConfig{}
+	add := func(path string, r config.RetentionConfig) {
+		if path == "" {
+			return
+		}
+		cur, seen := paths[path]
+		if !seen {
+			paths[path] = r
+			return
+		}
+		cur.MaxAgeHours = strictest(cur.MaxAgeHours, r.MaxAgeHours)
+		cur.MaxBytes = strictest(cur.MaxBytes, r.MaxBytes)
+		paths[path] = cur
+	}
+
+	if s.config.Server.TempCleanup.IsEnabled() {
+		add(s.config.Server.TempCleanup.QuarantinePath, s.config.Server.TempCleanup.QuarantineRetention)
+	}
+	for _, d := range s.directories() {
+		add(d.config.GetQuarantinePath(), d.config.QuarantineRetention)
+	}
+	return paths
+}
+
+// strictest returns the lower of two limits where 0 means no limit
+func strictest[T int | int64](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// pruneQuarantines applies each quarantine directory's retention and
+// exports what it holds
+func (s *Service) pruneQuarantines(now time.Time) {
+	for path, r := range s.quarantineRetentions() {
+		usage, err := quarantine.Prune(path, r.GetMaxAge(), r.MaxBytes, now)
+		if usage.Removed > 0 {
+			quarantineRemoved.With(path).Add(uint64(usage.Removed)) // #nosec G115
+			log.Printf("Quarantine retention: removed %d files from %s", usage.Removed, path)
+		}
+		if err != nil {
+			log.Printf("Quarantine retention: failed to prune %s: %v", path, err)
+			continue
+		}
+		quarantineFiles.With(path).Set(float64(usage.Files))
+		quarantineBytes.With(path).Set(float64(usage.Bytes))
+	}
+}
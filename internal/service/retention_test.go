@@ -0,0 +1,92 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/quarantine"
+)
+
+func TestPruneQuarantines(t *testing.T) {
+	dir := t.TempDir()
+	dirQuarantine := filepath.Join(dir, "quarantine")
+	tempQuarantine := filepath.Join(dir, "abandoned")
+	now := time.Now()
+	for _, f := range []struct {
+		path  string
+		moved time.Time
+	}{
+		{filepath.Join(dirQuarantine, "old.csv"), now.Add(-48 * time.Hour)},
+		{filepath.Join(dirQuarantine, "new.csv"), now},
+		{filepath.Join(tempQuarantine, "upload.partial"), now.Add(-48 * time.Hour)},
+	} {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f.path, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f.path+quarantine.SidecarSuffix, []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.path+quarantine.SidecarSuffix, f.moved, f.moved); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Service{config: &config.Config{Server: config.ServerConfig{
+		TempCleanup: config.TempCleanupConfig{QuarantinePath: tempQuarantine},
+	}}}
+	s.dirs = []*directory{{config: config.DirectoryConfig{
+		Name:                "reports",
+		QuarantinePath:      dirQuarantine,
+		QuarantineRetention: config.RetentionConfig{MaxAgeHours: 24},
+	}}}
+
+	before := quarantineRemoved.With(dirQuarantine).Value()
+	s.pruneQuarantines(now)
+	if _, err := os.Stat(filepath.Join(dirQuarantine, "old.csv")); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired file to be removed, got %v", err)
+	}
+	if got := quarantineRemoved.With(dirQuarantine).Value(); got != before+1 {
+		t.Errorf("Expected 1 removed file counted, got %d", got-before)
+	}
+	if got := quarantineFiles.With(dirQuarantine).Value(); got != 1 {
+		t.Errorf("Expected 1 file in the directory quarantine, got %v", got)
+	}
+	if got := quarantineBytes.With(dirQuarantine).Value(); got != 6 {
+		t.Errorf("Expected 6 bytes in the directory quarantine, got %v", got)
+	}
+
+	// Without retention the temp quarantine is only measured
+	if _, err := os.Stat(filepath.Join(tempQuarantine, "upload.partial")); err != nil {
+		t.Errorf("Expected the file without retention to be kept: %v", err)
+	}
+	if got := quarantineFiles.With(tempQuarantine).Value(); got != 1 {
+		t.Errorf("Expected 1 file in the temp quarantine, got %v", got)
+	}
+}
+
+func TestQuarantineRetentionsShared(t *testing.T) {
+	s := &Service{config: &config.Config{Server: config.ServerConfig{
+		TempCleanup: config.TempCleanupConfig{
+			QuarantinePath:      "/var/lib/xferd/quarantine",
+			QuarantineRetention: config.RetentionConfig{MaxAgeHours: 48},
+		},
+	}}}
+	s.dirs = []*directory{
+		{config: config.DirectoryConfig{QuarantinePath: "/var/lib/xferd/quarantine", QuarantineRetention: config.RetentionConfig{MaxAgeHours: 72, MaxBytes: 1 << 30}}},
+		{config: config.DirectoryConfig{RejectedPath: "/var/lib/xferd/rejected"}},
+	}
+
+	got := s.quarantineRetentions()
+	if r := got["/var/lib/xferd/quarantine"]; r.MaxAgeHours != 48 || r.MaxBytes != 1<<30 {
+		t.Errorf("Expected the strictest limits of a shared directory, got %+v", r)
+	}
+	if r, ok := got["/var/lib/xferd/rejected"]; !ok || r.IsSet() {
+		t.Errorf("Expected rejected_path to be measured without limits, got %+v, %v", r, ok)
+	}
+}
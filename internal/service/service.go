@@ -1,158 +1,427 @@
 package service
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/muzy/xferd/internal/capture"
 	"github.com/muzy/xferd/internal/config"
-	"github.com/muzy/xferd/internal/ingress"
-	"github.com/muzy/xferd/internal/shadow"
+	"github.com/muzy/xferd/internal/controlplane"
+	"github.com/muzy/xferd/internal/diskspace"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/history"
+	"github.com/muzy/xferd/internal/journal"
+	"github.com/muzy/xferd/internal/perm"
+	"github.com/muzy/xferd/internal/storage"
+	"github.com/muzy/xferd/internal/transferid"
 	"github.com/muzy/xferd/internal/uploader"
 	"github.com/muzy/xferd/internal/watcher"
 )
 
+// backlogLogInterval is how often startup backlog progress is logged
+const backlogLogInterval = 30 * time.Second
+
+// restServer is the REST ingress server. It is created by newRESTServer,
+// which fails when xferd is built with the noingress tag, so that the
+// ingress package is only compiled in when the REST API is.
+type restServer interface {
+	SetStorage(st storage.Storage)
+	SetDirectoryStorage(name string, st storage.Storage)
+	SetDirectory(cfg config.DirectoryConfig, st storage.Storage)
+	RemoveDirectory(name string)
+	SetDiskMonitor(m *diskspace.Monitor)
+	SetHistory(store *history.Store)
+	SetFileState(store *filestate.Store)
+	SetCapture(r *capture.Recorder)
+	SetReadinessCheck(check func(ctx context.Context) []readinessResult)
+	SetStatusProvider(provider func() any)
+	SetDrainHandler(drain func())
+	SetDraining()
+	Start(ctx context.Context) error
+	Stop() error
+}
+
 // Service represents the main xferd service
 type Service struct {
-	config       *config.Config
-	server       *ingress.Server
-	watchers     []watcher.Watcher
-	dispatchers  []*uploader.Dispatcher
-	shadows      []*shadow.Manager
-	ctx          context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
-	shadowStopCh chan struct{} // Channel to stop shadow cleanup routines
-	stopOnce     sync.Once     // Ensure Stop() is idempotent
+	config      *config.Config
+	server      restServer   // nil unless the REST API is enabled
+	dirs        []*directory // in configuration order, replaced as a whole under dirsMu
+	dirsMu      sync.RWMutex
+	reconfigure sync.Mutex            // serializes directory changes and Stop
+	workerLimit *uploader.WorkerLimit // shared by all dispatchers, nil without max_workers
+	journal     *journal.Exporter     // nil unless journal export is enabled
+	transfers   *history.Store        // nil unless the transfer history is enabled
+	files       *filestate.Store      // nil unless file lifecycles are tracked
+	capture     *capture.Recorder     // nil unless outbound capture is configured
+	perm        *perm.Perm            // mode and owner of created files, nil for the defaults
+	disk        *diskspace.Monitor    // nil unless disk_space is enabled
+	configPath  string                // reloaded on change in Kubernetes, "" if not loaded from a file
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	drainCh     chan struct{} // Closed by Drain to start a graceful shutdown
+	drainOnce   sync.Once     // Ensure drainCh is closed once
+	stopOnce    sync.Once     // Ensure Stop() is idempotent
 }
 
 // New creates a new xferd service
 func New(cfg *config.Config) (*Service, error) {
-	// Create REST ingress server
-	server, err := ingress.NewServer(cfg.Server, cfg.Directories)
+	p, err := perm.New(cfg.Permissions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create server: %w", err)
+		return nil, err
+	}
+
+	// Create REST ingress server unless running watchers only
+	var server restServer
+	if cfg.Server.IsEnabled() {
+		if !ingressBuilt {
+			return nil, fmt.Errorf("server is enabled but xferd was built without the REST API (noingress build tag); set server.enabled: false")
+		}
+		// Created here so it gets the configured mode and owner
+		if err := p.MkdirAll(cfg.Server.TempDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		server, err = newRESTServer(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create server: %w", err)
+		}
+		if p != nil {
+			st := storage.NewLocal(cfg.Server.TempDir)
+			st.SetPermissions(p)
+			server.SetStorage(st)
+		}
+	}
+
+	var disk *diskspace.Monitor
+	if cfg.DiskSpace.Enabled {
+		disk = diskspace.NewMonitor()
+		disk.Watch(cfg.Server.TempDir, cfg.DiskSpace.GetMinFree())
+		if server != nil {
+			server.SetDiskMonitor(disk)
+		}
 	}
 
 	svc := &Service{
 		config:      cfg,
 		server:      server,
-		watchers:    make([]watcher.Watcher, 0, len(cfg.Directories)),
-		dispatchers: make([]*uploader.Dispatcher, 0, len(cfg.Directories)),
-		shadows:     make([]*shadow.Manager, 0, len(cfg.Directories)),
+		perm:        p,
+		disk:        disk,
+		dirs:        make([]*directory, 0, len(cfg.Directories)),
+		workerLimit: uploader.NewWorkerLimit(cfg.MaxWorkers),
+		drainCh:     make(chan struct{}),
 	}
 
-	// Create watchers, dispatchers, and shadow managers for each directory
-	for i := range cfg.Directories {
-		dirCfg := &cfg.Directories[i]
-		// Create shadow manager
-		shadowMgr, err := shadow.NewManager(dirCfg.Shadow)
+	if cfg.Journal.Enabled {
+		exporter, err := journal.NewExporter(cfg.Journal)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create shadow manager for %s: %w", dirCfg.Name, err)
+			return nil, err
+		}
+		if cfg.Instance.Enabled {
+			exporter.SetInstance(cfg.Instance.GetID())
 		}
-		svc.shadows = append(svc.shadows, shadowMgr)
+		svc.journal = exporter
+	}
 
-		// Create upload dispatcher
-		dispatcher := uploader.NewDispatcher(dirCfg.Outbound, shadowMgr, 4) // 4 workers per directory
-		svc.dispatchers = append(svc.dispatchers, dispatcher)
+	if cfg.History.Enabled {
+		store, err := history.Open(cfg.History)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open transfer history: %w", err)
+		}
+		svc.transfers = store
+		if server != nil {
+			server.SetHistory(store)
+		}
+	}
 
-		// Create file event handler
-		handler := svc.createFileHandler(dirCfg.Name, dispatcher)
+	if cfg.FileState.Enabled {
+		store, err := filestate.Open(cfg.FileState)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file state: %w", err)
+		}
+		svc.files = store
+		if server != nil {
+			server.SetFileState(store)
+		}
+	}
 
-		// Create watcher
-		w, err := watcher.NewWatcher(*dirCfg, handler)
+	if cfg.Capture.Path != "" {
+		recorder, err := capture.New(cfg.Capture)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create watcher for %s: %w", dirCfg.Name, err)
+			return nil, err
+		}
+		svc.capture = recorder
+		if server != nil {
+			server.SetCapture(recorder)
 		}
-		svc.watchers = append(svc.watchers, w)
 	}
 
-	// Now that all watchers are created, set the callbacks on dispatchers
-	for i := range svc.dispatchers {
-		// Create callback to clear enqueued files from all watchers after successful upload
-		onSuccessfulUpload := func(path string) {
-			for _, watcher := range svc.watchers {
-				watcher.ClearEnqueued(path)
-			}
+	if k := cfg.Kubernetes; k.Enabled {
+		podInfo.With(k.GetPodName(), k.GetNamespace()).Set(1)
+	}
+
+	// Create watchers, dispatchers, and shadow managers for each directory
+	for i := range cfg.Directories {
+		d, err := svc.newDirectory(cfg.Directories[i])
+		if err != nil {
+			return nil, err
 		}
+		if server != nil && d.storage != nil {
+			server.SetDirectoryStorage(d.config.Name, d.storage)
+		}
+		svc.dirs = append(svc.dirs, d)
+	}
 
-		svc.dispatchers[i].SetOnSuccessfulUpload(onSuccessfulUpload)
+	if server != nil {
+		server.SetReadinessCheck(svc.ready)
+		server.SetStatusProvider(svc.status)
+		server.SetDrainHandler(svc.Drain)
 	}
 
 	return svc, nil
 }
 
+// newWatcher creates the watcher for a directory's watch mode
+func newWatcher(dirCfg config.DirectoryConfig, handler watcher.EventHandler) (watcher.Watcher, error) {
+	if dirCfg.Watch.Mode == "none" {
+		return watcher.NewIngestWatcher(dirCfg, handler), nil
+	}
+	if !watcherBuilt {
+		return nil, fmt.Errorf("watch mode %s is not available: xferd was built without filesystem watchers (nowatcher build tag), use watch mode none", dirCfg.Watch.Mode)
+	}
+	return watcher.NewWatcher(dirCfg, handler)
+}
+
+// scanBacklog counts the files waiting in a directory before the startup
+// reconciliation scan picks them up. Returns nil if there is no startup scan.
+func scanBacklog(dirCfg *config.DirectoryConfig) *uploader.Backlog {
+	// Tailed files keep growing, so they never complete a backlog
+	if !dirCfg.Watch.IsStartupReconcileScanEnabled() || dirCfg.Watch.Mode == "tail" {
+		return nil
+	}
+
+	start := time.Now()
+	files, err := watcher.ScanBacklog(*dirCfg)
+	if err != nil {
+		log.Printf("[%s] Failed to count startup backlog: %v", dirCfg.Name, err)
+		return nil
+	}
+
+	backlog := uploader.NewBacklog(dirCfg.Name, files)
+	if p := backlog.Progress(); p.TotalFiles > 0 {
+		log.Printf("[%s] Startup backlog: %d files, %d bytes (counted in %v)",
+			dirCfg.Name, p.TotalFiles, p.TotalBytes, time.Since(start).Round(time.Millisecond))
+	}
+	return backlog
+}
+
+// Status is the /status response
+type Status struct {
+	Directories []DirectoryStatus `json:"directories"`
+}
+
+// DirectoryStatus reports the state of one directory
+type DirectoryStatus struct {
+	Name        string                    `json:"name"`
+	Queued      int                       `json:"queued"`                // files waiting for an upload worker
+	Concurrency int                       `json:"concurrency,omitempty"` // current adaptive concurrency limit
+	Backlog     *uploader.BacklogProgress `json:"backlog,omitempty"`     // startup backlog, if counted
+}
+
+// status builds the /status response
+func (s *Service) status() any {
+	dirs := s.directories()
+	status := Status{Directories: make([]DirectoryStatus, 0, len(dirs))}
+	for _, d := range dirs {
+		dirStatus := DirectoryStatus{
+			Name:        d.config.Name,
+			Queued:      d.dispatcher.QueueLength(),
+			Concurrency: d.dispatcher.ConcurrencyLimit(),
+		}
+		if d.backlog != nil {
+			progress := d.backlog.Progress()
+			dirStatus.Backlog = &progress
+		}
+		status.Directories = append(status.Directories, dirStatus)
+	}
+	return status
+}
+
 // createFileHandler creates a file event handler for a directory
 func (s *Service) createFileHandler(dirName string, dispatcher *uploader.Dispatcher) watcher.EventHandler {
 	return func(event watcher.FileEvent) error {
-		log.Printf("[%s] File detected: %s (rename: %v)", dirName, event.Path, event.IsRename)
+		if event.IsDelete {
+			log.Printf("%s File deleted: %s", transferid.Tag(dirName, event.Path), event.Path)
+			dispatcher.Cancel(event.Path)
+			return nil
+		}
+
+		log.Printf("%s File detected: %s (rename: %v, priority: %s)", transferid.Tag(dirName, event.Path), event.Path, event.IsRename, event.Priority)
+		detected := cmp.Or(event.Detected, event.Timestamp)
+		dispatcher.MarkDetected(event.Path, detected)
+		if s.files != nil {
+			s.files.Record(filestate.Event{Time: detected.UTC(), Directory: dirName, Path: event.Path, Stage: filestate.StageDetected})
+			s.files.Record(filestate.Event{Time: event.Timestamp.UTC(), Directory: dirName, Path: event.Path, Stage: filestate.StageStable})
+		}
+
+		// Enqueue for upload (shadow copy will be created after successful upload).
+		// Dropped files are left for a later scan to pick up again.
+		return dispatcher.EnqueueWithPriority(event.Path, event.ProcessedDueToTimeout, event.Priority)
+	}
+}
 
-		// Enqueue for upload (shadow copy will be created after successful upload)
-		dispatcher.Enqueue(event.Path, event.ProcessedDueToTimeout)
+// resumeQueue enqueues the files recorded in a directory's queue state
+func (s *Service) resumeQueue(d *directory) {
+	dirCfg := d.config
+	if dirCfg.QueueStateFile == "" {
+		return
+	}
 
+	dispatcher, w := d.dispatcher, d.watcher
+	resumed := dispatcher.Resume(func(f uploader.QueuedFile) error {
+		w.MarkEnqueued(f.Path)
+		if err := dispatcher.EnqueueWithPriority(f.Path, f.ProcessedDueToTimeout, f.Priority); err != nil {
+			w.ClearEnqueued(f.Path) // left for the reconciliation scan
+			return err
+		}
 		return nil
+	})
+	if resumed > 0 {
+		log.Printf("[%s] Resumed %d queued files from %s", dirCfg.Name, resumed, dirCfg.QueueStateFile)
 	}
 }
 
 // Start starts the xferd service
 func (s *Service) Start() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	// Reload a changed configuration file, e.g. an updated ConfigMap
+	var reloads chan *config.Config
+	if interval := s.config.Kubernetes.GetReloadInterval(); interval > 0 && s.configPath != "" {
+		reloads = make(chan *config.Config, 1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			watchConfigFile(s.ctx, s.configPath, interval, reloads)
+		}()
+	}
+
+	// Wait for shutdown signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		select {
+		case sig := <-sigCh:
+			log.Printf("Received signal: %v, shutting down...", sig)
+		case <-s.drainCh:
+			log.Println("Drain requested, shutting down...")
+		case <-s.ctx.Done():
+			log.Println("Context cancelled, shutting down...")
+		case next := <-reloads:
+			s.reload(next)
+			continue
+		}
+
+		// Stop all components
+		return s.Stop()
+	}
+}
+
+// start starts all components without waiting for shutdown
+func (s *Service) start() error {
+	s.reconfigure.Lock()
+	defer s.reconfigure.Unlock()
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	log.Println("Starting xferd service...")
 
-	// Start upload dispatchers
-	for i, dispatcher := range s.dispatchers {
-		dispatcher.Start(s.ctx)
-		log.Printf("Started dispatcher for directory %d", i)
+	// Start journal export before any transfer events are recorded
+	if s.journal != nil {
+		s.journal.Start()
 	}
 
-	// Start watchers
-	for i, w := range s.watchers {
-		if err := w.Start(s.ctx); err != nil {
-			return fmt.Errorf("failed to start watcher %d: %w", i, err)
+	// Start dispatchers and watchers of each directory
+	for _, d := range s.directories() {
+		if err := s.startDirectory(d); err != nil {
+			return err
 		}
 	}
 
-	// Start shadow cleanup routines
-	s.shadowStopCh = make(chan struct{})
-	for i, shadowMgr := range s.shadows {
+	// Prune expired transfers from the history
+	if s.transfers != nil {
 		s.wg.Add(1)
-		go func(idx int, mgr *shadow.Manager) {
+		go func() {
 			defer s.wg.Done()
-			log.Printf("Starting shadow cleanup routine %d", idx)
-			mgr.StartCleanupRoutine(s.shadowStopCh)
-		}(i, shadowMgr)
+			s.transfers.RunPruning(s.ctx)
+		}()
 	}
 
-	// Start REST ingress server
+	// Prune expired lifecycle events
+	if s.files != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.files.RunPruning(s.ctx)
+		}()
+	}
+
+	// Check free space before uploads are accepted
+	if s.disk != nil {
+		s.disk.Check()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.disk.Run(s.ctx, s.config.DiskSpace.GetInterval())
+		}()
+	}
+
+	// Remove uploads left staged by a crash, then periodically those of
+	// lost connections
+	if s.server != nil && s.config.Server.TempCleanup.IsEnabled() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runTempCleanup(s.ctx)
+		}()
+	}
+
+	// Bound what accumulates in quarantine directories and export their
+	// size. Directories may gain one on reload, so this always runs.
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		if err := s.server.Start(s.ctx); err != nil && err != http.ErrServerClosed {
-			log.Printf("Server error: %v", err)
-		}
+		s.runQuarantineRetention(s.ctx)
 	}()
 
-	log.Println("Xferd service started successfully")
+	// Drain on SIGUSR1 as well as on POST /drain
+	s.watchDrainSignals()
 
-	// Wait for shutdown signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	select {
-	case sig := <-sigCh:
-		log.Printf("Received signal: %v, shutting down...", sig)
-	case <-s.ctx.Done():
-		log.Println("Context cancelled, shutting down...")
+	// Start REST ingress server
+	if s.server != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.server.Start(s.ctx); err != nil && err != http.ErrServerClosed {
+				log.Printf("Server error: %v", err)
+			}
+		}()
 	}
 
-	// Stop all components
-	return s.Stop()
+	log.Println("Xferd service started successfully")
+	return nil
 }
 
 // Stop stops the xferd service gracefully
@@ -161,6 +430,29 @@ func (s *Service) Stop() error {
 	s.stopOnce.Do(func() {
 		log.Println("Stopping xferd service...")
 
+		// Wait for a directory change in progress
+		s.reconfigure.Lock()
+		defer s.reconfigure.Unlock()
+		dirs := s.directories()
+
+		// Reject new uploads while queued files are delivered
+		if s.server != nil {
+			s.server.SetDraining()
+		}
+
+		// Stop all watchers so no new files are enqueued
+		for _, d := range dirs {
+			if watcherErr := d.watcher.Stop(); watcherErr != nil {
+				log.Printf("[%s] Error stopping watcher: %v", d.config.Name, watcherErr)
+				if err == nil {
+					err = watcherErr
+				}
+			}
+		}
+
+		// Deliver queued files before workers are cancelled
+		s.drainDispatchers(dirs)
+
 		// Cancel context to stop all goroutines
 		if s.cancel != nil {
 			s.cancel()
@@ -170,34 +462,40 @@ func (s *Service) Stop() error {
 		if s.server != nil {
 			if serverErr := s.server.Stop(); serverErr != nil {
 				log.Printf("Error stopping server: %v", serverErr)
-				err = serverErr
-			}
-		}
-
-		// Stop all watchers
-		for i, w := range s.watchers {
-			if watcherErr := w.Stop(); watcherErr != nil {
-				log.Printf("Error stopping watcher %d: %v", i, watcherErr)
 				if err == nil {
-					err = watcherErr
+					err = serverErr
 				}
 			}
 		}
 
 		// Stop all dispatchers
-		for i, dispatcher := range s.dispatchers {
-			dispatcher.Stop()
-			log.Printf("Stopped dispatcher %d", i)
+		for _, d := range dirs {
+			d.dispatcher.Stop()
+			log.Printf("[%s] Stopped dispatcher", d.config.Name)
 		}
 
-		// Stop shadow cleanup routines
-		if s.shadowStopCh != nil {
-			close(s.shadowStopCh)
+		// Send the remaining transfer events once the dispatchers are idle
+		if s.journal != nil {
+			s.journal.Stop()
 		}
 
-		// Wait for all goroutines to finish
+		// Stop shadow cleanup routines and wait for all goroutines to finish
+		for _, d := range dirs {
+			d.stopRoutines()
+		}
 		s.wg.Wait()
 
+		if s.transfers != nil {
+			if historyErr := s.transfers.Close(); historyErr != nil {
+				log.Printf("Error closing transfer history: %v", historyErr)
+			}
+		}
+		if s.files != nil {
+			if filesErr := s.files.Close(); filesErr != nil {
+				log.Printf("Error closing file state: %v", filesErr)
+			}
+		}
+
 		log.Println("Xferd service stopped")
 	})
 	return err
@@ -216,31 +514,187 @@ func Run(configPath string) error {
 	// Log configuration details
 	logConfiguration(cfg)
 
+	if cfg.ControlPlane.Enabled {
+		return runManaged(cfg)
+	}
+
 	// Create and start service
 	svc, err := New(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
+	svc.configPath = configPath
 
 	return svc.Start()
 }
 
+// runManaged runs the service with directories pushed by the control plane.
+// Each accepted configuration is applied to the running service: unchanged
+// directories keep running, changed and removed ones are drained and stopped.
+func runManaged(cfg *config.Config) error {
+	subscriber, err := controlplane.NewSubscriber(cfg.ControlPlane)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	updates := make(chan pushedConfig)
+	go subscriber.Run(ctx, controlPlaneApply(ctx, cfg, updates))
+
+	svc, err := New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	if err := svc.start(); err != nil {
+		_ = svc.Stop()
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Received shutdown signal, shutting down...")
+			return svc.Stop()
+		case <-svc.drainCh:
+			log.Println("Drain requested, shutting down...")
+			return svc.Stop()
+		case pushed := <-updates:
+			log.Printf("Applying configuration from the control plane: %d directories", len(pushed.config.Directories))
+			err := svc.ApplyDirectories(pushed.config.Directories)
+			pushed.done <- err
+			if err == nil {
+				logConfiguration(pushed.config)
+			}
+		}
+	}
+}
+
+// pushedConfig is a configuration from the control plane handed to the
+// service loop; the result of applying it is sent on done
+type pushedConfig struct {
+	config *config.Config
+	done   chan error
+}
+
+// controlPlaneApply returns the subscriber callback for runManaged. It hands
+// each pushed configuration to the service loop and waits until the loop has
+// applied it, so the subscriber only records a version the service runs.
+func controlPlaneApply(ctx context.Context, cfg *config.Config, updates chan<- pushedConfig) func(controlplane.Update) error {
+	return func(update controlplane.Update) error {
+		if err := cfg.ControlPlane.CheckPushed(update.Directories); err != nil {
+			return err
+		}
+		next, err := cfg.WithDirectories(update.Directories)
+		if err != nil {
+			return err
+		}
+		pushed := pushedConfig{config: next, done: make(chan error, 1)}
+		select {
+		case updates <- pushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case err := <-pushed.done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 // logConfiguration logs the current configuration details on startup
 func logConfiguration(cfg *config.Config) {
 	log.Println("=== XFERD CONFIGURATION ===")
 
 	// Server configuration
-	log.Printf("Server: %s:%d", cfg.Server.Address, cfg.Server.Port)
-	log.Printf("  Temp Directory: %s", cfg.Server.TempDir)
-	if cfg.Server.TLS.Enabled {
-		log.Printf("  TLS: enabled (cert: %s, key: %s)", cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	listeners := cfg.Server.GetListeners()
+	if !cfg.Server.IsEnabled() {
+		log.Println("Server: disabled (watchers only, no REST API, /health, /ready or /metrics)")
+		log.Printf("  Temp Directory: %s", cfg.Server.TempDir)
 	} else {
-		log.Println("  TLS: disabled")
+		for i := range listeners {
+			log.Printf("Server: %s (%s)", net.JoinHostPort(listeners[i].Address, strconv.Itoa(listeners[i].Port)), listeners[i].GetNetwork())
+		}
+		log.Printf("  Temp Directory: %s", cfg.Server.TempDir)
+		if cfg.Server.MaxUploadBytes > 0 {
+			log.Printf("  Max Upload Size: %d bytes", cfg.Server.MaxUploadBytes)
+		}
+		if cfg.Server.TLS.Enabled {
+			log.Printf("  TLS: enabled (cert: %s, key: %s)", cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		} else {
+			log.Println("  TLS: disabled")
+		}
+		if cfg.Server.TLS.ClientCAFile != "" {
+			log.Printf("  Client Certificates: verified against %s (required: %v)", cfg.Server.TLS.ClientCAFile, cfg.Server.TLS.RequireClientCert)
+		}
+		if cfg.Server.BasicAuth.Enabled {
+			log.Printf("  Basic Auth: enabled (user: %s)", cfg.Server.BasicAuth.Username)
+		} else {
+			log.Println("  Basic Auth: disabled")
+		}
+		if cfg.Server.JWTAuth.Enabled {
+			if cfg.Server.JWTAuth.JWKSURL != "" {
+				log.Printf("  JWT Auth: enabled (RS256, jwks: %s)", cfg.Server.JWTAuth.JWKSURL)
+			} else {
+				log.Println("  JWT Auth: enabled (HS256)")
+			}
+		}
+		if cfg.Server.AccessLog.Enabled {
+			log.Printf("  Access Log: %s format to %s", cfg.Server.AccessLog.GetFormat(), cmp.Or(cfg.Server.AccessLog.Path, "standard output"))
+		}
+		if rl := cfg.Server.RateLimit; rl.Enabled {
+			log.Printf("  Rate Limit: global %.1f req/s, %d concurrent uploads; per client %.1f req/s, %d concurrent uploads (0 = unlimited)",
+				rl.Global.RequestsPerSecond, rl.Global.MaxConcurrentUploads,
+				rl.PerClient.RequestsPerSecond, rl.PerClient.MaxConcurrentUploads)
+		}
+	}
+
+	if cfg.MaxWorkers > 0 {
+		log.Printf("Upload Workers: at most %d concurrent uploads across all directories", cfg.MaxWorkers)
+	}
+	if cfg.ControlPlane.Enabled {
+		log.Printf("Control Plane: directories pushed by %s (node %s)", cfg.ControlPlane.URL, cfg.ControlPlane.GetNodeID())
+	}
+	if cfg.Instance.Enabled {
+		log.Printf("Instance: %s (sent in %s)", cfg.Instance.GetID(), cfg.Instance.GetHeader())
+	}
+	if cfg.History.Enabled {
+		log.Printf("Transfer History: %s, kept for %v, searchable on /history", cfg.History.Path, cfg.History.GetRetention())
 	}
-	if cfg.Server.BasicAuth.Enabled {
-		log.Printf("  Basic Auth: enabled (user: %s)", cfg.Server.BasicAuth.Username)
+	if cfg.FileState.Enabled {
+		log.Printf("File State: %s, kept for %v, lifecycles on /files", cfg.FileState.Path, cfg.FileState.GetRetention())
+	}
+	if cfg.Readiness.ProbeDestinations {
+		log.Printf("Readiness: /ready also probes HTTP destinations (timeout %v)", cfg.Readiness.GetTimeout())
+	}
+	if k := cfg.Kubernetes; k.Enabled {
+		log.Printf("Kubernetes: pod %s in namespace %s", k.GetPodName(), cmp.Or(k.GetNamespace(), "(unknown)"))
+		if interval := k.GetReloadInterval(); interval > 0 && !cfg.ControlPlane.Enabled {
+			log.Printf("  Config Reload: directories applied from the changed configuration file, checked every %v", interval)
+		}
+	}
+	if timeout := cfg.Kubernetes.DrainTimeout(cfg.Shutdown.GetDrainTimeout()); timeout > 0 {
+		log.Printf("Shutdown: queued files delivered for up to %v (drain with SIGUSR1 or POST /drain)", timeout)
 	} else {
-		log.Println("  Basic Auth: disabled")
+		log.Println("Shutdown: queued files are not drained")
+	}
+	if cfg.WatchProbe.Enabled {
+		log.Printf("Watch Probe: watch paths probed every %v, /ready fails above %v", cfg.WatchProbe.GetInterval(), cfg.WatchProbe.GetThreshold())
+	}
+	if cfg.Journal.Enabled {
+		if cfg.Journal.Type == "kafka_rest" {
+			log.Printf("Journal Export: transfer events published to Kafka topic %s via %s", cfg.Journal.Kafka.Topic, cfg.Journal.URL)
+		} else if cfg.Journal.Template != "" {
+			log.Printf("Journal Export: templated events sent to %s one per request", cfg.Journal.URL)
+		} else {
+			log.Printf("Journal Export: transfer events sent to %s (batches of %d)", cfg.Journal.URL, cfg.Journal.GetBatchSize())
+		}
+		if len(cfg.Journal.Outcomes) > 0 {
+			log.Printf("    → Outcomes: %s", strings.Join(cfg.Journal.Outcomes, ", "))
+		}
 	}
 
 	// Directory configurations
@@ -263,8 +717,12 @@ func logConfiguration(cfg *config.Config) {
 			log.Printf("  Detection Method: Instant event-based detection with smart stability checks")
 			log.Printf("    → Files detected immediately via filesystem events (<50-200ms latency)")
 			log.Printf("    → Atomic renames processed instantly (no stability delay)")
-			log.Printf("    → Regular file writes confirmed stable after %d checks every %dms (up to %dms total)",
-				dir.Stability.RequiredStableChecks, dir.Stability.ConfirmationIntervalMs, dir.Stability.MaxWaitMs)
+			if dir.Watch.CloseWrite {
+				log.Printf("    → Regular file writes delivered when the writer closes the file (no stability delay)")
+			} else {
+				log.Printf("    → Regular file writes confirmed stable after %d checks every %dms (up to %dms total)",
+					dir.Stability.RequiredStableChecks, dir.Stability.ConfirmationIntervalMs, dir.Stability.MaxWaitMs)
+			}
 			if dir.Watch.ReconcileScan.Enabled {
 				log.Printf("    → Every %d seconds: Full directory scan catches any missed files", dir.Watch.ReconcileScan.IntervalSeconds)
 			}
@@ -292,6 +750,28 @@ func logConfiguration(cfg *config.Config) {
 			if dir.Watch.IsStartupReconcileScanEnabled() {
 				log.Printf("    → On startup: Immediate full directory scan")
 			}
+
+		case "none":
+			log.Printf("  Detection Method: None - REST uploads are enqueued as soon as they are stored")
+			log.Printf("    → No filesystem watching or stability checks")
+			if dir.Watch.IsStartupReconcileScanEnabled() {
+				log.Printf("    → On startup: Files already in the directory are enqueued once")
+			}
+
+		case "tail":
+			if dir.Watch.Tail.GetDeliver() == config.TailDeliverClose {
+				log.Printf("  Detection Method: Files delivered when their writer closes them (inotify close events)")
+				log.Printf("    → Atomic renames processed instantly")
+				log.Printf("    → Files still open for writing are never delivered")
+			} else {
+				log.Printf("  Detection Method: Growing files delivered in segments of appended data")
+				log.Printf("    → Every %v: New data copied to %s as <file>.<offset> and uploaded", dir.Watch.Tail.GetInterval(), dir.Watch.Tail.SpoolPath)
+				log.Printf("    → Tailed files are never deleted; offsets survive restarts")
+			}
+		}
+
+		if dir.Rotation.Enabled {
+			log.Printf("  Rotation: Active files %v: %s, rotated copies: %s", dir.Rotation.Patterns, dir.Rotation.GetActive(), dir.Rotation.GetRotated())
 		}
 
 		// Shadow directory explanation
@@ -304,29 +784,170 @@ func logConfiguration(cfg *config.Config) {
 		}
 
 		// Upload explanation
-		log.Printf("  Outbound Upload: Files sent to %s", dir.Outbound.URL)
+		if dir.Outbound.GetType() == config.OutboundLocalDir {
+			log.Printf("  Outbound Move: Files moved to %s", dir.Outbound.Path)
+		} else if dir.Outbound.GetType() == config.OutboundFTP {
+			log.Printf("  Outbound FTP: Files sent to %s", dir.Outbound.URL)
+		} else {
+			log.Printf("  Outbound Upload: Files sent to %s (%s, %s body)", dir.Outbound.URL, dir.Outbound.GetMethod(), dir.Outbound.GetBodyFormat())
+		}
 		switch dir.Outbound.Auth.Type {
 		case "basic":
 			log.Printf("    → Authentication: HTTP Basic Auth (%s)", dir.Outbound.Auth.Username)
 		case "bearer":
 			log.Printf("    → Authentication: Bearer token")
+		case "aws_sigv4":
+			log.Printf("    → Authentication: AWS SigV4 (%s/%s)", dir.Outbound.Auth.Service, dir.Outbound.Auth.Region)
 		default:
 			log.Printf("    → Authentication: none")
 		}
 		log.Printf("    → Method: Concurrent uploads with automatic retry on failure")
+		if dir.Outbound.Connection.WarmUp {
+			log.Printf("    → Connection: warmed up on startup")
+		}
+		if dir.Outbound.Connection.DNSRefreshSeconds > 0 {
+			log.Printf("    → DNS: re-resolved every %d seconds", dir.Outbound.Connection.DNSRefreshSeconds)
+		}
+		if dir.Outbound.PropagateDeletes {
+			log.Printf("    → Deletions: files removed before upload are reported to the destination")
+		}
+		if v := dir.Outbound.Versioning; v.Enabled {
+			detect := v.Detect
+			if detect == "" {
+				detect = "size_mtime"
+			}
+			log.Printf("    → Versioning: changed files re-delivered with X-File-Version (detect: %s)", detect)
+		}
+		if k := dir.Outbound.IdempotencyKey; k.Enabled {
+			log.Printf("    → Idempotency: %s header derived from file %s", k.GetHeader(), k.GetSource())
+		}
+		if d := dir.Outbound.Dedup; d.Enabled {
+			log.Printf("    → Dedup: identical files skipped for %v (up to %d hashes)", d.GetWindow(), d.GetMaxEntries())
+		}
+		if dir.Passthrough.Enabled {
+			log.Printf("    → Passthrough: REST uploads streamed straight to the destination, spilled to %s on failure", dir.GetIngestPath())
+		}
+		if m := dir.Mirror; m.Enabled {
+			log.Printf("    → Mirror: delivered files forwarded to %s (directory %s)", m.URL, m.GetDirectory(dir.Name))
+		}
+		if b := dir.Batch; b.Enabled {
+			log.Printf("    → Batches: up to %d files or %d bytes per %s archive, sent after at most %v", b.GetMaxFiles(), b.GetMaxBytes(), b.GetFormat(), b.GetWindow())
+		}
+		if h := dir.PostUpload; h.Enabled {
+			var hooks []string
+			if len(h.Command) > 0 {
+				hooks = append(hooks, "command "+h.Command[0])
+			}
+			if h.URL != "" {
+				hooks = append(hooks, "webhook "+h.URL)
+			}
+			log.Printf("    → Post-upload hook: %s, sources kept if it fails", strings.Join(hooks, " and "))
+		}
+		if conn := dir.Outbound.Connection; conn.MinThroughputBytes > 0 {
+			log.Printf("    → Request timeout: scaled to file size at %d bytes/s (minimum %v)", conn.MinThroughputBytes, conn.GetRequestTimeout(0))
+		}
+		if c := dir.Outbound.Compression; c.Enabled {
+			log.Printf("    → Compression: gzip level %d (%s mode) for files of %d bytes or more", c.GetLevel(), c.GetMode(), c.GetMinSize())
+		}
+		if e := dir.Outbound.Encryption; e.Enabled {
+			if e.GetType() == config.EncryptionPGP {
+				log.Printf("    → Encryption: pgp for the keys in %s", e.KeyFile)
+			} else {
+				log.Printf("    → Encryption: age for %d recipient(s)", len(e.Recipients))
+			}
+		}
+		if sc := dir.Outbound.Success; sc != (config.SuccessConfig{}) {
+			log.Printf("    → Success checks: 2xx responses must also pass the configured body and header checks")
+		}
+		if rp := dir.Outbound.RelativePath; rp.Mode == config.RelativePathURL {
+			log.Printf("    → Relative path: appended to the outbound URL")
+		} else if rp.Mode != "" {
+			log.Printf("    → Relative path: sent in %s %s", rp.Mode, rp.GetName())
+		}
+		if v := dir.Outbound.Verify; v.Enabled {
+			log.Printf("    → Verification: %s %s before the source is deleted", v.GetMethod(), cmp.Or(v.URL, "upload URL"))
+		}
+		if fo := dir.Outbound.Failover; len(fo.URLs) > 0 {
+			log.Printf("    → Failover: %d secondary destination(s) after %d failures (cooldown %v)", len(fo.URLs), fo.GetFailureThreshold(), fo.GetCooldown())
+		}
+		if dir.Outbound.Commit.Enabled {
+			log.Printf("    → Two-phase delivery: uploads committed by upload ID (field: %s)", dir.Outbound.Commit.GetIDField())
+		}
+		overflow := dir.GetQueueOverflow()
+		log.Printf("    → Workers: %d (queue size %d, when full: %s)", dir.GetMaxWorkers(), dir.GetQueueSize(), overflow.GetPolicy())
+		if dir.QueueStateFile != "" {
+			log.Printf("    → Queue state: %s (queued files resumed on restart)", dir.QueueStateFile)
+		}
+		if dir.Ordered {
+			key := dir.OrderingKey
+			if key == "" {
+				key = config.OrderingKeyDirectory
+			}
+			log.Printf("    → Ordered delivery: files delivered in order per %s", key)
+		}
+		for _, rule := range dir.Priorities {
+			log.Printf("    → Priority %s: %s", rule.Priority, strings.Join(rule.Patterns, ", "))
+		}
+		for _, rule := range dir.ContentRules {
+			if rule.GetAction() == config.ContentActionIgnore {
+				log.Printf("    → Content %s: ignored", strings.Join(rule.Types, ", "))
+			} else {
+				log.Printf("    → Content %s: routed to %s", strings.Join(rule.Types, ", "), rule.URL)
+			}
+		}
+		for i, route := range dir.Routes {
+			log.Printf("    → Route %d: %s", i, cmp.Or(route.URL, "outbound url with overridden headers or auth"))
+		}
+		if deadline := dir.GetUploadDeadline(); deadline > 0 {
+			log.Printf("    → Upload deadline: %v per file (stuck workers are replaced)", deadline)
+		}
+		if dir.SLA.Enabled {
+			if dir.SLA.Failover {
+				log.Printf("    → SLA: delivery within %v of detection (overdue files fail over)", dir.SLA.GetDeadline())
+			} else {
+				log.Printf("    → SLA: delivery within %v of detection", dir.SLA.GetDeadline())
+			}
+		}
+		if dir.Scan.Enabled {
+			scanner := dir.Scan.Address
+			if dir.Scan.GetType() == config.ScanTypeICAP {
+				scanner = dir.Scan.URL
+			}
+			log.Printf("    → Malware scan: %s at %s, infected files quarantined in %s", dir.Scan.GetType(), scanner, dir.GetQuarantinePath())
+		}
+		if ac := dir.AdaptiveConcurrency; ac.Enabled {
+			log.Printf("    → Adaptive concurrency: %d to %d concurrent uploads", ac.GetMinWorkers(), dir.GetMaxWorkers())
+		}
 
 		// REST API ingest endpoint
+		if !cfg.Server.IsEnabled() {
+			log.Printf("  REST API Ingest: disabled")
+			log.Println()
+			continue
+		}
 		protocol := "http"
 		if cfg.Server.TLS.Enabled {
 			protocol = "https"
 		}
-		baseURL := fmt.Sprintf("%s://%s:%d", protocol, cfg.Server.Address, cfg.Server.Port)
+		baseURL := fmt.Sprintf("%s://%s", protocol, net.JoinHostPort(listeners[0].Address, strconv.Itoa(listeners[0].Port)))
 		uploadEndpoint := fmt.Sprintf("%s/upload/%s", baseURL, dir.Name)
 		log.Printf("  REST API Ingest: %s", uploadEndpoint)
+		if dir.MaxUploadBytes > 0 {
+			log.Printf("    → Max upload size: %d bytes", dir.MaxUploadBytes)
+		}
+		if !dir.IsSubdirectoryUploadAllowed() {
+			log.Printf("    → Subdirectories: not allowed")
+		} else if dir.MaxDepth > 0 {
+			log.Printf("    → Subdirectories: at most %d levels", dir.MaxDepth)
+		}
 		log.Printf("    → Example: curl -X POST -F \"file=@example.pdf\" %s", uploadEndpoint)
 		if cfg.Server.BasicAuth.Enabled {
 			log.Printf("    → Requires authentication: Basic Auth (%s)", cfg.Server.BasicAuth.Username)
-		} else {
+		}
+		if cfg.Server.JWTAuth.Enabled {
+			log.Printf("    → Requires authentication: JWT bearer token")
+		}
+		if !cfg.Server.BasicAuth.Enabled && !cfg.Server.JWTAuth.Enabled {
 			log.Printf("    → No authentication required")
 		}
 		log.Printf("    → Supports subdirectories: %s/2025/01/30", uploadEndpoint)
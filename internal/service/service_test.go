@@ -5,6 +5,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -760,3 +761,306 @@ func TestE2ERecursiveWatching(t *testing.T) {
 
 	t.Log("E2E recursive watching test completed successfully")
 }
+
+// TestE2EDrain tests that POST /drain delivers queued files before the service exits
+func TestE2EDrain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	testDir := t.TempDir()
+	tempDir := filepath.Join(testDir, "temp")
+	watchDir := filepath.Join(testDir, "watch")
+	for _, dir := range []string{tempDir, watchDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory %s: %v", dir, err)
+		}
+	}
+
+	// Slow destination so files are still queued when the drain starts
+	uploadReceived := make(chan string, 10)
+	mockServer := http.NewServeMux()
+	mockServer.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		time.Sleep(300 * time.Millisecond)
+		uploadReceived <- header.Filename
+		w.WriteHeader(http.StatusOK)
+	})
+	httpServer := &http.Server{Addr: "127.0.0.1:18091", Handler: mockServer}
+	go httpServer.ListenAndServe()
+	defer httpServer.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Address:   "127.0.0.1",
+			Port:      18090,
+			TempDir:   tempDir,
+			BasicAuth: config.BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+		},
+		Directories: []config.DirectoryConfig{
+			{
+				Name:       "testdir",
+				WatchPath:  watchDir,
+				MaxWorkers: 1,
+				Watch:      config.WatchConfig{Mode: "event_only"},
+				Stability: config.StabilityConfig{
+					ConfirmationIntervalMs: 10,
+					RequiredStableChecks:   2,
+					MaxWaitMs:              100,
+				},
+				Outbound: config.OutboundConfig{URL: "http://127.0.0.1:18091/upload"},
+			},
+		},
+	}
+
+	svc, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	serviceDone := make(chan error, 1)
+	go func() {
+		serviceDone <- svc.Start()
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	expectedFiles := []string{"a.txt", "b.txt", "c.txt"}
+	for _, name := range expectedFiles {
+		if err := os.WriteFile(filepath.Join(watchDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", name, err)
+		}
+	}
+
+	// Drain once the first upload is underway
+	select {
+	case <-uploadReceived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("First upload not received within timeout")
+	}
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:18090/drain", nil)
+	req.SetBasicAuth("admin", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to request drain: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", resp.StatusCode)
+	}
+
+	select {
+	case err := <-serviceDone:
+		if err != nil {
+			t.Errorf("Expected clean exit after drain, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Service did not exit after drain")
+	}
+
+	// Every queued file was delivered and removed before the service exited
+	if n := len(uploadReceived) + 1; n != len(expectedFiles) {
+		t.Errorf("Expected %d uploads, got %d", len(expectedFiles), n)
+	}
+	entries, _ := os.ReadDir(watchDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected empty watch directory after drain, got %d entries", len(entries))
+	}
+}
+
+// TestE2EApplyDirectories tests adding and removing directories while the service runs
+func TestE2EApplyDirectories(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	testDir := t.TempDir()
+	tempDir := filepath.Join(testDir, "temp")
+	firstDir := filepath.Join(testDir, "first")
+	secondDir := filepath.Join(testDir, "second")
+	for _, dir := range []string{tempDir, firstDir, secondDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory %s: %v", dir, err)
+		}
+	}
+
+	uploadReceived := make(chan string, 10)
+	mockServer := http.NewServeMux()
+	mockServer.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uploadReceived <- header.Filename
+		w.WriteHeader(http.StatusOK)
+	})
+	httpServer := &http.Server{Addr: "127.0.0.1:18093", Handler: mockServer}
+	go httpServer.ListenAndServe()
+	defer httpServer.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	directory := func(name, watchPath string) config.DirectoryConfig {
+		return config.DirectoryConfig{
+			Name:      name,
+			WatchPath: watchPath,
+			Watch:     config.WatchConfig{Mode: "event_only"},
+			Stability: config.StabilityConfig{
+				ConfirmationIntervalMs: 10,
+				RequiredStableChecks:   2,
+				MaxWaitMs:              100,
+			},
+			Outbound: config.OutboundConfig{URL: "http://127.0.0.1:18093/upload"},
+		}
+	}
+	base := &config.Config{Server: config.ServerConfig{Address: "127.0.0.1", Port: 18092, TempDir: tempDir}}
+	cfg, err := base.WithDirectories([]config.DirectoryConfig{directory("first", firstDir)})
+	if err != nil {
+		t.Fatalf("Invalid configuration: %v", err)
+	}
+
+	svc, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	serviceDone := make(chan error, 1)
+	go func() {
+		serviceDone <- svc.Start()
+	}()
+	defer func() {
+		svc.Stop()
+		<-serviceDone
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// Files are renamed into place, so each is detected once
+	place := func(dir, name string) {
+		t.Helper()
+		staged := filepath.Join(testDir, name)
+		if err := os.WriteFile(staged, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", name, err)
+		}
+		if err := os.Rename(staged, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Failed to move file %s: %v", name, err)
+		}
+	}
+	expectUpload := func(name string) {
+		t.Helper()
+		select {
+		case got := <-uploadReceived:
+			if got != name {
+				t.Errorf("Expected upload of %s, got %s", name, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Upload of %s not received within timeout", name)
+		}
+	}
+
+	if err := svc.AddDirectory(directory("second", secondDir)); err != nil {
+		t.Fatalf("Failed to add directory: %v", err)
+	}
+	if err := svc.AddDirectory(directory("second", secondDir)); err == nil {
+		t.Error("Expected adding a directory twice to fail")
+	}
+	place(secondDir, "added.txt")
+	expectUpload("added.txt")
+
+	// The directory still running is not restarted by the change
+	place(firstDir, "kept.txt")
+	expectUpload("kept.txt")
+
+	if err := svc.RemoveDirectory("first"); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	if err := svc.RemoveDirectory("first"); err == nil {
+		t.Error("Expected removing an unknown directory to fail")
+	}
+	resp, err := http.Post("http://127.0.0.1:18092/upload/first?filename=late.txt", "application/octet-stream", bytes.NewReader([]byte("late")))
+	if err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a removed directory, got %d", resp.StatusCode)
+	}
+	place(firstDir, "ignored.txt")
+	select {
+	case got := <-uploadReceived:
+		t.Errorf("Expected no upload from a removed directory, got %s", got)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Invalid configurations are rejected without touching the running directories
+	if err := svc.ApplyDirectories([]config.DirectoryConfig{directory("second", secondDir), {Name: "broken"}}); err == nil {
+		t.Error("Expected an invalid configuration to be rejected")
+	}
+	place(secondDir, "still.txt")
+	expectUpload("still.txt")
+}
+
+// TestWatchConfigFile tests that an updated ConfigMap is reloaded
+func TestWatchConfigFile(t *testing.T) {
+	// A ConfigMap volume is updated by pointing its ..data symlink at a new
+	// directory of keys
+	mount := t.TempDir()
+	testDir := t.TempDir()
+	configYAML := func(names ...string) string {
+		yaml := fmt.Sprintf("server:\n  enabled: false\n  temp_dir: %s\ndirectories:\n", testDir)
+		for _, name := range names {
+			yaml += fmt.Sprintf("  - name: %s\n    watch_path: %s\n    watch:\n      mode: event_only\n"+
+				"    stability:\n      confirmation_interval_ms: 10\n      required_stable_checks: 2\n      max_wait_ms: 100\n"+
+				"    outbound:\n      url: http://127.0.0.1:1/upload\n", name, filepath.Join(testDir, name))
+		}
+		return yaml
+	}
+	version := 0
+	publish := func(content string) {
+		t.Helper()
+		version++
+		data := filepath.Join(mount, fmt.Sprintf("..v%d", version))
+		if err := os.Mkdir(data, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(data, "config.yml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(mount, "..data_tmp")
+		if err := os.Symlink(filepath.Base(data), link); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(link, filepath.Join(mount, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(configYAML("first"))
+	if err := os.Symlink(filepath.Join("..data", "config.yml"), filepath.Join(mount, "config.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *config.Config, 1)
+	go watchConfigFile(ctx, mount, 20*time.Millisecond, updates)
+	time.Sleep(50 * time.Millisecond)
+
+	// An invalid configuration is not passed on
+	publish("directories: [")
+	publish(configYAML("first", "second"))
+	select {
+	case next := <-updates:
+		if len(next.Directories) != 2 {
+			t.Errorf("Expected 2 directories after reload, got %d", len(next.Directories))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Changed configuration not reloaded within timeout")
+	}
+
+	select {
+	case <-updates:
+		t.Error("Expected no reload of an unchanged configuration")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/quarantine"
+	"github.com/muzy/xferd/internal/storage"
+)
+
+// staleTempFiles counts staged uploads found abandoned in a temp directory
+var staleTempFiles = metrics.NewCounterVec("xferd_temp_stale_files_total",
+	"Staged .partial files of interrupted uploads cleaned up from a temp directory, by action: removed or quarantined",
+	"action")
+
+// runTempCleanup cleans up stale temp files now and then every interval
+// until ctx is cancelled
+func (s *Service) runTempCleanup(ctx context.Context) {
+	s.cleanTempDirs(time.Now())
+	ticker := time.NewTicker(s.config.Server.TempCleanup.GetInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.cleanTempDirs(now)
+		}
+	}
+}
+
+// cleanTempDirs removes or quarantines the .partial files in the server's
+// and the directories' temp directories that were not written to for
+// temp_cleanup.max_age_minutes
+func (s *Service) cleanTempDirs(now time.Time) {
+	cfg := s.config.Server.TempCleanup
+	cutoff := now.Add(-cfg.GetMaxAge())
+
+	// Directories sharing the server's temp directory are cleaned with it
+	tempDirs := map[string]string{s.config.Server.TempDir: ""}
+	for _, d := range s.directories() {
+		if d.config.TempDir != "" {
+			tempDirs[d.config.TempDir] = d.config.Name
+		}
+	}
+
+	for tempDir, name := range tempDirs {
+		stale, err := storage.StalePartials(tempDir, cutoff)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Temp cleanup: failed to scan %s: %v", tempDir, err)
+			}
+			continue
+		}
+		for _, path := range stale {
+			if cfg.QuarantinePath != "" {
+				target, err := quarantine.Move(name, cfg.QuarantinePath, tempDir, path, quarantine.ReasonAbandoned,
+					"upload was not completed within temp_cleanup.max_age_minutes")
+				if err != nil {
+					log.Printf("Temp cleanup: failed to quarantine %s: %v", path, err)
+					continue
+				}
+				staleTempFiles.With("quarantined").Inc()
+				log.Printf("Temp cleanup: moved abandoned upload %s to %s", path, target)
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("Temp cleanup: failed to remove %s: %v", path, err)
+				continue
+			}
+			staleTempFiles.With("removed").Inc()
+			log.Printf("Temp cleanup: removed abandoned upload %s", path)
+		}
+	}
+}
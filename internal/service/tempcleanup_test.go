@@ -0,0 +1,51 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/quarantine"
+)
+
+func TestCleanTempDirs(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := filepath.Join(dir, "temp")
+	if err := os.MkdirAll(tempDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(tempDir, "crashed.txt.partial")
+	active := filepath.Join(tempDir, "active.txt.partial")
+	for _, path := range []string{stale, active} {
+		if err := os.WriteFile(path, []byte("partial"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{config: &config.Config{Server: config.ServerConfig{TempDir: tempDir}}}
+	s.cleanTempDirs(time.Now())
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected stale file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(active); err != nil {
+		t.Errorf("Expected recently written file to be kept: %v", err)
+	}
+
+	// With a quarantine path, stale files are moved there with a sidecar
+	quarantinePath := filepath.Join(dir, "quarantine")
+	s.config.Server.TempCleanup.QuarantinePath = quarantinePath
+	s.cleanTempDirs(time.Now().Add(72 * time.Hour))
+	moved := filepath.Join(quarantinePath, "active.txt.partial")
+	if _, err := os.Stat(moved); err != nil {
+		t.Errorf("Expected file in quarantine: %v", err)
+	}
+	if _, err := os.Stat(moved + quarantine.SidecarSuffix); err != nil {
+		t.Errorf("Expected quarantine sidecar: %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build nowatcher
+
+package service
+
+// watcherBuilt reports whether filesystem watchers are compiled in. The
+// nowatcher tag leaves them out; every directory must use watch mode none.
+const watcherBuilt = false
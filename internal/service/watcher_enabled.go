@@ -0,0 +1,7 @@
+//go:build !nowatcher
+
+package service
+
+// watcherBuilt reports whether filesystem watchers are compiled in. Build
+// with the nowatcher tag for API-gateway-only deployments.
+const watcherBuilt = true
@@ -0,0 +1,49 @@
+//go:build linux
+
+package shadow
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a reflink of src with FICLONE: the copy shares
+// the blocks of src until either is written, so no data is copied. Only
+// copy-on-write filesystems such as btrfs and XFS support it, and only within
+// one filesystem; dst is removed again if cloning fails.
+func cloneFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// copyRange copies src to dst inside the kernel with copy_file_range, which
+// NFS 4.2 servers perform server-side and some filesystems turn into a
+// reflink. It returns the number of bytes copied before any error.
+func copyRange(dst, src *os.File) (int64, error) {
+	var copied int64
+	for {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, 1<<30, 0)
+		if err != nil {
+			return copied, err
+		}
+		if n == 0 {
+			return copied, nil
+		}
+		copied += int64(n)
+	}
+}
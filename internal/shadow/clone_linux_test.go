@@ -0,0 +1,59 @@
+//go:build linux
+
+package shadow
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyRange(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	src := filepath.Join(tmpDir, "source.bin")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(tmpDir, "copy.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	n, err := copyRange(out, in)
+	if err != nil {
+		t.Skipf("copy_file_range not supported here: %v", err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("Expected %d bytes copied, got %d", len(content), n)
+	}
+	if got, _ := os.ReadFile(out.Name()); !bytes.Equal(got, content) {
+		t.Error("Copied content differs from the source")
+	}
+}
+
+func TestCloneFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "source.txt")
+	dst := filepath.Join(tmpDir, "clone.txt")
+	if err := os.WriteFile(src, []byte("cloned content"), 0644); err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := cloneFile(src, dst); err != nil {
+		// Most test machines do not run on btrfs or XFS with reflinks
+		if _, statErr := os.Stat(dst); !os.IsNotExist(statErr) {
+			t.Errorf("Expected no file left behind after a failed clone, got %v", statErr)
+		}
+		t.Skipf("reflinks not supported here: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "cloned content" {
+		t.Errorf("Expected the clone to hold the source content, got %q", got)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package shadow
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on Windows; copies are always written
+func cloneFile(_, _ string) error {
+	return errors.ErrUnsupported
+}
+
+// copyRange is not supported on Windows; copies are always streamed
+func copyRange(_, _ *os.File) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,317 @@
+package shadow
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+// ManifestName is the file below the shadow base path that lists every
+// verified shadow copy, one JSON object per line
+const ManifestName = "manifest.jsonl"
+
+// Statuses of shadow copies. A copy is only kept once its file was
+// delivered; a restore appends a line updating its status.
+const (
+	StatusDelivered = "delivered"
+	StatusRestored  = "restored"
+)
+
+// ManifestEntry records a shadow copy
+type ManifestEntry struct {
+	Time        time.Time `json:"time"`
+	Directory   string    `json:"directory,omitempty"` // set in search results, not stored
+	Source      string    `json:"source"`              // path of the original file
+	Path        string    `json:"path"`                // path of the shadow copy
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`              // hex SHA-256, verified against the copy and its source
+	Compression string    `json:"compression,omitempty"` // gzip if the copy is stored compressed
+	StoredSize  int64     `json:"stored_size,omitempty"` // size of the compressed copy
+	TransferID  string    `json:"transfer_id,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	RestoredTo  string    `json:"restored_to,omitempty"` // where the copy was last restored to
+}
+
+// Errors returned by Lookup and Restore
+var (
+	ErrNotFound         = errors.New("no such shadow copy in the manifest")
+	ErrChecksumMismatch = errors.New("shadow copy does not match its manifest checksum")
+)
+
+// manifestLocks serializes changes to each manifest, so that lines appended
+// by restores, which do not go through a Manager, are not lost to a
+// compaction replacing the file
+var manifestLocks sync.Map // manifest path -> *sync.Mutex
+
+// lockManifest locks a manifest for changes and returns its unlock function
+func lockManifest(manifest string) func() {
+	v, _ := manifestLocks.LoadOrStore(filepath.Clean(manifest), new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// manifestPath returns where the manifest of this shadow directory is kept
+func (m *Manager) manifestPath() string {
+	return manifestFile(m.config)
+}
+
+func manifestFile(cfg config.ShadowConfig) string {
+	return filepath.Join(cfg.GetBasePath(), ManifestName)
+}
+
+// record appends a verified copy of size bytes of content to the manifest.
+// Callers must hold m.mu.
+func (m *Manager) record(source, path string, size int64, checksum string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat shadow copy: %w", err)
+	}
+	entry := ManifestEntry{
+		Time:       time.Now().UTC(),
+		Source:     source,
+		Path:       path,
+		Size:       size,
+		Checksum:   checksum,
+		TransferID: transferid.Lookup(source),
+		Status:     StatusDelivered,
+	}
+	if c := m.config.GetCompression(); c != config.ShadowCompressionNone {
+		entry.Compression, entry.StoredSize = c, info.Size()
+		compressedBytes.With("original").Add(uint64(size))      // #nosec G115 -- sizes are never negative
+		compressedBytes.With("stored").Add(uint64(info.Size())) // #nosec G115 -- sizes are never negative
+	}
+	return appendManifest(m.manifestPath(), entry)
+}
+
+// appendManifest appends an entry to a manifest
+func appendManifest(manifest string, entry ManifestEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	defer lockManifest(manifest)()
+	f, err := os.OpenFile(manifest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open shadow manifest: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write shadow manifest: %w", err)
+	}
+	return f.Close()
+}
+
+// compactManifest drops the entries of copies removed by cleanup. Callers
+// must hold m.mu.
+func (m *Manager) compactManifest() error {
+	path := m.manifestPath()
+	defer lockManifest(path)()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read shadow manifest: %w", err)
+	}
+
+	var kept []byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry ManifestEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue // torn line from a crash
+		}
+		if _, err := os.Lstat(entry.Path); err == nil {
+			kept = append(append(kept, scanner.Bytes()...), '\n')
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0o644); err != nil {
+		return fmt.Errorf("failed to write shadow manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace shadow manifest: %w", err)
+	}
+	return nil
+}
+
+// Query filters manifest entries. Zero fields match every entry.
+type Query struct {
+	Name     string // case-insensitive substring of the original path
+	Checksum string
+	Since    time.Time
+	Until    time.Time
+	Limit    int // most entries returned, 0 for all
+}
+
+// Search returns the copies listed in the manifest of a shadow directory
+// that match q, newest first, each with its latest status
+func Search(cfg config.ShadowConfig, q Query) ([]ManifestEntry, error) {
+	entries, err := readManifest(manifestFile(cfg))
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(q.Name)
+	var matches []ManifestEntry
+	for _, e := range slices.Backward(entries) {
+		switch {
+		case name != "" && !strings.Contains(strings.ToLower(e.Source), name),
+			q.Checksum != "" && !strings.EqualFold(e.Checksum, q.Checksum),
+			!q.Since.IsZero() && e.Time.Before(q.Since),
+			!q.Until.IsZero() && e.Time.After(q.Until):
+			continue
+		}
+		matches = append(matches, e)
+		if q.Limit > 0 && len(matches) == q.Limit {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// readManifest reads a manifest into one entry per copy, oldest first. Later
+// lines for a copy update its status.
+func readManifest(manifest string) ([]ManifestEntry, error) {
+	f, err := os.Open(manifest) // #nosec G304 -- below the configured shadow path
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shadow manifest: %w", err)
+	}
+	defer f.Close()
+
+	var entries []ManifestEntry
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e ManifestEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue // torn line from a crash
+		}
+		if i, ok := index[e.Path]; ok {
+			entries[i].Status, entries[i].RestoredTo = e.Status, e.RestoredTo
+			continue
+		}
+		index[e.Path] = len(entries)
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read shadow manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// Lookup returns the manifest entry of the shadow copy at path
+func Lookup(cfg config.ShadowConfig, path string) (ManifestEntry, error) {
+	entries, err := readManifest(manifestFile(cfg))
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	i := slices.IndexFunc(entries, func(e ManifestEntry) bool { return e.Path == filepath.Clean(path) })
+	if i < 0 {
+		return ManifestEntry{}, ErrNotFound
+	}
+	return entries[i], nil
+}
+
+// RestorePath returns where a copy of source is restored to in dir: at its
+// path below watchPath, or by its name if it came from elsewhere
+func RestorePath(dir, watchPath, source string) string {
+	rel, err := filepath.Rel(watchPath, source)
+	if watchPath == "" || err != nil || !filepath.IsLocal(rel) {
+		rel = filepath.Base(source)
+	}
+	return filepath.Join(dir, rel)
+}
+
+// Restore copies a shadow copy back to target, for example into the watch
+// directory to deliver it again, and records the restore in the manifest.
+// The copy must match its checksum. target must not exist; it appears
+// atomically, so a watcher never picks up a partial file.
+func Restore(cfg config.ShadowConfig, entry ManifestEntry, target string) (ManifestEntry, error) {
+	if _, err := os.Lstat(target); err == nil {
+		return entry, fmt.Errorf("failed to restore to %s: %w", target, os.ErrExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return entry, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	partial := target + ".partial"
+	if err := restoreCopy(entry, partial); err != nil {
+		os.Remove(partial)
+		return entry, err
+	}
+	if err := os.Rename(partial, target); err != nil {
+		os.Remove(partial)
+		return entry, fmt.Errorf("failed to move restored file into place: %w", err)
+	}
+
+	entry.Status, entry.RestoredTo = StatusRestored, target
+	restored := entry
+	restored.Time = time.Now().UTC()
+	return entry, appendManifest(manifestFile(cfg), restored)
+}
+
+// restoreCopy copies a shadow copy to dst, decompressing it if it is stored
+// compressed, and checks it against its entry
+func restoreCopy(entry ManifestEntry, dst string) error {
+	f, err := os.Open(entry.Path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var src io.Reader = f
+	if entry.Compression == config.ShadowCompressionGzip {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+		}
+		src = gz
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644) // #nosec G304 -- restore target chosen by the caller
+	if err != nil {
+		return fmt.Errorf("failed to create restored file: %w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), src)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, gzip.ErrChecksum) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy shadow copy: %w", err)
+	}
+	if size != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
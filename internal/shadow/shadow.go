@@ -1,21 +1,44 @@
 package shadow
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/diskspace"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/perm"
+	"github.com/muzy/xferd/internal/strftime"
 )
 
+// shadowCopies counts shadow copies by how their content was copied
+var shadowCopies = metrics.NewCounterVec("xferd_shadow_copies_total",
+	"Shadow copies written, by method: clone (reflink sharing the source's blocks), copy_range (copied in the kernel) or stream (written while the file is read)",
+	"method")
+
+// compressedBytes counts the content of compressed shadow copies
+var compressedBytes = metrics.NewCounterVec("xferd_shadow_compressed_bytes_total",
+	"Bytes of shadow copies compressed at rest, by size: original or stored",
+	"size")
+
 // Manager handles shadow directory operations
 type Manager struct {
-	config config.ShadowConfig
-	mu     sync.Mutex
+	config    config.ShadowConfig
+	watchPath string // copies in the relative layout are named by their path below it
+	perm      *perm.Perm
+	disk      *diskspace.Monitor // copies are paused while the shadow volume is low on space
+	mu        sync.Mutex
 }
 
 // NewManager creates a new shadow directory manager
@@ -24,8 +47,8 @@ func NewManager(cfg config.ShadowConfig) (*Manager, error) {
 		return &Manager{config: cfg}, nil
 	}
 
-	// Ensure shadow directory exists
-	if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+	// Ensure shadow directory exists; dated subdirectories are created per file
+	if err := os.MkdirAll(cfg.GetBasePath(), 0o755); err != nil {
 		return nil, fmt.Errorf("failed to create shadow directory: %w", err)
 	}
 
@@ -34,12 +57,43 @@ func NewManager(cfg config.ShadowConfig) (*Manager, error) {
 	}, nil
 }
 
+// SetWatchPath sets the watch directory that copies in the relative layout
+// mirror
+func (m *Manager) SetWatchPath(watchPath string) {
+	m.watchPath = watchPath
+}
+
+// SetPermissions sets the mode and owner given to shadow copies and the
+// directories created for them
+func (m *Manager) SetPermissions(p *perm.Perm) {
+	m.perm = p
+}
+
+// SetDiskMonitor sets the monitor that pauses shadow copies while the
+// shadow volume is low on space
+func (m *Manager) SetDiskMonitor(d *diskspace.Monitor) {
+	m.disk = d
+}
+
+// checkSpace fails while the shadow volume is low on space, so copies are
+// not started that would fail part way through
+func (m *Manager) checkSpace() error {
+	if free, low := m.disk.Low(m.config.GetBasePath()); low {
+		return fmt.Errorf("shadow copies paused: %s has %d bytes free, below disk_space.shadow_min_free_bytes", m.config.GetBasePath(), free)
+	}
+	return nil
+}
+
 // Store copies a file to the shadow directory
 func (m *Manager) Store(sourcePath string) error {
 	if !m.config.Enabled {
 		return nil
 	}
 
+	if err := m.checkSpace(); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -48,17 +102,212 @@ func (m *Manager) Store(sourcePath string) error {
 
 	// Ensure parent directory exists
 	shadowDir := filepath.Dir(shadowPath)
-	if err := os.MkdirAll(shadowDir, 0o755); err != nil {
+	if err := m.perm.MkdirAll(shadowDir, 0o755); err != nil {
 		return fmt.Errorf("failed to create shadow subdirectory: %w", err)
 	}
 
 	// Create a real copy of the file
-	if err := m.copyFile(sourcePath, shadowPath); err != nil {
+	size, checksum, err := m.copyFile(sourcePath, shadowPath)
+	if err != nil {
 		return fmt.Errorf("failed to copy to shadow: %w", err)
 	}
-	log.Printf("Shadow: copied %s -> %s", sourcePath, shadowPath)
+	if err := m.perm.Apply(shadowPath); err != nil {
+		return err
+	}
+	log.Printf("Shadow: copied %s -> %s (sha256: %s)", sourcePath, shadowPath, checksum)
 
-	return nil
+	return m.record(sourcePath, shadowPath, size, checksum)
+}
+
+// Copy is a shadow copy that is filled while the source file is read for another
+// purpose, such as an upload, so large files are only read from disk once.
+// Write errors are recorded rather than returned so that a failing shadow volume
+// does not interrupt the reader; they are reported by Commit.
+type Copy struct {
+	mu          sync.Mutex // the reader may still be writing when the copy is aborted
+	manager     *Manager
+	file        *os.File
+	out         io.Writer    // file, or gz writing to it
+	gz          *gzip.Writer // set if the copy is compressed
+	partialPath string
+	path        string
+	source      string
+	hash        hash.Hash
+	size        int64
+	cloned      bool // the copy is a reflink of the source, written content is only hashed
+	err         error
+}
+
+// Begin starts a shadow copy of sourcePath. Returns nil if shadowing is disabled.
+func (m *Manager) Begin(sourcePath string) (*Copy, error) {
+	if !m.config.Enabled {
+		return nil, nil
+	}
+
+	if err := m.checkSpace(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	shadowPath := m.getShadowPath(sourcePath)
+	if err := m.perm.MkdirAll(filepath.Dir(shadowPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create shadow subdirectory: %w", err)
+	}
+
+	// On a copy-on-write filesystem shared with the source, the copy is a
+	// reflink made up front; what the reader sees is then only hashed, so
+	// Commit still verifies that the copy holds exactly what was read.
+	// Compressed copies are always written from what is read.
+	partialPath := shadowPath + ".partial"
+	compressed := m.config.GetCompression() == config.ShadowCompressionGzip
+	cloned := !compressed && cloneFile(sourcePath, partialPath) == nil
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if cloned {
+		flags = os.O_WRONLY
+	}
+	file, err := os.OpenFile(partialPath, flags, 0o644)
+	if err != nil {
+		if cloned {
+			os.Remove(partialPath)
+		}
+		return nil, fmt.Errorf("failed to create shadow file: %w", err)
+	}
+	if err := m.perm.File(file); err != nil {
+		file.Close()
+		os.Remove(partialPath)
+		return nil, err
+	}
+
+	c := &Copy{manager: m, file: file, out: file, partialPath: partialPath, path: shadowPath, source: sourcePath, hash: sha256.New(), cloned: cloned}
+	if compressed {
+		c.gz = gzip.NewWriter(file)
+		c.out = c.gz
+	}
+	return c, nil
+}
+
+// Write appends p to the shadow copy. It always reports success; the first
+// write error is kept and returned by Commit.
+func (c *Copy) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		if !c.cloned {
+			if _, err := c.out.Write(p); err != nil {
+				c.err = err
+			}
+		}
+		c.hash.Write(p)
+		c.size += int64(len(p))
+	}
+	return len(p), nil
+}
+
+// Reset discards what was written so far, for a reader that starts over,
+// e.g. an upload retried against another destination
+func (c *Copy) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	if c.cloned {
+		c.hash.Reset()
+		c.size = 0
+		return
+	}
+	if _, err := c.file.Seek(0, io.SeekStart); err != nil {
+		c.err = err
+		return
+	}
+	if err := c.file.Truncate(0); err != nil {
+		c.err = err
+		return
+	}
+	if c.gz != nil {
+		c.gz.Reset(c.file)
+	}
+	c.hash.Reset()
+	c.size = 0
+}
+
+// Commit syncs the shadow copy, verifies it against what was written and
+// against the source file, moves it into place and records it in the
+// manifest. A copy that was fed only part of its source, e.g. by an upload
+// that stopped reading early, fails here rather than being kept as a backup.
+func (c *Copy) Commit() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		c.abort()
+		return fmt.Errorf("failed to write shadow copy: %w", c.err)
+	}
+	if c.gz != nil {
+		if err := c.gz.Close(); err != nil {
+			c.abort()
+			return fmt.Errorf("failed to write shadow copy: %w", err)
+		}
+	}
+
+	if err := c.file.Sync(); err != nil {
+		c.abort()
+		return fmt.Errorf("failed to sync shadow copy: %w", err)
+	}
+
+	checksum := hex.EncodeToString(c.hash.Sum(nil))
+	if err := verifyFile(c.partialPath, c.manager.config.GetCompression(), c.size, checksum); err != nil {
+		c.abort()
+		return err
+	}
+	// The source is still in place until the copy is committed; if it is gone
+	// already, nothing is left to delete or to compare with
+	if size, sourceChecksum, err := fileChecksum(c.source); err == nil && (size != c.size || sourceChecksum != checksum) {
+		c.abort()
+		return fmt.Errorf("copy verification failed: source is %d bytes (sha256: %s), copy %d bytes (sha256: %s)", size, sourceChecksum, c.size, checksum)
+	} else if err != nil && !os.IsNotExist(err) {
+		c.abort()
+		return fmt.Errorf("failed to read source for verification: %w", err)
+	}
+	if err := c.file.Close(); err != nil {
+		os.Remove(c.partialPath)
+		return fmt.Errorf("failed to close shadow copy: %w", err)
+	}
+	if err := os.Rename(c.partialPath, c.path); err != nil {
+		os.Remove(c.partialPath)
+		return fmt.Errorf("failed to finalize shadow copy: %w", err)
+	}
+
+	log.Printf("Shadow: copied %s -> %s (sha256: %s)", c.source, c.path, checksum)
+	if c.cloned {
+		shadowCopies.With("clone").Inc()
+	} else {
+		shadowCopies.With("stream").Inc()
+	}
+
+	c.manager.mu.Lock()
+	defer c.manager.mu.Unlock()
+	return c.manager.record(c.source, c.path, c.size, checksum)
+}
+
+// Abort discards an uncommitted shadow copy
+func (c *Copy) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.abort()
+}
+
+// abort closes and removes the partial file. Callers must hold c.mu.
+func (c *Copy) abort() {
+	c.file.Close()
+	os.Remove(c.partialPath)
+	if c.err == nil {
+		c.err = os.ErrClosed // later writes are discarded
+	}
 }
 
 // Cleanup removes files older than retention period
@@ -76,13 +325,20 @@ func (m *Manager) Cleanup() error {
 	log.Printf("Shadow cleanup: removing files older than %v", retention)
 
 	removed := 0
-	err := filepath.Walk(m.config.Path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	base := m.config.GetBasePath()
+	var dirs []string
+	manifest := m.manifestPath()
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == manifest {
 			return nil // Skip errors
 		}
 
 		if info.IsDir() {
-			return nil // Skip directories
+			// Dated and relative directories are removed once they are old and empty
+			if path != base && (m.config.Path != base || m.config.GetLayout() == config.ShadowLayoutRelative) && info.ModTime().Before(cutoff) {
+				dirs = append(dirs, path)
+			}
+			return nil
 		}
 
 		if info.ModTime().Before(cutoff) {
@@ -100,7 +356,15 @@ func (m *Manager) Cleanup() error {
 		return fmt.Errorf("shadow cleanup failed: %w", err)
 	}
 
+	// Deepest first, so parents emptied by their children go too
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i]) // fails for directories that are not empty
+	}
+
 	log.Printf("Shadow cleanup: removed %d files", removed)
+	if removed > 0 {
+		return m.compactManifest()
+	}
 	return nil
 }
 
@@ -125,35 +389,198 @@ func (m *Manager) StartCleanupRoutine(stopCh <-chan struct{}) {
 	}
 }
 
-// getShadowPath generates the shadow path for a source file
+// getShadowPath generates the shadow path for a source file. Callers must
+// hold m.mu, so that no two copies are given the same path.
 func (m *Manager) getShadowPath(sourcePath string) string {
+	now := time.Now()
+	dir := strftime.Format(m.config.Path, now.UTC())
+	suffix := ""
+	if m.config.GetCompression() == config.ShadowCompressionGzip {
+		suffix = ".gz"
+	}
+	if m.config.GetLayout() == config.ShadowLayoutRelative {
+		return freePath(filepath.Join(dir, m.relPath(sourcePath)), suffix)
+	}
+
 	// Add timestamp to avoid conflicts
 	base := filepath.Base(sourcePath)
-	timestamp := time.Now().Format("20060102-150405.000000")
+	timestamp := now.Format("20060102-150405.000000")
 	shadowName := fmt.Sprintf("%s-%s", timestamp, base)
 
-	return filepath.Join(m.config.Path, shadowName)
+	return filepath.Join(dir, shadowName+suffix)
+}
+
+// relPath returns the path of a file below the watch directory, or its
+// name if it is not below it
+func (m *Manager) relPath(sourcePath string) string {
+	if m.watchPath == "" {
+		return filepath.Base(sourcePath)
+	}
+	rel, err := filepath.Rel(m.watchPath, sourcePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Base(sourcePath)
+	}
+	return rel
 }
 
-// copyFile copies a file from src to dst
-func (m *Manager) copyFile(src, dst string) error {
+// freePath returns path with suffix appended, or if a copy (or one being
+// written) is there already, with a numeric suffix added before its extension
+func freePath(path, suffix string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	target := path + suffix
+	for i := 1; exists(target) || exists(target+".partial"); i++ {
+		target = base + "." + strconv.Itoa(i) + ext + suffix
+	}
+	return target
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// copyFile copies a file from src to dst and verifies the copy after syncing.
+// The copy is a reflink if the filesystem supports it, or else copied in the
+// kernel if possible, before falling back to streaming the content.
+// Compressed copies are always streamed. Returns the size and hex SHA-256
+// checksum of the copied content.
+func (m *Manager) copyFile(src, dst string) (int64, string, error) {
+	compression := m.config.GetCompression()
+	if compression == config.ShadowCompressionNone {
+		if err := cloneFile(src, dst); err == nil {
+			shadowCopies.With("clone").Inc()
+			return verifyCopy(src, dst)
+		}
+	}
+
 	source, err := os.Open(src)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 	defer source.Close()
 
 	destination, err := os.Create(dst)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 	defer destination.Close()
 
-	// Stream copy to handle large files
-	if _, err := io.Copy(destination, source); err != nil {
-		return err
+	if compression == config.ShadowCompressionNone {
+		if n, err := copyRange(destination, source); err == nil {
+			if err := destination.Sync(); err != nil {
+				return 0, "", err
+			}
+			shadowCopies.With("copy_range").Inc()
+			return verifyCopy(src, dst)
+		} else if n > 0 {
+			destination.Close()
+			os.Remove(dst)
+			return 0, "", err
+		}
+	}
+
+	// Stream copy to handle large files, hashing what is written
+	var out io.Writer = destination
+	var gz *gzip.Writer
+	if compression == config.ShadowCompressionGzip {
+		gz = gzip.NewWriter(destination)
+		out = gz
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), source)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		destination.Close()
+		os.Remove(dst)
+		return 0, "", err
 	}
 
 	// Sync to disk
-	return destination.Sync()
+	if err := destination.Sync(); err != nil {
+		return 0, "", err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err := verifyFile(dst, compression, size, checksum); err != nil {
+		destination.Close()
+		os.Remove(dst)
+		return 0, "", err
+	}
+	shadowCopies.With("stream").Inc()
+	return size, checksum, nil
+}
+
+// verifyCopy checks a copy made without reading the source against the
+// source, returning its size and checksum. The copy is removed if it differs.
+func verifyCopy(src, dst string) (int64, string, error) {
+	size, checksum, err := fileChecksum(src)
+	if err != nil {
+		os.Remove(dst)
+		return 0, "", fmt.Errorf("failed to read source for verification: %w", err)
+	}
+	if err := verifyFile(dst, config.ShadowCompressionNone, size, checksum); err != nil {
+		os.Remove(dst)
+		return 0, "", err
+	}
+	return size, checksum, nil
+}
+
+// verifyFile reads back a written file, decompressing it if it was stored
+// compressed, and checks its size and SHA-256 checksum, catching silent
+// truncation or corruption on the shadow volume
+func verifyFile(path, compression string, size int64, checksum string) error {
+	n, got, err := contentChecksum(path, compression)
+	if err != nil {
+		return fmt.Errorf("failed to read copy for verification: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("copy verification failed: wrote %d bytes, read back %d", size, n)
+	}
+	if got != checksum {
+		return fmt.Errorf("copy verification failed: checksum mismatch (expected %s, got %s)", checksum, got)
+	}
+	return nil
+}
+
+// contentChecksum returns the size and hex SHA-256 checksum of the content
+// of a copy stored with compression
+func contentChecksum(path, compression string) (int64, string, error) {
+	if compression != config.ShadowCompressionGzip {
+		return fileChecksum(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, gz)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileChecksum returns the size and hex SHA-256 checksum of a file
+func fileChecksum(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
 }
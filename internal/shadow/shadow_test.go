@@ -1,15 +1,31 @@
 package shadow
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/diskspace"
 )
 
+// readCopies lists a shadow directory without its manifest
+func readCopies(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	return slices.DeleteFunc(entries, func(e os.DirEntry) bool { return e.Name() == ManifestName }), err
+}
+
 func TestNewManagerDisabled(t *testing.T) {
 	cfg := config.ShadowConfig{
 		Enabled: false,
@@ -115,7 +131,7 @@ func TestStoreFileEnabled(t *testing.T) {
 	}
 
 	// Verify shadow file exists (with timestamp prefix)
-	files, err := os.ReadDir(shadowPath)
+	files, err := readCopies(shadowPath)
 	if err != nil {
 		t.Fatalf("Failed to read shadow directory: %v", err)
 	}
@@ -173,7 +189,7 @@ func TestStoreMultipleFiles(t *testing.T) {
 	}
 
 	// Verify all files exist
-	files, err := os.ReadDir(shadowPath)
+	files, err := readCopies(shadowPath)
 	if err != nil {
 		t.Fatalf("Failed to read shadow directory: %v", err)
 	}
@@ -379,11 +395,16 @@ func TestCopyFile(t *testing.T) {
 
 	// Copy file
 	destFile := filepath.Join(shadowPath, "dest.txt")
-	err = mgr.copyFile(sourceFile, destFile)
+	_, checksum, err := mgr.copyFile(sourceFile, destFile)
 	if err != nil {
 		t.Fatalf("Failed to copy file: %v", err)
 	}
 
+	sum := sha256.Sum256(content)
+	if expected := hex.EncodeToString(sum[:]); checksum != expected {
+		t.Errorf("Expected checksum %s, got %s", expected, checksum)
+	}
+
 	// Verify destination file exists and has correct content
 	destContent, err := os.ReadFile(destFile)
 	if err != nil {
@@ -422,7 +443,7 @@ func TestCopyLargeFile(t *testing.T) {
 
 	// Copy file
 	destFile := filepath.Join(shadowPath, "large-copy.bin")
-	err = mgr.copyFile(sourceFile, destFile)
+	_, _, err = mgr.copyFile(sourceFile, destFile)
 	if err != nil {
 		t.Fatalf("Failed to copy large file: %v", err)
 	}
@@ -457,7 +478,7 @@ func TestCopyNonexistentFile(t *testing.T) {
 	sourceFile := filepath.Join(tmpDir, "nonexistent.txt")
 	destFile := filepath.Join(shadowPath, "dest.txt")
 
-	err = mgr.copyFile(sourceFile, destFile)
+	_, _, err = mgr.copyFile(sourceFile, destFile)
 	if err == nil {
 		t.Fatal("Expected error copying nonexistent file, got nil")
 	}
@@ -536,7 +557,7 @@ func TestConcurrentStores(t *testing.T) {
 	}
 
 	// Verify files exist
-	files, err := os.ReadDir(shadowPath)
+	files, err := readCopies(shadowPath)
 	if err != nil {
 		t.Fatalf("Failed to read shadow directory: %v", err)
 	}
@@ -545,3 +566,559 @@ func TestConcurrentStores(t *testing.T) {
 		t.Errorf("Expected 10 files in shadow directory, got %d", len(files))
 	}
 }
+
+func TestCopyCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, "shadow")
+
+	mgr, err := NewManager(config.ShadowConfig{Enabled: true, Path: shadowPath, RetentionHours: 24})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	shadowCopy, err := mgr.Begin(filepath.Join(tmpDir, "test.txt"))
+	if err != nil {
+		t.Fatalf("Failed to begin shadow copy: %v", err)
+	}
+	_, _ = shadowCopy.Write([]byte("test "))
+	_, _ = shadowCopy.Write([]byte("content"))
+
+	// Nothing is visible under the final name until committed
+	files, _ := readCopies(shadowPath)
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".partial" {
+		t.Fatalf("Expected a single .partial file before commit, got %v", files)
+	}
+
+	if err := shadowCopy.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	files, _ = readCopies(shadowPath)
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".txt" {
+		t.Fatalf("Expected committed shadow file, got %v", files)
+	}
+	content, err := os.ReadFile(filepath.Join(shadowPath, files[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read shadow file: %v", err)
+	}
+	if string(content) != "test content" {
+		t.Errorf("Shadow file content mismatch. Expected 'test content', got '%s'", string(content))
+	}
+}
+
+func TestCopyReset(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, "shadow")
+
+	mgr, err := NewManager(config.ShadowConfig{Enabled: true, Path: shadowPath, RetentionHours: 24})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	shadowCopy, err := mgr.Begin(filepath.Join(tmpDir, "test.txt"))
+	if err != nil {
+		t.Fatalf("Failed to begin shadow copy: %v", err)
+	}
+	_, _ = shadowCopy.Write([]byte("partial first attempt"))
+	shadowCopy.Reset()
+	_, _ = shadowCopy.Write([]byte("content"))
+
+	// The checksum verification on commit covers the reset content only
+	if err := shadowCopy.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	files, _ := readCopies(shadowPath)
+	if len(files) != 1 {
+		t.Fatalf("Expected committed shadow file, got %v", files)
+	}
+	content, err := os.ReadFile(filepath.Join(shadowPath, files[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read shadow file: %v", err)
+	}
+	if string(content) != "content" {
+		t.Errorf("Expected 'content' after reset, got '%s'", string(content))
+	}
+}
+
+func TestCopyAbort(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, "shadow")
+
+	mgr, err := NewManager(config.ShadowConfig{Enabled: true, Path: shadowPath, RetentionHours: 24})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	shadowCopy, err := mgr.Begin(filepath.Join(tmpDir, "test.txt"))
+	if err != nil {
+		t.Fatalf("Failed to begin shadow copy: %v", err)
+	}
+	_, _ = shadowCopy.Write([]byte("partial"))
+	shadowCopy.Abort()
+
+	// Writes after abort are discarded without error
+	if n, err := shadowCopy.Write([]byte("more")); n != 4 || err != nil {
+		t.Errorf("Expected write after abort to be discarded, got n=%d err=%v", n, err)
+	}
+	if err := shadowCopy.Commit(); err == nil {
+		t.Error("Expected commit after abort to fail")
+	}
+
+	files, _ := readCopies(shadowPath)
+	if len(files) != 0 {
+		t.Errorf("Expected no files after abort, got %d", len(files))
+	}
+}
+
+func TestBeginDisabled(t *testing.T) {
+	mgr, err := NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	shadowCopy, err := mgr.Begin("/tmp/test.txt")
+	if err != nil || shadowCopy != nil {
+		t.Errorf("Expected no shadow copy when disabled, got %v, %v", shadowCopy, err)
+	}
+}
+
+func TestLowDiskPausesCopies(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, "shadow")
+	source := filepath.Join(tmpDir, "source.txt")
+	if err := os.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	mgr, err := NewManager(config.ShadowConfig{Enabled: true, Path: shadowPath})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	disk := diskspace.NewMonitor()
+	disk.Watch(shadowPath, math.MaxUint64)
+	disk.Check()
+	mgr.SetDiskMonitor(disk)
+
+	if err := mgr.Store(source); err == nil {
+		t.Error("Expected Store to fail while the shadow volume is low")
+	}
+	if shadowCopy, err := mgr.Begin(source); err == nil || shadowCopy != nil {
+		t.Errorf("Expected Begin to fail while the shadow volume is low, got %v, %v", shadowCopy, err)
+	}
+	if entries, _ := readCopies(shadowPath); len(entries) != 0 {
+		t.Errorf("Expected no copies, got %d", len(entries))
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "copy.txt")
+	content := []byte("shadow content")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	if err := verifyFile(path, config.ShadowCompressionNone, int64(len(content)), checksum); err != nil {
+		t.Errorf("Expected intact file to verify, got %v", err)
+	}
+
+	// Simulate silent truncation on the shadow volume
+	if err := os.Truncate(path, 6); err != nil {
+		t.Fatalf("Failed to truncate file: %v", err)
+	}
+	if err := verifyFile(path, config.ShadowCompressionNone, int64(len(content)), checksum); err == nil {
+		t.Error("Expected truncated file to fail verification")
+	}
+
+	// Same size, different content
+	if err := os.WriteFile(path, []byte("shadow CONTENT"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	if err := verifyFile(path, config.ShadowCompressionNone, int64(len(content)), checksum); err == nil {
+		t.Error("Expected modified file to fail verification")
+	}
+}
+
+func TestDatedShadowPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := filepath.Join(tmpDir, "shadow")
+	cfg := config.ShadowConfig{
+		Enabled:        true,
+		Path:           filepath.Join(base, "%Y", "%m", "%d"),
+		RetentionHours: 1,
+	}
+
+	mgr, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if _, err := os.Stat(base); err != nil {
+		t.Fatalf("Expected the base shadow directory to be created: %v", err)
+	}
+
+	// Copies are partitioned by the date they are made
+	now := time.Now().UTC()
+	shadowPath := mgr.getShadowPath(filepath.Join(tmpDir, "file.txt"))
+	expectedDir := filepath.Join(base, now.Format("2006"), now.Format("01"), now.Format("02"))
+	if filepath.Dir(shadowPath) != expectedDir {
+		t.Errorf("Expected shadow copy in %s, got %s", expectedDir, shadowPath)
+	}
+
+	// Cleanup removes expired copies and the dated directories they leave empty
+	oldDir := filepath.Join(base, "2020", "01", "01")
+	if err := os.MkdirAll(oldDir, 0755); err != nil {
+		t.Fatalf("Failed to create old directory: %v", err)
+	}
+	oldFile := filepath.Join(oldDir, "old.txt")
+	if err := os.WriteFile(oldFile, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create old file: %v", err)
+	}
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{oldFile, oldDir, filepath.Dir(oldDir), filepath.Dir(filepath.Dir(oldDir))} {
+		if err := os.Chtimes(path, twoHoursAgo, twoHoursAgo); err != nil {
+			t.Fatalf("Failed to set timestamp of %s: %v", path, err)
+		}
+	}
+
+	if err := mgr.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "2020")); !os.IsNotExist(err) {
+		t.Error("Expected the expired dated directories to be removed")
+	}
+	if _, err := os.Stat(base); err != nil {
+		t.Errorf("Expected the base shadow directory to be kept: %v", err)
+	}
+}
+
+func TestRelativeShadowPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	base := filepath.Join(tmpDir, "shadow")
+	mgr, err := NewManager(config.ShadowConfig{Enabled: true, Path: base, RetentionHours: 1, Layout: config.ShadowLayoutRelative})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	mgr.SetWatchPath(watchDir)
+
+	source := filepath.Join(watchDir, "2025", "01", "30", "invoice.pdf")
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(source, []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := mgr.Store(source); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	// A second copy of the same path is kept next to the first
+	if err := os.WriteFile(source, []byte("second"), 0644); err != nil {
+		t.Fatalf("Failed to update test file: %v", err)
+	}
+	c, err := mgr.Begin(source)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	_, _ = c.Write([]byte("second"))
+	if err := c.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		filepath.Join(base, "2025", "01", "30", "invoice.pdf"):   "first",
+		filepath.Join(base, "2025", "01", "30", "invoice.1.pdf"): "second",
+	} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("Expected %q at %s, got %q (%v)", want, path, got, err)
+		}
+	}
+
+	// Files outside the watch directory are stored by name
+	if got := mgr.getShadowPath(filepath.Join(tmpDir, "other", "note.txt")); got != filepath.Join(base, "note.txt") {
+		t.Errorf("Expected %s, got %s", filepath.Join(base, "note.txt"), got)
+	}
+
+	// Cleanup removes the directories expired copies leave empty
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{
+		filepath.Join(base, "2025", "01", "30", "invoice.pdf"),
+		filepath.Join(base, "2025", "01", "30", "invoice.1.pdf"),
+		filepath.Join(base, "2025", "01", "30"),
+		filepath.Join(base, "2025", "01"),
+		filepath.Join(base, "2025"),
+	} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("Failed to set timestamp of %s: %v", path, err)
+		}
+	}
+	if err := mgr.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "2025")); !os.IsNotExist(err) {
+		t.Error("Expected the emptied directories to be removed")
+	}
+}
+
+func TestCopyVerifiesSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, "shadow")
+	mgr, err := NewManager(config.ShadowConfig{Enabled: true, Path: shadowPath, RetentionHours: 24})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	source := filepath.Join(tmpDir, "invoice.pdf")
+	if err := os.WriteFile(source, []byte("full invoice content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// A copy fed only part of its source is discarded
+	short, err := mgr.Begin(source)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	_, _ = short.Write([]byte("full invoice"))
+	if err := short.Commit(); err == nil {
+		t.Fatal("Expected a short copy to fail verification")
+	}
+	if files, _ := readCopies(shadowPath); len(files) != 0 {
+		t.Errorf("Expected the short copy to be removed, got %v", files)
+	}
+
+	// A complete copy is committed and recorded in the manifest
+	full, err := mgr.Begin(source)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	_, _ = full.Write([]byte("full invoice content"))
+	if err := full.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(shadowPath, ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var entry ManifestEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Expected a single manifest entry, got %q: %v", data, err)
+	}
+	sum := sha256.Sum256([]byte("full invoice content"))
+	if entry.Source != source || entry.Size != 20 || entry.Checksum != hex.EncodeToString(sum[:]) || entry.Path != full.path {
+		t.Errorf("Unexpected manifest entry %+v", entry)
+	}
+
+	// Cleanup drops the entries of expired copies
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(entry.Path, old, old); err != nil {
+		t.Fatalf("Failed to set timestamp: %v", err)
+	}
+	if err := mgr.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(shadowPath, ManifestName)); err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty manifest after cleanup, got %q (%v)", data, err)
+	}
+}
+
+func TestSearchAndRestore(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ShadowConfig{Enabled: true, Path: filepath.Join(tmpDir, "shadow"), RetentionHours: 24}
+	mgr, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	for _, name := range []string{"invoice.pdf", "report.csv"} {
+		source := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(source, []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := mgr.Store(source); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	if entries, err := Search(cfg, Query{}); err != nil || len(entries) != 2 || filepath.Base(entries[0].Source) != "report.csv" {
+		t.Fatalf("Expected both copies newest first, got %+v (%v)", entries, err)
+	}
+	sum := sha256.Sum256([]byte("content of invoice.pdf"))
+	entries, err := Search(cfg, Query{Name: "INVOICE", Checksum: hex.EncodeToString(sum[:])})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected the invoice, got %+v (%v)", entries, err)
+	}
+	if found, _ := Search(cfg, Query{Since: time.Now().Add(time.Hour)}); len(found) != 0 {
+		t.Errorf("Expected no copies from the future, got %+v", found)
+	}
+
+	entry, err := Lookup(cfg, entries[0].Path)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	target := filepath.Join(tmpDir, "restored", "invoice.pdf")
+	restored, err := Restore(cfg, entry, target)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "content of invoice.pdf" {
+		t.Errorf("Unexpected restored content %q", data)
+	}
+	if restored.Status != StatusRestored || restored.RestoredTo != target {
+		t.Errorf("Expected a restored entry, got %+v", restored)
+	}
+	if entry, _ := Lookup(cfg, entries[0].Path); entry.Status != StatusRestored || entry.RestoredTo != target {
+		t.Errorf("Expected the restore to be recorded, got %+v", entry)
+	}
+
+	// An existing file is never overwritten
+	if _, err := Restore(cfg, entry, target); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected os.ErrExist, got %v", err)
+	}
+	if _, err := Lookup(cfg, filepath.Join(cfg.Path, "missing.pdf")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// A corrupt copy is not restored
+	if err := os.WriteFile(entry.Path, []byte("tampered"), 0644); err != nil {
+		t.Fatalf("Failed to modify copy: %v", err)
+	}
+	other := filepath.Join(tmpDir, "restored", "again.pdf")
+	if _, err := Restore(cfg, entry, other); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := os.Lstat(other); !os.IsNotExist(err) {
+		t.Errorf("Expected no file after a failed restore, got %v", err)
+	}
+}
+
+func TestRestoreDuringCleanup(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ShadowConfig{Enabled: true, Path: filepath.Join(tmpDir, "shadow"), RetentionHours: 1}
+	mgr, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	const copies = 20
+	for i := 0; i < copies; i++ {
+		source := filepath.Join(tmpDir, fmt.Sprintf("file-%d.txt", i))
+		if err := os.WriteFile(source, []byte(fmt.Sprintf("content %d", i)), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := mgr.Store(source); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	entries, err := Search(cfg, Query{})
+	if err != nil || len(entries) != copies {
+		t.Fatalf("Expected %d copies, got %d (%v)", copies, len(entries), err)
+	}
+
+	// Every cleanup removes an expired file and so rewrites the manifest
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		old := time.Now().Add(-2 * time.Hour)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			expired := filepath.Join(cfg.Path, fmt.Sprintf("expired-%d.txt", i))
+			if err := os.WriteFile(expired, []byte("old"), 0644); err != nil {
+				t.Errorf("Failed to create expired file: %v", err)
+				return
+			}
+			_ = os.Chtimes(expired, old, old)
+			if err := mgr.Cleanup(); err != nil {
+				t.Errorf("Cleanup failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Restore(cfg, entry, filepath.Join(tmpDir, "restored", filepath.Base(entry.Source))); err != nil {
+				t.Errorf("Restore failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+
+	for _, entry := range entries {
+		if got, err := Lookup(cfg, entry.Path); err != nil || got.Status != StatusRestored {
+			t.Errorf("Expected the restore of %s to be recorded, got %+v (%v)", entry.Source, got, err)
+		}
+	}
+}
+
+func TestCompressedCopies(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.ShadowConfig{Enabled: true, Path: filepath.Join(tmpDir, "shadow"), RetentionHours: 24, Compression: config.ShadowCompressionGzip}
+	mgr, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	content := []byte(strings.Repeat("id;amount;currency\n42;13.37;EUR\n", 1000))
+	source := filepath.Join(tmpDir, "export.csv")
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Stored copies and copies written while the file is read are both gzipped
+	if err := mgr.Store(source); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	c, err := mgr.Begin(source)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	_, _ = c.Write([]byte("partial"))
+	c.Reset()
+	_, _ = c.Write(content)
+	if err := c.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	entries, err := Search(cfg, Query{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected 2 manifest entries, got %+v (%v)", entries, err)
+	}
+	sum := sha256.Sum256(content)
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Path, "export.csv.gz") || e.Compression != config.ShadowCompressionGzip {
+			t.Errorf("Expected a gzipped copy, got %+v", e)
+		}
+		if e.Size != int64(len(content)) || e.Checksum != hex.EncodeToString(sum[:]) || e.StoredSize == 0 || e.StoredSize >= e.Size {
+			t.Errorf("Expected the original size and checksum and a smaller stored size, got %+v", e)
+		}
+		if info, err := os.Stat(e.Path); err != nil || info.Size() != e.StoredSize {
+			t.Errorf("Expected %s to be %d bytes, got %v", e.Path, e.StoredSize, err)
+		}
+	}
+
+	// Restored files are decompressed
+	target := filepath.Join(tmpDir, "restored", "export.csv")
+	if _, err := Restore(cfg, entries[0], target); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if data, _ := os.ReadFile(target); !bytes.Equal(data, content) {
+		t.Errorf("Expected the original content, got %d bytes", len(data))
+	}
+
+	// A truncated copy fails verification
+	if err := os.Truncate(entries[1].Path, entries[1].StoredSize/2); err != nil {
+		t.Fatalf("Failed to truncate copy: %v", err)
+	}
+	if _, err := Restore(cfg, entries[1], filepath.Join(tmpDir, "restored", "again.csv")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/muzy/xferd/internal/perm"
+)
+
+// Local writes files to a temp directory on disk and renames them into
+// place on commit. The temp directory should be on the same filesystem as
+// the destinations so the rename is atomic; on another filesystem files are
+// copied next to their destination and renamed there instead.
+type Local struct {
+	tempDir string
+	perm    *perm.Perm
+}
+
+// NewLocal creates local disk storage staging files in tempDir
+func NewLocal(tempDir string) *Local {
+	return &Local{tempDir: tempDir}
+}
+
+// SetPermissions sets the mode and owner given to created files and
+// directories. Must be called before the first Create.
+func (l *Local) SetPermissions(p *perm.Perm) {
+	l.perm = p
+}
+
+// Create creates the destination directory and a .partial file in the temp directory
+func (l *Local) Create(_ context.Context, path string) (File, error) {
+	if err := l.perm.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tempPath := filepath.Join(l.tempDir, filepath.Base(path)+".partial")
+	f, err := os.Create(tempPath) // #nosec G304 -- inside the configured temp directory
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	return &localFile{file: f, tempPath: tempPath, path: path, perm: l.perm}, nil
+}
+
+// localFile is a file staged in the temp directory
+type localFile struct {
+	file     *os.File
+	tempPath string
+	path     string
+	perm     *perm.Perm
+}
+
+// Write appends p to the staged file
+func (f *localFile) Write(p []byte) (int, error) {
+	return f.file.Write(p)
+}
+
+// Commit syncs the staged file to disk and atomically renames it into place
+func (f *localFile) Commit() error {
+	return f.commit(f.path)
+}
+
+// CommitTo syncs the staged file to disk and renames it to path
+func (f *localFile) CommitTo(path string) error {
+	if err := f.perm.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return f.commit(path)
+}
+
+// commit syncs the staged file to disk and atomically renames it to path
+func (f *localFile) commit(path string) error {
+	if err := f.file.Sync(); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := f.perm.File(f.file); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		os.Remove(f.tempPath)
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	err := os.Rename(f.tempPath, path)
+	if isCrossDevice(err) {
+		if err = f.copyTo(path); err == nil {
+			os.Remove(f.tempPath)
+		}
+	}
+	if err != nil {
+		os.Remove(f.tempPath)
+		return fmt.Errorf("failed to finalize file: %w", err)
+	}
+	return nil
+}
+
+// copyTo publishes the staged file on another filesystem: it is copied to
+// a .partial file next to path, synced and renamed, so the file still
+// appears complete or not at all
+func (f *localFile) copyTo(path string) error {
+	src, err := os.Open(f.tempPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	partial := path + ".partial"
+	dst, err := os.Create(partial) // #nosec G304 -- next to the destination being committed
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err == nil {
+		err = f.perm.File(dst)
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		os.Remove(partial)
+	}
+	return err
+}
+
+// Abort closes and removes the staged file
+func (f *localFile) Abort() {
+	f.file.Close()
+	os.Remove(f.tempPath)
+}
+
+// StalePartials lists the files staged in tempDir that were last written
+// to before cutoff, left behind by uploads that were interrupted by a crash
+func StalePartials(tempDir string, cutoff time.Time) ([]string, error) {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), ".partial") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue // removed meanwhile, or still being written
+		}
+		stale = append(stale, filepath.Join(tempDir, e.Name()))
+	}
+	return stale, nil
+}
+
+// SameFilesystem reports whether a and b are on the same filesystem, so
+// files staged in one can be renamed into the other. Paths that do not
+// exist yet are checked by their closest existing parent.
+func SameFilesystem(a, b string) (bool, error) {
+	devA, err := device(existingParent(a))
+	if err != nil {
+		return false, err
+	}
+	devB, err := device(existingParent(b))
+	if err != nil {
+		return false, err
+	}
+	return devA == devB, nil
+}
+
+// existingParent returns path, or its closest parent that exists
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
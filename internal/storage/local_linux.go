@@ -0,0 +1,24 @@
+//go:build linux
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different filesystems
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// device returns the ID of the filesystem holding path
+func device(path string) (uint64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return stat.Dev, nil
+}
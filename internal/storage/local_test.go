@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	tempDir := filepath.Join(tmpDir, "temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	destPath := filepath.Join(tmpDir, "watch", "sub", "streamed.txt")
+
+	st := NewLocal(tempDir)
+	f, err := st.Create(context.Background(), destPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	content := []byte("test content for streaming")
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Nothing is visible before commit
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected file to be invisible before commit, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "streamed.txt.partial")); err != nil {
+		t.Errorf("Expected staged .partial file: %v", err)
+	}
+
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	written, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read committed file: %v", err)
+	}
+	if string(written) != string(content) {
+		t.Errorf("Content mismatch. Expected '%s', got '%s'", string(content), string(written))
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("Expected temp directory to be empty, got %d entries", len(entries))
+	}
+}
+
+func TestLocalCommitTo(t *testing.T) {
+	tmpDir := t.TempDir()
+	destPath := filepath.Join(tmpDir, "watch", "file.txt")
+	otherPath := filepath.Join(tmpDir, "quarantine", "sub", "file.txt")
+
+	f, err := NewLocal(tmpDir).Create(context.Background(), destPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.(Relocatable).CommitTo(otherPath); err != nil {
+		t.Fatalf("CommitTo failed: %v", err)
+	}
+
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing at the original path, got %v", err)
+	}
+	if written, err := os.ReadFile(otherPath); err != nil || string(written) != "content" {
+		t.Errorf("Expected file at the other path, got %q, %v", written, err)
+	}
+
+	// Directories are created alike whichever path the file is committed to
+	created, err := os.Stat(filepath.Dir(destPath))
+	if err != nil {
+		t.Fatalf("Failed to stat directory: %v", err)
+	}
+	other, err := os.Stat(filepath.Dir(otherPath))
+	if err != nil {
+		t.Fatalf("Failed to stat directory: %v", err)
+	}
+	if created.Mode().Perm() != other.Mode().Perm() {
+		t.Errorf("Expected the same directory modes, got %v and %v", created.Mode().Perm(), other.Mode().Perm())
+	}
+}
+
+func TestLocalLarge(t *testing.T) {
+	tmpDir := t.TempDir()
+	destPath := filepath.Join(tmpDir, "large.bin")
+
+	// Create 5 MB content
+	content := make([]byte, 5*1024*1024)
+	for i := range content {
+		content[i] = byte(i % 256)
+	}
+
+	start := time.Now()
+	f, err := NewLocal(tmpDir).Create(context.Background(), destPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := io.Copy(f, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	t.Logf("Streamed 5 MB in %v", time.Since(start))
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatalf("Failed to stat committed file: %v", err)
+	}
+	if info.Size() != int64(len(content)) {
+		t.Errorf("Size mismatch. Expected %d, got %d", len(content), info.Size())
+	}
+}
+
+func TestLocalAbort(t *testing.T) {
+	tmpDir := t.TempDir()
+	tempDir := filepath.Join(tmpDir, "temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	destPath := filepath.Join(tmpDir, "aborted.txt")
+
+	f, err := NewLocal(tempDir).Create(context.Background(), destPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("partial")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	f.Abort()
+
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected aborted file not to be published, got %v", err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("Expected staged file to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestLocalCopyTo(t *testing.T) {
+	tmpDir := t.TempDir()
+	destPath := filepath.Join(tmpDir, "watch", "copied.txt")
+
+	f, err := NewLocal(tmpDir).Create(context.Background(), destPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// The fallback used when the destination is on another filesystem
+	lf := f.(*localFile)
+	if err := lf.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.copyTo(destPath); err != nil {
+		t.Fatalf("copyTo failed: %v", err)
+	}
+
+	if written, err := os.ReadFile(destPath); err != nil || string(written) != "content" {
+		t.Errorf("Expected copied file, got %q, %v", written, err)
+	}
+	if _, err := os.Stat(destPath + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("Expected no .partial file next to the destination, got %v", err)
+	}
+}
+
+func TestSameFilesystem(t *testing.T) {
+	tmpDir := t.TempDir()
+	same, err := SameFilesystem(tmpDir, filepath.Join(tmpDir, "not", "created", "yet"))
+	if err != nil {
+		t.Fatalf("SameFilesystem failed: %v", err)
+	}
+	if !same {
+		t.Error("Expected a directory and a path below it to be on the same filesystem")
+	}
+}
+
+func TestStalePartials(t *testing.T) {
+	tempDir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"old.txt.partial", "new.txt.partial", "old.txt"} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if name != "new.txt.partial" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stale, err := StalePartials(tempDir, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("StalePartials failed: %v", err)
+	}
+	if len(stale) != 1 || stale[0] != filepath.Join(tempDir, "old.txt.partial") {
+		t.Errorf("Expected only old.txt.partial to be stale, got %v", stale)
+	}
+}
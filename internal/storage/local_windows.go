@@ -0,0 +1,36 @@
+//go:build windows
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different volumes
+func isCrossDevice(err error) bool {
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE)
+}
+
+// device returns the volume holding path as a number, so it compares like
+// a device ID
+func device(path string) (uint64, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+	volume, err := windows.UTF16PtrFromString(strings.ToUpper(filepath.VolumeName(abs)) + `\`)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+	var serial uint32
+	if err := windows.GetVolumeInformation(volume, nil, 0, &serial, nil, nil, nil, 0); err != nil {
+		return 0, fmt.Errorf("GetVolumeInformation %s: %w", path, err)
+	}
+	return uint64(serial), nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Notify wraps storage and reports every committed file, so files can be
+// delivered without a watcher picking them up
+type Notify struct {
+	Storage
+	onCommit func(path string)
+}
+
+// NewNotify creates storage writing to st and calling onCommit with the
+// path of every committed file
+func NewNotify(st Storage, onCommit func(path string)) *Notify {
+	return &Notify{Storage: st, onCommit: onCommit}
+}
+
+// Create starts writing a file through the wrapped storage
+func (n *Notify) Create(ctx context.Context, path string) (File, error) {
+	f, err := n.Storage.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &notifyFile{File: f, path: path, onCommit: n.onCommit}, nil
+}
+
+// notifyFile reports its path once it was committed
+type notifyFile struct {
+	File
+	path     string
+	onCommit func(path string)
+}
+
+// Commit publishes the file and reports it
+func (f *notifyFile) Commit() error {
+	if err := f.File.Commit(); err != nil {
+		return err
+	}
+	f.onCommit(f.path)
+	return nil
+}
+
+// CommitTo publishes the file at another path without reporting it
+func (f *notifyFile) CommitTo(path string) error {
+	r, ok := f.File.(Relocatable)
+	if !ok {
+		f.File.Abort()
+		return fmt.Errorf("storage cannot publish files at another path")
+	}
+	return r.CommitTo(path)
+}
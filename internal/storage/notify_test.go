@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotifyCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	tempDir := filepath.Join(tmpDir, "temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	var committed []string
+	st := NewNotify(NewLocal(tempDir), func(path string) {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be published before it is reported: %v", path, err)
+		}
+		committed = append(committed, path)
+	})
+
+	// Aborted files are not reported
+	aborted, err := st.Create(context.Background(), filepath.Join(tmpDir, "aborted.txt"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	aborted.Abort()
+
+	destPath := filepath.Join(tmpDir, "sub", "file.txt")
+	f, err := st.Create(context.Background(), destPath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if len(committed) != 1 || committed[0] != destPath {
+		t.Errorf("Expected only %s to be reported, got %v", destPath, committed)
+	}
+}
@@ -0,0 +1,30 @@
+// Package storage abstracts where files received by the ingest API are written
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Storage writes ingested files. A file only becomes visible at its path once
+// it is committed, so the watcher never picks up partial content.
+type Storage interface {
+	// Create starts writing a file that is published at path on Commit
+	Create(ctx context.Context, path string) (File, error)
+}
+
+// File is an ingested file being written
+type File interface {
+	io.Writer
+	// Commit makes the file visible at its path
+	Commit() error
+	// Abort discards the file
+	Abort()
+}
+
+// Relocatable is a File that can be published at another path than the one
+// it was created for, e.g. to keep a rejected upload for inspection
+type Relocatable interface {
+	// CommitTo makes the file visible at path instead of its own path
+	CommitTo(path string) error
+}
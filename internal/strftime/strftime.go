@@ -0,0 +1,85 @@
+// Package strftime expands strftime-style date tokens such as %Y/%m/%d, so
+// that paths and URLs can be partitioned by the time a file is delivered.
+package strftime
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// layouts maps the supported conversions to Go time layouts
+var layouts = map[byte]string{
+	'Y': "2006",    // year
+	'y': "06",      // year without century
+	'm': "01",      // month
+	'd': "02",      // day of the month
+	'H': "15",      // hour (24-hour clock)
+	'M': "04",      // minute
+	'S': "05",      // second
+	'b': "Jan",     // abbreviated month name
+	'B': "January", // full month name
+	'a': "Mon",     // abbreviated weekday name
+	'A': "Monday",  // full weekday name
+}
+
+// Format expands the tokens in pattern with t. %j is the day of the year and
+// %% a literal percent sign; other text is kept as it is.
+func Format(pattern string, t time.Time) string {
+	if !strings.Contains(pattern, "%") {
+		return pattern
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch c := pattern[i]; {
+		case c == '%':
+			b.WriteByte('%')
+		case c == 'j':
+			b.WriteString(fmt.Sprintf("%03d", t.YearDay()))
+		case layouts[c] != "":
+			b.WriteString(t.Format(layouts[c]))
+		default:
+			b.WriteString("%" + string(c))
+		}
+	}
+	return b.String()
+}
+
+// Validate checks that every token in pattern is supported
+func Validate(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		if i+1 == len(pattern) {
+			return fmt.Errorf("incomplete date token at the end of %q", pattern)
+		}
+		i++
+		if c := pattern[i]; c != '%' && c != 'j' && layouts[c] == "" {
+			return fmt.Errorf("unsupported date token %%%c in %q", c, pattern)
+		}
+	}
+	return nil
+}
+
+// StaticDir returns the directory of path above its first date token, which
+// exists independently of the time. Paths without tokens are returned as is.
+func StaticDir(path string) string {
+	for i := 0; i < len(path)-1; i++ {
+		if path[i] != '%' {
+			continue
+		}
+		if path[i+1] == '%' {
+			i++
+			continue
+		}
+		return filepath.Dir(Format(path[:i], time.Time{}) + "x")
+	}
+	return path
+}
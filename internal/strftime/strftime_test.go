@@ -0,0 +1,58 @@
+package strftime
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	at := time.Date(2026, 3, 7, 9, 5, 2, 0, time.UTC)
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"/archive/%Y/%m/%d/", "/archive/2026/03/07/"},
+		{"%y%m%d-%H%M%S", "260307-090502"},
+		{"day-%j", "day-066"},
+		{"%a %A %b %B", "Sat Saturday Mar March"},
+		{"100%% done", "100% done"},
+		{"no tokens", "no tokens"},
+		{"trailing %", "trailing %"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.pattern, at); got != tt.expected {
+			t.Errorf("Format(%q) = %q, expected %q", tt.pattern, got, tt.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, pattern := range []string{"/archive/%Y/%m/%d", "%j", "100%%", "plain"} {
+		if err := Validate(pattern); err != nil {
+			t.Errorf("Validate(%q) failed: %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"/archive/%Q", "trailing %"} {
+		if err := Validate(pattern); err == nil {
+			t.Errorf("Expected Validate(%q) to fail", pattern)
+		}
+	}
+}
+
+func TestStaticDir(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/archive/%Y/%m/%d", "/archive"},
+		{"/archive/shadow-%Y%m", "/archive"},
+		{"/archive/100%%/%Y", "/archive/100%"},
+		{"/archive/shadow", "/archive/shadow"},
+	}
+	for _, tt := range tests {
+		if got := StaticDir(filepath.FromSlash(tt.path)); got != filepath.FromSlash(tt.expected) {
+			t.Errorf("StaticDir(%q) = %q, expected %q", tt.path, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package transferid correlates the log lines of one file's journey, from
+// REST receipt or detection to delivery, through an ID assigned per path.
+package transferid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// maxIDs bounds the registry; IDs of files never delivered are pruned
+	maxIDs = 100000
+	// ttl is how long an ID is kept for a file that was not delivered
+	ttl = 24 * time.Hour
+)
+
+// entry is the ID of a path and when it was assigned
+type entry struct {
+	id       string
+	assigned time.Time
+}
+
+var (
+	mu  sync.Mutex
+	ids = make(map[string]entry)
+)
+
+// New returns a random transfer ID
+func New() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Assign sets the transfer ID of path, e.g. the request ID of the REST
+// upload that stored it
+func Assign(path, id string) {
+	if path == "" || id == "" {
+		return
+	}
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := ids[path]; !ok && len(ids) >= maxIDs {
+		prune(now)
+	}
+	ids[path] = entry{id: id, assigned: now}
+}
+
+// Lookup returns the transfer ID of path, or "" if it has none
+func Lookup(path string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return ids[path].id
+}
+
+// For returns the transfer ID of path, assigning a new one if it has none
+func For(path string) string {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if e, ok := ids[path]; ok {
+		return e.id
+	}
+	if len(ids) >= maxIDs {
+		prune(now)
+	}
+	id := New()
+	ids[path] = entry{id: id, assigned: now}
+	return id
+}
+
+// Forget drops the transfer ID of a path whose journey ended, so the next
+// file written to the same path gets a new one
+func Forget(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(ids, path)
+}
+
+// Tag returns the log prefix of a file: its directory, if known, and its
+// transfer ID
+func Tag(directory, path string) string {
+	return Prefix(directory, For(path))
+}
+
+// Prefix returns the log prefix of a directory and transfer ID
+func Prefix(directory, id string) string {
+	if directory == "" {
+		return "[" + id + "]"
+	}
+	return "[" + directory + "] [" + id + "]"
+}
+
+// prune drops expired IDs, and the oldest ones if the registry is still
+// full. Must be called with mu held.
+func prune(now time.Time) {
+	var oldest string
+	for path, e := range ids {
+		if now.Sub(e.assigned) > ttl {
+			delete(ids, path)
+		} else if oldest == "" || e.assigned.Before(ids[oldest].assigned) {
+			oldest = path
+		}
+	}
+	if len(ids) >= maxIDs {
+		delete(ids, oldest)
+	}
+}
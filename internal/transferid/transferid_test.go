@@ -0,0 +1,79 @@
+package transferid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssignLookupForget(t *testing.T) {
+	path := "/watch/assign.txt"
+	defer Forget(path)
+
+	if id := Lookup(path); id != "" {
+		t.Fatalf("Expected no ID before assignment, got %q", id)
+	}
+	Assign(path, "req-1")
+	if id := Lookup(path); id != "req-1" {
+		t.Errorf("Expected assigned ID req-1, got %q", id)
+	}
+	if id := For(path); id != "req-1" {
+		t.Errorf("Expected For to return the assigned ID, got %q", id)
+	}
+
+	Forget(path)
+	if id := Lookup(path); id != "" {
+		t.Errorf("Expected no ID after Forget, got %q", id)
+	}
+}
+
+func TestForAssignsOnce(t *testing.T) {
+	path := "/watch/for.txt"
+	defer Forget(path)
+
+	id := For(path)
+	if len(id) != 16 {
+		t.Fatalf("Expected a 16 character ID, got %q", id)
+	}
+	if again := For(path); again != id {
+		t.Errorf("Expected stable ID %q, got %q", id, again)
+	}
+	if other := For("/watch/other.txt"); other == id {
+		t.Error("Expected different paths to get different IDs")
+	}
+	Forget("/watch/other.txt")
+}
+
+func TestTag(t *testing.T) {
+	path := "/watch/tag.txt"
+	defer Forget(path)
+	Assign(path, "abc")
+
+	if tag := Tag("docs", path); tag != "[docs] [abc]" {
+		t.Errorf("Expected [docs] [abc], got %q", tag)
+	}
+	if tag := Tag("", path); tag != "[abc]" {
+		t.Errorf("Expected [abc] without a directory, got %q", tag)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	mu.Lock()
+	saved := ids
+	ids = map[string]entry{
+		"expired": {id: "1", assigned: time.Now().Add(-2 * ttl)},
+		"old":     {id: "2", assigned: time.Now().Add(-time.Hour)},
+		"new":     {id: "3", assigned: time.Now()},
+	}
+	prune(time.Now())
+	got := ids
+	ids = saved
+	mu.Unlock()
+
+	if _, ok := got["expired"]; ok {
+		t.Error("Expected expired ID to be pruned")
+	}
+	if len(got) != 2 || !strings.Contains(got["new"].id, "3") {
+		t.Errorf("Expected unexpired IDs to be kept below the limit, got %v", got)
+	}
+}
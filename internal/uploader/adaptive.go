@@ -0,0 +1,144 @@
+package uploader
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// concurrencyAdjustments counts changes of the adaptive concurrency limit
+var concurrencyAdjustments = metrics.NewCounterVec("xferd_concurrency_adjustments_total",
+	"Changes of the adaptive upload concurrency limit, by direction: increase or decrease",
+	"directory", "direction")
+
+// adaptiveLimit caps a dispatcher's concurrent uploads with AIMD: the limit
+// grows by one after a full window of uncongested responses and is halved
+// when the destination answers 429/503 or slower than the target latency
+type adaptiveLimit struct {
+	name     string
+	min, max int
+	target   time.Duration // 0 if latency is not a congestion signal
+
+	mu           sync.Mutex
+	limit        int
+	inFlight     int
+	successes    int           // uncongested responses since the last change
+	lastDecrease time.Time     // responses to requests sent before it are not counted
+	changed      chan struct{} // closed when a slot frees up or the limit grows
+}
+
+// newAdaptiveLimit creates a limit between cfg's min_workers and maxWorkers,
+// starting at the minimum
+func newAdaptiveLimit(name string, cfg config.AdaptiveConcurrencyConfig, maxWorkers int) *adaptiveLimit {
+	minWorkers := min(cfg.GetMinWorkers(), maxWorkers)
+	return &adaptiveLimit{
+		name:    name,
+		min:     minWorkers,
+		max:     maxWorkers,
+		target:  cfg.GetTargetLatency(),
+		limit:   minWorkers,
+		changed: make(chan struct{}),
+	}
+}
+
+// SetAdaptiveConcurrency lets the dispatcher tune its concurrent uploads
+// between cfg.MinWorkers and its worker count. Must be called before Start.
+func (d *Dispatcher) SetAdaptiveConcurrency(cfg config.AdaptiveConcurrencyConfig) {
+	if cfg.Enabled {
+		d.uploader.adaptive = newAdaptiveLimit(d.name, cfg, d.maxWorkers)
+	}
+}
+
+// ConcurrencyLimit returns the current adaptive concurrency limit, 0 if
+// adaptive concurrency is disabled
+func (d *Dispatcher) ConcurrencyLimit() int {
+	a := d.uploader.adaptive
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
+
+// acquire waits until fewer uploads than the limit are in progress. Returns
+// false if ctx is cancelled first.
+func (a *adaptiveLimit) acquire(ctx context.Context) bool {
+	if a == nil {
+		return true
+	}
+	for {
+		a.mu.Lock()
+		if a.inFlight < a.limit {
+			a.inFlight++
+			a.mu.Unlock()
+			return true
+		}
+		changed := a.changed
+		a.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release frees a slot taken by acquire
+func (a *adaptiveLimit) release() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	a.notify()
+}
+
+// notify wakes up workers waiting in acquire. Must be called with mu held.
+func (a *adaptiveLimit) notify() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// observe adjusts the limit to the response to a request sent at sent
+func (a *adaptiveLimit) observe(sent time.Time, status int) {
+	if a == nil {
+		return
+	}
+	now := time.Now()
+	congested := status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable ||
+		(a.target > 0 && now.Sub(sent) > a.target)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Requests sent before the last decrease were sent at the old limit
+	if sent.Before(a.lastDecrease) {
+		return
+	}
+	if congested {
+		a.successes = 0
+		a.lastDecrease = now
+		if a.limit > a.min {
+			a.limit = max(a.limit/2, a.min)
+			concurrencyAdjustments.With(a.name, "decrease").Inc()
+			log.Printf("Adaptive concurrency for %s: destination congested (status %d, %v), limit lowered to %d",
+				a.name, status, now.Sub(sent).Round(time.Millisecond), a.limit)
+		}
+		return
+	}
+	a.successes++
+	if a.successes >= a.limit && a.limit < a.max {
+		a.successes = 0
+		a.limit++
+		concurrencyAdjustments.With(a.name, "increase").Inc()
+		a.notify()
+	}
+}
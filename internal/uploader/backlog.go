@@ -0,0 +1,136 @@
+package uploader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Backlog tracks delivery of the files that were already waiting in a watch
+// directory at startup, so operators can see how long recovery will take
+type Backlog struct {
+	name       string
+	started    time.Time
+	mu         sync.Mutex
+	pending    map[string]int64 // path -> size, not yet delivered
+	totalFiles int
+	totalBytes int64
+	doneBytes  int64
+	finished   time.Time // zero until every backlog file was delivered
+}
+
+// BacklogProgress is a snapshot of startup backlog delivery
+type BacklogProgress struct {
+	TotalFiles     int    `json:"total_files"`
+	TotalBytes     int64  `json:"total_bytes"`
+	RemainingFiles int    `json:"remaining_files"`
+	RemainingBytes int64  `json:"remaining_bytes"`
+	ElapsedSeconds int64  `json:"elapsed_seconds"`
+	ETASeconds     *int64 `json:"eta_seconds,omitempty"` // unknown until some of the backlog was delivered
+	Complete       bool   `json:"complete"`
+}
+
+// NewBacklog starts tracking the given files (path -> size) for directory name
+func NewBacklog(name string, files map[string]int64) *Backlog {
+	b := &Backlog{
+		name:       name,
+		started:    time.Now(),
+		pending:    files,
+		totalFiles: len(files),
+	}
+	for _, size := range files {
+		b.totalBytes += size
+	}
+	if b.totalFiles == 0 {
+		b.finished = b.started
+	}
+	return b
+}
+
+// Done marks a file as no longer pending, whether it was delivered, skipped
+// or deleted. Files that were not part of the backlog are ignored.
+func (b *Backlog) Done(path string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size, ok := b.pending[path]
+	if !ok {
+		return
+	}
+	delete(b.pending, path)
+	b.doneBytes += size
+	if len(b.pending) == 0 {
+		b.finished = time.Now()
+	}
+}
+
+// Progress returns the current state of the backlog
+func (b *Backlog) Progress() BacklogProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.progress(time.Now())
+}
+
+// progress computes a snapshot at now. Callers must hold b.mu.
+func (b *Backlog) progress(now time.Time) BacklogProgress {
+	p := BacklogProgress{
+		TotalFiles:     b.totalFiles,
+		TotalBytes:     b.totalBytes,
+		RemainingFiles: len(b.pending),
+		RemainingBytes: b.totalBytes - b.doneBytes,
+		Complete:       !b.finished.IsZero(),
+	}
+	end := now
+	if p.Complete {
+		end = b.finished
+	}
+	elapsed := end.Sub(b.started)
+	p.ElapsedSeconds = int64(elapsed.Seconds())
+
+	// Estimate from throughput so far, by bytes or by files for empty files
+	var done, remaining float64
+	if b.totalBytes > 0 {
+		done, remaining = float64(b.doneBytes), float64(p.RemainingBytes)
+	} else {
+		done, remaining = float64(b.totalFiles-p.RemainingFiles), float64(p.RemainingFiles)
+	}
+	if done > 0 {
+		eta := int64(elapsed.Seconds() * remaining / done)
+		p.ETASeconds = &eta
+	}
+	return p
+}
+
+// LogProgress logs the backlog state every interval until it is delivered or ctx is cancelled
+func (b *Backlog) LogProgress(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p := b.Progress()
+		if p.Complete {
+			if p.TotalFiles > 0 {
+				log.Printf("Backlog [%s]: all %d files (%d bytes) delivered in %v",
+					b.name, p.TotalFiles, p.TotalBytes, time.Duration(p.ElapsedSeconds)*time.Second)
+			}
+			return
+		}
+
+		eta := "unknown"
+		if p.ETASeconds != nil {
+			eta = (time.Duration(*p.ETASeconds) * time.Second).String()
+		}
+		log.Printf("Backlog [%s]: %d of %d files remaining (%d of %d bytes), ETA %s",
+			b.name, p.RemainingFiles, p.TotalFiles, p.RemainingBytes, p.TotalBytes, eta)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package uploader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/history"
+	"github.com/muzy/xferd/internal/journal"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/shadow"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+var (
+	batches = metrics.NewCounterVec("xferd_batches_total",
+		"Batch archives uploaded, by outcome: uploaded or failed",
+		"directory", "outcome")
+	batchedFiles = metrics.NewCounterVec("xferd_batched_files_total",
+		"Files delivered inside batch archives",
+		"directory")
+)
+
+// batchEntry is a file offered to the batcher
+type batchEntry struct {
+	event fileEvent
+	size  int64
+}
+
+// batcher collects enqueued files into batches and hands each full batch to
+// the work queue as a single event, whose archive is written by the worker
+type batcher struct {
+	cfg  config.BatchConfig
+	dir  string // where archives are written
+	name string // directory name, prefixes archive names
+	ext  string
+	seq  atomic.Int64
+	adds chan batchEntry
+	done chan struct{} // closed once run returns
+}
+
+// SetBatch uploads files in archives of up to max_files files or max_bytes
+// instead of one by one. Archives are written below tempDir; leftovers of
+// an earlier run are removed, as their files are still in the watch path.
+// Must be called before Start.
+func (d *Dispatcher) SetBatch(cfg config.BatchConfig, tempDir string) error {
+	if !cfg.Enabled {
+		d.batcher = nil
+		return nil
+	}
+	dir := filepath.Join(tempDir, "batch", d.name)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear batch directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create batch directory: %w", err)
+	}
+	d.batcher = &batcher{
+		cfg:  cfg,
+		dir:  dir,
+		name: d.name,
+		ext:  "." + cfg.GetFormat(),
+		adds: make(chan batchEntry),
+		done: make(chan struct{}),
+	}
+	return nil
+}
+
+// add offers a file to the current batch. Files that do not fit into a batch
+// on their own are not batched; the caller uploads them individually.
+func (b *batcher) add(ctx context.Context, event fileEvent) (bool, error) {
+	info, err := os.Stat(event.path)
+	if err != nil || info.Size() > b.cfg.GetMaxBytes() {
+		return false, nil
+	}
+	select {
+	case b.adds <- batchEntry{event: event, size: info.Size()}:
+		return true, nil
+	case <-ctx.Done():
+		return false, fmt.Errorf("dispatcher stopped")
+	}
+}
+
+// run collects files until a batch is full or its window has passed and
+// passes each batch to flush. Files still collecting when ctx is done stay
+// in the watch path and are batched again after a restart.
+func (b *batcher) run(ctx context.Context, flush func(members []fileEvent)) {
+	defer close(b.done)
+
+	var members []fileEvent
+	var size int64
+	var window <-chan time.Time
+	send := func() {
+		flush(members)
+		members, size, window = nil, 0, nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-window:
+			send()
+		case entry := <-b.adds:
+			if len(members) > 0 && size+entry.size > b.cfg.GetMaxBytes() {
+				send()
+			}
+			if len(members) == 0 {
+				window = time.After(b.cfg.GetWindow())
+			}
+			members = append(members, entry.event)
+			size += entry.size
+			if len(members) >= b.cfg.GetMaxFiles() || size >= b.cfg.GetMaxBytes() {
+				send()
+			}
+		}
+	}
+}
+
+// archivePath returns a new, unique path for an archive
+func (b *batcher) archivePath() string {
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	return filepath.Join(b.dir, b.name+"-"+stamp+"-"+strconv.FormatInt(b.seq.Add(1), 10)+b.ext)
+}
+
+// enqueueBatch queues a batch for upload, waiting for queue space: its files
+// were accepted by Enqueue already
+func (d *Dispatcher) enqueueBatch(members []fileEvent) {
+	archive := d.batcher.archivePath()
+	select {
+	case d.queueFor(archive, config.PriorityNormal) <- fileEvent{path: archive, batch: members}:
+		d.logf(archive, "Enqueued batch of %d files for upload: %s", len(members), archive)
+	case <-d.ctx.Done():
+	}
+}
+
+// batchMember is a file being delivered in a batch
+type batchMember struct {
+	event     fileEvent
+	info      os.FileInfo
+	checksum  string
+	shadow    *shadow.Copy
+	shadowErr error
+}
+
+// processBatch writes the archive of a batch, uploads it and finishes each
+// of its files as if it had been uploaded on its own. Failures are recorded
+// for each file, so it always returns nil.
+func (d *Dispatcher) processBatch(ctx context.Context, id int, event fileEvent) error {
+	started := time.Now().UTC()
+	archive := filepath.Base(event.path)
+
+	members := make([]*batchMember, 0, len(event.batch))
+	for _, m := range event.batch {
+		info, err := os.Stat(m.path)
+		if os.IsNotExist(err) {
+			d.handleRemoved(id, m.path)
+			continue
+		}
+		if err != nil {
+			d.logf(m.path, "Worker %d: failed to stat %s: %v", id, m.path, err)
+			continue
+		}
+		if ok, _ := d.scanFile(ctx, id, m.path, info); !ok {
+			continue
+		}
+		member := &batchMember{event: m, info: info}
+		if !m.processedDueToTimeout {
+			member.shadow, member.shadowErr = d.shadowManager.Begin(m.path)
+		}
+		members = append(members, member)
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	defer os.Remove(event.path)
+
+	fail := func(err error) error {
+		batches.With(d.name, "failed").Inc()
+		for _, m := range members {
+			if m.shadow != nil {
+				m.shadow.Abort()
+			}
+			d.logf(m.event.path, "Worker %d: upload failed for %s in %s: %v", id, m.event.path, archive, err)
+			d.journal.Record(journal.Event{Directory: d.name, Path: m.event.path, Outcome: journal.OutcomeFailed, Error: err.Error(), Archive: archive})
+			d.transfers.Record(history.Entry{Started: started, Directory: d.name, Path: m.event.path, Status: history.StatusFailed, Error: err.Error()})
+			d.recordStage(m.event.path, filestate.StageFailed, err)
+		}
+		return nil
+	}
+	if err := d.batcher.write(event.path, d.uploader.relPath, members); err != nil {
+		return fail(fmt.Errorf("failed to write batch archive: %w", err))
+	}
+
+	opts := uploadOptions{}
+	if d.hook != nil {
+		opts.receipt = &receipt{}
+	}
+	d.logf(event.path, "Worker %d: uploading %d files in %s", id, len(members), archive)
+	destination, err := d.uploader.upload(ctx, event.path, opts)
+	if err != nil {
+		return fail(err)
+	}
+	batches.With(d.name, "uploaded").Inc()
+	if opts.receipt != nil {
+		opts.receipt.destination = cmp.Or(opts.receipt.destination, destination)
+	}
+
+	for _, m := range members {
+		filePath, size := m.event.path, m.info.Size()
+		d.logf(filePath, "Worker %d: upload completed: %s (in %s)", id, filePath, archive)
+		d.recordStage(filePath, filestate.StageUploaded, nil)
+		d.sla.done(filePath)
+		batchedFiles.With(d.name).Inc()
+		d.journal.Record(journal.Event{Directory: d.name, Path: filePath, Size: size, Checksum: m.checksum,
+			Outcome: journal.OutcomeDelivered, Destination: destination, Archive: archive})
+		d.transfers.Record(history.Entry{Started: started, Directory: d.name, Path: filePath, Size: size,
+			Checksum: m.checksum, Status: history.StatusDelivered, Destination: destination})
+		if d.mirror != nil {
+			d.mirror.Forward(filePath)
+		}
+		if d.onSuccessfulUpload != nil {
+			d.onSuccessfulUpload(filePath)
+		}
+
+		hookErr := d.runHook(ctx, id, filePath, size, m.checksum, opts.receipt)
+		if hookErr != nil {
+			d.logf(filePath, "Worker %d: post-upload hook failed for %s: %v", id, filePath, hookErr)
+		}
+		d.finish(id, m.event, m.info, m.shadow, m.shadowErr, hookErr)
+		d.forget(filePath)
+		transferid.Forget(filePath)
+	}
+	return nil
+}
+
+// write writes the archive of a batch to path, naming each file by name.
+// Files are hashed and shadow-copied as they are read.
+func (b *batcher) write(path string, name func(string) string, members []*batchMember) (err error) {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640) // #nosec G304 -- inside the batch directory
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	var add func(m *batchMember, src io.Reader) error
+	var closeArchive func() error
+	switch b.cfg.GetFormat() {
+	case config.BatchFormatZip:
+		zw := zip.NewWriter(out)
+		add = func(m *batchMember, src io.Reader) error {
+			header, err := zip.FileInfoHeader(m.info)
+			if err != nil {
+				return err
+			}
+			header.Name, header.Method = name(m.event.path), zip.Deflate
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = copyContent(w, src)
+			return err
+		}
+		closeArchive = zw.Close
+	default:
+		var w io.Writer = out
+		var gz *gzip.Writer
+		if b.cfg.GetFormat() == config.BatchFormatTarGz {
+			gz = gzip.NewWriter(out)
+			w = gz
+		}
+		tw := tar.NewWriter(w)
+		add = func(m *batchMember, src io.Reader) error {
+			header, err := tar.FileInfoHeader(m.info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = name(m.event.path)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = io.CopyN(tw, src, header.Size)
+			return err
+		}
+		closeArchive = func() error {
+			if err := tw.Close(); err != nil || gz == nil {
+				return err
+			}
+			return gz.Close()
+		}
+	}
+
+	for _, m := range members {
+		if err := b.addFile(m, add); err != nil {
+			return fmt.Errorf("failed to add %s: %w", m.event.path, err)
+		}
+	}
+	return closeArchive()
+}
+
+// addFile adds a member to an archive with add, hashing and shadow-copying
+// its content on the way
+func (b *batcher) addFile(m *batchMember, add func(*batchMember, io.Reader) error) error {
+	f, err := os.Open(m.event.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	var w io.Writer = hash
+	if m.shadow != nil {
+		w = io.MultiWriter(hash, m.shadow)
+	}
+	if err := add(m, io.TeeReader(f, w)); err != nil {
+		return err
+	}
+	m.checksum = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
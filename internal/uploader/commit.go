@@ -0,0 +1,83 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// uploadIDHeader carries the destination-provided upload ID
+const uploadIDHeader = "X-Upload-ID"
+
+// uploadResponse is a successful response from the destination
+type uploadResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// uploadID returns the upload ID from the X-Upload-ID header or, failing
+// that, from the configured field of a JSON response body
+func (u *Uploader) uploadID(resp *uploadResponse) string {
+	if id := resp.header.Get(uploadIDHeader); id != "" {
+		return id
+	}
+
+	var fields map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(resp.body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return ""
+	}
+
+	switch id := fields[u.config.Commit.GetIDField()].(type) {
+	case string:
+		return id
+	case json.Number:
+		return id.String()
+	}
+	return ""
+}
+
+// commitURL returns the commit endpoint for an upload ID. By default it is
+// below the URL the file was uploaded to.
+func (u *Uploader) commitURL(uploadURL, id string) string {
+	tmpl := u.config.Commit.URL
+	if tmpl == "" {
+		tmpl = strings.TrimSuffix(uploadURL, "/") + "/{upload_id}/commit"
+	}
+	return strings.ReplaceAll(tmpl, "{upload_id}", url.PathEscape(id))
+}
+
+// commit completes a two-phase upload. Destinations with commit enabled accept
+// the file under an upload ID and only keep it once the ID is committed.
+func (u *Uploader) commit(ctx context.Context, filePath, uploadURL string, resp *uploadResponse) error {
+	if !u.config.Commit.Enabled {
+		return nil
+	}
+
+	id := u.uploadID(resp)
+	if id == "" {
+		return fmt.Errorf("destination did not return an upload ID to commit")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.commitURL(uploadURL, id), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create commit request: %w", err)
+	}
+	req.Header.Set(uploadIDHeader, id)
+	if err := u.addAuth(req); err != nil {
+		return err
+	}
+
+	if _, err := u.doWithRetry(req, filePath, 0, nil); err != nil {
+		return fmt.Errorf("failed to commit upload %s: %w", id, err)
+	}
+
+	u.logf(filePath, "Upload committed: %s (upload ID: %s)", filePath, id)
+	return nil
+}
@@ -0,0 +1,116 @@
+package uploader
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+var compressionBytes = metrics.NewCounterVec("xferd_compression_bytes_total",
+	"Bytes of files gzipped during upload, by size: original (read from the file) or compressed (sent)",
+	"directory", "size")
+
+// compression is how one upload is gzipped. The zero value sends it as is.
+type compression struct {
+	mode      string // config.CompressionHeader or config.CompressionSuffix, "" if uncompressed
+	level     int
+	directory string
+}
+
+// compression returns how a file is compressed: not at all if compression
+// is disabled, the file is smaller than min_size_bytes or its type is excluded
+func (u *Uploader) compression(filePath string, file *os.File, size int64) compression {
+	c := u.config.Compression
+	if !c.Enabled || size < c.GetMinSize() {
+		return compression{}
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(filePath))
+	if mimeType == "" {
+		header := make([]byte, 512)
+		n, _ := file.ReadAt(header, 0)
+		mimeType = http.DetectContentType(header[:n])
+	}
+	if c.Excludes(mimeType) {
+		return compression{}
+	}
+	return compression{mode: c.GetMode(), level: c.GetLevel(), directory: u.directory}
+}
+
+// name returns the name a file is sent under, with .gz appended in suffix mode
+func (c compression) name(filePath string) string {
+	if c.mode == config.CompressionSuffix {
+		return filePath + ".gz"
+	}
+	return filePath
+}
+
+// body wraps the writer of the request body, gzipping it in header mode
+func (c compression) body(w io.Writer) (io.Writer, func() error) {
+	if c.mode != config.CompressionHeader {
+		return w, func() error { return nil }
+	}
+	return c.gzip(w)
+}
+
+// content wraps the writer of the file content, gzipping it in suffix mode
+func (c compression) content(w io.Writer) (io.Writer, func() error) {
+	if c.mode != config.CompressionSuffix {
+		return w, func() error { return nil }
+	}
+	return c.gzip(w)
+}
+
+// reader gzips src through a pipe if the upload is compressed. Raw bodies
+// are both the request body and the file content, so either mode applies.
+func (c compression) reader(src io.Reader) io.Reader {
+	if c.mode == "" {
+		return src
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, closeGzip := c.gzip(pw)
+		_, err := copyContent(w, src)
+		if err == nil {
+			err = closeGzip()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// gzip returns a gzip writer on w and the function that flushes it, which
+// counts the bytes before and after compression
+func (c compression) gzip(w io.Writer) (io.Writer, func() error) {
+	compressed := &countingWriter{w: w}
+	gz, err := gzip.NewWriterLevel(compressed, c.level)
+	if err != nil {
+		gz = gzip.NewWriter(compressed)
+	}
+	original := &countingWriter{w: gz}
+	return original, func() error {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		compressionBytes.With(c.directory, "original").Add(uint64(original.n))     // #nosec G115 -- byte counts are never negative
+		compressionBytes.With(c.directory, "compressed").Add(uint64(compressed.n)) // #nosec G115 -- byte counts are never negative
+		return nil
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,212 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// newDialContext returns a dial function honoring the configured IP family.
+// Dual-stack destinations are dialed with Happy Eyeballs (RFC 6555) fallback.
+func newDialContext(cfg config.ConnectionConfig) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: cfg.GetFallbackDelay(),
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		switch cfg.IPFamily {
+		case "ipv4":
+			network = "tcp4"
+		case "ipv6":
+			network = "tcp6"
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// pooledTransport is an HTTP transport that can be retired: once it is, and
+// the requests still using it finished, its connections are closed
+type pooledTransport struct {
+	*http.Transport
+	mu      sync.Mutex
+	active  int // requests whose response body is not closed yet
+	retired bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (p *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.mu.Lock()
+	p.active++
+	p.mu.Unlock()
+
+	resp, err := p.Transport.RoundTrip(req)
+	if err != nil {
+		p.done()
+		return nil, err
+	}
+	resp.Body = &pooledBody{ReadCloser: resp.Body, done: sync.OnceFunc(p.done)}
+	return resp, nil
+}
+
+// done ends a request, closing the connections of a retired transport
+// once it was the last
+func (p *pooledTransport) done() {
+	p.mu.Lock()
+	p.active--
+	drained := p.retired && p.active == 0
+	p.mu.Unlock()
+	if drained {
+		p.CloseIdleConnections()
+	}
+}
+
+// retire closes the idle connections, and the others once their requests
+// finished
+func (p *pooledTransport) retire() {
+	p.mu.Lock()
+	p.retired = true
+	p.mu.Unlock()
+	p.CloseIdleConnections()
+}
+
+// pooledBody ends its request when it is closed
+type pooledBody struct {
+	io.ReadCloser
+	done func()
+}
+
+// Close implements io.Closer
+func (b *pooledBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// needsConnectionMaintenance reports whether warm-up or DNS refresh is configured
+func (u *Uploader) needsConnectionMaintenance() bool {
+	conn := u.config.Connection
+	return conn.WarmUp || conn.DNSRefreshSeconds > 0
+}
+
+// maintainConnections warms up the destination connection and periodically
+// re-resolves its hostname until the context is cancelled
+func (u *Uploader) maintainConnections(ctx context.Context) {
+	conn := u.config.Connection
+
+	// Record the initial resolution so later refreshes can detect changes
+	if _, err := u.RefreshDNS(ctx); err != nil {
+		log.Printf("DNS resolution failed for %s: %v", u.config.URL, err)
+	}
+
+	if conn.WarmUp {
+		if err := u.WarmUp(ctx); err != nil {
+			log.Printf("Connection warm-up failed for %s: %v", u.config.URL, err)
+		}
+	}
+
+	if conn.DNSRefreshSeconds <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(conn.GetDNSRefreshInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := u.RefreshDNS(ctx)
+			if err != nil {
+				log.Printf("DNS refresh failed for %s: %v", u.config.URL, err)
+			}
+			if changed && conn.WarmUp {
+				if err := u.WarmUp(ctx); err != nil {
+					log.Printf("Connection warm-up failed for %s: %v", u.config.URL, err)
+				}
+			}
+		}
+	}
+}
+
+// WarmUp establishes a connection to the destination ahead of the first upload
+// by sending a HEAD request. Any HTTP response counts as success since only
+// the underlying connection matters.
+func (u *Uploader) WarmUp(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.staticURL(), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create warm-up request: %w", err)
+	}
+	if err := u.addAuth(req); err != nil {
+		return err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("warm-up request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	log.Printf("Connection warmed up: %s (status: %d)", u.config.URL, resp.StatusCode)
+	return nil
+}
+
+// RefreshDNS re-resolves the hosts of the destination and its failover URLs.
+// When their addresses changed, the transport is replaced so that the next
+// upload dials the new targets, and the old one's connections are closed
+// once the uploads still using them finished. Returns true if any address
+// set changed since the previous resolution.
+func (u *Uploader) RefreshDNS(ctx context.Context) (bool, error) {
+	var hosts []string
+	for _, d := range u.destinations {
+		parsed, err := url.Parse(staticPrefix(d.rawURL))
+		if err != nil {
+			return false, fmt.Errorf("invalid outbound url: %w", err)
+		}
+		if host := parsed.Hostname(); host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	changed := false
+	var errs []error
+	for _, host := range hosts {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to resolve %s: %w", host, err))
+			continue
+		}
+		slices.Sort(addrs)
+
+		u.dnsMu.Lock()
+		previous, seen := u.resolvedAddrs[host]
+		u.resolvedAddrs[host] = addrs
+		u.dnsMu.Unlock()
+
+		if seen && !slices.Equal(previous, addrs) {
+			log.Printf("DNS change detected for %s: %v -> %v, replacing connections", host, previous, addrs)
+			changed = true
+		}
+	}
+
+	if changed {
+		u.dnsMu.Lock()
+		old := u.transport.Swap(&pooledTransport{Transport: u.transport.Load().Clone()})
+		u.dnsMu.Unlock()
+		old.retire()
+	}
+	return changed, errors.Join(errs...)
+}
@@ -0,0 +1,56 @@
+package uploader
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/magic"
+)
+
+// contentRoutes sends files to an alternate URL based on their detected type
+type contentRoutes struct {
+	rules []config.ContentRule
+	urls  []*template.Template // parsed URL per rule, nil for ignore rules
+}
+
+// SetContentRules routes files whose detected type matches a route rule to
+// the rule's URL. Ignore rules are applied by the watcher. Must be called before Start.
+func (d *Dispatcher) SetContentRules(rules []config.ContentRule) error {
+	routes := &contentRoutes{rules: rules, urls: make([]*template.Template, len(rules))}
+	hasRoute := false
+	for i := range rules {
+		if rules[i].GetAction() != config.ContentActionRoute {
+			continue
+		}
+		tmpl, err := parseURLTemplate(rules[i].URL)
+		if err != nil {
+			return fmt.Errorf("content_rules[%d]: invalid url template: %w", i, err)
+		}
+		routes.urls[i] = tmpl
+		hasRoute = true
+	}
+	if hasRoute {
+		d.routes = routes
+	}
+	return nil
+}
+
+// match returns the URL template for a file and its detected type, or nil
+// if the file goes to the default destination
+func (r *contentRoutes) match(filePath string) (*template.Template, string, error) {
+	detected, err := magic.DetectFile(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	rule := config.MatchContentRule(r.rules, detected)
+	if rule == nil {
+		return nil, detected, nil
+	}
+	for i := range r.rules {
+		if &r.rules[i] == rule {
+			return r.urls[i], detected, nil
+		}
+	}
+	return nil, detected, nil
+}
@@ -0,0 +1,151 @@
+package uploader
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+var duplicatesSkipped = metrics.NewCounterVec("xferd_duplicates_skipped_total",
+	"Files not uploaded because identical content was uploaded within the dedup window",
+	"directory")
+
+// dedupEntry records when content with a given hash was last uploaded
+type dedupEntry struct {
+	Hash     string    `json:"hash"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// dedupCache is a bounded LRU of recently uploaded content hashes used to
+// skip byte-identical files that producers drop again
+type dedupCache struct {
+	window     time.Duration
+	maxEntries int
+	stateFile  string
+	mu         sync.Mutex
+	order      *list.List               // *dedupEntry, most recently uploaded first
+	entries    map[string]*list.Element // hash -> element in order
+}
+
+// newDedupCache creates a dedup cache, loading the state file if configured
+func newDedupCache(cfg config.DedupConfig) *dedupCache {
+	c := &dedupCache{
+		window:     cfg.GetWindow(),
+		maxEntries: cfg.GetMaxEntries(),
+		stateFile:  cfg.StateFile,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+
+	if c.stateFile != "" {
+		data, err := os.ReadFile(c.stateFile)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			log.Printf("Failed to read dedup state %s: %v", c.stateFile, err)
+		default:
+			var saved []dedupEntry // oldest first
+			if err := json.Unmarshal(data, &saved); err != nil {
+				log.Printf("Failed to parse dedup state %s: %v", c.stateFile, err)
+			}
+			now := time.Now()
+			for _, entry := range saved {
+				if now.Sub(entry.Uploaded) < c.window {
+					c.add(entry)
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+// seen reports whether content with this hash was uploaded within the window
+func (c *dedupCache) seen(hash string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[hash]
+	if !ok {
+		return false
+	}
+	if now.Sub(entryOf(elem).Uploaded) >= c.window {
+		c.order.Remove(elem)
+		delete(c.entries, hash)
+		return false
+	}
+	return true
+}
+
+// record remembers an upload and persists the cache if configured
+func (c *dedupCache) record(hash string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(dedupEntry{Hash: hash, Uploaded: now})
+
+	if c.stateFile == "" {
+		return
+	}
+	if err := c.save(); err != nil {
+		log.Printf("Failed to save dedup state %s: %v", c.stateFile, err)
+	}
+}
+
+// add inserts or refreshes an entry, evicting the least recently uploaded
+// hashes beyond maxEntries. Callers must hold c.mu (or own c exclusively).
+func (c *dedupCache) add(entry dedupEntry) {
+	if elem, ok := c.entries[entry.Hash]; ok {
+		elem.Value = &entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.Hash] = c.order.PushFront(&entry)
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, entryOf(oldest).Hash)
+	}
+}
+
+// entryOf returns the entry stored in a list element
+func entryOf(elem *list.Element) *dedupEntry {
+	entry, _ := elem.Value.(*dedupEntry)
+	return entry
+}
+
+// save atomically writes the cache to the state file, oldest entry first.
+// Callers must hold c.mu.
+func (c *dedupCache) save() error {
+	saved := make([]dedupEntry, 0, c.order.Len())
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		saved = append(saved, *entryOf(elem))
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.stateFile), ".xferd-dedup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.stateFile)
+}
@@ -0,0 +1,36 @@
+package uploader
+
+import (
+	"context"
+	"time"
+)
+
+// drainPollInterval is how often Drain checks whether the dispatcher is idle
+const drainPollInterval = 100 * time.Millisecond
+
+// Idle reports whether no file is queued or being uploaded
+func (d *Dispatcher) Idle() bool {
+	// Queued entries are counted until a worker takes them and active covers
+	// them from then on, so pending must be read first
+	d.pendingMu.Lock()
+	queued := len(d.pending)
+	d.pendingMu.Unlock()
+	return queued == 0 && d.active.Load() == 0
+}
+
+// Drain waits until every queued file was delivered or the context is done.
+// The caller stops enqueueing first. Returns false if files were still
+// queued or uploading when the context ended.
+func (d *Dispatcher) Drain(ctx context.Context) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for !d.Idle() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package uploader
+
+import (
+	"fmt"
+	"io"
+)
+
+// errEncryption is returned for uploads when the outbound encryption keys
+// could not be loaded: files are never sent unencrypted instead
+func (u *Uploader) errEncryption() error {
+	if u.encryptErr != nil {
+		return fmt.Errorf("invalid outbound encryption configuration: %w", u.encryptErr)
+	}
+	return nil
+}
+
+// sendName returns the name a file is sent under, with the suffixes of its
+// compression and encryption appended
+func (u *Uploader) sendName(filePath string, c compression) string {
+	return u.encrypter.Name(c.name(filePath))
+}
+
+// content wraps w, the writer of the file content, so that the content is
+// gzipped in suffix mode and then encrypted. The returned function writes the
+// end of both.
+func (u *Uploader) content(w io.Writer, c compression) (io.Writer, func() error, error) {
+	if err := u.errEncryption(); err != nil {
+		return nil, nil, err
+	}
+	encrypted, err := u.encrypter.Writer(w)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start encryption: %w", err)
+	}
+	out, closeGzip := c.content(encrypted)
+	return out, func() error {
+		if err := closeGzip(); err != nil {
+			return err
+		}
+		return encrypted.Close()
+	}, nil
+}
+
+// reader returns src as it is sent in a raw body: gzipped and encrypted
+// through a pipe if configured
+func (u *Uploader) reader(src io.Reader, c compression) io.Reader {
+	if u.encrypter == nil && u.encryptErr == nil {
+		return c.reader(src)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, finish, err := u.content(pw, c)
+		if err == nil {
+			_, err = copyContent(w, src)
+		}
+		if err == nil {
+			err = finish()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,113 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+var failoverUploads = metrics.NewCounterVec("xferd_failover_uploads_total",
+	"Files delivered to a secondary destination because the destinations before it were unavailable",
+	"directory", "destination")
+
+// errRejected marks 4xx responses, which say nothing about destination health
+var errRejected = errors.New("client error (no retry)")
+
+// destination is an outbound URL with a circuit breaker. After a number of
+// consecutive failed uploads it is skipped for a cooldown period.
+type destination struct {
+	rawURL    string
+	url       *template.Template // nil for the primary, which uses the outbound url
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// available reports whether the destination's circuit is closed
+func (d *destination) available(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !now.Before(d.openUntil)
+}
+
+// record updates the circuit with the result of an upload. Returns true if
+// the circuit opened.
+func (d *destination) record(err error, threshold int, cooldown time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.failures = 0
+		return false
+	}
+	d.failures++
+	if d.failures < threshold {
+		return false
+	}
+	d.failures = 0
+	d.openUntil = time.Now().Add(cooldown)
+	return true
+}
+
+// newDestinations returns the primary destination followed by the parsed
+// failover URLs. Invalid templates are logged and left out.
+func newDestinations(primary string, failover []string) []*destination {
+	destinations := []*destination{{rawURL: primary}}
+	for _, rawURL := range failover {
+		tmpl, err := parseURLTemplate(rawURL)
+		if err != nil {
+			log.Printf("Outbound failover URL template error for %s: %v", rawURL, err)
+			continue
+		}
+		destinations = append(destinations, &destination{rawURL: rawURL, url: tmpl})
+	}
+	return destinations
+}
+
+// uploadFailover tries the available destinations in order and returns the
+// one that accepted the file. When none is available the primary is probed.
+func (u *Uploader) uploadFailover(ctx context.Context, filePath string, opts uploadOptions) (string, error) {
+	now := time.Now()
+	candidates := make([]*destination, 0, len(u.destinations))
+	for i, dest := range u.destinations {
+		if i == 0 && opts.skipPrimary {
+			continue
+		}
+		if dest.available(now) {
+			candidates = append(candidates, dest)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = u.destinations[:1]
+	}
+
+	failover := u.config.Failover
+	var err error
+	for i, dest := range candidates {
+		if i > 0 {
+			u.logf(filePath, "Upload of %s failed, failing over to %s: %v", filePath, dest.rawURL, err)
+			if r, ok := opts.tee.(interface{ Reset() }); ok {
+				r.Reset()
+			}
+		}
+		opts.url = dest.url
+		err = u.send(ctx, filePath, opts)
+		if ctx.Err() != nil || errors.Is(err, errRejected) {
+			return "", err
+		}
+		if dest.record(err, failover.GetFailureThreshold(), failover.GetCooldown()) {
+			log.Printf("Destination %s unavailable, skipping it for %v", dest.rawURL, failover.GetCooldown())
+		}
+		if err == nil {
+			if dest != u.destinations[0] {
+				failoverUploads.With(u.directory, dest.rawURL).Inc()
+			}
+			return dest.rawURL, nil
+		}
+	}
+	return "", err
+}
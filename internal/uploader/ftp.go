@@ -0,0 +1,331 @@
+package uploader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sendFTP uploads a file to an FTP or FTPS server, retrying transient
+// failures with exponential backoff. The URL path names the remote file, or
+// the remote directory if it ends with a slash. Missing directories are
+// created, and the file is stored under a .partial name and renamed into
+// place once complete.
+func (u *Uploader) sendFTP(ctx context.Context, filePath string, opts uploadOptions) error {
+	if u.tlsErr != nil {
+		return fmt.Errorf("invalid outbound TLS configuration: %w", u.tlsErr)
+	}
+	if err := u.errEncryption(); err != nil {
+		return err
+	}
+	name := u.sendName(filePath, compression{})
+	rawURL, err := u.fileURL(name)
+	if opts.url != nil {
+		rawURL, err = u.expandURL(opts.url, name)
+	}
+	if err != nil {
+		return err
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse outbound url: %w", err)
+	}
+	remote := target.Path
+	if remote == "" || strings.HasSuffix(remote, "/") {
+		remote = path.Join("/", remote, filepath.Base(name))
+	}
+
+	maxRetries := 3
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = u.storeFTP(ctx, target, remote, filePath, opts)
+		if err == nil {
+			u.logf(filePath, "Upload successful: %s -> %s%s", filePath, target.Host, remote)
+			opts.receipt.set((&url.URL{Scheme: target.Scheme, Host: target.Host, Path: remote}).String(), nil)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload cancelled: %w", ctx.Err())
+		}
+		// 5xx replies are permanent: the server refused the file
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return fmt.Errorf("%w: %d - %s", errRejected, reply.Code, reply.Msg)
+		}
+		if attempt >= maxRetries {
+			return fmt.Errorf("upload failed after %d attempts: %w", maxRetries+1, err)
+		}
+
+		u.logf(filePath, "Upload retry %d/%d for %s: %v", attempt+1, maxRetries, filePath, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("upload cancelled: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if r, ok := opts.tee.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+	}
+}
+
+// storeFTP performs one upload over a new control connection
+func (u *Uploader) storeFTP(ctx context.Context, target *url.URL, remote, filePath string, opts uploadOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if info, statErr := file.Stat(); statErr == nil {
+		if timeout := u.config.Connection.GetRequestTimeout(info.Size()); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.client.Timeout)
+		defer cancel()
+	}
+
+	c, err := u.dialFTP(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	username, password := "anonymous", "anonymous"
+	if u.config.Auth.Type == "basic" {
+		username, password = u.config.Auth.Username, u.config.Auth.Password
+	}
+	code, _, err := c.cmd(0, "USER %s", username)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		code, _, err = c.cmd(0, "PASS %s", password)
+		if err != nil {
+			return err
+		}
+	}
+	if code != 230 && code != 202 {
+		return &textproto.Error{Code: code, Msg: "login failed"}
+	}
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return err
+	}
+	c.mkdirAll(path.Dir(remote))
+
+	partial := remote + ".partial"
+	data, err := c.dataConn(ctx)
+	if err != nil {
+		return err
+	}
+	if _, _, err := c.cmd(1, "STOR %s", partial); err != nil {
+		data.Close()
+		return err
+	}
+	// Complete the handshake even if the file is empty and nothing is written
+	if secured, ok := data.(*tls.Conn); ok {
+		if err := secured.HandshakeContext(ctx); err != nil {
+			data.Close()
+			return fmt.Errorf("TLS handshake on data connection failed: %w", err)
+		}
+	}
+	content, finish, err := u.content(data, compression{})
+	if err != nil {
+		data.Close()
+		return err
+	}
+	if _, err := copyContent(content, opts.source(file)); err != nil {
+		data.Close()
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+	if err := finish(); err != nil {
+		data.Close()
+		return fmt.Errorf("failed to encode file content: %w", err)
+	}
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("failed to close data connection: %w", err)
+	}
+	if _, _, err := c.text.ReadResponse(2); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd(3, "RNFR %s", partial); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd(2, "RNTO %s", remote); err != nil {
+		return err
+	}
+	_, _, _ = c.cmd(2, "QUIT")
+	return nil
+}
+
+// ftpConn is a control connection to an FTP server
+type ftpConn struct {
+	conn      net.Conn
+	text      *textproto.Conn
+	host      string
+	dialer    *net.Dialer
+	tlsConfig *tls.Config // set when data connections are encrypted
+	stop      func() bool // stops closing the connection on context cancellation
+}
+
+// dialFTP connects and, for FTPS, secures the control connection. The
+// connection is closed if ctx is done, unblocking any pending command.
+func (u *Uploader) dialFTP(ctx context.Context, target *url.URL) (*ftpConn, error) {
+	implicit := target.Scheme == "ftps"
+	host := target.Host
+	if target.Port() == "" {
+		port := "21"
+		if implicit {
+			port = "990"
+		}
+		host = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var tlsConfig *tls.Config
+	if implicit || u.config.FTP.ExplicitTLS {
+		tlsConfig = u.transport.Load().TLSClientConfig.Clone()
+		tlsConfig.ServerName = target.Hostname()
+		// Servers commonly require data connections to resume the control session
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if implicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c := &ftpConn{conn: conn, text: textproto.NewConn(conn), host: target.Hostname(), dialer: dialer}
+	c.stop = context.AfterFunc(ctx, func() { conn.Close() })
+	if _, _, err := c.text.ReadResponse(2); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	if u.config.FTP.ExplicitTLS {
+		if _, _, err := c.cmd(2, "AUTH TLS"); err != nil {
+			c.close()
+			return nil, err
+		}
+		secured := tls.Client(conn, tlsConfig)
+		c.conn, c.text = secured, textproto.NewConn(secured)
+	}
+	if tlsConfig != nil {
+		for _, command := range []string{"PBSZ 0", "PROT P"} {
+			if _, _, err := c.cmd(2, "%s", command); err != nil {
+				c.close()
+				return nil, err
+			}
+		}
+		c.tlsConfig = tlsConfig
+	}
+	return c, nil
+}
+
+// cmd sends a command and reads the reply, which must start with expectCode
+// unless it is 0
+func (c *ftpConn) cmd(expectCode int, format string, args ...any) (int, string, error) {
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expectCode)
+}
+
+// mkdirAll creates dir and its parents. Errors are ignored since existing
+// directories are reported as errors too; a missing directory fails the upload.
+func (c *ftpConn) mkdirAll(dir string) {
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+		_, _, _ = c.cmd(0, "MKD %s", current)
+	}
+}
+
+// dataConn opens a passive mode data connection, trying EPSV before PASV.
+// The address the server reports for PASV is ignored in favor of the control
+// connection's host, which also works behind NAT.
+func (c *ftpConn) dataConn(ctx context.Context) (net.Conn, error) {
+	port, err := c.epsv()
+	if err != nil {
+		port, err = c.pasv()
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, err := c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data connection: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if c.tlsConfig != nil {
+		return tls.Client(conn, c.tlsConfig), nil
+	}
+	return conn, nil
+}
+
+// epsv requests an extended passive mode port: 229 ... (|||port|)
+func (c *ftpConn) epsv() (int, error) {
+	_, msg, err := c.cmd(229, "EPSV")
+	if err != nil {
+		return 0, err
+	}
+	start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+	if start < 0 || end < start+4 {
+		return 0, fmt.Errorf("invalid EPSV reply: %s", msg)
+	}
+	return strconv.Atoi(msg[start+4 : end])
+}
+
+// pasv requests a passive mode port: 227 ... (h1,h2,h3,h4,p1,p2)
+func (c *ftpConn) pasv() (int, error) {
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return 0, err
+	}
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("invalid PASV reply: %s", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("invalid PASV reply: %s", msg)
+	}
+	high, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	low, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("invalid PASV reply: %s", msg)
+	}
+	return high<<8 | low, nil
+}
+
+// close closes the control connection
+func (c *ftpConn) close() {
+	c.stop()
+	c.conn.Close()
+}
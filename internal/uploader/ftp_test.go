@@ -0,0 +1,262 @@
+package uploader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// fakeFTPServer is a minimal passive mode FTP server keeping stored files in memory
+type fakeFTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	files    map[string]string
+	dirs     map[string]bool
+	commands []string
+	storErr  string      // reply to STOR instead of accepting the file, if set
+	tls      *tls.Config // implicit TLS on control and data connections, if set
+}
+
+func newFakeFTPServer(t *testing.T, tlsConfig *tls.Config) *fakeFTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s := &fakeFTPServer{listener: listener, files: map[string]string{}, dirs: map[string]bool{}, tls: tlsConfig}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeFTPServer) url(path string) string {
+	if s.tls != nil {
+		return "ftps://" + s.listener.Addr().String() + path
+	}
+	return "ftp://" + s.listener.Addr().String() + path
+}
+
+func (s *fakeFTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) { fmt.Fprintf(conn, format+"\r\n", args...) }
+	reply("220 fake ftp")
+
+	var data net.Listener
+	var renameFrom string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		switch command {
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			if arg != "secret" {
+				reply("530 login incorrect")
+				continue
+			}
+			reply("230 logged in")
+		case "TYPE", "PBSZ", "PROT":
+			reply("200 ok")
+		case "MKD":
+			s.mu.Lock()
+			s.dirs[arg] = true
+			s.mu.Unlock()
+			reply("257 created")
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := data.Addr().(*net.TCPAddr).Port
+			if s.tls != nil {
+				data = tls.NewListener(data, s.tls)
+			}
+			reply("229 Entering Extended Passive Mode (|||%d|)", port)
+		case "STOR":
+			if s.storErr != "" {
+				data.Close()
+				reply("%s", s.storErr)
+				continue
+			}
+			reply("150 ok to send")
+			dc, err := data.Accept()
+			data.Close()
+			if err != nil {
+				return
+			}
+			content, _ := io.ReadAll(dc)
+			dc.Close()
+			s.mu.Lock()
+			s.files[arg] = string(content)
+			s.mu.Unlock()
+			reply("226 transfer complete")
+		case "RNFR":
+			renameFrom = arg
+			reply("350 ready")
+		case "RNTO":
+			s.mu.Lock()
+			s.files[arg] = s.files[renameFrom]
+			delete(s.files, renameFrom)
+			s.mu.Unlock()
+			reply("250 renamed")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestUploadFTP(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "2026", "report.csv")
+	if err := os.MkdirAll(filepath.Dir(testFile), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(testFile, []byte("a,b,c"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	server := newFakeFTPServer(t, nil)
+
+	uploader := NewUploader(config.OutboundConfig{
+		Type: config.OutboundFTP,
+		URL:  server.url("/incoming/{{.Directory}}/"),
+		Auth: config.AuthConfig{Type: "basic", Username: "partner", Password: "secret"},
+	})
+	uploader.directory = "reports"
+	uploader.watchPath = tmpDir
+	tee := &strings.Builder{}
+	if _, err := uploader.upload(context.Background(), testFile, uploadOptions{tee: tee}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if got := server.files["/incoming/reports/report.csv"]; got != "a,b,c" {
+		t.Errorf("Expected file stored in /incoming/reports, got %v", server.files)
+	}
+	if len(server.files) != 1 {
+		t.Errorf("Expected the .partial file to be renamed, got %v", server.files)
+	}
+	if !server.dirs["/incoming"] || !server.dirs["/incoming/reports"] {
+		t.Errorf("Expected remote directories to be created, got %v", server.dirs)
+	}
+	if tee.String() != "a,b,c" {
+		t.Errorf("Expected tee to receive the content, got %q", tee.String())
+	}
+}
+
+func TestUploadFTPEncrypted(t *testing.T) {
+	entity, keyFile := pgpRecipient(t)
+	testFile := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(testFile, []byte("a,b,c"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	server := newFakeFTPServer(t, nil)
+
+	uploader := NewUploader(config.OutboundConfig{
+		Type:       config.OutboundFTP,
+		URL:        server.url("/incoming/"),
+		Auth:       config.AuthConfig{Type: "basic", Username: "partner", Password: "secret"},
+		Encryption: config.EncryptionConfig{Enabled: true, Type: config.EncryptionPGP, KeyFile: keyFile},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	stored, ok := server.files["/incoming/report.csv.pgp"]
+	if !ok {
+		t.Fatalf("Expected report.csv.pgp to be stored, got %v", server.files)
+	}
+	if got := pgpDecrypt(t, entity, []byte(stored)); string(got) != "a,b,c" {
+		t.Errorf("Expected the file content after decryption, got %q", got)
+	}
+}
+
+func TestUploadFTPRejected(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	server := newFakeFTPServer(t, nil)
+	server.storErr = "553 file name not allowed"
+
+	// Permanent replies are not retried
+	uploader := NewUploader(config.OutboundConfig{
+		Type: config.OutboundFTP,
+		URL:  server.url("/incoming/"),
+		Auth: config.AuthConfig{Type: "basic", Username: "partner", Password: "secret"},
+	})
+	err := uploader.Upload(context.Background(), testFile)
+	if err == nil || !strings.Contains(err.Error(), "553") {
+		t.Fatalf("Expected 553 rejection, got %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	stors := 0
+	for _, command := range server.commands {
+		if command == "STOR" {
+			stors++
+		}
+	}
+	if stors != 1 {
+		t.Errorf("Expected a single STOR attempt, got %d", stors)
+	}
+}
+
+func TestUploadFTPS(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	// Borrow the test certificate of an httptest TLS server
+	https := httptest.NewTLSServer(nil)
+	defer https.Close()
+	server := newFakeFTPServer(t, &tls.Config{Certificates: https.TLS.Certificates})
+
+	uploader := NewUploader(config.OutboundConfig{
+		Type: config.OutboundFTP,
+		URL:  server.url("/secure/"),
+		Auth: config.AuthConfig{Type: "basic", Username: "partner", Password: "secret"},
+		TLS:  config.OutboundTLSConfig{InsecureSkipVerify: true},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if got := server.files["/secure/test.txt"]; got != "content" {
+		t.Errorf("Expected file stored over FTPS, got %v", server.files)
+	}
+}
@@ -0,0 +1,219 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/quarantine"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+var postUploadHooks = metrics.NewCounterVec("xferd_post_upload_hooks_total",
+	"Post-upload hook runs for delivered files, by outcome: succeeded, or failed once retries were exhausted",
+	"directory", "outcome")
+
+// maxHookBody is how much of the destination's response body is passed to a hook
+const maxHookBody = 64 << 10
+
+// receipt records where a file was delivered and what the destination
+// answered. It is filled in by the upload for the post-upload hook.
+type receipt struct {
+	destination string
+	response    *uploadResponse // nil for FTP and local_dir delivery
+}
+
+// set records a delivery, if a receipt was requested
+func (r *receipt) set(destination string, resp *uploadResponse) {
+	if r != nil {
+		r.destination, r.response = destination, resp
+	}
+}
+
+// Delivery describes a delivered file to a post-upload hook
+type Delivery struct {
+	Directory       string            `json:"directory"`
+	Path            string            `json:"path"`
+	RelativePath    string            `json:"relative_path"`
+	Size            int64             `json:"size"`
+	Checksum        string            `json:"checksum"` // hex SHA-256 of the content
+	Destination     string            `json:"destination"`
+	Status          int               `json:"status,omitempty"` // HTTP status of the upload
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    string            `json:"response_body,omitempty"` // up to 64 KiB, omitted unless UTF-8
+	TransferID      string            `json:"transfer_id,omitempty"`
+	Time            time.Time         `json:"time"`
+}
+
+// postUploadHook runs a command and/or webhook for every delivered file
+type postUploadHook struct {
+	cfg    config.PostUploadConfig
+	client *http.Client
+}
+
+// SetPostUpload runs the configured hook after every successful delivery,
+// before the source is deleted. Files whose hook keeps failing are moved to
+// quarantinePath, if set. Must be called before Start.
+func (d *Dispatcher) SetPostUpload(cfg config.PostUploadConfig, quarantinePath string) {
+	if !cfg.Enabled {
+		d.hook = nil
+		return
+	}
+	d.hook = &postUploadHook{cfg: cfg, client: &http.Client{}}
+	d.quarantinePath = quarantinePath
+}
+
+// runHook runs the post-upload hook for a delivered file, retrying failures
+// with exponential backoff. It returns the last error once retries are
+// exhausted.
+func (d *Dispatcher) runHook(ctx context.Context, id int, filePath string, size int64, checksum string, rec *receipt) error {
+	if d.hook == nil {
+		return nil
+	}
+	if checksum == "" {
+		var err error
+		if checksum, err = hashFile(filePath); err != nil {
+			return fmt.Errorf("failed to hash file: %w", err)
+		}
+	}
+	delivery := Delivery{
+		Directory:    d.name,
+		Path:         filePath,
+		RelativePath: d.uploader.relPath(filePath),
+		Size:         size,
+		Checksum:     checksum,
+		Destination:  rec.destination,
+		TransferID:   transferid.Lookup(filePath),
+		Time:         time.Now().UTC(),
+	}
+	if resp := rec.response; resp != nil {
+		delivery.Status = resp.status
+		delivery.ResponseHeaders = make(map[string]string, len(resp.header))
+		for name := range resp.header {
+			delivery.ResponseHeaders[name] = resp.header.Get(name)
+		}
+		if body := resp.body; len(body) <= maxHookBody && utf8.Valid(body) {
+			delivery.ResponseBody = string(body)
+		}
+	}
+	payload, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	maxRetries := d.hook.cfg.GetMaxRetries()
+	for attempt := 0; ; attempt++ {
+		if err = d.hook.run(ctx, delivery, payload); err == nil {
+			postUploadHooks.With(d.name, "succeeded").Inc()
+			return nil
+		}
+		if attempt == maxRetries || ctx.Err() != nil {
+			break
+		}
+		d.logf(filePath, "Worker %d: post-upload hook for %s failed, retry %d/%d: %v", id, filePath, attempt+1, maxRetries, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	postUploadHooks.With(d.name, "failed").Inc()
+	return err
+}
+
+// hookFailed records that the post-upload hook of a delivered file failed
+// for good. The file is not kept in the watch path, where it would be
+// delivered again: it is moved to the quarantine path with a hook_failed
+// sidecar, so the hook can be rerun from it, and otherwise left to
+// after_upload. It reports whether the file was quarantined.
+func (d *Dispatcher) hookFailed(id int, filePath string, hookErr error) bool {
+	d.recordStage(filePath, filestate.StageHookFailed, hookErr)
+	if d.quarantinePath == "" {
+		return false
+	}
+	detail := "post-upload hook failed: " + hookErr.Error()
+	target, err := quarantine.Move(d.name, d.quarantinePath, d.uploader.watchPath, filePath, quarantine.ReasonHookFailed, detail)
+	if err != nil {
+		d.logf(filePath, "Worker %d: failed to quarantine %s after its post-upload hook failed: %v", id, filePath, err)
+		return false
+	}
+	d.logf(filePath, "Worker %d: quarantined delivered file %s, %s, moved to %s", id, filePath, detail, target)
+	d.recordStage(filePath, filestate.StageQuarantined, errors.New(detail))
+	return true
+}
+
+// run runs the command, then calls the webhook, each given payload
+func (h *postUploadHook) run(ctx context.Context, delivery Delivery, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.GetTimeout())
+	defer cancel()
+	if len(h.cfg.Command) > 0 {
+		if err := h.runCommand(ctx, delivery, payload); err != nil {
+			return err
+		}
+	}
+	if h.cfg.URL != "" {
+		return h.callWebhook(ctx, payload)
+	}
+	return nil
+}
+
+// runCommand runs the command with the delivery as JSON on its standard input
+// and its main fields in XFERD_* environment variables
+func (h *postUploadHook) runCommand(ctx context.Context, delivery Delivery, payload []byte) error {
+	cmd := exec.CommandContext(ctx, h.cfg.Command[0], h.cfg.Command[1:]...) // #nosec G204 -- command from the configuration
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"XFERD_DIRECTORY="+delivery.Directory,
+		"XFERD_FILE="+delivery.Path,
+		"XFERD_RELATIVE_PATH="+delivery.RelativePath,
+		"XFERD_SIZE="+strconv.FormatInt(delivery.Size, 10),
+		"XFERD_CHECKSUM="+delivery.Checksum,
+		"XFERD_DESTINATION="+delivery.Destination,
+		"XFERD_STATUS="+strconv.Itoa(delivery.Status),
+		"XFERD_TRANSFER_ID="+delivery.TransferID,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if out := strings.TrimSpace(string(output[max(0, len(output)-512):])); out != "" {
+			return fmt.Errorf("command failed: %w: %s", err, out)
+		}
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
+
+// callWebhook POSTs the delivery to the webhook, which must answer 2xx
+func (h *postUploadHook) callWebhook(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// idempotencyKey derives a key that is the same for every attempt to deliver
+// the same file, so destinations can discard duplicates caused by retries
+// after ambiguous network failures. knownHash is used instead of re-reading
+// the file if its content digest was already computed.
+func idempotencyKey(cfg config.IdempotencyConfig, filePath string, info os.FileInfo, knownHash string) (string, error) {
+	if cfg.GetSource() == config.IdempotencySourceContent {
+		if knownHash != "" {
+			return knownHash, nil
+		}
+		return hashFile(filePath)
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%d\x00%d", filePath, info.Size(), info.ModTime().UnixNano())
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// setIdempotencyKey adds the idempotency key, if any, to an upload request
+func (u *Uploader) setIdempotencyKey(req *http.Request, key string) {
+	if key != "" {
+		req.Header.Set(u.config.IdempotencyKey.GetHeader(), key)
+	}
+}
@@ -0,0 +1,71 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/muzy/xferd/internal/strftime"
+)
+
+// sendLocal delivers a file into the local_dir target directory, with its
+// date tokens expanded, keeping its path below the watch directory. The file
+// is hard-linked, or copied where that is not possible (e.g. across
+// filesystems or while a copy is teed), to a hidden .partial name and renamed
+// into place, so the target only ever holds complete files. The dispatcher
+// removes the source afterwards.
+func (u *Uploader) sendLocal(ctx context.Context, filePath string, opts uploadOptions) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("delivery cancelled: %w", err)
+	}
+
+	dir := strftime.Format(u.config.Path, time.Now().UTC())
+	target := filepath.Join(dir, filepath.FromSlash(u.relPath(filePath)))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+	temp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".partial")
+	_ = os.Remove(temp) // left over from an interrupted delivery
+
+	if !opts.link || opts.tee != nil || os.Link(filePath, temp) != nil {
+		if err := copyLocal(filePath, temp, opts); err != nil {
+			_ = os.Remove(temp)
+			return err
+		}
+	}
+	if err := os.Rename(temp, target); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	u.logf(filePath, "Delivery successful: %s -> %s", filePath, target)
+	opts.receipt.set(target, nil)
+	return nil
+}
+
+// copyLocal copies a file to dst and syncs it to disk
+func copyLocal(filePath, dst string, opts uploadOptions) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	out, err := os.Create(dst) // #nosec G304 -- inside the configured target directory
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := copyContent(out, opts.source(src)); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/pkg/client"
+)
+
+var mirrorResults = metrics.NewCounterVec("xferd_mirror_files_total",
+	"Delivered files forwarded to the mirror, by outcome: mirrored, failed or dropped (mirror queue full or spool error)",
+	"directory", "outcome")
+
+// spoolPrefix names the per-file directories in the mirror spool
+const spoolPrefix = "m-"
+
+// mirrorItem is a spooled copy of a delivered file
+type mirrorItem struct {
+	dir string // spool directory holding the copy, removed once mirrored
+	rel string // path relative to the watch directory
+}
+
+// Mirror forwards copies of delivered files to the upload endpoint of a
+// secondary xferd. Files are hard-linked (or copied) into a spool directory
+// before the source is deleted and uploaded from a separate queue, so the
+// mirror never delays primary delivery. It is best effort: files that cannot
+// be spooled, do not fit the queue or fail to upload are logged and dropped.
+type Mirror struct {
+	client    *client.Client
+	name      string // local directory name, used in logs and metrics
+	directory string // directory name on the secondary
+	watchPath string
+	spoolPath string
+	queue     chan mirrorItem
+}
+
+// NewMirror creates a mirror for the directory name watching watchPath
+func NewMirror(name, watchPath, tempDir string, cfg config.MirrorConfig) (*Mirror, error) {
+	tlsConfig, err := NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror TLS configuration: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	opts := []client.Option{client.WithHTTPClient(&http.Client{Transport: transport, Timeout: 5 * time.Minute})}
+	switch cfg.Auth.Type {
+	case "basic":
+		opts = append(opts, client.WithBasicAuth(cfg.Auth.Username, cfg.Auth.Password))
+	case "bearer":
+		opts = append(opts, client.WithBearerToken(cfg.Auth.Token))
+	}
+	c, err := client.New(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mirror{
+		client:    c,
+		name:      name,
+		directory: cfg.GetDirectory(name),
+		watchPath: watchPath,
+		spoolPath: cfg.GetSpoolPath(tempDir, name),
+		queue:     make(chan mirrorItem, cfg.GetQueueSize()),
+	}
+	if err := os.MkdirAll(m.spoolPath, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create mirror spool %s: %w", m.spoolPath, err)
+	}
+	return m, nil
+}
+
+// Forward spools a copy of a delivered file and queues it for mirroring.
+// It must be called before the source file is deleted.
+func (m *Mirror) Forward(filePath string) {
+	rel, err := filepath.Rel(m.watchPath, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(filePath)
+	}
+
+	dir, err := os.MkdirTemp(m.spoolPath, spoolPrefix)
+	if err != nil {
+		m.drop(filePath, fmt.Errorf("failed to create spool directory: %w", err))
+		return
+	}
+	if err := linkOrCopy(filePath, filepath.Join(dir, rel)); err != nil {
+		os.RemoveAll(dir)
+		m.drop(filePath, err)
+		return
+	}
+
+	select {
+	case m.queue <- mirrorItem{dir: dir, rel: rel}:
+	default:
+		os.RemoveAll(dir)
+		m.drop(filePath, fmt.Errorf("mirror queue full"))
+	}
+}
+
+// drop records a file that will not be mirrored
+func (m *Mirror) drop(filePath string, err error) {
+	log.Printf("Mirror [%s]: not mirroring %s: %v", m.name, filePath, err)
+	mirrorResults.With(m.name, "dropped").Inc()
+}
+
+// run uploads queued copies until ctx is cancelled. Copies spooled before a
+// restart are queued again first.
+func (m *Mirror) run(ctx context.Context) {
+	m.requeueSpooled()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-m.queue:
+			m.send(ctx, item)
+		}
+	}
+}
+
+// send uploads one spooled copy and removes it, whether or not it succeeded
+func (m *Mirror) send(ctx context.Context, item mirrorItem) {
+	destination := m.directory
+	if sub := filepath.ToSlash(filepath.Dir(item.rel)); sub != "." {
+		destination = path.Join(destination, sub)
+	}
+
+	_, err := m.client.UploadFile(ctx, destination, filepath.Join(item.dir, item.rel))
+	if ctx.Err() != nil {
+		// Shutting down: keep the copy for the next start
+		return
+	}
+	os.RemoveAll(item.dir)
+
+	if err != nil {
+		log.Printf("Mirror [%s]: failed to mirror %s: %v", m.name, item.rel, err)
+		mirrorResults.With(m.name, "failed").Inc()
+		return
+	}
+	mirrorResults.With(m.name, "mirrored").Inc()
+}
+
+// requeueSpooled queues copies left in the spool by a previous run
+func (m *Mirror) requeueSpooled() {
+	entries, err := os.ReadDir(m.spoolPath)
+	if err != nil {
+		log.Printf("Mirror [%s]: failed to read spool %s: %v", m.name, m.spoolPath, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), spoolPrefix) {
+			continue
+		}
+		dir := filepath.Join(m.spoolPath, entry.Name())
+		var rel string
+		_ = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				rel, _ = filepath.Rel(dir, p)
+				return filepath.SkipAll
+			}
+			return nil
+		})
+
+		if rel == "" {
+			os.RemoveAll(dir)
+			continue
+		}
+		select {
+		case m.queue <- mirrorItem{dir: dir, rel: rel}:
+		default:
+			os.RemoveAll(dir)
+			m.drop(rel, fmt.Errorf("mirror queue full"))
+		}
+	}
+}
+
+// linkOrCopy hard-links src to dst, falling back to a copy across filesystems
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src) // #nosec G304 -- src is a watched file
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) // #nosec G304 -- dst is inside the spool directory
+	if err != nil {
+		return fmt.Errorf("failed to create spool copy: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return out.Close()
+}
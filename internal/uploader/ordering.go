@@ -0,0 +1,47 @@
+package uploader
+
+import (
+	"hash/fnv"
+	"path/filepath"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// maxOrderedRetryBackoff caps the wait between retries of a failed ordered delivery
+const maxOrderedRetryBackoff = time.Minute
+
+// SetOrdering enables ordered delivery: files with the same ordering key are
+// uploaded one at a time in the order they were enqueued. Each worker gets its
+// own queue and a key always maps to the same worker, so the directory key uses
+// a single worker. Must be called before Start.
+func (d *Dispatcher) SetOrdering(orderingKey, watchPath string) {
+	switch orderingKey {
+	case config.OrderingKeySubdirectory:
+		d.orderKey = func(path string) string {
+			rel, err := filepath.Rel(watchPath, filepath.Dir(path))
+			if err != nil {
+				return filepath.Dir(path)
+			}
+			return rel
+		}
+	default:
+		d.orderKey = func(string) string { return "" }
+		d.maxWorkers = min(d.maxWorkers, 1)
+	}
+
+	// Split the queue capacity across the per-worker queues
+	n := max(1, d.maxWorkers)
+	size := max(1, (d.queueSize+n-1)/n)
+	d.queues = make([]chan fileEvent, n)
+	for i := range d.queues {
+		d.queues[i] = make(chan fileEvent, size)
+	}
+}
+
+// orderedQueue returns the per-worker queue for a file's ordering key
+func (d *Dispatcher) orderedQueue(path string) chan fileEvent {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(d.orderKey(path)))
+	return d.queues[h.Sum32()%uint32(len(d.queues))] // #nosec G115 -- number of workers
+}
@@ -0,0 +1,149 @@
+package uploader
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/storage"
+	"github.com/muzy/xferd/internal/transferid"
+)
+
+var passthroughResults = metrics.NewCounterVec("xferd_passthrough_files_total",
+	"Files received in passthrough mode, by outcome: delivered (streamed to the destination) or spilled (written to the watch directory after the upload failed)",
+	"directory", "outcome")
+
+// errUploadAborted cancels a passthrough upload whose ingest was rejected
+var errUploadAborted = errors.New("ingest aborted")
+
+// Passthrough is ingest storage that streams files straight to the outbound
+// destination while they are received. A copy is kept in memory, and staged
+// on disk once it outgrows the buffer, so that a file whose upload fails can
+// be spilled to the watch directory and delivered by the regular pipeline.
+type Passthrough struct {
+	uploader    *Uploader
+	name        string
+	spill       storage.Storage
+	bufferBytes int64
+}
+
+// NewPassthrough creates passthrough storage delivering to the dispatcher's
+// destination and spilling failed uploads to spill
+func NewPassthrough(d *Dispatcher, spill storage.Storage, bufferBytes int64) *Passthrough {
+	return &Passthrough{uploader: d.uploader, name: d.name, spill: spill, bufferBytes: bufferBytes}
+}
+
+// Create starts streaming a file that would be written to path
+func (p *Passthrough) Create(ctx context.Context, path string) (storage.File, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	f := &passthroughFile{p: p, ctx: ctx, cancel: cancel, path: path, pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		err := p.uploader.sendStream(ctx, path, pr, -1, uploadOptions{})
+		// Unblock writes if the upload ended early
+		pr.CloseWithError(cmp.Or(err, io.ErrClosedPipe))
+		f.done <- err
+	}()
+	return f, nil
+}
+
+// passthroughFile is a file being streamed to the destination
+type passthroughFile struct {
+	p         *Passthrough
+	ctx       context.Context
+	cancel    context.CancelFunc
+	path      string
+	pw        *io.PipeWriter
+	done      chan error   // upload result
+	uploadErr error        // set once the upload stopped accepting data
+	mem       bytes.Buffer // copy of the content until it is staged
+	staged    storage.File // copy on disk, nil while the content fits mem
+}
+
+// Write keeps a copy of p and forwards it to the upload. A failed upload
+// does not fail the write; the file is spilled on Commit instead.
+func (f *passthroughFile) Write(p []byte) (int, error) {
+	if err := f.keep(p); err != nil {
+		return 0, err
+	}
+	if f.uploadErr == nil {
+		if _, err := f.pw.Write(p); err != nil {
+			f.uploadErr = err
+		}
+	}
+	return len(p), nil
+}
+
+// keep appends p to the copy, moving it to disk once it exceeds the buffer
+func (f *passthroughFile) keep(p []byte) error {
+	if f.staged == nil && int64(f.mem.Len()+len(p)) > f.p.bufferBytes {
+		if err := f.stage(); err != nil {
+			return err
+		}
+	}
+	if f.staged != nil {
+		_, err := f.staged.Write(p)
+		return err
+	}
+	f.mem.Write(p)
+	return nil
+}
+
+// stage moves the in-memory copy to spill storage
+func (f *passthroughFile) stage() error {
+	staged, err := f.p.spill.Create(f.ctx, f.path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.mem.WriteTo(staged); err != nil {
+		staged.Abort()
+		return err
+	}
+	f.staged = staged
+	return nil
+}
+
+// Commit completes the upload, or spills the copy if the upload failed
+func (f *passthroughFile) Commit() error {
+	defer f.cancel()
+	f.pw.Close()
+	err := <-f.done
+
+	if err == nil {
+		if f.staged != nil {
+			f.staged.Abort()
+		}
+		log.Printf("%s Passthrough: delivered %s", transferid.Tag(f.p.name, f.path), f.path)
+		transferid.Forget(f.path)
+		passthroughResults.With(f.p.name, "delivered").Inc()
+		return nil
+	}
+
+	log.Printf("%s Passthrough: upload of %s failed, spilling to watch directory: %v", transferid.Tag(f.p.name, f.path), f.path, err)
+	if f.staged == nil {
+		if err := f.stage(); err != nil {
+			return fmt.Errorf("failed to spill file: %w", err)
+		}
+	}
+	if err := f.staged.Commit(); err != nil {
+		return fmt.Errorf("failed to spill file: %w", err)
+	}
+	passthroughResults.With(f.p.name, "spilled").Inc()
+	return nil
+}
+
+// Abort cancels the upload so the destination never receives a complete file
+func (f *passthroughFile) Abort() {
+	f.cancel()
+	f.pw.CloseWithError(errUploadAborted)
+	<-f.done
+	if f.staged != nil {
+		f.staged.Abort()
+	}
+}
@@ -0,0 +1,55 @@
+package uploader
+
+import "github.com/muzy/xferd/internal/config"
+
+// EnablePriorities adds high and low priority queues next to the normal queue,
+// each holding up to the configured queue size. Must be called before Start.
+func (d *Dispatcher) EnablePriorities() {
+	d.high = make(chan fileEvent, d.queueSize)
+	d.low = make(chan fileEvent, d.queueSize)
+}
+
+// next waits for the next queued file. High priority files are taken first and
+// low priority files only when no other file is waiting. Returns false once the
+// dispatcher is stopped.
+func (d *Dispatcher) next(queue chan fileEvent) (fileEvent, bool) {
+	if d.ctx.Err() != nil {
+		return fileEvent{}, false
+	}
+
+	if d.high != nil {
+		for _, q := range []chan fileEvent{d.high, queue} {
+			select {
+			case event, ok := <-q:
+				return event, ok
+			default:
+			}
+		}
+	}
+
+	// Nil priority queues never become ready
+	select {
+	case <-d.ctx.Done():
+		return fileEvent{}, false
+	case event, ok := <-d.high:
+		return event, ok
+	case event, ok := <-queue:
+		return event, ok
+	case event, ok := <-d.low:
+		return event, ok
+	}
+}
+
+// queueFor returns the queue a file is enqueued on
+func (d *Dispatcher) queueFor(path, priority string) chan fileEvent {
+	switch {
+	case priority == config.PriorityHigh && d.high != nil:
+		return d.high
+	case priority == config.PriorityLow && d.low != nil:
+		return d.low
+	case len(d.queues) > 1:
+		return d.orderedQueue(path)
+	default:
+		return d.queues[0]
+	}
+}
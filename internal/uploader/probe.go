@@ -0,0 +1,192 @@
+package uploader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// ProbeDestination sends a HEAD request to the primary HTTP destination and
+// returns an error if it cannot be reached or answers with a server error.
+// Any other status counts as reachable, since endpoints often reject HEAD or
+// unauthenticated requests.
+func (d *Dispatcher) ProbeDestination(ctx context.Context) error {
+	return d.uploader.probe(ctx)
+}
+
+// probe sends a HEAD request to the outbound URL, or to the part before its
+// first placeholder for templated URLs
+func (u *Uploader) probe(ctx context.Context) error {
+	if u.tlsErr != nil {
+		return fmt.Errorf("invalid outbound tls configuration: %w", u.tlsErr)
+	}
+	target := probeURL(u.config.URL)
+	if target == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create probe request: %w", err)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("destination %s unreachable: %w", target, err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("destination %s answered %d", target, resp.StatusCode)
+	}
+	return nil
+}
+
+// probeURL returns the URL to probe for an outbound URL template, or "" if
+// the host itself is templated
+func probeURL(rawURL string) string {
+	prefix, _, _ := strings.Cut(rawURL, "{")
+	parsed, err := url.Parse(prefix)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	return prefix
+}
+
+// ProbeExchange is an HTTP request sent by ProbeUpload, including redirects
+// and retries
+type ProbeExchange struct {
+	Method string
+	URL    string
+	Status int    // 0 if no response was received
+	TLS    string // negotiated version and certificate expiry, "" for plain HTTP
+	Err    error
+}
+
+// ProbeResult is the outcome of a test upload
+type ProbeResult struct {
+	File      string // name of the uploaded test file
+	Size      int64
+	Exchanges []ProbeExchange
+	Err       error  // nil if the destination accepted the file
+	Hint      string // likely cause of a failure
+}
+
+// probeTransport records the exchanges of a probe
+type probeTransport struct {
+	next   http.RoundTripper
+	mu     sync.Mutex
+	result *ProbeResult
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	ex := ProbeExchange{Method: req.Method, URL: req.URL.Redacted(), Err: err}
+	if resp != nil {
+		ex.Status = resp.StatusCode
+		if state := resp.TLS; state != nil {
+			ex.TLS = tls.VersionName(state.Version)
+			if len(state.PeerCertificates) > 0 {
+				ex.TLS += ", certificate valid until " + state.PeerCertificates[0].NotAfter.UTC().Format(time.DateOnly)
+			}
+		}
+	}
+	t.mu.Lock()
+	t.result.Exchanges = append(t.result.Exchanges, ex)
+	t.mu.Unlock()
+	return resp, err
+}
+
+// ProbeUpload uploads a small generated file to the primary destination of a
+// directory, with its authentication, TLS, URL template and success checks,
+// and diagnoses failures. The test file is left at the destination.
+func ProbeUpload(ctx context.Context, dirCfg config.DirectoryConfig, instance config.InstanceConfig) *ProbeResult {
+	result := &ProbeResult{File: fmt.Sprintf("xferd-probe-%d.txt", time.Now().Unix())}
+
+	dir, err := os.MkdirTemp("", "xferd-probe-")
+	if err != nil {
+		result.Err = fmt.Errorf("failed to create test file: %w", err)
+		return result
+	}
+	defer os.RemoveAll(dir)
+
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("xferd destination probe for directory %s from %s at %s\n", dirCfg.Name, host, time.Now().UTC().Format(time.RFC3339))
+	path := filepath.Join(dir, result.File)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		result.Err = fmt.Errorf("failed to create test file: %w", err)
+		return result
+	}
+	result.Size = int64(len(content))
+
+	u := NewUploader(dirCfg.Outbound)
+	u.directory = dirCfg.Name
+	u.watchPath = dir
+	instance.ID = instance.GetID()
+	u.instance = instance
+	u.client.Transport = &probeTransport{next: u.client.Transport, result: result}
+
+	// The primary destination only, failover would hide its problems
+	result.Err = u.send(ctx, path, uploadOptions{})
+
+	// Uploads redirected with 301, 302 or 303 are followed as GET requests
+	// without the file, which a destination may well answer with 200
+	for i, ex := range result.Exchanges[:max(len(result.Exchanges)-1, 0)] {
+		next := result.Exchanges[i+1]
+		if ex.Method != http.MethodGet && next.Method == http.MethodGet &&
+			(ex.Status == http.StatusMovedPermanently || ex.Status == http.StatusFound || ex.Status == http.StatusSeeOther) {
+			result.Err = fmt.Errorf("the upload was redirected with %d and arrived as a GET request without the file", ex.Status)
+			result.Hint = "set outbound.url to the final URL " + next.URL
+			return result
+		}
+	}
+	if result.Err != nil {
+		result.Hint = probeHint(result)
+	}
+	return result
+}
+
+// probeHint names the likely cause of a failed probe
+func probeHint(result *ProbeResult) string {
+
+	var last ProbeExchange
+	if len(result.Exchanges) > 0 {
+		last = result.Exchanges[len(result.Exchanges)-1]
+	}
+	msg := result.Err.Error()
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case strings.Contains(msg, "invalid outbound TLS configuration"):
+		return "check the files and settings in outbound.tls"
+	case strings.Contains(msg, "outbound url"):
+		return "check the placeholders in outbound.url"
+	case errors.As(last.Err, &certErr) || strings.Contains(msg, "x509:"):
+		return "the destination's certificate is not trusted or does not match its host name; set outbound.tls.ca_file to the CA that issued it"
+	case strings.Contains(msg, "tls:"):
+		return "the TLS handshake failed; check outbound.tls.min_version and whether the destination requires a client certificate (outbound.tls.cert_file)"
+	case last.Err != nil:
+		return "the destination is unreachable; check the host name, port, firewall and proxy settings"
+	case last.Status == http.StatusUnauthorized || last.Status == http.StatusForbidden:
+		return "the destination rejected the credentials; check outbound.auth"
+	case last.Status == http.StatusNotFound || last.Status == http.StatusMethodNotAllowed:
+		return "the destination does not accept uploads at this URL; check outbound.url and outbound.method"
+	case last.Status == http.StatusRequestEntityTooLarge:
+		return "the destination rejected even a tiny file as too large; check its upload limits"
+	case last.Status >= http.StatusInternalServerError:
+		return "the destination failed with a server error; check its logs"
+	case strings.Contains(msg, "unsuccessful response"):
+		return "the destination answered 2xx, but outbound.success rejected the response"
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package uploader
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/strftime"
+)
+
+// SetAfterUpload sets what happens to a source file once it was delivered:
+// it is deleted (config.AfterUploadDelete, the default), kept in place, or
+// moved below processedPath, whose date tokens are expanded per file. Must be
+// called before Start.
+func (d *Dispatcher) SetAfterUpload(action, processedPath string) {
+	d.afterUpload, d.processedPath = action, processedPath
+}
+
+// moveProcessed moves a delivered file below the processed path, keeping its
+// path below the watch directory. A file processed earlier under the same
+// name is not overwritten: a numeric suffix is added instead. Files are
+// renamed, or copied where that is not possible (e.g. across filesystems).
+func (d *Dispatcher) moveProcessed(id int, filePath string) {
+	dir := strftime.Format(d.processedPath, time.Now().UTC())
+	target := processedTarget(filepath.Join(dir, filepath.FromSlash(d.uploader.relPath(filePath))))
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		d.logf(filePath, "Worker %d: failed to create processed directory for %s: %v", id, filePath, err)
+		return
+	}
+	if err := os.Rename(filePath, target); err != nil {
+		// The processed path may be on another filesystem
+		temp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".partial")
+		if err := copyLocal(filePath, temp, uploadOptions{}); err != nil {
+			_ = os.Remove(temp)
+			d.logf(filePath, "Worker %d: failed to move source file %s to %s: %v", id, filePath, target, err)
+			return
+		}
+		if err := os.Rename(temp, target); err != nil {
+			_ = os.Remove(temp)
+			d.logf(filePath, "Worker %d: failed to move source file %s to %s: %v", id, filePath, target, err)
+			return
+		}
+		if err := os.Remove(filePath); err != nil {
+			d.logf(filePath, "Worker %d: copied source file %s to %s but failed to delete it: %v", id, filePath, target, err)
+			return
+		}
+	}
+	d.logf(filePath, "Worker %d: moved source file %s to %s", id, filePath, target)
+	d.recordStage(filePath, filestate.StageMoved, nil)
+}
+
+// processedTarget returns path, or if a file is there already, path with a
+// numeric suffix added before its extension
+func processedTarget(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	target := path
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			return target
+		}
+		target = base + "." + strconv.Itoa(i) + ext
+	}
+}
@@ -0,0 +1,213 @@
+package uploader
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// queueStateInterval is how often changes to the queue state are saved
+const queueStateInterval = time.Second
+
+// QueuedFile is a file that was enqueued but not delivered yet
+type QueuedFile struct {
+	Path                  string `json:"path"`
+	ProcessedDueToTimeout bool   `json:"processed_due_to_timeout,omitempty"`
+	Priority              string `json:"priority,omitempty"`
+	seq                   uint64 // enqueue order, files are saved and resumed in this order
+}
+
+// queueState remembers the files a dispatcher accepted and has not delivered,
+// so they can be enqueued again after a restart without waiting for a
+// reconciliation scan and stability checks. Changes are saved periodically
+// and when the dispatcher stops. A nil queueState records nothing.
+type queueState struct {
+	file    string
+	mu      sync.Mutex
+	files   map[string]QueuedFile // path -> queued file
+	nextSeq uint64
+	dirty   bool
+}
+
+// newQueueState creates a queue state, loading the files left in file by the
+// previous run
+func newQueueState(file string) *queueState {
+	s := &queueState{
+		file:  file,
+		files: make(map[string]QueuedFile),
+	}
+
+	data, err := os.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		log.Printf("Failed to read queue state %s: %v", file, err)
+	default:
+		var saved []QueuedFile // first enqueued first
+		if err := json.Unmarshal(data, &saved); err != nil {
+			log.Printf("Failed to parse queue state %s: %v", file, err)
+		}
+		for _, f := range saved {
+			f.seq = s.nextSeq
+			s.nextSeq++
+			s.files[f.Path] = f
+		}
+	}
+
+	return s
+}
+
+// add records a queued file, keeping its position if it is already recorded
+func (s *queueState) add(f QueuedFile) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.files[f.Path]; ok {
+		f.seq = existing.seq
+	} else {
+		f.seq = s.nextSeq
+		s.nextSeq++
+	}
+	s.files[f.Path] = f
+	s.dirty = true
+}
+
+// remove forgets a file that was delivered or deleted
+func (s *queueState) remove(path string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[path]; ok {
+		delete(s.files, path)
+		s.dirty = true
+	}
+}
+
+// queued returns the recorded files in the order they were enqueued
+func (s *queueState) queued() []QueuedFile {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+// sorted returns the recorded files in enqueue order. Callers must hold s.mu.
+func (s *queueState) sorted() []QueuedFile {
+	files := make([]QueuedFile, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	slices.SortFunc(files, func(a, b QueuedFile) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return files
+}
+
+// run saves changes every queueStateInterval until ctx is done
+func (s *queueState) run(ctx context.Context) {
+	ticker := time.NewTicker(queueStateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush saves the queue state if it changed since it was last saved
+func (s *queueState) flush() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return
+	}
+	if err := s.save(s.sorted()); err != nil {
+		log.Printf("Failed to save queue state %s: %v", s.file, err)
+		return
+	}
+	s.dirty = false
+}
+
+// save atomically writes files to the state file. Callers must hold s.mu.
+func (s *queueState) save(files []QueuedFile) error {
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".xferd-queue-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// SetQueueStateFile records queued files in file so that Resume can enqueue
+// them again after a restart. Must be called before Start.
+func (d *Dispatcher) SetQueueStateFile(file string) {
+	d.state = newQueueState(file)
+}
+
+// Resume hands the files left queued by the previous run that still exist to
+// enqueue, in the order they were first enqueued. Files deleted meanwhile are
+// forgotten. Must be called after Start. Returns the number of files enqueued.
+func (d *Dispatcher) Resume(enqueue func(f QueuedFile) error) int {
+	resumed := 0
+	for _, f := range d.state.queued() {
+		if _, err := os.Stat(f.Path); os.IsNotExist(err) {
+			d.state.remove(f.Path)
+			continue
+		}
+		if err := enqueue(f); err != nil {
+			log.Printf("Failed to resume queued file %s: %v", f.Path, err)
+			continue
+		}
+		resumed++
+	}
+	return resumed
+}
+
+// forget removes a delivered or deleted file from the queue state unless it
+// was queued again meanwhile
+func (d *Dispatcher) forget(filePath string) {
+	if d.state == nil {
+		return
+	}
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	if d.pending[filePath] == 0 {
+		d.state.remove(filePath)
+	}
+}
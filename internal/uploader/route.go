@@ -0,0 +1,100 @@
+package uploader
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// fileRoute is a route rule with its compiled regex and the uploader for
+// its destination, headers and auth
+type fileRoute struct {
+	index    int // position in the routes list, for logging
+	rule     config.RouteRule
+	regex    *regexp.Regexp // nil without a regex condition
+	uploader *Uploader
+}
+
+// SetRoutes sends files matching a route rule through an uploader with the
+// rule's URL, headers and auth instead of the outbound settings. Must be
+// called before Start.
+func (d *Dispatcher) SetRoutes(rules []config.RouteRule) error {
+	routes := make([]*fileRoute, 0, len(rules))
+	for i, rule := range rules {
+		route := &fileRoute{index: i, rule: rule}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("routes[%d]: invalid regex: %w", i, err)
+			}
+			route.regex = re
+		}
+
+		cfg := d.uploader.config
+		if rule.URL != "" {
+			// Failover URLs are alternatives to outbound.url, not to the route's URL
+			cfg.URL = rule.URL
+			cfg.Failover = config.FailoverConfig{}
+		}
+		if rule.Auth != nil {
+			cfg.Auth = *rule.Auth
+		}
+		route.uploader = NewUploader(cfg)
+		if route.uploader.urlErr != nil {
+			return fmt.Errorf("routes[%d]: invalid url template: %w", i, route.uploader.urlErr)
+		}
+		route.uploader.headers = rule.Headers
+		routes = append(routes, route)
+	}
+	d.fileRoutes = routes
+	return nil
+}
+
+// route returns the first route matching a file, or nil if the file goes to
+// the outbound destination
+func (d *Dispatcher) route(filePath string, size int64) *fileRoute {
+	if len(d.fileRoutes) == 0 {
+		return nil
+	}
+	relPath := d.uploader.relPath(filePath)
+	for _, route := range d.fileRoutes {
+		if route.matches(relPath, size) {
+			return route
+		}
+	}
+	return nil
+}
+
+// matches reports whether a file with the given path below the watch
+// directory and size meets all of the rule's conditions
+func (r *fileRoute) matches(relPath string, size int64) bool {
+	rule := &r.rule
+	if len(rule.Patterns) > 0 && !slices.ContainsFunc(rule.Patterns, func(pattern string) bool {
+		return matchRoutePattern(pattern, relPath)
+	}) {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(relPath) {
+		return false
+	}
+	if sub := strings.Trim(filepath.ToSlash(rule.Subdirectory), "/"); sub != "" && !strings.HasPrefix(relPath, sub+"/") {
+		return false
+	}
+	return size >= rule.MinSizeBytes && (rule.MaxSizeBytes == 0 || size <= rule.MaxSizeBytes)
+}
+
+// matchRoutePattern matches a filename glob against the file name, or a glob
+// containing a / against the whole path below the watch directory
+func matchRoutePattern(pattern, relPath string) bool {
+	name := relPath
+	if !strings.Contains(pattern, "/") {
+		name = relPath[strings.LastIndex(relPath, "/")+1:]
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
@@ -0,0 +1,60 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/journal"
+	"github.com/muzy/xferd/internal/quarantine"
+	"github.com/muzy/xferd/internal/scan"
+)
+
+// SetScanner scans every file before it is uploaded and moves infected files
+// to quarantinePath. Must be called before Start.
+func (d *Dispatcher) SetScanner(scanner *scan.Scanner, quarantinePath string) {
+	d.scanner = scanner
+	d.quarantinePath = quarantinePath
+}
+
+// scanFile scans a file before upload. It reports whether the file may be
+// uploaded; an error means it could not be scanned or quarantined and is kept
+// for a later attempt.
+func (d *Dispatcher) scanFile(ctx context.Context, id int, filePath string, fileInfo os.FileInfo) (bool, error) {
+	if d.scanner == nil {
+		return true, nil
+	}
+	res, err := d.scanner.Scan(ctx, filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.handleRemoved(id, filePath)
+		return false, nil
+	}
+	if err != nil {
+		d.logf(filePath, "Worker %d: not uploading %s: %v", id, filePath, err)
+		return false, err
+	}
+	if !res.Infected {
+		return true, nil
+	}
+
+	detail := "malware found"
+	if res.Signature != "" {
+		detail += ": " + res.Signature
+	}
+	target, err := quarantine.Move(d.name, d.quarantinePath, d.uploader.watchPath, filePath, quarantine.ReasonInfected, detail)
+	if err != nil {
+		d.logf(filePath, "Worker %d: not uploading %s, %s, and it could not be quarantined: %v", id, filePath, detail, err)
+		return false, fmt.Errorf("%s, not quarantined: %w", detail, err)
+	}
+	d.logf(filePath, "Worker %d: quarantined %s, %s, moved to %s", id, filePath, detail, target)
+	d.journal.Record(journal.Event{Directory: d.name, Path: filePath, Size: fileInfo.Size(), Outcome: journal.OutcomeQuarantined, Error: detail})
+	d.recordStage(filePath, filestate.StageQuarantined, errors.New(detail))
+	d.sla.done(filePath)
+	if d.onRemoved != nil {
+		d.onRemoved(filePath)
+	}
+	return false, nil
+}
@@ -0,0 +1,334 @@
+package uploader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+const (
+	sigV4Algorithm      = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat     = "20060102T150405Z"
+	sigV4DateFormat     = "20060102"
+	unsignedPayload     = "UNSIGNED-PAYLOAD"
+	defaultIMDSEndpoint = "http://169.254.169.254"
+)
+
+// awsCredentials holds resolved AWS credentials
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // zero for non-expiring credentials
+}
+
+// awsSigner signs requests with AWS Signature Version 4
+type awsSigner struct {
+	config       config.AuthConfig
+	client       *http.Client
+	imdsEndpoint string
+	now          func() time.Time
+
+	mu     sync.Mutex
+	cached *awsCredentials
+}
+
+// newAWSSigner creates a SigV4 signer for the given auth configuration
+func newAWSSigner(cfg config.AuthConfig) *awsSigner {
+	return &awsSigner{
+		config:       cfg,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		imdsEndpoint: defaultIMDSEndpoint,
+		now:          time.Now,
+	}
+}
+
+// Sign adds SigV4 authentication headers to the request
+func (s *awsSigner) Sign(req *http.Request, payloadHash string) error {
+	creds, err := s.credentials(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to resolve AWS credentials: %w", err)
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	scope := strings.Join([]string{now.Format(sigV4DateFormat), s.config.Region, s.config.Service, "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	// S3 requires the payload hash header; other services only need it for unsigned payloads
+	if s.config.Service == "s3" || payloadHash == unsignedPayload {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req),
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), now.Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, s.config.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// credentials resolves credentials from the configured source, caching expiring ones
+func (s *awsSigner) credentials(ctx context.Context) (*awsCredentials, error) {
+	source := s.config.CredentialSource
+	if source == "" {
+		switch {
+		case s.config.AccessKeyID != "":
+			source = "static"
+		case os.Getenv("AWS_ACCESS_KEY_ID") != "":
+			source = "env"
+		default:
+			source = "instance_profile"
+		}
+	}
+
+	switch source {
+	case "static":
+		return &awsCredentials{
+			AccessKeyID:     s.config.AccessKeyID,
+			SecretAccessKey: s.config.SecretAccessKey,
+			SessionToken:    s.config.SessionToken,
+		}, nil
+	case "env":
+		creds := &awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+		}
+		return creds, nil
+	case "instance_profile":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// Refresh ahead of expiry so in-flight requests don't use stale credentials
+		if s.cached != nil && s.now().Before(s.cached.Expires.Add(-5*time.Minute)) {
+			return s.cached, nil
+		}
+		creds, err := s.fetchInstanceCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.cached = creds
+		return creds, nil
+	default:
+		return nil, fmt.Errorf("unknown credential source: %s", source)
+	}
+}
+
+// fetchInstanceCredentials retrieves role credentials from the EC2 instance metadata service (IMDSv2)
+func (s *awsSigner) fetchInstanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPut, s.imdsEndpoint+"/latest/api/token", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := s.imdsGet(tokenReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IMDS token: %w", err)
+	}
+
+	metadata := func(path string) (string, error) {
+		req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, s.imdsEndpoint+path, http.NoBody)
+		if reqErr != nil {
+			return "", reqErr
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return s.imdsGet(req)
+	}
+
+	roles, err := metadata("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instance roles: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return nil, fmt.Errorf("no instance profile role attached")
+	}
+
+	body, err := metadata("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role credentials: %w", err)
+	}
+
+	var result struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode role credentials: %w", err)
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     result.AccessKeyID,
+		SecretAccessKey: result.SecretAccessKey,
+		SessionToken:    result.Token,
+		Expires:         result.Expiration,
+	}, nil
+}
+
+// imdsGet performs a metadata request and returns the body
+func (s *awsSigner) imdsGet(req *http.Request) (string, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return string(body), nil
+}
+
+// canonicalURI returns the URI-encoded path; non-S3 services encode segments twice
+func (s *awsSigner) canonicalURI(req *http.Request) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	if s.config.Service == "s3" {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the sorted, encoded query string
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders returns the canonical header block and signed header list.
+// Only host and x-amz-* headers are signed.
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// payloadHash returns the hex SHA-256 of a replayable request body,
+// or UNSIGNED-PAYLOAD for streamed bodies
+func payloadHash(req *http.Request) (string, error) {
+	if req.GetBody == nil {
+		if req.Body == nil || req.Body == http.NoBody {
+			return hashHex(nil), nil
+		}
+		return unsignedPayload, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", fmt.Errorf("failed to hash request body: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sigV4Escape percent-encodes everything except RFC 3986 unreserved characters
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hashHex returns the hex SHA-256 of data
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 computes HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
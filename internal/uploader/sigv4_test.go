@@ -0,0 +1,227 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// AWS SigV4 test suite credentials
+var sigV4TestAuth = config.AuthConfig{
+	Type:            "aws_sigv4",
+	Region:          "us-east-1",
+	Service:         "service",
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSigV4GetVanilla(t *testing.T) {
+	signer := newAWSSigner(sigV4TestAuth)
+	signer.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", http.NoBody)
+	hash, err := payloadHash(req)
+	if err != nil {
+		t.Fatalf("payloadHash failed: %v", err)
+	}
+	if err := signer.Sign(req, hash); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// Expected value from the AWS SigV4 test suite (get-vanilla)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Authorization mismatch\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestSigV4GetVanillaQuery(t *testing.T) {
+	signer := newAWSSigner(sigV4TestAuth)
+	signer.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", http.NoBody)
+	if err := signer.Sign(req, hashHex(nil)); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// Expected value from the AWS SigV4 test suite (get-vanilla-query-order-key-case)
+	if !strings.HasSuffix(req.Header.Get("Authorization"), "Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500") {
+		t.Errorf("Unexpected signature: %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestSigV4SessionTokenAndS3(t *testing.T) {
+	auth := sigV4TestAuth
+	auth.Service = "s3"
+	auth.SessionToken = "session-token"
+	signer := newAWSSigner(auth)
+
+	req, _ := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/key", strings.NewReader("data"))
+	hash, err := payloadHash(req)
+	if err != nil {
+		t.Fatalf("payloadHash failed: %v", err)
+	}
+	if err := signer.Sign(req, hash); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	if req.Header.Get("X-Amz-Security-Token") != "session-token" {
+		t.Error("Expected X-Amz-Security-Token header")
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") != hashHex([]byte("data")) {
+		t.Errorf("Expected payload hash header, got %s", req.Header.Get("X-Amz-Content-Sha256"))
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token") {
+		t.Errorf("Unexpected signed headers: %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestSigV4EnvCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	signer := newAWSSigner(config.AuthConfig{Type: "aws_sigv4", Region: "eu-west-1", Service: "execute-api"})
+
+	req, _ := http.NewRequest("POST", "https://api.example.com/upload", http.NoBody)
+	if err := signer.Sign(req, hashHex(nil)); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "Credential=AKIDENV/") {
+		t.Errorf("Expected env credentials to be used: %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestSigV4InstanceProfileCredentials(t *testing.T) {
+	var credentialRequests int
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("xferd-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/xferd-role":
+			credentialRequests++
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"AccessKeyId":     "AKIDINSTANCE",
+				"SecretAccessKey": "secret",
+				"Token":           "instance-token",
+				"Expiration":      time.Now().Add(time.Hour),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer imds.Close()
+
+	signer := newAWSSigner(config.AuthConfig{
+		Type:             "aws_sigv4",
+		Region:           "us-east-1",
+		Service:          "execute-api",
+		CredentialSource: "instance_profile",
+	})
+	signer.imdsEndpoint = imds.URL
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "https://api.example.com/upload", http.NoBody)
+		if err := signer.Sign(req, hashHex(nil)); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		if !strings.Contains(req.Header.Get("Authorization"), "Credential=AKIDINSTANCE/") {
+			t.Errorf("Expected instance credentials: %s", req.Header.Get("Authorization"))
+		}
+		if req.Header.Get("X-Amz-Security-Token") != "instance-token" {
+			t.Error("Expected instance session token")
+		}
+	}
+
+	// Credentials are cached until close to expiry
+	if credentialRequests != 1 {
+		t.Errorf("Expected 1 credential request, got %d", credentialRequests)
+	}
+}
+
+func TestUploadWithSigV4(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var authHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{URL: server.URL, Auth: sigV4TestAuth})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if !strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		t.Errorf("Expected SigV4 Authorization header, got %q", authHeader)
+	}
+}
+
+func TestUploadWithSigV4SignsLargePayloads(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "large.bin")
+	if err := os.WriteFile(testFile, bytes.Repeat([]byte("x"), 4096), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	now := func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+	verifier := newAWSSigner(sigV4TestAuth)
+	verifier.now = now
+
+	var authHeader, expected, payload string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		authHeader = r.Header.Get("Authorization")
+		payload = r.Header.Get("X-Amz-Content-Sha256")
+
+		// Re-sign the received request over its body
+		check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), bytes.NewReader(body))
+		if err := verifier.Sign(check, hashHex(body)); err == nil {
+			expected = check.Header.Get("Authorization")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Files above the stream threshold are buffered so the payload can be signed
+	uploader := NewUploader(config.OutboundConfig{URL: server.URL, Auth: sigV4TestAuth, StreamThresholdBytes: 1024})
+	uploader.signer.now = now
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if payload == unsignedPayload {
+		t.Error("Expected a signed payload for a service other than s3")
+	}
+	if authHeader == "" || authHeader != expected {
+		t.Errorf("Signature does not cover the body\nexpected: %s\ngot:      %s", expected, authHeader)
+	}
+
+	if err := uploader.UploadStream(context.Background(), testFile); err != nil {
+		t.Fatalf("UploadStream failed: %v", err)
+	}
+	if authHeader != expected {
+		t.Errorf("Streamed upload signature does not cover the body\nexpected: %s\ngot:      %s", expected, authHeader)
+	}
+}
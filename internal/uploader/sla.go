@@ -0,0 +1,164 @@
+package uploader
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/journal"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+var overdueFiles = metrics.NewCounterVec("xferd_sla_overdue_total",
+	"Files not delivered within the sla deadline of their detection",
+	"directory")
+
+var overdueOutstanding = metrics.NewGaugeVec("xferd_sla_overdue_files",
+	"Files past the sla deadline that are not delivered yet",
+	"directory")
+
+// slaCheckInterval is how often tracked files are checked against the deadline
+const slaCheckInterval = 5 * time.Second
+
+// slaFile is an undelivered file the deadline applies to
+type slaFile struct {
+	detected time.Time
+	overdue  bool // escalated already
+}
+
+// slaTracker remembers when undelivered files were detected. Methods are
+// safe to call on a nil tracker, which tracks nothing.
+type slaTracker struct {
+	cfg   config.SLAConfig
+	mu    sync.Mutex
+	files map[string]*slaFile
+}
+
+// newSLATracker creates a tracker for cfg
+func newSLATracker(cfg config.SLAConfig) *slaTracker {
+	return &slaTracker{cfg: cfg, files: make(map[string]*slaFile)}
+}
+
+// detected records when a file was detected. The earliest time is kept, so
+// a file enqueued again after a failed or dropped upload keeps its deadline.
+func (t *slaTracker) detected(path string, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f, ok := t.files[path]; !ok || at.Before(f.detected) {
+		t.files[path] = &slaFile{detected: at}
+	}
+}
+
+// done forgets a file that was delivered or removed
+func (t *slaTracker) done(path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.files, path)
+}
+
+// reroute reports whether a file is overdue and must skip the primary destination
+func (t *slaTracker) reroute(path string) bool {
+	if t == nil || !t.cfg.Failover {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.files[path]
+	return ok && f.overdue
+}
+
+// expire marks files past the deadline as overdue. It returns the files
+// that became overdue and the number of overdue files. Files that no longer
+// exist, e.g. deleted after being dropped from the queue, are forgotten.
+func (t *slaTracker) expire(now time.Time) ([]string, int) {
+	deadline := t.cfg.GetDeadline()
+	t.mu.Lock()
+	var due []string
+	for path, f := range t.files {
+		if now.Sub(f.detected) >= deadline {
+			due = append(due, path)
+		}
+	}
+	t.mu.Unlock()
+
+	gone := make(map[string]bool)
+	for _, path := range due {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			gone[path] = true
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var expired []string
+	overdue := 0
+	for _, path := range due {
+		f, ok := t.files[path]
+		if !ok {
+			continue // delivered meanwhile
+		}
+		if gone[path] {
+			delete(t.files, path)
+			continue
+		}
+		if !f.overdue {
+			f.overdue = true
+			expired = append(expired, path)
+		}
+		overdue++
+	}
+	return expired, overdue
+}
+
+// SetSLA escalates files not delivered within a deadline of their detection.
+// Must be called before Start.
+func (d *Dispatcher) SetSLA(cfg config.SLAConfig) {
+	if cfg.Enabled {
+		d.sla = newSLATracker(cfg)
+	}
+}
+
+// MarkDetected records when a file was detected, before its stability
+// check. The sla deadline runs from the earliest detection of a file.
+func (d *Dispatcher) MarkDetected(path string, at time.Time) {
+	d.sla.detected(path, at)
+}
+
+// watchSLA escalates overdue files until the dispatcher stops
+func (d *Dispatcher) watchSLA() {
+	ticker := time.NewTicker(slaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.escalateOverdue(now)
+		}
+	}
+}
+
+// escalateOverdue logs, counts and exports files that passed the deadline
+func (d *Dispatcher) escalateOverdue(now time.Time) {
+	expired, overdue := d.sla.expire(now)
+	for _, path := range expired {
+		if d.sla.cfg.Failover {
+			d.logf(path, "SLA: %s not delivered within %v of its detection, sending it to the failover destinations", path, d.sla.cfg.GetDeadline())
+		} else {
+			d.logf(path, "SLA: %s not delivered within %v of its detection", path, d.sla.cfg.GetDeadline())
+		}
+		overdueFiles.With(d.name).Inc()
+		d.journal.Record(journal.Event{Directory: d.name, Path: path, Outcome: journal.OutcomeOverdue})
+		d.recordStage(path, filestate.StageOverdue, nil)
+	}
+	overdueOutstanding.With(d.name).Set(float64(overdue))
+}
@@ -0,0 +1,70 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// checkSuccess applies the configured success checks to a 2xx upload
+// response, for destinations that report errors in the body of a 200
+func (u *Uploader) checkSuccess(resp *uploadResponse) error {
+	success := u.config.Success
+	if success.Header != "" && resp.header.Get(success.Header) == "" {
+		return fmt.Errorf("response is missing header %s", success.Header)
+	}
+	if success.BodyRegex != "" {
+		re, err := regexp.Compile(success.BodyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid outbound.success.body_regex: %w", err)
+		}
+		if !re.Match(resp.body) {
+			return fmt.Errorf("response body does not match %s", success.BodyRegex)
+		}
+	}
+	if success.JSONField != "" {
+		value, ok := jsonField(resp.body, success.JSONField)
+		if !ok {
+			return fmt.Errorf("response has no JSON field %s", success.JSONField)
+		}
+		if success.JSONValue != "" && value != success.JSONValue {
+			return fmt.Errorf("response field %s is %q, expected %q", success.JSONField, value, success.JSONValue)
+		}
+	}
+	return nil
+}
+
+// jsonField returns the text of a field in a JSON object, addressed by a
+// dotted path such as result.status. Missing and null fields are not found.
+func jsonField(body []byte, path string) (string, bool) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok || value == nil {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		text, err := json.Marshal(v)
+		return string(text), err == nil
+	}
+}
@@ -0,0 +1,62 @@
+package uploader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+var tlsHandshakes = metrics.NewCounterVec("xferd_tls_handshakes_total",
+	"Outbound TLS handshakes, by whether a cached session was resumed",
+	"directory", "resumed")
+
+// NewTLSConfig builds the client TLS configuration for outbound uploads
+func NewTLSConfig(cfg config.OutboundTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify, // #nosec G402 -- explicit opt-in for testing
+	}
+
+	if cfg.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+
+	// Resumed sessions skip the certificate exchange, which dominates the
+	// handshake cost when new connections to the same destination are frequent
+	if size := cfg.GetSessionCacheSize(); size > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// countHandshake records whether an outbound TLS handshake resumed a session
+func (u *Uploader) countHandshake(state tls.ConnectionState) error {
+	tlsHandshakes.With(u.directory, strconv.FormatBool(state.DidResume)).Inc()
+	return nil
+}
@@ -0,0 +1,229 @@
+package uploader
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// writeClientCertificate writes a self-signed client certificate and key to dir
+func writeClientCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "xferd-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// writeServerCA writes the httptest server certificate as a CA bundle
+func writeServerCA(t *testing.T, server *httptest.Server, dir string) string {
+	t.Helper()
+	caFile := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	return caFile
+}
+
+func TestUploadWithCustomCA(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Private CA is not trusted by default; certificate errors are not retried as 4xx,
+	// so bound the retries with a short context
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := NewUploader(config.OutboundConfig{URL: server.URL}).Upload(ctx, testFile); err == nil {
+		t.Error("Expected upload to fail without custom CA")
+	}
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL: server.URL,
+		TLS: config.OutboundTLSConfig{CAFile: writeServerCA(t, server, tmpDir)},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload with custom CA failed: %v", err)
+	}
+
+	uploader = NewUploader(config.OutboundConfig{
+		URL: server.URL,
+		TLS: config.OutboundTLSConfig{InsecureSkipVerify: true},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload with insecure_skip_verify failed: %v", err)
+	}
+}
+
+func TestUploadWithClientCertificate(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var clientCN string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	certFile, keyFile := writeClientCertificate(t, tmpDir)
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL: server.URL,
+		TLS: config.OutboundTLSConfig{
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			CAFile:     writeServerCA(t, server, tmpDir),
+			MinVersion: "1.3",
+		},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload with client certificate failed: %v", err)
+	}
+
+	if clientCN != "xferd-client" {
+		t.Errorf("Expected client certificate CN xferd-client, got %q", clientCN)
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	if _, err := NewTLSConfig(config.OutboundTLSConfig{CertFile: "/nonexistent.pem", KeyFile: "/nonexistent.key"}); err == nil {
+		t.Error("Expected error for missing client certificate")
+	}
+
+	if _, err := NewTLSConfig(config.OutboundTLSConfig{CAFile: "/nonexistent-ca.pem"}); err == nil {
+		t.Error("Expected error for missing CA file")
+	}
+
+	invalidCA := filepath.Join(tmpDir, "invalid.pem")
+	if err := os.WriteFile(invalidCA, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if _, err := NewTLSConfig(config.OutboundTLSConfig{CAFile: invalidCA}); err == nil {
+		t.Error("Expected error for CA file without certificates")
+	}
+
+	// Uploads fail rather than falling back to default TLS settings
+	uploader := NewUploader(config.OutboundConfig{
+		URL: "https://example.com",
+		TLS: config.OutboundTLSConfig{CAFile: invalidCA},
+	})
+	if err := uploader.Upload(context.Background(), invalidCA); err == nil {
+		t.Error("Expected upload to fail with invalid TLS configuration")
+	}
+}
+
+func TestUploadResumesTLSSessions(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var resumed []bool
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resumed = append(resumed, r.TLS.DidResume)
+		// Force a new connection, and handshake, for every upload
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		size     int
+		expected []bool
+	}{
+		{"default cache", 0, []bool{false, true, true}},
+		{"disabled", -1, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resumed = nil
+			dispatcher := NewDispatcher(config.OutboundConfig{
+				URL: server.URL,
+				TLS: config.OutboundTLSConfig{CAFile: writeServerCA(t, server, tmpDir), SessionCacheSize: tt.size},
+			}, nil, 1, 1)
+			dispatcher.SetName("tls-resume-" + tt.name)
+			before := tlsHandshakes.With("tls-resume-"+tt.name, "true").Value()
+			for range tt.expected {
+				if err := dispatcher.uploader.Upload(context.Background(), testFile); err != nil {
+					t.Fatalf("Upload failed: %v", err)
+				}
+			}
+			if fmt.Sprint(resumed) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected resumed handshakes %v, got %v", tt.expected, resumed)
+			}
+			want := 0
+			for _, r := range tt.expected {
+				if r {
+					want++
+				}
+			}
+			if got := tlsHandshakes.With("tls-resume-"+tt.name, "true").Value() - before; got != uint64(want) {
+				t.Errorf("Expected %d resumed handshakes counted, got %d", want, got)
+			}
+		})
+	}
+}
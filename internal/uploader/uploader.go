@@ -2,7 +2,9 @@ package uploader
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,30 +13,193 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	"github.com/muzy/xferd/internal/capture"
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/encrypt"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/history"
+	"github.com/muzy/xferd/internal/journal"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/scan"
 	"github.com/muzy/xferd/internal/shadow"
+	"github.com/muzy/xferd/internal/transferid"
 )
 
 // Uploader handles outbound file uploads
 type Uploader struct {
-	config config.OutboundConfig
-	client *http.Client
+	config        config.OutboundConfig
+	client        *http.Client
+	transport     atomic.Pointer[pooledTransport] // replaced when the destination addresses change
+	resolvedAddrs map[string][]string             // last resolved addresses by destination host
+	dnsMu         sync.Mutex
+	tlsErr        error                 // set if the outbound TLS configuration could not be loaded
+	signer        *awsSigner            // set for aws_sigv4 auth
+	urlTemplate   *template.Template    // outbound URL expanded per file
+	urlErr        error                 // set if the outbound URL template is invalid
+	encrypter     *encrypt.Encrypter    // encrypts uploads, nil unless outbound encryption is enabled
+	encryptErr    error                 // set if the outbound encryption keys could not be loaded
+	directory     string                // directory name for URL templates
+	watchPath     string                // watch directory for {{.RelPath}}
+	destinations  []*destination        // primary first, then failover URLs
+	adaptive      *adaptiveLimit        // nil unless adaptive concurrency is enabled
+	headers       map[string]string     // extra upload request headers, set for route uploaders
+	instance      config.InstanceConfig // identifies this instance in uploads, ID resolved
+	recorder      *capture.Recorder     // records sampled requests, nil unless outbound_capture is configured
+	pod           string                // Kubernetes pod name, "" outside Kubernetes
+	namespace     string                // Kubernetes pod namespace
+}
+
+// recordingTransport sends requests through the uploader's transport,
+// recording sampled ones if outbound capture is configured
+type recordingTransport struct {
+	u *Uploader
+}
+
+// RoundTrip implements http.RoundTripper
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.u.recorder.RoundTrip(t.u.directory, req, t.u.transport.Load())
 }
 
 // NewUploader creates a new uploader
 func NewUploader(cfg config.OutboundConfig) *Uploader {
-	return &Uploader{
-		config: cfg,
-		client: &http.Client{
-			Timeout: 5 * time.Minute, // Long timeout for large files
-		},
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newDialContext(cfg.Connection)
+
+	// Uploads fail with tlsErr rather than falling back to default TLS settings
+	tlsConfig, tlsErr := NewTLSConfig(cfg.TLS)
+	if tlsErr != nil {
+		log.Printf("Outbound TLS configuration error for %s: %v", cfg.URL, tlsErr)
+	}
+
+	u := &Uploader{
+		config:        cfg,
+		resolvedAddrs: make(map[string][]string),
+		tlsErr:        tlsErr,
 	}
+	u.transport.Store(&pooledTransport{Transport: transport})
+	u.client = &http.Client{
+		Transport: recordingTransport{u},
+		Timeout:   5 * time.Minute, // Long timeout for large files, unless scaled by size
+	}
+	if tlsErr == nil {
+		tlsConfig.VerifyConnection = u.countHandshake
+		transport.TLSClientConfig = tlsConfig
+	}
+	if cfg.Auth.Type == "aws_sigv4" {
+		u.signer = newAWSSigner(cfg.Auth)
+	}
+	u.destinations = newDestinations(cfg.URL, cfg.Failover.URLs)
+	u.urlTemplate, u.urlErr = parseURLTemplate(cfg.URL)
+	if u.urlErr != nil {
+		log.Printf("Outbound URL template error for %s: %v", cfg.URL, u.urlErr)
+	}
+	// Uploads fail with encryptErr rather than sending plaintext
+	u.encrypter, u.encryptErr = encrypt.New(cfg.Encryption)
+	if u.encryptErr != nil {
+		log.Printf("Outbound encryption configuration error for %s: %v", cfg.URL, u.encryptErr)
+	}
+	return u
 }
 
-// Upload sends a file to the configured endpoint
+// uploadOptions carries per-upload settings used by the dispatcher
+type uploadOptions struct {
+	version        int                // sent as X-File-Version when non-zero
+	idempotencyKey string             // sent in the idempotency key header when set
+	tee            io.Writer          // receives the file content as it is read, e.g. a shadow copy
+	url            *template.Template // overrides the outbound URL, e.g. for a content route
+	link           bool               // local_dir: the file may be hard-linked into place, its source is removed afterwards
+	skipPrimary    bool               // with failover: try the secondary destinations only, e.g. for overdue files
+	receipt        *receipt           // receives the destination and its response once delivered, nil if not needed
+	compress       compression        // gzips the upload, chosen per file as it is opened; encryption applies after it
+}
+
+// source returns the reader for the file content, teeing it if requested
+func (o uploadOptions) source(file io.Reader) io.Reader {
+	if o.tee == nil {
+		return file
+	}
+	return io.TeeReader(file, o.tee)
+}
+
+// copyBufferPool reuses the buffers that copy file content into multipart bodies
+var copyBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32<<10)
+		return &buf
+	},
+}
+
+// copyContent copies src to dst through a pooled buffer
+func copyContent(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+
+	// Hide ReaderFrom/WriterTo so the pooled buffer is always used
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// Upload sends a file to the configured endpoint. Files larger than the
+// configured stream threshold are streamed rather than buffered in memory.
 func (u *Uploader) Upload(ctx context.Context, filePath string) error {
+	_, err := u.upload(ctx, filePath, uploadOptions{})
+	return err
+}
+
+// UploadStream uploads using streaming to handle large files efficiently.
+// Files are buffered instead when the auth type signs the payload.
+func (u *Uploader) UploadStream(ctx context.Context, filePath string) error {
+	if u.config.Auth.SignsPayload() {
+		return u.uploadBuffered(ctx, filePath, uploadOptions{})
+	}
+	return u.uploadStream(ctx, filePath, uploadOptions{})
+}
+
+// upload sends a file, failing over to secondary destinations if configured.
+// It returns the destination URL that accepted the file when failover is
+// configured, and an empty string otherwise.
+func (u *Uploader) upload(ctx context.Context, filePath string, opts uploadOptions) (string, error) {
+	if len(u.destinations) > 1 && opts.url == nil {
+		return u.uploadFailover(ctx, filePath, opts)
+	}
+	return "", u.send(ctx, filePath, opts)
+}
+
+// send uploads a file, streaming it if it is larger than the stream threshold
+// and the auth type does not sign the payload, or delivers it over FTP or into the target directory for other outbound types
+func (u *Uploader) send(ctx context.Context, filePath string, opts uploadOptions) error {
+	switch u.config.GetType() {
+	case config.OutboundLocalDir:
+		return u.sendLocal(ctx, filePath, opts)
+	case config.OutboundFTP:
+		return u.sendFTP(ctx, filePath, opts)
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	// Streamed bodies cannot be hashed up front, so they go unsigned
+	if fileInfo.Size() > u.config.GetStreamThreshold() && !u.config.Auth.SignsPayload() {
+		return u.uploadStream(ctx, filePath, opts)
+	}
+	return u.uploadBuffered(ctx, filePath, opts)
+}
+
+// uploadBuffered sends a file as a request built in memory.
+// Buffered bodies can be hashed for signing.
+func (u *Uploader) uploadBuffered(ctx context.Context, filePath string, opts uploadOptions) error {
+	if u.tlsErr != nil {
+		return fmt.Errorf("invalid outbound TLS configuration: %w", u.tlsErr)
+	}
+	if err := u.errEncryption(); err != nil {
+		return err
+	}
+
 	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -48,43 +213,81 @@ func (u *Uploader) Upload(ctx context.Context, filePath string) error {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	// Prepare multipart upload
+	// Prepare the body, sized for the file plus multipart framing
 	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	body.Grow(int(fileInfo.Size()) + 1024)
+	contentType := rawContentType
+	opts.compress = u.compression(filePath, file, fileInfo.Size())
+	out, closeBody := opts.compress.body(body)
+	name := u.sendName(filePath, opts.compress)
 
-	// Create form file
-	part, partErr := writer.CreateFormFile("file", filepath.Base(filePath))
-	if partErr != nil {
-		return fmt.Errorf("failed to create form file: %w", partErr)
-	}
+	if u.config.GetBodyFormat() == config.BodyFormatRaw {
+		content, closeContent, contentErr := u.content(out, opts.compress)
+		if contentErr != nil {
+			return contentErr
+		}
+		if _, copyErr := copyContent(content, opts.source(file)); copyErr != nil {
+			return fmt.Errorf("failed to copy file content: %w", copyErr)
+		}
+		if closeErr := closeContent(); closeErr != nil {
+			return fmt.Errorf("failed to encode file content: %w", closeErr)
+		}
+	} else {
+		writer := multipart.NewWriter(out)
+		contentType = writer.FormDataContentType()
 
-	// Copy file content
-	if _, copyErr := io.Copy(part, file); copyErr != nil {
-		return fmt.Errorf("failed to copy file content: %w", copyErr)
-	}
+		if fieldErr := u.writeFormFields(writer, name); fieldErr != nil {
+			return fmt.Errorf("failed to write form field: %w", fieldErr)
+		}
 
-	// Close multipart writer
-	if closeErr := writer.Close(); closeErr != nil {
-		return fmt.Errorf("failed to close multipart writer: %w", closeErr)
+		// Create form file
+		part, partErr := writer.CreateFormFile(u.config.GetFieldName(), filepath.Base(name))
+		if partErr != nil {
+			return fmt.Errorf("failed to create form file: %w", partErr)
+		}
+
+		// Copy file content
+		content, closeContent, contentErr := u.content(part, opts.compress)
+		if contentErr != nil {
+			return contentErr
+		}
+		if _, copyErr := copyContent(content, opts.source(file)); copyErr != nil {
+			return fmt.Errorf("failed to copy file content: %w", copyErr)
+		}
+		if closeErr := closeContent(); closeErr != nil {
+			return fmt.Errorf("failed to encode file content: %w", closeErr)
+		}
+
+		// Close multipart writer
+		if closeErr := writer.Close(); closeErr != nil {
+			return fmt.Errorf("failed to close multipart writer: %w", closeErr)
+		}
+	}
+	if closeErr := closeBody(); closeErr != nil {
+		return fmt.Errorf("failed to compress request body: %w", closeErr)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", u.config.URL, body)
+	req, err := u.newUploadRequest(ctx, filePath, body, contentType, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
 	// Add authentication
-	u.addAuth(req)
+	if err := u.addAuth(req); err != nil {
+		return err
+	}
 
 	// Execute request with retries
-	return u.executeWithRetry(req, filePath, fileInfo.Size())
+	return u.executeWithRetry(req, filePath, fileInfo.Size(), opts)
 }
 
-// UploadStream uploads using streaming to handle large files efficiently
-func (u *Uploader) UploadStream(ctx context.Context, filePath string) error {
+// uploadStream sends a file as a streamed request
+func (u *Uploader) uploadStream(ctx context.Context, filePath string, opts uploadOptions) error {
+	if u.tlsErr != nil {
+		return fmt.Errorf("invalid outbound TLS configuration: %w", u.tlsErr)
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
@@ -96,42 +299,185 @@ func (u *Uploader) UploadStream(ctx context.Context, filePath string) error {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
+	opts.compress = u.compression(filePath, file, fileInfo.Size())
+	return u.sendStream(ctx, filePath, opts.source(file), fileInfo.Size(), opts)
+}
+
+// sendStream streams content named after filePath to the destination. size
+// is the content length, or -1 if unknown.
+func (u *Uploader) sendStream(ctx context.Context, filePath string, src io.Reader, size int64, opts uploadOptions) error {
+	if err := u.errEncryption(); err != nil {
+		return err
+	}
+
+	// Raw bodies are sent as read, or gzipped or encrypted with an unknown length
+	if u.config.GetBodyFormat() == config.BodyFormatRaw {
+		req, err := u.newUploadRequest(ctx, filePath, u.reader(src, opts.compress), rawContentType, opts)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = size
+		if opts.compress.mode != "" || u.encrypter != nil {
+			req.ContentLength = -1
+		}
+		if err := u.addAuth(req); err != nil {
+			return err
+		}
+		return u.executeWithRetry(req, filePath, size, opts)
+	}
+
 	// Create a pipe for streaming
 	pr, pw := io.Pipe()
-	writer := multipart.NewWriter(pw)
+	out, closeBody := opts.compress.body(pw)
+	writer := multipart.NewWriter(out)
+	name := u.sendName(filePath, opts.compress)
 
 	// Write multipart data in a goroutine
 	go func() {
 		defer pw.Close()
+		defer closeBody()
 		defer writer.Close()
 
-		part, partErr := writer.CreateFormFile("file", filepath.Base(filePath))
+		if fieldErr := u.writeFormFields(writer, name); fieldErr != nil {
+			pw.CloseWithError(fieldErr)
+			return
+		}
+
+		part, partErr := writer.CreateFormFile(u.config.GetFieldName(), filepath.Base(name))
 		if partErr != nil {
 			pw.CloseWithError(partErr)
 			return
 		}
 
-		if _, copyErr := io.Copy(part, file); copyErr != nil {
+		content, closeContent, contentErr := u.content(part, opts.compress)
+		if contentErr != nil {
+			pw.CloseWithError(contentErr)
+			return
+		}
+		if _, copyErr := copyContent(content, src); copyErr != nil {
 			pw.CloseWithError(copyErr)
 			return
 		}
+		if closeErr := closeContent(); closeErr != nil {
+			pw.CloseWithError(closeErr)
+		}
 	}()
 
 	// Create request with pipe reader
-	req, err := http.NewRequestWithContext(ctx, "POST", u.config.URL, pr)
+	req, err := u.newUploadRequest(ctx, filePath, pr, writer.FormDataContentType(), opts)
+	if err != nil {
+		return err
+	}
+	if err := u.addAuth(req); err != nil {
+		return err
+	}
+
+	// Execute request
+	return u.executeWithRetry(req, filePath, size, opts)
+}
+
+// rawContentType is sent for raw request bodies
+const rawContentType = "application/octet-stream"
+
+// newUploadRequest creates an upload request with the configured method and
+// URL and the per-upload headers. Raw bodies name the file in X-Filename.
+func (u *Uploader) newUploadRequest(ctx context.Context, filePath string, body io.Reader, contentType string, opts uploadOptions) (*http.Request, error) {
+	filePath = u.sendName(filePath, opts.compress)
+	target, err := u.fileURL(filePath)
+	if opts.url != nil {
+		target, err = u.expandURL(opts.url, filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, u.config.GetMethod(), target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if opts.compress.mode == config.CompressionHeader {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for name, value := range u.headers {
+		req.Header.Set(name, value)
+	}
+	if u.config.GetBodyFormat() == config.BodyFormatRaw {
+		req.Header.Set("X-Filename", filepath.Base(filePath))
+	}
+	u.setRelativePathHeader(req, filePath)
+	if u.instance.Enabled {
+		req.Header.Set(u.instance.GetHeader(), u.instance.ID)
+	}
+	if u.pod != "" {
+		req.Header.Set("X-Xferd-Pod", u.pod)
+	}
+	if u.namespace != "" {
+		req.Header.Set("X-Xferd-Namespace", u.namespace)
+	}
+	setVersionHeader(req, opts.version)
+	u.setIdempotencyKey(req, opts.idempotencyKey)
+	return req, nil
+}
+
+// setRelativePathHeader names the file's path below the watch directory in
+// a header when configured
+func (u *Uploader) setRelativePathHeader(req *http.Request, filePath string) {
+	if rp := u.config.RelativePath; rp.Mode == config.RelativePathHeader {
+		req.Header.Set(rp.GetName(), u.relPath(filePath))
+	}
+}
+
+// writeFormFields adds the file's path below the watch directory and the
+// instance ID as form fields ahead of the file when configured
+func (u *Uploader) writeFormFields(writer *multipart.Writer, filePath string) error {
+	if rp := u.config.RelativePath; rp.Mode == config.RelativePathField {
+		if err := writer.WriteField(rp.GetName(), u.relPath(filePath)); err != nil {
+			return err
+		}
+	}
+	if u.instance.Enabled && u.instance.Field != "" {
+		return writer.WriteField(u.instance.Field, u.instance.ID)
+	}
+	return nil
+}
+
+// NotifyDelete tells the destination that a file was deleted before it could be uploaded.
+// The notice is a DELETE request to the outbound URL naming the file in X-Filename.
+func (u *Uploader) NotifyDelete(ctx context.Context, filePath string) error {
+	if u.tlsErr != nil {
+		return fmt.Errorf("invalid outbound TLS configuration: %w", u.tlsErr)
+	}
+
+	target, err := u.fileURL(filePath)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, target, http.NoBody)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("X-Filename", filepath.Base(filePath))
+	u.setRelativePathHeader(req, filePath)
+	if err := u.addAuth(req); err != nil {
+		return err
+	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	u.addAuth(req)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
 
-	// Execute request
-	return u.executeWithRetry(req, filePath, fileInfo.Size())
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return nil
 }
 
 // addAuth adds authentication to the request
-func (u *Uploader) addAuth(req *http.Request) {
+func (u *Uploader) addAuth(req *http.Request) error {
 	switch u.config.Auth.Type {
 	case "basic":
 		req.SetBasicAuth(u.config.Auth.Username, u.config.Auth.Password)
@@ -139,11 +485,53 @@ func (u *Uploader) addAuth(req *http.Request) {
 		req.Header.Set("Authorization", "Bearer "+u.config.Auth.Token)
 	case "token":
 		req.Header.Set("Authorization", "Token "+u.config.Auth.Token)
+	case "aws_sigv4":
+		hash, err := payloadHash(req)
+		if err != nil {
+			return err
+		}
+		if err := u.signer.Sign(req, hash); err != nil {
+			return fmt.Errorf("failed to sign request: %w", err)
+		}
 	}
+	return nil
 }
 
-// executeWithRetry executes the request with retry logic
-func (u *Uploader) executeWithRetry(req *http.Request, filePath string, fileSize int64) error {
+// logf logs a message about a file, prefixed with the directory and the
+// file's transfer ID
+func (u *Uploader) logf(filePath, format string, args ...any) {
+	log.Print(transferid.Tag(u.directory, filePath) + " " + fmt.Sprintf(format, args...))
+}
+
+// executeWithRetry executes the upload request with retry logic, completes
+// two-phase uploads by committing them and verifies the result if enabled
+func (u *Uploader) executeWithRetry(req *http.Request, filePath string, fileSize int64, opts uploadOptions) error {
+	resp, err := u.doWithRetry(req, filePath, u.config.Connection.GetRequestTimeout(fileSize), u.checkSuccess)
+	if err != nil {
+		return err
+	}
+	u.logf(filePath, "Upload successful: %s (size: %d bytes, status: %d)", filePath, fileSize, resp.status)
+
+	if err := u.commit(req.Context(), filePath, req.URL.String(), resp); err != nil {
+		return err
+	}
+	if err := u.verify(req.Context(), filePath, req.URL.String(), resp); err != nil {
+		return err
+	}
+	opts.receipt.set(req.URL.Redacted(), resp)
+	return nil
+}
+
+// doWithRetry sends a request, retrying server errors with exponential backoff.
+// A non-zero timeout replaces the client's fixed timeout for each attempt.
+// 2xx responses rejected by check are retried like server errors.
+func (u *Uploader) doWithRetry(req *http.Request, filePath string, timeout time.Duration, check func(*uploadResponse) error) (*uploadResponse, error) {
+	client := u.client
+	if timeout > 0 {
+		scaled := *u.client
+		scaled.Timeout = timeout
+		client = &scaled
+	}
 	maxRetries := 3
 	backoff := time.Second
 
@@ -151,24 +539,38 @@ func (u *Uploader) executeWithRetry(req *http.Request, filePath string, fileSize
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
-			log.Printf("Upload retry %d/%d for %s", attempt, maxRetries, filePath)
+			// Streamed bodies were consumed by the first attempt and cannot be replayed
+			if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+				return nil, fmt.Errorf("upload failed, streamed body cannot be retried: %w", lastErr)
+			}
+			u.logf(filePath, "Upload retry %d/%d for %s", attempt, maxRetries, filePath)
 
 			// Check if context is cancelled before sleeping
 			select {
 			case <-req.Context().Done():
-				return fmt.Errorf("upload cancelled: %w", req.Context().Err())
+				return nil, fmt.Errorf("upload cancelled: %w", req.Context().Err())
 			case <-time.After(backoff):
 				// Continue with retry
 			}
 			backoff *= 2
+
+			// Buffered bodies are rewound; the first attempt consumed them
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("failed to rewind request body: %w", err)
+				}
+				req.Body = body
+			}
 		}
 
-		resp, err := u.client.Do(req)
+		sent := time.Now()
+		resp, err := client.Do(req)
 		if err != nil {
 			lastErr = fmt.Errorf("request failed: %w", err)
 			// Check if this is a context cancellation error
 			if req.Context().Err() != nil {
-				return fmt.Errorf("upload cancelled: %w", req.Context().Err())
+				return nil, fmt.Errorf("upload cancelled: %w", req.Context().Err())
 			}
 			continue
 		}
@@ -176,33 +578,111 @@ func (u *Uploader) executeWithRetry(req *http.Request, filePath string, fileSize
 		// Read and close response body
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		u.adaptive.observe(sent, resp.StatusCode)
 
 		// Check status code
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			log.Printf("Upload successful: %s (size: %d bytes, status: %d)",
-				filePath, fileSize, resp.StatusCode)
-			return nil
+			result := &uploadResponse{status: resp.StatusCode, header: resp.Header, body: body}
+			if check != nil {
+				if checkErr := check(result); checkErr != nil {
+					lastErr = fmt.Errorf("unsuccessful response: %w", checkErr)
+					continue
+				}
+			}
+			return result, nil
 		}
 
 		// 4xx errors - don't retry (client error)
 		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			return fmt.Errorf("client error (no retry): %d - %s", resp.StatusCode, string(body))
+			return nil, fmt.Errorf("%w: %d - %s", errRejected, resp.StatusCode, string(body))
 		}
 
 		// 5xx errors - retry (server error)
 		lastErr = fmt.Errorf("server error: %d - %s", resp.StatusCode, string(body))
 	}
 
-	return fmt.Errorf("upload failed after %d attempts: %w", maxRetries+1, lastErr)
+	return nil, fmt.Errorf("upload failed after %d attempts: %w", maxRetries+1, lastErr)
+}
+
+// ErrQueueFull is returned by Enqueue when a file is dropped because the upload queue is full
+var ErrQueueFull = errors.New("upload queue full")
+
+// queueOverflows counts enqueue attempts that found the upload queue full
+var queueOverflows = metrics.NewCounterVec("xferd_upload_queue_overflows_total",
+	"Enqueue attempts that found the upload queue full, by outcome: blocked (enqueued after waiting), timeout, dropped_newest or dropped_oldest",
+	"directory", "outcome")
+
+// WorkerLimit caps the number of uploads in progress across dispatchers
+type WorkerLimit struct {
+	slots chan struct{}
+}
+
+// NewWorkerLimit creates a limit of n concurrent uploads. Returns nil (no limit) if n <= 0.
+func NewWorkerLimit(n int) *WorkerLimit {
+	if n <= 0 {
+		return nil
+	}
+	return &WorkerLimit{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot. Returns false if ctx is cancelled first.
+func (l *WorkerLimit) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *WorkerLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
 }
 
 // Dispatcher manages upload queue and concurrency
 type Dispatcher struct {
 	uploader           *Uploader
 	shadowManager      *shadow.Manager
-	workQueue          chan fileEvent
+	queues             []chan fileEvent // one shared queue, or one per worker with ordered delivery
+	high, low          chan fileEvent   // priority class queues, nil unless priorities are enabled
+	queueSize          int
 	maxWorkers         int
-	onSuccessfulUpload func(path string) // callback for successful uploads
+	orderKey           func(path string) string // nil unless delivery is ordered
+	limit              *WorkerLimit             // shared cap across dispatchers, nil if unlimited
+	name               string                   // directory name used in metrics
+	overflow           config.QueueOverflow     // what to do when the queue is full
+	onSuccessfulUpload func(path string)        // callback for successful uploads
+	onRemoved          func(path string)        // callback for files deleted before upload
+	onDropped          func(path string)        // callback for queued files evicted by drop_oldest
+	history            *deliveryHistory         // nil unless versioning is enabled
+	dedup              *dedupCache              // nil unless duplicate suppression is enabled
+	mirror             *Mirror                  // nil unless mirroring is enabled
+	deadline           time.Duration            // hard per-file upload deadline, 0 if disabled
+	routes             *contentRoutes           // nil unless content route rules are configured
+	fileRoutes         []*fileRoute             // name, path and size routes, first match wins
+	journal            *journal.Exporter        // nil unless journal export is enabled
+	transfers          *history.Store           // nil unless the transfer history is enabled
+	state              *queueState              // nil unless queued files are persisted across restarts
+	files              *filestate.Store         // nil unless file lifecycles are tracked
+	sla                *slaTracker              // nil unless an sla deadline is set
+	scanner            *scan.Scanner            // nil unless files are scanned for malware
+	quarantinePath     string                   // where infected files and files whose hook failed are moved
+	hook               *postUploadHook          // nil unless a post-upload hook is configured
+	afterUpload        string                   // what happens to delivered files, "" to delete them
+	processedPath      string                   // where delivered files are moved with after_upload: move
+	batcher            *batcher                 // nil unless small files are uploaded in batches
+	workersMu          sync.Mutex
+	workers            map[int]*workerSlot // busy state per worker id, only tracked with a deadline
+	pendingMu          sync.Mutex
+	pending            map[string]int // queued entries per path
+	cancelled          map[string]int // queued entries to skip because the file was deleted
+	active             atomic.Int64   // files taken from the queue and not yet finished
 	ctx                context.Context
 	cancel             context.CancelFunc
 	stopped            bool
@@ -215,33 +695,216 @@ func (d *Dispatcher) SetOnSuccessfulUpload(callback func(path string)) {
 	d.onSuccessfulUpload = callback
 }
 
+// SetWorkerLimit shares a global upload cap with other dispatchers. Must be called before Start.
+func (d *Dispatcher) SetWorkerLimit(limit *WorkerLimit) {
+	d.limit = limit
+}
+
+// SetOnDropped sets the callback for queued files evicted to make room for newer ones
+func (d *Dispatcher) SetOnDropped(callback func(path string)) {
+	d.onDropped = callback
+}
+
+// SetName sets the directory name used to label the dispatcher's metrics and
+// expand {{.Directory}} in the outbound URL
+func (d *Dispatcher) SetName(name string) {
+	d.name = name
+	d.uploader.directory = name
+}
+
+// logf logs a message about a file, prefixed with the directory and the
+// file's transfer ID
+func (d *Dispatcher) logf(filePath, format string, args ...any) {
+	log.Print(transferid.Tag(d.name, filePath) + " " + fmt.Sprintf(format, args...))
+}
+
+// SetRecorder records sampled outbound requests and responses of the
+// dispatcher's uploads
+func (d *Dispatcher) SetRecorder(r *capture.Recorder) {
+	d.uploader.recorder = r
+}
+
+// SetWatchPath sets the watch directory {{.RelPath}} in the outbound URL is relative to
+func (d *Dispatcher) SetWatchPath(watchPath string) {
+	d.uploader.watchPath = watchPath
+}
+
+// SetInstance identifies this instance in upload requests. Must be called before Start.
+func (d *Dispatcher) SetInstance(instance config.InstanceConfig) {
+	instance.ID = instance.GetID()
+	d.uploader.instance = instance
+}
+
+// SetPod names the Kubernetes pod and namespace in upload requests. Must be
+// called before Start.
+func (d *Dispatcher) SetPod(name, namespace string) {
+	d.uploader.pod = name
+	d.uploader.namespace = namespace
+}
+
+// SetJournal records transfer events with an exporter. Must be called before Start.
+func (d *Dispatcher) SetJournal(exporter *journal.Exporter) {
+	d.journal = exporter
+}
+
+// SetTransferHistory records completed transfers in a history store. Must be called before Start.
+func (d *Dispatcher) SetTransferHistory(store *history.Store) {
+	d.transfers = store
+}
+
+// SetFileState records file lifecycle stages in a store. Must be called before Start.
+func (d *Dispatcher) SetFileState(store *filestate.Store) {
+	d.files = store
+}
+
+// recordStage records a lifecycle stage of a file, if lifecycles are tracked
+func (d *Dispatcher) recordStage(filePath, stage string, err error) {
+	e := filestate.Event{Directory: d.name, Path: filePath, Stage: stage}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	d.files.Record(e)
+}
+
+// SetQueueOverflow sets the policy applied when the upload queue is full
+func (d *Dispatcher) SetQueueOverflow(overflow config.QueueOverflow) {
+	d.overflow = overflow
+}
+
+// SetMirror forwards a copy of every delivered file to a secondary xferd. Must be called before Start.
+func (d *Dispatcher) SetMirror(mirror *Mirror) {
+	d.mirror = mirror
+}
+
+// QueueLength returns the number of files waiting for a worker
+func (d *Dispatcher) QueueLength() int {
+	n := len(d.high) + len(d.low)
+	for _, queue := range d.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// QueueCapacity returns the number of files that can wait for a worker
+func (d *Dispatcher) QueueCapacity() int {
+	n := cap(d.high) + cap(d.low)
+	for _, queue := range d.queues {
+		n += cap(queue)
+	}
+	return n
+}
+
+// SetOnRemoved sets the callback for queued files that were deleted before upload
+func (d *Dispatcher) SetOnRemoved(callback func(path string)) {
+	d.onRemoved = callback
+}
+
 // fileEvent represents a file to be uploaded with metadata
 type fileEvent struct {
 	path                  string
 	processedDueToTimeout bool
+	batch                 []fileEvent // files in the archive at path, nil unless this is a batch
 }
 
 // NewDispatcher creates a new upload dispatcher
-func NewDispatcher(cfg config.OutboundConfig, shadowMgr *shadow.Manager, maxWorkers int) *Dispatcher {
-	return &Dispatcher{
+func NewDispatcher(cfg config.OutboundConfig, shadowMgr *shadow.Manager, maxWorkers, queueSize int) *Dispatcher {
+	d := &Dispatcher{
 		uploader:      NewUploader(cfg),
 		shadowManager: shadowMgr,
-		workQueue:     make(chan fileEvent, 100),
+		queues:        []chan fileEvent{make(chan fileEvent, queueSize)},
+		queueSize:     queueSize,
 		maxWorkers:    maxWorkers,
+		pending:       make(map[string]int),
+		cancelled:     make(map[string]int),
+		workers:       make(map[int]*workerSlot),
 	}
+	// Keep a connection per worker open between uploads instead of the default two
+	d.uploader.transport.Load().MaxIdleConnsPerHost = max(maxWorkers, 2)
+	if cfg.Versioning.Enabled {
+		d.history = newDeliveryHistory(cfg.Versioning)
+	}
+	if cfg.Dedup.Enabled {
+		d.dedup = newDedupCache(cfg.Dedup)
+	}
+	return d
 }
 
 // Start starts the dispatcher workers
 func (d *Dispatcher) Start(ctx context.Context) {
 	d.ctx, d.cancel = context.WithCancel(ctx)
 
+	// Route uploaders share the directory settings of the dispatcher's uploader
+	uploaders := []*Uploader{d.uploader}
+	for _, route := range d.fileRoutes {
+		route.uploader.directory = d.uploader.directory
+		route.uploader.watchPath = d.uploader.watchPath
+		route.uploader.adaptive = d.uploader.adaptive
+		route.uploader.instance = d.uploader.instance
+		route.uploader.recorder = d.uploader.recorder
+		route.uploader.pod = d.uploader.pod
+		route.uploader.namespace = d.uploader.namespace
+		uploaders = append(uploaders, route.uploader)
+	}
+
+	// Keep outbound connections warm and DNS fresh if configured
+	for _, u := range uploaders {
+		if u.needsConnectionMaintenance() {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				u.maintainConnections(d.ctx)
+			}()
+		}
+	}
+
+	if d.mirror != nil {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.mirror.run(d.ctx)
+		}()
+	}
+
+	if d.state != nil {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.state.run(d.ctx)
+		}()
+	}
+
+	if d.batcher != nil {
+		go d.batcher.run(d.ctx, d.enqueueBatch)
+	}
+
 	// Start worker goroutines
 	for i := 0; i < d.maxWorkers; i++ {
+		d.startWorker(i)
+	}
+
+	// Escalate files not delivered within the sla deadline
+	if d.sla != nil {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.watchSLA()
+		}()
+	}
+
+	// Replace workers that hang past the upload deadline
+	if d.deadline > 0 {
 		d.wg.Add(1)
-		go d.worker(i)
+		go func() {
+			defer d.wg.Done()
+			d.watchdog()
+		}()
 	}
 
-	log.Printf("Upload dispatcher started with %d workers", d.maxWorkers)
+	if d.orderKey != nil {
+		log.Printf("Upload dispatcher started with %d workers (queue size %d, ordered delivery)", d.maxWorkers, d.queueSize)
+	} else {
+		log.Printf("Upload dispatcher started with %d workers (queue size %d)", d.maxWorkers, d.queueSize)
+	}
 }
 
 // Stop stops the dispatcher and waits for all workers to finish
@@ -259,113 +922,528 @@ func (d *Dispatcher) Stop() {
 		d.cancel()
 	}
 
-	// Close work queue to unblock workers waiting on queue
-	close(d.workQueue)
+	// The batcher sends to the work queues, so it must stop before they close
+	if d.batcher != nil && d.ctx != nil {
+		<-d.batcher.done
+	}
+
+	// Close work queues to unblock workers waiting on them
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	if d.high != nil {
+		close(d.high)
+		close(d.low)
+	}
 
 	// Wait for all workers to finish processing
 	d.wg.Wait()
 	log.Printf("All upload workers stopped")
+
+	// Files still queued or interrupted mid-upload are resumed on the next start
+	d.state.flush()
+}
+
+// Enqueue adds a file to the upload queue with normal priority
+func (d *Dispatcher) Enqueue(filePath string, processedDueToTimeout bool) error {
+	return d.EnqueueWithPriority(filePath, processedDueToTimeout, config.PriorityNormal)
 }
 
-// Enqueue adds a file to the upload queue
-func (d *Dispatcher) Enqueue(filePath string, processedDueToTimeout bool) {
+// EnqueueWithPriority adds a file to the upload queue of its priority class.
+// If the queue is full, the overflow policy decides whether to wait for space,
+// evict the oldest entry or drop the file; a dropped file is reported with ErrQueueFull.
+func (d *Dispatcher) EnqueueWithPriority(filePath string, processedDueToTimeout bool, priority string) error {
 	event := fileEvent{
 		path:                  filePath,
 		processedDueToTimeout: processedDueToTimeout,
 	}
 
+	if d.journal.Wants(journal.OutcomeDetected) {
+		detected := journal.Event{Directory: d.name, Path: filePath, Outcome: journal.OutcomeDetected}
+		if info, err := os.Stat(filePath); err == nil {
+			detected.Size = info.Size()
+		}
+		d.journal.Record(detected)
+	}
+
+	queue := d.queueFor(filePath, priority)
+	d.sla.detected(filePath, time.Now())
+	d.recordStage(filePath, filestate.StageEnqueued, nil)
+	if d.batcher != nil {
+		batched, err := d.batcher.add(d.ctx, event)
+		if err != nil {
+			d.logf(filePath, "Dispatcher stopped, cannot enqueue: %s", filePath)
+			return err
+		}
+		if batched {
+			d.logf(filePath, "Added to the next batch: %s", filePath)
+			return nil
+		}
+	}
+	d.trackPending(filePath, 1)
+	d.state.add(QueuedFile{Path: filePath, ProcessedDueToTimeout: processedDueToTimeout, Priority: priority})
+
 	select {
-	case d.workQueue <- event:
-		log.Printf("Enqueued for upload: %s", filePath)
+	case queue <- event:
+		d.logf(filePath, "Enqueued for upload: %s", filePath)
+		return nil
 	case <-d.ctx.Done():
-		log.Printf("Dispatcher stopped, cannot enqueue: %s", filePath)
+		d.trackPending(filePath, -1)
+		d.logf(filePath, "Dispatcher stopped, cannot enqueue: %s", filePath)
+		return fmt.Errorf("dispatcher stopped")
 	default:
-		log.Printf("Upload queue full, dropping: %s", filePath)
 	}
+
+	switch d.overflow.GetPolicy() {
+	case config.OverflowBlock:
+		timer := time.NewTimer(d.overflow.GetBlockTimeout())
+		defer timer.Stop()
+
+		select {
+		case queue <- event:
+			queueOverflows.With(d.name, "blocked").Inc()
+			d.logf(filePath, "Enqueued for upload after waiting for queue space: %s", filePath)
+			return nil
+		case <-d.ctx.Done():
+			d.trackPending(filePath, -1)
+			d.logf(filePath, "Dispatcher stopped, cannot enqueue: %s", filePath)
+			return fmt.Errorf("dispatcher stopped")
+		case <-timer.C:
+			d.trackPending(filePath, -1)
+			queueOverflows.With(d.name, "timeout").Inc()
+			d.logf(filePath, "Upload queue full for %v, dropping: %s", d.overflow.GetBlockTimeout(), filePath)
+			d.recordStage(filePath, filestate.StageDropped, ErrQueueFull)
+			return ErrQueueFull
+		}
+
+	case config.OverflowDropOldest:
+		select {
+		case oldest := <-queue:
+			d.evict(oldest)
+		default: // a worker emptied a slot meanwhile
+		}
+		select {
+		case queue <- event:
+			d.logf(filePath, "Enqueued for upload: %s", filePath)
+			return nil
+		default: // another producer took the slot
+		}
+	}
+
+	d.trackPending(filePath, -1)
+	queueOverflows.With(d.name, "dropped_newest").Inc()
+	d.logf(filePath, "Upload queue full, dropping: %s", filePath)
+	d.recordStage(filePath, filestate.StageDropped, ErrQueueFull)
+	return ErrQueueFull
+}
+
+// evict drops a queued entry to make room for a newer file
+func (d *Dispatcher) evict(event fileEvent) {
+	if d.dequeue(event.path) {
+		// Deleted before upload; a worker would have skipped it anyway
+		d.forget(event.path)
+		d.sla.done(event.path)
+		if d.onRemoved != nil {
+			d.onRemoved(event.path)
+		}
+		return
+	}
+
+	queueOverflows.With(d.name, "dropped_oldest").Inc()
+	d.logf(event.path, "Upload queue full, evicted oldest entry: %s", event.path)
+	d.recordStage(event.path, filestate.StageDropped, ErrQueueFull)
+	if d.onDropped != nil {
+		d.onDropped(event.path)
+	}
+}
+
+// Cancel marks all queued entries for a file as cancelled so workers skip them.
+// Returns false if the file is not waiting in the queue.
+func (d *Dispatcher) Cancel(filePath string) bool {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	n := d.pending[filePath]
+	if n == 0 {
+		return false
+	}
+	d.cancelled[filePath] = n
+	d.logf(filePath, "Cancelled queued upload: %s", filePath)
+	return true
+}
+
+// trackPending adjusts the number of queued entries for a file
+func (d *Dispatcher) trackPending(filePath string, delta int) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	d.pending[filePath] += delta
+	if d.pending[filePath] <= 0 {
+		delete(d.pending, filePath)
+	}
+}
+
+// dequeue records that a worker took a queued entry for a file.
+// Returns true if the entry was cancelled and should be skipped.
+func (d *Dispatcher) dequeue(filePath string) bool {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	if d.pending[filePath]--; d.pending[filePath] <= 0 {
+		delete(d.pending, filePath)
+	}
+	if d.cancelled[filePath] == 0 {
+		return false
+	}
+	if d.cancelled[filePath]--; d.cancelled[filePath] == 0 {
+		delete(d.cancelled, filePath)
+	}
+	return true
+}
+
+// handleRemoved cleans up after a file that was deleted before its upload started
+func (d *Dispatcher) handleRemoved(id int, filePath string) {
+	d.logf(filePath, "Worker %d: %s was deleted before upload, skipping", id, filePath)
+	d.journal.Record(journal.Event{Directory: d.name, Path: filePath, Outcome: journal.OutcomeRemoved})
+	d.recordStage(filePath, filestate.StageRemoved, nil)
+	d.forget(filePath)
+	d.sla.done(filePath)
+
+	if d.onRemoved != nil {
+		d.onRemoved(filePath)
+	}
+
+	if d.uploader.config.PropagateDeletes {
+		if err := d.uploader.NotifyDelete(d.ctx, filePath); err != nil {
+			d.logf(filePath, "Worker %d: failed to propagate deletion of %s: %v", id, filePath, err)
+		} else {
+			d.logf(filePath, "Worker %d: propagated deletion of %s", id, filePath)
+		}
+	}
+	transferid.Forget(filePath)
 }
 
 // worker processes files from the queue
-func (d *Dispatcher) worker(id int) {
-	defer d.wg.Done()
+func (d *Dispatcher) worker(id int, slot *workerSlot) {
 	log.Printf("Upload worker %d started", id)
+	queue := d.queues[id%len(d.queues)]
 
 	for {
+		event, ok := d.next(queue)
+		if !ok {
+			log.Printf("Upload worker %d stopped", id)
+			d.wg.Done()
+			return
+		}
+
+		// Wait for a slot under the adaptive limit, then under the global worker cap
+		if !d.uploader.adaptive.acquire(d.ctx) {
+			log.Printf("Upload worker %d stopped", id)
+			d.wg.Done()
+			return
+		}
+		if !d.limit.acquire(d.ctx) {
+			d.uploader.adaptive.release()
+			log.Printf("Upload worker %d stopped", id)
+			d.wg.Done()
+			return
+		}
+		d.run(id, event, slot)
+		if slot.finish() {
+			// The watchdog already released this worker and started a replacement
+			d.logf(event.path, "Upload worker %d: stuck upload of %s returned, exiting replaced worker", id, event.path)
+			return
+		}
+		d.uploader.adaptive.release()
+		d.limit.release()
+	}
+}
+
+// run delivers a queued file. With ordered delivery a failed upload is retried
+// until it succeeds so that later files for the same key cannot overtake it.
+// The watchdog times each attempt on its own; the worker counts as idle while
+// it waits to retry, so a long outage does not get it replaced.
+func (d *Dispatcher) run(id int, event fileEvent, slot *workerSlot) {
+	d.active.Add(1)
+	defer d.active.Add(-1)
+
+	if d.dequeue(event.path) {
+		d.handleRemoved(id, event.path)
+		return
+	}
+
+	backoff := time.Second
+	for {
+		slot.begin(event.path)
+		if d.attempt(id, event) == nil {
+			d.forget(event.path)
+			transferid.Forget(event.path)
+			return
+		}
+		if d.orderKey == nil {
+			return // failed files stay recorded in the queue state
+		}
+		if slot.finish() {
+			return // replaced while the attempt hung; the replacement owns the queue
+		}
+		d.logf(event.path, "Worker %d: retrying %s in %v to preserve delivery order", id, event.path, backoff)
 		select {
 		case <-d.ctx.Done():
-			log.Printf("Upload worker %d stopped", id)
 			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxOrderedRetryBackoff)
+	}
+}
 
-		case event, ok := <-d.workQueue:
-			if !ok {
-				log.Printf("Upload worker %d stopped (queue closed)", id)
-				return
-			}
+// attempt processes a file once, cancelling it if it exceeds the upload deadline
+func (d *Dispatcher) attempt(id int, event fileEvent) error {
+	ctx, cancel := d.uploadContext()
+	defer cancel()
 
-			filePath := event.path
+	started := time.Now().UTC()
+	err := d.process(ctx, id, event)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		d.logf(event.path, "Worker %d: %s exceeded the upload deadline of %v", id, event.path, d.deadline)
+		stuckUploads.With(d.name, "cancelled").Inc()
+	}
+	if err != nil {
+		d.journal.Record(journal.Event{Directory: d.name, Path: event.path, Outcome: journal.OutcomeFailed, Error: err.Error()})
+		d.transfers.Record(history.Entry{Started: started, Directory: d.name, Path: event.path, Status: history.StatusFailed, Error: err.Error()})
+		d.recordStage(event.path, filestate.StageFailed, err)
+	}
+	return err
+}
 
-			// Upload the file (use streaming for large files)
-			fileInfo, err := os.Stat(filePath)
-			if err != nil {
-				log.Printf("Worker %d: failed to stat %s: %v", id, filePath, err)
-				continue
-			}
+// process uploads a single queued file. Returns an error only if the upload failed.
+func (d *Dispatcher) process(ctx context.Context, id int, event fileEvent) error {
+	if event.batch != nil {
+		return d.processBatch(ctx, id, event)
+	}
+	filePath := event.path
+	started := time.Now().UTC()
+
+	// Upload the file (use streaming for large files)
+	fileInfo, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		d.handleRemoved(id, filePath)
+		return nil
+	}
+	if err != nil {
+		d.logf(filePath, "Worker %d: failed to stat %s: %v", id, filePath, err)
+		return nil
+	}
 
-			// Use streaming for files larger than 100MB
-			if fileInfo.Size() > 100*1024*1024 {
-				err = d.uploader.UploadStream(d.ctx, filePath)
-			} else {
-				err = d.uploader.Upload(d.ctx, filePath)
+	// With versioning, files already delivered with the same content are skipped
+	var fingerprint deliveryRecord
+	var version int
+	if d.history != nil {
+		fingerprint, err = d.history.fingerprint(filePath)
+		if err != nil {
+			d.logf(filePath, "Worker %d: failed to fingerprint %s: %v", id, filePath, err)
+			return nil
+		}
+		version = d.history.nextVersion(filePath, fingerprint)
+		if version == 0 {
+			d.logf(filePath, "Worker %d: %s unchanged since last delivery, skipping", id, filePath)
+			d.recordStage(filePath, filestate.StageSkipped, nil)
+			d.sla.done(filePath)
+			if d.onSuccessfulUpload != nil {
+				d.onSuccessfulUpload(filePath)
 			}
+			return nil
+		}
+	}
 
+	// With duplicate suppression, content uploaded within the window is not sent again
+	var contentHash string
+	if d.dedup != nil {
+		contentHash = fingerprint.Hash
+		if contentHash == "" {
+			contentHash, err = hashFile(filePath)
 			if err != nil {
-				log.Printf("Worker %d: upload failed for %s: %v", id, filePath, err)
-			} else {
-				log.Printf("Worker %d: upload completed: %s", id, filePath)
+				d.logf(filePath, "Worker %d: failed to hash %s: %v", id, filePath, err)
+				return nil
+			}
+		}
+		if d.dedup.seen(contentHash, time.Now()) {
+			d.logf(filePath, "Worker %d: %s is identical to a recent upload, skipping", id, filePath)
+			d.recordStage(filePath, filestate.StageSkipped, nil)
+			d.sla.done(filePath)
+			duplicatesSkipped.With(d.name).Inc()
+			if d.onSuccessfulUpload != nil {
+				d.onSuccessfulUpload(filePath)
+			}
+			if !event.processedDueToTimeout {
+				d.removeSource(id, filePath, fileInfo)
+			}
+			return nil
+		}
+	}
 
-				// Call success callback if provided
-				if d.onSuccessfulUpload != nil {
-					d.onSuccessfulUpload(filePath)
-				}
+	// Nothing leaves unscanned when a scanner is configured
+	if ok, err := d.scanFile(ctx, id, filePath, fileInfo); !ok {
+		return err
+	}
 
-				// If file was processed due to timeout, it may still be writing - don't delete
-				if event.processedDueToTimeout {
-					log.Printf("Worker %d: keeping source file %s (processed due to stability timeout)", id, filePath)
-					continue
-				}
+	// The shadow copy is written from the same read pass as the upload
+	var shadowCopy *shadow.Copy
+	var shadowErr error
+	opts := uploadOptions{}
+	if d.uploader.config.Versioning.Enabled {
+		opts.version = version // read_only directories track deliveries without versioning them
+	}
+	if d.uploader.config.IdempotencyKey.Enabled {
+		opts.idempotencyKey, err = idempotencyKey(d.uploader.config.IdempotencyKey, filePath, fileInfo,
+			cmp.Or(contentHash, fingerprint.Hash))
+		if err != nil {
+			d.logf(filePath, "Worker %d: failed to derive idempotency key for %s: %v", id, filePath, err)
+			return nil
+		}
+	}
+	if d.routes != nil {
+		route, detected, err := d.routes.match(filePath)
+		if err != nil {
+			d.logf(filePath, "Worker %d: failed to detect content type of %s: %v", id, filePath, err)
+			return nil
+		}
+		if route != nil {
+			d.logf(filePath, "Worker %d: routing %s by content type %s", id, filePath, detected)
+			opts.url = route
+		}
+	}
+	up := d.uploader
+	if route := d.route(filePath, fileInfo.Size()); route != nil {
+		d.logf(filePath, "Worker %d: routing %s by routes[%d]", id, filePath, route.index)
+		up = route.uploader
+	}
+	if d.sla.reroute(filePath) {
+		d.logf(filePath, "Worker %d: %s is overdue, skipping the primary destination", id, filePath)
+		opts.skipPrimary = true
+	}
+	if d.hook != nil {
+		opts.receipt = &receipt{}
+	}
+	if !event.processedDueToTimeout {
+		opts.link = true
+		shadowCopy, shadowErr = d.shadowManager.Begin(filePath)
+		if shadowCopy != nil {
+			opts.tee = shadowCopy
+		}
+	}
 
-				// Get file info before shadow copy for final stability check
-				info, err := os.Stat(filePath)
-				if err != nil {
-					log.Printf("Worker %d: failed to stat file before shadow copy %s: %v", id, filePath, err)
-					log.Printf("Worker %d: keeping source file due to stat failure", id)
-					continue
-				}
-				preShadowSize := info.Size()
-				preShadowModTime := info.ModTime()
+	// Files above the stream threshold are streamed
+	destination, err := up.upload(ctx, filePath, opts)
 
-				// Create shadow copy
-				if err := d.shadowManager.Store(filePath); err != nil {
-					log.Printf("Worker %d: failed to create shadow copy for %s: %v", id, filePath, err)
-					log.Printf("Worker %d: keeping source file due to shadow copy failure", id)
-					continue
-				}
+	if err != nil {
+		d.logf(filePath, "Worker %d: upload failed for %s: %v", id, filePath, err)
+		if shadowCopy != nil {
+			shadowCopy.Abort()
+		}
+		return err
+	}
 
-				// Final stability check before deletion
-				// If file changed during upload/shadow process, don't delete it
-				if info, err := os.Stat(filePath); err != nil {
-					log.Printf("Worker %d: file disappeared before deletion check: %s", id, filePath)
-				} else if info.Size() != preShadowSize || !info.ModTime().Equal(preShadowModTime) {
-					log.Printf("Worker %d: file changed during processing, keeping source: %s", id, filePath)
-					log.Printf("Worker %d: size before: %d, after: %d", id, preShadowSize, info.Size())
-				} else {
-					// File is still stable, safe to delete source
-					if err := os.Remove(filePath); err != nil {
-						log.Printf("Worker %d: failed to delete source file %s: %v", id, filePath, err)
-					} else {
-						log.Printf("Worker %d: deleted source file: %s", id, filePath)
-					}
-				}
-			}
+	d.logf(filePath, "Worker %d: upload completed: %s", id, filePath)
+	d.recordStage(filePath, filestate.StageUploaded, nil)
+	d.sla.done(filePath)
+	checksum := cmp.Or(contentHash, fingerprint.Hash)
+	if checksum == "" && (d.journal.Checksums() || d.transfers.Checksums()) {
+		if checksum, err = hashFile(filePath); err != nil {
+			d.logf(filePath, "Worker %d: failed to hash %s for the transfer record: %v", id, filePath, err)
+		}
+	}
+	d.journal.Record(journal.Event{Directory: d.name, Path: filePath, Size: fileInfo.Size(), Checksum: checksum,
+		Outcome: journal.OutcomeDelivered, Destination: destination})
+	d.transfers.Record(history.Entry{Started: started, Directory: d.name, Path: filePath, Size: fileInfo.Size(),
+		Checksum: checksum, Status: history.StatusDelivered, Destination: destination})
+
+	if d.history != nil {
+		d.history.record(filePath, fingerprint, version)
+		if version > 1 {
+			d.logf(filePath, "Worker %d: delivered %s as version %d", id, filePath, version)
+		}
+	}
+	if d.dedup != nil {
+		d.dedup.record(contentHash, time.Now())
+	}
+	if d.mirror != nil {
+		d.mirror.Forward(filePath)
+	}
+
+	// Call success callback if provided
+	if d.onSuccessfulUpload != nil {
+		d.onSuccessfulUpload(filePath)
+	}
+
+	// The post-upload hook sees the source before it is removed
+	if opts.receipt != nil {
+		opts.receipt.destination = cmp.Or(opts.receipt.destination, destination)
+	}
+	hookErr := d.runHook(ctx, id, filePath, fileInfo.Size(), checksum, opts.receipt)
+	if hookErr != nil {
+		d.logf(filePath, "Worker %d: post-upload hook failed for %s: %v", id, filePath, hookErr)
+	}
+
+	d.finish(id, event, fileInfo, shadowCopy, shadowErr, hookErr)
+	return nil
+}
+
+// finish stores the shadow copy of a delivered file and removes its source.
+// The source is kept if it may still be written or its shadow copy failed,
+// and quarantined if its post-upload hook failed and a quarantine path is set.
+func (d *Dispatcher) finish(id int, event fileEvent, fileInfo os.FileInfo, shadowCopy *shadow.Copy, shadowErr, hookErr error) {
+	filePath := event.path
+
+	// If file was processed due to timeout, it may still be writing - don't delete
+	if event.processedDueToTimeout {
+		d.logf(filePath, "Worker %d: keeping source file %s (processed due to stability timeout)", id, filePath)
+		return
+	}
+
+	if shadowCopy != nil {
+		shadowErr = shadowCopy.Commit()
+		if shadowErr == nil {
+			d.recordStage(filePath, filestate.StageShadowed, nil)
+		}
+	}
+	if shadowErr != nil {
+		d.logf(filePath, "Worker %d: failed to create shadow copy for %s: %v", id, filePath, shadowErr)
+		d.logf(filePath, "Worker %d: keeping source file due to shadow copy failure", id)
+		d.recordStage(filePath, filestate.StageShadowed, shadowErr)
+		return
+	}
+	if hookErr != nil && d.hookFailed(id, filePath, hookErr) {
+		return
+	}
+
+	d.removeSource(id, filePath, fileInfo)
+}
+
+// removeSource deletes a delivered source file, or moves or keeps it as
+// after_upload says, unless it changed since fileInfo was taken, in which
+// case it is still being written
+func (d *Dispatcher) removeSource(id int, filePath string, fileInfo os.FileInfo) {
+	if d.afterUpload == config.AfterUploadKeep {
+		d.logf(filePath, "Worker %d: keeping source file: %s", id, filePath)
+		return
+	}
+
+	// Final stability check before deletion
+	// If file changed since the upload started, don't delete it
+	if info, err := os.Stat(filePath); err != nil {
+		d.logf(filePath, "Worker %d: file disappeared before deletion check: %s", id, filePath)
+	} else if info.Size() != fileInfo.Size() || !info.ModTime().Equal(fileInfo.ModTime()) {
+		d.logf(filePath, "Worker %d: file changed during processing, keeping source: %s", id, filePath)
+		d.logf(filePath, "Worker %d: size before: %d, after: %d", id, fileInfo.Size(), info.Size())
+	} else if d.afterUpload == config.AfterUploadMove {
+		d.moveProcessed(id, filePath)
+	} else {
+		// File is still stable, safe to delete source
+		if err := os.Remove(filePath); err != nil {
+			d.logf(filePath, "Worker %d: failed to delete source file %s: %v", id, filePath, err)
+		} else {
+			d.logf(filePath, "Worker %d: deleted source file: %s", id, filePath)
+			d.recordStage(filePath, filestate.StageDeleted, nil)
 		}
 	}
 }
@@ -1,20 +1,40 @@
 package uploader
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/filestate"
+	"github.com/muzy/xferd/internal/quarantine"
+	"github.com/muzy/xferd/internal/scan"
+	"github.com/muzy/xferd/internal/shadow"
+	"github.com/muzy/xferd/internal/storage"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // SA1019
 	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
-
-	"github.com/muzy/xferd/internal/config"
-	"github.com/muzy/xferd/internal/shadow"
 )
 
 func TestNewUploader(t *testing.T) {
@@ -390,7 +410,7 @@ func TestNewDispatcher(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 4)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 4, 100)
 
 	if dispatcher == nil {
 		t.Fatal("Expected non-nil dispatcher")
@@ -399,6 +419,10 @@ func TestNewDispatcher(t *testing.T) {
 	if dispatcher.maxWorkers != 4 {
 		t.Errorf("Expected 4 workers, got %d", dispatcher.maxWorkers)
 	}
+
+	if cap(dispatcher.queues[0]) != 100 {
+		t.Errorf("Expected queue size 100, got %d", cap(dispatcher.queues[0]))
+	}
 }
 
 func TestDispatcherStartStop(t *testing.T) {
@@ -415,7 +439,7 @@ func TestDispatcherStartStop(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 2)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 2, 100)
 
 	ctx := context.Background()
 	dispatcher.Start(ctx)
@@ -468,7 +492,7 @@ func TestDispatcherEnqueue(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 2)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 2, 100)
 	ctx := context.Background()
 	dispatcher.Start(ctx)
 	defer dispatcher.Stop()
@@ -538,7 +562,7 @@ func TestDispatcherMultipleFiles(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 3)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 3, 100)
 	ctx := context.Background()
 	dispatcher.Start(ctx)
 	defer dispatcher.Stop()
@@ -600,7 +624,7 @@ func TestDispatcherWithShadow(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 2)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 2, 100)
 	ctx := context.Background()
 	dispatcher.Start(ctx)
 	defer dispatcher.Stop()
@@ -616,14 +640,24 @@ func TestDispatcherWithShadow(t *testing.T) {
 		t.Error("Source file should have been deleted after upload")
 	}
 
-	// Verify shadow copy exists
+	// Verify shadow copy exists, next to the manifest listing it
 	files, err := os.ReadDir(shadowPath)
 	if err != nil {
 		t.Fatalf("Failed to read shadow directory: %v", err)
 	}
+	files = slices.DeleteFunc(files, func(e os.DirEntry) bool { return e.Name() == shadow.ManifestName })
 
 	if len(files) != 1 {
-		t.Errorf("Expected 1 shadow file, got %d", len(files))
+		t.Fatalf("Expected 1 shadow file, got %d", len(files))
+	}
+
+	// Shadow copy is written from the upload stream
+	content, err := os.ReadFile(filepath.Join(shadowPath, files[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read shadow file: %v", err)
+	}
+	if string(content) != "content" {
+		t.Errorf("Shadow file content mismatch. Expected 'content', got '%s'", string(content))
 	}
 }
 
@@ -654,7 +688,7 @@ func TestDispatcherUploadFailure(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 1)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
 	ctx := context.Background()
 	dispatcher.Start(ctx)
 	defer dispatcher.Stop()
@@ -711,7 +745,7 @@ func TestDispatcherLargeFileStreaming(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 1)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
 	ctx := context.Background()
 	dispatcher.Start(ctx)
 	defer dispatcher.Stop()
@@ -739,7 +773,7 @@ func TestDispatcherContextCancellation(t *testing.T) {
 		t.Fatalf("Failed to create shadow manager: %v", err)
 	}
 
-	dispatcher := NewDispatcher(cfg, shadowMgr, 2)
+	dispatcher := NewDispatcher(cfg, shadowMgr, 2, 100)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	dispatcher.Start(ctx)
@@ -805,3 +839,3652 @@ func TestUploaderTimeout(t *testing.T) {
 		t.Logf("Error: %v", err)
 	}
 }
+
+func TestWarmUp(t *testing.T) {
+	var headRequests int
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			headRequests++
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{URL: server.URL})
+
+	// Any HTTP response means the connection is established
+	if err := uploader.WarmUp(context.Background()); err != nil {
+		t.Fatalf("WarmUp failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if headRequests != 1 {
+		t.Errorf("Expected 1 HEAD request, got %d", headRequests)
+	}
+}
+
+func TestWarmUpUnreachable(t *testing.T) {
+	uploader := NewUploader(config.OutboundConfig{URL: "http://127.0.0.1:1/upload"})
+
+	if err := uploader.WarmUp(context.Background()); err == nil {
+		t.Error("Expected error for unreachable destination")
+	}
+}
+
+func TestRefreshDNS(t *testing.T) {
+	uploader := NewUploader(config.OutboundConfig{URL: "http://127.0.0.1:8080/upload"})
+
+	// First resolution only records the addresses
+	changed, err := uploader.RefreshDNS(context.Background())
+	if err != nil {
+		t.Fatalf("RefreshDNS failed: %v", err)
+	}
+	if changed {
+		t.Error("Expected no change on first resolution")
+	}
+
+	// Simulate a previous resolution pointing elsewhere
+	uploader.resolvedAddrs["127.0.0.1"] = []string{"10.0.0.1"}
+	transport := uploader.transport.Load()
+
+	changed, err = uploader.RefreshDNS(context.Background())
+	if err != nil {
+		t.Fatalf("RefreshDNS failed: %v", err)
+	}
+	if !changed {
+		t.Error("Expected change to be detected")
+	}
+	if uploader.transport.Load() == transport {
+		t.Error("Expected the transport to be replaced")
+	}
+
+	changed, err = uploader.RefreshDNS(context.Background())
+	if err != nil {
+		t.Fatalf("RefreshDNS failed: %v", err)
+	}
+	if changed {
+		t.Error("Expected no change when addresses are identical")
+	}
+}
+
+func TestRefreshDNSFailover(t *testing.T) {
+	uploader := NewUploader(config.OutboundConfig{
+		URL:      "http://127.0.0.1:8080/upload",
+		Failover: config.FailoverConfig{URLs: []string{"http://localhost:8081/{{.Filename}}"}},
+	})
+	if _, err := uploader.RefreshDNS(context.Background()); err != nil {
+		t.Fatalf("RefreshDNS failed: %v", err)
+	}
+	if _, ok := uploader.resolvedAddrs["localhost"]; !ok {
+		t.Fatalf("Expected the failover host to be resolved, got %v", uploader.resolvedAddrs)
+	}
+
+	// A failover destination moving replaces the connections too
+	uploader.resolvedAddrs["localhost"] = []string{"10.0.0.1"}
+	if changed, err := uploader.RefreshDNS(context.Background()); err != nil || !changed {
+		t.Errorf("Expected the failover change to be detected, got %v (%v)", changed, err)
+	}
+}
+
+func TestRefreshDNSRetiresInFlightConnections(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	closed := make(chan struct{}, 4)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL: server.URL,
+		TLS: config.OutboundTLSConfig{CAFile: writeServerCA(t, server, t.TempDir())},
+	})
+	if _, err := uploader.RefreshDNS(context.Background()); err != nil {
+		t.Fatalf("RefreshDNS failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := uploader.client.Get(server.URL + "/slow")
+		if err == nil {
+			if resp.ProtoMajor != 2 {
+				err = fmt.Errorf("expected HTTP/2, got %s", resp.Proto)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	<-entered
+
+	// The address changes while the upload is running
+	uploader.resolvedAddrs["127.0.0.1"] = []string{"10.0.0.1"}
+	if changed, err := uploader.RefreshDNS(context.Background()); err != nil || !changed {
+		t.Fatalf("Expected a change, got %v (%v)", changed, err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Expected the connection in use to stay open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the old connection to be closed once its request finished")
+	}
+}
+
+func TestDispatcherConnectionWarmUp(t *testing.T) {
+	warmedUp := make(chan struct{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			select {
+			case warmedUp <- struct{}{}:
+			default:
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.OutboundConfig{
+		URL: server.URL,
+		Connection: config.ConnectionConfig{
+			WarmUp:            true,
+			DNSRefreshSeconds: 1,
+		},
+	}
+
+	shadowMgr, _ := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	select {
+	case <-warmedUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected warm-up request on dispatcher start")
+	}
+}
+
+func TestUploadWithIPFamily(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// httptest listens on IPv4 loopback, so forcing IPv4 must succeed
+	uploader := NewUploader(config.OutboundConfig{
+		URL:        server.URL,
+		Connection: config.ConnectionConfig{IPFamily: "ipv4"},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload over IPv4 failed: %v", err)
+	}
+
+	// Forcing IPv6 against an IPv4 literal cannot connect
+	uploader = NewUploader(config.OutboundConfig{
+		URL:        server.URL,
+		Connection: config.ConnectionConfig{IPFamily: "ipv6"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := uploader.Upload(ctx, testFile); err == nil {
+		t.Error("Expected IPv6-only upload to an IPv4 address to fail")
+	}
+}
+
+func TestDispatcherCancel(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	requests := make(chan *http.Request, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	// Start without workers so the file stays queued until cancelled
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL, PropagateDeletes: true}, shadowMgr, 0, 100)
+	removed := make(chan string, 1)
+	dispatcher.SetOnRemoved(func(path string) { removed <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	if dispatcher.Cancel(testFile) {
+		t.Error("Expected Cancel to return false for a file that is not queued")
+	}
+
+	dispatcher.Enqueue(testFile, false)
+	if !dispatcher.Cancel(testFile) {
+		t.Fatal("Expected Cancel to return true for a queued file")
+	}
+
+	dispatcher.wg.Add(1)
+	go dispatcher.worker(0, nil)
+
+	select {
+	case path := <-removed:
+		if path != testFile {
+			t.Errorf("Expected removed callback for %s, got %s", testFile, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Removed callback not called within timeout")
+	}
+
+	select {
+	case r := <-requests:
+		if r.Method != http.MethodDelete {
+			t.Errorf("Expected DELETE request, got %s", r.Method)
+		}
+		if r.Header.Get("X-Filename") != "test.txt" {
+			t.Errorf("Expected X-Filename test.txt, got %s", r.Header.Get("X-Filename"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deletion notice not received within timeout")
+	}
+
+	// Cancelled files are not uploaded and are left untouched
+	if _, err := os.Stat(testFile); err != nil {
+		t.Errorf("Expected source file to remain: %v", err)
+	}
+}
+
+func TestDispatcherFileDeletedBeforeUpload(t *testing.T) {
+	tmpDir := t.TempDir()
+	missingFile := filepath.Join(tmpDir, "missing.txt")
+
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+	removed := make(chan string, 1)
+	dispatcher.SetOnRemoved(func(path string) { removed <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	dispatcher.Enqueue(missingFile, false)
+
+	select {
+	case path := <-removed:
+		if path != missingFile {
+			t.Errorf("Expected removed callback for %s, got %s", missingFile, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Removed callback not called within timeout")
+	}
+
+	// Deletions are not propagated unless enabled
+	if n := atomic.LoadInt32(&requestCount); n != 0 {
+		t.Errorf("Expected no requests, got %d", n)
+	}
+}
+
+func TestDispatcherVersioning(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "daily.csv")
+	stateFile := filepath.Join(tmpDir, "history.json")
+
+	versions := make(chan string, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions <- r.Header.Get("X-File-Version")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	cfg := config.OutboundConfig{
+		URL:        server.URL,
+		Versioning: config.VersioningConfig{Enabled: true, Detect: "hash", StateFile: stateFile},
+	}
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
+	handled := make(chan string, 3)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { handled <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	deliver := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		dispatcher.Enqueue(testFile, true) // keep the source file in place
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("File not handled within timeout")
+		}
+	}
+
+	deliver("day 1")
+	deliver("day 1") // identical content is not re-delivered
+	deliver("day 2")
+
+	for _, expected := range []string{"1", "2"} {
+		select {
+		case got := <-versions:
+			if got != expected {
+				t.Errorf("Expected X-File-Version %s, got %q", expected, got)
+			}
+		default:
+			t.Fatalf("Expected delivery of version %s", expected)
+		}
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected unchanged file to be skipped, got %d extra deliveries", len(versions))
+	}
+
+	// History survives a restart
+	history := newDeliveryHistory(cfg.Versioning)
+	fp, err := history.fingerprint(testFile)
+	if err != nil {
+		t.Fatalf("Failed to fingerprint file: %v", err)
+	}
+	if v := history.nextVersion(testFile, fp); v != 0 {
+		t.Errorf("Expected persisted history to recognise delivered content, got version %d", v)
+	}
+	if err := os.WriteFile(testFile, []byte("day 3"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	fp, _ = history.fingerprint(testFile)
+	if v := history.nextVersion(testFile, fp); v != 3 {
+		t.Errorf("Expected version 3, got %d", v)
+	}
+}
+
+func TestDeliveryHistorySizeMtime(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "daily.csv")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	history := newDeliveryHistory(config.VersioningConfig{Enabled: true})
+	fp, err := history.fingerprint(testFile)
+	if err != nil {
+		t.Fatalf("Failed to fingerprint file: %v", err)
+	}
+	if fp.Hash != "" {
+		t.Error("Expected no hash for size_mtime detection")
+	}
+	history.record(testFile, fp, history.nextVersion(testFile, fp))
+
+	// Touching the file counts as a change
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(testFile, later, later); err != nil {
+		t.Fatalf("Failed to change mtime: %v", err)
+	}
+	fp, _ = history.fingerprint(testFile)
+	if v := history.nextVersion(testFile, fp); v != 2 {
+		t.Errorf("Expected version 2 after mtime change, got %d", v)
+	}
+}
+
+func TestDispatcherShadowDiscardedOnUploadFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	shadowPath := filepath.Join(tmpDir, "shadow")
+
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Client errors are not retried
+	requests := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusBadRequest)
+		requests <- struct{}{}
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: true, Path: shadowPath, RetentionHours: 24})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+	dispatcher.Start(context.Background())
+
+	dispatcher.Enqueue(testFile, false)
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upload not received within timeout")
+	}
+	dispatcher.Stop()
+
+	files, err := os.ReadDir(shadowPath)
+	if err != nil {
+		t.Fatalf("Failed to read shadow directory: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no shadow files after failed upload, got %d", len(files))
+	}
+	if _, err := os.Stat(testFile); err != nil {
+		t.Errorf("Expected source file to be kept after failed upload: %v", err)
+	}
+}
+
+func TestUploadStreamThreshold(t *testing.T) {
+	tmpDir := t.TempDir()
+	smallFile := filepath.Join(tmpDir, "small.txt")
+	largeFile := filepath.Join(tmpDir, "large.txt")
+	if err := os.WriteFile(smallFile, bytes.Repeat([]byte("s"), 50), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(largeFile, bytes.Repeat([]byte("l"), 500), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	type received struct {
+		contentLength int64
+		size          int
+	}
+	requests := make(chan received, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Failed to get file: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		requests <- received{contentLength: r.ContentLength, size: len(data)}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{URL: server.URL, StreamThresholdBytes: 100})
+
+	if err := uploader.Upload(context.Background(), smallFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := <-requests; got.contentLength <= 0 || got.size != 50 {
+		t.Errorf("Expected buffered upload with Content-Length, got %+v", got)
+	}
+
+	if err := uploader.Upload(context.Background(), largeFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := <-requests; got.contentLength != -1 || got.size != 500 {
+		t.Errorf("Expected streamed upload without Content-Length, got %+v", got)
+	}
+}
+
+func TestWorkerLimit(t *testing.T) {
+	if NewWorkerLimit(0) != nil {
+		t.Error("Expected nil limit for 0")
+	}
+
+	// A nil limit never blocks
+	var unlimited *WorkerLimit
+	if !unlimited.acquire(context.Background()) {
+		t.Error("Expected nil limit to acquire")
+	}
+	unlimited.release()
+
+	limit := NewWorkerLimit(1)
+	if !limit.acquire(context.Background()) {
+		t.Fatal("Expected first acquire to succeed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if limit.acquire(ctx) {
+		t.Error("Expected acquire to fail while the slot is taken")
+	}
+
+	limit.release()
+	if !limit.acquire(context.Background()) {
+		t.Error("Expected acquire to succeed after release")
+	}
+}
+
+func TestDispatcherSharedWorkerLimit(t *testing.T) {
+	var active, peak, uploads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+		uploads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	// Two directories with several workers each, capped to one upload globally
+	limit := NewWorkerLimit(1)
+	tmpDir := t.TempDir()
+	var dispatchers []*Dispatcher
+	for i := 0; i < 2; i++ {
+		d := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 3, 10)
+		d.SetWorkerLimit(limit)
+		d.Start(context.Background())
+		defer d.Stop()
+		dispatchers = append(dispatchers, d)
+	}
+
+	for i := 0; i < 6; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		dispatchers[i%2].Enqueue(path, false)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for uploads.Load() < 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := uploads.Load(); got != 6 {
+		t.Fatalf("Expected 6 uploads, got %d", got)
+	}
+	if got := peak.Load(); got != 1 {
+		t.Errorf("Expected at most 1 concurrent upload, got %d", got)
+	}
+}
+
+// newStalledDispatcher starts a dispatcher without workers so enqueued files stay queued
+func newStalledDispatcher(t *testing.T, name string, queueSize int, overflow config.QueueOverflow) *Dispatcher {
+	t.Helper()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: "https://example.com/upload"}, shadowMgr, 0, queueSize)
+	dispatcher.SetName(name)
+	dispatcher.SetQueueOverflow(overflow)
+	dispatcher.Start(context.Background())
+	t.Cleanup(dispatcher.Stop)
+	return dispatcher
+}
+
+func TestEnqueueDropNewest(t *testing.T) {
+	dispatcher := newStalledDispatcher(t, "overflow-drop-newest", 1, config.QueueOverflow{})
+
+	if err := dispatcher.Enqueue("/data/a.txt", false); err != nil {
+		t.Fatalf("Expected first enqueue to succeed, got %v", err)
+	}
+	if err := dispatcher.Enqueue("/data/b.txt", false); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+
+	if event := <-dispatcher.queues[0]; event.path != "/data/a.txt" {
+		t.Errorf("Expected queued file /data/a.txt, got %s", event.path)
+	}
+	if got := queueOverflows.With("overflow-drop-newest", "dropped_newest").Value(); got != 1 {
+		t.Errorf("Expected 1 dropped_newest overflow, got %d", got)
+	}
+}
+
+func TestEnqueueDropOldest(t *testing.T) {
+	dispatcher := newStalledDispatcher(t, "overflow-drop-oldest", 1,
+		config.QueueOverflow{Policy: config.OverflowDropOldest})
+
+	dropped := make(chan string, 1)
+	dispatcher.SetOnDropped(func(path string) { dropped <- path })
+
+	if err := dispatcher.Enqueue("/data/a.txt", false); err != nil {
+		t.Fatalf("Expected first enqueue to succeed, got %v", err)
+	}
+	if err := dispatcher.Enqueue("/data/b.txt", false); err != nil {
+		t.Fatalf("Expected second enqueue to evict the oldest entry, got %v", err)
+	}
+
+	select {
+	case path := <-dropped:
+		if path != "/data/a.txt" {
+			t.Errorf("Expected /data/a.txt to be dropped, got %s", path)
+		}
+	default:
+		t.Error("Expected dropped callback to be called")
+	}
+
+	if event := <-dispatcher.queues[0]; event.path != "/data/b.txt" {
+		t.Errorf("Expected queued file /data/b.txt, got %s", event.path)
+	}
+	if dispatcher.Cancel("/data/a.txt") {
+		t.Error("Expected evicted file to no longer be pending")
+	}
+	if got := queueOverflows.With("overflow-drop-oldest", "dropped_oldest").Value(); got != 1 {
+		t.Errorf("Expected 1 dropped_oldest overflow, got %d", got)
+	}
+}
+
+func TestEnqueueBlock(t *testing.T) {
+	dispatcher := newStalledDispatcher(t, "overflow-block", 1,
+		config.QueueOverflow{Policy: config.OverflowBlock, BlockTimeoutMs: 50})
+
+	if err := dispatcher.Enqueue("/data/a.txt", false); err != nil {
+		t.Fatalf("Expected first enqueue to succeed, got %v", err)
+	}
+
+	// Nothing drains the queue, so the enqueue times out
+	start := time.Now()
+	if err := dispatcher.Enqueue("/data/b.txt", false); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull after timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected enqueue to wait for the timeout, returned after %v", elapsed)
+	}
+
+	// Space frees up while waiting, so the enqueue succeeds
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-dispatcher.queues[0]
+	}()
+	if err := dispatcher.Enqueue("/data/c.txt", false); err != nil {
+		t.Errorf("Expected enqueue to succeed once space was available, got %v", err)
+	}
+
+	if got := queueOverflows.With("overflow-block", "timeout").Value(); got != 1 {
+		t.Errorf("Expected 1 timeout overflow, got %d", got)
+	}
+	if got := queueOverflows.With("overflow-block", "blocked").Value(); got != 1 {
+		t.Errorf("Expected 1 blocked overflow, got %d", got)
+	}
+}
+
+func TestUploadTwoPhaseCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var committed atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/upload":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"upload_id": "id 42", "status": "pending"}`))
+		case "/upload/id%2042/commit":
+			if r.Method != http.MethodPost {
+				t.Errorf("Expected POST commit, got %s", r.Method)
+			}
+			if got := r.Header.Get("X-Upload-ID"); got != "id 42" {
+				t.Errorf("Expected X-Upload-ID id 42, got %q", got)
+			}
+			committed.Add(1)
+		default:
+			t.Errorf("Unexpected request path %s", r.URL.EscapedPath())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL:    server.URL + "/upload",
+		Commit: config.CommitConfig{Enabled: true},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := committed.Load(); got != 1 {
+		t.Errorf("Expected 1 commit, got %d", got)
+	}
+}
+
+func TestUploadCommitIDFromHeader(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	commits := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/commit/") {
+			commits <- strings.TrimPrefix(r.URL.Path, "/commit/")
+			return
+		}
+		w.Header().Set("X-Upload-ID", "abc123")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL:    server.URL,
+		Commit: config.CommitConfig{Enabled: true, URL: server.URL + "/commit/{upload_id}"},
+	})
+	if err := uploader.UploadStream(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	select {
+	case id := <-commits:
+		if id != "abc123" {
+			t.Errorf("Expected commit for abc123, got %s", id)
+		}
+	default:
+		t.Error("Expected upload to be committed")
+	}
+}
+
+func TestUploadCommitMissingID(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{URL: server.URL, Commit: config.CommitConfig{Enabled: true}})
+	err := uploader.Upload(context.Background(), testFile)
+	if err == nil || !strings.Contains(err.Error(), "upload ID") {
+		t.Errorf("Expected missing upload ID error, got %v", err)
+	}
+}
+
+func TestDispatcherKeepsSourceWhenCommitFails(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	commitAttempted := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/commit") {
+			commitAttempted <- struct{}{}
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		_, _ = w.Write([]byte(`{"upload_id": 7}`))
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	cfg := config.OutboundConfig{URL: server.URL, Commit: config.CommitConfig{Enabled: true}}
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
+	uploaded := make(chan string, 1)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { uploaded <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	dispatcher.Enqueue(testFile, false)
+
+	select {
+	case <-commitAttempted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Commit not attempted within timeout")
+	}
+
+	select {
+	case <-uploaded:
+		t.Error("Expected delivery not to succeed when the commit fails")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := os.Stat(testFile); err != nil {
+		t.Errorf("Expected source file to be kept when the commit fails: %v", err)
+	}
+}
+
+func TestDispatcherOrderedDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Failed to get form file: %v", err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// Reject the second file once; later files must wait for its retry
+		if header.Filename == "2.txt" && !failed {
+			failed = true
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delivered = append(delivered, header.Filename)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 4, 100)
+	dispatcher.SetOrdering(config.OrderingKeyDirectory, tmpDir)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	for i := 1; i <= 5; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("%d.txt", i))
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		dispatcher.Enqueue(path, false)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(delivered)
+		mu.Unlock()
+		if n == 5 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"}
+	if strings.Join(delivered, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected delivery order %v, got %v", expected, delivered)
+	}
+}
+
+func TestSetOrdering(t *testing.T) {
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	root := filepath.Join(string(filepath.Separator), "data")
+
+	// A single ordered stream uses one worker and the full queue
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: "https://example.com/upload"}, shadowMgr, 4, 100)
+	dispatcher.SetOrdering(config.OrderingKeyDirectory, root)
+	if dispatcher.maxWorkers != 1 {
+		t.Errorf("Expected 1 worker for directory ordering, got %d", dispatcher.maxWorkers)
+	}
+	if len(dispatcher.queues) != 1 || cap(dispatcher.queues[0]) != 100 {
+		t.Errorf("Expected a single queue of 100, got %d queues", len(dispatcher.queues))
+	}
+
+	// Subdirectory ordering spreads keys across per-worker queues
+	dispatcher = NewDispatcher(config.OutboundConfig{URL: "https://example.com/upload"}, shadowMgr, 4, 100)
+	dispatcher.SetOrdering(config.OrderingKeySubdirectory, root)
+	if len(dispatcher.queues) != 4 {
+		t.Fatalf("Expected 4 queues, got %d", len(dispatcher.queues))
+	}
+	if cap(dispatcher.queues[0]) != 25 {
+		t.Errorf("Expected per-worker queue size 25, got %d", cap(dispatcher.queues[0]))
+	}
+
+	a1 := filepath.Join(root, "a", "1.txt")
+	a2 := filepath.Join(root, "a", "2.txt")
+	if got := dispatcher.orderKey(a1); got != "a" {
+		t.Errorf("Expected ordering key a, got %s", got)
+	}
+	if got := dispatcher.orderKey(filepath.Join(root, "top.txt")); got != "." {
+		t.Errorf("Expected ordering key . for top-level files, got %s", got)
+	}
+	if dispatcher.orderedQueue(a1) != dispatcher.orderedQueue(a2) {
+		t.Error("Expected files in the same subdirectory to share a queue")
+	}
+}
+
+func TestDispatcherPriorities(t *testing.T) {
+	dispatcher := newStalledDispatcher(t, "priorities", 10, config.QueueOverflow{})
+	dispatcher.EnablePriorities()
+
+	files := []struct{ path, priority string }{
+		{"/data/payload.iso", config.PriorityLow},
+		{"/data/invoice.pdf", config.PriorityNormal},
+		{"/data/batch.ctl", config.PriorityHigh},
+		{"/data/report.csv", ""},
+	}
+	for _, f := range files {
+		if err := dispatcher.EnqueueWithPriority(f.path, false, f.priority); err != nil {
+			t.Fatalf("Failed to enqueue %s: %v", f.path, err)
+		}
+	}
+
+	// High first, then normal in enqueue order, low last
+	expected := []string{"/data/batch.ctl", "/data/invoice.pdf", "/data/report.csv", "/data/payload.iso"}
+	for _, want := range expected {
+		event, ok := dispatcher.next(dispatcher.queues[0])
+		if !ok {
+			t.Fatal("Expected a queued file")
+		}
+		if event.path != want {
+			t.Errorf("Expected %s next, got %s", want, event.path)
+		}
+	}
+}
+
+func TestDispatcherPrioritiesDisabled(t *testing.T) {
+	dispatcher := newStalledDispatcher(t, "priorities-disabled", 10, config.QueueOverflow{})
+
+	// Without priority queues every class shares the normal queue
+	if err := dispatcher.EnqueueWithPriority("/data/payload.iso", false, config.PriorityLow); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	if err := dispatcher.EnqueueWithPriority("/data/batch.ctl", false, config.PriorityHigh); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	for _, want := range []string{"/data/payload.iso", "/data/batch.ctl"} {
+		if event := <-dispatcher.queues[0]; event.path != want {
+			t.Errorf("Expected %s next, got %s", want, event.path)
+		}
+	}
+}
+
+func TestDedupCache(t *testing.T) {
+	now := time.Now()
+	cache := newDedupCache(config.DedupConfig{Enabled: true, WindowSeconds: 60, MaxEntries: 2})
+
+	cache.record("a", now)
+	if !cache.seen("a", now.Add(30*time.Second)) {
+		t.Error("Expected hash to be seen within the window")
+	}
+	if cache.seen("a", now.Add(time.Minute)) {
+		t.Error("Expected hash to expire after the window")
+	}
+
+	// The least recently uploaded hash is evicted first
+	cache.record("a", now)
+	cache.record("b", now)
+	cache.record("a", now)
+	cache.record("c", now)
+	if cache.seen("b", now) {
+		t.Error("Expected b to be evicted")
+	}
+	if !cache.seen("a", now) || !cache.seen("c", now) {
+		t.Error("Expected a and c to be kept")
+	}
+}
+
+func TestDedupCachePersistence(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "dedup.json")
+	cfg := config.DedupConfig{Enabled: true, WindowSeconds: 60, StateFile: stateFile}
+
+	cache := newDedupCache(cfg)
+	cache.record("expired", time.Now().Add(-time.Hour))
+	cache.record("recent", time.Now())
+
+	reloaded := newDedupCache(cfg)
+	if !reloaded.seen("recent", time.Now()) {
+		t.Error("Expected recent hash to survive a restart")
+	}
+	if _, ok := reloaded.entries["expired"]; ok {
+		t.Error("Expected expired hash to be dropped on load")
+	}
+}
+
+func TestDispatcherSkipsDuplicates(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	cfg := config.OutboundConfig{URL: server.URL, Dedup: config.DedupConfig{Enabled: true}}
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
+	dispatcher.SetName("dedup")
+	done := make(chan string, 3)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { done <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	deliver := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		_ = dispatcher.Enqueue(path, false)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not processed within timeout", name)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+
+	deliver("first.csv", "same content")
+	deliver("again.csv", "same content")
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected the duplicate not to be uploaded, got %d requests", got)
+	}
+	if got := duplicatesSkipped.With("dedup").Value(); got != 1 {
+		t.Errorf("Expected 1 skipped duplicate, got %v", got)
+	}
+
+	deliver("other.csv", "different content")
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected different content to be uploaded, got %d requests", got)
+	}
+}
+
+// newMirrorServer returns a fake secondary xferd that reports received files as "path: content"
+func newMirrorServer(t *testing.T) (*httptest.Server, chan string) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Mirror request without file: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		received <- r.URL.Path + "/" + header.Filename + ": " + string(data)
+	}))
+	return server, received
+}
+
+func TestDispatcherMirror(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	testFile := filepath.Join(watchDir, "sub", "test.txt")
+	if err := os.MkdirAll(filepath.Dir(testFile), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer primary.Close()
+	secondary, received := newMirrorServer(t)
+	defer secondary.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	mirror, err := NewMirror("local", watchDir, filepath.Join(tmpDir, "temp"),
+		config.MirrorConfig{Enabled: true, URL: secondary.URL, Directory: "dr"})
+	if err != nil {
+		t.Fatalf("Failed to create mirror: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: primary.URL}, shadowMgr, 1, 100)
+	dispatcher.SetMirror(mirror)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	_ = dispatcher.Enqueue(testFile, false)
+
+	select {
+	case got := <-received:
+		if want := "/upload/dr/sub/test.txt: content"; got != want {
+			t.Errorf("Expected mirror to receive %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("File not mirrored within timeout")
+	}
+
+	// The source is deleted as usual and the spooled copy is cleaned up
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries, _ := os.ReadDir(mirror.spoolPath)
+		_, statErr := os.Stat(testFile)
+		if len(entries) == 0 && os.IsNotExist(statErr) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected source and spool to be removed, spool has %d entries, source: %v", len(entries), statErr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := mirrorResults.With("local", "mirrored").Value(); got != 1 {
+		t.Errorf("Expected 1 mirrored file, got %v", got)
+	}
+}
+
+func TestMirrorQueueFull(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	mirror, err := NewMirror("mirror-full", tmpDir, filepath.Join(tmpDir, "temp"),
+		config.MirrorConfig{Enabled: true, URL: "http://127.0.0.1:1", QueueSize: 1})
+	if err != nil {
+		t.Fatalf("Failed to create mirror: %v", err)
+	}
+
+	mirror.Forward(testFile)
+	mirror.Forward(testFile)
+
+	entries, _ := os.ReadDir(mirror.spoolPath)
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 spooled copy, got %d", len(entries))
+	}
+	if got := mirrorResults.With("mirror-full", "dropped").Value(); got != 1 {
+		t.Errorf("Expected 1 dropped file, got %v", got)
+	}
+}
+
+func TestMirrorRequeuesSpooledFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	secondary, received := newMirrorServer(t)
+	defer secondary.Close()
+
+	cfg := config.MirrorConfig{Enabled: true, URL: secondary.URL, SpoolPath: filepath.Join(tmpDir, "spool")}
+	leftover := filepath.Join(cfg.SpoolPath, spoolPrefix+"1", "a", "left.txt")
+	if err := os.MkdirAll(filepath.Dir(leftover), 0755); err != nil {
+		t.Fatalf("Failed to create spool: %v", err)
+	}
+	if err := os.WriteFile(leftover, []byte("spooled"), 0644); err != nil {
+		t.Fatalf("Failed to write spooled file: %v", err)
+	}
+
+	mirror, err := NewMirror("restart", tmpDir, "", cfg)
+	if err != nil {
+		t.Fatalf("Failed to create mirror: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mirror.run(ctx)
+
+	select {
+	case got := <-received:
+		if want := "/upload/restart/a/left.txt: spooled"; got != want {
+			t.Errorf("Expected mirror to receive %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Spooled file not mirrored within timeout")
+	}
+}
+
+func TestBacklogProgress(t *testing.T) {
+	backlog := NewBacklog("backlog", map[string]int64{"/a": 100, "/b": 300})
+	start := backlog.started
+
+	p := backlog.progress(start.Add(10 * time.Second))
+	if p.TotalFiles != 2 || p.TotalBytes != 400 || p.RemainingFiles != 2 || p.Complete {
+		t.Errorf("Unexpected initial progress: %+v", p)
+	}
+	if p.ETASeconds != nil {
+		t.Errorf("Expected no ETA before any delivery, got %d", *p.ETASeconds)
+	}
+
+	backlog.Done("/a")
+	backlog.Done("/not-in-backlog")
+	p = backlog.progress(start.Add(10 * time.Second))
+	if p.RemainingFiles != 1 || p.RemainingBytes != 300 {
+		t.Errorf("Unexpected progress after one delivery: %+v", p)
+	}
+	// 100 bytes took 10s, so 300 remaining bytes take 30s
+	if p.ETASeconds == nil || *p.ETASeconds != 30 {
+		t.Errorf("Expected ETA 30s, got %v", p.ETASeconds)
+	}
+
+	backlog.Done("/b")
+	if p = backlog.Progress(); !p.Complete || p.RemainingFiles != 0 || *p.ETASeconds != 0 {
+		t.Errorf("Expected complete backlog, got %+v", p)
+	}
+
+	var nilBacklog *Backlog
+	nilBacklog.Done("/a") // must not panic
+
+	if p := NewBacklog("empty", map[string]int64{}).Progress(); !p.Complete {
+		t.Error("Expected empty backlog to be complete")
+	}
+}
+
+func TestDispatcherIdempotencyKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	type attempt struct{ key, body string }
+	attempts := make(chan attempt, 2)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		attempts <- attempt{key: r.Header.Get("X-Request-Key"), body: string(body)}
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	cfg := config.OutboundConfig{
+		URL:            server.URL,
+		IdempotencyKey: config.IdempotencyConfig{Enabled: true, Source: config.IdempotencySourceContent, Header: "X-Request-Key"},
+	}
+	dispatcher := NewDispatcher(cfg, shadowMgr, 1, 100)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+	_ = dispatcher.Enqueue(testFile, false)
+
+	var got []attempt
+	for len(got) < 2 {
+		select {
+		case a := <-attempts:
+			got = append(got, a)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 2 attempts, got %d", len(got))
+		}
+	}
+
+	want := sha256.Sum256([]byte("content"))
+	for i, a := range got {
+		if a.key != hex.EncodeToString(want[:]) {
+			t.Errorf("Attempt %d: expected content digest as key, got %q", i+1, a.key)
+		}
+		if !strings.Contains(a.body, "content") {
+			t.Errorf("Attempt %d: expected the file in the request body, got %q", i+1, a.body)
+		}
+	}
+}
+
+func TestIdempotencyKeyMetadata(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	cfg := config.IdempotencyConfig{Enabled: true}
+
+	info, _ := os.Stat(testFile)
+	first, err := idempotencyKey(cfg, testFile, info, "")
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	again, _ := idempotencyKey(cfg, testFile, info, "")
+	if first == "" || first != again {
+		t.Errorf("Expected a stable key, got %q and %q", first, again)
+	}
+
+	later := info.ModTime().Add(time.Hour)
+	if err := os.Chtimes(testFile, later, later); err != nil {
+		t.Fatalf("Failed to change mtime: %v", err)
+	}
+	info, _ = os.Stat(testFile)
+	if changed, _ := idempotencyKey(cfg, testFile, info, ""); changed == first {
+		t.Error("Expected a new key after the file changed")
+	}
+}
+
+func TestUploadRawPut(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "daily report.csv")
+	content := strings.Repeat("a,b,c\n", 100)
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		threshold int64
+	}{
+		{"Buffered", 0},
+		{"Streamed", 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut {
+					t.Errorf("Expected PUT, got %s", r.Method)
+				}
+				if r.URL.EscapedPath() != "/files/daily%20report.csv" {
+					t.Errorf("Expected filename in URL, got %s", r.URL.EscapedPath())
+				}
+				if got := r.Header.Get("X-Filename"); got != "daily report.csv" {
+					t.Errorf("Expected X-Filename header, got %q", got)
+				}
+				if got := r.Header.Get("Content-Type"); got != "application/octet-stream" {
+					t.Errorf("Expected application/octet-stream, got %q", got)
+				}
+				if r.ContentLength != int64(len(content)) {
+					t.Errorf("Expected Content-Length %d, got %d", len(content), r.ContentLength)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != content {
+					t.Errorf("Expected raw file content as body, got %d bytes", len(body))
+				}
+			}))
+			defer server.Close()
+
+			uploader := NewUploader(config.OutboundConfig{
+				URL:                  server.URL + "/files/{filename}",
+				Method:               "PUT",
+				BodyFormat:           config.BodyFormatRaw,
+				StreamThresholdBytes: tc.threshold,
+			})
+			if err := uploader.Upload(context.Background(), testFile); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadMultipartFieldName(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, threshold := range []int64{0, 1} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Errorf("Expected POST, got %s", r.Method)
+			}
+			file, header, err := r.FormFile("document")
+			if err != nil {
+				t.Errorf("Expected file in field document: %v", err)
+				return
+			}
+			defer file.Close()
+			if header.Filename != "test.txt" {
+				t.Errorf("Expected filename test.txt, got %s", header.Filename)
+			}
+		}))
+
+		uploader := NewUploader(config.OutboundConfig{URL: server.URL, FieldName: "document", StreamThresholdBytes: threshold})
+		if err := uploader.Upload(context.Background(), testFile); err != nil {
+			t.Errorf("Upload failed (threshold %d): %v", threshold, err)
+		}
+		server.Close()
+	}
+}
+
+func TestDispatcherUploadDeadline(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// The server never answers, as with a hung connection
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+	dispatcher.SetName("deadline-test")
+	cancelled := stuckUploads.With("deadline-test", "cancelled").Value()
+	restarted := stuckUploads.With("deadline-test", "restarted").Value()
+	dispatcher.SetUploadDeadline(200 * time.Millisecond)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	_ = dispatcher.Enqueue(testFile, false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for stuckUploads.With("deadline-test", "cancelled").Value() == cancelled {
+		if time.Now().After(deadline) {
+			t.Fatal("Upload was not cancelled at the deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The worker recovered by itself, so it is not replaced
+	if got := stuckUploads.With("deadline-test", "restarted").Value() - restarted; got != 0 {
+		t.Errorf("Expected no restarted workers, got %v", got)
+	}
+	if _, err := os.Stat(testFile); err != nil {
+		t.Errorf("Expected source file to be kept after a cancelled upload: %v", err)
+	}
+}
+
+func TestDispatcherRestartsStuckWorker(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := filepath.Join(tmpDir, "first.txt")
+	second := filepath.Join(tmpDir, "second.txt")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	// The success callback for the first file blocks regardless of cancellation
+	unblock := make(chan struct{})
+	delivered := make(chan string, 2)
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+	dispatcher.SetName("restart-test")
+	restarted := stuckUploads.With("restart-test", "restarted").Value()
+	dispatcher.SetUploadDeadline(100 * time.Millisecond)
+	dispatcher.SetOnSuccessfulUpload(func(path string) {
+		if path == first {
+			<-unblock
+		}
+		delivered <- path
+	})
+	dispatcher.Start(context.Background())
+
+	_ = dispatcher.Enqueue(first, false)
+	_ = dispatcher.Enqueue(second, false)
+
+	select {
+	case path := <-delivered:
+		if path != second {
+			t.Errorf("Expected %s to be delivered by the replacement worker, got %s", second, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stuck worker was not replaced within timeout")
+	}
+	if got := stuckUploads.With("restart-test", "restarted").Value() - restarted; got != 1 {
+		t.Errorf("Expected 1 restarted worker, got %v", got)
+	}
+
+	// Stop must not wait for the abandoned worker
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on the abandoned worker")
+	}
+
+	close(unblock)
+	select {
+	case path := <-delivered:
+		if path != first {
+			t.Errorf("Expected abandoned worker to finish %s, got %s", first, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Abandoned worker did not finish after being unblocked")
+	}
+}
+
+func TestDispatcherOrderedDeliveryOutlastsDeadline(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := filepath.Join(tmpDir, "1.txt")
+	second := filepath.Join(tmpDir, "2.txt")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// The destination stays down for longer than the deadline and grace period
+	var mu sync.Mutex
+	var delivered []string
+	downUntil := time.Now().Add(500 * time.Millisecond)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Failed to get form file: %v", err)
+			return
+		}
+		if time.Now().Before(downUntil) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		delivered = append(delivered, header.Filename)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+	dispatcher.SetName("ordered-deadline-test")
+	restarted := stuckUploads.With("ordered-deadline-test", "restarted").Value()
+	dispatcher.SetOrdering(config.OrderingKeyDirectory, tmpDir)
+	dispatcher.SetUploadDeadline(100 * time.Millisecond)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	_ = dispatcher.Enqueue(first, false)
+	_ = dispatcher.Enqueue(second, false)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		n := len(delivered)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Files were not delivered after the destination recovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Waiting to retry is not a stuck upload, so no second worker takes the queue
+	if got := stuckUploads.With("ordered-deadline-test", "restarted").Value() - restarted; got != 0 {
+		t.Errorf("Expected no restarted workers, got %v", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if delivered[0] != "1.txt" || delivered[1] != "2.txt" {
+		t.Errorf("Expected delivery in order, got %v", delivered)
+	}
+}
+
+func TestUploadURLTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "sub dir", "a b.txt")
+	if err := os.MkdirAll(filepath.Dir(testFile), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var gotPath, gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotQuery = r.URL.Query().Get("name")
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL: server.URL + `/{{.Directory}}/{{.Date "2006"}}/{{.RelPath}}?name={{.Filename}}`,
+	})
+	uploader.directory = "in voices"
+	uploader.watchPath = tmpDir
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	want := "/in%20voices/" + time.Now().UTC().Format("2006") + "/sub%20dir/a%20b.txt"
+	if gotPath != want {
+		t.Errorf("Expected path %s, got %s", want, gotPath)
+	}
+	if gotQuery != "a b.txt" {
+		t.Errorf("Expected filename query a b.txt, got %q", gotQuery)
+	}
+}
+
+func TestUploadURLTemplateDateTokens(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{URL: server.URL + `/{{.Date "%Y/%m/%d"}}/{filename}`})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if want := "/" + time.Now().UTC().Format("2006/01/02") + "/test.txt"; gotPath != want {
+		t.Errorf("Expected path %s, got %s", want, gotPath)
+	}
+
+	// Unsupported tokens fail when the template is parsed
+	if _, err := parseURLTemplate(server.URL + `/{{.Date "%Q"}}/{filename}`); err == nil {
+		t.Error("Expected error for unsupported date token")
+	}
+}
+
+func TestUploadURLTemplateInvalid(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Unknown placeholders only fail when executed
+	uploader := NewUploader(config.OutboundConfig{URL: "http://127.0.0.1:1/{{.Unknown}}"})
+	if err := uploader.Upload(context.Background(), testFile); err == nil || !strings.Contains(err.Error(), "url template") {
+		t.Errorf("Expected url template error, got %v", err)
+	}
+}
+
+func TestUploadRelativePath(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "2026", "q3 reports", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(testFile), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		streamed bool
+	}{
+		{"url", config.RelativePathURL, false},
+		{"header", config.RelativePathHeader, false},
+		{"field", config.RelativePathField, false},
+		{"field streamed", config.RelativePathField, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath, gotHeader, gotField string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.EscapedPath()
+				gotHeader = r.Header.Get("X-Relative-Path")
+				if err := r.ParseMultipartForm(1 << 20); err == nil {
+					gotField = r.FormValue("relative_path")
+				}
+			}))
+			defer server.Close()
+
+			cfg := config.OutboundConfig{
+				URL:          server.URL + "/upload/?token=x",
+				RelativePath: config.RelativePathConfig{Mode: tt.mode},
+			}
+			if tt.streamed {
+				cfg.StreamThresholdBytes = 1
+			}
+			uploader := NewUploader(cfg)
+			uploader.watchPath = tmpDir
+			if err := uploader.Upload(context.Background(), testFile); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+
+			want := map[string][3]string{
+				config.RelativePathURL:    {"/upload/2026/q3%20reports/a.txt", "", ""},
+				config.RelativePathHeader: {"/upload/", "2026/q3 reports/a.txt", ""},
+				config.RelativePathField:  {"/upload/", "", "2026/q3 reports/a.txt"},
+			}[tt.mode]
+			if got := [3]string{gotPath, gotHeader, gotField}; got != want {
+				t.Errorf("Expected path, header and field %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestUploadInstanceIdentity(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, streamed := range []bool{false, true} {
+		var gotHeader, gotField, gotPod string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotHeader = r.Header.Get("X-Xferd-Instance")
+			gotPod = r.Header.Get("X-Xferd-Namespace") + "/" + r.Header.Get("X-Xferd-Pod")
+			if err := r.ParseMultipartForm(1 << 20); err == nil {
+				gotField = r.FormValue("site")
+			}
+		}))
+
+		cfg := config.OutboundConfig{URL: server.URL}
+		if streamed {
+			cfg.StreamThresholdBytes = 1
+		}
+		dispatcher := NewDispatcher(cfg, nil, 1, 1)
+		dispatcher.SetInstance(config.InstanceConfig{Enabled: true, ID: "edge-berlin", Field: "site"})
+		dispatcher.SetPod("xferd-7d9f", "ingest")
+		if err := dispatcher.uploader.Upload(context.Background(), testFile); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		server.Close()
+
+		if gotHeader != "edge-berlin" || gotField != "edge-berlin" {
+			t.Errorf("Expected instance ID in header and field (streamed: %v), got %q and %q", streamed, gotHeader, gotField)
+		}
+		if gotPod != "ingest/xferd-7d9f" {
+			t.Errorf("Expected pod headers (streamed: %v), got %q", streamed, gotPod)
+		}
+	}
+}
+
+func TestUploadScaledTimeout(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// The first attempt stalls and is cut off by the scaled timeout
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if attempts.Add(1) == 1 {
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL:        server.URL,
+		Connection: config.ConnectionConfig{MinThroughputBytes: 1 << 20, MinTimeoutSeconds: 1},
+	})
+	start := time.Now()
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("Expected 2 attempts, got %d", got)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the stalled attempt to time out after about 1s, took %v", elapsed)
+	}
+}
+
+func TestUploadSuccessCheckRetries(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// The destination reports the first failure in a 200 response
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if attempts.Add(1) == 1 {
+			_, _ = w.Write([]byte(`{"status":"error"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL:     server.URL,
+		Success: config.SuccessConfig{JSONField: "status", JSONValue: "ok"},
+	})
+	if err := uploader.Upload(context.Background(), testFile); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("Expected 2 attempts, got %d", got)
+	}
+}
+
+func TestCheckSuccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		success config.SuccessConfig
+		header  http.Header
+		body    string
+		wantErr bool
+	}{
+		{"no checks", config.SuccessConfig{}, nil, `{"status":"error"}`, false},
+		{"json value", config.SuccessConfig{JSONField: "status", JSONValue: "ok"}, nil, `{"status":"ok"}`, false},
+		{"json mismatch", config.SuccessConfig{JSONField: "status", JSONValue: "ok"}, nil, `{"status":"error"}`, true},
+		{"nested json", config.SuccessConfig{JSONField: "result.code", JSONValue: "0"}, nil, `{"result":{"code":0}}`, false},
+		{"json bool", config.SuccessConfig{JSONField: "stored", JSONValue: "true"}, nil, `{"stored":true}`, false},
+		{"json present", config.SuccessConfig{JSONField: "id"}, nil, `{"id":"abc"}`, false},
+		{"json missing", config.SuccessConfig{JSONField: "id"}, nil, `{"id":null}`, true},
+		{"not json", config.SuccessConfig{JSONField: "status"}, nil, `OK`, true},
+		{"body regex", config.SuccessConfig{BodyRegex: `^OK\b`}, nil, `OK stored`, false},
+		{"body regex mismatch", config.SuccessConfig{BodyRegex: `^OK\b`}, nil, `ERROR`, true},
+		{"header", config.SuccessConfig{Header: "X-Stored"}, http.Header{"X-Stored": {"1"}}, ``, false},
+		{"header missing", config.SuccessConfig{Header: "X-Stored"}, http.Header{}, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader := NewUploader(config.OutboundConfig{URL: "http://example.com/", Success: tt.success})
+			err := uploader.checkSuccess(&uploadResponse{status: http.StatusOK, header: tt.header, body: []byte(tt.body)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestUploadVerify(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	sum := sha256.Sum256([]byte("content"))
+	checksum := hex.EncodeToString(sum[:])
+
+	for _, persisted := range []bool{true, false} {
+		t.Run(fmt.Sprintf("persisted=%v", persisted), func(t *testing.T) {
+			var verified atomic.Bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				if r.Method == http.MethodPost {
+					_, _ = w.Write([]byte(`{"upload_id":"u-1"}`))
+					return
+				}
+				if r.Method != http.MethodHead || r.URL.Path != "/files/u-1/"+checksum {
+					t.Errorf("Unexpected verify request %s %s", r.Method, r.URL.Path)
+				}
+				if r.Header.Get(checksumHeader) != checksum {
+					t.Errorf("Expected checksum header %s, got %q", checksum, r.Header.Get(checksumHeader))
+				}
+				verified.Store(true)
+				if !persisted {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			uploader := NewUploader(config.OutboundConfig{
+				URL:    server.URL + "/upload",
+				Verify: config.VerifyConfig{Enabled: true, URL: server.URL + "/files/{upload_id}/{checksum}"},
+			})
+			err := uploader.Upload(context.Background(), testFile)
+			if !verified.Load() {
+				t.Fatal("Expected a verify request")
+			}
+			if persisted && err != nil {
+				t.Errorf("Expected verified upload to succeed, got %v", err)
+			}
+			if !persisted && (err == nil || !strings.Contains(err.Error(), "verify")) {
+				t.Errorf("Expected verification error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadFailover(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := filepath.Join(tmpDir, "first.txt")
+	second := filepath.Join(tmpDir, "second.txt")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	var primaryHits, secondaryHits atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		primaryHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		secondaryHits.Add(1)
+	}))
+	defer secondary.Close()
+
+	uploader := NewUploader(config.OutboundConfig{
+		URL:      primary.URL,
+		Failover: config.FailoverConfig{URLs: []string{secondary.URL + "/dr/{{.Filename}}"}, FailureThreshold: 1},
+	})
+	uploader.directory = "failover-test"
+	failovers := failoverUploads.With("failover-test", secondary.URL+"/dr/{{.Filename}}").Value()
+
+	// The first file fails over once the primary gave up; the tee only keeps the delivered attempt
+	tee := &bytes.Buffer{}
+	destination, err := uploader.upload(context.Background(), first, uploadOptions{tee: tee})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if destination != secondary.URL+"/dr/{{.Filename}}" {
+		t.Errorf("Expected delivery to the secondary, got %q", destination)
+	}
+	if tee.String() != "content" {
+		t.Errorf("Expected tee to hold the content once, got %q", tee.String())
+	}
+	hits := primaryHits.Load()
+
+	// The primary's circuit is open, so the second file goes straight to the secondary
+	if _, err := uploader.upload(context.Background(), second, uploadOptions{}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := primaryHits.Load(); got != hits {
+		t.Errorf("Expected the primary to be skipped, got %d more requests", got-hits)
+	}
+	if got := secondaryHits.Load(); got != 2 {
+		t.Errorf("Expected 2 uploads to the secondary, got %d", got)
+	}
+	if got := failoverUploads.With("failover-test", secondary.URL+"/dr/{{.Filename}}").Value() - failovers; got != 2 {
+		t.Errorf("Expected 2 failover uploads counted, got %d", got)
+	}
+}
+
+func TestUploadFailoverSkipsRejected(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer primary.Close()
+	var secondaryHits atomic.Int32
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryHits.Add(1)
+	}))
+	defer secondary.Close()
+
+	// A rejected file is not the primary's fault and is not sent elsewhere
+	uploader := NewUploader(config.OutboundConfig{URL: primary.URL, Failover: config.FailoverConfig{URLs: []string{secondary.URL}}})
+	if err := uploader.Upload(context.Background(), testFile); err == nil || !strings.Contains(err.Error(), "client error") {
+		t.Errorf("Expected client error, got %v", err)
+	}
+	if got := secondaryHits.Load(); got != 0 {
+		t.Errorf("Expected no failover for a rejected file, got %d requests", got)
+	}
+}
+
+func TestDispatcherSLA(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "late.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var primaryHits, secondaryHits atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		primaryHits.Add(1)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		secondaryHits.Add(1)
+	}))
+	defer secondary.Close()
+
+	dispatcher := NewDispatcher(config.OutboundConfig{
+		URL:      primary.URL,
+		Failover: config.FailoverConfig{URLs: []string{secondary.URL}},
+	}, nil, 1, 10)
+	dispatcher.SetName("sla-test")
+	dispatcher.SetSLA(config.SLAConfig{Enabled: true, DeadlineSeconds: 60, Failover: true})
+
+	// Files within the deadline are not escalated
+	now := time.Now()
+	dispatcher.MarkDetected(testFile, now.Add(-30*time.Second))
+	dispatcher.escalateOverdue(now)
+	if got := overdueFiles.With("sla-test").Value(); got != 0 {
+		t.Errorf("Expected no overdue files, got %d", got)
+	}
+
+	// A later detection does not move the deadline; an overdue file is escalated once
+	dispatcher.MarkDetected(testFile, now)
+	for range 2 {
+		dispatcher.escalateOverdue(now.Add(time.Minute))
+	}
+	if got := overdueFiles.With("sla-test").Value(); got != 1 {
+		t.Errorf("Expected 1 overdue file, got %d", got)
+	}
+	if got := overdueOutstanding.With("sla-test").Value(); got != 1 {
+		t.Errorf("Expected 1 outstanding overdue file, got %v", got)
+	}
+
+	// Overdue files skip the primary destination
+	delivered := make(chan string, 1)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { delivered <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+	_ = dispatcher.Enqueue(testFile, true)
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for delivery")
+	}
+	if primaryHits.Load() != 0 || secondaryHits.Load() != 1 {
+		t.Errorf("Expected delivery to the secondary only, got %d primary and %d secondary requests", primaryHits.Load(), secondaryHits.Load())
+	}
+
+	// Delivered and vanished files are no longer overdue
+	dispatcher.MarkDetected(filepath.Join(t.TempDir(), "gone.txt"), now.Add(-time.Hour))
+	dispatcher.escalateOverdue(now.Add(time.Minute))
+	if got := overdueOutstanding.With("sla-test").Value(); got != 0 {
+		t.Errorf("Expected no outstanding overdue files, got %v", got)
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	limit := newAdaptiveLimit("adaptive-test", config.AdaptiveConcurrencyConfig{Enabled: true, MinWorkers: 2}, 4)
+	increases := concurrencyAdjustments.With("adaptive-test", "increase").Value()
+
+	// The limit grows by one after a window of uncongested responses
+	sent := time.Now()
+	for i := 0; i < 2; i++ {
+		limit.observe(sent, http.StatusOK)
+	}
+	if limit.limit != 3 {
+		t.Errorf("Expected limit 3 after a full window, got %d", limit.limit)
+	}
+	for i := 0; i < 10; i++ {
+		limit.observe(sent, http.StatusOK)
+	}
+	if limit.limit != 4 {
+		t.Errorf("Expected limit capped at 4, got %d", limit.limit)
+	}
+	if got := concurrencyAdjustments.With("adaptive-test", "increase").Value() - increases; got != 2 {
+		t.Errorf("Expected 2 increases counted, got %d", got)
+	}
+
+	// 429 halves the limit, but not below the minimum
+	limit.observe(time.Now(), http.StatusTooManyRequests)
+	if limit.limit != 2 {
+		t.Errorf("Expected limit halved to 2, got %d", limit.limit)
+	}
+
+	// Responses to requests sent before the decrease are ignored
+	limit.observe(sent, http.StatusServiceUnavailable)
+	limit.observe(sent, http.StatusOK)
+	if limit.limit != 2 || limit.successes != 0 {
+		t.Errorf("Expected stale responses ignored, got limit %d and %d successes", limit.limit, limit.successes)
+	}
+	time.Sleep(time.Millisecond)
+	limit.observe(time.Now(), http.StatusServiceUnavailable)
+	if limit.limit != 2 {
+		t.Errorf("Expected limit to stay at the minimum, got %d", limit.limit)
+	}
+
+	// acquire blocks at the limit until a slot is released
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if !limit.acquire(ctx) || !limit.acquire(ctx) {
+		t.Fatal("Expected two slots")
+	}
+	if limit.acquire(ctx) {
+		t.Error("Expected acquire to block at the limit")
+	}
+	limit.release()
+	if !limit.acquire(context.Background()) {
+		t.Error("Expected a released slot to be available")
+	}
+}
+
+func TestDispatcherAdaptiveConcurrency(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var inFlight, peak atomic.Int32
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		inFlight.Add(-1)
+		received.Add(1)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	// Every response is slower than the target, so the limit stays at the minimum
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 4, 100)
+	dispatcher.SetName("adaptive-dispatch-test")
+	dispatcher.SetAdaptiveConcurrency(config.AdaptiveConcurrencyConfig{Enabled: true, MinWorkers: 1, TargetLatencyMs: 1})
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	for i := 0; i < 4; i++ {
+		testFile := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+		if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := dispatcher.Enqueue(testFile, false); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for received.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := received.Load(); got != 4 {
+		t.Fatalf("Expected 4 uploads, got %d", got)
+	}
+	if got := peak.Load(); got != 1 {
+		t.Errorf("Expected at most 1 concurrent upload, got %d", got)
+	}
+	if got := dispatcher.ConcurrencyLimit(); got != 1 {
+		t.Errorf("Expected concurrency limit 1, got %d", got)
+	}
+}
+
+// writePassthrough streams content through passthrough storage to path
+func writePassthrough(t *testing.T, p *Passthrough, path, content string) error {
+	t.Helper()
+	f, err := p.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for chunk := range slices.Chunk([]byte(content), 1000) {
+		if _, err := f.Write(chunk); err != nil {
+			f.Abort()
+			return err
+		}
+	}
+	return f.Commit()
+}
+
+func TestPassthroughDelivered(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	tempDir := filepath.Join(tmpDir, "temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Failed to read form file: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		received <- r.URL.Path + " " + header.Filename + ": " + string(data)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL + "/{{.RelPath}}"}, shadowMgr, 1, 100)
+	dispatcher.SetName("passthrough-delivered")
+	dispatcher.SetWatchPath(watchDir)
+	delivered := passthroughResults.With("passthrough-delivered", "delivered").Value()
+
+	// Content beyond the buffer is staged on disk but never published
+	content := strings.Repeat("x", 5000)
+	p := NewPassthrough(dispatcher, storage.NewLocal(tempDir), 1024)
+	if err := writePassthrough(t, p, filepath.Join(watchDir, "sub", "a.txt"), content); err != nil {
+		t.Fatalf("Passthrough failed: %v", err)
+	}
+
+	if got, want := <-received, "/sub/a.txt a.txt: "+content; got != want {
+		t.Errorf("Unexpected upload: got %d bytes, expected %d", len(got), len(want))
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "sub", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written to the watch directory, got %v", err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("Expected staged copy to be removed, got %d entries", len(entries))
+	}
+	if got := passthroughResults.With("passthrough-delivered", "delivered").Value() - delivered; got != 1 {
+		t.Errorf("Expected 1 delivered file, got %v", got)
+	}
+}
+
+func TestPassthroughSpillsOnFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		bufferBytes int64
+	}{
+		{"Memory", 1 << 20},
+		{"Staged", 1024},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			watchDir := filepath.Join(tmpDir, "watch")
+			tempDir := filepath.Join(tmpDir, "temp")
+			if err := os.MkdirAll(tempDir, 0755); err != nil {
+				t.Fatalf("Failed to create temp directory: %v", err)
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+			if err != nil {
+				t.Fatalf("Failed to create shadow manager: %v", err)
+			}
+			dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+			dispatcher.SetName("passthrough-spilled")
+			spilled := passthroughResults.With("passthrough-spilled", "spilled").Value()
+
+			content := strings.Repeat("y", 5000)
+			path := filepath.Join(watchDir, "b.txt")
+			p := NewPassthrough(dispatcher, storage.NewLocal(tempDir), tc.bufferBytes)
+			if err := writePassthrough(t, p, path, content); err != nil {
+				t.Fatalf("Passthrough failed: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Expected failed upload to be spilled to the watch directory: %v", err)
+			}
+			if string(data) != content {
+				t.Errorf("Spilled content mismatch: got %d bytes, expected %d", len(data), len(content))
+			}
+			if got := passthroughResults.With("passthrough-spilled", "spilled").Value() - spilled; got != 1 {
+				t.Errorf("Expected 1 spilled file, got %v", got)
+			}
+		})
+	}
+}
+
+func TestPassthroughAbort(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+
+	var completed atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := r.FormFile("file"); err == nil {
+			completed.Add(1)
+		}
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 100)
+
+	p := NewPassthrough(dispatcher, storage.NewLocal(tmpDir), 1<<20)
+	f, err := p.Create(context.Background(), filepath.Join(watchDir, "c.txt"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("partial")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	f.Abort()
+
+	if completed.Load() != 0 {
+		t.Error("Expected destination not to receive an aborted file")
+	}
+	if _, err := os.Stat(filepath.Join(watchDir, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected aborted file not to be spilled, got %v", err)
+	}
+}
+
+func TestDispatcherContentRoutes(t *testing.T) {
+	tmpDir := t.TempDir()
+	archive := filepath.Join(tmpDir, "report.xml")
+	plain := filepath.Join(tmpDir, "notes.txt")
+	if err := os.WriteFile(archive, []byte("PK\x03\x04archive"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(plain, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var mu sync.Mutex
+	paths := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		paths[path.Base(r.URL.Path)] = r.URL.Path
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL + "/default/{filename}"}, shadowMgr, 1, 100)
+	err = dispatcher.SetContentRules([]config.ContentRule{
+		{Types: []string{"gzip"}, Action: config.ContentActionIgnore},
+		{Types: []string{"zip"}, URL: server.URL + "/archives/{{.Filename}}"},
+	})
+	if err != nil {
+		t.Fatalf("SetContentRules failed: %v", err)
+	}
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+	_ = dispatcher.Enqueue(archive, false)
+	_ = dispatcher.Enqueue(plain, false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(paths)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for uploads")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := paths["report.xml"]; got != "/archives/report.xml" {
+		t.Errorf("Expected ZIP content to be routed to /archives/report.xml, got %q", got)
+	}
+	if got := paths["notes.txt"]; got != "/default/notes.txt" {
+		t.Errorf("Expected plain file at /default/notes.txt, got %q", got)
+	}
+}
+
+func TestDispatcherContentRulesInvalidURL(t *testing.T) {
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: "http://example.com/"}, nil, 1, 1)
+	err := dispatcher.SetContentRules([]config.ContentRule{{Types: []string{"zip"}, URL: "http://example.com/{{.Missing}}"}})
+	if err == nil {
+		t.Error("Expected error for invalid route url template")
+	}
+}
+
+func TestDispatcherRoutes(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "finance"), 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+	files := map[string]string{
+		"report.pdf":          "pdf",
+		"finance/ledger.csv":  "csv",
+		"data.csv":            "csv",
+		"large.bin":           strings.Repeat("x", 100),
+		"finance/invoice.txt": "invoice",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	type request struct{ path, team, auth string }
+	var mu sync.Mutex
+	requests := map[string]request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		requests[path.Base(r.URL.Path)] = request{r.URL.Path, r.Header.Get("X-Team"), r.Header.Get("Authorization")}
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{
+		URL:  server.URL + "/default/{{.Filename}}",
+		Auth: config.AuthConfig{Type: "bearer", Token: "default"},
+	}, shadowMgr, 1, 100)
+	dispatcher.SetWatchPath(tmpDir)
+	err = dispatcher.SetRoutes([]config.RouteRule{
+		{Patterns: []string{"*.pdf"}, URL: server.URL + "/documents/{{.Filename}}"},
+		{Regex: `\.csv$`, Subdirectory: "finance", Headers: map[string]string{"X-Team": "finance"},
+			Auth: &config.AuthConfig{Type: "bearer", Token: "finance"}},
+		{MinSizeBytes: 50, URL: server.URL + "/large/{{.Filename}}"},
+	})
+	if err != nil {
+		t.Fatalf("SetRoutes failed: %v", err)
+	}
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+	for name := range files {
+		_ = dispatcher.Enqueue(filepath.Join(tmpDir, name), false)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(requests)
+		mu.Unlock()
+		if n == len(files) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for uploads")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[string]request{
+		"report.pdf":  {"/documents/report.pdf", "", "Bearer default"},
+		"ledger.csv":  {"/default/ledger.csv", "finance", "Bearer finance"},
+		"data.csv":    {"/default/data.csv", "", "Bearer default"},
+		"large.bin":   {"/large/large.bin", "", "Bearer default"},
+		"invoice.txt": {"/default/invoice.txt", "", "Bearer default"},
+	}
+	for name, want := range expected {
+		if got := requests[name]; got != want {
+			t.Errorf("Expected %s to be sent as %+v, got %+v", name, want, got)
+		}
+	}
+}
+
+func TestDispatcherLocalDir(t *testing.T) {
+	watchDir := t.TempDir()
+	targetDir := t.TempDir()
+	shadowDir := t.TempDir()
+	moved := filepath.Join(watchDir, "sub", "moved.txt")
+	kept := filepath.Join(watchDir, "kept.txt")
+	if err := os.MkdirAll(filepath.Dir(moved), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, file := range []string{moved, kept} {
+		if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: true, Path: shadowDir})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	delivered := make(chan string, 2)
+	dispatcher := NewDispatcher(config.OutboundConfig{Type: config.OutboundLocalDir, Path: targetDir}, shadowMgr, 1, 10)
+	dispatcher.SetWatchPath(watchDir)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { delivered <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	_ = dispatcher.Enqueue(moved, false)
+	_ = dispatcher.Enqueue(kept, true) // still being written, so it is copied and kept
+	for range 2 {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for delivery")
+		}
+	}
+	// The source is removed right after the callback
+	time.Sleep(50 * time.Millisecond)
+
+	if content, err := os.ReadFile(filepath.Join(targetDir, "sub", "moved.txt")); err != nil || string(content) != "content" {
+		t.Errorf("Expected moved.txt below sub in the target, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(moved); !os.IsNotExist(err) {
+		t.Errorf("Expected source to be removed, got %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(shadowDir, "*moved.txt")); len(files) != 1 {
+		t.Errorf("Expected one shadow copy, got %d", len(files))
+	}
+
+	// A kept source is copied, so later writes do not reach the target
+	if err := os.WriteFile(kept, []byte("content, continued"), 0644); err != nil {
+		t.Fatalf("Failed to append to kept file: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(targetDir, "kept.txt")); err != nil || string(content) != "content" {
+		t.Errorf("Expected a copy of kept.txt in the target, got %q (%v)", content, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(targetDir, "sub")); len(entries) != 1 {
+		t.Errorf("Expected no leftover .partial files, got %d entries", len(entries))
+	}
+}
+
+func TestDispatcherAfterUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	deliver := func(t *testing.T, action, processedPath string, files ...string) {
+		t.Helper()
+		delivered := make(chan string, len(files))
+		shadowMgr, _ := shadow.NewManager(config.ShadowConfig{Enabled: false})
+		dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+		dispatcher.SetWatchPath(filepath.Dir(files[0]))
+		dispatcher.SetAfterUpload(action, processedPath)
+		dispatcher.SetOnSuccessfulUpload(func(path string) { delivered <- path })
+		dispatcher.Start(context.Background())
+		defer dispatcher.Stop()
+		for _, file := range files {
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+			_ = dispatcher.Enqueue(file, false)
+			select {
+			case <-delivered:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for delivery")
+			}
+			// The source is handled right after the callback
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	t.Run("move", func(t *testing.T) {
+		watchDir, processedDir := t.TempDir(), t.TempDir()
+		file := filepath.Join(watchDir, "invoice.pdf")
+		deliver(t, config.AfterUploadMove, filepath.Join(processedDir, "%Y"), file, file)
+
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected source to be moved, got %v", err)
+		}
+		year := filepath.Join(processedDir, time.Now().UTC().Format("2006"))
+		for _, name := range []string{"invoice.pdf", "invoice.1.pdf"} {
+			if content, err := os.ReadFile(filepath.Join(year, name)); err != nil || string(content) != "content" {
+				t.Errorf("Expected %s in the processed path, got %q (%v)", name, content, err)
+			}
+		}
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "invoice.pdf")
+		deliver(t, config.AfterUploadKeep, "", file)
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected source to be kept, got %v", err)
+		}
+	})
+}
+
+func TestDispatcherReadOnly(t *testing.T) {
+	var uploads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("X-File-Version"); v != "" {
+			t.Errorf("Expected no version header without versioning, got %s", v)
+		}
+		uploads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "report.csv")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	state := config.ReadOnlyStateConfig{StateFile: filepath.Join(tmpDir, "delivered.json")}
+
+	// Every dispatcher run sees the file again, as a reconciliation scan after a restart would
+	run := func() {
+		shadowMgr, _ := shadow.NewManager(config.ShadowConfig{Enabled: false})
+		done := make(chan string, 1)
+		dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+		dispatcher.SetReadOnly(state)
+		dispatcher.SetOnSuccessfulUpload(func(path string) { done <- path })
+		dispatcher.Start(context.Background())
+		defer dispatcher.Stop()
+		_ = dispatcher.Enqueue(file, false)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for delivery")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	run()
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("Expected the source to be kept, got %v", err)
+	}
+	run()
+	if n := uploads.Load(); n != 1 {
+		t.Errorf("Expected a delivered file to be skipped after a restart, got %d uploads", n)
+	}
+
+	// A changed file is delivered again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Failed to touch file: %v", err)
+	}
+	run()
+	if n := uploads.Load(); n != 2 {
+		t.Errorf("Expected a changed file to be delivered again, got %d uploads", n)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("Expected the source to be kept, got %v", err)
+	}
+}
+
+func TestSendLocalDatedPath(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "test.txt")
+	targetDir := t.TempDir()
+	if err := os.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	uploader := NewUploader(config.OutboundConfig{Type: config.OutboundLocalDir, Path: filepath.Join(targetDir, "%Y", "%m")})
+	if err := uploader.sendLocal(context.Background(), source, uploadOptions{}); err != nil {
+		t.Fatalf("sendLocal failed: %v", err)
+	}
+	now := time.Now().UTC()
+	target := filepath.Join(targetDir, now.Format("2006"), now.Format("01"), "test.txt")
+	if content, err := os.ReadFile(target); err != nil || string(content) != "content" {
+		t.Errorf("Expected test.txt below the dated directory, got %q (%v)", content, err)
+	}
+}
+
+func TestSendLocalLinks(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "test.txt")
+	targetDir := t.TempDir()
+	if err := os.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Without a tee the file is linked rather than copied
+	uploader := NewUploader(config.OutboundConfig{Type: config.OutboundLocalDir, Path: targetDir})
+	if err := uploader.sendLocal(context.Background(), source, uploadOptions{link: true}); err != nil {
+		t.Fatalf("sendLocal failed: %v", err)
+	}
+	sourceInfo, _ := os.Stat(source)
+	targetInfo, err := os.Stat(filepath.Join(targetDir, "test.txt"))
+	if err != nil {
+		t.Fatalf("Expected delivered file: %v", err)
+	}
+	if !os.SameFile(sourceInfo, targetInfo) {
+		t.Error("Expected the target to be a link to the source")
+	}
+}
+
+func TestDispatcherRoutesInvalidRegex(t *testing.T) {
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: "http://example.com/"}, nil, 1, 1)
+	if err := dispatcher.SetRoutes([]config.RouteRule{{Regex: "(", URL: "http://example.com/"}}); err == nil {
+		t.Error("Expected error for invalid route regex")
+	}
+}
+
+func TestProbeDestination(t *testing.T) {
+	status := http.StatusMethodNotAllowed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/upload/" {
+			t.Errorf("Unexpected probe %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// Templated URLs are probed up to the first placeholder
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL + "/upload/{{.Directory}}/{filename}"}, nil, 1, 10)
+	if err := dispatcher.ProbeDestination(context.Background()); err != nil {
+		t.Errorf("Expected a destination rejecting HEAD to be reachable, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := dispatcher.ProbeDestination(context.Background()); err == nil {
+		t.Error("Expected a server error to fail the probe")
+	}
+
+	server.Close()
+	if err := dispatcher.ProbeDestination(context.Background()); err == nil {
+		t.Error("Expected an unreachable destination to fail the probe")
+	}
+
+	// Templated hosts cannot be probed
+	dispatcher = NewDispatcher(config.OutboundConfig{URL: "https://{{.Directory}}.example.com/upload"}, nil, 1, 10)
+	if err := dispatcher.ProbeDestination(context.Background()); err != nil {
+		t.Errorf("Expected templated hosts to be skipped, got %v", err)
+	}
+	if got := dispatcher.QueueCapacity(); got != 10 {
+		t.Errorf("Expected queue capacity 10, got %d", got)
+	}
+}
+
+func TestProbeUpload(t *testing.T) {
+	var received string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/", func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, _ := r.BasicAuth(); pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received = r.URL.Path
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/old/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/upload/", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dirCfg := config.DirectoryConfig{Name: "invoices", Outbound: config.OutboundConfig{
+		URL:  server.URL + "/upload/{{.Filename}}",
+		Auth: config.AuthConfig{Type: "basic", Username: "user", Password: "secret"},
+	}}
+	result := ProbeUpload(context.Background(), dirCfg, config.InstanceConfig{})
+	if result.Err != nil {
+		t.Fatalf("Expected the probe to pass, got %v", result.Err)
+	}
+	if received != "/upload/"+result.File || len(result.Exchanges) != 1 || result.Exchanges[0].Status != http.StatusCreated {
+		t.Errorf("Unexpected probe: received %q, exchanges %+v", received, result.Exchanges)
+	}
+
+	dirCfg.Outbound.Auth.Password = "wrong"
+	result = ProbeUpload(context.Background(), dirCfg, config.InstanceConfig{})
+	if result.Err == nil || !strings.Contains(result.Hint, "outbound.auth") {
+		t.Errorf("Expected a failure pointing at outbound.auth, got %v (hint %q)", result.Err, result.Hint)
+	}
+
+	// A POST redirected with 302 arrives as a GET without the file, and is accepted
+	dirCfg.Outbound.Auth.Password = "secret"
+	dirCfg.Outbound.URL = server.URL + "/old/{{.Filename}}"
+	result = ProbeUpload(context.Background(), dirCfg, config.InstanceConfig{})
+	if result.Err == nil || !strings.Contains(result.Hint, server.URL+"/upload/") {
+		t.Errorf("Expected a failure pointing at the redirect, got %v (hint %q)", result.Err, result.Hint)
+	}
+}
+
+func TestDispatcherDrain(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := []string{filepath.Join(tmpDir, "a.txt"), filepath.Join(tmpDir, "b.txt")}
+	for _, file := range files {
+		if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	if !dispatcher.Idle() {
+		t.Error("Expected a new dispatcher to be idle")
+	}
+	for _, file := range files {
+		if err := dispatcher.Enqueue(file, false); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+
+	// One file is uploading and one is queued
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if dispatcher.Drain(ctx) {
+		t.Fatal("Expected Drain to time out while uploads are blocked")
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !dispatcher.Drain(ctx) {
+		t.Fatal("Expected Drain to finish once the destination responds")
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be delivered and removed before Drain returned", file)
+		}
+	}
+}
+
+func TestDispatcherQueueState(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatalf("Failed to create watch dir: %v", err)
+	}
+	stateFile := filepath.Join(tmpDir, "queue.json")
+	files := []string{filepath.Join(watchDir, "a.txt"), filepath.Join(watchDir, "b.txt"), filepath.Join(watchDir, "c.txt")}
+	for _, file := range files {
+		if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+
+	// The destination hangs, so the service stops with every file undelivered
+	release := make(chan struct{})
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer blocked.Close()
+	defer close(release)
+
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: blocked.URL}, shadowMgr, 1, 10)
+	dispatcher.SetQueueStateFile(stateFile)
+	dispatcher.Start(context.Background())
+	for _, file := range files {
+		if err := dispatcher.EnqueueWithPriority(file, file == files[1], config.PriorityNormal); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	dispatcher.Stop()
+
+	// A file deleted while the service was down is forgotten
+	if err := os.Remove(files[2]); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+
+	var uploaded []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		uploaded = append(uploaded, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dispatcher = NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+	dispatcher.SetQueueStateFile(stateFile)
+	dispatcher.Start(context.Background())
+
+	var resumed []QueuedFile
+	n := dispatcher.Resume(func(f QueuedFile) error {
+		resumed = append(resumed, f)
+		return dispatcher.EnqueueWithPriority(f.Path, f.ProcessedDueToTimeout, f.Priority)
+	})
+	if n != 2 || len(resumed) != 2 || resumed[0].Path != files[0] || resumed[1].Path != files[1] {
+		t.Fatalf("Expected a.txt and b.txt to be resumed in order, got %d %+v", n, resumed)
+	}
+	if resumed[0].ProcessedDueToTimeout || !resumed[1].ProcessedDueToTimeout || resumed[0].Priority != config.PriorityNormal {
+		t.Errorf("Expected the enqueue flags to be restored, got %+v", resumed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !dispatcher.Drain(ctx) {
+		t.Fatal("Expected the resumed files to be delivered")
+	}
+	dispatcher.Stop()
+
+	mu.Lock()
+	if len(uploaded) != 2 {
+		t.Errorf("Expected 2 uploads, got %v", uploaded)
+	}
+	mu.Unlock()
+
+	// Delivered files are removed from the state
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to read queue state: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "[]" {
+		t.Errorf("Expected an empty queue state, got %s", data)
+	}
+}
+
+func TestDispatcherFileState(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "a.txt")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, err := filestate.Open(config.FileStateConfig{Enabled: true, Path: filepath.Join(tmpDir, "state", "files.jsonl")})
+	if err != nil {
+		t.Fatalf("Failed to open file state: %v", err)
+	}
+	defer store.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+	dispatcher.SetName("test")
+	dispatcher.SetFileState(store)
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	if err := dispatcher.Enqueue(file, false); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !dispatcher.Drain(ctx) {
+		t.Fatal("Expected the file to be delivered")
+	}
+
+	files, err := store.Lookup(filestate.Query{})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one tracked file, got %v %+v", err, files)
+	}
+	var stages []string
+	for _, s := range files[0].Stages {
+		stages = append(stages, s.Stage)
+	}
+	if files[0].Directory != "test" || strings.Join(stages, ",") != "enqueued,uploaded,deleted" {
+		t.Errorf("Expected enqueued, uploaded and deleted for test, got %s %v", files[0].Directory, stages)
+	}
+}
+
+func TestDispatcherScan(t *testing.T) {
+	watchDir := t.TempDir()
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+
+	// clamd reporting files containing "EICAR" as infected
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			_, _ = r.ReadString(0)
+			var content bytes.Buffer
+			for {
+				var n uint32
+				if binary.Read(r, binary.BigEndian, &n) != nil || n == 0 {
+					break
+				}
+				_, _ = io.CopyN(&content, r, int64(n))
+			}
+			if bytes.Contains(content.Bytes(), []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+
+	uploaded := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, header, err := r.FormFile("file")
+		if err == nil {
+			uploaded <- header.Filename
+		}
+	}))
+	defer server.Close()
+
+	scanner, err := scan.New("scanned", config.ScanConfig{Enabled: true, Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+	dispatcher.SetName("scanned")
+	dispatcher.SetWatchPath(watchDir)
+	dispatcher.SetScanner(scanner, quarantineDir)
+	removed := make(chan string, 1)
+	dispatcher.SetOnRemoved(func(path string) { removed <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	infected := filepath.Join(watchDir, "invoice.pdf.exe")
+	clean := filepath.Join(watchDir, "invoice.pdf")
+	if err := os.WriteFile(infected, []byte("X5O!P%@AP EICAR"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(clean, []byte("%PDF-1.7"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	dispatcher.Enqueue(infected, false)
+	dispatcher.Enqueue(clean, false)
+
+	select {
+	case path := <-removed:
+		if path != infected {
+			t.Errorf("Expected the infected file to leave the queue, got %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Infected file not quarantined within timeout")
+	}
+	select {
+	case name := <-uploaded:
+		if name != "invoice.pdf" {
+			t.Errorf("Expected only the clean file to be uploaded, got %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Clean file not uploaded within timeout")
+	}
+
+	if _, err := os.Stat(infected); !os.IsNotExist(err) {
+		t.Errorf("Expected the infected file to be moved out of the watch path: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(quarantineDir, "invoice.pdf.exe"+quarantine.SidecarSuffix))
+	if err != nil {
+		t.Fatalf("Expected a quarantine sidecar: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"reason": "infected"`)) || !bytes.Contains(data, []byte("Eicar-Test-Signature")) {
+		t.Errorf("Unexpected sidecar: %s", data)
+	}
+}
+
+func TestDispatcherPostUploadWebhook(t *testing.T) {
+	watchDir := t.TempDir()
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upload-ID", "u-42")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"u-42"}`))
+	}))
+	defer server.Close()
+
+	var failing atomic.Bool
+	deliveries := make(chan Delivery, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tracking" {
+			t.Errorf("Expected the configured webhook header, got %q", r.Header.Get("Authorization"))
+		}
+		var delivery Delivery
+		if err := json.NewDecoder(r.Body).Decode(&delivery); err != nil {
+			t.Errorf("Failed to decode delivery: %v", err)
+		}
+		deliveries <- delivery
+		if failing.Load() {
+			http.Error(w, "tracking unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer webhook.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL + "/upload"}, shadowMgr, 1, 10)
+	dispatcher.SetName("tracked")
+	dispatcher.SetWatchPath(watchDir)
+	dispatcher.SetPostUpload(config.PostUploadConfig{Enabled: true, URL: webhook.URL,
+		Headers: map[string]string{"Authorization": "Bearer tracking"}, MaxRetries: 1}, quarantineDir)
+	uploaded := make(chan string, 2)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { uploaded <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	content := []byte("shipment 1")
+	file := filepath.Join(watchDir, "shipment.csv")
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	dispatcher.Enqueue(file, false)
+
+	var delivery Delivery
+	select {
+	case delivery = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook not called within timeout")
+	}
+	sum := sha256.Sum256(content)
+	if delivery.Directory != "tracked" || delivery.Path != file || delivery.RelativePath != "shipment.csv" ||
+		delivery.Size != int64(len(content)) || delivery.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected delivery: %+v", delivery)
+	}
+	if delivery.Destination != server.URL+"/upload" || delivery.Status != http.StatusCreated ||
+		delivery.ResponseHeaders["X-Upload-Id"] != "u-42" || delivery.ResponseBody != `{"id":"u-42"}` {
+		t.Errorf("Unexpected response metadata: %+v", delivery)
+	}
+	<-uploaded
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := os.Stat(file); err == nil && time.Now().Before(deadline); _, err = os.Stat(file) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected the source to be deleted after the hook succeeded: %v", err)
+	}
+
+	// A file whose hook fails after its retries is quarantined, not left to
+	// be delivered again
+	failing.Store(true)
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	dispatcher.Enqueue(file, false)
+	for range 2 {
+		select {
+		case <-deliveries:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Webhook not retried within timeout")
+		}
+	}
+	<-uploaded
+	deadline = time.Now().Add(5 * time.Second)
+	for postUploadHooks.With("tracked", "failed").Value() < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := postUploadHooks.With("tracked", "failed").Value(); got != 1 {
+		t.Errorf("Expected 1 failed hook, got %v", got)
+	}
+	sidecar := filepath.Join(quarantineDir, "shipment.csv"+quarantine.SidecarSuffix)
+	for _, err := os.Stat(sidecar); err != nil && time.Now().Before(deadline); _, err = os.Stat(sidecar) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatalf("Expected a quarantine sidecar: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"reason": "hook_failed"`)) || !bytes.Contains(data, []byte("503")) {
+		t.Errorf("Unexpected sidecar: %s", data)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected the source to leave the watch path after the hook failed: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(quarantineDir, "shipment.csv")); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Expected the file in quarantine, got %q (%v)", got, err)
+	}
+}
+
+func TestDispatcherPostUploadFailureWithoutQuarantine(t *testing.T) {
+	watchDir := t.TempDir()
+	var uploads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads.Add(1)
+	}))
+	defer server.Close()
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "tracking unavailable", http.StatusServiceUnavailable)
+	}))
+	defer webhook.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 1, 10)
+	dispatcher.SetName("untracked")
+	dispatcher.SetWatchPath(watchDir)
+	dispatcher.SetPostUpload(config.PostUploadConfig{Enabled: true, URL: webhook.URL, MaxRetries: 1}, "")
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	file := filepath.Join(watchDir, "shipment.csv")
+	if err := os.WriteFile(file, []byte("shipment 1"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	dispatcher.Enqueue(file, false)
+
+	// The delivered source is removed as after_upload says, so a later scan
+	// does not deliver it again
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := os.Stat(file); err == nil && time.Now().Before(deadline); _, err = os.Stat(file) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected the source to be deleted after the hook failed: %v", err)
+	}
+	if got := postUploadHooks.With("untracked", "failed").Value(); got != 1 {
+		t.Errorf("Expected 1 failed hook, got %v", got)
+	}
+	if n := uploads.Load(); n != 1 {
+		t.Errorf("Expected 1 upload, got %d", n)
+	}
+}
+
+func TestPostUploadCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	watchDir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "hook.out")
+	file := filepath.Join(watchDir, "shipment.csv")
+	if err := os.WriteFile(file, []byte("shipment 1"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	dispatcher := NewDispatcher(config.OutboundConfig{Type: config.OutboundLocalDir}, nil, 1, 10)
+	dispatcher.SetName("tracked")
+	dispatcher.SetWatchPath(watchDir)
+	dispatcher.SetPostUpload(config.PostUploadConfig{Enabled: true,
+		Command: []string{"sh", "-c", `printf '%s %s ' "$XFERD_RELATIVE_PATH" "$XFERD_DESTINATION" > "$0" && cat >> "$0"`, out}}, "")
+	rec := &receipt{destination: "/srv/outbox/shipment.csv"}
+	if err := dispatcher.runHook(context.Background(), 1, file, 10, "abc123", rec); err != nil {
+		t.Fatalf("Hook failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Expected the command to run: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "shipment.csv /srv/outbox/shipment.csv {") || !strings.Contains(string(data), `"checksum":"abc123"`) {
+		t.Errorf("Unexpected command input: %s", data)
+	}
+
+	dispatcher.SetPostUpload(config.PostUploadConfig{Enabled: true, Command: []string{"sh", "-c", "echo tracking down >&2; exit 3"}, MaxRetries: 1}, "")
+	err = dispatcher.runHook(context.Background(), 1, file, 10, "abc123", rec)
+	if err == nil || !strings.Contains(err.Error(), "tracking down") {
+		t.Errorf("Expected the command's output in the error, got %v", err)
+	}
+}
+
+func TestUploadCompression(t *testing.T) {
+	dir := t.TempDir()
+	text := bytes.Repeat([]byte("2026-10-15T12:00:00Z INFO request served in 3ms\n"), 200)
+	logFile := filepath.Join(dir, "app.log")
+	smallFile := filepath.Join(dir, "small.log")
+	imageFile := filepath.Join(dir, "photo.png")
+	for path, content := range map[string][]byte{logFile: text, smallFile: []byte("tiny\n"), imageFile: append([]byte("\x89PNG\r\n\x1a\n"), text...)} {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		format    string
+		mode      string
+		threshold int64
+		file      string
+		wantName  string
+		encoding  string // expected Content-Encoding
+		gzipped   bool   // file content arrives gzipped inside an uncompressed body
+	}{
+		{"header raw", config.BodyFormatRaw, config.CompressionHeader, 0, logFile, "app.log", "gzip", false},
+		{"header multipart", config.BodyFormatMultipart, config.CompressionHeader, 0, logFile, "app.log", "gzip", false},
+		{"header multipart streamed", config.BodyFormatMultipart, config.CompressionHeader, 100, logFile, "app.log", "gzip", false},
+		{"suffix raw streamed", config.BodyFormatRaw, config.CompressionSuffix, 100, logFile, "app.log.gz", "", true},
+		{"suffix multipart", config.BodyFormatMultipart, config.CompressionSuffix, 0, logFile, "app.log.gz", "", true},
+		{"below min size", config.BodyFormatRaw, config.CompressionHeader, 0, smallFile, "small.log", "", false},
+		{"excluded type", config.BodyFormatRaw, config.CompressionSuffix, 0, imageFile, "photo.png", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var name, encoding string
+			var received []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding = r.Header.Get("Content-Encoding")
+				body := io.Reader(r.Body)
+				if encoding == "gzip" {
+					gz, err := gzip.NewReader(r.Body)
+					if err != nil {
+						t.Errorf("Expected a gzipped body: %v", err)
+						return
+					}
+					body = gz
+				}
+				if tt.format == config.BodyFormatRaw {
+					name = r.Header.Get("X-Filename")
+					received, _ = io.ReadAll(body)
+					return
+				}
+				r.Body = io.NopCloser(body)
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					t.Errorf("Failed to read form file: %v", err)
+					return
+				}
+				name = header.Filename
+				received, _ = io.ReadAll(file)
+			}))
+			defer server.Close()
+
+			uploader := NewUploader(config.OutboundConfig{URL: server.URL + "/{filename}", BodyFormat: tt.format, StreamThresholdBytes: tt.threshold,
+				Compression: config.CompressionConfig{Enabled: true, Mode: tt.mode}})
+			if err := uploader.Upload(context.Background(), tt.file); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if name != tt.wantName || encoding != tt.encoding {
+				t.Errorf("Expected %s with encoding %q, got %s with %q", tt.wantName, tt.encoding, name, encoding)
+			}
+			if tt.gzipped {
+				gz, err := gzip.NewReader(bytes.NewReader(received))
+				if err != nil {
+					t.Fatalf("Expected gzipped content: %v", err)
+				}
+				received, _ = io.ReadAll(gz)
+			}
+			want, _ := os.ReadFile(tt.file)
+			if !bytes.Equal(received, want) {
+				t.Errorf("Expected the file content after decompression, got %d bytes", len(received))
+			}
+		})
+	}
+}
+
+// pgpRecipient writes the public key of a new OpenPGP entity to a key file
+func pgpRecipient(t *testing.T) (*openpgp.Entity, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Partner", "", "partner@example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var key bytes.Buffer
+	if err := entity.Serialize(&key); err != nil {
+		t.Fatalf("Failed to serialize key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "partner.gpg")
+	if err := os.WriteFile(keyFile, key.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	return entity, keyFile
+}
+
+// pgpDecrypt decrypts content encrypted for entity
+func pgpDecrypt(t *testing.T, entity *openpgp.Entity, encrypted []byte) []byte {
+	t.Helper()
+	md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected encrypted content: %v", err)
+	}
+	plain, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatalf("Failed to decrypt content: %v", err)
+	}
+	return plain
+}
+
+func TestUploadEncryption(t *testing.T) {
+	entity, keyFile := pgpRecipient(t)
+	content := bytes.Repeat([]byte("id,amount\n1,100\n"), 500)
+	testFile := filepath.Join(t.TempDir(), "payments.csv")
+	if err := os.WriteFile(testFile, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	encryption := config.EncryptionConfig{Enabled: true, Type: config.EncryptionPGP, KeyFile: keyFile}
+
+	tests := []struct {
+		name      string
+		format    string
+		threshold int64
+		compress  bool
+		wantName  string
+	}{
+		{"raw", config.BodyFormatRaw, 0, false, "payments.csv.pgp"},
+		{"raw streamed", config.BodyFormatRaw, 100, false, "payments.csv.pgp"},
+		{"multipart", config.BodyFormatMultipart, 0, false, "payments.csv.pgp"},
+		{"multipart streamed", config.BodyFormatMultipart, 100, false, "payments.csv.pgp"},
+		{"compressed", config.BodyFormatRaw, 100, true, "payments.csv.gz.pgp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var name string
+			var received []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.format == config.BodyFormatRaw {
+					name = r.Header.Get("X-Filename")
+					received, _ = io.ReadAll(r.Body)
+					return
+				}
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					t.Errorf("Failed to read form file: %v", err)
+					return
+				}
+				name = header.Filename
+				received, _ = io.ReadAll(file)
+			}))
+			defer server.Close()
+
+			cfg := config.OutboundConfig{URL: server.URL + "/{filename}", BodyFormat: tt.format, StreamThresholdBytes: tt.threshold, Encryption: encryption}
+			if tt.compress {
+				cfg.Compression = config.CompressionConfig{Enabled: true, Mode: config.CompressionSuffix}
+			}
+			if err := NewUploader(cfg).Upload(context.Background(), testFile); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if name != tt.wantName {
+				t.Errorf("Expected %s, got %s", tt.wantName, name)
+			}
+			if bytes.Contains(received, []byte("id,amount")) {
+				t.Fatal("Expected no plaintext to be sent")
+			}
+			plain := pgpDecrypt(t, entity, received)
+			if tt.compress {
+				gz, err := gzip.NewReader(bytes.NewReader(plain))
+				if err != nil {
+					t.Fatalf("Expected gzipped content: %v", err)
+				}
+				plain, _ = io.ReadAll(gz)
+			}
+			if !bytes.Equal(plain, content) {
+				t.Errorf("Expected the file content after decryption, got %d bytes", len(plain))
+			}
+		})
+	}
+
+	t.Run("unusable key", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { requests++ }))
+		defer server.Close()
+		uploader := NewUploader(config.OutboundConfig{URL: server.URL,
+			Encryption: config.EncryptionConfig{Enabled: true, Type: config.EncryptionPGP, KeyFile: filepath.Join(t.TempDir(), "missing.asc")}})
+		if err := uploader.Upload(context.Background(), testFile); err == nil {
+			t.Error("Expected upload to fail without its encryption key")
+		}
+		if requests != 0 {
+			t.Errorf("Expected nothing to be sent, got %d requests", requests)
+		}
+	})
+}
+
+func TestDispatcherBatch(t *testing.T) {
+	watchDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(watchDir, "sensor"), 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+
+	type upload struct {
+		name    string
+		entries map[string]string
+	}
+	uploads := make(chan upload, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Failed to read form file: %v", err)
+			return
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Errorf("Expected a tar.gz archive: %v", err)
+			return
+		}
+		entries := map[string]string{}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			content, _ := io.ReadAll(tr)
+			entries[hdr.Name] = string(content)
+		}
+		uploads <- upload{header.Filename, entries}
+	}))
+	defer server.Close()
+
+	shadowMgr, err := shadow.NewManager(config.ShadowConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Failed to create shadow manager: %v", err)
+	}
+	dispatcher := NewDispatcher(config.OutboundConfig{URL: server.URL}, shadowMgr, 2, 10)
+	dispatcher.SetName("sensors")
+	dispatcher.SetWatchPath(watchDir)
+	if err := dispatcher.SetBatch(config.BatchConfig{Enabled: true, Format: config.BatchFormatTarGz, MaxFiles: 3, WindowMs: 200}, t.TempDir()); err != nil {
+		t.Fatalf("Failed to set up batches: %v", err)
+	}
+	delivered := make(chan string, 10)
+	dispatcher.SetOnSuccessfulUpload(func(path string) { delivered <- path })
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+
+	var files []string
+	for i := range 5 {
+		file := filepath.Join(watchDir, "sensor", fmt.Sprintf("reading-%d.json", i))
+		if err := os.WriteFile(file, []byte(fmt.Sprintf(`{"reading":%d}`, i)), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := dispatcher.Enqueue(file, false); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	// Three files fill the first batch, the other two are sent once the window passes
+	entries := map[string]string{}
+	for _, want := range []int{3, 2} {
+		select {
+		case u := <-uploads:
+			if len(u.entries) != want || !strings.HasPrefix(u.name, "sensors-") || !strings.HasSuffix(u.name, ".tar.gz") {
+				t.Errorf("Expected an archive of %d files, got %s with %v", want, u.name, u.entries)
+			}
+			maps.Copy(entries, u.entries)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Batch of %d files not uploaded within timeout", want)
+		}
+	}
+	for i := range 5 {
+		if got := entries[fmt.Sprintf("sensor/reading-%d.json", i)]; got != fmt.Sprintf(`{"reading":%d}`, i) {
+			t.Errorf("Unexpected content for reading %d: %q", i, got)
+		}
+	}
+	for range files {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Batched file not reported as delivered")
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, file := range files {
+		for _, err := os.Stat(file); err == nil && time.Now().Before(deadline); _, err = os.Stat(file) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted after delivery: %v", file, err)
+		}
+	}
+	if got := batches.With("sensors", "uploaded").Value(); got != 2 {
+		t.Errorf("Expected 2 uploaded batches, got %v", got)
+	}
+}
+
+func TestBatchWriteZip(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(file, []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %v", err)
+	}
+
+	b := &batcher{cfg: config.BatchConfig{Enabled: true, Format: config.BatchFormatZip}}
+	archive := filepath.Join(dir, "batch.zip")
+	member := &batchMember{event: fileEvent{path: file}, info: info}
+	if err := b.write(archive, filepath.Base, []*batchMember{member}); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	sum := sha256.Sum256([]byte("alpha"))
+	if member.checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the member to be hashed, got %q", member.checksum)
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 || zr.File[0].Name != "a.txt" {
+		t.Fatalf("Unexpected archive entries: %v", zr.File)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatalf("Failed to open entry: %v", err)
+	}
+	defer rc.Close()
+	if content, _ := io.ReadAll(rc); string(content) != "alpha" {
+		t.Errorf("Unexpected entry content: %q", content)
+	}
+}
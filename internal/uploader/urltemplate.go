@@ -0,0 +1,111 @@
+package uploader
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/strftime"
+)
+
+// urlFields are the placeholders available in outbound URL templates.
+// Values are URL-escaped; RelPath keeps its slashes.
+type urlFields struct {
+	Filename  string // base name of the file
+	Directory string // name of the watched directory
+	RelPath   string // path relative to the watch directory
+	now       time.Time
+}
+
+// Date formats the upload time (UTC) with a Go time layout, or with
+// strftime-style tokens such as %Y/%m/%d if the layout contains any
+func (f urlFields) Date(layout string) (string, error) {
+	if !strings.Contains(layout, "%") {
+		return f.now.Format(layout), nil
+	}
+	if err := strftime.Validate(layout); err != nil {
+		return "", err
+	}
+	return strftime.Format(layout, f.now), nil
+}
+
+// parseURLTemplate parses an outbound URL template. The legacy {filename}
+// placeholder is kept as an alias for {{.Filename}}. The template is executed
+// once with sample values so unknown placeholders fail at startup.
+func parseURLTemplate(rawURL string) (*template.Template, error) {
+	tmpl, err := template.New("url").Parse(strings.ReplaceAll(rawURL, "{filename}", "{{.Filename}}"))
+	if err != nil {
+		return nil, err
+	}
+	sample := urlFields{Filename: "file", Directory: "directory", RelPath: "file", now: time.Now()}
+	if err := tmpl.Execute(new(strings.Builder), sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// fileURL expands the outbound URL template for a file
+func (u *Uploader) fileURL(filePath string) (string, error) {
+	if u.urlErr != nil {
+		return "", fmt.Errorf("invalid outbound url template: %w", u.urlErr)
+	}
+	return u.expandURL(u.urlTemplate, filePath)
+}
+
+// expandURL expands a URL template for a file
+func (u *Uploader) expandURL(tmpl *template.Template, filePath string) (string, error) {
+	segments := strings.Split(u.relPath(filePath), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	var b strings.Builder
+	err := tmpl.Execute(&b, urlFields{
+		Filename:  url.PathEscape(filepath.Base(filePath)),
+		Directory: url.PathEscape(u.directory),
+		RelPath:   strings.Join(segments, "/"),
+		now:       time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to expand outbound url: %w", err)
+	}
+	if u.config.RelativePath.Mode != config.RelativePathURL {
+		return b.String(), nil
+	}
+
+	// The relative path is appended as path segments, keeping any query
+	target, err := url.Parse(b.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse outbound url: %w", err)
+	}
+	return target.JoinPath(strings.Split(u.relPath(filePath), "/")...).String(), nil
+}
+
+// relPath returns the slash-separated path of a file relative to the watch
+// directory, or its base name if it is outside
+func (u *Uploader) relPath(filePath string) string {
+	if u.watchPath != "" {
+		if r, err := filepath.Rel(u.watchPath, filePath); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(r)
+		}
+	}
+	return filepath.Base(filePath)
+}
+
+// staticURL returns the part of the outbound URL before the first
+// placeholder, used where no file is involved such as connection warm-up
+func (u *Uploader) staticURL() string {
+	return staticPrefix(u.config.URL)
+}
+
+// staticPrefix returns the part of a URL template before the first placeholder
+func staticPrefix(rawURL string) string {
+	if i := strings.Index(rawURL, "{"); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
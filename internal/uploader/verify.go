@@ -0,0 +1,60 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checksumHeader carries the SHA-256 digest of the file in verify requests
+const checksumHeader = "X-Checksum-SHA256"
+
+// verifyURL returns the verification endpoint for an uploaded file. By default
+// it is the URL the file was uploaded to.
+func (u *Uploader) verifyURL(uploadURL, id, checksum string) string {
+	tmpl := u.config.Verify.URL
+	if tmpl == "" {
+		return uploadURL
+	}
+	tmpl = strings.ReplaceAll(tmpl, "{upload_id}", url.PathEscape(id))
+	return strings.ReplaceAll(tmpl, "{checksum}", checksum)
+}
+
+// verify asks the destination whether it persisted an uploaded file. Any
+// 2xx response confirms the file; anything else fails the upload so the
+// source is kept and retried.
+func (u *Uploader) verify(ctx context.Context, filePath, uploadURL string, resp *uploadResponse) error {
+	if !u.config.Verify.Enabled {
+		return nil
+	}
+
+	checksum, err := hashFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash file for verification: %w", err)
+	}
+	id := u.uploadID(resp)
+	if id == "" && strings.Contains(u.config.Verify.URL, "{upload_id}") {
+		return fmt.Errorf("destination did not return an upload ID to verify")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, u.config.Verify.GetMethod(), u.verifyURL(uploadURL, id, checksum), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create verify request: %w", err)
+	}
+	req.Header.Set(checksumHeader, checksum)
+	if id != "" {
+		req.Header.Set(uploadIDHeader, id)
+	}
+	if err := u.addAuth(req); err != nil {
+		return err
+	}
+
+	if _, err := u.doWithRetry(req, filePath, 0, nil); err != nil {
+		return fmt.Errorf("failed to verify upload: %w", err)
+	}
+
+	u.logf(filePath, "Upload verified: %s (sha256: %s)", filePath, checksum)
+	return nil
+}
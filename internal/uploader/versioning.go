@@ -0,0 +1,171 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// deliveryRecord identifies the content of a file as it was last delivered
+type deliveryRecord struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash,omitempty"` // only set when detecting changes by hash
+	Version int       `json:"version"`
+}
+
+// sameContent reports whether two records describe the same file content
+func (r deliveryRecord) sameContent(other deliveryRecord) bool {
+	if r.Hash != "" || other.Hash != "" {
+		return r.Hash == other.Hash
+	}
+	return r.Size == other.Size && r.ModTime.Equal(other.ModTime)
+}
+
+// deliveryHistory tracks delivered files so that files overwritten in place
+// are re-delivered as new versions and unchanged files are not sent twice
+type deliveryHistory struct {
+	config  config.VersioningConfig
+	mu      sync.Mutex
+	records map[string]deliveryRecord // path -> last delivery
+}
+
+// newDeliveryHistory creates a delivery history, loading the state file if configured
+func newDeliveryHistory(cfg config.VersioningConfig) *deliveryHistory {
+	h := &deliveryHistory{
+		config:  cfg,
+		records: make(map[string]deliveryRecord),
+	}
+
+	if cfg.StateFile != "" {
+		data, err := os.ReadFile(cfg.StateFile)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			log.Printf("Failed to read delivery history %s: %v", cfg.StateFile, err)
+		default:
+			if err := json.Unmarshal(data, &h.records); err != nil {
+				log.Printf("Failed to parse delivery history %s: %v", cfg.StateFile, err)
+			}
+		}
+	}
+
+	return h
+}
+
+// SetReadOnly tracks the delivered files of a directory whose files are
+// never deleted or moved, so that scans and restarts skip them until they
+// change. With versioning enabled its history is used. Must be called
+// before Start.
+func (d *Dispatcher) SetReadOnly(cfg config.ReadOnlyStateConfig) {
+	d.afterUpload = config.AfterUploadKeep
+	if d.history == nil {
+		d.history = newDeliveryHistory(config.VersioningConfig{Detect: cfg.Detect, StateFile: cfg.StateFile})
+	}
+}
+
+// fingerprint describes the current content of a file
+func (h *deliveryHistory) fingerprint(filePath string) (deliveryRecord, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return deliveryRecord{}, err
+	}
+
+	if h.config.Detect != "hash" {
+		return deliveryRecord{Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+
+	sum, err := hashFile(filePath)
+	if err != nil {
+		return deliveryRecord{}, err
+	}
+	return deliveryRecord{Size: info.Size(), Hash: sum}, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 of a file's content
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// nextVersion returns the version to deliver for a file with the given fingerprint,
+// or 0 if the same content was already delivered
+func (h *deliveryHistory) nextVersion(filePath string, fp deliveryRecord) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	last, ok := h.records[filePath]
+	if !ok {
+		return 1
+	}
+	if last.sameContent(fp) {
+		return 0
+	}
+	return last.Version + 1
+}
+
+// record stores a successful delivery and persists the history if configured
+func (h *deliveryHistory) record(filePath string, fp deliveryRecord, version int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fp.Version = version
+	h.records[filePath] = fp
+
+	if h.config.StateFile == "" {
+		return
+	}
+	if err := h.save(); err != nil {
+		log.Printf("Failed to save delivery history %s: %v", h.config.StateFile, err)
+	}
+}
+
+// save atomically writes the history to the state file. Callers must hold h.mu.
+func (h *deliveryHistory) save() error {
+	data, err := json.Marshal(h.records)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.config.StateFile), ".xferd-history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.config.StateFile)
+}
+
+// setVersionHeader adds the delivery version indicator to an upload request
+func setVersionHeader(req *http.Request, version int) {
+	if version > 0 {
+		req.Header.Set("X-File-Version", strconv.Itoa(version))
+	}
+}
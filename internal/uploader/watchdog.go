@@ -0,0 +1,128 @@
+package uploader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+var stuckUploads = metrics.NewCounterVec("xferd_stuck_uploads_total",
+	"Uploads that exceeded the per-file deadline, by outcome: cancelled (upload aborted) or restarted (worker did not return after cancellation and was replaced)",
+	"directory", "outcome")
+
+// maxStuckGrace bounds how long a cancelled upload may take to return before
+// its worker is replaced
+const maxStuckGrace = 30 * time.Second
+
+// workerSlot tracks the file a worker is processing so the watchdog can
+// replace workers that hang past the upload deadline
+type workerSlot struct {
+	mu        sync.Mutex
+	path      string
+	started   time.Time // zero while idle
+	abandoned bool      // replaced by the watchdog, which released the worker's wg and limit slots
+}
+
+// begin marks the worker busy with path
+func (s *workerSlot) begin(path string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.started = time.Now()
+}
+
+// finish marks the worker idle. Returns true if it was replaced in the
+// meantime and must exit without releasing anything. It may be called again
+// after the worker went idle.
+func (s *workerSlot) finish() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = time.Time{}
+	return s.abandoned
+}
+
+// SetUploadDeadline sets a hard per-file upload deadline. Uploads running
+// longer are cancelled, and workers that do not return within a grace period
+// afterwards are replaced. Zero disables the watchdog. Must be called before Start.
+func (d *Dispatcher) SetUploadDeadline(deadline time.Duration) {
+	d.deadline = deadline
+}
+
+// uploadContext returns the context for one upload attempt
+func (d *Dispatcher) uploadContext() (context.Context, context.CancelFunc) {
+	if d.deadline <= 0 {
+		return context.WithCancel(d.ctx)
+	}
+	return context.WithTimeout(d.ctx, d.deadline)
+}
+
+// startWorker starts worker id, registering it with the watchdog if enabled
+func (d *Dispatcher) startWorker(id int) {
+	var slot *workerSlot
+	if d.deadline > 0 {
+		slot = &workerSlot{}
+		d.workersMu.Lock()
+		d.workers[id] = slot
+		d.workersMu.Unlock()
+	}
+	d.wg.Add(1)
+	go d.worker(id, slot)
+}
+
+// watchdog replaces workers still busy a grace period after their upload
+// deadline passed, e.g. blocked on I/O that ignores cancellation
+func (d *Dispatcher) watchdog() {
+	grace := min(d.deadline, maxStuckGrace)
+	ticker := time.NewTicker(min(d.deadline/4, 10*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.replaceStuckWorkers(now.Add(-d.deadline - grace))
+		}
+	}
+}
+
+// replaceStuckWorkers abandons workers busy since before cutoff and starts a
+// replacement with the same id and queue. The abandoned goroutine exits once
+// its upload returns.
+func (d *Dispatcher) replaceStuckWorkers(cutoff time.Time) {
+	d.workersMu.Lock()
+	defer d.workersMu.Unlock()
+
+	if d.ctx.Err() != nil {
+		return
+	}
+	for id, slot := range d.workers {
+		slot.mu.Lock()
+		stuck := !slot.started.IsZero() && slot.started.Before(cutoff)
+		if stuck {
+			slot.abandoned = true
+			d.logf(slot.path, "Upload worker %d stuck on %s for %v, starting a replacement",
+				id, slot.path, time.Since(slot.started).Round(time.Second))
+		}
+		slot.mu.Unlock()
+		if !stuck {
+			continue
+		}
+
+		stuckUploads.With(d.name, "restarted").Inc()
+		d.uploader.adaptive.release()
+		d.limit.release()
+		d.workers[id] = &workerSlot{}
+		d.wg.Add(1)
+		go d.worker(id, d.workers[id])
+		d.wg.Done() // on behalf of the abandoned worker
+	}
+}
@@ -0,0 +1,109 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// coalescedWrites counts write events folded into a pending stability check
+var coalescedWrites = metrics.NewCounterVec("xferd_watcher_coalesced_events_total",
+	"Write events coalesced into a stability check already pending for the file",
+	"directory")
+
+// pendingWrite is a file waiting for its writes to settle
+type pendingWrite struct {
+	timer *time.Timer
+	first time.Time // first write event since the file was last checked
+}
+
+// debouncer coalesces the write events of each file: every event resets the
+// file's timer, and fire runs once, in the timer's goroutine, after the file
+// saw no event for the window or the maximum wait since its first event passed.
+// A slow copy thus costs one timer instead of a stability check per event.
+type debouncer struct {
+	name    string // directory, for metrics
+	window  time.Duration
+	maxWait time.Duration
+	fire    func(path string, first time.Time)
+	mu      sync.Mutex
+	pending map[string]*pendingWrite
+	stopped bool
+	wg      sync.WaitGroup // running fire calls
+}
+
+// newDebouncer creates a debouncer calling fire for settled files
+func newDebouncer(name string, window, maxWait time.Duration, fire func(path string, first time.Time)) *debouncer {
+	return &debouncer{name: name, window: window, maxWait: maxWait, fire: fire, pending: make(map[string]*pendingWrite)}
+}
+
+// touch records a write event for path
+func (d *debouncer) touch(path string) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+
+	p, ok := d.pending[path]
+	if !ok {
+		p = &pendingWrite{first: now}
+		d.pending[path] = p
+		p.timer = time.AfterFunc(d.window, func() { d.settle(path, p) })
+		return
+	}
+
+	coalescedWrites.With(d.name).Inc()
+	delay := d.window
+	if remaining := d.maxWait - now.Sub(p.first); remaining < delay {
+		delay = max(remaining, 0)
+	}
+	p.timer.Reset(delay)
+}
+
+// settle hands a file whose timer expired to fire
+func (d *debouncer) settle(path string, p *pendingWrite) {
+	d.mu.Lock()
+	if d.pending[path] != p || d.stopped {
+		d.mu.Unlock()
+		return // cancelled, or settled already
+	}
+	delete(d.pending, path)
+	d.wg.Add(1)
+	d.mu.Unlock()
+
+	defer d.wg.Done()
+	d.fire(path, p.first)
+}
+
+// cancel forgets a file, e.g. one that was removed
+func (d *debouncer) cancel(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, ok := d.pending[path]; ok {
+		p.timer.Stop()
+		delete(d.pending, path)
+	}
+}
+
+// isPending reports whether a file is waiting for its writes to settle
+func (d *debouncer) isPending(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.pending[path]
+	return ok
+}
+
+// stop cancels all pending files and waits for running fire calls
+func (d *debouncer) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	for path, p := range d.pending {
+		p.timer.Stop()
+		delete(d.pending, path)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// IngestWatcher delivers files received by the REST API without watching the
+// filesystem (watch mode none). Uploads are handed over by Add as soon as
+// they are committed; files already in the watch path are picked up once by
+// the startup scan.
+type IngestWatcher struct {
+	config        config.DirectoryConfig
+	handler       EventHandler
+	enqueuedFiles sync.Map // tracks files that have been enqueued for upload
+}
+
+// NewIngestWatcher creates a watcher for a directory in watch mode none
+func NewIngestWatcher(cfg config.DirectoryConfig, handler EventHandler) *IngestWatcher {
+	return &IngestWatcher{config: cfg, handler: handler}
+}
+
+// Start performs the startup scan if enabled
+func (w *IngestWatcher) Start(_ context.Context) error {
+	if w.config.Watch.IsStartupReconcileScanEnabled() {
+		log.Printf("Performing startup reconciliation scan for: %s", w.config.WatchPath)
+		files, err := ScanBacklog(w.config)
+		if err != nil {
+			log.Printf("Startup scan error: %v", err)
+		}
+		for path := range files {
+			w.Add(path)
+		}
+	}
+
+	log.Printf("Ingest watcher started for: %s (watch mode none)", w.config.WatchPath)
+	return nil
+}
+
+// Stop stops the watcher
+func (w *IngestWatcher) Stop() error {
+	log.Printf("Ingest watcher stopped for: %s", w.config.WatchPath)
+	return nil
+}
+
+// ClearEnqueued removes a file from the enqueued tracking
+func (w *IngestWatcher) ClearEnqueued(path string) {
+	w.enqueuedFiles.Delete(path)
+}
+
+// MarkEnqueued records a file enqueued by the service, e.g. resumed from the
+// queue state, so that it is not enqueued again
+func (w *IngestWatcher) MarkEnqueued(path string) {
+	w.enqueuedFiles.Store(path, true)
+}
+
+// Add hands a complete file over for upload. Uploads are committed
+// atomically, so no stability check is needed.
+func (w *IngestWatcher) Add(path string) {
+	event, err := processFile(path, true, w.config)
+	if err != nil {
+		log.Printf("Error processing file %s: %v", path, err)
+		return
+	}
+	if event.Path == "" {
+		return // Ignored or disappeared
+	}
+
+	if _, alreadyEnqueued := w.enqueuedFiles.LoadOrStore(path, true); alreadyEnqueued {
+		return
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling file %s: %v", path, err)
+		w.enqueuedFiles.Delete(path) // Remove on failure
+	}
+}
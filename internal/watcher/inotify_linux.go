@@ -0,0 +1,99 @@
+//go:build linux
+
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// closeWriteMask selects files closed after writing and files renamed into
+// place, which fsnotify does not tell apart from files still being written
+const closeWriteMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+// readInotify reads events from an inotify instance until it is closed and
+// calls fn for each, with the name relative to the watched directory
+func readInotify(inotify *os.File, fn func(wd int, name string, mask uint32)) {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := inotify.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("Watcher error: %v", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset])) // #nosec G103 -- decoding the kernel's inotify records
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+			offset += unix.SizeofInotifyEvent + int(raw.Len)
+			fn(int(raw.Wd), string(bytes.TrimRight(name, "\x00")), raw.Mask)
+		}
+	}
+}
+
+// closeWrites reports files closed by their writer or renamed into the
+// directories of a LinuxWatcher. fsnotify does not expose IN_CLOSE_WRITE,
+// so a second inotify instance watches the same directories.
+type closeWrites struct {
+	fd      int      // inotify descriptor, File.Fd would make inotify blocking
+	inotify *os.File // reads fd through the runtime poller
+	mu      sync.Mutex
+	dirs    map[int]string // watch descriptor -> directory
+}
+
+// newCloseWrites creates the inotify instance
+func newCloseWrites() (*closeWrites, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inotify instance: %w", err)
+	}
+	return &closeWrites{fd: fd, inotify: os.NewFile(uintptr(fd), "inotify"), dirs: make(map[int]string)}, nil
+}
+
+// add watches a directory
+func (c *closeWrites) add(dir string) error {
+	wd, err := unix.InotifyAddWatch(c.fd, dir, closeWriteMask)
+	if err != nil {
+		return fmt.Errorf("failed to add close watch for %s: %w", dir, err)
+	}
+	c.mu.Lock()
+	c.dirs[wd] = dir
+	c.mu.Unlock()
+	return nil
+}
+
+// run calls complete for every file closed after writing or renamed into
+// place, and overflow when events were lost, until close is called
+func (c *closeWrites) run(complete func(path string, renamed bool), overflow func()) {
+	readInotify(c.inotify, func(wd int, name string, mask uint32) {
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			overflow()
+			return
+		}
+		c.mu.Lock()
+		dir, ok := c.dirs[wd]
+		if mask&unix.IN_IGNORED != 0 {
+			delete(c.dirs, wd)
+		}
+		c.mu.Unlock()
+		if !ok || name == "" || mask&unix.IN_ISDIR != 0 {
+			return
+		}
+		complete(filepath.Join(dir, name), mask&unix.IN_MOVED_TO != 0)
+	})
+}
+
+// close stops run
+func (c *closeWrites) close() {
+	c.inotify.Close()
+}
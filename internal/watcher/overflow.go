@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// watcherOverflows counts kernel event queue overflows, after which events
+// were lost and the watch path is rescanned
+var watcherOverflows = metrics.NewCounterVec("xferd_watcher_overflows_total",
+	"Watcher event queue overflows; events were lost and the watch path was rescanned",
+	"directory")
+
+// recentPathTTL is how long a delivered path is remembered. It covers the
+// time between the dispatcher clearing a file and removing its source.
+const recentPathTTL = time.Minute
+
+// maxRecentPaths bounds the number of remembered paths
+const maxRecentPaths = 100000
+
+// recentPaths remembers when files were cleared after delivery, so that a
+// resync does not enqueue files whose source is about to be removed
+type recentPaths struct {
+	mu    sync.Mutex
+	paths map[string]time.Time
+}
+
+// add records that path was cleared at now, forgetting expired paths
+func (r *recentPaths) add(path string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paths == nil {
+		r.paths = make(map[string]time.Time)
+	}
+	if len(r.paths) >= maxRecentPaths {
+		for p, cleared := range r.paths {
+			if now.Sub(cleared) > recentPathTTL {
+				delete(r.paths, p)
+			}
+		}
+		if len(r.paths) >= maxRecentPaths {
+			return
+		}
+	}
+	r.paths[path] = now
+}
+
+// delivered reports whether path was cleared within the TTL and has not been
+// modified since
+func (r *recentPaths) delivered(path string, info os.FileInfo, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cleared, ok := r.paths[path]
+	if !ok {
+		return false
+	}
+	if now.Sub(cleared) > recentPathTTL {
+		delete(r.paths, path)
+		return false
+	}
+	return !info.ModTime().After(cleared)
+}
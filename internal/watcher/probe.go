@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// watchPathLatency reports how long the latest probe of each watch path took
+var watchPathLatency = metrics.NewGaugeVec("xferd_watch_path_latency_seconds",
+	"Duration of the latest stat and read of the watch path; while a probe hangs, how long it has been running",
+	"directory")
+
+// watchPathProbes counts watch path probes per directory
+var watchPathProbes = metrics.NewCounterVec("xferd_watch_path_probes_total",
+	"Watch path probes by result: ok, slow (above the threshold) or failed",
+	"directory", "result")
+
+// Probe periodically stats and reads a watch path, so that a hung or slow
+// network mount is reported instead of silently producing no events
+type Probe struct {
+	name      string
+	path      string
+	threshold time.Duration
+
+	mu      sync.Mutex
+	started time.Time     // zero unless a probe is running
+	latency time.Duration // duration of the last completed probe
+	err     error         // error of the last completed probe
+}
+
+// NewProbe creates a probe for the watch path of a directory. Probes slower
+// than threshold make Check fail.
+func NewProbe(name, path string, threshold time.Duration) *Probe {
+	return &Probe{name: name, path: path, threshold: threshold}
+}
+
+// Run probes the path every interval until ctx is cancelled. A probe that
+// blocks, e.g. on a hung NFS mount, is not started again until it returns.
+func (p *Probe) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.start()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// start runs a probe in the background unless one is still running, in
+// which case its running time is reported as the latency
+func (p *Probe) start() {
+	p.mu.Lock()
+	if !p.started.IsZero() {
+		running := time.Since(p.started)
+		p.mu.Unlock()
+		watchPathLatency.With(p.name).Set(running.Seconds())
+		log.Printf("Watch path probe for %s: %s not responding for %v", p.name, p.path, running.Round(time.Second))
+		return
+	}
+	p.started = time.Now()
+	p.mu.Unlock()
+
+	go p.probe()
+}
+
+// probe measures one stat and read of the path
+func (p *Probe) probe() {
+	start := time.Now()
+	err := probePath(p.path)
+	elapsed := time.Since(start)
+
+	p.mu.Lock()
+	p.started = time.Time{}
+	p.latency = elapsed
+	p.err = err
+	p.mu.Unlock()
+
+	watchPathLatency.With(p.name).Set(elapsed.Seconds())
+	switch {
+	case err != nil:
+		watchPathProbes.With(p.name, "failed").Inc()
+		log.Printf("Watch path probe for %s failed: %v", p.name, err)
+	case elapsed > p.threshold:
+		watchPathProbes.With(p.name, "slow").Inc()
+		log.Printf("Watch path probe for %s: %s took %v (threshold %v)", p.name, p.path, elapsed, p.threshold)
+	default:
+		watchPathProbes.With(p.name, "ok").Inc()
+	}
+}
+
+// probePath stats a directory and reads an entry from it
+func probePath(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// Check returns an error if the last probe failed or exceeded the threshold,
+// or if a probe has been running for longer than the threshold
+func (p *Probe) Check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.started.IsZero() {
+		if running := time.Since(p.started); running > p.threshold {
+			return fmt.Errorf("watch path of %s not responding for %v", p.name, running.Round(time.Millisecond))
+		}
+	}
+	if p.err != nil {
+		return fmt.Errorf("watch path of %s: %w", p.name, p.err)
+	}
+	if p.latency > p.threshold {
+		return fmt.Errorf("watch path of %s slow: %v (threshold %v)", p.name, p.latency.Round(time.Millisecond), p.threshold)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// rotationSkipped counts files not delivered because of the rotation settings
+var rotationSkipped = metrics.NewCounterVec("xferd_rotation_skipped_total",
+	"Files skipped by rotation handling: active (still written), rotated (rotated files ignored) or duplicate (rotated file renamed again)",
+	"directory", "reason")
+
+// rotatedName matches the names rotation tools give rotated files: the active
+// name followed by numbers or dates, e.g. app.log.1, app.log-20260101, and an
+// optional compression extension
+var rotatedName = regexp.MustCompile(`^(.+?)((?:[.-]\d+)+)(\.(?:gz|bz2|xz|zst))?$`)
+
+// maxRotations bounds how many delivered rotations are remembered
+const maxRotations = 10000
+
+// rotationFilter wraps a handler to apply the rotation settings of a
+// directory. Active files are delivered or skipped, and a rotated file is
+// delivered once even when the rotation tool renames it again, e.g. app.log.1
+// to app.log.2, before its upload removed it.
+type rotationFilter struct {
+	config  config.DirectoryConfig
+	handler EventHandler
+	clear   func(path string) // forgets skipped files, so their path is watched again
+
+	mu        sync.Mutex
+	delivered map[string]string // rotation key -> path it was delivered from
+}
+
+// newRotationFilter creates the filter for a directory with rotation enabled
+func newRotationFilter(cfg config.DirectoryConfig, handler EventHandler) *rotationFilter {
+	return &rotationFilter{
+		config:    cfg,
+		handler:   handler,
+		delivered: make(map[string]string),
+	}
+}
+
+// handle passes an event on unless the rotation settings skip the file
+func (f *rotationFilter) handle(event FileEvent) error {
+	if event.IsDelete {
+		return f.handler(event)
+	}
+	if reason := f.skip(event.Path); reason != "" {
+		rotationSkipped.With(f.config.Name, reason).Inc()
+		if f.clear != nil {
+			f.clear(event.Path)
+		}
+		return nil
+	}
+	return f.handler(event)
+}
+
+// skip returns why a file is not delivered, or "" to deliver it
+func (f *rotationFilter) skip(path string) string {
+	rotation := f.config.Rotation
+	if isActiveFile(path, rotation) {
+		if rotation.GetActive() == config.RotationActiveIgnore {
+			return "active"
+		}
+		return ""
+	}
+	active := rotatedFrom(path, rotation)
+	if active == "" {
+		return ""
+	}
+	if rotation.GetRotated() == config.RotationRotatedIgnore {
+		return "rotated"
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "" // Let the upload report the missing file
+	}
+	// A rename keeps the modification time, so it identifies the rotation
+	// across renames, and across compression by tools preserving it
+	key := filepath.Join(filepath.Dir(path), active) + "|" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	first, seen := f.delivered[key]
+	if seen && first != path {
+		log.Printf("[%s] Rotation: skipping %s, already delivered as %s", f.config.Name, path, first)
+		return "duplicate"
+	}
+	if !seen {
+		if len(f.delivered) >= maxRotations {
+			f.delivered = make(map[string]string)
+		}
+		f.delivered[key] = path
+	}
+	return ""
+}
+
+// isActiveFile reports whether a file is one written by an application, as
+// opposed to a rotated copy of it
+func isActiveFile(path string, rotation config.RotationConfig) bool {
+	return matchesAny(filepath.Base(path), rotation.Patterns)
+}
+
+// rotatedFrom returns the name of the active file a rotated file was renamed
+// from, or "" if the file is not a rotated copy
+func rotatedFrom(path string, rotation config.RotationConfig) string {
+	m := rotatedName.FindStringSubmatch(filepath.Base(path))
+	if m == nil || !matchesAny(m[1], rotation.Patterns) {
+		return ""
+	}
+	return m[1]
+}
+
+// matchesAny reports whether a name matches one of the patterns
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
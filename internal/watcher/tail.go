@@ -0,0 +1,359 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/metrics"
+)
+
+// tailSegments counts segments cut from growing files
+var tailSegments = metrics.NewCounterVec("xferd_tail_segments_total",
+	"Segments of appended data cut from growing files in tail mode",
+	"directory")
+
+// tailStateFile holds the delivered offsets in the spool directory. It is
+// hidden, so the spool scan skips it.
+const tailStateFile = ".xferd-offsets.json"
+
+// TailWatcher delivers data appended to growing files, e.g. logs, in
+// segments. Every interval the bytes written since the last check are copied
+// into a segment file in the spool directory, named after the file and the
+// offset the segment starts at, and handed to the handler. Offsets are kept
+// in a state file so a restart continues where it stopped.
+type TailWatcher struct {
+	config   config.DirectoryConfig
+	handler  EventHandler
+	spool    string
+	offsets  map[string]*tailOffset // by path of the growing file
+	enqueued sync.Map               // segments handed to the handler and not yet cleared
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// tailOffset is how much of a growing file has been cut into segments
+type tailOffset struct {
+	Offset int64
+	info   os.FileInfo // identity of the file the offset belongs to, nil after a restart
+}
+
+// newTailWatcher creates the watcher for tail mode
+func newTailWatcher(cfg config.DirectoryConfig, handler EventHandler) (Watcher, error) {
+	if cfg.Watch.Tail.GetDeliver() == config.TailDeliverClose {
+		return newCloseWatcher(cfg, handler)
+	}
+	return &TailWatcher{
+		config:  cfg,
+		handler: handler,
+		spool:   cfg.Watch.Tail.SpoolPath,
+		offsets: make(map[string]*tailOffset),
+	}, nil
+}
+
+// Start loads the offsets, hands over segments left in the spool directory
+// and starts checking for appended data
+func (w *TailWatcher) Start(ctx context.Context) error {
+	w.ctx, w.cancel = context.WithCancel(ctx)
+
+	if err := os.MkdirAll(w.spool, 0o750); err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	fresh, err := w.loadOffsets()
+	if err != nil {
+		return err
+	}
+	// Without a startup scan, files already there are only tailed from their current end
+	if fresh && !w.config.Watch.IsStartupReconcileScanEnabled() {
+		w.skipExisting()
+	}
+	w.poll()
+
+	w.wg.Add(1)
+	go w.run()
+
+	log.Printf("Tail watcher started for: %s (segments every %v, spooled in %s)", w.config.WatchPath, w.config.Watch.Tail.GetInterval(), w.spool)
+	return nil
+}
+
+// Stop stops checking for appended data
+func (w *TailWatcher) Stop() error {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+	log.Printf("Tail watcher stopped for: %s", w.config.WatchPath)
+	return nil
+}
+
+// ClearEnqueued forgets a segment once it was delivered or dropped, so a
+// dropped segment is handed over again by the next spool scan
+func (w *TailWatcher) ClearEnqueued(path string) {
+	w.enqueued.Delete(path)
+}
+
+// MarkEnqueued records a file enqueued by the service, e.g. resumed from the
+// queue state, so that it is not enqueued again
+func (w *TailWatcher) MarkEnqueued(path string) {
+	w.enqueued.Store(path, true)
+}
+
+// run checks for appended data every interval
+func (w *TailWatcher) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.config.Watch.Tail.GetInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll cuts segments from every file that grew, then hands every segment in
+// the spool directory that is not enqueued yet to the handler
+func (w *TailWatcher) poll() {
+	found := make(map[string]os.FileInfo)
+	w.walkFiles(func(path string, info os.FileInfo) {
+		found[path] = info
+	})
+	changed := w.followRenames(found)
+	for path, info := range found {
+		if w.cut(path, info) {
+			changed = true
+		}
+	}
+	for path := range w.offsets {
+		if _, ok := found[path]; !ok {
+			delete(w.offsets, path)
+			changed = true
+		}
+	}
+	if changed {
+		if err := w.saveOffsets(); err != nil {
+			log.Printf("[%s] Tail: failed to save offsets: %v", w.config.Name, err)
+		}
+	}
+
+	w.enqueueSpooled()
+}
+
+// walkFiles calls fn for every file to tail
+func (w *TailWatcher) walkFiles(fn func(path string, info os.FileInfo)) {
+	err := filepath.Walk(w.config.WatchPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip unreadable entries
+		}
+		if info.IsDir() {
+			if path != w.config.WatchPath && !w.config.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !ShouldSkip(path, w.config) {
+			fn(path, info)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[%s] Tail: failed to scan %s: %v", w.config.Name, w.config.WatchPath, err)
+	}
+}
+
+// followRenames moves the offsets of files that were renamed, e.g. app.log to
+// app.log.1 by a rotation tool, to their new path. The rotated file continues
+// where it was cut, so data written just before the rotation is delivered and
+// nothing is delivered twice. Reports whether an offset moved.
+func (w *TailWatcher) followRenames(found map[string]os.FileInfo) bool {
+	moved := false
+	for oldPath, offset := range w.offsets {
+		if offset.info == nil {
+			continue
+		}
+		if info, ok := found[oldPath]; ok && os.SameFile(offset.info, info) {
+			continue
+		}
+		for newPath, info := range found {
+			if newPath == oldPath || !os.SameFile(offset.info, info) {
+				continue
+			}
+			if current, tracked := w.offsets[newPath]; tracked && current.info != nil && os.SameFile(current.info, info) {
+				continue
+			}
+			log.Printf("[%s] Tail: %s was renamed to %s, continuing at %d", w.config.Name, oldPath, newPath, offset.Offset)
+			w.offsets[newPath] = &tailOffset{Offset: offset.Offset, info: offset.info}
+			delete(w.offsets, oldPath)
+			moved = true
+			break
+		}
+	}
+	return moved
+}
+
+// cut copies the data appended to a file since its offset into a segment and
+// reports whether the offset changed
+func (w *TailWatcher) cut(path string, info os.FileInfo) bool {
+	offset, tracked := w.offsets[path]
+	if !tracked {
+		offset = &tailOffset{}
+		w.offsets[path] = offset
+	}
+
+	changed := !tracked
+	switch {
+	case offset.info != nil && !os.SameFile(offset.info, info):
+		log.Printf("[%s] Tail: %s was replaced, starting from the beginning", w.config.Name, path)
+		offset.Offset, changed = 0, true
+	case info.Size() < offset.Offset:
+		log.Printf("[%s] Tail: %s was truncated, starting from the beginning", w.config.Name, path)
+		offset.Offset, changed = 0, true
+	}
+	// Resolve the file identity now: on Windows it is looked up by path when
+	// first compared, which fails once the file was renamed
+	os.SameFile(info, info)
+	offset.info = info
+	if info.Size() == offset.Offset {
+		return changed
+	}
+
+	n, err := w.writeSegment(path, offset.Offset, info.Size())
+	if err != nil {
+		log.Printf("[%s] Tail: failed to cut segment of %s at %d: %v", w.config.Name, path, offset.Offset, err)
+		return changed
+	}
+	offset.Offset += n
+	tailSegments.With(w.config.Name).Inc()
+	return true
+}
+
+// writeSegment copies the bytes of a file from offset to end into the spool
+// directory and returns how many were copied. The segment only appears under
+// its final name once complete.
+func (w *TailWatcher) writeSegment(path string, offset, end int64) (int64, error) {
+	src, err := os.Open(path) // #nosec G304 -- path from walking the configured watch directory
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	target := w.segmentPath(path, offset)
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".segment-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.NewSectionReader(src, offset, end-offset))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no data at offset %d", offset)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// segmentPath returns where the segment of a file starting at offset is
+// spooled: below the same subdirectory, named <file>.<offset> with the offset
+// zero-padded so segments sort in file order
+func (w *TailWatcher) segmentPath(path string, offset int64) string {
+	rel, err := filepath.Rel(w.config.WatchPath, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.Join(w.spool, fmt.Sprintf("%s.%020d", rel, offset))
+}
+
+// enqueueSpooled hands the spooled segments to the handler in file order,
+// skipping those already enqueued
+func (w *TailWatcher) enqueueSpooled() {
+	_ = filepath.Walk(w.spool, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		if _, loaded := w.enqueued.LoadOrStore(path, true); loaded {
+			return nil
+		}
+		event := FileEvent{
+			Path:      path,
+			Timestamp: time.Now(),
+			Priority:  PriorityFor(path, w.config.Priorities),
+		}
+		if err := w.handler(event); err != nil {
+			log.Printf("[%s] Tail: error handling segment %s: %v", w.config.Name, path, err)
+			w.enqueued.Delete(path)
+		}
+		return nil
+	})
+}
+
+// skipExisting starts files that are already there at their current end
+func (w *TailWatcher) skipExisting() {
+	w.walkFiles(func(path string, info os.FileInfo) {
+		w.offsets[path] = &tailOffset{Offset: info.Size(), info: info}
+	})
+	if err := w.saveOffsets(); err != nil {
+		log.Printf("[%s] Tail: failed to save offsets: %v", w.config.Name, err)
+	}
+}
+
+// loadOffsets reads the offsets saved by a previous run and reports whether
+// there were none
+func (w *TailWatcher) loadOffsets() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(w.spool, tailStateFile))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read tail offsets: %w", err)
+	}
+	saved := make(map[string]int64)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return false, fmt.Errorf("failed to parse tail offsets: %w", err)
+	}
+	for path, offset := range saved {
+		w.offsets[path] = &tailOffset{Offset: offset}
+	}
+	return false, nil
+}
+
+// saveOffsets replaces the state file with the current offsets
+func (w *TailWatcher) saveOffsets() error {
+	saved := make(map[string]int64, len(w.offsets))
+	for path, offset := range w.offsets {
+		saved[path] = offset.Offset
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(w.spool, tailStateFile+".tmp."+strconv.Itoa(os.Getpid()))
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(w.spool, tailStateFile))
+}
@@ -0,0 +1,214 @@
+//go:build linux
+
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/muzy/xferd/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// closeWatchMask selects files closed after writing, files renamed into place
+// and new directories
+const closeWatchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_ONLYDIR
+
+// CloseWatcher delivers files when their writer closes them, using inotify
+// IN_CLOSE_WRITE rather than stability checks. Files renamed into the watch
+// path are delivered right away.
+type CloseWatcher struct {
+	config   config.DirectoryConfig
+	handler  EventHandler
+	fd       int            // inotify descriptor, File.Fd would make inotify blocking
+	inotify  *os.File       // reads fd through the runtime poller
+	dirs     map[int]string // watch descriptor -> directory
+	enqueued sync.Map       // files handed to the handler and not yet cleared
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// newCloseWatcher creates the watcher for tail mode with deliver: close
+func newCloseWatcher(cfg config.DirectoryConfig, handler EventHandler) (Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inotify instance: %w", err)
+	}
+	return &CloseWatcher{
+		config:  cfg,
+		handler: handler,
+		fd:      fd,
+		// A non-blocking descriptor uses the runtime poller, so Close interrupts Read
+		inotify: os.NewFile(uintptr(fd), "inotify"),
+		dirs:    make(map[int]string),
+	}, nil
+}
+
+// Start watches the directory tree and delivers files already there once
+// they are stable
+func (w *CloseWatcher) Start(ctx context.Context) error {
+	if err := w.addTree(w.config.WatchPath); err != nil {
+		return fmt.Errorf("failed to setup watches: %w", err)
+	}
+
+	if w.config.Watch.IsStartupReconcileScanEnabled() {
+		log.Printf("Performing startup reconciliation scan for: %s", w.config.WatchPath)
+		w.scanExisting()
+	}
+
+	w.wg.Add(1)
+	go w.readEvents()
+
+	go func() {
+		<-ctx.Done()
+		w.inotify.Close()
+	}()
+
+	log.Printf("Close watcher started for: %s (recursive: %v)", w.config.WatchPath, w.config.Recursive)
+	return nil
+}
+
+// Stop stops the watcher
+func (w *CloseWatcher) Stop() error {
+	w.inotify.Close()
+	w.wg.Wait()
+	log.Printf("Close watcher stopped for: %s", w.config.WatchPath)
+	return nil
+}
+
+// ClearEnqueued removes a file from the enqueued tracking
+func (w *CloseWatcher) ClearEnqueued(path string) {
+	w.enqueued.Delete(path)
+}
+
+// MarkEnqueued records a file enqueued by the service, e.g. resumed from the
+// queue state, so that it is not enqueued again
+func (w *CloseWatcher) MarkEnqueued(path string) {
+	w.enqueued.Store(path, true)
+}
+
+// addTree watches a directory and, if recursive, its subdirectories
+func (w *CloseWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && !w.config.Recursive {
+			return filepath.SkipDir
+		}
+		return w.addWatch(path)
+	})
+}
+
+// addWatch adds a directory to the inotify instance
+func (w *CloseWatcher) addWatch(dir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, dir, closeWatchMask)
+	if err != nil {
+		return fmt.Errorf("failed to add watch for %s: %w", dir, err)
+	}
+	w.mu.Lock()
+	w.dirs[wd] = dir
+	w.mu.Unlock()
+	log.Printf("Added watch: %s", dir)
+	return nil
+}
+
+// readEvents reads inotify events until the instance is closed
+func (w *CloseWatcher) readEvents() {
+	defer w.wg.Done()
+
+	readInotify(w.inotify, func(wd int, name string, mask uint32) {
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			log.Printf("Watcher error: inotify queue overflow for %s, events were lost", w.config.WatchPath)
+			return
+		}
+		w.mu.Lock()
+		dir, ok := w.dirs[wd]
+		if mask&unix.IN_IGNORED != 0 {
+			delete(w.dirs, wd)
+		}
+		w.mu.Unlock()
+		if !ok || name == "" {
+			return
+		}
+		w.handleEvent(filepath.Join(dir, name), mask)
+	})
+}
+
+// handleEvent delivers closed and renamed files and watches new directories
+func (w *CloseWatcher) handleEvent(path string, mask uint32) {
+	if mask&unix.IN_ISDIR != 0 {
+		if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 && w.config.Recursive {
+			if err := w.addTree(path); err != nil {
+				log.Printf("Failed to add watch for new directory %s: %v", path, err)
+			}
+		}
+		return
+	}
+	if mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) == 0 || ShouldSkip(path, w.config) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	w.deliver(path, mask&unix.IN_MOVED_TO != 0)
+}
+
+// scanExisting delivers the files already in the watch path once they are
+// stable, since their writers may have closed them before the watch started
+func (w *CloseWatcher) scanExisting() {
+	_ = filepath.Walk(w.config.WatchPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != w.config.WatchPath && !w.config.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		event, err := processFile(path, false, w.config)
+		if err != nil {
+			log.Printf("Reconciliation: error processing %s: %v", path, err)
+			return nil
+		}
+		if event.Path != "" {
+			w.enqueue(event)
+		}
+		return nil
+	})
+}
+
+// deliver hands a closed or renamed file to the handler
+func (w *CloseWatcher) deliver(path string, isRename bool) {
+	if ignoredByContent(path, w.config.ContentRules) {
+		log.Printf("Ignoring %s: content type matches an ignore rule", path)
+		return
+	}
+	w.enqueue(FileEvent{
+		Path:      path,
+		IsRename:  isRename,
+		Timestamp: time.Now(),
+		Priority:  PriorityFor(path, w.config.Priorities),
+	})
+}
+
+// enqueue hands an event to the handler unless the file is already enqueued
+func (w *CloseWatcher) enqueue(event FileEvent) {
+	if _, loaded := w.enqueued.LoadOrStore(event.Path, true); loaded {
+		return
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling file %s: %v", event.Path, err)
+		w.enqueued.Delete(event.Path)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/muzy/xferd/internal/config"
+)
+
+// newCloseWatcher reports that delivering files on close needs Linux, since
+// Windows has no notification for a writer closing a file
+func newCloseWatcher(config.DirectoryConfig, EventHandler) (Watcher, error) {
+	return nil, fmt.Errorf("watch.tail.deliver close is only supported on Linux")
+}
@@ -10,14 +10,30 @@ import (
 	"time"
 
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/magic"
+	"github.com/muzy/xferd/internal/metrics"
+	"github.com/muzy/xferd/internal/quarantine"
 )
 
+// stabilityChecks counts stability check outcomes per directory
+var stabilityChecks = metrics.NewCounterVec("xferd_stability_checks_total",
+	"Stability checks by outcome: stable, vanished (file disappeared) or timeout (assumed stable)",
+	"directory", "outcome")
+
+// rejectedFiles counts files not delivered because they failed validation
+var rejectedFiles = metrics.NewCounterVec("xferd_watcher_rejected_files_total",
+	"Files not delivered because they failed validation, by reason: too_small or too_large (min_size_bytes and max_size_bytes) or blocked_extension",
+	"directory", "reason")
+
 // FileEvent represents a detected file
 type FileEvent struct {
 	Path                  string
 	IsRename              bool
 	Timestamp             time.Time
-	ProcessedDueToTimeout bool // true if file was processed due to stability timeout
+	Detected              time.Time // when the file was picked up, before its stability check (zero if unknown)
+	ProcessedDueToTimeout bool      // true if file was processed due to stability timeout
+	IsDelete              bool      // true if an enqueued file was deleted before its upload completed
+	Priority              string    // upload priority class from the directory's priority rules
 }
 
 // EventHandler processes detected files
@@ -28,6 +44,7 @@ type Watcher interface {
 	Start(ctx context.Context) error
 	Stop() error
 	ClearEnqueued(path string)
+	MarkEnqueued(path string)
 }
 
 // IgnoredSuffixes are file patterns to ignore (legacy - for backward compatibility)
@@ -44,17 +61,8 @@ func ShouldIgnore(path string, ignorePatterns []string) bool {
 
 	// Check configurable ignore patterns
 	for _, pattern := range ignorePatterns {
-		// Check if pattern contains path separators (indicates path pattern)
-		if strings.Contains(pattern, "/") || strings.Contains(pattern, "\\") {
-			// Path pattern - use simple glob matching for paths
-			if matchesPathPattern(pattern, path) {
-				return true
-			}
-		} else {
-			// Filename pattern - match against basename
-			if matched, err := filepath.Match(pattern, base); err == nil && matched {
-				return true
-			}
+		if matchesPattern(pattern, path) {
+			return true
 		}
 	}
 
@@ -68,48 +76,97 @@ func ShouldIgnore(path string, ignorePatterns []string) bool {
 	return false
 }
 
-// matchesPathPattern performs simple glob matching for path patterns
-func matchesPathPattern(pattern, path string) bool {
-	// Normalize separators
-	pattern = filepath.ToSlash(pattern)
-	path = filepath.ToSlash(path)
-
-	// Remove leading slash for matching
-	pattern = strings.TrimPrefix(pattern, "/")
-	path = strings.TrimPrefix(path, "/")
-
-	// Split into segments
-	patternParts := strings.Split(pattern, "/")
-	pathParts := strings.Split(path, "/")
+// matchesPattern matches a filename glob against the basename, or a path glob
+// (a pattern containing a separator) against the trailing path segments
+func matchesPattern(pattern, path string) bool {
+	if strings.Contains(pattern, "/") || strings.Contains(pattern, "\\") {
+		return matchesPathPattern(pattern, path)
+	}
+	matched, err := filepath.Match(pattern, filepath.Base(path))
+	return err == nil && matched
+}
 
-	// If pattern has more parts than path, no match
-	if len(patternParts) > len(pathParts) {
-		return false
+// PriorityFor returns the upload priority for a file from the first matching rule
+func PriorityFor(path string, rules []config.PriorityRule) string {
+	for _, rule := range rules {
+		for _, pattern := range rule.Patterns {
+			if matchesPattern(pattern, path) {
+				return rule.Priority
+			}
+		}
 	}
+	return config.PriorityNormal
+}
 
-	// Match from the end (rightmost parts first)
-	patternIdx := len(patternParts) - 1
-	pathIdx := len(pathParts) - 1
+// matchesPathPattern matches a path glob against the trailing segments of
+// path. A ** segment matches any number of segments.
+func matchesPathPattern(pattern, path string) bool {
+	patternParts := splitPattern(pattern)
+	pathParts := splitPattern(path)
+	for start := range pathParts {
+		if matchSegments(patternParts, pathParts[start:]) {
+			return true
+		}
+	}
+	return false
+}
 
-	for patternIdx >= 0 && pathIdx >= 0 {
-		part := patternParts[patternIdx]
-		pathPart := pathParts[pathIdx]
+// splitPattern splits a pattern or path into its segments, ignoring a
+// leading separator
+func splitPattern(pattern string) []string {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "/")
+	return strings.Split(pattern, "/")
+}
 
-		// If this pattern part doesn't match, fail
-		if matched, err := filepath.Match(part, pathPart); err != nil || !matched {
-			return false
+// matchSegments matches all path segments against the pattern segments
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSegments(pattern[1:], path[i:]) {
+				return true
+			}
 		}
-
-		patternIdx--
-		pathIdx--
+		return false
 	}
-
-	// If we have more pattern parts but no more path parts, fail
-	if patternIdx >= 0 {
+	if len(path) == 0 {
 		return false
 	}
+	if matched, err := filepath.Match(pattern[0], path[0]); err != nil || !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], path[1:])
+}
 
-	return true
+// isIncluded reports whether a file matches one of the directory's include
+// patterns, or the directory has none. Filename patterns match the basename,
+// path patterns the path below the watch path.
+func isIncluded(path string, cfg config.DirectoryConfig) bool {
+	if len(cfg.Include) == 0 {
+		return true
+	}
+	rel, err := filepath.Rel(cfg.WatchPath, path)
+	if err != nil {
+		rel = path
+	}
+	for _, pattern := range cfg.Include {
+		if strings.Contains(pattern, "/") || strings.Contains(pattern, "\\") {
+			if matchSegments(splitPattern(pattern), splitPattern(rel)) {
+				return true
+			}
+		} else if matched, err := filepath.Match(pattern, filepath.Base(path)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldSkip checks if a file is left alone: include patterns, if any, are
+// evaluated first, then the ignore patterns
+func ShouldSkip(path string, cfg config.DirectoryConfig) bool {
+	return !isIncluded(path, cfg) || ShouldIgnore(path, cfg.Ignore)
 }
 
 // ShouldIgnoreLegacy checks if a file should be ignored (legacy function for backward compatibility)
@@ -119,6 +176,23 @@ func ShouldIgnoreLegacy(path string) bool {
 
 // NewWatcher creates a platform-specific watcher
 func NewWatcher(cfg config.DirectoryConfig, handler EventHandler) (Watcher, error) {
+	if !cfg.Rotation.Enabled {
+		return newWatcher(cfg, handler)
+	}
+	filter := newRotationFilter(cfg, handler)
+	w, err := newWatcher(cfg, filter.handle)
+	if err != nil {
+		return nil, err
+	}
+	filter.clear = w.ClearEnqueued
+	return w, nil
+}
+
+// newWatcher creates the watcher for the directory's watch mode
+func newWatcher(cfg config.DirectoryConfig, handler EventHandler) (Watcher, error) {
+	if cfg.Watch.Mode == "tail" {
+		return newTailWatcher(cfg, handler)
+	}
 	// Use platform-specific implementation
 	return newPlatformWatcher(cfg, handler)
 }
@@ -194,10 +268,94 @@ func walkDirectory(root string, fn func(path string, info os.FileInfo) error) er
 	})
 }
 
+// ScanBacklog lists the files a reconciliation scan of the watch path would
+// pick up, with their sizes
+func ScanBacklog(cfg config.DirectoryConfig) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := filepath.Walk(cfg.WatchPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == cfg.WatchPath {
+				return err
+			}
+			return nil // Skip unreadable entries, like the reconciliation scan
+		}
+		if info.Mode().IsRegular() && !ShouldSkip(path, cfg) && rejection(path, info.Size(), cfg) == "" {
+			files[path] = info.Size()
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ignoredByContent reports whether the file's detected type matches an ignore rule
+func ignoredByContent(path string, rules []config.ContentRule) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	detected, err := magic.DetectFile(path)
+	if err != nil {
+		return false
+	}
+	rule := config.MatchContentRule(rules, detected)
+	return rule != nil && rule.GetAction() == config.ContentActionIgnore
+}
+
+// rejection returns why a file is not delivered: too_small, too_large,
+// blocked_extension, or "" if it passes validation
+func rejection(path string, size int64, cfg config.DirectoryConfig) string {
+	switch {
+	case cfg.IsBlockedExtension(path):
+		return quarantine.ReasonBlockedExtension
+	case size < cfg.MinSizeBytes:
+		return quarantine.ReasonTooSmall
+	case cfg.MaxSizeBytes > 0 && size > cfg.MaxSizeBytes:
+		return quarantine.ReasonTooLarge
+	}
+	return ""
+}
+
+// reject reports whether a stable file fails validation. Rejected files are
+// moved to the quarantine path if set, otherwise they are left in place.
+func reject(path string, cfg config.DirectoryConfig) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false // vanished files are handled by the caller's later checks
+	}
+	reason := rejection(path, info.Size(), cfg)
+	if reason == "" {
+		return false
+	}
+	rejectedFiles.With(cfg.Name, reason).Inc()
+
+	detail := fmt.Sprintf("size %d bytes is outside the allowed range", info.Size())
+	if reason == quarantine.ReasonBlockedExtension {
+		detail = fmt.Sprintf("extension %s is blocked", filepath.Ext(path))
+	}
+	dir := cfg.GetQuarantinePath()
+	if dir == "" {
+		log.Printf("Ignoring %s: %s (%s)", path, detail, reason)
+		return true
+	}
+	target, err := quarantine.Move(cfg.Name, dir, cfg.WatchPath, path, reason, detail)
+	if err != nil {
+		log.Printf("Ignoring %s: %s (%s), failed to quarantine it: %v", path, detail, reason, err)
+		return true
+	}
+	log.Printf("Quarantined %s: %s (%s), moved to %s", path, detail, reason, target)
+	return true
+}
+
 // processFile handles a detected file after stability confirmation
 func processFile(path string, isRename bool, cfg config.DirectoryConfig) (FileEvent, error) {
-	// Skip if should be ignored
-	if ShouldIgnore(path, cfg.Ignore) {
+	detected := time.Now()
+
+	// Skip files not included or ignored
+	if ShouldSkip(path, cfg) {
+		return FileEvent{}, nil
+	}
+
+	// Active files skipped by the rotation settings need no stability check
+	if cfg.Rotation.Enabled && cfg.Rotation.GetActive() == config.RotationActiveIgnore && isActiveFile(path, cfg.Rotation) {
 		return FileEvent{}, nil
 	}
 
@@ -224,17 +382,36 @@ func processFile(path string, isRename bool, cfg config.DirectoryConfig) (FileEv
 	if needsStabilityCheck {
 		stable, timedOut := isStable(path, cfg.Stability)
 		if !stable {
+			stabilityChecks.With(cfg.Name, "vanished").Inc()
 			return FileEvent{}, fmt.Errorf("file stability check failed: %s", path)
 		}
+		if timedOut {
+			stabilityChecks.With(cfg.Name, "timeout").Inc()
+		} else {
+			stabilityChecks.With(cfg.Name, "stable").Inc()
+		}
 		processedDueToTimeout = timedOut
 	}
 
+	// The size is final once the file is stable
+	if reject(path, cfg) {
+		return FileEvent{}, nil
+	}
+
+	// Content rules look at the final content, so they run after the stability check
+	if ignoredByContent(path, cfg.ContentRules) {
+		log.Printf("Ignoring %s: content type matches an ignore rule", path)
+		return FileEvent{}, nil
+	}
+
 	// File is ready, return event for caller to handle
 	event := FileEvent{
 		Path:                  path,
 		IsRename:              isRename,
 		Timestamp:             time.Now(),
+		Detected:              detected,
 		ProcessedDueToTimeout: processedDueToTimeout,
+		Priority:              PriorityFor(path, cfg.Priorities),
 	}
 
 	return event, nil
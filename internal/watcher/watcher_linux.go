@@ -4,6 +4,7 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -21,8 +22,12 @@ type LinuxWatcher struct {
 	handler         EventHandler
 	watcher         *fsnotify.Watcher
 	watchedDirs     map[string]bool
-	processingFiles sync.Map // tracks files currently being processed for stability
-	enqueuedFiles   sync.Map // tracks files that have been enqueued for upload
+	processingFiles sync.Map      // tracks files currently being processed for stability
+	writes          *debouncer    // coalesces write events before the stability check
+	enqueuedFiles   sync.Map      // tracks files that have been enqueued for upload
+	recent          recentPaths   // files cleared after delivery whose source may not be removed yet
+	resync          chan struct{} // requests a full rescan after events were lost
+	closes          *closeWrites  // nil unless watch.close_write is enabled
 	mu              sync.Mutex
 	ctx             context.Context
 	cancel          context.CancelFunc
@@ -36,12 +41,22 @@ func newPlatformWatcher(cfg config.DirectoryConfig, handler EventHandler) (Watch
 		return nil, fmt.Errorf("failed to create fsnotify watcher: %w", err)
 	}
 
-	return &LinuxWatcher{
+	lw := &LinuxWatcher{
 		config:      cfg,
 		handler:     handler,
 		watcher:     w,
 		watchedDirs: make(map[string]bool),
-	}, nil
+		resync:      make(chan struct{}, 1),
+	}
+	lw.writes = newDebouncer(cfg.Name, cfg.Stability.GetDebounce(), cfg.Stability.GetMaxWait(), lw.checkWritten)
+	if cfg.Watch.CloseWrite {
+		lw.closes, err = newCloseWrites()
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return lw, nil
 }
 
 // Start begins watching the configured directory
@@ -63,6 +78,17 @@ func (w *LinuxWatcher) Start(ctx context.Context) error {
 	w.wg.Add(1)
 	go w.processEvents()
 
+	w.wg.Add(1)
+	go w.resyncOnOverflow()
+
+	if w.closes != nil {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.closes.run(w.handleClose, func() { w.handleError(fsnotify.ErrEventOverflow) })
+		}()
+	}
+
 	// Start reconciliation scan if enabled
 	if w.config.Watch.ReconcileScan.Enabled {
 		w.wg.Add(1)
@@ -82,8 +108,12 @@ func (w *LinuxWatcher) Stop() error {
 	if w.watcher != nil {
 		w.watcher.Close()
 	}
+	if w.closes != nil {
+		w.closes.close()
+	}
 
 	w.wg.Wait()
+	w.writes.stop()
 	log.Printf("Linux watcher stopped for: %s", w.config.WatchPath)
 	return nil
 }
@@ -115,6 +145,11 @@ func (w *LinuxWatcher) addWatch(dir string) error {
 	if err := w.watcher.Add(dir); err != nil {
 		return fmt.Errorf("failed to add watch for %s: %w", dir, err)
 	}
+	if w.closes != nil {
+		if err := w.closes.add(dir); err != nil {
+			return err
+		}
+	}
 
 	w.watchedDirs[dir] = true
 	log.Printf("Added watch: %s", dir)
@@ -141,7 +176,41 @@ func (w *LinuxWatcher) processEvents() {
 			if !ok {
 				return
 			}
-			log.Printf("Watcher error: %v", err)
+			w.handleError(err)
+		}
+	}
+}
+
+// handleError logs a watcher error. When the kernel event queue overflowed,
+// events were lost, so a full rescan is requested.
+func (w *LinuxWatcher) handleError(err error) {
+	if !errors.Is(err, fsnotify.ErrEventOverflow) {
+		log.Printf("Watcher error: %v", err)
+		return
+	}
+	log.Printf("Watcher error: inotify queue overflow for %s, events were lost; rescanning", w.config.WatchPath)
+	watcherOverflows.With(w.config.Name).Inc()
+	select {
+	case w.resync <- struct{}{}:
+	default: // a rescan is already pending
+	}
+}
+
+// resyncOnOverflow rescans the watch path whenever events were lost. Requests
+// arriving during a rescan are coalesced into one more rescan.
+func (w *LinuxWatcher) resyncOnOverflow() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.resync:
+			// Directories created while events were lost are not watched yet
+			if err := w.setupWatches(); err != nil {
+				log.Printf("Failed to restore watches for %s: %v", w.config.WatchPath, err)
+			}
+			w.performReconciliationScan()
 		}
 	}
 }
@@ -150,6 +219,12 @@ func (w *LinuxWatcher) processEvents() {
 func (w *LinuxWatcher) handleEvent(event fsnotify.Event) {
 	path := event.Name
 
+	// Deleted files may still be waiting in the upload queue
+	if event.Op&fsnotify.Remove != 0 {
+		w.handleRemove(path)
+		return
+	}
+
 	// Handle directory creation (for recursive watching)
 	if event.Op&fsnotify.Create != 0 {
 		info, err := os.Stat(path)
@@ -178,16 +253,27 @@ func (w *LinuxWatcher) handleEvent(event fsnotify.Event) {
 func (w *LinuxWatcher) handleHybridEvent(event fsnotify.Event) {
 	path := event.Name
 
-	// Check if this file has already been enqueued
+	// fsnotify reports a rename under the old name: an enqueued file renamed
+	// or moved out of the watch path is gone, like a deleted one
 	_, alreadyEnqueued := w.enqueuedFiles.Load(path)
+	if alreadyEnqueued && event.Op&fsnotify.Rename != 0 {
+		w.handleRemove(path)
+		return
+	}
+
+	// With close_write, files are delivered by handleClose instead
+	if w.closes != nil {
+		return
+	}
+
+	// Check if this file has already been enqueued
 	if alreadyEnqueued {
 		// Already enqueued this file, skip
 		return
 	}
 
-	// IN_MOVED_TO (rename into watched directory) - fastest path
-	if event.Op&fsnotify.Rename != 0 || event.Op&fsnotify.Create != 0 {
-		// Check if this is actually a rename completion
+	// Renamed files are complete and processed immediately
+	if event.Op&fsnotify.Rename != 0 {
 		info, err := os.Stat(path)
 		if err != nil {
 			return // File doesn't exist
@@ -197,11 +283,8 @@ func (w *LinuxWatcher) handleHybridEvent(event fsnotify.Event) {
 			return // Not a file
 		}
 
-		// For atomic rename (MOVED_TO), process immediately
-		isRename := event.Op&fsnotify.Rename != 0
-
 		// Process file and get event
-		event, err := processFile(path, isRename, w.config)
+		event, err := processFile(path, true, w.config)
 		if err != nil {
 			log.Printf("Error processing file %s: %v", path, err)
 			return
@@ -219,41 +302,71 @@ func (w *LinuxWatcher) handleHybridEvent(event fsnotify.Event) {
 			log.Printf("Error handling file %s: %v", path, err)
 			w.enqueuedFiles.Delete(path) // Remove on failure
 		}
+		return
 	}
 
-	// WRITE events - confirm stability synchronously before enqueuing
-	if event.Op&fsnotify.Write != 0 {
-		// Check if we're already processing this file
-		_, alreadyProcessing := w.processingFiles.LoadOrStore(path, true)
-		if alreadyProcessing {
-			// Already processing this file, skip
-			return
-		}
+	// Files being written are checked for stability once their write
+	// events settle, however many events a slow copy produces
+	if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+		w.writes.touch(path)
+	}
+}
 
-		// File being written - confirm stability synchronously
-		go func() {
-			defer w.processingFiles.Delete(path) // Clean up when done
+// checkWritten confirms the stability of a file whose write events settled
+// and enqueues it
+func (w *LinuxWatcher) checkWritten(path string, first time.Time) {
+	if _, alreadyEnqueued := w.enqueuedFiles.Load(path); alreadyEnqueued {
+		return
+	}
 
-			// Process file and get event
-			event, err := processFile(path, false, w.config)
-			if err != nil {
-				log.Printf("Error processing file %s: %v", path, err)
-				return
-			}
+	// The reconciliation scan may be checking the file already
+	if _, alreadyProcessing := w.processingFiles.LoadOrStore(path, true); alreadyProcessing {
+		return
+	}
+	defer w.processingFiles.Delete(path)
 
-			// Check if event is valid (processFile returns empty event for ignored/disappeared files)
-			if event.Path == "" {
-				return
-			}
+	event, err := processFile(path, false, w.config)
+	if err != nil {
+		log.Printf("Error processing file %s: %v", path, err)
+		return
+	}
+	if event.Path == "" {
+		return // Ignored or disappeared
+	}
+	event.Detected = first
 
-			// Mark as enqueued
-			w.enqueuedFiles.Store(path, true)
+	if _, alreadyEnqueued := w.enqueuedFiles.LoadOrStore(path, true); alreadyEnqueued {
+		return
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling file %s: %v", path, err)
+		w.enqueuedFiles.Delete(path) // Remove on failure
+	}
+}
 
-			if err := w.handler(event); err != nil {
-				log.Printf("Error handling file %s: %v", path, err)
-				w.enqueuedFiles.Delete(path) // Remove on failure
-			}
-		}()
+// handleClose delivers a file its writer closed or that was renamed into
+// place. Both are complete, so no stability check is needed.
+func (w *LinuxWatcher) handleClose(path string, renamed bool) {
+	if _, alreadyEnqueued := w.enqueuedFiles.Load(path); alreadyEnqueued {
+		return
+	}
+
+	event, err := processFile(path, true, w.config)
+	if err != nil {
+		log.Printf("Error processing file %s: %v", path, err)
+		return
+	}
+	if event.Path == "" {
+		return // Ignored or disappeared
+	}
+	event.IsRename = renamed
+
+	if _, alreadyEnqueued := w.enqueuedFiles.LoadOrStore(path, true); alreadyEnqueued {
+		return
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling file %s: %v", path, err)
+		w.enqueuedFiles.Delete(path) // Remove on failure
 	}
 }
 
@@ -291,9 +404,36 @@ func (w *LinuxWatcher) reconciliationScan() {
 	}
 }
 
-// ClearEnqueued removes a file from the enqueued tracking
+// handleRemove notifies the handler when an enqueued file is deleted or
+// moved away
+func (w *LinuxWatcher) handleRemove(path string) {
+	w.writes.cancel(path)
+	if _, wasEnqueued := w.enqueuedFiles.LoadAndDelete(path); !wasEnqueued {
+		return
+	}
+
+	event := FileEvent{
+		Path:      path,
+		Timestamp: time.Now(),
+		IsDelete:  true,
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling deletion of %s: %v", path, err)
+	}
+}
+
+// ClearEnqueued removes a file from the enqueued tracking. The path is
+// remembered for a while, since its source is removed only after this.
 func (w *LinuxWatcher) ClearEnqueued(path string) {
-	w.enqueuedFiles.Delete(path)
+	if _, wasEnqueued := w.enqueuedFiles.LoadAndDelete(path); wasEnqueued {
+		w.recent.add(path, time.Now())
+	}
+}
+
+// MarkEnqueued records a file enqueued by the service, e.g. resumed from the
+// queue state, so that it is not enqueued again
+func (w *LinuxWatcher) MarkEnqueued(path string) {
+	w.enqueuedFiles.Store(path, true)
 }
 
 // performReconciliationScan scans for files that may have been missed
@@ -309,7 +449,7 @@ func (w *LinuxWatcher) performReconciliationScan() {
 			return nil
 		}
 
-		if ShouldIgnore(path, w.config.Ignore) {
+		if ShouldSkip(path, w.config) {
 			return nil
 		}
 
@@ -319,6 +459,16 @@ func (w *LinuxWatcher) performReconciliationScan() {
 			return nil // Already processed
 		}
 
+		// Delivered files are skipped until their source is removed
+		if w.recent.delivered(path, info, time.Now()) {
+			return nil
+		}
+
+		// Files still being written are checked once their writes settle
+		if w.writes.isPending(path) {
+			return nil
+		}
+
 		// Check if we're already processing this file
 		_, alreadyProcessing := w.processingFiles.LoadOrStore(path, true)
 		if alreadyProcessing {
@@ -341,8 +491,11 @@ func (w *LinuxWatcher) performReconciliationScan() {
 				return nil
 			}
 
-			// Mark as enqueued
-			w.enqueuedFiles.Store(path, true)
+			// Mark as enqueued, unless it was delivered meanwhile
+			if _, alreadyEnqueued := w.enqueuedFiles.LoadOrStore(path, true); alreadyEnqueued {
+				w.processingFiles.Delete(path)
+				return nil
+			}
 
 			if err := w.handler(event); err != nil {
 				log.Printf("Reconciliation: error handling file %s: %v", path, err)
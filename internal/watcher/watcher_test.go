@@ -1,13 +1,18 @@
 package watcher
 
 import (
+	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/muzy/xferd/internal/config"
+	"github.com/muzy/xferd/internal/quarantine"
 )
 
 func TestShouldIgnoreHiddenFiles(t *testing.T) {
@@ -123,6 +128,62 @@ func TestShouldIgnoreWithPatterns(t *testing.T) {
 	}
 }
 
+func TestShouldSkipInclude(t *testing.T) {
+	cfg := config.DirectoryConfig{
+		WatchPath: "/data/vendor",
+		Include:   []string{"*.xml", "reports/**/*.csv"},
+		Ignore:    []string{"*_draft.xml"},
+	}
+	tests := []struct {
+		name     string
+		path     string
+		expected bool
+	}{
+		{"filename pattern", "/data/vendor/orders.xml", false},
+		{"filename pattern in subdirectory", "/data/vendor/a/b/orders.xml", false},
+		{"not included", "/data/vendor/readme.txt", true},
+		{"path pattern directly below", "/data/vendor/reports/q1.csv", false},
+		{"path pattern nested", "/data/vendor/reports/2025/01/q1.csv", false},
+		{"path pattern outside its directory", "/data/vendor/exports/q1.csv", true},
+		{"path pattern anchored at the watch path", "/data/vendor/old/reports/q1.csv", true},
+		{"included but ignored", "/data/vendor/orders_draft.xml", true},
+		{"included but hidden", "/data/vendor/.orders.xml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldSkip(tt.path, cfg); got != tt.expected {
+				t.Errorf("ShouldSkip(%s) = %v, expected %v", tt.path, got, tt.expected)
+			}
+		})
+	}
+
+	// Without include patterns only the ignore rules apply
+	cfg.Include = nil
+	if ShouldSkip("/data/vendor/readme.txt", cfg) {
+		t.Error("Expected files to be processed without include patterns")
+	}
+}
+
+func TestMatchesPathPatternDoubleStar(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"**/cache/*", "/data/cache/file.txt", true},
+		{"**/cache/*", "/data/a/b/cache/file.txt", true},
+		{"cache/**/*.tmp", "/data/cache/file.tmp", true},
+		{"cache/**/*.tmp", "/data/cache/a/b/file.tmp", true},
+		{"cache/**/*.tmp", "/data/cache/a/b/file.txt", false},
+		{"*/cache/*", "/data/a/cache/b/file.txt", false},
+	}
+	for _, tt := range tests {
+		if got := matchesPathPattern(tt.pattern, tt.path); got != tt.expected {
+			t.Errorf("matchesPathPattern(%q, %q) = %v, expected %v", tt.pattern, tt.path, got, tt.expected)
+		}
+	}
+}
+
 func TestProcessFileWithIgnorePatterns(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -724,3 +785,983 @@ func TestWalkDirectoryNonexistent(t *testing.T) {
 		t.Fatal("Expected error walking nonexistent directory")
 	}
 }
+
+func TestProcessFileStabilityMetrics(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirName := "metrics-" + filepath.Base(tmpDir)
+
+	newFile := func(name string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		return path
+	}
+
+	// Stable
+	cfg := config.DirectoryConfig{
+		Name:      dirName,
+		Stability: config.StabilityConfig{ConfirmationIntervalMs: 10, RequiredStableChecks: 2, MaxWaitMs: 500},
+	}
+	if _, err := processFile(newFile("stable.txt"), false, cfg); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	// Timeout
+	cfg.Stability = config.StabilityConfig{ConfirmationIntervalMs: 10, RequiredStableChecks: 100, MaxWaitMs: 50}
+	if _, err := processFile(newFile("timeout.txt"), false, cfg); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	// Vanished during the check
+	cfg.Stability = config.StabilityConfig{ConfirmationIntervalMs: 50, RequiredStableChecks: 100, MaxWaitMs: 2000}
+	vanishing := newFile("vanishing.txt")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		os.Remove(vanishing)
+	}()
+	if _, err := processFile(vanishing, false, cfg); err == nil {
+		t.Error("Expected stability check to fail for vanished file")
+	}
+
+	for _, outcome := range []string{"stable", "timeout", "vanished"} {
+		if got := stabilityChecks.With(dirName, outcome).Value(); got != 1 {
+			t.Errorf("Expected 1 %s stability check, got %d", outcome, got)
+		}
+	}
+}
+
+func TestPriorityFor(t *testing.T) {
+	rules := []config.PriorityRule{
+		{Priority: config.PriorityHigh, Patterns: []string{"*.ctl", "control/*"}},
+		{Priority: config.PriorityLow, Patterns: []string{"*.iso", "*.ctl"}},
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"filename pattern", "/data/in/batch.ctl", config.PriorityHigh},
+		{"path pattern", "/data/in/control/start.txt", config.PriorityHigh},
+		{"low priority", "/data/in/image.iso", config.PriorityLow},
+		{"no match", "/data/in/invoice.pdf", config.PriorityNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PriorityFor(tt.path, rules); got != tt.expected {
+				t.Errorf("PriorityFor(%s) = %s, expected %s", tt.path, got, tt.expected)
+			}
+		})
+	}
+
+	if got := PriorityFor("/data/in/batch.ctl", nil); got != config.PriorityNormal {
+		t.Errorf("Expected normal priority without rules, got %s", got)
+	}
+}
+
+func TestScanBacklog(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	files := map[string]string{
+		"a.txt":       "12345",
+		"sub/b.txt":   "123",
+		"skip.tmp":    "ignored",
+		".hidden":     "ignored",
+		"c.partial":   "ignored",
+		"sub/.hidden": "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	backlog, err := ScanBacklog(config.DirectoryConfig{WatchPath: tmpDir, Ignore: []string{"*.tmp"}})
+	if err != nil {
+		t.Fatalf("ScanBacklog failed: %v", err)
+	}
+	if len(backlog) != 2 {
+		t.Errorf("Expected 2 files, got %v", backlog)
+	}
+	if backlog[filepath.Join(tmpDir, "a.txt")] != 5 || backlog[filepath.Join(tmpDir, "sub", "b.txt")] != 3 {
+		t.Errorf("Unexpected sizes: %v", backlog)
+	}
+
+	if _, err := ScanBacklog(config.DirectoryConfig{WatchPath: filepath.Join(tmpDir, "missing")}); err == nil {
+		t.Error("Expected error for missing watch path")
+	}
+}
+
+func TestProcessFileContentRules(t *testing.T) {
+	tmpDir := t.TempDir()
+	archive := filepath.Join(tmpDir, "report.xml")
+	plain := filepath.Join(tmpDir, "notes.xml")
+	if err := os.WriteFile(archive, []byte("PK\x03\x04archive"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(plain, []byte("<?xml version=\"1.0\"?><notes/>"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	cfg := config.DirectoryConfig{
+		ContentRules: []config.ContentRule{{Types: []string{"zip"}, Action: config.ContentActionIgnore}},
+		Stability: config.StabilityConfig{
+			ConfirmationIntervalMs: 10,
+			RequiredStableChecks:   2,
+			MaxWaitMs:              200,
+		},
+	}
+
+	// The extension says xml, but the content is a ZIP archive
+	event, err := processFile(archive, false, cfg)
+	if err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+	if event.Path != "" {
+		t.Errorf("Expected %s to be ignored by content, got event for %s", archive, event.Path)
+	}
+
+	event, err = processFile(plain, false, cfg)
+	if err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+	if event.Path != plain {
+		t.Errorf("Expected event for %s, got %q", plain, event.Path)
+	}
+}
+
+func TestProcessFileSizeRange(t *testing.T) {
+	watchDir := t.TempDir()
+	rejectedDir := filepath.Join(t.TempDir(), "rejected")
+	files := map[string]string{
+		"empty.csv":     "",
+		"ok.csv":        "a,b,c",
+		"sub/large.csv": "0123456789abcdef",
+	}
+	for name, content := range files {
+		path := filepath.Join(watchDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	cfg := config.DirectoryConfig{
+		Name:         "sized",
+		WatchPath:    watchDir,
+		MinSizeBytes: 1,
+		MaxSizeBytes: 10,
+		Stability: config.StabilityConfig{
+			ConfirmationIntervalMs: 10,
+			RequiredStableChecks:   2,
+			MaxWaitMs:              200,
+		},
+	}
+
+	backlog, err := ScanBacklog(cfg)
+	if err != nil {
+		t.Fatalf("ScanBacklog failed: %v", err)
+	}
+	if len(backlog) != 1 {
+		t.Errorf("Expected only ok.csv in the backlog, got %v", backlog)
+	}
+
+	// Without rejected_path, files outside the range are left in place
+	empty := filepath.Join(watchDir, "empty.csv")
+	tooSmall := rejectedFiles.With("sized", "too_small").Value()
+	event, err := processFile(empty, false, cfg)
+	if err != nil || event.Path != "" {
+		t.Errorf("Expected empty file to be skipped, got %+v, %v", event, err)
+	}
+	if _, err := os.Stat(empty); err != nil {
+		t.Errorf("Expected empty file to be left in place: %v", err)
+	}
+	if got := rejectedFiles.With("sized", "too_small").Value(); got != tooSmall+1 {
+		t.Errorf("Expected too_small count %v, got %v", tooSmall+1, got)
+	}
+
+	ok := filepath.Join(watchDir, "ok.csv")
+	if event, err := processFile(ok, false, cfg); err != nil || event.Path != ok {
+		t.Errorf("Expected event for %s, got %+v, %v", ok, event, err)
+	}
+
+	// With rejected_path, they are moved there below their relative path
+	cfg.RejectedPath = rejectedDir
+	large := filepath.Join(watchDir, "sub", "large.csv")
+	if event, err := processFile(large, false, cfg); err != nil || event.Path != "" {
+		t.Errorf("Expected large file to be skipped, got %+v, %v", event, err)
+	}
+	if _, err := os.Stat(large); !os.IsNotExist(err) {
+		t.Errorf("Expected large file to be moved out of the watch path: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rejectedDir, "sub", "large.csv")); err != nil {
+		t.Errorf("Expected large file in the rejected directory: %v", err)
+	}
+}
+
+func TestProcessFileQuarantine(t *testing.T) {
+	watchDir := t.TempDir()
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	cfg := config.DirectoryConfig{
+		Name:              "quarantined",
+		WatchPath:         watchDir,
+		BlockedExtensions: []string{".exe"},
+		QuarantinePath:    quarantineDir,
+		Stability: config.StabilityConfig{
+			ConfirmationIntervalMs: 10,
+			RequiredStableChecks:   2,
+			MaxWaitMs:              200,
+		},
+	}
+
+	// The same name quarantined twice keeps both files
+	for i, content := range []string{"first", "second"} {
+		path := filepath.Join(watchDir, "setup.EXE")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if i == 0 {
+			if backlog, _ := ScanBacklog(cfg); len(backlog) != 0 {
+				t.Errorf("Expected blocked files outside the backlog, got %v", backlog)
+			}
+		}
+		if event, err := processFile(path, false, cfg); err != nil || event.Path != "" {
+			t.Errorf("Expected blocked file to be skipped, got %+v, %v", event, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected blocked file to be moved out of the watch path: %v", err)
+		}
+	}
+
+	for _, name := range []string{"setup.EXE", "setup.1.EXE"} {
+		data, err := os.ReadFile(filepath.Join(quarantineDir, name+quarantine.SidecarSuffix))
+		if err != nil {
+			t.Fatalf("Expected sidecar of %s: %v", name, err)
+		}
+		var rec quarantine.Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			t.Fatalf("Failed to decode sidecar: %v", err)
+		}
+		if rec.Reason != quarantine.ReasonBlockedExtension || rec.Directory != "quarantined" || rec.File != filepath.Join(watchDir, "setup.EXE") {
+			t.Errorf("Unexpected sidecar of %s: %+v", name, rec)
+		}
+	}
+
+	allowed := filepath.Join(watchDir, "readme.txt")
+	if err := os.WriteFile(allowed, []byte("ok"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if event, err := processFile(allowed, false, cfg); err != nil || event.Path != allowed {
+		t.Errorf("Expected event for %s, got %+v, %v", allowed, event, err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tmpDir := t.TempDir()
+	probe := NewProbe("probe-test", tmpDir, time.Minute)
+
+	ok := watchPathProbes.With("probe-test", "ok").Value()
+	probe.probe()
+	if err := probe.Check(); err != nil {
+		t.Errorf("Expected healthy watch path, got %v", err)
+	}
+	if got := watchPathProbes.With("probe-test", "ok").Value() - ok; got != 1 {
+		t.Errorf("Expected 1 ok probe, got %d", got)
+	}
+
+	// A missing watch path fails the probe
+	probe.path = filepath.Join(tmpDir, "missing")
+	probe.probe()
+	if err := probe.Check(); err == nil {
+		t.Error("Expected error for missing watch path")
+	}
+
+	// A probe running longer than the threshold fails the check
+	probe = NewProbe("probe-test", tmpDir, 10*time.Millisecond)
+	probe.started = time.Now().Add(-time.Second)
+	if err := probe.Check(); err == nil {
+		t.Error("Expected error for a hung probe")
+	}
+	probe.start() // must not start a second probe
+	if probe.started.IsZero() {
+		t.Error("Expected the hung probe to be kept")
+	}
+}
+
+func TestProbeRun(t *testing.T) {
+	probe := NewProbe("probe-run-test", t.TempDir(), time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		probe.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for watchPathProbes.With("probe-run-test", "ok").Value() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := watchPathProbes.With("probe-run-test", "ok").Value(); got < 2 {
+		t.Errorf("Expected repeated probes, got %d", got)
+	}
+}
+
+func TestTailWatcherSegments(t *testing.T) {
+	watchDir := t.TempDir()
+	spool := filepath.Join(t.TempDir(), "spool")
+	logFile := filepath.Join(watchDir, "app.log")
+	if err := os.WriteFile(logFile, []byte("line 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	cfg := config.DirectoryConfig{
+		Name:      "tail",
+		WatchPath: watchDir,
+		Watch:     config.WatchConfig{Mode: "tail", Tail: config.TailConfig{IntervalMs: 3600000, SpoolPath: spool}},
+	}
+	var segments []string
+	handler := func(event FileEvent) error {
+		content, err := os.ReadFile(event.Path)
+		if err != nil {
+			t.Errorf("Failed to read segment: %v", err)
+		}
+		segments = append(segments, filepath.Base(event.Path)+"="+string(content))
+		return nil
+	}
+	newWatcher := func() *TailWatcher {
+		w, err := NewWatcher(cfg, handler)
+		if err != nil {
+			t.Fatalf("Failed to create watcher: %v", err)
+		}
+		if err := w.Start(context.Background()); err != nil {
+			t.Fatalf("Failed to start watcher: %v", err)
+		}
+		t.Cleanup(func() { _ = w.Stop() })
+		return w.(*TailWatcher)
+	}
+	w := newWatcher()
+
+	appendLog := func(data string) {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	deliver := func(w *TailWatcher) {
+		for _, name := range segments {
+			path := filepath.Join(spool, name[:strings.Index(name, "=")])
+			_ = os.Remove(path)
+			w.ClearEnqueued(path)
+		}
+		segments = nil
+	}
+
+	// The existing content is the first segment
+	if len(segments) != 1 || segments[0] != "app.log.00000000000000000000=line 1\n" {
+		t.Fatalf("Expected the first segment, got %q", segments)
+	}
+	deliver(w)
+
+	// Only appended data is cut, and nothing without new data
+	appendLog("line 2\nline 3\n")
+	w.poll()
+	w.poll()
+	if len(segments) != 1 || segments[0] != "app.log.00000000000000000007=line 2\nline 3\n" {
+		t.Fatalf("Expected the appended segment, got %q", segments)
+	}
+
+	// A segment that was not delivered is handed over again after it is cleared
+	w.ClearEnqueued(filepath.Join(spool, "app.log.00000000000000000007"))
+	segments = nil
+	w.poll()
+	if len(segments) != 1 {
+		t.Fatalf("Expected the undelivered segment again, got %q", segments)
+	}
+	deliver(w)
+
+	// Offsets survive a restart
+	if err := w.Stop(); err != nil {
+		t.Fatalf("Failed to stop watcher: %v", err)
+	}
+	appendLog("line 4\n")
+	w = newWatcher()
+	if len(segments) != 1 || segments[0] != "app.log.00000000000000000021=line 4\n" {
+		t.Fatalf("Expected to continue after the saved offset, got %q", segments)
+	}
+	deliver(w)
+
+	// A truncated file starts over
+	if err := os.WriteFile(logFile, []byte("new\n"), 0644); err != nil {
+		t.Fatalf("Failed to truncate log: %v", err)
+	}
+	w.poll()
+	if len(segments) != 1 || segments[0] != "app.log.00000000000000000000=new\n" {
+		t.Fatalf("Expected the truncated file from the start, got %q", segments)
+	}
+	deliver(w)
+
+	// A rotated file continues at its offset and the new file starts from the beginning
+	appendLog("more\n")
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatalf("Failed to rotate log: %v", err)
+	}
+	if err := os.WriteFile(logFile, []byte("fresh\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	w.poll()
+	expected := []string{"app.log.00000000000000000000=fresh\n", "app.log.1.00000000000000000004=more\n"}
+	if strings.Join(segments, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %q after rotation, got %q", expected, segments)
+	}
+}
+
+func TestTailWatcherSkipsExistingWithoutStartupScan(t *testing.T) {
+	watchDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(watchDir, "app.log"), []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	disabled := false
+	cfg := config.DirectoryConfig{
+		Name:      "tail-skip",
+		WatchPath: watchDir,
+		Watch: config.WatchConfig{
+			Mode:                 "tail",
+			StartupReconcileScan: &disabled,
+			Tail:                 config.TailConfig{IntervalMs: 3600000, SpoolPath: filepath.Join(t.TempDir(), "spool")},
+		},
+	}
+	var events []FileEvent
+	w, err := NewWatcher(cfg, func(event FileEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+	if len(events) != 0 {
+		t.Errorf("Expected existing content to be skipped, got %v", events)
+	}
+}
+
+func TestCloseWatcher(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Delivering on close requires Linux")
+	}
+	watchDir := t.TempDir()
+	cfg := config.DirectoryConfig{
+		Name:      "close",
+		WatchPath: watchDir,
+		Recursive: true,
+		Watch:     config.WatchConfig{Mode: "tail", Tail: config.TailConfig{Deliver: config.TailDeliverClose}},
+	}
+	events := make(chan FileEvent, 10)
+	w, err := NewWatcher(cfg, func(event FileEvent) error {
+		events <- event
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// Nothing is delivered while the writer keeps the file open
+	path := filepath.Join(watchDir, "app.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	_, _ = f.WriteString("data\n")
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no delivery before close, got %v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	f.Close()
+	select {
+	case event := <-events:
+		if event.Path != path {
+			t.Errorf("Expected %s, got %s", path, event.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected delivery after close")
+	}
+
+	// New subdirectories are watched too
+	subdir := filepath.Join(watchDir, "sub")
+	if err := os.Mkdir(subdir, 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(subdir, "b.log"), []byte("b"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Path != filepath.Join(subdir, "b.log") {
+			t.Errorf("Expected the file in the subdirectory, got %s", event.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected delivery from the new subdirectory")
+	}
+}
+
+func TestWatcherOverflowResync(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Overflow handling requires Linux")
+	}
+	watchDir := t.TempDir()
+	startupScan := false
+	cfg := config.DirectoryConfig{
+		Name:      "overflow",
+		WatchPath: watchDir,
+		Recursive: true,
+		Watch:     config.WatchConfig{Mode: "polling_only", StartupReconcileScan: &startupScan},
+		Stability: config.StabilityConfig{ConfirmationIntervalMs: 10, RequiredStableChecks: 2, MaxWaitMs: 1000},
+	}
+	events := make(chan FileEvent, 10)
+	w, err := NewWatcher(cfg, func(event FileEvent) error {
+		events <- event
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+	overflow, ok := w.(interface{ handleError(error) })
+	if !ok {
+		t.Fatalf("Expected a Linux watcher, got %T", w)
+	}
+
+	path := filepath.Join(watchDir, "lost.txt")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Files whose events were lost are picked up by the rescan
+	overflow.handleError(fsnotify.ErrEventOverflow)
+	select {
+	case event := <-events:
+		if event.Path != path {
+			t.Errorf("Expected %s, got %s", path, event.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the rescan to deliver the file")
+	}
+	if got := watcherOverflows.With("overflow").Value(); got != 1 {
+		t.Errorf("Expected 1 overflow, got %d", got)
+	}
+
+	// A delivered file whose source is not removed yet is not enqueued again
+	w.ClearEnqueued(path)
+	overflow.handleError(fsnotify.ErrEventOverflow)
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no delivery of a delivered file, got %v", event)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Unless it was modified after its delivery
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Failed to touch file: %v", err)
+	}
+	overflow.handleError(fsnotify.ErrEventOverflow)
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a modified file to be delivered again")
+	}
+}
+
+func TestLinuxWatcherCloseWrite(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Close-write events require Linux")
+	}
+	watchDir := t.TempDir()
+	startupScan := false
+	cfg := config.DirectoryConfig{
+		Name:      "close-write",
+		WatchPath: watchDir,
+		Recursive: true,
+		Watch:     config.WatchConfig{Mode: "hybrid_ultra_low_latency", CloseWrite: true, StartupReconcileScan: &startupScan},
+		// A stability check would take at least 10 seconds
+		Stability: config.StabilityConfig{ConfirmationIntervalMs: 5000, RequiredStableChecks: 3, MaxWaitMs: 60000},
+	}
+	events := make(chan FileEvent, 10)
+	w, err := NewWatcher(cfg, func(event FileEvent) error {
+		events <- event
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// Nothing is delivered while the writer keeps the file open
+	path := filepath.Join(watchDir, "data.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	_, _ = f.WriteString("a,b\n")
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no delivery before close, got %v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Closing the file delivers it without a stability check
+	f.Close()
+	select {
+	case event := <-events:
+		if event.Path != path || event.IsRename {
+			t.Errorf("Expected closed file %s, got %+v", path, event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected delivery right after close")
+	}
+
+	// Files renamed into place are delivered too
+	staged := filepath.Join(t.TempDir(), "moved.csv")
+	if err := os.WriteFile(staged, []byte("c,d\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	moved := filepath.Join(watchDir, "moved.csv")
+	if err := os.Rename(staged, moved); err != nil {
+		t.Fatalf("Failed to rename file: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Path != moved || !event.IsRename {
+			t.Errorf("Expected renamed file %s, got %+v", moved, event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected delivery of the renamed file")
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	fired := make(chan string, 10)
+	d := newDebouncer("debounce", 50*time.Millisecond, 300*time.Millisecond, func(path string, first time.Time) {
+		fired <- path
+	})
+	defer d.stop()
+
+	// A burst of events fires once, after the burst
+	before := coalescedWrites.With("debounce").Value()
+	for range 5 {
+		d.touch("/data/burst")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !d.isPending("/data/burst") {
+		t.Error("Expected the file to be pending during the burst")
+	}
+	select {
+	case path := <-fired:
+		if path != "/data/burst" {
+			t.Errorf("Expected /data/burst, got %s", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the burst to fire")
+	}
+	select {
+	case path := <-fired:
+		t.Errorf("Expected a single fire, got another for %s", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := coalescedWrites.With("debounce").Value() - before; got != 4 {
+		t.Errorf("Expected 4 coalesced events, got %v", got)
+	}
+
+	// Events that never settle fire after the maximum wait
+	start := time.Now()
+	stop := time.After(time.Second)
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	d.touch("/data/busy")
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			d.touch("/data/busy")
+		case <-fired:
+			if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+				t.Errorf("Expected a fire after the maximum wait, took %v", elapsed)
+			}
+			done = true
+		case <-stop:
+			t.Fatal("Expected a continuously written file to fire after the maximum wait")
+		}
+	}
+
+	// Cancelled files do not fire
+	d.cancel("/data/burst")
+	d.touch("/data/removed")
+	d.cancel("/data/removed")
+	select {
+	case path := <-fired:
+		if path == "/data/removed" {
+			t.Error("Expected no fire for a cancelled file")
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLinuxWatcherCoalescesWrites(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Exercises the inotify watcher")
+	}
+	watchDir := t.TempDir()
+	startupScan := false
+	cfg := config.DirectoryConfig{
+		Name:      "coalesce",
+		WatchPath: watchDir,
+		Watch:     config.WatchConfig{Mode: "hybrid_ultra_low_latency", StartupReconcileScan: &startupScan},
+		Stability: config.StabilityConfig{ConfirmationIntervalMs: 20, RequiredStableChecks: 2, MaxWaitMs: 5000, DebounceMs: 100},
+	}
+	events := make(chan FileEvent, 10)
+	w, err := NewWatcher(cfg, func(event FileEvent) error {
+		events <- event
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// A slow copy: many small writes with pauses shorter than the debounce window
+	path := filepath.Join(watchDir, "slow.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	start := time.Now()
+	for range 20 {
+		_, _ = f.WriteString("chunk\n")
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.Close()
+
+	select {
+	case event := <-events:
+		if event.Path != path {
+			t.Errorf("Expected %s, got %s", path, event.Path)
+		}
+		if !event.Detected.Before(start.Add(50 * time.Millisecond)) {
+			t.Errorf("Expected detection at the first write, got %v after it", event.Detected.Sub(start))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the file to be enqueued")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected a single event, got another for %s", event.Path)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWatcherFileMovedOut(t *testing.T) {
+	watchDir := t.TempDir()
+	startupScan := false
+	cfg := config.DirectoryConfig{
+		Name:      "moved",
+		WatchPath: watchDir,
+		Watch:     config.WatchConfig{Mode: "hybrid_ultra_low_latency", StartupReconcileScan: &startupScan},
+		Stability: config.StabilityConfig{ConfirmationIntervalMs: 20, RequiredStableChecks: 2, MaxWaitMs: 5000, DebounceMs: 50},
+	}
+	events := make(chan FileEvent, 10)
+	w, err := NewWatcher(cfg, func(event FileEvent) error {
+		events <- event
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	path := filepath.Join(watchDir, "report.csv")
+	if err := os.WriteFile(path, []byte("a,b\n"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Path != path || event.IsDelete {
+			t.Fatalf("Expected %s to be enqueued, got %+v", path, event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the file to be enqueued")
+	}
+
+	// Moving the enqueued file out of the watch path cancels its upload
+	if err := os.Rename(path, filepath.Join(t.TempDir(), "report.csv")); err != nil {
+		t.Fatalf("Failed to move file: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Path != path || !event.IsDelete {
+			t.Errorf("Expected a delete event for %s, got %+v", path, event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the moved file to be reported as deleted")
+	}
+}
+
+func TestRotationFilter(t *testing.T) {
+	watchDir := t.TempDir()
+	write := func(name, content string, modTime time.Time) string {
+		path := filepath.Join(watchDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set times of %s: %v", name, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name      string
+		rotation  config.RotationConfig
+		delivered []string
+	}{
+		{"defaults", config.RotationConfig{}, []string{"app.log.1", "app.log.1", "app.log-20260101", "data.csv"}},
+		{"deliver active", config.RotationConfig{Active: config.RotationActiveDeliver}, []string{"app.log", "app.log.1", "app.log.1", "app.log-20260101", "data.csv"}},
+		{"ignore rotated", config.RotationConfig{Active: config.RotationActiveDeliver, Rotated: config.RotationRotatedIgnore}, []string{"app.log", "data.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delivered, cleared []string
+			tt.rotation.Enabled = true
+			tt.rotation.Patterns = []string{"*.log"}
+			filter := newRotationFilter(config.DirectoryConfig{Name: "rotation", Rotation: tt.rotation}, func(event FileEvent) error {
+				delivered = append(delivered, filepath.Base(event.Path))
+				return nil
+			})
+			filter.clear = func(path string) { cleared = append(cleared, path) }
+
+			rotated := time.Now().Add(-time.Hour)
+			events := []string{
+				write("app.log", "active", time.Now()),
+				write("app.log.1", "first", rotated),
+				write("app.log.1", "first", rotated), // A retry of the same file is passed on
+				write("app.log.2", "first", rotated), // Renamed again by the next rotation
+				write("app.log-20260101", "second", rotated.Add(time.Minute)),
+				write("data.csv", "data", rotated),
+			}
+			for _, path := range events {
+				if err := filter.handle(FileEvent{Path: path}); err != nil {
+					t.Fatalf("handle failed: %v", err)
+				}
+			}
+			if strings.Join(delivered, ",") != strings.Join(tt.delivered, ",") {
+				t.Errorf("Expected %v to be delivered, got %v", tt.delivered, delivered)
+			}
+			if len(cleared)+len(delivered) != len(events) {
+				t.Errorf("Expected every skipped file to be cleared, got %v", cleared)
+			}
+		})
+	}
+}
+
+func TestRotatedFrom(t *testing.T) {
+	rotation := config.RotationConfig{Patterns: []string{"*.log", "access_log"}}
+	tests := map[string]string{
+		"app.log":          "",
+		"app.log.1":        "app.log",
+		"app.log.2.gz":     "app.log",
+		"app.log-20260101": "app.log",
+		"access_log.3":     "access_log",
+		"report.csv.1":     "",
+		"app-1.log.1":      "app-1.log",
+	}
+	for name, expected := range tests {
+		if active := rotatedFrom(filepath.Join("/var/log", name), rotation); active != expected {
+			t.Errorf("rotatedFrom(%q) = %q, expected %q", name, active, expected)
+		}
+	}
+}
+
+func TestIngestWatcher(t *testing.T) {
+	tmpDir := t.TempDir()
+	existing := filepath.Join(tmpDir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("left from the last run"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "skip.tmp"), []byte("ignored"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	startupScan := true
+	cfg := config.DirectoryConfig{
+		Name:      "test",
+		WatchPath: tmpDir,
+		Watch:     config.WatchConfig{Mode: "none", StartupReconcileScan: &startupScan},
+	}
+	var events []FileEvent
+	w := NewIngestWatcher(cfg, func(event FileEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	// The startup scan picks up existing files without a stability check
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+	if len(events) != 1 || events[0].Path != existing || events[0].Priority != config.PriorityNormal {
+		t.Fatalf("Expected the existing file from the startup scan, got %+v", events)
+	}
+
+	uploaded := filepath.Join(tmpDir, "uploaded.txt")
+	if err := os.WriteFile(uploaded, []byte("committed upload"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	w.Add(uploaded)
+	w.Add(uploaded) // still enqueued
+	if len(events) != 2 || events[1].Path != uploaded {
+		t.Fatalf("Expected the uploaded file once, got %+v", events)
+	}
+
+	// Once delivered, the same path is handed over again
+	w.ClearEnqueued(uploaded)
+	w.Add(uploaded)
+	if len(events) != 3 {
+		t.Errorf("Expected a new upload to the same path after ClearEnqueued, got %d events", len(events))
+	}
+
+	w.Add(filepath.Join(tmpDir, "missing.txt"))
+	if len(events) != 3 {
+		t.Errorf("Expected missing files to be skipped, got %d events", len(events))
+	}
+}
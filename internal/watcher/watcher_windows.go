@@ -21,8 +21,9 @@ type WindowsWatcher struct {
 	handler         EventHandler
 	watcher         *fsnotify.Watcher
 	watchedDirs     map[string]bool
-	processingFiles sync.Map // tracks files currently being processed for stability
-	enqueuedFiles   sync.Map // tracks files that have been enqueued for upload
+	processingFiles sync.Map   // tracks files currently being processed for stability
+	enqueuedFiles   sync.Map   // tracks files that have been enqueued for upload
+	writes          *debouncer // coalesces write events before the stability check
 	mu              sync.Mutex
 	ctx             context.Context
 	cancel          context.CancelFunc
@@ -36,12 +37,14 @@ func newPlatformWatcher(cfg config.DirectoryConfig, handler EventHandler) (Watch
 		return nil, fmt.Errorf("failed to create fsnotify watcher: %w", err)
 	}
 
-	return &WindowsWatcher{
+	ww := &WindowsWatcher{
 		config:      cfg,
 		handler:     handler,
 		watcher:     w,
 		watchedDirs: make(map[string]bool),
-	}, nil
+	}
+	ww.writes = newDebouncer(cfg.Name, cfg.Stability.GetDebounce(), cfg.Stability.GetMaxWait(), ww.checkWritten)
+	return ww, nil
 }
 
 // Start begins watching the configured directory
@@ -84,6 +87,7 @@ func (w *WindowsWatcher) Stop() error {
 	}
 
 	w.wg.Wait()
+	w.writes.stop()
 	log.Printf("Windows watcher stopped for: %s", w.config.WatchPath)
 	return nil
 }
@@ -156,6 +160,12 @@ func (w *WindowsWatcher) processEvents() {
 func (w *WindowsWatcher) handleEvent(event fsnotify.Event) {
 	path := event.Name
 
+	// Deleted files may still be waiting in the upload queue
+	if event.Op&fsnotify.Remove != 0 {
+		w.handleRemove(path)
+		return
+	}
+
 	// Handle directory creation (for recursive watching)
 	if event.Op&fsnotify.Create != 0 {
 		info, err := os.Stat(path)
@@ -192,20 +202,22 @@ func (w *WindowsWatcher) handleEvent(event fsnotify.Event) {
 
 // handleHybridEvent handles events in hybrid mode
 func (w *WindowsWatcher) handleHybridEvent(event fsnotify.Event) {
-	// RENAME_NEW_NAME on Windows indicates file completion
-	// Still do a short stability check
-	if event.Op&(fsnotify.Rename|fsnotify.Create|fsnotify.Write) != 0 {
-		path := event.Name
+	path := event.Name
 
-		// Check if this file has already been enqueued
-		_, alreadyEnqueued := w.enqueuedFiles.Load(path)
-		if alreadyEnqueued {
-			// Already enqueued this file, skip
-			return
+	// Check if this file has already been enqueued
+	_, alreadyEnqueued := w.enqueuedFiles.Load(path)
+	if alreadyEnqueued {
+		// fsnotify reports a rename under the old name: an enqueued file
+		// renamed or moved out of the watch path is gone, like a deleted one
+		if event.Op&fsnotify.Rename != 0 {
+			w.handleRemove(path)
 		}
+		return
+	}
 
-		isRename := event.Op&fsnotify.Rename != 0
-
+	// RENAME_NEW_NAME on Windows indicates file completion
+	// Still do a short stability check
+	if event.Op&fsnotify.Rename != 0 {
 		// Check if we're already processing this file
 		_, alreadyProcessing := w.processingFiles.LoadOrStore(path, true)
 		if alreadyProcessing {
@@ -217,7 +229,7 @@ func (w *WindowsWatcher) handleHybridEvent(event fsnotify.Event) {
 			defer w.processingFiles.Delete(path) // Clean up when done
 
 			// Process file and get event
-			event, err := processFile(path, isRename, w.config)
+			event, err := processFile(path, true, w.config)
 			if err != nil {
 				log.Printf("Error processing file %s: %v", path, err)
 				return
@@ -236,6 +248,45 @@ func (w *WindowsWatcher) handleHybridEvent(event fsnotify.Event) {
 				w.enqueuedFiles.Delete(path) // Remove on failure
 			}
 		}()
+		return
+	}
+
+	// Files being written are checked for stability once their write
+	// events settle, however many events a slow copy produces
+	if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+		w.writes.touch(path)
+	}
+}
+
+// checkWritten confirms the stability of a file whose write events settled
+// and enqueues it
+func (w *WindowsWatcher) checkWritten(path string, first time.Time) {
+	if _, alreadyEnqueued := w.enqueuedFiles.Load(path); alreadyEnqueued {
+		return
+	}
+
+	// The reconciliation scan may be checking the file already
+	if _, alreadyProcessing := w.processingFiles.LoadOrStore(path, true); alreadyProcessing {
+		return
+	}
+	defer w.processingFiles.Delete(path)
+
+	event, err := processFile(path, false, w.config)
+	if err != nil {
+		log.Printf("Error processing file %s: %v", path, err)
+		return
+	}
+	if event.Path == "" {
+		return // Ignored or disappeared
+	}
+	event.Detected = first
+
+	if _, alreadyEnqueued := w.enqueuedFiles.LoadOrStore(path, true); alreadyEnqueued {
+		return
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling file %s: %v", path, err)
+		w.enqueuedFiles.Delete(path) // Remove on failure
 	}
 }
 
@@ -272,11 +323,35 @@ func (w *WindowsWatcher) reconciliationScan() {
 	}
 }
 
+// handleRemove notifies the handler when an enqueued file is deleted or
+// moved away
+func (w *WindowsWatcher) handleRemove(path string) {
+	w.writes.cancel(path)
+	if _, wasEnqueued := w.enqueuedFiles.LoadAndDelete(path); !wasEnqueued {
+		return
+	}
+
+	event := FileEvent{
+		Path:      path,
+		Timestamp: time.Now(),
+		IsDelete:  true,
+	}
+	if err := w.handler(event); err != nil {
+		log.Printf("Error handling deletion of %s: %v", path, err)
+	}
+}
+
 // ClearEnqueued removes a file from the enqueued tracking
 func (w *WindowsWatcher) ClearEnqueued(path string) {
 	w.enqueuedFiles.Delete(path)
 }
 
+// MarkEnqueued records a file enqueued by the service, e.g. resumed from the
+// queue state, so that it is not enqueued again
+func (w *WindowsWatcher) MarkEnqueued(path string) {
+	w.enqueuedFiles.Store(path, true)
+}
+
 // performReconciliationScan scans for files that may have been missed
 func (w *WindowsWatcher) performReconciliationScan() {
 	log.Printf("Performing reconciliation scan for: %s", w.config.WatchPath)
@@ -290,7 +365,7 @@ func (w *WindowsWatcher) performReconciliationScan() {
 			return nil
 		}
 
-		if ShouldIgnore(path, w.config.Ignore) {
+		if ShouldSkip(path, w.config) {
 			return nil
 		}
 
@@ -300,6 +375,11 @@ func (w *WindowsWatcher) performReconciliationScan() {
 			return nil // Already processed
 		}
 
+		// Files still being written are checked once their writes settle
+		if w.writes.isPending(path) {
+			return nil
+		}
+
 		// Check if we're already processing this file
 		_, alreadyProcessing := w.processingFiles.LoadOrStore(path, true)
 		if alreadyProcessing {
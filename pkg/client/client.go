@@ -0,0 +1,490 @@
+// Package client is a Go client for the xferd ingress API.
+//
+// It wraps the upload, validation and health endpoints, retries transient
+// failures (connection errors, 429 and 5xx responses, honoring Retry-After)
+// and sends a SHA-256 checksum with every upload so the server rejects
+// corrupted transfers instead of delivering them.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChecksumHeader carries the hex-encoded SHA-256 of the uploaded file, as a
+// header for buffered uploads and as a trailer for streamed ones
+const ChecksumHeader = "X-Checksum-SHA256"
+
+// Default retry settings
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = time.Second
+	maxBackoff        = 30 * time.Second
+)
+
+// Client talks to a single xferd server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	auth       func(*http.Request)
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, e.g. to configure
+// TLS client certificates or timeouts
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithBasicAuth authenticates with server.basic_auth credentials
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) { req.SetBasicAuth(username, password) }
+	}
+}
+
+// WithBearerToken authenticates with a server.jwt_auth token
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+}
+
+// WithRetries sets how often a failed request is retried and the initial
+// backoff, which doubles after every attempt. maxRetries 0 disables retries.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New creates a client for the server at baseURL (e.g. "https://xferd.example.com:8080")
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	return c, nil
+}
+
+// APIError is returned when the server rejects a request
+type APIError struct {
+	StatusCode int
+	Code       string // stable XFERD_* error code, empty if the body was not an xferd error
+	Message    string
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("xferd: HTTP %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("xferd: HTTP %d [%s]: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Temporary reports whether retrying the request may succeed
+func (e *APIError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// UploadResult describes a completed upload
+type UploadResult struct {
+	Destination string // directory name, optionally followed by a subdirectory path
+	Filename    string
+	Size        int64
+	Checksum    string // hex-encoded SHA-256 verified by the server
+
+	// The server's receipt; empty if the server answered with plain text
+	ReceiptID  string    // unique ID of the stored file, for reconciliation
+	TransferID string    // correlates the upload with its delivery in the server's logs
+	Path       string    // stored file relative to the directory's ingest path
+	Received   time.Time // when the server stored the file
+}
+
+// receipt is the part of the server's JSON upload response kept in an
+// UploadResult
+type receipt struct {
+	ReceiptID  string    `json:"receipt_id"`
+	TransferID string    `json:"transfer_id"`
+	Path       string    `json:"path"`
+	Received   time.Time `json:"received"`
+}
+
+// readReceipt reads the receipt of an upload response and closes it.
+// Servers before receipts answer with plain text, giving an empty receipt.
+func readReceipt(resp *http.Response) receipt {
+	defer drain(resp)
+	var rec receipt
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		_ = json.NewDecoder(resp.Body).Decode(&rec)
+	}
+	return rec
+}
+
+// fill adds the receipt to result
+func (rec receipt) fill(result *UploadResult) *UploadResult {
+	result.ReceiptID, result.TransferID = rec.ReceiptID, rec.TransferID
+	result.Path, result.Received = rec.Path, rec.Received
+	return result
+}
+
+// ValidateResult is the server's answer to a pre-flight check
+type ValidateResult struct {
+	Valid     bool   `json:"valid"`
+	Directory string `json:"directory"`
+	Filename  string `json:"filename"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+// Upload sends data as filename to destination, which is a configured
+// directory name optionally followed by a subdirectory ("invoices/2025/01").
+// The request is buffered in memory and retried on transient failures.
+func (c *Client) Upload(ctx context.Context, destination, filename string, data []byte) (*UploadResult, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write form file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	payload := body.Bytes()
+
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPost, "upload", destination, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(ChecksumHeader, checksum)
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rec := readReceipt(resp)
+
+	return rec.fill(&UploadResult{Destination: destination, Filename: filename, Size: int64(len(data)), Checksum: checksum}), nil
+}
+
+// UploadFile streams the local file at path to destination under its base
+// name. The file is re-read for every retry, so it is never held in memory.
+func (c *Client) UploadFile(ctx context.Context, destination, path string) (*UploadResult, error) {
+	filename := filepath.Base(path)
+
+	var stream *multipartStream
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		f, err := os.Open(path) // #nosec G304 -- path is chosen by the caller
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		stream = newMultipartStream(f, filename)
+		return c.newStreamRequest(ctx, destination, stream)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rec := readReceipt(resp)
+
+	return rec.fill(stream.result(destination)), nil
+}
+
+// UploadStream streams r to destination as filename using chunked transfer
+// encoding; the checksum is sent as a trailer once r is exhausted. Because r
+// cannot be rewound the upload is not retried.
+func (c *Client) UploadStream(ctx context.Context, destination, filename string, r io.Reader) (*UploadResult, error) {
+	stream := newMultipartStream(io.NopCloser(r), filename)
+	req, err := c.newStreamRequest(ctx, destination, stream)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := readReceipt(resp)
+
+	return rec.fill(stream.result(destination)), nil
+}
+
+// Validate asks whether an upload of filename to destination would be
+// accepted. size is checked against upload limits and free disk space; pass
+// a negative size if it is unknown.
+func (c *Client) Validate(ctx context.Context, destination, filename string, size int64) (*ValidateResult, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPost, "validate", destination, nil)
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{"filename": {filename}}
+		if size >= 0 {
+			query.Set("size", strconv.FormatInt(size, 10))
+		}
+		req.URL.RawQuery = query.Encode()
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+
+	var result ValidateResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode validate response: %w", err)
+	}
+	return &result, nil
+}
+
+// Health checks that the server is up
+func (c *Client) Health(ctx context.Context) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return c.newRequest(ctx, http.MethodGet, "health", "", nil)
+	})
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// newRequest builds a request for /<endpoint>/<destination>; each destination
+// path segment is escaped separately
+func (c *Client) newRequest(ctx context.Context, method, endpoint, destination string, body io.Reader) (*http.Request, error) {
+	elems := []string{endpoint}
+	if destination != "" {
+		elems = append(elems, strings.Split(strings.Trim(destination, "/"), "/")...)
+	}
+	u := c.baseURL.JoinPath(elems...)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.auth != nil {
+		c.auth(req)
+	}
+	return req, nil
+}
+
+// newStreamRequest builds an upload request whose body is produced by stream.
+// The unknown length makes the transport use chunked encoding, which allows
+// the checksum to follow the body as a trailer.
+func (c *Client) newStreamRequest(ctx context.Context, destination string, stream *multipartStream) (*http.Request, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "upload", destination, stream.reader)
+	if err != nil {
+		stream.reader.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", stream.writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.Trailer = stream.trailer
+	stream.start()
+	return req, nil
+}
+
+// do sends the request built by newReq, retrying transient failures with
+// exponential backoff. On success the caller must close the response body.
+func (c *Client) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.send(req)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= c.maxRetries || !retryable(ctx, err) {
+			return nil, err
+		}
+
+		wait := backoff
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// send performs a single request and converts non-2xx responses to *APIError
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer drain(resp)
+	return nil, parseError(resp)
+}
+
+// parseError reads an xferd JSON error body, falling back to the raw text
+func parseError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var errResp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error.Code != "" {
+		apiErr.Code = errResp.Error.Code
+		apiErr.Message = errResp.Error.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// retryable reports whether err may go away on retry
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	// Local I/O errors (e.g. a missing file) will not fix themselves
+	var pathErr *os.PathError
+	return !errors.As(err, &pathErr)
+}
+
+// drain discards the rest of the body so the connection can be reused
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+// multipartStream encodes a file as a multipart body through a pipe,
+// hashing it on the way and publishing the checksum as a trailer
+type multipartStream struct {
+	src      io.ReadCloser
+	filename string
+	reader   *io.PipeReader
+	pipe     *io.PipeWriter
+	writer   *multipart.Writer
+	trailer  http.Header
+	hash     hash.Hash
+	size     int64
+	done     chan struct{}
+}
+
+func newMultipartStream(src io.ReadCloser, filename string) *multipartStream {
+	pr, pw := io.Pipe()
+	return &multipartStream{
+		src:      src,
+		filename: filename,
+		reader:   pr,
+		pipe:     pw,
+		writer:   multipart.NewWriter(pw),
+		trailer:  http.Header{ChecksumHeader: nil},
+		hash:     sha256.New(),
+		done:     make(chan struct{}),
+	}
+}
+
+// start begins encoding in the background. The trailer is set before the
+// pipe is closed, so the transport sees it once the body reaches EOF.
+func (s *multipartStream) start() {
+	go func() {
+		defer close(s.done)
+		defer s.src.Close()
+
+		part, err := s.writer.CreateFormFile("file", s.filename)
+		if err != nil {
+			s.pipe.CloseWithError(err)
+			return
+		}
+		n, err := io.Copy(part, io.TeeReader(s.src, s.hash))
+		if err != nil {
+			s.pipe.CloseWithError(err)
+			return
+		}
+		if err := s.writer.Close(); err != nil {
+			s.pipe.CloseWithError(err)
+			return
+		}
+		s.size = n
+		s.trailer.Set(ChecksumHeader, hex.EncodeToString(s.hash.Sum(nil)))
+		s.pipe.Close()
+	}()
+}
+
+// result waits for encoding to finish and describes the uploaded file
+func (s *multipartStream) result(destination string) *UploadResult {
+	<-s.done
+	return &UploadResult{
+		Destination: destination,
+		Filename:    s.filename,
+		Size:        s.size,
+		Checksum:    s.trailer.Get(ChecksumHeader),
+	}
+}
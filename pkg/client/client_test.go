@@ -0,0 +1,318 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeUpload is a minimal stand-in for the xferd upload endpoint: it reads
+// the multipart file and verifies the checksum header or trailer
+type fakeUpload struct {
+	path     string
+	filename string
+	content  string
+	checksum string
+	auth     string
+	receipt  bool // answer with a JSON receipt instead of plain text
+}
+
+func (f *fakeUpload) handler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.path = r.URL.EscapedPath()
+		f.auth = r.Header.Get("Authorization")
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Failed to read form file: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		f.filename = header.Filename
+		f.content = string(data)
+		f.checksum = r.Header.Get(ChecksumHeader)
+		if f.checksum == "" {
+			f.checksum = r.Trailer.Get(ChecksumHeader)
+		}
+		if f.receipt && r.Header.Get("Accept") == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"receipt_id":"r1","transfer_id":"t1","directory":"reports","filename":"`+header.Filename+
+				`","path":"2026/`+header.Filename+`","size":5,"checksum":"`+f.checksum+`","received":"2026-10-15T09:30:00Z"}`+"\n")
+			return
+		}
+		_, _ = io.WriteString(w, "Upload successful: "+header.Filename+"\n")
+	}
+}
+
+func checksumOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestNewInvalidURL(t *testing.T) {
+	for _, baseURL := range []string{"", "ftp://example.com", "://bad"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("Expected error for base URL %q", baseURL)
+		}
+	}
+}
+
+func TestUpload(t *testing.T) {
+	fake := &fakeUpload{}
+	ts := httptest.NewServer(fake.handler(t))
+	defer ts.Close()
+
+	c, err := New(ts.URL+"/", WithBasicAuth("admin", "secret"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	result, err := c.Upload(context.Background(), "invoices/2025 Q1", "a.txt", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if fake.path != "/upload/invoices/2025%20Q1" {
+		t.Errorf("Expected path /upload/invoices/2025%%20Q1, got %s", fake.path)
+	}
+	if fake.filename != "a.txt" || fake.content != "hello" {
+		t.Errorf("Unexpected upload %s: %q", fake.filename, fake.content)
+	}
+	if !strings.HasPrefix(fake.auth, "Basic ") {
+		t.Errorf("Expected basic auth, got %q", fake.auth)
+	}
+	if fake.checksum != checksumOf("hello") {
+		t.Errorf("Expected checksum header %s, got %s", checksumOf("hello"), fake.checksum)
+	}
+	if result.Checksum != checksumOf("hello") || result.Size != 5 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.ReceiptID != "" {
+		t.Errorf("Expected no receipt from a plain text response, got %q", result.ReceiptID)
+	}
+}
+
+func TestUploadFileReceipt(t *testing.T) {
+	fake := &fakeUpload{receipt: true}
+	ts := httptest.NewServer(fake.handler(t))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "b.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	result, err := c.UploadFile(context.Background(), "reports/2026", path)
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+
+	received := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+	if result.ReceiptID != "r1" || result.TransferID != "t1" || result.Path != "2026/b.txt" || !result.Received.Equal(received) {
+		t.Errorf("Expected the server's receipt, got %+v", result)
+	}
+	if result.Checksum != checksumOf("hello") {
+		t.Errorf("Expected checksum %s, got %s", checksumOf("hello"), result.Checksum)
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	fake := &fakeUpload{}
+	ts := httptest.NewServer(fake.handler(t))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithBearerToken("token"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	content := strings.Repeat("streamed data ", 10000)
+	result, err := c.UploadStream(context.Background(), "reports", "big.txt", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("UploadStream failed: %v", err)
+	}
+
+	if fake.content != content {
+		t.Errorf("Content mismatch: got %d bytes, expected %d", len(fake.content), len(content))
+	}
+	if fake.auth != "Bearer token" {
+		t.Errorf("Expected bearer auth, got %q", fake.auth)
+	}
+	if fake.checksum != checksumOf(content) {
+		t.Errorf("Expected checksum trailer %s, got %q", checksumOf(content), fake.checksum)
+	}
+	if result.Checksum != checksumOf(content) || result.Size != int64(len(content)) {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestUploadFileRetries(t *testing.T) {
+	var attempts atomic.Int32
+	fake := &fakeUpload{}
+	upload := fake.handler(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, `{"error":{"code":"XFERD_INTERNAL_ERROR","message":"busy"}}`)
+			return
+		}
+		upload(w, r)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(path, []byte("a,b,c\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	c, err := New(ts.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	result, err := c.UploadFile(context.Background(), "invoices", path)
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+
+	if attempts.Load() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts.Load())
+	}
+	if fake.filename != "data.csv" || fake.content != "a,b,c\n" {
+		t.Errorf("Unexpected upload %s: %q", fake.filename, fake.content)
+	}
+	if fake.checksum != checksumOf("a,b,c\n") || result.Checksum != fake.checksum {
+		t.Errorf("Checksum mismatch: sent %s, result %s", fake.checksum, result.Checksum)
+	}
+}
+
+func TestUploadFileMissing(t *testing.T) {
+	c, err := New("http://127.0.0.1:1", WithRetries(3, time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	// A missing local file must fail immediately instead of being retried
+	if _, err := c.UploadFile(context.Background(), "invoices", filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, `{"error":{"code":"XFERD_CHECKSUM_MISMATCH","message":"Checksum mismatch"}}`)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	_, err = c.Upload(context.Background(), "invoices", "a.txt", []byte("x"))
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "XFERD_CHECKSUM_MISMATCH" || apiErr.Message != "Checksum mismatch" {
+		t.Errorf("Unexpected error: %+v", apiErr)
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("Client errors should not be retried, got %d attempts", attempts.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		attempts.Add(1)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	start := time.Now()
+	err = c.Health(context.Background())
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 APIError, got %v", err)
+	}
+	if apiErr.RetryAfter != time.Second {
+		t.Errorf("Expected RetryAfter 1s, got %v", apiErr.RetryAfter)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected retry to wait for Retry-After, waited %v", elapsed)
+	}
+}
+
+func TestRetryContextCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithRetries(5, time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Health(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/validate/invoices/sub" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.URL.Query().Get("filename") != "a.txt" || r.URL.Query().Get("size") != "42" {
+			t.Errorf("Unexpected query %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"valid":true,"directory":"invoices","filename":"a.txt","path":"sub/a.txt","size":42}`)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	result, err := c.Validate(context.Background(), "invoices/sub", "a.txt", 42)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if !result.Valid || result.Path != "sub/a.txt" || result.Size != 42 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}